@@ -0,0 +1,13 @@
+// Command field-simulator is a thin wrapper around workfield/internal/cli/simulator; the same
+// command is available as "field simulator".
+package main
+
+import (
+	"os"
+
+	"workfield/internal/cli/simulator"
+)
+
+func main() {
+	simulator.Main(os.Args[1:])
+}
@@ -0,0 +1,54 @@
+// Command field bundles every tool in one binary so a collection PC or
+// ingest host only needs a single file:
+//
+//	field client analyze-daily ...   same as field-client
+//	field analyzer ...               same as field-client analyze-daily
+//	field worker [subcommand] ...    same as field-ingest-worker
+//	field simulator ...              same as field-simulator
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"workfield/internal/buildinfo"
+	"workfield/internal/cli/client"
+	"workfield/internal/cli/simulator"
+	"workfield/internal/cli/worker"
+)
+
+const usage = `usage: field <command> [arguments]
+
+commands:
+  client      field-client subcommands (analyze-daily, ack, export-usb, health, history, receipts, secret, service, support-bundle, upload)
+  analyzer    shorthand for "client analyze-daily"
+  worker      field-ingest-worker and its subcommands
+  simulator   generate synthetic logs and archives
+  version     print the build version`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+	client.ServiceArgs = []string{"client"}
+	worker.ServiceArgs = []string{"worker"}
+	switch command {
+	case "client":
+		client.Main(args)
+	case "analyzer":
+		client.Main(append([]string{"analyze-daily"}, args...))
+	case "worker":
+		worker.Main(args)
+	case "simulator":
+		simulator.Main(args)
+	case "version", "-version", "--version":
+		fmt.Println(buildinfo.Get().String("field"))
+	case "help", "-h", "-help", "--help":
+		fmt.Println(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", command, usage)
+		os.Exit(2)
+	}
+}
@@ -2,4 +2,8 @@ module workfield
 
 go 1.22
 
-require modernc.org/sqlite v1.29.1
+require (
+	github.com/klauspost/compress v1.18.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.29.1
+)
@@ -0,0 +1,185 @@
+// Package alarm reads the controller's own event and alarm logs (power
+// loss, door open, ...), which explain many sensor gaps better than the
+// serial traffic does. Events travel as events.jsonl lines with
+// "type": "controller_event".
+//
+// A log line is a timestamp in one of the configured layouts, the event
+// kind and optionally key=value fields and a free-text message:
+//
+//	2026-01-20 03:12:44.120 POWER_LOSS code=E01 source=UPS mains lost
+package alarm
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"workfield/internal/timeparse"
+)
+
+// EventType marks controller events among the lines of events.jsonl.
+const EventType = "controller_event"
+
+// DefaultGlobs are the log_root directories searched for controller logs
+// when none are configured.
+var DefaultGlobs = []string{"ALARM*", "EVENT*"}
+
+// Event is one controller event.
+type Event struct {
+	Type       string            `json:"type"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	Kind       string            `json:"kind"`
+	Code       string            `json:"code,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	// Source is the log directory the event came from, e.g. ALARM.
+	Source string `json:"source"`
+}
+
+// Parse reads one log line; ok is false for lines without a timestamp and
+// kind.
+func Parse(line string, times *timeparse.Parser) (Event, bool) {
+	at, rest, ok := times.ParsePrefix(line)
+	if !ok {
+		return Event{}, false
+	}
+	tokens := strings.Fields(rest)
+	if len(tokens) == 0 {
+		return Event{}, false
+	}
+	e := Event{Type: EventType, OccurredAt: at, Kind: normalizeKind(tokens[0])}
+	var message []string
+	for _, token := range tokens[1:] {
+		key, value, found := strings.Cut(token, "=")
+		if !found || key == "" || len(message) > 0 {
+			message = append(message, token)
+			continue
+		}
+		if strings.EqualFold(key, "code") {
+			e.Code = value
+			continue
+		}
+		if e.Fields == nil {
+			e.Fields = map[string]string{}
+		}
+		e.Fields[strings.ToLower(key)] = value
+	}
+	e.Message = strings.Join(message, " ")
+	return e, true
+}
+
+// normalizeKind turns POWER_LOSS, Power-Loss and power loss spellings into
+// power_loss.
+func normalizeKind(kind string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.Trim(kind, ":[]"), "-", "_"))
+}
+
+// Collect reads the events of day (YYYYMMDD) from every directory under
+// logRoot matching globs. Files whose name contains the day (YYYY-MM-DD or
+// YYYYMMDD) are read when there are any, otherwise every file in the
+// directory; only lines timestamped on that day are kept. Events are
+// returned in time order.
+func Collect(ctx context.Context, logRoot string, globs []string, day string, times *timeparse.Parser) ([]Event, error) {
+	start, err := times.ParseDate(day)
+	if err != nil {
+		return nil, err
+	}
+	end := start.AddDate(0, 0, 1)
+	dashed := start.Format(timeparse.DayLayout)
+
+	var events []Event
+	for _, dir := range dirs(logRoot, globs) {
+		files, err := dayFiles(dir, dashed, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range files {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			found, err := readFile(path, filepath.Base(dir), times, start, end)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, found...)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].OccurredAt.Before(events[j].OccurredAt) })
+	return events, nil
+}
+
+func dirs(logRoot string, globs []string) []string {
+	if len(globs) == 0 {
+		globs = DefaultGlobs
+	}
+	seen := map[string]bool{}
+	var found []string
+	for _, pattern := range globs {
+		matches, _ := filepath.Glob(filepath.Join(logRoot, pattern))
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() && !seen[match] {
+				seen[match] = true
+				found = append(found, match)
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+func dayFiles(dir string, tokens ...string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var all, matched []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		all = append(all, path)
+		for _, token := range tokens {
+			if strings.Contains(entry.Name(), token) {
+				matched = append(matched, path)
+				break
+			}
+		}
+	}
+	if len(matched) > 0 {
+		return matched, nil
+	}
+	return all, nil
+}
+
+func readFile(path, source string, times *timeparse.Parser, start, end time.Time) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		e, ok := Parse(scanner.Text(), times)
+		if !ok || e.OccurredAt.Before(start) || !e.OccurredAt.Before(end) {
+			continue
+		}
+		e.Source = source
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// Count tallies events by kind.
+func Count(events []Event) map[string]int {
+	counts := map[string]int{}
+	for _, e := range events {
+		counts[e.Kind]++
+	}
+	return counts
+}
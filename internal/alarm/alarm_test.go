@@ -0,0 +1,68 @@
+package alarm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"workfield/internal/timeparse"
+)
+
+func TestParse(t *testing.T) {
+	times, err := timeparse.New(nil, "Asia/Seoul")
+	if err != nil {
+		t.Fatalf("parser: %v", err)
+	}
+	e, ok := Parse("2026-01-20 03:12:44.120 POWER-LOSS code=E01 source=UPS mains lost key=ignored", times)
+	if !ok {
+		t.Fatalf("line not parsed")
+	}
+	if e.Kind != "power_loss" || e.Code != "E01" || e.Fields["source"] != "UPS" || e.Message != "mains lost key=ignored" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if got := e.OccurredAt.Format("2006-01-02T15:04:05.000-07:00"); got != "2026-01-20T03:12:44.120+09:00" {
+		t.Fatalf("occurred at %s", got)
+	}
+	for _, line := range []string{"", "no timestamp DOOR_OPEN", "2026-01-20 03:12:44.120"} {
+		if _, ok := Parse(line, times); ok {
+			t.Fatalf("expected %q to be skipped", line)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, content string) {
+		path := filepath.Join(root, rel)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("ALARM/2026-01-20.log", "2026-01-20 09:00:00.000 DOOR_OPEN cabinet=1\n2026-01-19 23:59:59.000 DOOR_CLOSE\n")
+	write("ALARM/2026-01-19.log", "2026-01-19 08:00:00.000 DOOR_OPEN\n")
+	write("EVENT/controller.log", "2026-01-20 02:00:00.000 POWER_LOSS\ngarbage\n2026-01-20 02:00:05.000 POWER_RESTORE\n")
+	write("GATE1/2026-01-20.log", "2026-01-20 01:00:00.000 snd: 01\n")
+
+	events, err := Collect(context.Background(), root, nil, "20260120", timeparse.Default())
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	var kinds []string
+	for _, e := range events {
+		kinds = append(kinds, e.Source+":"+e.Kind)
+	}
+	want := []string{"EVENT:power_loss", "EVENT:power_restore", "ALARM:door_open"}
+	if len(kinds) != len(want) {
+		t.Fatalf("events = %v", kinds)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("events = %v, want %v", kinds, want)
+		}
+	}
+	if counts := Count(events); counts["door_open"] != 1 || counts["power_loss"] != 1 {
+		t.Fatalf("counts = %v", counts)
+	}
+}
@@ -2,14 +2,25 @@ package analyzer
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"workfield/internal/alarm"
+	"workfield/internal/decoder"
+	"workfield/internal/i18n"
+	"workfield/internal/timeparse"
+	"workfield/internal/tracing"
 )
 
 type Config struct {
@@ -21,32 +32,84 @@ type Config struct {
 	ExcludeDirs           []string
 	DuplicateRunThreshold int
 	FallbackToLatestFile  bool
-	Debug                 bool
+	PayloadFormat         PayloadFormat
+	Timestamps            *timeparse.Parser
+	Language              i18n.Lang
+	Decoders              *decoder.Set
+	// Events, when set, receives the normalized event stream as JSON lines
+	// while the sensors are analyzed.
+	Events io.Writer
+	// ControllerLogGlobs are the log_root directories holding controller
+	// event/alarm logs; empty means alarm.DefaultGlobs.
+	ControllerLogGlobs []string
+	// ExpectedSensors are the sensor IDs that should have logged on the
+	// day; one without a line that day is reported as MISSING_SENSOR.
+	ExpectedSensors []string
+	// Thresholds, when set, grade every sensor OK or WARN.
+	Thresholds Thresholds
+	// ExampleContext, when positive, adds that many lines before and after
+	// the first timeout, unanswered snd and zero data to the examples.
+	ExampleContext int
+	// SensorStream, when set, receives every sensor result as a SensorLine
+	// JSON line as soon as it is computed, and Summary.Sensors stays empty
+	// so a large log root is not held in memory.
+	SensorStream io.Writer
+	// DiskWarnDays, when positive, sets Summary.Disk.LowSpace once the log
+	// partition fills in fewer days at the day's growth.
+	DiskWarnDays int
+	// Schedules are when sensors are expected to report; timeouts and
+	// unanswered snd outside them count as off_schedule instead, and an
+	// expected sensor is not missing before its first window opens.
+	Schedules Schedules
+}
+
+// timestamps returns the configured line timestamp parser, defaulting to
+// timeparse.DefaultLayouts in the system timezone.
+func (c Config) timestamps() *timeparse.Parser {
+	if c.Timestamps == nil {
+		return timeparse.Default()
+	}
+	return c.Timestamps
 }
 
 type Metrics struct {
-	Lines          int       `json:"-"`
-	Timeout        int       `json:"timeout"`
-	NoResponse     int       `json:"no_response"`
-	ZeroData       int       `json:"zero_data"`
-	Duplicates     int       `json:"duplicates"`
-	TimeRange      TimeRange `json:"time_range"`
-	SndCount       int       `json:"snd_count"`
-	RcvCount       int       `json:"rcv_count"`
-	WLSLastValueCm *int      `json:"wls_last_value_cm,omitempty"`
-	WLSMinValueCm  *int      `json:"wls_min_value_cm,omitempty"`
-	WLSMaxValueCm  *int      `json:"wls_max_value_cm,omitempty"`
-	TotalPayloads  int       `json:"-"`
-	UniquePayloads int       `json:"-"`
+	Lines          int                        `json:"-"`
+	Timeout        int                        `json:"timeout"`
+	NoResponse     int                        `json:"no_response"`
+	ZeroData       int                        `json:"zero_data"`
+	Duplicates     int                        `json:"duplicates"`
+	ParseErrors    int                        `json:"parse_errors"`
+	OffSchedule    int                        `json:"off_schedule,omitempty"`
+	TimeRange      TimeRange                  `json:"time_range"`
+	SndCount       int                        `json:"snd_count"`
+	RcvCount       int                        `json:"rcv_count"`
+	SndBytes       int                        `json:"snd_bytes"`
+	RcvBytes       int                        `json:"rcv_bytes"`
+	FramesPerHour  float64                    `json:"frames_per_hour"`
+	FramesByHour   map[string]int             `json:"frames_by_hour,omitempty"`
+	Commands       []CommandCoverage          `json:"commands,omitempty"`
+	WLSLastValueCm *int                       `json:"wls_last_value_cm,omitempty"`
+	WLSMinValueCm  *int                       `json:"wls_min_value_cm,omitempty"`
+	WLSMaxValueCm  *int                       `json:"wls_max_value_cm,omitempty"`
+	DecodedLast    map[string]json.RawMessage `json:"decoded_last,omitempty"`
+	TotalPayloads  int                        `json:"-"`
+	UniquePayloads int                        `json:"-"`
 }
 
 type Examples struct {
 	FirstTimeoutLine    string `json:"first_timeout_line,omitempty"`
 	FirstNoResponseLine string `json:"first_no_response_line,omitempty"`
 	FirstZeroDataLine   string `json:"first_zero_data_line,omitempty"`
+	FirstParseErrorLine string `json:"first_parse_error_line,omitempty"`
 	TopDuplicatePayload string `json:"top_duplicate_payload,omitempty"`
 	ZeroDataPayload     string `json:"zero_data_payload,omitempty"`
 	Note                string `json:"note,omitempty"`
+
+	// The contexts are the lines around the first timeout, unanswered snd
+	// and zero data when Config.ExampleContext is set.
+	TimeoutContext    []string `json:"timeout_context,omitempty"`
+	NoResponseContext []string `json:"no_response_context,omitempty"`
+	ZeroDataContext   []string `json:"zero_data_context,omitempty"`
 }
 
 type TimeRange struct {
@@ -54,9 +117,18 @@ type TimeRange struct {
 	To   string `json:"to,omitempty"`
 }
 
+// Result and status of an expected sensor without any log line on the day.
+const (
+	ResultMissingSensor = "MISSING_SENSOR"
+	StatusError         = "ERROR"
+)
+
 type SensorResult struct {
 	SensorID   string   `json:"sensor_id"`
 	SensorType string   `json:"sensor_type"`
+	Result     string   `json:"result,omitempty"`
+	Status     string   `json:"status,omitempty"`
+	Exceeded   []string `json:"exceeded,omitempty"`
 	Metrics    Metrics  `json:"metrics"`
 	Examples   Examples `json:"examples"`
 }
@@ -69,15 +141,25 @@ type Summary struct {
 	LogRoot     string         `json:"log_root"`
 	Sensors     []SensorResult `json:"sensors"`
 	TopIssues   []TopIssue     `json:"top_issues"`
+	// ControllerEvents are the day's controller events, for context next
+	// to the sensor issues, with their counts by kind.
+	ControllerEvents      []alarm.Event  `json:"controller_events,omitempty"`
+	ControllerEventCounts map[string]int `json:"controller_event_counts,omitempty"`
+	// Disk is the space taken by the log root and its growth on the day.
+	Disk *DiskUsage `json:"disk,omitempty"`
 }
 
 type TopIssue struct {
 	Type     string `json:"type"`
+	Label    string `json:"label,omitempty"`
 	SensorID string `json:"sensor_id"`
+	Command  string `json:"command,omitempty"`
 	Count    int    `json:"count"`
 }
 
-func AnalyzeDaily(cfg Config, date string, maxLines int) (Summary, error) {
+// AnalyzeDaily analyzes every sensor directory under cfg.LogRoot for date
+// (YYYYMMDD). Cancelling ctx aborts the scan with ctx.Err().
+func AnalyzeDaily(ctx context.Context, cfg Config, date string, maxLines int) (Summary, error) {
 	if date == "" {
 		return Summary{}, errors.New("date is required")
 	}
@@ -87,6 +169,16 @@ func AnalyzeDaily(cfg Config, date string, maxLines int) (Summary, error) {
 	if cfg.DuplicateRunThreshold <= 0 {
 		cfg.DuplicateRunThreshold = 3
 	}
+	format, err := ParsePayloadFormat(string(cfg.PayloadFormat))
+	if err != nil {
+		return Summary{}, err
+	}
+	cfg.PayloadFormat = format
+	lang, err := i18n.ParseLang(string(cfg.Language))
+	if err != nil {
+		return Summary{}, err
+	}
+	cfg.Language = lang
 
 	datePrefix, err := normalizeDatePrefix(date)
 	if err != nil {
@@ -97,36 +189,59 @@ func AnalyzeDaily(cfg Config, date string, maxLines int) (Summary, error) {
 	if err != nil {
 		return Summary{}, err
 	}
+	ctx, span := tracing.Start(ctx, "analyzer.analyze_daily", tracing.String("date", date), tracing.Int("sensors", len(dirs)))
+	defer span.End()
 
-	var results []SensorResult
+	sensors := newSensorCollector(cfg, date)
 	for _, dir := range dirs {
-		result, err := analyzeSensorDir(dir, datePrefix, maxLines, cfg)
+		result, err := analyzeSensorDir(ctx, dir, datePrefix, maxLines, cfg)
 		if err != nil {
+			span.RecordError(err)
 			return Summary{}, err
 		}
 		if result.SensorID != "" {
-			results = append(results, result)
+			if err := sensors.add(result); err != nil {
+				return Summary{}, fmt.Errorf("sensor stream: %w", err)
+			}
 		}
 	}
+	if err := sensors.finish(); err != nil {
+		return Summary{}, fmt.Errorf("sensor stream: %w", err)
+	}
+
+	controllerEvents, err := alarm.Collect(ctx, cfg.LogRoot, cfg.ControllerLogGlobs, date, cfg.timestamps())
+	if err != nil {
+		span.RecordError(err)
+		return Summary{}, fmt.Errorf("controller events: %w", err)
+	}
+	start, err := cfg.timestamps().ParseDate(date)
+	if err != nil {
+		return Summary{}, err
+	}
+	disk, err := diskUsage(cfg.LogRoot, start, start.AddDate(0, 0, 1), cfg.DiskWarnDays)
+	if err != nil {
+		span.RecordError(err)
+		return Summary{}, fmt.Errorf("disk usage: %w", err)
+	}
 
 	summary := Summary{
-		SiteID:      cfg.SiteID,
-		DeviceID:    cfg.DeviceID,
-		Date:        date,
-		GeneratedAt: time.Now().Format(time.RFC3339),
-		LogRoot:     cfg.LogRoot,
-		Sensors:     results,
-		TopIssues:   buildTopIssues(results),
+		SiteID:           cfg.SiteID,
+		DeviceID:         cfg.DeviceID,
+		Date:             date,
+		GeneratedAt:      time.Now().Format(time.RFC3339),
+		LogRoot:          cfg.LogRoot,
+		Sensors:          sensors.results,
+		TopIssues:        rankIssues(sensors.issues),
+		ControllerEvents: controllerEvents,
+		Disk:             disk,
+	}
+	if len(controllerEvents) > 0 {
+		summary.ControllerEventCounts = alarm.Count(controllerEvents)
 	}
 	return summary, nil
 }
 
-func analyzeSensorDir(dir, datePrefix string, maxLines int, cfg Config) (SensorResult, error) {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return SensorResult{}, err
-	}
-
+func analyzeSensorDir(ctx context.Context, dir, datePrefix string, maxLines int, cfg Config) (SensorResult, error) {
 	sensorID := filepath.Base(dir)
 	sensorType := sensorTypeFromID(sensorID)
 	if sensorType == "" {
@@ -136,18 +251,24 @@ func analyzeSensorDir(dir, datePrefix string, maxLines int, cfg Config) (SensorR
 	metrics := Metrics{}
 	examples := Examples{}
 	payloadCounts := map[string]int{}
-	state := SensorState{}
+	state := SensorState{SensorID: sensorID, Schedule: cfg.Schedules.For(sensorID, sensorType), Context: newLineContext(cfg.ExampleContext)}
 	var lastPayload string
 	consecutive := 0
 	linesRead := 0
 
-	files, fileNotes, err := selectFiles(entries, dir, datePrefix, cfg.FallbackToLatestFile)
+	_, span := tracing.Start(ctx, "analyzer.sensor", tracing.String("sensor_id", sensorID))
+	defer span.End()
+
+	var events *json.Encoder
+	if cfg.Events != nil {
+		events = json.NewEncoder(cfg.Events)
+	}
+	onDate := newDayFilter(datePrefix, cfg.timestamps())
+	files, fileNotes, err := selectFiles(dir, datePrefix, cfg.FallbackToLatestFile)
 	if err != nil {
 		return SensorResult{}, err
 	}
-	if cfg.Debug {
-		fmt.Printf("sensor=%s files=%d fallback=%t\n", sensorID, len(files), fileNotes.usedFallback)
-	}
+	slog.Debug("sensor files selected", "sensor", sensorID, "files", len(files), "fallback", fileNotes.usedFallback)
 
 	for _, path := range files {
 		file, err := os.Open(path)
@@ -160,13 +281,27 @@ func analyzeSensorDir(dir, datePrefix string, maxLines int, cfg Config) (SensorR
 			if linesRead >= maxLines {
 				break
 			}
+			if err := ctx.Err(); err != nil {
+				file.Close()
+				return SensorResult{}, err
+			}
 			line := scanner.Text()
 			trimmed := strings.TrimLeft(line, " \t")
-			if !strings.HasPrefix(trimmed, datePrefix) {
+			if !onDate.match(trimmed) {
 				continue
 			}
 			linesRead++
-			metrics, examples, lastPayload, consecutive, state = updateMetrics(metrics, examples, trimmed, sensorType, cfg, payloadCounts, lastPayload, consecutive, state)
+			metrics, examples, lastPayload, consecutive, state = updateMetrics(ctx, metrics, examples, trimmed, sensorType, cfg, payloadCounts, lastPayload, consecutive, state)
+			if state.DecoderErr != nil {
+				file.Close()
+				return SensorResult{}, state.DecoderErr
+			}
+			if events != nil {
+				if state, err = flushEvents(events, state); err != nil {
+					file.Close()
+					return SensorResult{}, fmt.Errorf("event stream: %w", err)
+				}
+			}
 		}
 		file.Close()
 		if err := scanner.Err(); err != nil {
@@ -177,10 +312,9 @@ func analyzeSensorDir(dir, datePrefix string, maxLines int, cfg Config) (SensorR
 		}
 	}
 
-	metrics, examples = finalizeMetrics(metrics, examples, state, payloadCounts, datePrefix)
-	if cfg.Debug {
-		fmt.Printf("sensor=%s lines=%d payloads=%d\n", sensorID, metrics.Lines, metrics.TotalPayloads)
-	}
+	metrics, examples = finalizeMetrics(metrics, examples, state, payloadCounts, datePrefix, cfg)
+	span.SetAttributes(tracing.Int("lines", metrics.Lines), tracing.Int("payloads", metrics.TotalPayloads))
+	slog.Debug("sensor analyzed", "sensor", sensorID, "lines", metrics.Lines, "payloads", metrics.TotalPayloads)
 
 	return SensorResult{
 		SensorID:   sensorID,
@@ -234,11 +368,8 @@ func findSensorDirs(root string, includeGlobs, excludeDirs []string) ([]string,
 }
 
 func normalizeDatePrefix(date string) (string, error) {
-	if len(date) != 8 {
-		return "", fmt.Errorf("invalid date %q: expected YYYYMMDD", date)
-	}
-	if _, err := time.Parse("20060102", date); err != nil {
-		return "", fmt.Errorf("invalid date %q: expected YYYYMMDD", date)
+	if _, err := timeparse.ParseDate(date); err != nil {
+		return "", err
 	}
 	return fmt.Sprintf("%s-%s-%s", date[:4], date[4:6], date[6:]), nil
 }
@@ -299,24 +430,56 @@ func topDuplicatePayload(counts map[string]int) string {
 	return top
 }
 
-func buildTopIssues(results []SensorResult) []TopIssue {
+// buildTopIssues lists every missing sensor first, then the five largest
+// counts of the other issues.
+func buildTopIssues(results []SensorResult, lang i18n.Lang) []TopIssue {
 	var issues []TopIssue
 	for _, result := range results {
-		metrics := result.Metrics
-		if metrics.Timeout > 0 {
-			issues = append(issues, TopIssue{Type: "timeout", SensorID: result.SensorID, Count: metrics.Timeout})
-		}
-		if metrics.NoResponse > 0 {
-			issues = append(issues, TopIssue{Type: "no_response", SensorID: result.SensorID, Count: metrics.NoResponse})
-		}
-		if metrics.ZeroData > 0 {
-			issues = append(issues, TopIssue{Type: "zero_data", SensorID: result.SensorID, Count: metrics.ZeroData})
-		}
-		if metrics.Duplicates > 0 {
-			issues = append(issues, TopIssue{Type: "duplicates", SensorID: result.SensorID, Count: metrics.Duplicates})
+		issues = append(issues, sensorIssues(result, lang)...)
+	}
+	return rankIssues(issues)
+}
+
+func sensorIssues(result SensorResult, lang i18n.Lang) []TopIssue {
+	var issues []TopIssue
+	if result.Result == ResultMissingSensor {
+		issues = append(issues, TopIssue{Type: "missing_sensor", Label: lang.T(i18n.IssueMissingSensor), SensorID: result.SensorID, Count: 1})
+	}
+	metrics := result.Metrics
+	for _, candidate := range []struct {
+		kind, label string
+		count       int
+	}{
+		{"timeout", i18n.IssueTimeout, metrics.Timeout},
+		{"no_response", i18n.IssueNoResponse, metrics.NoResponse},
+		{"zero_data", i18n.IssueZeroData, metrics.ZeroData},
+		{"duplicates", i18n.IssueDuplicates, metrics.Duplicates},
+		{"parse_errors", i18n.IssueParseErrors, metrics.ParseErrors},
+	} {
+		if candidate.count > 0 {
+			issues = append(issues, TopIssue{Type: candidate.kind, Label: lang.T(candidate.label), SensorID: result.SensorID, Count: candidate.count})
+		}
+	}
+	// A sensor that never answered is already a no_response issue; the
+	// commands matter when the sensor answers some of them.
+	for _, command := range metrics.Commands {
+		if command.Coverage == CoverageNeverAnswered && metrics.RcvCount > 0 {
+			issues = append(issues, TopIssue{Type: "unanswered_command", Label: lang.T(i18n.IssueUnansweredCommand), SensorID: result.SensorID, Command: command.Command, Count: command.Sent})
 		}
 	}
+	return issues
+}
 
+func rankIssues(all []TopIssue) []TopIssue {
+	var missing, issues []TopIssue
+	for _, issue := range all {
+		if issue.Type == "missing_sensor" {
+			missing = append(missing, issue)
+		} else {
+			issues = append(issues, issue)
+		}
+	}
+	sort.SliceStable(missing, func(i, j int) bool { return missing[i].SensorID < missing[j].SensorID })
 	sort.Slice(issues, func(i, j int) bool {
 		if issues[i].Count == issues[j].Count {
 			return issues[i].SensorID < issues[j].SensorID
@@ -326,55 +489,104 @@ func buildTopIssues(results []SensorResult) []TopIssue {
 	if len(issues) > 5 {
 		issues = issues[:5]
 	}
-	return issues
+	return append(missing, issues...)
 }
 
 func analyzeLines(lines []string, datePrefix string, sensorType string, cfg Config) (Metrics, Examples) {
 	metrics := Metrics{}
 	examples := Examples{}
 	payloadCounts := map[string]int{}
-	state := SensorState{}
+	state := SensorState{Schedule: cfg.Schedules.For("", sensorType), Context: newLineContext(cfg.ExampleContext)}
 	var lastPayload string
 	consecutive := 0
+	onDate := newDayFilter(datePrefix, cfg.timestamps())
 	for _, line := range lines {
 		trimmed := strings.TrimLeft(line, " \t")
-		if !strings.HasPrefix(trimmed, datePrefix) {
+		if !onDate.match(trimmed) {
 			continue
 		}
-		metrics, examples, lastPayload, consecutive, state = updateMetrics(metrics, examples, trimmed, sensorType, cfg, payloadCounts, lastPayload, consecutive, state)
+		metrics, examples, lastPayload, consecutive, state = updateMetrics(context.Background(), metrics, examples, trimmed, sensorType, cfg, payloadCounts, lastPayload, consecutive, state)
 	}
-	return finalizeMetrics(metrics, examples, state, payloadCounts, datePrefix)
+	return finalizeMetrics(metrics, examples, state, payloadCounts, datePrefix, cfg)
 }
 
-func updateMetrics(metrics Metrics, examples Examples, line string, sensorType string, cfg Config, payloadCounts map[string]int, lastPayload string, consecutive int, state SensorState) (Metrics, Examples, string, int, SensorState) {
+func updateMetrics(ctx context.Context, metrics Metrics, examples Examples, line string, sensorType string, cfg Config, payloadCounts map[string]int, lastPayload string, consecutive int, state SensorState) (Metrics, Examples, string, int, SensorState) {
 	metrics.Lines++
 	trimmed := strings.TrimLeft(line, " \t")
 	lower := strings.ToLower(trimmed)
-	lineTime, hasTime := parseLineTime(trimmed)
-	if strings.Contains(lower, "timeout") {
+	lineTime, _, hasTime := cfg.timestamps().ParsePrefix(trimmed)
+	scheduled := !hasTime || state.Schedule.Covers(lineTime)
+	state.Context.begin(line)
+	defer state.Context.end(line)
+	if strings.Contains(lower, "timeout") && !scheduled {
+		metrics.OffSchedule++
+		state = emit(cfg, state, sensorType, lineTime, EventTimeout, "")
+	} else if strings.Contains(lower, "timeout") {
+		state.Context.occurred(contextTimeout, line)
 		metrics.Timeout++
 		if examples.FirstTimeoutLine == "" {
 			examples.FirstTimeoutLine = line
 		}
+		state = emit(cfg, state, sensorType, lineTime, EventTimeout, "")
 	}
 	if hasTime && strings.Contains(lower, "snd:") {
+		state.Context.sent(line, state.HasPending)
 		state = updateTimeRange(state, lineTime)
 		state.PendingSentAt = lineTime
 		state.PendingLine = metrics.Lines
 		state.HasPending = true
 		state.SndCount++
+		if !scheduled {
+			state.OffScheduleSnd++
+		}
+		state.SndBytes += payloadBytes(sndPayload(trimmed), cfg.PayloadFormat)
+		state = countCommand(state, sndPayload(trimmed), cfg.PayloadFormat)
+		state = countFrame(state, lineTime)
+		state = emit(cfg, state, sensorType, lineTime, EventSnd, sndPayload(trimmed))
 	}
 
+	rcvEvent := -1
 	if hasTime && strings.Contains(lower, "rcv:") {
 		state = updateTimeRange(state, lineTime)
 		state.RcvCount++
+		payload, _ := extractPayload(trimmed)
+		state.RcvBytes += payloadBytes(payload, cfg.PayloadFormat)
+		state = countFrame(state, lineTime)
+		state = emit(cfg, state, sensorType, lineTime, EventRcv, payload)
+		if cfg.Events != nil {
+			rcvEvent = len(state.Events) - 1
+			if state.HasPending {
+				state.Events[rcvEvent].LatencyMS = latencyMS(state.PendingSentAt, lineTime)
+			}
+		}
+		if state.HasPending {
+			state.Commands[state.PendingCommand].answered++
+		}
+		state.Context.answered()
 		state.HasPending = false
 	}
 
 	payload, ok := extractPayload(trimmed)
 	if ok {
 		metrics.TotalPayloads++
-		isValid, isZero := validateWLSFrame(payload, sensorType)
+		var isValid, isZero bool
+		var parseErr error
+		if cfg.Decoders.Has(sensorType) {
+			isValid, state, parseErr = decodeWithPlugin(ctx, payload, sensorType, cfg, state)
+		} else {
+			isValid, isZero, parseErr = validateWLSFrame(payload, sensorType, cfg.PayloadFormat)
+		}
+		if parseErr != nil {
+			metrics.ParseErrors++
+			if examples.FirstParseErrorLine == "" {
+				examples.FirstParseErrorLine = line
+			}
+			state = emit(cfg, state, sensorType, lineTime, EventParseError, payload)
+		}
+		if isZero || isZeroPayload(payload) {
+			state.Context.occurred(contextZeroData, line)
+			state = emit(cfg, state, sensorType, lineTime, EventZeroData, payload)
+		}
 		if isZero {
 			metrics.ZeroData++
 			if examples.FirstZeroDataLine == "" {
@@ -404,13 +616,14 @@ func updateMetrics(metrics Metrics, examples Examples, line string, sensorType s
 			consecutive++
 			if consecutive >= cfg.DuplicateRunThreshold {
 				metrics.Duplicates++
+				state = emit(cfg, state, sensorType, lineTime, EventDuplicate, payload)
 			}
 		} else {
 			lastPayload = payload
 			consecutive = 1
 		}
-		if strings.EqualFold(sensorType, "WLS") && isValid && !isZero {
-			if value, ok := parseWLSValue(payload); ok {
+		if strings.EqualFold(sensorType, "WLS") && isValid && !isZero && !cfg.Decoders.Has(sensorType) {
+			if value, ok := parseWLSValue(payload, cfg.PayloadFormat); ok {
 				state.WLSLast = &value
 				if state.WLSMin == nil || value < *state.WLSMin {
 					state.WLSMin = &value
@@ -418,8 +631,14 @@ func updateMetrics(metrics Metrics, examples Examples, line string, sensorType s
 				if state.WLSMax == nil || value > *state.WLSMax {
 					state.WLSMax = &value
 				}
+				if rcvEvent >= 0 {
+					state.Events[rcvEvent].Value = value
+				}
 			}
 		}
+		if rcvEvent >= 0 && isValid && cfg.Decoders.Has(sensorType) {
+			state.Events[rcvEvent].Value = state.Decoded
+		}
 	} else {
 		lastPayload = ""
 		consecutive = 0
@@ -428,72 +647,34 @@ func updateMetrics(metrics Metrics, examples Examples, line string, sensorType s
 	return metrics, examples, lastPayload, consecutive, state
 }
 
-type fileSelectionNotes struct {
-	usedFallback bool
-}
-
-func selectFiles(entries []os.DirEntry, dir string, datePrefix string, fallback bool) ([]string, fileSelectionNotes, error) {
-	dateToken := datePrefix
-	var matched []string
-	var files []string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		path := filepath.Join(dir, entry.Name())
-		files = append(files, path)
-		if strings.Contains(entry.Name(), dateToken) {
-			matched = append(matched, path)
-		}
-	}
-	sort.Strings(matched)
-	if len(matched) > 0 {
-		return matched, fileSelectionNotes{}, nil
-	}
-	if !fallback || len(files) == 0 {
-		return nil, fileSelectionNotes{}, nil
-	}
-	latest, err := latestFile(files)
-	if err != nil {
-		return nil, fileSelectionNotes{}, err
-	}
-	return []string{latest}, fileSelectionNotes{usedFallback: true}, nil
-}
-
-func latestFile(files []string) (string, error) {
-	var latest string
-	var latestTime time.Time
-	for _, path := range files {
-		info, err := os.Stat(path)
-		if err != nil {
-			return "", err
-		}
-		if latest == "" || info.ModTime().After(latestTime) {
-			latest = path
-			latestTime = info.ModTime()
-		}
-	}
-	if latest == "" {
-		return "", errors.New("no files available")
-	}
-	return latest, nil
-}
-
 type SensorState struct {
+	SensorID       string
+	Decoded        map[string]json.RawMessage
+	DecoderErr     error
 	PendingSentAt  time.Time
 	PendingLine    int
 	HasPending     bool
+	PendingCommand string
+	Schedule       Schedule
+	OffScheduleSnd int
+	Commands       map[string]*commandCount
 	TimeRangeStart time.Time
 	TimeRangeEnd   time.Time
 	HasTimeRange   bool
 	SndCount       int
 	RcvCount       int
+	SndBytes       int
+	RcvBytes       int
+	FrameHours     map[int]int
+	Context        *lineContext
 	WLSLast        *int
 	WLSMin         *int
 	WLSMax         *int
+	// Events queues the event stream entries of the current line.
+	Events []Event
 }
 
-func finalizeMetrics(metrics Metrics, examples Examples, state SensorState, payloadCounts map[string]int, datePrefix string) (Metrics, Examples) {
+func finalizeMetrics(metrics Metrics, examples Examples, state SensorState, payloadCounts map[string]int, datePrefix string, cfg Config) (Metrics, Examples) {
 	if state.HasTimeRange {
 		metrics.TimeRange = TimeRange{
 			From: state.TimeRangeStart.Format(time.RFC3339),
@@ -501,51 +682,111 @@ func finalizeMetrics(metrics Metrics, examples Examples, state SensorState, payl
 		}
 	} else {
 		if metrics.Lines > 0 {
-			estimated, ok := estimateRangeFromDate(datePrefix)
+			estimated, ok := estimateRangeFromDate(datePrefix, cfg.timestamps().Location())
 			if ok {
 				metrics.TimeRange = estimated
 				if examples.Note == "" {
-					examples.Note = "time_range estimated from filename"
+					examples.Note = cfg.Language.T(i18n.NoteTimeRangeEstimated)
 				}
 			}
 		} else if examples.Note == "" {
-			examples.Note = "no timestamps found for date"
+			examples.Note = cfg.Language.T(i18n.NoteNoTimestamps)
 		}
 	}
 	metrics.SndCount = state.SndCount
 	metrics.RcvCount = state.RcvCount
+	metrics.SndBytes = state.SndBytes
+	metrics.RcvBytes = state.RcvBytes
+	metrics.FramesPerHour, metrics.FramesByHour = frameRate(state)
+	metrics.Commands = commandCoverage(state)
+	state.Context.finish(state.HasPending)
+	examples.TimeoutContext = state.Context.lines(contextTimeout)
+	examples.NoResponseContext = state.Context.lines(contextNoResponse)
+	examples.ZeroDataContext = state.Context.lines(contextZeroData)
 	if state.SndCount > 0 && state.RcvCount == 0 {
-		metrics.NoResponse = state.SndCount
+		metrics.NoResponse = state.SndCount - state.OffScheduleSnd
+		metrics.OffSchedule += state.OffScheduleSnd
+	}
+	if metrics.NoResponse > 0 {
 		if examples.Note == "" {
-			examples.Note = "snd exists but no rcv found; treated as no_response"
+			examples.Note = cfg.Language.T(i18n.NoteSndWithoutRcv)
 		}
 	}
 	examples.TopDuplicatePayload = topDuplicatePayload(payloadCounts)
 	metrics.WLSLastValueCm = state.WLSLast
 	metrics.WLSMinValueCm = state.WLSMin
 	metrics.WLSMaxValueCm = state.WLSMax
+	metrics.DecodedLast = state.Decoded
 	if metrics.TotalPayloads == 0 {
 		if examples.Note == "" {
-			examples.Note = "no payload for date"
+			examples.Note = cfg.Language.T(i18n.NoteNoPayload)
 		}
 	}
 	return metrics, examples
 }
 
-func parseLineTime(line string) (time.Time, bool) {
-	if len(line) < len("2006-01-02 15:04:05.000") {
-		return time.Time{}, false
+// dayFilter selects the lines of one day. A line belongs to the day when its
+// timestamp falls on it in the parser's timezone; lines whose timestamp does
+// not parse still match on a literal YYYY-MM-DD prefix.
+type dayFilter struct {
+	prefix     string
+	start, end time.Time
+	times      *timeparse.Parser
+}
+
+func newDayFilter(datePrefix string, times *timeparse.Parser) dayFilter {
+	filter := dayFilter{prefix: datePrefix, times: times}
+	if day, err := time.ParseInLocation(timeparse.DayLayout, datePrefix, times.Location()); err == nil {
+		filter.start = day
+		filter.end = day.AddDate(0, 0, 1)
 	}
-	trimmed := strings.TrimLeft(line, " \t")
-	if len(trimmed) < len("2006-01-02 15:04:05.000") {
-		return time.Time{}, false
+	return filter
+}
+
+func (f dayFilter) match(line string) bool {
+	if t, _, ok := f.times.ParsePrefix(line); ok && !f.start.IsZero() {
+		return !t.Before(f.start) && t.Before(f.end)
 	}
-	value := trimmed[:23]
-	parsed, err := time.ParseInLocation("2006-01-02 15:04:05.000", value, time.Local)
-	if err != nil {
-		return time.Time{}, false
+	return strings.HasPrefix(line, f.prefix)
+}
+
+// payloadBytes is the size of a logged payload: its decoded bytes, or the
+// text itself for commands logged as text (e.g. "snd: STATUS").
+func payloadBytes(payload string, format PayloadFormat) int {
+	if payload == "" {
+		return 0
+	}
+	if decoded, err := DecodeBytes(payload, format); err == nil {
+		return len(decoded)
 	}
-	return parsed, true
+	return len(payload)
+}
+
+func countFrame(state SensorState, at time.Time) SensorState {
+	if state.FrameHours == nil {
+		state.FrameHours = map[int]int{}
+	}
+	state.FrameHours[at.Hour()]++
+	return state
+}
+
+// frameRate returns the snd and rcv frames per hour over the logged time
+// range (at least one hour) and the frame counts by hour ("00"-"23").
+func frameRate(state SensorState) (float64, map[string]int) {
+	if len(state.FrameHours) == 0 {
+		return 0, nil
+	}
+	byHour := map[string]int{}
+	total := 0
+	for hour, count := range state.FrameHours {
+		byHour[fmt.Sprintf("%02d", hour)] = count
+		total += count
+	}
+	hours := state.TimeRangeEnd.Sub(state.TimeRangeStart).Hours()
+	if hours < 1 {
+		hours = 1
+	}
+	return math.Round(float64(total)/hours*100) / 100, byHour
 }
 
 func updateTimeRange(state SensorState, value time.Time) SensorState {
@@ -564,8 +805,8 @@ func updateTimeRange(state SensorState, value time.Time) SensorState {
 	return state
 }
 
-func estimateRangeFromDate(datePrefix string) (TimeRange, bool) {
-	parsed, err := time.ParseInLocation("2006-01-02", datePrefix, time.Local)
+func estimateRangeFromDate(datePrefix string, location *time.Location) (TimeRange, bool) {
+	parsed, err := time.ParseInLocation(timeparse.DayLayout, datePrefix, location)
 	if err != nil {
 		return TimeRange{}, false
 	}
@@ -577,9 +818,9 @@ func estimateRangeFromDate(datePrefix string) (TimeRange, bool) {
 	}, true
 }
 
-func parseWLSValue(payload string) (int, bool) {
-	bytes, ok := parsePayloadBytes(payload)
-	if !ok {
+func parseWLSValue(payload string, format PayloadFormat) (int, bool) {
+	bytes, err := DecodeBytes(payload, format)
+	if err != nil {
 		return 0, false
 	}
 	if len(bytes) < 6 {
@@ -592,18 +833,43 @@ func parseWLSValue(payload string) (int, bool) {
 	return value, true
 }
 
-func validateWLSFrame(payload string, sensorType string) (bool, bool) {
+// validateWLSFrame reports whether payload is a well-formed WLS frame and
+// whether it should count as zero_data. Undecodable payloads are invalid
+// frames as before and additionally return the decode error.
+// decodeWithPlugin replaces the built-in frame checks for sensor types with a
+// decoder plugin. Rejected payloads are parse errors; a failing plugin is
+// kept in state and ends the sensor's analysis.
+func decodeWithPlugin(ctx context.Context, payload, sensorType string, cfg Config, state SensorState) (bool, SensorState, error) {
+	values, err := cfg.Decoders.Decode(ctx, sensorType, state.SensorID, payload)
+	switch {
+	case err == nil:
+		state.Decoded = values
+		return true, state, nil
+	case errors.Is(err, decoder.ErrDecode):
+		return false, state, err
+	default:
+		if state.DecoderErr == nil {
+			state.DecoderErr = err
+		}
+		return false, state, nil
+	}
+}
+
+func validateWLSFrame(payload string, sensorType string, format PayloadFormat) (bool, bool, error) {
 	if !strings.EqualFold(sensorType, "WLS") {
-		return true, false
+		return true, false, nil
+	}
+	bytes, err := DecodeBytes(payload, format)
+	if err != nil {
+		return false, true, err
 	}
-	bytes, ok := parsePayloadBytes(payload)
-	if !ok || len(bytes) != 11 {
-		return false, true
+	if len(bytes) != 11 {
+		return false, true, nil
 	}
 	if bytes[0] != 0xFA || bytes[len(bytes)-1] != 0x76 {
-		return false, true
+		return false, true, nil
 	}
-	return true, false
+	return true, false, nil
 }
 
 func parsePayloadBytes(payload string) ([]byte, bool) {
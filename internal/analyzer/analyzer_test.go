@@ -1,10 +1,20 @@
 package analyzer
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"workfield/internal/decoder"
+	"workfield/internal/i18n"
+	"workfield/internal/timeparse"
 )
 
 func TestDuplicateCounting(t *testing.T) {
@@ -56,11 +66,7 @@ func TestSelectFilesByDate(t *testing.T) {
 			t.Fatalf("write: %v", err)
 		}
 	}
-	entries, err := os.ReadDir(sensorDir)
-	if err != nil {
-		t.Fatalf("readdir: %v", err)
-	}
-	selected, _, err := selectFiles(entries, sensorDir, "2026-01-19", true)
+	selected, _, err := selectFiles(sensorDir, "2026-01-19", true)
 	if err != nil {
 		t.Fatalf("selectFiles: %v", err)
 	}
@@ -69,6 +75,51 @@ func TestSelectFilesByDate(t *testing.T) {
 	}
 }
 
+func TestSelectFilesNestedLayout(t *testing.T) {
+	sensorDir := filepath.Join(t.TempDir(), "GATE1")
+	for _, rel := range []string{
+		"2026/01/gate.log",
+		"2026/01/2026-01-18.log",
+		"2026/01/20260119_gate.log",
+		"2026/01/19/part1.log",
+		"2026/01/20/part1.log",
+		"2026/02/gate.log",
+		"2025/01/gate.log",
+		"2026-01/gate.log",
+		"backup/2026-01-19.log",
+		"backup/notes.txt",
+	} {
+		path := filepath.Join(sensorDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("2026-01-19 00:00:01.000 rcv: (01)\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	selected, _, err := selectFiles(sensorDir, "2026-01-19", false)
+	if err != nil {
+		t.Fatalf("selectFiles: %v", err)
+	}
+	var got []string
+	for _, path := range selected {
+		rel, _ := filepath.Rel(sensorDir, path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	want := []string{"2026-01/gate.log", "2026/01/19/part1.log", "2026/01/20260119_gate.log", "2026/01/gate.log", "backup/2026-01-19.log"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("selected %v, want %v", got, want)
+	}
+
+	result, err := analyzeSensorDir(context.Background(), sensorDir, "2026-01-19", 100, Config{DuplicateRunThreshold: 3})
+	if err != nil {
+		t.Fatalf("analyzeSensorDir: %v", err)
+	}
+	if result.Metrics.Lines != len(want) {
+		t.Fatalf("expected %d lines, got %d", len(want), result.Metrics.Lines)
+	}
+}
+
 func TestAnalyzeSensorDirFiltersByDate(t *testing.T) {
 	root := t.TempDir()
 	sensorDir := filepath.Join(root, "GATE1")
@@ -85,7 +136,7 @@ func TestAnalyzeSensorDirFiltersByDate(t *testing.T) {
 		t.Fatalf("write: %v", err)
 	}
 
-	result, err := analyzeSensorDir(sensorDir, "2026-01-19", 100, Config{FallbackToLatestFile: true, DuplicateRunThreshold: 3})
+	result, err := analyzeSensorDir(context.Background(), sensorDir, "2026-01-19", 100, Config{FallbackToLatestFile: true, DuplicateRunThreshold: 3})
 	if err != nil {
 		t.Fatalf("analyzeSensorDir: %v", err)
 	}
@@ -158,3 +209,489 @@ func TestWLSFrameInvalidLengthCountsZeroData(t *testing.T) {
 		t.Fatalf("expected zero_data_payload to be set")
 	}
 }
+
+func TestAnalyzeLinesWithConfiguredLayout(t *testing.T) {
+	times, err := timeparse.New([]string{"2006/01/02 15:04:05"}, "UTC")
+	if err != nil {
+		t.Fatalf("timeparse: %v", err)
+	}
+	cfg := Config{DuplicateRunThreshold: 3, Timestamps: times}
+	metrics, _ := analyzeLines([]string{
+		"2026/01/18 23:59:59 rcv: (01)",
+		"2026/01/19 00:00:01 snd: STATUS",
+		"2026/01/19 00:00:02 rcv: (02)",
+	}, "2026-01-19", "GATE", cfg)
+
+	if metrics.Lines != 2 || metrics.SndCount != 1 || metrics.RcvCount != 1 {
+		t.Fatalf("expected 2 lines with 1 snd and 1 rcv, got %+v", metrics)
+	}
+	if metrics.TimeRange.From != "2026-01-19T00:00:01Z" {
+		t.Fatalf("unexpected time range %+v", metrics.TimeRange)
+	}
+}
+
+func TestNotesAndIssueLabelsFollowLanguage(t *testing.T) {
+	cfg := Config{DuplicateRunThreshold: 3, Language: i18n.Korean}
+	metrics, examples := analyzeLines([]string{
+		"2026-01-19 00:00:01.000 snd: STATUS",
+	}, "2026-01-19", "GATE", cfg)
+
+	if examples.Note != i18n.Korean.T(i18n.NoteSndWithoutRcv) {
+		t.Fatalf("expected Korean note, got %q", examples.Note)
+	}
+	issues := buildTopIssues([]SensorResult{{SensorID: "GATE1", Metrics: metrics}}, cfg.Language)
+	if len(issues) != 1 || issues[0].Type != "no_response" || issues[0].Label != "무응답" {
+		t.Fatalf("unexpected issues %+v", issues)
+	}
+}
+
+func TestAnalyzeSensorDirHandlesCRLF(t *testing.T) {
+	sensorDir := filepath.Join(t.TempDir(), "GATE1")
+	if err := os.MkdirAll(sensorDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	content := "2026-01-19 00:00:01.000 rcv: (01)\r\n2026-01-19 00:00:02.000 rcv: (01)\r\n"
+	if err := os.WriteFile(filepath.Join(sensorDir, "2026-01-19.log"), []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	result, err := analyzeSensorDir(context.Background(), sensorDir, "2026-01-19", 100, Config{DuplicateRunThreshold: 1})
+	if err != nil {
+		t.Fatalf("analyzeSensorDir: %v", err)
+	}
+	if result.Metrics.RcvCount != 2 || result.Metrics.Duplicates != 1 {
+		t.Fatalf("expected 2 rcv and 1 duplicate, got %+v", result.Metrics)
+	}
+}
+
+// TestHelperDecoder is the decoder plugin for TestDecoderPluginReplacesFrameChecks.
+func TestHelperDecoder(t *testing.T) {
+	if os.Getenv("ANALYZER_DECODER_HELPER") == "" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req decoder.Request
+		json.Unmarshal(scanner.Bytes(), &req)
+		if strings.HasPrefix(req.Payload, "OK") {
+			fmt.Printf("{\"values\":{\"level\":%q}}\n", strings.TrimPrefix(req.Payload, "OK "))
+		} else {
+			fmt.Println(`{"error":"unknown frame"}`)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestDecoderPluginReplacesFrameChecks(t *testing.T) {
+	t.Setenv("ANALYZER_DECODER_HELPER", "1")
+	decoders, err := decoder.NewSet(map[string][]string{"WLS": {os.Args[0], "-test.run=^TestHelperDecoder$"}})
+	if err != nil {
+		t.Fatalf("decoder: %v", err)
+	}
+	defer decoders.Close()
+
+	cfg := Config{DuplicateRunThreshold: 3, Decoders: decoders}
+	metrics, examples := analyzeLines([]string{
+		"2026-01-19 00:00:01.000 rcv: OK 12",
+		"2026-01-19 00:00:02.000 rcv: XX",
+		"2026-01-19 00:00:03.000 rcv: OK 15",
+	}, "2026-01-19", "WLS", cfg)
+
+	if metrics.ParseErrors != 1 || metrics.ZeroData != 0 || examples.FirstParseErrorLine == "" {
+		t.Fatalf("expected one parse error and no zero_data, got %+v", metrics)
+	}
+	if string(metrics.DecodedLast["level"]) != `"15"` {
+		t.Fatalf("expected last decoded level 15, got %v", metrics.DecodedLast)
+	}
+}
+
+func TestAnalyzeSensorDirWritesEventStream(t *testing.T) {
+	sensorDir := filepath.Join(t.TempDir(), "WLS1")
+	if err := os.MkdirAll(sensorDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	content := strings.Join([]string{
+		"2026-01-19 00:00:01.000 snd: READ",
+		"2026-01-19 00:00:01.250 rcv: FA 00 00 00 00 3C 00 00 00 00 76",
+		"2026-01-19 00:00:02.000 timeout",
+		"2026-01-19 00:00:03.000 rcv: 00 00 00",
+	}, "\n")
+	if err := os.WriteFile(filepath.Join(sensorDir, "2026-01-19.log"), []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var stream strings.Builder
+	cfg := Config{DuplicateRunThreshold: 3, Events: &stream}
+	if _, err := analyzeSensorDir(context.Background(), sensorDir, "2026-01-19", 100, cfg); err != nil {
+		t.Fatalf("analyzeSensorDir: %v", err)
+	}
+	var events []Event
+	scanner := bufio.NewScanner(strings.NewReader(stream.String()))
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("decode %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	if got := strings.Join(types, ","); got != "snd,rcv,timeout,rcv,zero_data" {
+		t.Fatalf("unexpected events %s", got)
+	}
+	rcv := events[1]
+	if rcv.SensorID != "WLS1" || rcv.Value != float64(60) || rcv.LatencyMS == nil || *rcv.LatencyMS != 250 {
+		t.Fatalf("unexpected rcv event %+v", rcv)
+	}
+	if events[3].LatencyMS != nil {
+		t.Fatalf("rcv without a pending snd must not carry a latency: %+v", events[3])
+	}
+}
+
+func TestAnalyzeDailyIncludesControllerEvents(t *testing.T) {
+	root := t.TempDir()
+	for rel, content := range map[string]string{
+		"GATE1/2026-01-19.log": "2026-01-19 02:00:01.000 snd: 01\n",
+		"ALARM/2026-01-19.log": "2026-01-19 02:00:00.000 POWER_LOSS code=E01\n2026-01-19 02:10:00.000 POWER_RESTORE\n",
+	} {
+		path := filepath.Join(root, rel)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	summary, err := AnalyzeDaily(context.Background(), Config{LogRoot: root}, "20260119", 0)
+	if err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	if len(summary.Sensors) != 1 || summary.Sensors[0].Metrics.NoResponse != 1 {
+		t.Fatalf("sensors = %+v", summary.Sensors)
+	}
+	if len(summary.ControllerEvents) != 2 || summary.ControllerEvents[0].Code != "E01" || summary.ControllerEventCounts["power_restore"] != 1 {
+		t.Fatalf("controller events = %+v %v", summary.ControllerEvents, summary.ControllerEventCounts)
+	}
+}
+
+func TestAnalyzeDailyReportsMissingSensors(t *testing.T) {
+	root := t.TempDir()
+	for rel, content := range map[string]string{
+		"WLS1/2026-01-19.log":  "2026-01-19 00:00:01.000 rcv: (01)\n",
+		"GATE1/2026-01-18.log": "2026-01-18 00:00:01.000 rcv: (01)\n",
+	} {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	summary, err := AnalyzeDaily(context.Background(), Config{LogRoot: root, ExpectedSensors: []string{"wls1", "GATE1", "PUMP2"}}, "20260119", 100)
+	if err != nil {
+		t.Fatalf("AnalyzeDaily: %v", err)
+	}
+	status := map[string]string{}
+	for _, sensor := range summary.Sensors {
+		status[sensor.SensorID] = sensor.Result + "/" + sensor.Status
+	}
+	want := map[string]string{"WLS1": "/", "GATE1": "MISSING_SENSOR/ERROR", "PUMP2": "MISSING_SENSOR/ERROR"}
+	if len(status) != len(want) {
+		t.Fatalf("sensors = %v", status)
+	}
+	for id, w := range want {
+		if status[id] != w {
+			t.Fatalf("sensors = %v, want %v", status, want)
+		}
+	}
+	if len(summary.TopIssues) < 2 || summary.TopIssues[0].Type != "missing_sensor" || summary.TopIssues[0].SensorID != "GATE1" || summary.TopIssues[1].SensorID != "PUMP2" {
+		t.Fatalf("top issues = %+v", summary.TopIssues)
+	}
+}
+
+func TestAnalyzeLinesCountsBytesAndFrameRate(t *testing.T) {
+	lines := []string{
+		"2026-01-19 00:00:01.000 snd: STATUS",
+		"2026-01-19 00:00:01.200 rcv: (FA, 00, 01, 02, 03, 04, 05, 06, 07, 08, 76)",
+		"2026-01-19 01:59:59.000 snd: (01, 03)",
+		"2026-01-19 02:00:01.000 rcv: (01)",
+	}
+	metrics, _ := analyzeLines(lines, "2026-01-19", "WLS", Config{DuplicateRunThreshold: 3, PayloadFormat: PayloadHexCSV})
+	if metrics.SndBytes != len("STATUS")+2 || metrics.RcvBytes != 12 {
+		t.Fatalf("bytes snd=%d rcv=%d", metrics.SndBytes, metrics.RcvBytes)
+	}
+	if metrics.FramesPerHour != 2 {
+		t.Fatalf("frames per hour = %v", metrics.FramesPerHour)
+	}
+	if metrics.FramesByHour["00"] != 2 || metrics.FramesByHour["01"] != 1 || metrics.FramesByHour["02"] != 1 {
+		t.Fatalf("frames by hour = %v", metrics.FramesByHour)
+	}
+}
+
+func TestCommandCoverage(t *testing.T) {
+	metrics, _ := analyzeLines([]string{
+		"2026-01-19 00:00:01.000 snd: (01, 03, 00, 00)",
+		"2026-01-19 00:00:01.100 rcv: (01, 03, 02)",
+		"2026-01-19 00:00:02.000 snd: (01, 2B, 0E)",
+		"2026-01-19 00:00:03.000 snd: (01, 03, 00, 00)",
+		"2026-01-19 00:00:04.000 snd: (01, 2B, 0E)",
+		"2026-01-19 00:00:05.000 snd: (01, 03, 00, 00)",
+		"2026-01-19 00:00:05.100 rcv: (01, 03, 02)",
+	}, "2026-01-19", "GATE", Config{DuplicateRunThreshold: 3, PayloadFormat: PayloadHexCSV})
+
+	want := []CommandCoverage{
+		{Command: "01 03", Sent: 3, Answered: 2, Missing: 1, Coverage: CoverageIntermittent},
+		{Command: "01 2B", Sent: 2, Answered: 0, Missing: 2, Coverage: CoverageNeverAnswered},
+	}
+	if len(metrics.Commands) != len(want) {
+		t.Fatalf("commands = %+v", metrics.Commands)
+	}
+	for i := range want {
+		if metrics.Commands[i] != want[i] {
+			t.Fatalf("commands = %+v, want %+v", metrics.Commands, want)
+		}
+	}
+	issues := buildTopIssues([]SensorResult{{SensorID: "GATE1", Metrics: metrics}}, "")
+	if len(issues) != 1 || issues[0].Type != "unanswered_command" || issues[0].Command != "01 2B" || issues[0].Count != 2 {
+		t.Fatalf("unexpected issues %+v", issues)
+	}
+}
+
+func TestThresholdProfiles(t *testing.T) {
+	limit := func(n int) *int { return &n }
+	thresholds := Thresholds{
+		Default: Limits{ZeroData: limit(0), Duplicates: limit(5)},
+		Types:   map[string]Limits{"wls": {ZeroData: limit(100), Duplicates: limit(1000)}},
+		Sensors: map[string]Limits{"WLS2": {Duplicates: limit(10)}},
+	}
+	results := []SensorResult{
+		{SensorID: "TEMP1", SensorType: "TEMP", Metrics: Metrics{ZeroData: 1}},
+		{SensorID: "WLS1", SensorType: "WLS", Metrics: Metrics{ZeroData: 50, Duplicates: 500}},
+		{SensorID: "WLS2", SensorType: "WLS", Metrics: Metrics{ZeroData: 50, Duplicates: 500}},
+		{SensorID: "GATE1", SensorType: "GATE", Result: ResultMissingSensor, Status: StatusError},
+	}
+	for i := range results {
+		grade(&results[i], thresholds)
+	}
+	want := []string{"WARN zero_data", "OK ", "WARN duplicates", "ERROR "}
+	for i, r := range results {
+		if got := r.Status + " " + strings.Join(r.Exceeded, ","); got != want[i] {
+			t.Fatalf("%s graded %q, want %q", r.SensorID, got, want[i])
+		}
+	}
+
+	unconfigured := []SensorResult{{SensorID: "TEMP1", SensorType: "TEMP", Metrics: Metrics{ZeroData: 1}}}
+	grade(&unconfigured[0], Thresholds{})
+	if unconfigured[0].Status != "" {
+		t.Fatalf("expected no grading without thresholds, got %q", unconfigured[0].Status)
+	}
+}
+
+func TestAnalyzeDailyStreamsSensors(t *testing.T) {
+	root := t.TempDir()
+	for _, id := range []string{"GATE1", "WLS1"} {
+		path := filepath.Join(root, id, "2026-01-19.log")
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("2026-01-19 00:00:01.000 timeout\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	var stream bytes.Buffer
+	cfg := Config{SiteID: "siteA", DeviceID: "device01", LogRoot: root, ExpectedSensors: []string{"PUMP1"}, SensorStream: &stream}
+	summary, err := AnalyzeDaily(context.Background(), cfg, "20260119", 100)
+	if err != nil {
+		t.Fatalf("AnalyzeDaily: %v", err)
+	}
+	if len(summary.Sensors) != 0 || len(summary.TopIssues) != 3 {
+		t.Fatalf("expected streamed sensors and kept issues, got %+v", summary)
+	}
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(stream.String()), "\n") {
+		var got SensorLine
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		if got.Type != LineSensor || got.SiteID != "siteA" || got.Date != "20260119" {
+			t.Fatalf("unexpected line %s", line)
+		}
+		ids = append(ids, got.SensorID+":"+got.Status)
+	}
+	if strings.Join(ids, " ") != "GATE1: WLS1: PUMP1:ERROR" {
+		t.Fatalf("streamed %v", ids)
+	}
+}
+
+func TestExampleContext(t *testing.T) {
+	lines := []string{
+		"2026-01-19 00:00:01.000 snd: (01, 03)",
+		"2026-01-19 00:00:01.100 rcv: (01, 03, 02)",
+		"2026-01-19 00:00:02.000 snd: (01, 03)",
+		"2026-01-19 00:00:03.000 timeout",
+		"2026-01-19 00:00:04.000 snd: (01, 03)",
+		"2026-01-19 00:00:04.100 rcv: (00, 00)",
+		"2026-01-19 00:00:05.000 snd: (01, 03)",
+	}
+	_, examples := analyzeLines(lines, "2026-01-19", "GATE", Config{DuplicateRunThreshold: 3, PayloadFormat: PayloadHexCSV, ExampleContext: 1})
+	check := func(name string, got []string, want ...int) {
+		t.Helper()
+		var expected []string
+		for _, i := range want {
+			expected = append(expected, lines[i])
+		}
+		if strings.Join(got, "|") != strings.Join(expected, "|") {
+			t.Fatalf("%s context = %q, want %q", name, got, expected)
+		}
+	}
+	check("timeout", examples.TimeoutContext, 2, 3, 4)
+	check("no_response", examples.NoResponseContext, 1, 2, 3)
+	check("zero_data", examples.ZeroDataContext, 4, 5, 6)
+
+	_, without := analyzeLines(lines, "2026-01-19", "GATE", Config{DuplicateRunThreshold: 3, PayloadFormat: PayloadHexCSV})
+	if without.TimeoutContext != nil || without.NoResponseContext != nil {
+		t.Fatalf("expected no context by default, got %+v", without)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h, err := OpenHistory(filepath.Join(t.TempDir(), "history"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	days := []Summary{
+		{Date: "20260118", Sensors: []SensorResult{{SensorID: "WLS1"}, {SensorID: "GATE1"}}},
+		{Date: "20260119", Sensors: []SensorResult{{SensorID: "WLS1", Metrics: Metrics{Timeout: 3}}, {SensorID: "GATE1", Status: StatusOK}}},
+		{Date: "20260120", Sensors: []SensorResult{{SensorID: "GATE1", Result: ResultMissingSensor, Status: StatusError}}},
+	}
+	for _, s := range days {
+		if err := h.Append(s); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	history, err := h.GetSensorHistory("wls1", "20260119", "")
+	if err != nil {
+		t.Fatalf("sensor history: %v", err)
+	}
+	if len(history) != 1 || history[0].Date != "20260119" || history[0].Status != StatusWarn || history[0].Result.Metrics.Timeout != 3 {
+		t.Fatalf("unexpected history %+v", history)
+	}
+
+	calendar, err := h.GetStatusCalendar("", "")
+	if err != nil {
+		t.Fatalf("calendar: %v", err)
+	}
+	var got []string
+	for _, day := range calendar {
+		got = append(got, day.Date+":"+day.Status+":"+day.Sensors["GATE1"])
+	}
+	if strings.Join(got, " ") != "20260118:OK:OK 20260119:WARN:OK 20260120:ERROR:ERROR" {
+		t.Fatalf("calendar = %v", got)
+	}
+
+	cutoff, err := HistoryCutoff("20260120", 2)
+	if err != nil || cutoff != "20260119" {
+		t.Fatalf("cutoff = %q, %v", cutoff, err)
+	}
+	if removed, err := h.Prune(cutoff); err != nil || removed != 1 {
+		t.Fatalf("prune removed %d, %v", removed, err)
+	}
+	if _, ok, _ := h.Summary("20260118"); ok {
+		t.Fatalf("expected 20260118 pruned")
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	root := t.TempDir()
+	day := time.Date(2026, 1, 19, 0, 0, 0, 0, time.Local)
+	for rel, file := range map[string]struct {
+		size     int
+		modified time.Time
+	}{
+		"GATE1/2026-01-18.log":      {100, day.Add(-time.Hour)},
+		"GATE1/2026-01-19.log":      {40, day.Add(10 * time.Hour)},
+		"WLS1/2026/01/19/part1.log": {300, day.Add(23 * time.Hour)},
+		"ALARM/controller.log":      {5, day.AddDate(0, 0, -3)},
+		"README.txt":                {1, day.AddDate(0, 0, 1)},
+	} {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), file.size), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := os.Chtimes(path, file.modified, file.modified); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+
+	usage, err := diskUsage(root, day, day.AddDate(0, 0, 1), 0)
+	if err != nil {
+		t.Fatalf("disk usage: %v", err)
+	}
+	if usage.Bytes != 446 || usage.Files != 5 || usage.GrowthBytes != 340 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	var got []string
+	for _, dir := range usage.Dirs {
+		got = append(got, fmt.Sprintf("%s:%d:%d", dir.Dir, dir.Bytes, dir.GrowthBytes))
+	}
+	if strings.Join(got, " ") != "WLS1:300:300 GATE1:140:40 ALARM:5:0 .:1:0" {
+		t.Fatalf("dirs = %v", got)
+	}
+	if usage.LowSpace {
+		t.Fatalf("warned without disk_warn_days")
+	}
+	if usage.FreeBytes == nil {
+		return // no free space on this platform
+	}
+	if usage.DaysUntilFull == nil {
+		t.Fatalf("expected days until full, got %+v", usage)
+	}
+	usage, err = diskUsage(root, day, day.AddDate(0, 0, 1), int(*usage.DaysUntilFull)+1)
+	if err != nil || !usage.LowSpace {
+		t.Fatalf("expected low space warning, got %+v, %v", usage, err)
+	}
+}
+
+func TestSchedulesExcludeOffHours(t *testing.T) {
+	daylight, err := ParseSchedule([]string{"06:00-19:00"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cfg := Config{DuplicateRunThreshold: 3, Schedules: Schedules{"gate": daylight}}
+	metrics, _ := analyzeLines([]string{
+		"2026-01-19 02:00:00.000 timeout",
+		"2026-01-19 03:00:00.000 snd: STATUS",
+		"2026-01-19 10:00:00.000 timeout",
+		"2026-01-19 12:00:00.000 snd: STATUS",
+		"2026-01-19 20:00:00.000 snd: STATUS",
+	}, "2026-01-19", "GATE", cfg)
+	if metrics.Timeout != 1 || metrics.NoResponse != 1 || metrics.OffSchedule != 3 {
+		t.Fatalf("timeout %d, no_response %d, off_schedule %d", metrics.Timeout, metrics.NoResponse, metrics.OffSchedule)
+	}
+
+	if _, err := ParseSchedule([]string{"06:00"}); err == nil {
+		t.Fatalf("expected invalid window error")
+	}
+	night, _ := ParseSchedule([]string{"18:00-06:00"})
+	if !night.Covers(time.Date(2026, 1, 19, 23, 0, 0, 0, time.UTC)) || night.Covers(time.Date(2026, 1, 19, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("wrapped window coverage wrong")
+	}
+
+	day := time.Date(2026, 1, 19, 0, 0, 0, 0, time.Local)
+	c := newSensorCollector(Config{ExpectedSensors: []string{"GATE1", "WLS1"}, Schedules: Schedules{"GATE1": daylight}}, "20260119")
+	c.now = day.Add(5 * time.Hour)
+	if err := c.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	for _, result := range c.results {
+		if missing := result.Result == ResultMissingSensor; missing != (result.SensorID == "WLS1") {
+			t.Fatalf("before 06:00 %s result %q", result.SensorID, result.Result)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Coverage classes of a command type.
+const (
+	CoverageAnswered     = "answered"
+	CoverageIntermittent = "intermittent"
+	// CoverageNeverAnswered marks a command type no snd of which got a
+	// response, typically one the firmware stopped supporting.
+	CoverageNeverAnswered = "never_answered"
+)
+
+// CommandCoverage counts the snd frames of one command type and how many
+// of them were answered by a rcv before the next snd.
+type CommandCoverage struct {
+	Command  string `json:"command"`
+	Sent     int    `json:"sent"`
+	Answered int    `json:"answered"`
+	Missing  int    `json:"missing"`
+	Coverage string `json:"coverage"`
+}
+
+type commandCount struct {
+	sent, answered int
+}
+
+// commandType classifies a snd payload: the first two bytes (address and
+// function code for Modbus-style frames) when it decodes as bytes, else its
+// first word, e.g. STATUS.
+func commandType(payload string, format PayloadFormat) string {
+	if decoded, err := DecodeBytes(payload, format); err == nil && len(decoded) > 0 {
+		if len(decoded) > 2 {
+			decoded = decoded[:2]
+		}
+		parts := make([]string, len(decoded))
+		for i, b := range decoded {
+			parts[i] = fmt.Sprintf("%02X", b)
+		}
+		return strings.Join(parts, " ")
+	}
+	if fields := strings.Fields(payload); len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+	return ""
+}
+
+func countCommand(state SensorState, payload string, format PayloadFormat) SensorState {
+	if state.Commands == nil {
+		state.Commands = map[string]*commandCount{}
+	}
+	command := commandType(payload, format)
+	if state.Commands[command] == nil {
+		state.Commands[command] = &commandCount{}
+	}
+	state.Commands[command].sent++
+	state.PendingCommand = command
+	return state
+}
+
+func commandCoverage(state SensorState) []CommandCoverage {
+	var coverage []CommandCoverage
+	for command, count := range state.Commands {
+		c := CommandCoverage{Command: command, Sent: count.sent, Answered: count.answered, Missing: count.sent - count.answered}
+		switch {
+		case c.Answered == 0:
+			c.Coverage = CoverageNeverAnswered
+		case c.Missing > 0:
+			c.Coverage = CoverageIntermittent
+		default:
+			c.Coverage = CoverageAnswered
+		}
+		coverage = append(coverage, c)
+	}
+	sort.Slice(coverage, func(i, j int) bool { return coverage[i].Command < coverage[j].Command })
+	return coverage
+}
@@ -0,0 +1,127 @@
+package analyzer
+
+// maxContextBytes caps each captured context; lines past the cap are
+// dropped.
+const maxContextBytes = 4096
+
+// Context kinds, as captured by lineContext.
+const (
+	contextTimeout    = "timeout"
+	contextNoResponse = "no_response"
+	contextZeroData   = "zero_data"
+)
+
+// lineContext keeps the lines around the first occurrence of each issue:
+// the n lines before it, the line itself and the n lines after. A nil
+// lineContext captures nothing.
+type lineContext struct {
+	n        int
+	before   []string
+	captures map[string]*capture
+	// pending follows the latest snd until a rcv answers it; a second snd
+	// without one makes it the no_response context.
+	pending *capture
+}
+
+type capture struct {
+	lines     []string
+	size      int
+	remaining int
+}
+
+func newLineContext(n int) *lineContext {
+	if n <= 0 {
+		return nil
+	}
+	return &lineContext{n: n, captures: map[string]*capture{}}
+}
+
+func (c *capture) add(line string) {
+	if c.size+len(line) > maxContextBytes {
+		return
+	}
+	c.lines = append(c.lines, line)
+	c.size += len(line)
+}
+
+// begin feeds line to the captures still collecting lines after their
+// occurrence; call it before the line is analyzed.
+func (lc *lineContext) begin(line string) {
+	if lc == nil {
+		return
+	}
+	for _, c := range lc.captures {
+		if c.remaining > 0 {
+			c.add(line)
+			c.remaining--
+		}
+	}
+	if lc.pending != nil && lc.pending.remaining > 0 {
+		lc.pending.add(line)
+		lc.pending.remaining--
+	}
+}
+
+// end remembers line as context for later occurrences.
+func (lc *lineContext) end(line string) {
+	if lc == nil {
+		return
+	}
+	lc.before = append(lc.before, line)
+	if len(lc.before) > lc.n {
+		lc.before = lc.before[1:]
+	}
+}
+
+func (lc *lineContext) start(line string) *capture {
+	c := &capture{remaining: lc.n}
+	for _, prev := range lc.before {
+		c.add(prev)
+	}
+	c.add(line)
+	return c
+}
+
+// occurred starts the capture of kind at line unless it already has one.
+func (lc *lineContext) occurred(kind, line string) {
+	if lc == nil || lc.captures[kind] != nil {
+		return
+	}
+	lc.captures[kind] = lc.start(line)
+}
+
+// sent follows a snd line; unanswered tells whether the previous snd got no
+// rcv before it.
+func (lc *lineContext) sent(line string, unanswered bool) {
+	if lc == nil || lc.captures[contextNoResponse] != nil {
+		return
+	}
+	if unanswered && lc.pending != nil {
+		lc.captures[contextNoResponse] = lc.pending
+		lc.pending = nil
+		return
+	}
+	lc.pending = lc.start(line)
+}
+
+// answered drops the context of the snd a rcv answered.
+func (lc *lineContext) answered() {
+	if lc != nil {
+		lc.pending = nil
+	}
+}
+
+// finish makes a snd still unanswered at the end of the log the
+// no_response context if there is none yet.
+func (lc *lineContext) finish(unanswered bool) {
+	if lc != nil && unanswered && lc.pending != nil && lc.captures[contextNoResponse] == nil {
+		lc.captures[contextNoResponse] = lc.pending
+	}
+}
+
+func (lc *lineContext) lines(kind string) []string {
+	if lc == nil || lc.captures[kind] == nil {
+		return nil
+	}
+	return lc.captures[kind].lines
+}
@@ -0,0 +1,121 @@
+package analyzer
+
+import (
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"workfield/internal/health"
+)
+
+// DiskUsage reports how much space the log root takes and how fast it
+// grows, so the nightly run warns before the partition fills and the
+// sensors stop recording.
+type DiskUsage struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+	// GrowthBytes is the size of the files last written on the analyzed
+	// day, the estimate of what the log root grows by per day.
+	GrowthBytes int64 `json:"growth_bytes"`
+	// FreeBytes and UsedPct describe the partition holding the log root,
+	// when the platform can tell.
+	FreeBytes *uint64  `json:"free_bytes,omitempty"`
+	UsedPct   *float64 `json:"used_pct,omitempty"`
+	// DaysUntilFull is FreeBytes / GrowthBytes.
+	DaysUntilFull *float64 `json:"days_until_full,omitempty"`
+	// LowSpace is set when DaysUntilFull is below Config.DiskWarnDays.
+	LowSpace bool `json:"low_space,omitempty"`
+	// Dirs are the log root's directories (one per sensor, plus ALARM,
+	// PING and the like), largest first.
+	Dirs []DirUsage `json:"dirs"`
+}
+
+// DirUsage is the size of one directory of the log root.
+type DirUsage struct {
+	Dir         string `json:"dir"`
+	Bytes       int64  `json:"bytes"`
+	Files       int    `json:"files"`
+	GrowthBytes int64  `json:"growth_bytes"`
+}
+
+// diskUsage walks the log root, counting the files under each top-level
+// directory and the ones modified within [start, end).
+func diskUsage(root string, start, end time.Time, warnDays int) (*DiskUsage, error) {
+	usage := &DiskUsage{}
+	dirs := map[string]*DirUsage{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// A directory removed or unreadable mid-walk only leaves it out.
+			slog.Debug("disk usage: skipping", "path", path, "err", err)
+			return nil
+		}
+		if entry.IsDir() || !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := "."
+		if parent := filepath.Dir(rel); parent != "." {
+			name = topDir(parent)
+		}
+		dir := dirs[name]
+		if dir == nil {
+			dir = &DirUsage{Dir: name}
+			dirs[name] = dir
+		}
+		size := info.Size()
+		dir.Bytes += size
+		dir.Files++
+		if modified := info.ModTime(); !modified.Before(start) && modified.Before(end) {
+			dir.GrowthBytes += size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		usage.Bytes += dir.Bytes
+		usage.Files += dir.Files
+		usage.GrowthBytes += dir.GrowthBytes
+		usage.Dirs = append(usage.Dirs, *dir)
+	}
+	sort.Slice(usage.Dirs, func(i, j int) bool {
+		if usage.Dirs[i].Bytes != usage.Dirs[j].Bytes {
+			return usage.Dirs[i].Bytes > usage.Dirs[j].Bytes
+		}
+		return usage.Dirs[i].Dir < usage.Dirs[j].Dir
+	})
+
+	used, free, err := health.DiskUsage(root)
+	if err != nil {
+		slog.Debug("disk usage: free space unknown", "path", root, "err", err)
+		return usage, nil
+	}
+	usage.FreeBytes, usage.UsedPct = &free, &used
+	if usage.GrowthBytes > 0 {
+		days := float64(free) / float64(usage.GrowthBytes)
+		usage.DaysUntilFull = &days
+		usage.LowSpace = warnDays > 0 && days < float64(warnDays)
+	}
+	return usage, nil
+}
+
+// topDir returns the first element of a relative directory path.
+func topDir(rel string) string {
+	for {
+		parent := filepath.Dir(rel)
+		if parent == "." {
+			return rel
+		}
+		rel = parent
+	}
+}
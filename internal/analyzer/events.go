@@ -0,0 +1,70 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Event types of the normalized event stream.
+const (
+	EventSnd        = "snd"
+	EventRcv        = "rcv"
+	EventTimeout    = "timeout"
+	EventParseError = "parse_error"
+	EventZeroData   = "zero_data"
+	EventDuplicate  = "duplicate"
+)
+
+// Event is one line of the normalized event stream written when
+// Config.Events is set. Value is the decoded reading of a rcv line (the WLS
+// level in cm, or the decoder plugin's values) when there is one; LatencyMS
+// is the time since the preceding snd line. Events come sensor by sensor in
+// log order, not globally sorted by time.
+type Event struct {
+	Timestamp  string   `json:"timestamp,omitempty"`
+	SensorID   string   `json:"sensor_id"`
+	SensorType string   `json:"sensor_type"`
+	Type       string   `json:"type"`
+	Payload    string   `json:"payload,omitempty"`
+	Value      any      `json:"value,omitempty"`
+	LatencyMS  *float64 `json:"latency_ms,omitempty"`
+}
+
+// emit queues an event on state when the stream is enabled.
+func emit(cfg Config, state SensorState, sensorType string, lineTime time.Time, eventType, payload string) SensorState {
+	if cfg.Events == nil {
+		return state
+	}
+	event := Event{SensorID: state.SensorID, SensorType: sensorType, Type: eventType, Payload: payload}
+	if !lineTime.IsZero() {
+		event.Timestamp = lineTime.Format(time.RFC3339Nano)
+	}
+	state.Events = append(state.Events, event)
+	return state
+}
+
+// flushEvents writes the queued events and empties the queue.
+func flushEvents(enc *json.Encoder, state SensorState) (SensorState, error) {
+	for _, event := range state.Events {
+		if err := enc.Encode(event); err != nil {
+			return state, err
+		}
+	}
+	state.Events = state.Events[:0]
+	return state, nil
+}
+
+func sndPayload(line string) string {
+	idx := strings.Index(strings.ToLower(line), "snd:")
+	if idx == -1 {
+		return ""
+	}
+	return strings.TrimSpace(line[idx+4:])
+}
+
+// latencyMS is the milliseconds between two times.
+func latencyMS(from, to time.Time) *float64 {
+	ms := float64(to.Sub(from).Microseconds()) / 1000
+	return &ms
+}
@@ -0,0 +1,133 @@
+package analyzer
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type fileSelectionNotes struct {
+	usedFallback bool
+}
+
+// datedName finds a date (2026-01-19, 2026_01_19 or 20260119) in a file
+// name.
+var datedName = regexp.MustCompile(`(19|20)\d{2}[-_]?\d{2}[-_]?\d{2}`)
+
+// selectFiles picks the log files of datePrefix (YYYY-MM-DD) under a sensor
+// directory. Besides files directly in dir, nested layouts such as
+// GATE1/2026/01/19.log or GATE1/2026-01/gate.log are followed: directories
+// named like a year, month or day (or a run of them) that are not on the
+// way to the date are skipped, and inside a directory naming at least the
+// date's month a file matches unless its name carries another date. Lines
+// of other days are dropped later by the line filter. With fallback and no
+// match, the most recently modified file of the whole tree is used.
+func selectFiles(dir string, datePrefix string, fallback bool) ([]string, fileSelectionNotes, error) {
+	compact := strings.ReplaceAll(datePrefix, "-", "")
+	var matched []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		digits, onDate := datePath(dir, path, compact, entry.IsDir())
+		if entry.IsDir() {
+			if !onDate {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := entry.Name()
+		if strings.Contains(name, datePrefix) || (digits != "" && strings.Contains(name, compact)) ||
+			(len(digits) >= 6 && !datedName.MatchString(name)) {
+			matched = append(matched, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fileSelectionNotes{}, err
+	}
+	sort.Strings(matched)
+	if len(matched) > 0 {
+		return matched, fileSelectionNotes{}, nil
+	}
+	if !fallback {
+		return nil, fileSelectionNotes{}, nil
+	}
+	files, err := allFiles(dir)
+	if err != nil || len(files) == 0 {
+		return nil, fileSelectionNotes{}, err
+	}
+	latest, err := latestFile(files)
+	if err != nil {
+		return nil, fileSelectionNotes{}, err
+	}
+	return []string{latest}, fileSelectionNotes{usedFallback: true}, nil
+}
+
+// datePath returns the date digits named by the directories between dir
+// and path (e.g. "202601" for 2026/01/x.log) and whether they are on the
+// way to the compact (YYYYMMDD) date. Directories whose name is not made
+// of digits and separators add nothing and never leave the date's path.
+func datePath(dir, path, compact string, isDir bool) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if !isDir {
+		parts = parts[:len(parts)-1]
+	}
+	var digits strings.Builder
+	for _, part := range parts {
+		stripped := strings.NewReplacer("-", "", "_", "").Replace(part)
+		if stripped == "" || strings.Trim(stripped, "0123456789") != "" {
+			continue
+		}
+		digits.WriteString(stripped)
+		if !strings.HasPrefix(compact, digits.String()) {
+			return digits.String(), false
+		}
+	}
+	return digits.String(), true
+}
+
+func allFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func latestFile(files []string) (string, error) {
+	var latest string
+	var latestTime time.Time
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest = path
+			latestTime = info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", errors.New("no files available")
+	}
+	return latest, nil
+}
@@ -0,0 +1,192 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"workfield/internal/timeparse"
+)
+
+// History keeps one summary per day as dir/YYYYMMDD.json, so trends and
+// day-to-day differences can be read without analyzing old logs again.
+// Analyzing a day again replaces its summary.
+type History struct {
+	dir string
+}
+
+// OpenHistory opens (and creates) the history directory.
+func OpenHistory(dir string) (*History, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &History{dir: dir}, nil
+}
+
+// Append stores the summary of its day atomically.
+func (h *History) Append(s Summary) error {
+	if _, err := timeparse.ParseDate(s.Date); err != nil {
+		return err
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(h.dir, s.Date+".json")
+	tmp := path + ".partial"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Prune removes the summaries of days before the given day (YYYYMMDD).
+func (h *History) Prune(before string) (int, error) {
+	days, err := h.days("", "")
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, day := range days {
+		if day >= before {
+			break
+		}
+		if err := os.Remove(filepath.Join(h.dir, day+".json")); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// days lists the stored days between from and to (YYYYMMDD, inclusive;
+// empty is open), in order.
+func (h *History) days(from, to string) ([]string, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, entry := range entries {
+		day, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := timeparse.ParseDate(day); err != nil {
+			continue
+		}
+		if (from != "" && day < from) || (to != "" && day > to) {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// Summary returns the stored summary of day; ok is false when there is none.
+func (h *History) Summary(day string) (Summary, bool, error) {
+	data, err := os.ReadFile(filepath.Join(h.dir, day+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return Summary{}, false, nil
+	}
+	if err != nil {
+		return Summary{}, false, err
+	}
+	var s Summary
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Summary{}, false, err
+	}
+	return s, true, nil
+}
+
+// SensorDay is one day of one sensor's history.
+type SensorDay struct {
+	Date   string       `json:"date"`
+	Status string       `json:"status"`
+	Result SensorResult `json:"result"`
+}
+
+// GetSensorHistory returns the days between from and to on which the
+// sensor has a result, in order.
+func (h *History) GetSensorHistory(sensorID, from, to string) ([]SensorDay, error) {
+	days, err := h.days(from, to)
+	if err != nil {
+		return nil, err
+	}
+	var history []SensorDay
+	for _, day := range days {
+		s, _, err := h.Summary(day)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range s.Sensors {
+			if strings.EqualFold(result.SensorID, sensorID) {
+				history = append(history, SensorDay{Date: day, Status: EffectiveStatus(result), Result: result})
+				break
+			}
+		}
+	}
+	return history, nil
+}
+
+// CalendarDay is the status of every sensor on one day; Status is the worst
+// of them.
+type CalendarDay struct {
+	Date    string            `json:"date"`
+	Status  string            `json:"status"`
+	Sensors map[string]string `json:"sensors"`
+}
+
+// GetStatusCalendar returns one CalendarDay per stored day between from
+// and to, in order.
+func (h *History) GetStatusCalendar(from, to string) ([]CalendarDay, error) {
+	days, err := h.days(from, to)
+	if err != nil {
+		return nil, err
+	}
+	var calendar []CalendarDay
+	for _, day := range days {
+		s, _, err := h.Summary(day)
+		if err != nil {
+			return nil, err
+		}
+		entry := CalendarDay{Date: day, Status: StatusOK, Sensors: map[string]string{}}
+		for _, result := range s.Sensors {
+			status := EffectiveStatus(result)
+			entry.Sensors[result.SensorID] = status
+			if statusRank[status] > statusRank[entry.Status] {
+				entry.Status = status
+			}
+		}
+		calendar = append(calendar, entry)
+	}
+	return calendar, nil
+}
+
+var statusRank = map[string]int{StatusOK: 0, StatusWarn: 1, StatusError: 2}
+
+// EffectiveStatus is the sensor's graded status; a sensor analyzed without
+// thresholds is WARN when it has any issue and OK otherwise.
+func EffectiveStatus(r SensorResult) string {
+	if r.Status != "" {
+		return r.Status
+	}
+	if len(sensorIssues(r, "")) > 0 {
+		return StatusWarn
+	}
+	return StatusOK
+}
+
+// HistoryCutoff is the first day (YYYYMMDD) kept when keepDays days up to
+// day are kept.
+func HistoryCutoff(day string, keepDays int) (string, error) {
+	t, err := timeparse.ParseDate(day)
+	if err != nil {
+		return "", err
+	}
+	return t.AddDate(0, 0, -(keepDays - 1)).Format(timeparse.DateLayout), nil
+}
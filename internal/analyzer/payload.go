@@ -0,0 +1,103 @@
+package analyzer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PayloadFormat selects how the bytes after "rcv:" are decoded.
+type PayloadFormat string
+
+const (
+	// PayloadAuto keeps the historical guess: a token is hex when it contains
+	// a-f or is exactly two characters, decimal otherwise. "12" therefore
+	// decodes as 0x12; pick an explicit format when the device logs decimal.
+	PayloadAuto      PayloadFormat = "auto"
+	PayloadHexCSV    PayloadFormat = "hex-csv"
+	PayloadDecCSV    PayloadFormat = "dec-csv"
+	PayloadHexString PayloadFormat = "hexstring"
+	PayloadBase64    PayloadFormat = "base64"
+)
+
+var payloadFormats = []PayloadFormat{PayloadAuto, PayloadHexCSV, PayloadDecCSV, PayloadHexString, PayloadBase64}
+
+// ParsePayloadFormat accepts the config spelling; empty means auto.
+func ParsePayloadFormat(value string) (PayloadFormat, error) {
+	if value == "" {
+		return PayloadAuto, nil
+	}
+	for _, format := range payloadFormats {
+		if strings.EqualFold(value, string(format)) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown payload format %q", value)
+}
+
+// decodePayload turns a logged payload such as "(FA, FF, 07)" into bytes.
+// Every format except auto is strict: one bad token fails the whole payload.
+func DecodeBytes(payload string, format PayloadFormat) ([]byte, error) {
+	clean := strings.TrimSpace(strings.Trim(payload, "()[]{} "))
+	if clean == "" {
+		return nil, fmt.Errorf("empty payload")
+	}
+	switch format {
+	case "", PayloadAuto:
+		bytes, ok := parsePayloadBytes(payload)
+		if !ok {
+			return nil, fmt.Errorf("cannot decode payload %q", payload)
+		}
+		return bytes, nil
+	case PayloadHexCSV:
+		return decodeTokens(clean, func(token string) (uint64, error) {
+			token = strings.TrimPrefix(strings.ToLower(token), "0x")
+			if len(token) == 0 || len(token) > 2 {
+				return 0, fmt.Errorf("invalid hex byte %q", token)
+			}
+			return strconv.ParseUint(token, 16, 8)
+		})
+	case PayloadDecCSV:
+		return decodeTokens(clean, func(token string) (uint64, error) {
+			return strconv.ParseUint(token, 10, 8)
+		})
+	case PayloadHexString:
+		clean = strings.TrimPrefix(strings.ToLower(clean), "0x")
+		bytes, err := hex.DecodeString(clean)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex string %q: %w", clean, err)
+		}
+		return bytes, nil
+	case PayloadBase64:
+		bytes, err := base64.StdEncoding.DecodeString(clean)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 %q: %w", clean, err)
+		}
+		if len(bytes) == 0 {
+			return nil, fmt.Errorf("empty payload")
+		}
+		return bytes, nil
+	default:
+		return nil, fmt.Errorf("unknown payload format %q", format)
+	}
+}
+
+func decodeTokens(clean string, parse func(string) (uint64, error)) ([]byte, error) {
+	parts := strings.FieldsFunc(clean, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("empty payload")
+	}
+	bytes := make([]byte, 0, len(parts))
+	for _, part := range parts {
+		value, err := parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte %q: %w", part, err)
+		}
+		bytes = append(bytes, byte(value))
+	}
+	return bytes, nil
+}
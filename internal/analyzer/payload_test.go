@@ -0,0 +1,80 @@
+package analyzer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodePayloadFormats(t *testing.T) {
+	cases := []struct {
+		format  PayloadFormat
+		payload string
+		want    []byte
+	}{
+		{PayloadAuto, "(FA, 12, 7)", []byte{0xFA, 0x12, 7}},
+		{PayloadHexCSV, "(FA, 12, 7)", []byte{0xFA, 0x12, 0x07}},
+		{PayloadHexCSV, "[0xfa 0x76]", []byte{0xFA, 0x76}},
+		{PayloadDecCSV, "(250, 12, 7)", []byte{250, 12, 7}},
+		{PayloadHexString, "FAFF0715", []byte{0xFA, 0xFF, 0x07, 0x15}},
+		{PayloadBase64, "+v8HFQ==", []byte{0xFA, 0xFF, 0x07, 0x15}},
+	}
+	for _, tc := range cases {
+		got, err := DecodeBytes(tc.payload, tc.format)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tc.format, tc.payload, err)
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Fatalf("%s %q: expected % X, got % X", tc.format, tc.payload, tc.want, got)
+		}
+	}
+}
+
+func TestDecodePayloadStrict(t *testing.T) {
+	cases := []struct {
+		format  PayloadFormat
+		payload string
+	}{
+		{PayloadHexCSV, "(FA, 123)"},
+		{PayloadHexCSV, "(FA, ZZ)"},
+		{PayloadDecCSV, "(FA, 12)"},
+		{PayloadDecCSV, "(256)"},
+		{PayloadHexString, "FAF"},
+		{PayloadBase64, "not base64!"},
+		{PayloadHexCSV, "()"},
+	}
+	for _, tc := range cases {
+		if _, err := DecodeBytes(tc.payload, tc.format); err == nil {
+			t.Fatalf("%s %q: expected error", tc.format, tc.payload)
+		}
+	}
+}
+
+func TestParsePayloadFormat(t *testing.T) {
+	if format, err := ParsePayloadFormat(""); err != nil || format != PayloadAuto {
+		t.Fatalf("expected auto for empty, got %q %v", format, err)
+	}
+	if format, err := ParsePayloadFormat("HEX-CSV"); err != nil || format != PayloadHexCSV {
+		t.Fatalf("expected hex-csv, got %q %v", format, err)
+	}
+	if _, err := ParsePayloadFormat("octal"); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
+
+func TestDecimalWLSFrameNeedsDecFormat(t *testing.T) {
+	lines := []string{
+		"2026-01-19 00:00:01.000 rcv: (250, 255, 7, 21, 0, 12, 221, 221, 255, 0, 118)",
+	}
+	metrics, _ := analyzeLines(lines, "2026-01-19", "WLS", Config{DuplicateRunThreshold: 3, PayloadFormat: PayloadDecCSV})
+	if metrics.WLSLastValueCm == nil || *metrics.WLSLastValueCm != 12 {
+		t.Fatalf("expected 12cm with dec-csv, got %+v", metrics.WLSLastValueCm)
+	}
+	if metrics.ParseErrors != 0 {
+		t.Fatalf("expected no parse errors, got %d", metrics.ParseErrors)
+	}
+
+	metrics, examples := analyzeLines(lines, "2026-01-19", "WLS", Config{DuplicateRunThreshold: 3, PayloadFormat: PayloadHexCSV})
+	if metrics.ParseErrors != 1 || examples.FirstParseErrorLine == "" {
+		t.Fatalf("expected one parse error with hex-csv, got %d", metrics.ParseErrors)
+	}
+}
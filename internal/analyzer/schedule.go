@@ -0,0 +1,103 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily span of local time, as offsets from midnight. End
+// before Start wraps past midnight (18:00-06:00).
+type Window struct {
+	Start, End time.Duration
+}
+
+// Schedule is when a sensor is expected to report, e.g. a solar-powered
+// gate only in daylight. No windows means all day.
+type Schedule []Window
+
+// ParseSchedule reads windows written as "HH:MM-HH:MM".
+func ParseSchedule(specs []string) (Schedule, error) {
+	var s Schedule
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(strings.ReplaceAll(spec, " ", ""), "-")
+		if !ok {
+			return nil, fmt.Errorf("schedule window %q: expected HH:MM-HH:MM", spec)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("schedule window %q: %w", spec, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("schedule window %q: %w", spec, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("schedule window %q is empty", spec)
+		}
+		s = append(s, Window{Start: start, End: end})
+	}
+	return s, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Covers reports whether the wall clock of t falls inside a window.
+func (s Schedule) Covers(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	y, m, d := t.Date()
+	offset := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	for _, w := range s {
+		if w.Start < w.End {
+			if offset >= w.Start && offset < w.End {
+				return true
+			}
+		} else if offset >= w.Start || offset < w.End {
+			return true
+		}
+	}
+	return false
+}
+
+// openedBy reports whether a window of the day starting at day had opened
+// by now; a sensor is not missing before it was due to report.
+func (s Schedule) openedBy(day, now time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, w := range s {
+		if w.End < w.Start || !day.Add(w.Start).After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Schedules are the reporting schedules by sensor ID or sensor type, looked
+// up case-insensitively; a sensor ID entry wins over its type's.
+type Schedules map[string]Schedule
+
+// For returns the schedule of a sensor, empty when it has none.
+func (s Schedules) For(sensorID, sensorType string) Schedule {
+	var byType Schedule
+	for key, schedule := range s {
+		if strings.EqualFold(key, sensorID) {
+			return schedule
+		}
+		if strings.EqualFold(key, sensorType) {
+			byType = schedule
+		}
+	}
+	return byType
+}
@@ -0,0 +1,96 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LineSensor is the type of the JSON lines Config.SensorStream receives;
+// LineSummary is the type callers give the summary line that follows them.
+const (
+	LineSensor  = "sensor"
+	LineSummary = "summary"
+)
+
+// SensorLine is one sensor result on Config.SensorStream, with the device
+// and day it belongs to so each line stands on its own.
+type SensorLine struct {
+	Type     string `json:"type"`
+	SiteID   string `json:"site_id"`
+	DeviceID string `json:"device_id"`
+	Date     string `json:"date"`
+	SensorResult
+}
+
+// sensorCollector finishes each sensor result as it comes: it marks
+// expected sensors without lines missing, grades the result and keeps its
+// issues, then streams or keeps the result.
+type sensorCollector struct {
+	cfg      Config
+	date     string
+	now      time.Time
+	expected map[string]string
+	seen     map[string]bool
+	stream   *json.Encoder
+	results  []SensorResult
+	issues   []TopIssue
+}
+
+func newSensorCollector(cfg Config, date string) *sensorCollector {
+	c := &sensorCollector{cfg: cfg, date: date, now: time.Now(), expected: map[string]string{}, seen: map[string]bool{}}
+	for _, id := range cfg.ExpectedSensors {
+		if key := strings.ToUpper(id); id != "" && c.expected[key] == "" {
+			c.expected[key] = id
+		}
+	}
+	if cfg.SensorStream != nil {
+		c.stream = json.NewEncoder(cfg.SensorStream)
+	}
+	return c
+}
+
+func (c *sensorCollector) add(result SensorResult) error {
+	key := strings.ToUpper(result.SensorID)
+	c.seen[key] = true
+	if _, ok := c.expected[key]; ok && result.Metrics.Lines == 0 && c.due(result) {
+		result.Result, result.Status = ResultMissingSensor, StatusError
+	}
+	grade(&result, c.cfg.Thresholds)
+	c.issues = append(c.issues, sensorIssues(result, c.cfg.Language)...)
+	if c.stream != nil {
+		return c.stream.Encode(SensorLine{Type: LineSensor, SiteID: c.cfg.SiteID, DeviceID: c.cfg.DeviceID, Date: c.date, SensorResult: result})
+	}
+	c.results = append(c.results, result)
+	return nil
+}
+
+// due reports whether the sensor's schedule had it report by now on the
+// analyzed day, so analyzing today early does not call a daylight-only
+// sensor missing.
+func (c *sensorCollector) due(result SensorResult) bool {
+	day, err := c.cfg.timestamps().ParseDate(c.date)
+	if err != nil {
+		return true
+	}
+	return c.cfg.Schedules.For(result.SensorID, result.SensorType).openedBy(day, c.now)
+}
+
+// finish adds an empty result for every expected sensor that has no
+// directory at all.
+func (c *sensorCollector) finish() error {
+	var missing []string
+	for key, id := range c.expected {
+		if !c.seen[key] {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	for _, id := range missing {
+		if err := c.add(SensorResult{SensorID: id, SensorType: sensorTypeFromID(id)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
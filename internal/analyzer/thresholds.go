@@ -0,0 +1,108 @@
+package analyzer
+
+import "strings"
+
+// StatusOK and StatusWarn grade a sensor against its threshold limits.
+const (
+	StatusOK   = "OK"
+	StatusWarn = "WARN"
+)
+
+// Limits are the highest daily counts a sensor may reach before it is
+// graded WARN; nil means no limit.
+type Limits struct {
+	Timeout     *int `json:"timeout,omitempty"`
+	NoResponse  *int `json:"no_response,omitempty"`
+	ZeroData    *int `json:"zero_data,omitempty"`
+	Duplicates  *int `json:"duplicates,omitempty"`
+	ParseErrors *int `json:"parse_errors,omitempty"`
+}
+
+// merge returns l with the limits set in over replacing its own.
+func (l Limits) merge(over Limits) Limits {
+	for _, pair := range []struct{ dst, src **int }{
+		{&l.Timeout, &over.Timeout},
+		{&l.NoResponse, &over.NoResponse},
+		{&l.ZeroData, &over.ZeroData},
+		{&l.Duplicates, &over.Duplicates},
+		{&l.ParseErrors, &over.ParseErrors},
+	} {
+		if *pair.src != nil {
+			*pair.dst = *pair.src
+		}
+	}
+	return l
+}
+
+// Exceeded lists the metrics above their limit, by metrics JSON name.
+func (l Limits) Exceeded(m Metrics) []string {
+	var exceeded []string
+	for _, check := range []struct {
+		name  string
+		limit *int
+		count int
+	}{
+		{"timeout", l.Timeout, m.Timeout},
+		{"no_response", l.NoResponse, m.NoResponse},
+		{"zero_data", l.ZeroData, m.ZeroData},
+		{"duplicates", l.Duplicates, m.Duplicates},
+		{"parse_errors", l.ParseErrors, m.ParseErrors},
+	} {
+		if check.limit != nil && check.count > *check.limit {
+			exceeded = append(exceeded, check.name)
+		}
+	}
+	return exceeded
+}
+
+// Thresholds grade sensors: Default applies to every sensor, Types
+// replaces limits per sensor type (GATE, WLS, ...) and Sensors per sensor
+// ID, each only for the limits it sets. Keys match case-insensitively.
+type Thresholds struct {
+	Default Limits
+	Types   map[string]Limits
+	Sensors map[string]Limits
+}
+
+// IsZero reports whether no profile is configured, in which case sensors
+// are not graded.
+func (t Thresholds) IsZero() bool {
+	return t.Default == (Limits{}) && len(t.Types) == 0 && len(t.Sensors) == 0
+}
+
+// For returns the limits of one sensor.
+func (t Thresholds) For(sensorID, sensorType string) Limits {
+	limits := t.Default
+	if over, ok := lookupFold(t.Types, sensorType); ok {
+		limits = limits.merge(over)
+	}
+	if over, ok := lookupFold(t.Sensors, sensorID); ok {
+		limits = limits.merge(over)
+	}
+	return limits
+}
+
+func lookupFold(m map[string]Limits, key string) (Limits, bool) {
+	if limits, ok := m[key]; ok {
+		return limits, true
+	}
+	for k, limits := range m {
+		if strings.EqualFold(k, key) {
+			return limits, true
+		}
+	}
+	return Limits{}, false
+}
+
+// grade sets the status of a sensor that is not already in error from its
+// threshold limits.
+func grade(r *SensorResult, thresholds Thresholds) {
+	if thresholds.IsZero() || r.Status == StatusError {
+		return
+	}
+	r.Exceeded = thresholds.For(r.SensorID, r.SensorType).Exceeded(r.Metrics)
+	r.Status = StatusOK
+	if len(r.Exceeded) > 0 {
+		r.Status = StatusWarn
+	}
+}
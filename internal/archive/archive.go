@@ -0,0 +1,319 @@
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Limits bounds what an archive may expand to. Zero values disable a limit.
+// Sizes are enforced on the bytes actually read, not on the zip headers.
+type Limits struct {
+	MaxFiles     int
+	MaxFileSize  int64
+	MaxTotalSize int64
+}
+
+var DefaultLimits = Limits{
+	MaxFiles:     100000,
+	MaxFileSize:  2 << 30,
+	MaxTotalSize: 8 << 30,
+}
+
+var (
+	ErrLimitExceeded = errors.New("archive limit exceeded")
+	// ErrBadArchive covers archives that cannot be read safely: corrupt zips,
+	// entries escaping the extraction root and non-regular entries.
+	ErrBadArchive = errors.New("bad archive")
+)
+
+// CreateZip writes a zip at zipPath containing the given entries, which are
+// paths relative to root. Directories are added recursively.
+func CreateZip(zipPath, root string, names []string) (err error) {
+	file, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	writer := zip.NewWriter(file)
+	for _, name := range names {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		info, err := os.Stat(full)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = AddDir(writer, full, filepath.ToSlash(name))
+		} else {
+			err = AddFile(writer, full, filepath.ToSlash(name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func AddFile(writer *zip.Writer, src, name string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	dst, err := writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, file)
+	return err
+}
+
+// AddDir adds every regular file below dir under prefix, in lexical order.
+func AddDir(writer *zip.Writer, dir, prefix string) error {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, p := range files {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if err := AddFile(writer, p, path.Join(prefix, filepath.ToSlash(rel))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader gives access to archive entries with limits applied: zip entries
+// are streamed, and a tar archive, which has no index, is unpacked to a
+// temporary directory that Close removes.
+type Reader struct {
+	zip    *zip.ReadCloser
+	dir    string
+	names  []string
+	limits Limits
+	total  int64
+}
+
+// OpenReader opens the archive at archivePath, in any supported format.
+func OpenReader(archivePath string, limits Limits) (*Reader, error) {
+	format, err := Detect(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if format != FormatZip {
+		dir, err := os.MkdirTemp("", "archive-")
+		if err != nil {
+			return nil, err
+		}
+		names, err := extractTar(archivePath, dir, format, limits)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		return &Reader{dir: dir, names: names, limits: limits}, nil
+	}
+	rc, err := zip.OpenReader(archivePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrBadArchive, err)
+	}
+	if limits.MaxFiles > 0 && len(rc.File) > limits.MaxFiles {
+		rc.Close()
+		return nil, fmt.Errorf("%w: %d entries", ErrLimitExceeded, len(rc.File))
+	}
+	for _, file := range rc.File {
+		if _, err := SafePath(file.Name); err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(rc.File))
+	for _, file := range rc.File {
+		if !strings.HasSuffix(file.Name, "/") {
+			names = append(names, file.Name)
+		}
+	}
+	return &Reader{zip: rc, names: names, limits: limits}, nil
+}
+
+// Files returns the zip entries; it is empty for a tar archive.
+func (r *Reader) Files() []*zip.File {
+	if r.zip == nil {
+		return nil
+	}
+	return r.zip.File
+}
+
+// Names lists the file entries, in archive order.
+func (r *Reader) Names() []string {
+	return r.names
+}
+
+// Open returns a reader for the named entry, or fs.ErrNotExist.
+func (r *Reader) Open(name string) (io.ReadCloser, error) {
+	if r.zip == nil {
+		for _, n := range r.names {
+			if n == name {
+				return os.Open(filepath.Join(r.dir, filepath.FromSlash(name)))
+			}
+		}
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	for _, file := range r.zip.File {
+		if file.Name == name {
+			return r.OpenFile(file)
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+}
+
+func (r *Reader) OpenFile(file *zip.File) (io.ReadCloser, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrBadArchive, file.Name, err)
+	}
+	return &limitedReader{ReadCloser: src, reader: r, name: file.Name}, nil
+}
+
+func (r *Reader) Close() error {
+	if r.zip == nil {
+		return os.RemoveAll(r.dir)
+	}
+	return r.zip.Close()
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	reader *Reader
+	name   string
+	read   int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.read += int64(n)
+	l.reader.total += int64(n)
+	if max := l.reader.limits.MaxFileSize; max > 0 && l.read > max {
+		return n, fmt.Errorf("%w: %s larger than %d bytes", ErrLimitExceeded, l.name, max)
+	}
+	if max := l.reader.limits.MaxTotalSize; max > 0 && l.reader.total > max {
+		return n, fmt.Errorf("%w: archive larger than %d bytes", ErrLimitExceeded, max)
+	}
+	if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) {
+		return n, fmt.Errorf("%w: %s: %w", ErrBadArchive, l.name, err)
+	}
+	return n, err
+}
+
+// Extract unpacks the archive at archivePath, a zip, tar.gz or tar.zst told
+// apart by its content, into dest, rejecting entries that would escape dest
+// and enforcing limits.
+func Extract(archivePath, dest string, limits Limits) error {
+	format, err := Detect(archivePath)
+	if err != nil {
+		return err
+	}
+	if format != FormatZip {
+		_, err := extractTar(archivePath, dest, format, limits)
+		return err
+	}
+	reader, err := OpenReader(archivePath, limits)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.Files() {
+		rel, err := SafePath(file.Name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			return fmt.Errorf("%w: unsupported zip entry type: %s", ErrBadArchive, file.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := extractFile(reader, file, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(reader *Reader, file *zip.File, target string) error {
+	src, err := reader.OpenFile(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// SafePath converts a zip entry name into a relative OS path, rejecting
+// absolute names and names that climb out of the extraction root.
+func SafePath(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if slashed == "" || strings.HasPrefix(slashed, "/") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: invalid zip path: %s", ErrBadArchive, name)
+	}
+	clean := path.Clean(slashed)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: invalid zip path: %s", ErrBadArchive, name)
+	}
+	return filepath.FromSlash(clean), nil
+}
@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeZip(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for name, content := range entries {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("zip create: %v", err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatalf("zip write: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("zip close: %v", err)
+	}
+}
+
+func TestCreateAndExtractRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "raw_session", "WLS1"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "events.jsonl"), []byte("{}\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "raw_session", "WLS1", "a.log"), []byte("rcv: 01\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	zipPath := filepath.Join(t.TempDir(), "out.zip")
+	if err := CreateZip(zipPath, src, []string{"events.jsonl", "raw_session"}); err != nil {
+		t.Fatalf("CreateZip: %v", err)
+	}
+
+	dest := t.TempDir()
+	if err := Extract(zipPath, dest, DefaultLimits); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "raw_session", "WLS1", "a.log"))
+	if err != nil || string(data) != "rcv: 01\n" {
+		t.Fatalf("unexpected extracted content %q: %v", data, err)
+	}
+}
+
+func TestExtractRejectsZipSlip(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "/abs.txt", "a/../../evil.txt", "..\\evil.txt"} {
+		zipPath := filepath.Join(t.TempDir(), "slip.zip")
+		writeZip(t, zipPath, map[string]string{name: "x"})
+		err := Extract(zipPath, t.TempDir(), DefaultLimits)
+		if !errors.Is(err, ErrBadArchive) || !strings.Contains(err.Error(), "invalid zip path") {
+			t.Fatalf("expected invalid zip path for %q, got %v", name, err)
+		}
+	}
+}
+
+func TestExtractEnforcesLimits(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "big.zip")
+	writeZip(t, zipPath, map[string]string{"a.txt": strings.Repeat("x", 100), "b.txt": strings.Repeat("y", 100)})
+
+	cases := []Limits{
+		{MaxFiles: 1},
+		{MaxFileSize: 50},
+		{MaxTotalSize: 150},
+	}
+	for _, limits := range cases {
+		err := Extract(zipPath, t.TempDir(), limits)
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("limits %+v: expected ErrLimitExceeded, got %v", limits, err)
+		}
+	}
+}
+
+func TestReaderOpenStreamsEntry(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "stream.zip")
+	writeZip(t, zipPath, map[string]string{"sensor_data.jsonl": "line\n"})
+
+	reader, err := OpenReader(zipPath, DefaultLimits)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer reader.Close()
+
+	rc, err := reader.Open("sensor_data.jsonl")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "line\n" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+	if _, err := reader.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format is an archive container. Devices that only have busybox tar send
+// tar.gz or tar.zst; everything else sends zip. All three carry the same
+// layout and manifest.
+type Format string
+
+const (
+	FormatZip    Format = "zip"
+	FormatTarGz  Format = "tar.gz"
+	FormatTarZst Format = "tar.zst"
+)
+
+// Formats lists the supported formats, zip first.
+var Formats = []Format{FormatZip, FormatTarGz, FormatTarZst}
+
+// ParseFormat accepts "" (zip), zip, tar.gz and tar.zst.
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(strings.ToLower(strings.TrimSpace(value))); format {
+	case "":
+		return FormatZip, nil
+	case FormatZip, FormatTarGz, FormatTarZst:
+		return format, nil
+	}
+	return FormatZip, fmt.Errorf("unknown archive format %q; use zip, tar.gz or tar.zst", value)
+}
+
+// Ext is the file name extension of the format, e.g. ".tar.gz".
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+// SplitExt splits an archive file name into its base and format. ok is
+// false for a name without a supported extension.
+func SplitExt(name string) (base string, format Format, ok bool) {
+	lower := strings.ToLower(name)
+	for _, f := range Formats {
+		if strings.HasSuffix(lower, f.Ext()) {
+			return name[:len(name)-len(f.Ext())], f, true
+		}
+	}
+	return name, "", false
+}
+
+// TrimExt returns name without its archive extension, or without its last
+// extension when it is not an archive name.
+func TrimExt(name string) string {
+	if base, _, ok := SplitExt(name); ok {
+		return base
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Detect reads the format of the archive at path from its first bytes, so
+// a misnamed archive is still unpacked correctly.
+func Detect(archivePath string) (Format, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	head := make([]byte, 4)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	head = head[:n]
+	switch {
+	case bytes.HasPrefix(head, zipMagic):
+		return FormatZip, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		return FormatTarZst, nil
+	}
+	return "", fmt.Errorf("%w: not a zip, tar.gz or tar.zst file", ErrBadArchive)
+}
+
+// Create writes an archive of format at archivePath containing the given
+// entries, which are paths relative to root. Directories are added
+// recursively.
+func Create(archivePath, root string, names []string, format Format) (err error) {
+	if format == FormatZip || format == "" {
+		return CreateZip(archivePath, root, names)
+	}
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	var compressor io.WriteCloser
+	switch format {
+	case FormatTarGz:
+		compressor = gzip.NewWriter(file)
+	case FormatTarZst:
+		if compressor, err = zstd.NewWriter(file); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+	writer := tar.NewWriter(compressor)
+	var files []string
+	for _, name := range names {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		err := filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	sort.Strings(files)
+	for _, p := range files {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if err := addTarFile(writer, p, filepath.ToSlash(rel)); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+func addTarFile(writer *tar.Writer, src, name string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Format = tar.FormatPAX
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// openTar returns a tar reader over the decompressed archive.
+func openTar(archivePath string, format Format) (*tar.Reader, io.Closer, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stream io.Reader
+	closer := io.Closer(file)
+	switch format {
+	case FormatTarGz:
+		gz, err := gzip.NewReader(bufio.NewReader(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("%w: %w", ErrBadArchive, err)
+		}
+		stream = gz
+	case FormatTarZst:
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("%w: %w", ErrBadArchive, err)
+		}
+		stream = zr
+		closer = closers{zr.IOReadCloser(), file}
+	default:
+		file.Close()
+		return nil, nil, fmt.Errorf("unknown archive format %q", format)
+	}
+	return tar.NewReader(stream), closer, nil
+}
+
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var errs []error
+	for _, closer := range c {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// extractTar unpacks a tar.gz or tar.zst archive into dest under the same
+// rules as a zip: entries must stay inside dest, only directories and
+// regular files are accepted, and limits apply to the bytes read. It
+// returns the names of the files extracted.
+func extractTar(archivePath, dest string, format Format, limits Limits) ([]string, error) {
+	reader, closer, err := openTar(archivePath, format)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	counter := &Reader{limits: limits}
+	var names []string
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return names, nil
+		}
+		if err != nil {
+			return names, fmt.Errorf("%w: %w", ErrBadArchive, err)
+		}
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeDir, tar.TypeReg:
+		default:
+			return names, fmt.Errorf("%w: unsupported tar entry type: %s", ErrBadArchive, header.Name)
+		}
+		rel, err := SafePath(header.Name)
+		if err != nil {
+			return names, err
+		}
+		target := filepath.Join(dest, rel)
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return names, err
+			}
+			continue
+		}
+		names = append(names, path.Clean(strings.ReplaceAll(header.Name, "\\", "/")))
+		if limits.MaxFiles > 0 && len(names) > limits.MaxFiles {
+			return names, fmt.Errorf("%w: more than %d entries", ErrLimitExceeded, limits.MaxFiles)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return names, err
+		}
+		src := &limitedReader{ReadCloser: io.NopCloser(corruptReader{reader, header.Name}), reader: counter, name: header.Name}
+		if err := copyTo(target, src); err != nil {
+			return names, err
+		}
+	}
+}
+
+// corruptReader marks read errors of a tar entry, such as a cut-off
+// compressed stream, as a bad archive, apart from write errors.
+type corruptReader struct {
+	r    io.Reader
+	name string
+}
+
+func (c corruptReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w: %s: %w", ErrBadArchive, c.name, err)
+	}
+	return n, err
+}
+
+func copyTo(target string, src io.Reader) error {
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTarGz(t *testing.T, path string, headers []*tar.Header, contents []string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	writer := tar.NewWriter(gz)
+	for i, header := range headers {
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("tar header: %v", err)
+		}
+		if _, err := io.WriteString(writer, contents[i]); err != nil {
+			t.Fatalf("tar write: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("tar close: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+}
+
+func TestTarFormatsRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "raw_session", "WLS1"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "events.jsonl"), []byte("{}\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "raw_session", "WLS1", "a.log"), []byte("rcv: 01\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	for _, format := range Formats {
+		// A misnamed archive is still read by its content.
+		archivePath := filepath.Join(t.TempDir(), "out.bin")
+		if err := Create(archivePath, src, []string{"events.jsonl", "raw_session"}, format); err != nil {
+			t.Fatalf("%s: Create: %v", format, err)
+		}
+		if got, err := Detect(archivePath); err != nil || got != format {
+			t.Fatalf("%s: Detect = %q, %v", format, got, err)
+		}
+
+		dest := t.TempDir()
+		if err := Extract(archivePath, dest, DefaultLimits); err != nil {
+			t.Fatalf("%s: Extract: %v", format, err)
+		}
+		data, err := os.ReadFile(filepath.Join(dest, "raw_session", "WLS1", "a.log"))
+		if err != nil || string(data) != "rcv: 01\n" {
+			t.Fatalf("%s: unexpected extracted content %q: %v", format, data, err)
+		}
+
+		reader, err := OpenReader(archivePath, DefaultLimits)
+		if err != nil {
+			t.Fatalf("%s: OpenReader: %v", format, err)
+		}
+		if got := strings.Join(reader.Names(), ","); got != "events.jsonl,raw_session/WLS1/a.log" {
+			t.Fatalf("%s: Names = %s", format, got)
+		}
+		rc, err := reader.Open("events.jsonl")
+		if err != nil {
+			t.Fatalf("%s: Open: %v", format, err)
+		}
+		data, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != "{}\n" {
+			t.Fatalf("%s: unexpected content %q: %v", format, data, err)
+		}
+		if err := reader.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", format, err)
+		}
+	}
+}
+
+func TestExtractTarRejectsUnsafeEntries(t *testing.T) {
+	cases := []*tar.Header{
+		{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 1},
+		{Name: "/abs.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 1},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	}
+	for _, header := range cases {
+		content := ""
+		if header.Typeflag == tar.TypeReg {
+			content = "x"
+		}
+		archivePath := filepath.Join(t.TempDir(), "slip.tar.gz")
+		writeTarGz(t, archivePath, []*tar.Header{header}, []string{content})
+		if err := Extract(archivePath, t.TempDir(), DefaultLimits); !errors.Is(err, ErrBadArchive) {
+			t.Fatalf("%s: expected ErrBadArchive, got %v", header.Name, err)
+		}
+	}
+}
+
+func TestExtractTarEnforcesLimits(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "big.tar.gz")
+	writeTarGz(t, archivePath, []*tar.Header{
+		{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 100},
+		{Name: "b.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 100},
+	}, []string{strings.Repeat("x", 100), strings.Repeat("y", 100)})
+
+	for _, limits := range []Limits{{MaxFiles: 1}, {MaxFileSize: 50}, {MaxTotalSize: 150}} {
+		if err := Extract(archivePath, t.TempDir(), limits); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("limits %+v: expected ErrLimitExceeded, got %v", limits, err)
+		}
+	}
+
+	data, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	cut := filepath.Join(t.TempDir(), "cut.tar.gz")
+	if err := os.WriteFile(cut, data[:len(data)/2], 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := Extract(cut, t.TempDir(), DefaultLimits); !errors.Is(err, ErrBadArchive) {
+		t.Fatalf("truncated archive: expected ErrBadArchive, got %v", err)
+	}
+}
+
+func TestSplitExt(t *testing.T) {
+	cases := map[string]string{
+		"a_b_20240501.zip":     "a_b_20240501|zip",
+		"a_b_20240501.tar.gz":  "a_b_20240501|tar.gz",
+		"a_b_20240501.TAR.ZST": "a_b_20240501|tar.zst",
+		"a_b_20240501.tar":     "a_b_20240501.tar|",
+		"a.zip.partial":        "a.zip.partial|",
+	}
+	for name, want := range cases {
+		base, format, _ := SplitExt(name)
+		if got := base + "|" + string(format); got != want {
+			t.Fatalf("SplitExt(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Package archivename formats and parses the file names of daily archives
+// from a template such as "{site}_{device}_{date}", so the field PC that
+// names an archive and the worker that reads the site, device and date back
+// out of it share one definition. The extension (.zip) is not part of the
+// template.
+package archivename
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"workfield/internal/timeparse"
+)
+
+// Default is the template used when none is configured.
+const Default = "{site}_{device}_{date}"
+
+// ErrNoMatch means a name does not carry a site and device in the template.
+var ErrNoMatch = errors.New("archive name does not match template")
+
+// Placeholders of a template.
+const (
+	Site      = "site"
+	Device    = "device"
+	WorkField = "work_field"
+	Date      = "date"
+	Hour      = "hour"
+)
+
+// Fields are the values a name carries; Date is YYYYMMDD and Hour HH.
+type Fields struct {
+	Site      string
+	Device    string
+	WorkField string
+	Date      string
+	Hour      string
+}
+
+// token is a literal run of text or, when field is set, a placeholder.
+type token struct {
+	literal string
+	field   string
+}
+
+// Template is a parsed name template. A nil *Template is Default.
+type Template struct {
+	text   string
+	tokens []token
+}
+
+var defaultTemplate = mustParse(Default)
+
+func mustParse(text string) *Template {
+	t, err := Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Parse checks text: it needs {site} and {device}, each placeholder at most
+// once, {hour} only with {date}, and a literal after {site}, {device} and
+// {work_field} unless they end the template, so a name splits back into
+// the same fields. An empty text is Default.
+func Parse(text string) (*Template, error) {
+	if text == "" {
+		text = Default
+	}
+	if strings.ContainsAny(text, `/\`) {
+		return nil, fmt.Errorf("template %q: must be a file name, not a path", text)
+	}
+	t := &Template{text: text}
+	seen := map[string]bool{}
+	for rest := text; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open != 0 {
+			if open < 0 {
+				open = len(rest)
+			}
+			if strings.Contains(rest[:open], "}") {
+				return nil, fmt.Errorf("template %q: unmatched }", text)
+			}
+			t.tokens = append(t.tokens, token{literal: rest[:open]})
+			rest = rest[open:]
+			continue
+		}
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: unclosed {", text)
+		}
+		field := rest[1:end]
+		switch field {
+		case Site, Device, WorkField, Date, Hour:
+		default:
+			return nil, fmt.Errorf("template %q: unknown placeholder {%s}; use {site}, {device}, {work_field}, {date} or {hour}", text, field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("template %q: {%s} appears twice", text, field)
+		}
+		seen[field] = true
+		if n := len(t.tokens); n > 0 && t.tokens[n-1].field != "" && !fixedWidth(t.tokens[n-1].field) {
+			return nil, fmt.Errorf("template %q: {%s} needs a separator before {%s}", text, t.tokens[n-1].field, field)
+		}
+		t.tokens = append(t.tokens, token{field: field})
+		rest = rest[end+1:]
+	}
+	if !seen[Site] || !seen[Device] {
+		return nil, fmt.Errorf("template %q: needs {site} and {device}", text)
+	}
+	if seen[Hour] && !seen[Date] {
+		return nil, fmt.Errorf("template %q: {hour} needs {date}", text)
+	}
+	return t, nil
+}
+
+// fixedWidth reports whether field always has the same length, so it can
+// be followed directly by another placeholder.
+func fixedWidth(field string) bool {
+	return field == Date || field == Hour
+}
+
+func (t *Template) get() *Template {
+	if t == nil {
+		return defaultTemplate
+	}
+	return t
+}
+
+// String returns the template text.
+func (t *Template) String() string {
+	return t.get().text
+}
+
+// Format returns the name for f, without an extension.
+func (t *Template) Format(f Fields) string {
+	var b strings.Builder
+	for _, tok := range t.get().tokens {
+		if tok.field == "" {
+			b.WriteString(tok.literal)
+		} else {
+			b.WriteString(f.value(tok.field))
+		}
+	}
+	return b.String()
+}
+
+// Match reads the fields out of base, a name without its extension. Like
+// the names it replaced, a name may stop or go astray after its site and
+// device: the fields read up to there are returned, and the ones after
+// stay empty. Text after the whole template is ignored.
+func (t *Template) Match(base string) (Fields, error) {
+	var f Fields
+	tokens := t.get().tokens
+	rest := base
+	for i, tok := range tokens {
+		if tok.field == "" {
+			var ok bool
+			if rest, ok = strings.CutPrefix(rest, tok.literal); !ok {
+				break
+			}
+			continue
+		}
+		value, ok := "", false
+		switch tok.field {
+		case Date:
+			if len(rest) >= 8 {
+				if _, err := timeparse.ParseDate(rest[:8]); err == nil {
+					value, ok = rest[:8], true
+				}
+			}
+		case Hour:
+			if len(rest) >= 2 && rest[0] >= '0' && rest[0] <= '2' && rest[1] >= '0' && rest[1] <= '9' && rest[:2] <= "23" {
+				value, ok = rest[:2], true
+			}
+		default:
+			end := len(rest)
+			if i+1 < len(tokens) {
+				if sep := strings.IndexByte(rest, tokens[i+1].literal[0]); sep >= 0 {
+					end = sep
+				}
+			}
+			value, ok = rest[:end], end > 0
+		}
+		if !ok {
+			break
+		}
+		f.set(tok.field, value)
+		rest = rest[len(value):]
+	}
+	if f.Site == "" || f.Device == "" {
+		return Fields{}, fmt.Errorf("%w %s: %s", ErrNoMatch, t.String(), base)
+	}
+	return f, nil
+}
+
+func (f Fields) value(field string) string {
+	switch field {
+	case Site:
+		return f.Site
+	case Device:
+		return f.Device
+	case WorkField:
+		return f.WorkField
+	case Date:
+		return f.Date
+	case Hour:
+		return f.Hour
+	}
+	return ""
+}
+
+func (f *Fields) set(field, value string) {
+	switch field {
+	case Site:
+		f.Site = value
+	case Device:
+		f.Device = value
+	case WorkField:
+		f.WorkField = value
+	case Date:
+		f.Date = value
+	case Hour:
+		f.Hour = value
+	}
+}
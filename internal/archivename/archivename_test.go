@@ -0,0 +1,61 @@
+package archivename
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDefaultMatchesLegacyNames(t *testing.T) {
+	var names *Template
+	cases := []struct {
+		base string
+		want Fields
+	}{
+		{"siteA_device01_20240501", Fields{Site: "siteA", Device: "device01", Date: "20240501"}},
+		{"siteA_device01_20240501_retry", Fields{Site: "siteA", Device: "device01", Date: "20240501"}},
+		{"siteA_device01_notadate", Fields{Site: "siteA", Device: "device01"}},
+		{"siteA_device01", Fields{Site: "siteA", Device: "device01"}},
+	}
+	for _, c := range cases {
+		got, err := names.Match(c.base)
+		if err != nil || got != c.want {
+			t.Errorf("Match(%q) = %+v, %v; want %+v", c.base, got, err, c.want)
+		}
+	}
+	for _, base := range []string{"siteA", "siteA__20240501", "_device01_20240501"} {
+		if _, err := names.Match(base); !errors.Is(err, ErrNoMatch) {
+			t.Errorf("Match(%q) error = %v, want ErrNoMatch", base, err)
+		}
+	}
+}
+
+func TestTemplateRoundTrip(t *testing.T) {
+	names, err := Parse("{work_field}-{site}-{device}-{date}{hour}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := Fields{Site: "siteA", Device: "device01", WorkField: "north", Date: "20240501", Hour: "13"}
+	base := names.Format(f)
+	if base != "north-siteA-device01-2024050113" {
+		t.Fatalf("Format = %q", base)
+	}
+	if got, err := names.Match(base); err != nil || got != f {
+		t.Fatalf("Match(%q) = %+v, %v", base, got, err)
+	}
+}
+
+func TestParseRejectsAmbiguousTemplates(t *testing.T) {
+	for _, text := range []string{
+		"{site}{device}_{date}",
+		"{site}_{date}",
+		"{site}_{device}_{hour}",
+		"{site}_{device}_{day}",
+		"{site}_{device}_{site}",
+		"{site}_{device",
+		"out/{site}_{device}",
+	} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) accepted", text)
+		}
+	}
+}
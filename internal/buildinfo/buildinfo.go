@@ -0,0 +1,79 @@
+// Package buildinfo reports which build of the tools is running. Release
+// builds set the variables with -ldflags, e.g.
+//
+//	go build -ldflags "-X workfield/internal/buildinfo.Version=v1.4.0 \
+//	  -X workfield/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X workfield/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Plain go build leaves them empty and the VCS stamp embedded by the Go
+// toolchain is used instead.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the ldflags values, filling gaps from the embedded build info.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// Short identifies the build in stored rows: the version plus a 12-character
+// commit when known, e.g. "v1.4.0+3f7dadb1c2e4".
+func (i Info) Short() string {
+	if i.Commit == "" {
+		return i.Version
+	}
+	commit := i.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if i.Modified {
+		commit += "-dirty"
+	}
+	return i.Version + "+" + commit
+}
+
+// String is the --version line for program.
+func (i Info) String(program string) string {
+	line := fmt.Sprintf("%s %s", program, i.Short())
+	if i.Date != "" {
+		line += " built " + i.Date
+	}
+	return line + " " + i.GoVersion
+}
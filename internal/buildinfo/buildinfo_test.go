@@ -0,0 +1,32 @@
+package buildinfo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShortAndString(t *testing.T) {
+	info := Info{Version: "v1.4.0", Commit: "3f7dadb1c2e4a5b6", Date: "2026-10-16T00:00:00Z", GoVersion: "go1.22.5"}
+	if got := info.Short(); got != "v1.4.0+3f7dadb1c2e4" {
+		t.Fatalf("unexpected short %q", got)
+	}
+	if got := info.String("field-client"); got != "field-client v1.4.0+3f7dadb1c2e4 built 2026-10-16T00:00:00Z go1.22.5" {
+		t.Fatalf("unexpected string %q", got)
+	}
+	info.Modified = true
+	if !strings.HasSuffix(info.Short(), "-dirty") {
+		t.Fatalf("expected dirty marker, got %q", info.Short())
+	}
+	if got := (Info{Version: "dev"}).Short(); got != "dev" {
+		t.Fatalf("unexpected short without commit %q", got)
+	}
+}
+
+func TestGetPrefersLinkerValues(t *testing.T) {
+	defer func(v, c string) { Version, Commit = v, c }(Version, Commit)
+	Version, Commit = "v9.9.9", "abc"
+	info := Get()
+	if info.Version != "v9.9.9" || info.Commit != "abc" || info.GoVersion == "" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/receipt"
+)
+
+// Exit codes of "ack", so upload scripts can alert on each case.
+const (
+	exitAckRejected = 3 // uploaded but rejected as packaged
+	exitAckFailed   = 4 // accepted but failed on the server
+	exitAckTimeout  = 5 // no receipt before -timeout
+)
+
+// runAck waits for the worker's receipt of an uploaded archive. A package
+// the worker refused (broken zip, manifest mismatch) is reported as
+// "uploaded but rejected" so packaging bugs surface the same day.
+func runAck(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("ack", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	receiptsDir := fs.String("receipts-dir", "", "directory holding the worker's receipts (overrides config receipts_dir)")
+	archiveName := fs.String("archive", "", "uploaded archive, e.g. siteA_device01_20260120.zip")
+	timeout := fs.Duration("timeout", 2*time.Hour, "give up after this long")
+	interval := fs.Duration("interval", 30*time.Second, "poll interval")
+	fs.Parse(args)
+
+	if *archiveName == "" {
+		fatal(errors.New("--archive is required"))
+	}
+	if *interval <= 0 {
+		fatal(errors.New("--interval must be positive"))
+	}
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if *receiptsDir != "" {
+		cfg.ReceiptsDir = *receiptsDir
+	}
+	if cfg.ReceiptsDir == "" {
+		fatal(errors.New("receipts_dir is not configured"))
+	}
+
+	name := filepath.Base(*archiveName)
+	waitCtx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	r, err := receipt.Await(waitCtx, cfg.ReceiptsDir, name, *interval)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Printf("%s: no receipt after %s\n", name, *timeout)
+		exit(exitAckTimeout)
+	case err != nil:
+		fatal(err)
+	case r.OK():
+		fmt.Printf("%s: ingested (audit id %d)\n", name, r.AuditID)
+	case r.Rejected():
+		fmt.Printf("%s: uploaded but rejected at %s: %s\n", name, r.Stage, r.Error)
+		exit(exitAckRejected)
+	default:
+		fmt.Printf("%s: uploaded, failed on the server at %s: %s\n", name, r.Stage, r.Error)
+		exit(exitAckFailed)
+	}
+}
+
+func exit(code int) {
+	flushTracing()
+	os.Exit(code)
+}
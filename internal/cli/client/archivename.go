@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"workfield/internal/archive"
+	"workfield/internal/archivename"
+	"workfield/internal/config"
+	"workfield/internal/timeparse"
+)
+
+// runArchiveName prints the file name of a day's archive under
+// archive_name_template, for the packager script to name its zip with:
+//
+//	zip "$OUTBOX/$(field-client archive-name -config config.yaml -date yesterday)" ...
+//
+// With -format tar.gz or tar.zst the name carries that extension instead.
+func runArchiveName(args []string) {
+	fs := flag.NewFlagSet("archive-name", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	dateStr := fs.String("date", "", "date in YYYYMMDD, or today/yesterday")
+	hour := fs.Int("hour", -1, "hour of the day (0-23) for templates with {hour}")
+	workField := fs.String("work-field", "", "work field for templates with {work_field} (default: config work_field)")
+	formatName := fs.String("format", "zip", "archive format: zip, tar.gz or tar.zst")
+	fs.Parse(args)
+
+	if *dateStr == "" {
+		fatal(errors.New("--date is required (YYYYMMDD)"))
+	}
+	format, err := archive.ParseFormat(*formatName)
+	if err != nil {
+		fatal(err)
+	}
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	names, err := cfg.ArchiveNames()
+	if err != nil {
+		fatal(err)
+	}
+	timestamps, err := timeparse.New(cfg.TimestampLayouts, cfg.Timezone)
+	if err != nil {
+		fatal(err)
+	}
+	date, err := timestamps.ResolveDate(*dateStr, time.Now())
+	if err != nil {
+		fatal(err)
+	}
+	fields := archivename.Fields{Site: cfg.SiteID, Device: cfg.DeviceID, WorkField: cfg.WorkField, Date: date}
+	if *workField != "" {
+		fields.WorkField = *workField
+	}
+	if *hour >= 0 {
+		if *hour > 23 {
+			fatal(fmt.Errorf("invalid --hour %d: expected 0-23", *hour))
+		}
+		fields.Hour = fmt.Sprintf("%02d", *hour)
+	}
+	fmt.Println(names.Format(fields) + format.Ext())
+}
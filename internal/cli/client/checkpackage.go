@@ -0,0 +1,106 @@
+package client
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"workfield/internal/manifest"
+	"workfield/internal/record"
+)
+
+// runCheckPackage checks the files of a package directory before the
+// packager zips it, so empty or cut-off files are caught on the field PC
+// instead of failing, or ingesting nothing, on the worker:
+//
+//	field-client check-package [-allow-empty] [-strict-payloads] outbox/daily/20240501
+//
+// It exits 1 when a file should not be packaged. Payloads in
+// sensor_data.jsonl that do not have the shape the worker compares are
+// counted and logged, and fail the check with -strict-payloads.
+func runCheckPackage(args []string) {
+	flags := flag.NewFlagSet("check-package", flag.ExitOnError)
+	allowEmpty := flags.Bool("allow-empty", false, "accept empty files, for a legitimately quiet day")
+	strictPayloads := flags.Bool("strict-payloads", false, "fail when a sensor_data.jsonl payload does not have the expected shape")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fatal(errors.New("usage: check-package [-allow-empty] [-strict-payloads] DIR"))
+	}
+	root := flags.Arg(0)
+
+	var names []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel != manifest.FileName {
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		fatal(err)
+	}
+	if len(names) == 0 {
+		fatal(fmt.Errorf("%s has no files to package", root))
+	}
+	problems, err := manifest.Check(root, names, *allowEmpty)
+	if err != nil {
+		fatal(err)
+	}
+	nonconforming, err := checkPayloads(filepath.Join(root, sensorDataFile))
+	if err != nil {
+		fatal(err)
+	}
+	onlyEmpty := true
+	for _, problem := range problems {
+		slog.Warn("file should not be packaged", "file", problem.Name, "error", problem.Err)
+		onlyEmpty = onlyEmpty && errors.Is(problem.Err, manifest.ErrEmpty)
+	}
+	switch {
+	case len(problems) == 0 && nonconforming > 0 && *strictPayloads:
+		fatal(fmt.Errorf("%d payloads in %s do not have the expected shape", nonconforming, sensorDataFile))
+	case len(problems) == 0:
+		fmt.Printf("%d files ok\n", len(names))
+	case onlyEmpty:
+		fatal(fmt.Errorf("%d of %d files are empty; pass -allow-empty if the day was quiet", len(problems), len(names)))
+	default:
+		fatal(fmt.Errorf("%d of %d files should not be packaged", len(problems), len(names)))
+	}
+}
+
+// sensorDataFile holds the payloads the worker compares.
+const sensorDataFile = "sensor_data.jsonl"
+
+// checkPayloads logs the payload problems of path, one warning per kind
+// with its count and first line, and returns how many lines had one. A
+// missing file has none; manifest.Check reports files that do not parse.
+func checkPayloads(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	report, err := record.CheckPayloads(f)
+	if err != nil {
+		return 0, err
+	}
+	for _, kind := range report.SortedKinds() {
+		slog.Warn("nonconforming payloads", "file", sensorDataFile, "problem", kind, "lines", report.Kinds[kind], "first_line", report.FirstLine[kind])
+	}
+	if report.Nonconforming > 0 {
+		fmt.Printf("%s: %d of %d payloads do not have the expected shape\n", sensorDataFile, report.Nonconforming, report.Lines)
+	}
+	return report.Nonconforming, nil
+}
@@ -0,0 +1,371 @@
+// Package client implements the field-client command line, shared by the
+// field-client binary and "field client".
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"workfield/internal/alarm"
+	"workfield/internal/analyzer"
+	"workfield/internal/buildinfo"
+	"workfield/internal/config"
+	"workfield/internal/debugserver"
+	"workfield/internal/decoder"
+	"workfield/internal/i18n"
+	"workfield/internal/logging"
+	"workfield/internal/timeparse"
+	"workfield/internal/tracing"
+	"workfield/internal/webhook"
+)
+
+// Main runs the command with args after the program name and exits on
+// failure.
+func Main(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "expected subcommand: analyze-daily, ack, archive-name, check-package, export-usb, health, history, notify, receipts, secret, service, support-bundle or upload")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownTracing = tracing.Setup("field-client")
+	defer flushTracing()
+
+	switch args[0] {
+	case "version", "-version", "--version":
+		fmt.Println(buildinfo.Get().String("field-client"))
+	case "analyze-daily":
+		runAnalyzeDaily(ctx, args[1:])
+	case "ack":
+		runAck(ctx, args[1:])
+	case "archive-name":
+		runArchiveName(args[1:])
+	case "check-package":
+		runCheckPackage(args[1:])
+	case "export-usb":
+		runExportUSB(args[1:])
+	case "health":
+		runHealth(ctx, args[1:])
+	case "history":
+		runHistory(args[1:])
+	case "notify":
+		runNotify(ctx, args[1:])
+	case "receipts":
+		runReceipts(args[1:])
+	case "secret":
+		runSecret(ctx, args[1:])
+	case "service":
+		runService(ctx, args[1:])
+	case "support-bundle":
+		runSupportBundle(ctx, args[1:])
+	case "upload":
+		runUpload(ctx, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, "unknown subcommand")
+		os.Exit(2)
+	}
+}
+
+func runAnalyzeDaily(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("analyze-daily", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	dateStr := fs.String("date", "", "date in YYYYMMDD, or today/yesterday")
+	logRoot := fs.String("log-root", "", "log root directory")
+	maxLines := fs.Int("max-lines", 5000, "max lines per sensor (overrides config max_lines)")
+	pprofAddr := fs.String("pprof-addr", "", "serve net/http/pprof on this address while running (e.g. 127.0.0.1:6060)")
+	applyLogFlags := logFlags(fs)
+	eventStream := fs.Bool("event-stream", false, "also write event_stream.jsonl next to analysis.json (overrides config event_stream)")
+	exampleContext := fs.Int("example-context", 0, "lines of context around the first timeout, unanswered snd and zero data (overrides config example_context_lines)")
+	ndjson := fs.Bool("ndjson", false, "print one JSON line per sensor on stdout as it is analyzed, then a summary line, instead of writing analysis.json")
+	fs.Parse(args)
+
+	if *dateStr == "" {
+		fatal(errors.New("--date is required (YYYYMMDD)"))
+	}
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if *logRoot != "" {
+		cfg.LogRoot = *logRoot
+	}
+	applyLogFlags(&cfg)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max-lines":
+			cfg.MaxLines = *maxLines
+		case "event-stream":
+			cfg.EventStream = *eventStream
+		case "example-context":
+			cfg.ExampleContextLines = *exampleContext
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	closeLog, err := logging.Setup(cfg.Logging())
+	if err != nil {
+		fatal(err)
+	}
+	defer closeLog()
+	if *pprofAddr != "" {
+		server, err := debugserver.Start(*pprofAddr)
+		if err != nil {
+			fatal(err)
+		}
+		defer server.Close()
+		slog.Info("pprof listening", "url", "http://"+server.Addr()+"/debug/pprof/")
+	}
+
+	timestamps, err := timeparse.New(cfg.TimestampLayouts, cfg.Timezone)
+	if err != nil {
+		fatal(err)
+	}
+	date, err := timestamps.ResolveDate(*dateStr, time.Now())
+	if err != nil {
+		fatal(err)
+	}
+	lang, err := i18n.ParseLang(cfg.Language)
+	if err != nil {
+		fatal(err)
+	}
+	decoders, err := decoder.NewSet(cfg.Decoders)
+	if err != nil {
+		fatal(err)
+	}
+	defer decoders.Close()
+	schedules := analyzer.Schedules{}
+	for key, windows := range cfg.SensorSchedules {
+		if schedules[key], err = analyzer.ParseSchedule(windows); err != nil {
+			fatal(err)
+		}
+	}
+	analysisConfig := analyzer.Config{
+		SiteID:                cfg.SiteID,
+		DeviceID:              cfg.DeviceID,
+		OutboxDir:             cfg.OutboxDir,
+		LogRoot:               cfg.LogRoot,
+		IncludeGlobs:          cfg.IncludeGlobs,
+		ExcludeDirs:           cfg.ExcludeDirs,
+		ControllerLogGlobs:    cfg.ControllerLogGlobs,
+		ExpectedSensors:       cfg.ExpectedSensors,
+		Thresholds:            analysisThresholds(cfg.Thresholds),
+		Schedules:             schedules,
+		ExampleContext:        cfg.ExampleContextLines,
+		DiskWarnDays:          cfg.DiskWarnDays,
+		DuplicateRunThreshold: cfg.DuplicateRunThreshold,
+		FallbackToLatestFile:  *cfg.FallbackToLatestFile,
+		PayloadFormat:         analyzer.PayloadFormat(cfg.PayloadFormat),
+		Timestamps:            timestamps,
+		Language:              lang,
+		Decoders:              decoders,
+	}
+
+	outDir := filepath.Join(cfg.OutboxDir, "daily", date)
+	if !*ndjson || cfg.EventStream {
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			fatal(err)
+		}
+	}
+	var (
+		stream   *os.File
+		buffered *bufio.Writer
+	)
+	streamPath := filepath.Join(outDir, "event_stream.jsonl")
+	if cfg.EventStream {
+		if stream, err = os.Create(streamPath + ".partial"); err != nil {
+			fatal(err)
+		}
+		defer os.Remove(stream.Name())
+		buffered = bufio.NewWriter(stream)
+		analysisConfig.Events = buffered
+	}
+
+	if *ndjson {
+		analysisConfig.SensorStream = os.Stdout
+	}
+
+	hook := clientHook(cfg, *configPath)
+	started := time.Now()
+	summary, err := analyzer.AnalyzeDaily(ctx, analysisConfig, date, cfg.MaxLines)
+	if err != nil {
+		e := stepEvent(cfg, webhook.StepCollect, started, err)
+		e.Date = date
+		hook.Send(context.WithoutCancel(ctx), e)
+		fatal(err)
+	}
+	if disk := summary.Disk; disk != nil && disk.LowSpace {
+		slog.Warn("log partition filling up", "log_root", cfg.LogRoot, "days_until_full", fmt.Sprintf("%.1f", *disk.DaysUntilFull), "free_bytes", *disk.FreeBytes, "growth_bytes", disk.GrowthBytes)
+	}
+	if stream != nil {
+		if err := finishStream(stream, buffered, streamPath); err != nil {
+			fatal(err)
+		}
+	}
+	if *ndjson {
+		line := struct {
+			Type string `json:"type"`
+			analyzer.Summary
+		}{analyzer.LineSummary, summary}
+		if err := json.NewEncoder(os.Stdout).Encode(line); err != nil {
+			fatal(err)
+		}
+		hook.Send(ctx, collectEvent(cfg, summary, started))
+		return
+	}
+	outputPath := filepath.Join(outDir, "analysis.json")
+	if err := writeJSON(outputPath, summary); err != nil {
+		fatal(err)
+	}
+	if err := writeControllerEvents(filepath.Join(outDir, "events.jsonl"), summary.ControllerEvents); err != nil {
+		fatal(err)
+	}
+	if cfg.HistoryDir != "" {
+		if err := appendHistory(cfg, summary); err != nil {
+			fatal(fmt.Errorf("history: %w", err))
+		}
+	}
+	e := collectEvent(cfg, summary, started)
+	e.Files = describeFiles(outputPath, filepath.Join(outDir, "events.jsonl"))
+	if stream != nil {
+		e.Files = append(e.Files, describeFiles(streamPath)...)
+	}
+	hook.Send(ctx, e)
+
+	fmt.Println(lang.T(i18n.ClientWrote, outputPath))
+}
+
+// collectEvent reports a finished analysis with its sensor and issue counts.
+func collectEvent(cfg config.Client, summary analyzer.Summary, started time.Time) webhook.Event {
+	e := stepEvent(cfg, webhook.StepCollect, started, nil)
+	e.Date = summary.Date
+	e.Counts = map[string]int64{
+		"sensors":           int64(len(summary.Sensors)),
+		"top_issues":        int64(len(summary.TopIssues)),
+		"controller_events": int64(len(summary.ControllerEvents)),
+	}
+	return e
+}
+
+// analysisThresholds converts the configured limit profiles.
+func analysisThresholds(t config.Thresholds) analyzer.Thresholds {
+	convert := func(m map[string]config.ThresholdLimits) map[string]analyzer.Limits {
+		if m == nil {
+			return nil
+		}
+		out := make(map[string]analyzer.Limits, len(m))
+		for key, limits := range m {
+			out[key] = analyzer.Limits(limits)
+		}
+		return out
+	}
+	return analyzer.Thresholds{Default: analyzer.Limits(t.Default), Types: convert(t.Types), Sensors: convert(t.Sensors)}
+}
+
+// finishStream flushes the event stream and renames it into place, so a
+// failed run never leaves a truncated event_stream.jsonl behind.
+func finishStream(file *os.File, buffered *bufio.Writer, path string) error {
+	if err := buffered.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// writeControllerEvents replaces the controller_event lines of the day's
+// events.jsonl with events, keeping the other lines (health samples), so a
+// re-run does not duplicate them.
+func writeControllerEvents(path string, events []alarm.Event) error {
+	var kept [][]byte
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var typed struct {
+			Type string `json:"type"`
+		}
+		if len(bytes.TrimSpace(line)) == 0 || (json.Unmarshal(line, &typed) == nil && typed.Type == alarm.EventType) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(events) == 0 && len(kept) == 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	for _, line := range kept {
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	enc := json.NewEncoder(&out)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".partial"
+	if err := os.WriteFile(tmp, out.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeJSON(path string, data any) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(data)
+}
+
+// logFlags adds -log-level and -log-format to fs. The returned function
+// sets those given on cfg, overriding its log_level and log_format.
+func logFlags(fs *flag.FlagSet) func(cfg *config.Client) {
+	level := fs.String("log-level", "", "log level: debug, info, warn, error (overrides config log_level)")
+	format := fs.String("log-format", "", "log format: text or json (overrides config log_format)")
+	return func(cfg *config.Client) {
+		if *level != "" {
+			cfg.LogLevel = *level
+		}
+		if *format != "" {
+			cfg.LogFormat = *format
+		}
+	}
+}
+
+// shutdownTracing is replaced in main once tracing is configured.
+var shutdownTracing = func(context.Context) error { return nil }
+
+func flushTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("traces not flushed", "error", err)
+	}
+}
+
+func fatal(err error) {
+	slog.Error("exiting", "error", err)
+	flushTracing()
+	os.Exit(1)
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/transfer"
+	"workfield/internal/upload"
+)
+
+// usbTarget is the name export-usb records its copies under in the upload
+// state, so the next export only carries new archives.
+const usbTarget = "usb"
+
+// runExportUSB copies the outbox archives not exported yet to removable
+// media for air-gapped sites, verifying each copy, and writes the transfer
+// manifest the worker's usb-import checks them against.
+func runExportUSB(args []string) {
+	fs := flag.NewFlagSet("export-usb", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	to := fs.String("to", "", "mounted media directory to copy the archives to")
+	all := fs.Bool("all", false, "export every outbox archive, including ones exported before")
+	dryRun := fs.Bool("dry-run", false, "list what would be exported without copying")
+	fs.Parse(args)
+
+	if *to == "" {
+		fatal(errors.New("--to is required"))
+	}
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	archives, err := upload.Archives(cfg.OutboxDir)
+	if err != nil {
+		fatal(err)
+	}
+	state, err := upload.LoadState(filepath.Join(cfg.OutboxDir, upload.StateFile))
+	if err != nil {
+		fatal(err)
+	}
+	var names []string
+	for _, archive := range archives {
+		if *all || len(state.Pending(archive, []string{usbTarget})) > 0 {
+			names = append(names, archive)
+		}
+	}
+	if len(names) == 0 {
+		fmt.Println("nothing to export")
+		return
+	}
+	if *dryRun {
+		for _, name := range names {
+			fmt.Printf("would export %s\n", name)
+		}
+		return
+	}
+
+	now := time.Now()
+	m, manifestPath, exportErr := transfer.Export(cfg.OutboxDir, *to, names, cfg.SiteID, cfg.DeviceID, now)
+	// Copies only count once the manifest listing them is on the media.
+	if manifestPath != "" {
+		for _, f := range m.Files {
+			state.Record(f.Name, usbTarget, now, nil)
+			fmt.Printf("exported %s\t%d\t%s\n", f.Name, f.Size, f.SHA256)
+		}
+		if err := state.Save(); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("manifest %s\n", manifestPath)
+	}
+	if exportErr != nil {
+		fatal(exportErr)
+	}
+}
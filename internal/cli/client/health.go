@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/health"
+	"workfield/internal/timeparse"
+)
+
+// runHealth appends one device health sample to the day's events.jsonl in
+// the outbox, next to analysis.json, so it travels with the daily package.
+func runHealth(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	dateStr := fs.String("date", "today", "package date in YYYYMMDD, or today/yesterday")
+	ntpServer := fs.String("ntp-server", "", "measure the clock offset against this server (overrides config ntp_server)")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if *ntpServer != "" {
+		cfg.NTPServer = *ntpServer
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	timestamps, err := timeparse.New(cfg.TimestampLayouts, cfg.Timezone)
+	if err != nil {
+		fatal(err)
+	}
+	date, err := timestamps.ResolveDate(*dateStr, time.Now())
+	if err != nil {
+		fatal(err)
+	}
+
+	sample := health.Collect(ctx, cfg.LogRoot, cfg.NTPServer)
+	line, err := json.Marshal(sample)
+	if err != nil {
+		fatal(err)
+	}
+	outDir := filepath.Join(cfg.OutboxDir, "daily", date)
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		fatal(err)
+	}
+	path := filepath.Join(outDir, "events.jsonl")
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		fatal(err)
+	}
+	for _, problem := range sample.Errors {
+		slog.Warn("health not fully sampled", "problem", problem)
+	}
+	fmt.Println(path)
+}
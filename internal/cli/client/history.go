@@ -0,0 +1,112 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"workfield/internal/analyzer"
+	"workfield/internal/config"
+)
+
+// appendHistory stores the day's summary in history_dir and drops the
+// days past history_keep_days.
+func appendHistory(cfg config.Client, summary analyzer.Summary) error {
+	history, err := analyzer.OpenHistory(cfg.HistoryDir)
+	if err != nil {
+		return err
+	}
+	if err := history.Append(summary); err != nil {
+		return err
+	}
+	if cfg.HistoryKeepDays <= 0 {
+		return nil
+	}
+	cutoff, err := analyzer.HistoryCutoff(summary.Date, cfg.HistoryKeepDays)
+	if err != nil {
+		return err
+	}
+	_, err = history.Prune(cutoff)
+	return err
+}
+
+// runHistory prints one sensor's stored daily results, or the status of
+// every sensor per day, from history_dir.
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	sensor := fs.String("sensor", "", "print this sensor's daily results")
+	from := fs.String("from", "", "first day, YYYYMMDD")
+	to := fs.String("to", "", "last day, YYYYMMDD")
+	asJSON := fs.Bool("json", false, "print JSON lines instead of a table")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if cfg.HistoryDir == "" {
+		fatal(errors.New("history_dir is not configured"))
+	}
+	history, err := analyzer.OpenHistory(cfg.HistoryDir)
+	if err != nil {
+		fatal(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	if *sensor != "" {
+		days, err := history.GetSensorHistory(*sensor, *from, *to)
+		if err != nil {
+			fatal(err)
+		}
+		if !*asJSON {
+			fmt.Fprintln(w, "date\tstatus\tframes\ttimeout\tno response\tzero data\tduplicates\tparse errors")
+		}
+		for _, day := range days {
+			if *asJSON {
+				enc.Encode(day)
+				continue
+			}
+			m := day.Result.Metrics
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n", day.Date, day.Status, m.SndCount+m.RcvCount,
+				m.Timeout, m.NoResponse, m.ZeroData, m.Duplicates, m.ParseErrors)
+		}
+		return
+	}
+
+	calendar, err := history.GetStatusCalendar(*from, *to)
+	if err != nil {
+		fatal(err)
+	}
+	if !*asJSON {
+		fmt.Fprintln(w, "date\tstatus\tsensors not ok")
+	}
+	for _, day := range calendar {
+		if *asJSON {
+			enc.Encode(day)
+			continue
+		}
+		var notOK []string
+		for id, status := range day.Sensors {
+			if status != analyzer.StatusOK {
+				notOK = append(notOK, id+" "+status)
+			}
+		}
+		sort.Strings(notOK)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", day.Date, day.Status, orDash(strings.Join(notOK, ", ")))
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
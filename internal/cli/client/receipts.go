@@ -0,0 +1,56 @@
+package client
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"workfield/internal/config"
+	"workfield/internal/receipt"
+)
+
+// runReceipts collects the worker's receipts and deletes the local copies of
+// archives the worker has ingested.
+func runReceipts(args []string) {
+	fs := flag.NewFlagSet("receipts", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	receiptsDir := fs.String("receipts-dir", "", "directory holding the worker's receipts (overrides config receipts_dir)")
+	dryRun := fs.Bool("dry-run", false, "list what would be deleted without touching any file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if *receiptsDir != "" {
+		cfg.ReceiptsDir = *receiptsDir
+	}
+	if cfg.ReceiptsDir == "" {
+		fatal(errors.New("receipts_dir is not configured"))
+	}
+	if cfg.OutboxDir == "" {
+		fatal(errors.New("outbox_dir is not configured"))
+	}
+
+	hold, err := undelivered(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	results, err := receipt.Collect(cfg.ReceiptsDir, cfg.OutboxDir, *dryRun, hold)
+	for _, result := range results {
+		r := result.Receipt
+		switch {
+		case result.Deleted && *dryRun:
+			fmt.Printf("would delete %s\n", r.Archive)
+		case result.Deleted:
+			fmt.Printf("deleted %s\n", r.Archive)
+		case result.Held:
+			fmt.Printf("kept %s: not yet sent to every upload target\n", r.Archive)
+		case !r.OK():
+			fmt.Printf("kept %s: %s at %s: %s\n", r.Archive, r.Status, r.Stage, r.Error)
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"workfield/internal/config"
+	"workfield/internal/secret"
+)
+
+// SecretEnvPrefix is prepended to the upper-cased secret name for commands
+// run by "secret exec".
+const SecretEnvPrefix = "FIELD_SECRET_"
+
+// runSecret manages the encrypted secrets block of the client config:
+//
+//	secret set -name upload_token [-value ...]   (reads stdin without -value)
+//	secret list
+//	secret exec -- scp ...                        (secrets in FIELD_SECRET_*)
+func runSecret(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "expected: secret set|list|exec")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "set":
+		runSecretSet(args[1:])
+	case "list":
+		runSecretList(args[1:])
+	case "exec":
+		runSecretExec(ctx, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, "unknown secret command")
+		os.Exit(2)
+	}
+}
+
+func runSecretSet(args []string) {
+	fs := flag.NewFlagSet("secret set", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	name := fs.String("name", "", "secret name, e.g. upload_token")
+	value := fs.String("value", "", "secret value; read from stdin when omitted so it stays out of shell history")
+	fs.Parse(args)
+
+	if err := config.CheckSecretName(*name); err != nil {
+		fatal(err)
+	}
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	plaintext := *value
+	if plaintext == "" {
+		fmt.Fprintf(os.Stderr, "value for %s: ", *name)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fatal(fmt.Errorf("read secret: %w", err))
+		}
+		plaintext = strings.TrimRight(line, "\r\n")
+	}
+	if plaintext == "" {
+		fatal(errors.New("secret value must not be empty"))
+	}
+	keyPath := cfg.SecretKeyPath(*configPath)
+	key, err := secret.LoadOrCreateKey(keyPath)
+	if err != nil {
+		fatal(err)
+	}
+	sealed, err := secret.Encrypt(key, *name, plaintext)
+	if err != nil {
+		fatal(err)
+	}
+	if err := config.SetSecret(*configPath, *name, sealed); err != nil {
+		fatal(err)
+	}
+	fmt.Printf("stored %s in %s (key %s)\n", *name, *configPath, keyPath)
+}
+
+func runSecretList(args []string) {
+	fs := flag.NewFlagSet("secret list", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	names := make([]string, 0, len(cfg.Secrets))
+	for name := range cfg.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state := "plaintext"
+		if secret.IsEncrypted(cfg.Secrets[name]) {
+			state = "encrypted"
+		}
+		fmt.Printf("%s\t%s\n", name, state)
+	}
+}
+
+// runSecretExec decrypts the secrets in memory and runs the given command
+// (usually the upload step) with them in its environment. The plaintext is
+// never written to disk.
+func runSecretExec(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("secret exec", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	fs.Parse(args)
+
+	command := fs.Args()
+	if len(command) == 0 {
+		fatal(errors.New("secret exec: expected a command after the flags, e.g. secret exec -- ./upload.sh"))
+	}
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	secrets, err := secret.Resolve(cfg.SecretKeyPath(*configPath), cfg.Secrets)
+	if err != nil {
+		fatal(err)
+	}
+	env := os.Environ()
+	for name, value := range secrets {
+		env = append(env, SecretEnvPrefix+strings.ToUpper(name)+"="+value)
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			flushTracing()
+			os.Exit(exitErr.ExitCode())
+		}
+		fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"workfield/internal/config"
+	"workfield/internal/service"
+)
+
+// ServiceArgs is inserted before "analyze-daily" in installed units; the
+// multiplexed field binary sets it to "client".
+var ServiceArgs []string
+
+func runService(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fatal(errors.New("expected service install or service uninstall"))
+	}
+	fs := flag.NewFlagSet("service "+args[0], flag.ExitOnError)
+	name := fs.String("name", "field-client-analyze", "systemd unit or scheduled task name")
+	unitDir := fs.String("unit-dir", service.DefaultUnitDir, "directory for systemd units")
+	var configPath, at, user *string
+	var noEnable, printOnly *bool
+	if args[0] == "install" {
+		configPath = fs.String("config", "config.json", "config file path baked into the unit")
+		at = fs.String("at", "00:05", "daily start time (HH:MM); the run analyzes yesterday")
+		user = fs.String("user", "", "run the systemd service as this user (default root)")
+		noEnable = fs.Bool("no-enable", false, "write the units without enabling the timer")
+		printOnly = fs.Bool("print", false, "print the units instead of installing them")
+	}
+	fs.Parse(args[1:])
+	installer := service.Installer{UnitDir: *unitDir}
+
+	switch args[0] {
+	case "install":
+		path, err := filepath.Abs(*configPath)
+		if err != nil {
+			fatal(err)
+		}
+		cfg, err := config.LoadClient(path)
+		if err != nil {
+			fatal(err)
+		}
+		if err := cfg.Validate(); err != nil {
+			fatal(err)
+		}
+		exe, err := service.Executable()
+		if err != nil {
+			fatal(err)
+		}
+		runArgs := append(append([]string(nil), ServiceArgs...), "analyze-daily", "-config", path, "-date", "yesterday")
+		spec := service.Spec{
+			Name:        *name,
+			Description: "field-client daily log analysis (yesterday)",
+			Exec:        exe,
+			Args:        runArgs,
+			WorkingDir:  filepath.Dir(path),
+			User:        *user,
+			At:          *at,
+		}
+		if *printOnly {
+			if err := spec.Validate(); err != nil {
+				fatal(err)
+			}
+			fmt.Print(spec.Units())
+			return
+		}
+		installer.NoEnable = *noEnable
+		files, err := installer.Install(ctx, spec)
+		if err != nil {
+			fatal(err)
+		}
+		for _, file := range files {
+			fmt.Printf("wrote %s\n", file)
+		}
+		fmt.Printf("installed %s\n", *name)
+	case "uninstall":
+		if err := installer.Uninstall(ctx, *name); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("uninstalled %s\n", *name)
+	default:
+		fatal(fmt.Errorf("unknown service action %q: expected install or uninstall", args[0]))
+	}
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/support"
+)
+
+// runSupportBundle writes a diagnostic zip for support tickets. It works
+// with a broken config, which is then reported inside the bundle.
+func runSupportBundle(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	out := fs.String("out", "", "zip to write (default field-client-support-<host>-<time>.zip in the current directory)")
+	logTail := fs.Int64("log-tail", 1<<20, "bytes from the end of the log file to include")
+	since := fs.Duration("since", 72*time.Hour, "how much of the systemd journal to include")
+	fs.Parse(args)
+
+	now := time.Now()
+	bundle := support.Bundle{ConfigPath: *configPath, TailBytes: *logTail, Now: now}
+	if cfg, err := config.LoadClient(*configPath); err != nil {
+		bundle.ConfigErr = err
+	} else {
+		bundle.Config = &cfg
+	}
+	if runtime.GOOS == "linux" {
+		if journalctl, err := exec.LookPath("journalctl"); err == nil {
+			bundle.Journal = []string{journalctl, "--no-pager", "-o", "short-iso",
+				"--since", now.Add(-*since).Format("2006-01-02 15:04:05"), "-u", "field-client*"}
+		}
+	}
+
+	path := *out
+	if path == "" {
+		host, _ := os.Hostname()
+		if host == "" {
+			host = "unknown"
+		}
+		path = fmt.Sprintf("field-client-support-%s-%s.zip", host, now.Format("20060102T150405"))
+	}
+	tmp := path + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		fatal(err)
+	}
+	if err := bundle.Write(ctx, f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		fatal(err)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	fmt.Println(path)
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/logging"
+	"workfield/internal/secret"
+	"workfield/internal/upload"
+	"workfield/internal/webhook"
+)
+
+// runUpload sends the outbox archives to every configured upload target,
+// retrying only the deliveries that failed before. Outside upload_windows
+// it sends nothing, so a frequent timer only uploads inside the windows.
+func runUpload(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	dryRun := fs.Bool("dry-run", false, "list what would be sent without sending")
+	force := fs.Bool("force", false, "send now even outside upload_windows")
+	wait := fs.Bool("wait", false, "outside upload_windows, wait for the next window instead of exiting")
+	applyLogFlags := logFlags(fs)
+	fs.Parse(args)
+
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	applyLogFlags(&cfg)
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	closeLog, err := logging.Setup(cfg.Logging())
+	if err != nil {
+		fatal(err)
+	}
+	defer closeLog()
+	if len(cfg.UploadTargets) == 0 {
+		fatal(errors.New("upload_targets is not configured"))
+	}
+	targets, err := uploadTargets(cfg, *configPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	schedule, err := cfg.UploadSchedule()
+	if err != nil {
+		fatal(err)
+	}
+	if *force {
+		schedule = nil
+	}
+	if now := time.Now(); !*dryRun && !schedule.Open(now) {
+		next := schedule.Next(now)
+		if !*wait {
+			fmt.Printf("outside upload windows; next opens at %s\n", next.Format(time.RFC3339))
+			return
+		}
+		slog.Info("waiting for upload window", "opens", next)
+		select {
+		case <-ctx.Done():
+			fatal(ctx.Err())
+		case <-time.After(time.Until(next)):
+		}
+	}
+
+	started := time.Now()
+	results, err := upload.Run(ctx, cfg.OutboxDir, targets, schedule, *dryRun)
+	if !*dryRun && (len(results) > 0 || err != nil) {
+		clientHook(cfg, *configPath).Send(context.WithoutCancel(ctx), uploadEvent(cfg, results, started, err))
+	}
+	failed := 0
+	for _, result := range results {
+		switch {
+		case *dryRun:
+			fmt.Printf("would send %s to %s\n", result.Archive, result.Target)
+		case result.Err != nil:
+			failed++
+			fmt.Printf("failed %s to %s: %v\n", result.Archive, result.Target, result.Err)
+		default:
+			fmt.Printf("sent %s to %s\n", result.Archive, result.Target)
+		}
+	}
+	if errors.Is(err, upload.ErrWindowClosed) {
+		fmt.Println("upload window closed; the rest is sent in the next window")
+		err = nil
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if failed > 0 {
+		fatal(fmt.Errorf("%d of %d uploads failed; they are retried on the next run", failed, len(results)))
+	}
+}
+
+// uploadEvent reports an upload run: deliveries sent and failed, and the
+// archives it sent anywhere.
+func uploadEvent(cfg config.Client, results []upload.Result, started time.Time, err error) webhook.Event {
+	if errors.Is(err, upload.ErrWindowClosed) {
+		err = nil
+	}
+	e := stepEvent(cfg, webhook.StepUpload, started, err)
+	e.Counts = map[string]int64{"sent": 0, "failed": 0}
+	var sent []string
+	seen := map[string]bool{}
+	for _, result := range results {
+		if result.Err != nil {
+			e.Counts["failed"]++
+			continue
+		}
+		e.Counts["sent"]++
+		if !seen[result.Archive] {
+			seen[result.Archive] = true
+			sent = append(sent, filepath.Join(cfg.OutboxDir, result.Archive))
+		}
+	}
+	if e.Counts["failed"] > 0 && e.Error == "" {
+		e.OK = false
+		e.Error = fmt.Sprintf("%d of %d uploads failed", e.Counts["failed"], len(results))
+	}
+	e.Files = describeFiles(sent...)
+	return e
+}
+
+// uploadTargets resolves the configured targets' secrets.
+func uploadTargets(cfg config.Client, configPath string) ([]upload.Target, error) {
+	secrets, err := secret.Resolve(cfg.SecretKeyPath(configPath), cfg.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]upload.Target, len(cfg.UploadTargets))
+	for i, t := range cfg.UploadTargets {
+		targets[i] = upload.Target{Name: t.Name, URL: t.URL, IdentityFile: t.IdentityFile, Secret: secrets[t.Secret]}
+	}
+	return targets, nil
+}
+
+// undelivered reports archives some upload target has not received yet, so
+// "receipts" keeps them even after the worker's ok.
+func undelivered(cfg config.Client) (func(archive string) bool, error) {
+	if len(cfg.UploadTargets) == 0 {
+		return nil, nil
+	}
+	state, err := upload.LoadState(filepath.Join(cfg.OutboxDir, upload.StateFile))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(cfg.UploadTargets))
+	for i, t := range cfg.UploadTargets {
+		names[i] = t.Name
+	}
+	return func(archive string) bool {
+		return len(state.Pending(archive, names)) > 0
+	}, nil
+}
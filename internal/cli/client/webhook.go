@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/logging"
+	"workfield/internal/secret"
+	"workfield/internal/webhook"
+)
+
+// clientHook is the configured webhook, or the zero Hook when there is
+// none. A secret that cannot be resolved only costs the signature.
+func clientHook(cfg config.Client, configPath string) webhook.Hook {
+	if cfg.WebhookURL == "" {
+		return webhook.Hook{}
+	}
+	hook := webhook.Hook{URL: cfg.WebhookURL}
+	if cfg.WebhookSecret != "" {
+		secrets, err := secret.Resolve(cfg.SecretKeyPath(configPath), cfg.Secrets)
+		if err != nil {
+			slog.Warn("webhook secret not resolved; sending unsigned", "error", err)
+		} else {
+			hook.Secret = secrets[cfg.WebhookSecret]
+		}
+	}
+	return hook
+}
+
+// stepEvent starts the event for step; the caller fills in the outcome.
+func stepEvent(cfg config.Client, step string, started time.Time, err error) webhook.Event {
+	e := webhook.Event{
+		Step:       step,
+		SiteID:     cfg.SiteID,
+		DeviceID:   cfg.DeviceID,
+		DurationMS: time.Since(started).Milliseconds(),
+		OK:         err == nil,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// describeFiles describes each path, skipping (and logging) the ones that
+// cannot be read.
+func describeFiles(paths ...string) []webhook.File {
+	var files []webhook.File
+	for _, path := range paths {
+		file, err := webhook.Describe(path)
+		if err != nil {
+			slog.Warn("webhook file not described", "path", path, "error", err)
+			continue
+		}
+		file.Name = filepath.Base(path)
+		files = append(files, file)
+	}
+	return files
+}
+
+// runNotify reports a step the client does not run itself, i.e. packaging
+// by the site's packager script, to the configured webhook:
+//
+//	field-client notify -config config.yaml -step package -duration 4s outbox/siteA_device01_20240501.zip
+func runNotify(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("notify", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file path (json or yaml)")
+	step := fs.String("step", webhook.StepPackage, "pipeline step the event reports")
+	duration := fs.Duration("duration", 0, "time the step took")
+	failure := fs.String("error", "", "report the step as failed with this message")
+	applyLogFlags := logFlags(fs)
+	fs.Parse(args)
+
+	cfg, err := config.LoadClient(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	applyLogFlags(&cfg)
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	closeLog, err := logging.Setup(cfg.Logging())
+	if err != nil {
+		fatal(err)
+	}
+	defer closeLog()
+	if cfg.WebhookURL == "" {
+		fatal(errors.New("webhook_url is not configured"))
+	}
+	if *failure != "" {
+		err = errors.New(*failure)
+	}
+	e := stepEvent(cfg, *step, time.Now().Add(-*duration), err)
+	e.Files = describeFiles(fs.Args()...)
+	e.Counts = map[string]int64{"files": int64(len(e.Files))}
+	for _, file := range e.Files {
+		e.Counts["bytes"] += file.Bytes
+	}
+	clientHook(cfg, *configPath).Send(ctx, e)
+}
@@ -0,0 +1,123 @@
+// Package simulator implements the field-simulator command line, shared by
+// the field-simulator binary and "field simulator".
+package simulator
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"workfield/internal/archive"
+	"workfield/internal/buildinfo"
+	"workfield/internal/logging"
+	"workfield/internal/simulate"
+	"workfield/internal/timeparse"
+)
+
+// Main runs the simulator with args after the program name and exits on
+// failure.
+func Main(args []string) {
+	fs := flag.NewFlagSet("field-simulator", flag.ExitOnError)
+	siteID := fs.String("site", "siteA", "site id")
+	deviceID := fs.String("device", "device01", "device id")
+	workField := fs.String("work-field", "field-01", "work_field written into payloads")
+	from := fs.String("from", "", "first date in YYYYMMDD")
+	to := fs.String("to", "", "last date in YYYYMMDD (default: same as -from)")
+	interval := fs.Duration("interval", time.Minute, "sampling interval")
+	mappingPath := fs.String("mapping", "", "mapping json to take sensors from (default: built-in WLS/GATE/TEMP/PUMP set)")
+	seed := fs.Int64("seed", 1, "random seed")
+	timeoutRate := fs.Float64("timeout-rate", 0, "probability a request times out")
+	duplicateRate := fs.Float64("duplicate-rate", 0, "probability a response repeats the previous payload")
+	zeroRate := fs.Float64("zero-rate", 0, "probability a response is all zero bytes")
+	drift := fs.Float64("drift", 0, "drift added to sent values per hour")
+	logRoot := fs.String("log-root", "", "write per-sensor logs for analyze-daily here")
+	incoming := fs.String("incoming", "", "write daily archives for the ingest worker here")
+	formatName := fs.String("format", "zip", "archive format for --incoming: zip, tar.gz or tar.zst")
+	version := fs.Bool("version", false, "print the build version and exit")
+	var logCfg logging.Config
+	fs.StringVar(&logCfg.Level, "log-level", "", "log level: debug, info, warn, error")
+	fs.StringVar(&logCfg.Format, "log-format", "", "log format: text or json")
+	fs.Parse(args)
+
+	if *version {
+		fmt.Println(buildinfo.Get().String("field-simulator"))
+		return
+	}
+	if _, err := logging.Setup(logCfg); err != nil {
+		fatal(err)
+	}
+
+	if *from == "" {
+		fatal(errors.New("--from is required (YYYYMMDD)"))
+	}
+	if *to == "" {
+		*to = *from
+	}
+	if *logRoot == "" && *incoming == "" {
+		fatal(errors.New("at least one of --log-root or --incoming is required"))
+	}
+	format, err := archive.ParseFormat(*formatName)
+	if err != nil {
+		fatal(err)
+	}
+	start, err := timeparse.ParseDate(*from)
+	if err != nil {
+		fatal(fmt.Errorf("invalid --from %q: expected YYYYMMDD", *from))
+	}
+	last, err := timeparse.ParseDate(*to)
+	if err != nil {
+		fatal(fmt.Errorf("invalid --to %q: expected YYYYMMDD", *to))
+	}
+
+	cfg := simulate.Config{
+		SiteID:    *siteID,
+		DeviceID:  *deviceID,
+		WorkField: *workField,
+		Start:     start,
+		End:       last.AddDate(0, 0, 1),
+		Interval:  *interval,
+		Seed:      *seed,
+		Format:    format,
+		Faults: simulate.Faults{
+			TimeoutRate:   *timeoutRate,
+			DuplicateRate: *duplicateRate,
+			ZeroRate:      *zeroRate,
+			DriftPerHour:  *drift,
+		},
+	}
+	if *mappingPath != "" {
+		sensors, err := simulate.LoadSensors(*mappingPath)
+		if err != nil {
+			fatal(err)
+		}
+		cfg.Sensors = sensors
+	}
+
+	days := simulate.Generate(cfg)
+	for _, day := range days {
+		slog.Debug("generated day", "date", day.DateString(), "snapshots", len(day.Snapshots), "events", len(day.Events))
+	}
+	if *logRoot != "" {
+		if err := simulate.WriteLogRoot(*logRoot, days); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("wrote logs for %d days to %s\n", len(days), *logRoot)
+	}
+	if *incoming != "" {
+		for _, day := range days {
+			path, err := simulate.WriteArchive(*incoming, cfg, day)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Printf("wrote %s\n", path)
+		}
+	}
+}
+
+func fatal(err error) {
+	slog.Error("exiting", "error", err)
+	os.Exit(1)
+}
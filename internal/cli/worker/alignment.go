@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+)
+
+// runAlignment prints how far each sensor's matched raw observations lie
+// from the snapshots per archive, so the comparison window can be tuned
+// from data instead of guessed.
+func runAlignment(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("alignment", flag.ExitOnError)
+	dbPath := fs.String("db", config.DefaultWorker().DB, "sqlite database path")
+	archive := fs.String("archive", "", "only this archive (its file name)")
+	filter := filterFlags(fs)
+	asJSON := fs.Bool("json", false, "print JSON lines instead of a table")
+	fs.Parse(args)
+	if err := checkFilter(filter); err != nil {
+		fatal(err)
+	}
+
+	db, err := ingest.OpenReadDB(*dbPath, ingest.DBOptions{})
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	report, err := ingest.Alignment(ctx, db, *filter, *archive)
+	if err != nil {
+		fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !*asJSON {
+		fmt.Fprintln(w, "archive\tsensor\twindow\tsnapshots\tmatched\tskew\tp50\tp90\tmax\tbeyond\tbeyond p50")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, entry := range report {
+		if *asJSON {
+			enc.Encode(map[string]any{
+				"site_id":       entry.SiteID,
+				"device_id":     entry.DeviceID,
+				"ingest_file":   entry.IngestFile,
+				"sensor_id":     entry.SensorID,
+				"first_publish": formatStamp(entry.FirstPublish),
+				"window_ms":     entry.Window.Milliseconds(),
+				"snapshots":     entry.Snapshots,
+				"matched":       entry.Matched,
+				"skew_ms":       entry.Skew.Milliseconds(),
+				"abs_p50_ms":    entry.AbsP50.Milliseconds(),
+				"abs_p90_ms":    entry.AbsP90.Milliseconds(),
+				"abs_max_ms":    entry.AbsMax.Milliseconds(),
+				"beyond_window": entry.BeyondWindow,
+				"beyond_p50_ms": entry.BeyondP50.Milliseconds(),
+			})
+			continue
+		}
+		beyondP50 := "-"
+		if entry.BeyondWindow > 0 {
+			beyondP50 = formatOffset(entry.BeyondP50)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%d\t%s\n", entry.IngestFile, entry.SensorID, entry.Window,
+			entry.Snapshots, entry.Matched, formatOffset(entry.Skew), formatOffset(entry.AbsP50), formatOffset(entry.AbsP90),
+			formatOffset(entry.AbsMax), entry.BeyondWindow, beyondP50)
+	}
+	w.Flush()
+}
+
+func formatOffset(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
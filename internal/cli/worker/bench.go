@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+	"workfield/internal/simulate"
+)
+
+// runBench ingests a generated corpus into a throwaway database and reports
+// per-stage throughput, so releases can be compared on the target hardware.
+func runBench(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	days := fs.Int("days", 1, "days of data per device")
+	devices := fs.Int("devices", 2, "number of simulated devices (one archive per device and day)")
+	interval := fs.Duration("interval", 10*time.Second, "sampling interval of the generated data")
+	mappingPath := fs.String("mapping", "", "mapping json for sensors (default: simulator's built-in set)")
+	seed := fs.Int64("seed", 1, "random seed")
+	hashChain := fs.Bool("hash-chain", false, "also maintain the comparison hash chain")
+	keep := fs.Bool("keep", false, "keep the corpus and database instead of deleting them")
+	fs.Parse(args)
+
+	if *days <= 0 || *devices <= 0 {
+		fatal(errors.New("--days and --devices must be positive"))
+	}
+
+	sensors := simulate.DefaultSensors
+	if *mappingPath != "" {
+		var err error
+		if sensors, err = simulate.LoadSensors(*mappingPath); err != nil {
+			fatal(err)
+		}
+	}
+	mapping := map[string]ingest.SensorMapping{}
+	for _, sensor := range sensors {
+		mapping[strconv.Itoa(sensor.ID)] = ingest.SensorMapping{
+			SensorID: sensor.SensorID,
+			Type:     sensor.Type,
+			Field:    sensor.Field,
+			JSONType: sensor.JSONType,
+		}
+	}
+
+	root, err := os.MkdirTemp("", "field-ingest-bench-")
+	if err != nil {
+		fatal(err)
+	}
+	if *keep {
+		fmt.Printf("corpus kept in %s\n", root)
+	} else {
+		defer os.RemoveAll(root)
+	}
+	incoming := filepath.Join(root, "incoming")
+	opts := ingest.Options{
+		WorkDir:   filepath.Join(root, "work"),
+		DoneDir:   filepath.Join(root, "done"),
+		Window:    time.Duration(config.DefaultWorker().WindowSeconds) * time.Second,
+		HashChain: *hashChain,
+		Stats:     &ingest.Stats{},
+	}
+	for _, dir := range []string{incoming, opts.WorkDir, opts.DoneDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fatal(err)
+		}
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	genStart := time.Now()
+	archives := 0
+	for i := 0; i < *devices; i++ {
+		cfg := simulate.Config{
+			SiteID:    "bench",
+			DeviceID:  fmt.Sprintf("device%02d", i+1),
+			WorkField: "bench",
+			Sensors:   sensors,
+			Start:     start,
+			End:       start.AddDate(0, 0, *days),
+			Interval:  *interval,
+			Seed:      *seed + int64(i),
+		}
+		for _, day := range simulate.Generate(cfg) {
+			if _, err := simulate.WriteArchive(incoming, cfg, day); err != nil {
+				fatal(err)
+			}
+			archives++
+		}
+	}
+	fmt.Printf("generated %d archives in %s\n", archives, time.Since(genStart).Round(time.Millisecond))
+
+	db, err := ingest.OpenDB(filepath.Join(root, "db", "bench.sqlite3"), ingest.DBOptions{})
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	runStart := time.Now()
+	failures, err := ingest.ProcessDir(ctx, incoming, db, mapping, opts)
+	if err != nil {
+		fatal(err)
+	}
+	for _, failure := range failures {
+		slog.Error("archive failed", "error", failure)
+	}
+	elapsed := time.Since(runStart)
+	fmt.Printf("ingested %d archives in %s (%.1f archives/s)\n\n", archives-len(failures), elapsed.Round(time.Millisecond), perSecond(int64(archives-len(failures)), elapsed))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "stage\ttime\tlines\tlines/s\trows\trows/s\t")
+	for _, name := range ingest.StageNames() {
+		stage := opts.Stats.Stage(name)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.0f\t%d\t%.0f\t\n", name, stage.Duration.Round(time.Millisecond),
+			stage.Lines, perSecond(stage.Lines, stage.Duration), stage.Rows, perSecond(stage.Rows, stage.Duration))
+	}
+	w.Flush()
+	fmt.Println("\ncompare: lines are comparisons made")
+}
+
+func perSecond(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
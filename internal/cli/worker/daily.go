@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+)
+
+// filterFlags registers the report filters shared by the query commands.
+func filterFlags(fs *flag.FlagSet) *ingest.Filter {
+	filter := &ingest.Filter{}
+	fs.StringVar(&filter.SiteID, "site", "", "only this site id")
+	fs.StringVar(&filter.DeviceID, "device", "", "only this device id")
+	fs.StringVar(&filter.WorkField, "work-field", "", "only this work field")
+	fs.StringVar(&filter.From, "from", "", "first day, YYYY-MM-DD")
+	fs.StringVar(&filter.To, "to", "", "last day, YYYY-MM-DD")
+	return filter
+}
+
+func checkFilter(filter *ingest.Filter) error {
+	for name, day := range map[string]string{"from": filter.From, "to": filter.To} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			return fmt.Errorf("--%s %q is not a YYYY-MM-DD day", name, day)
+		}
+	}
+	return nil
+}
+
+// runDaily prints per-work-field daily rollups of snapshots and comparison
+// results, with the device's controller events of the day next to them, or
+// with -hours the results of every hour that had comparisons.
+func runDaily(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("daily", flag.ExitOnError)
+	dbPath := fs.String("db", config.DefaultWorker().DB, "sqlite database path")
+	filter := filterFlags(fs)
+	asJSON := fs.Bool("json", false, "print JSON lines instead of a table")
+	hours := fs.Bool("hours", false, "print the comparison results per hour of the day instead of per day")
+	fs.Parse(args)
+	if err := checkFilter(filter); err != nil {
+		fatal(err)
+	}
+
+	db, err := ingest.OpenReadDB(*dbPath, ingest.DBOptions{})
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	summaries, err := ingest.DailySummary(ctx, db, *filter)
+	if err != nil {
+		fatal(err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, s := range summaries {
+			enc.Encode(s)
+		}
+		return
+	}
+	if *hours {
+		printDailyHours(summaries)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "day\tsite\tdevice\twork field\tsnapshots\tcomparisons\tmatch\tmismatch\tmissing raw\tmissing sent\tcontroller events")
+	for _, s := range summaries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", s.Day, s.SiteID, s.DeviceID, orDash(s.WorkField),
+			s.Snapshots, s.Comparisons, s.Match, s.Mismatch, s.MissingRaw, s.MissingSent, eventKinds(s.ControllerEventKinds))
+	}
+	w.Flush()
+}
+
+// printDailyHours prints one row per hour with comparisons of each rollup.
+func printDailyHours(summaries []ingest.DailyFieldSummary) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "day\tsite\tdevice\twork field\thour\tcomparisons\tmatch\tmismatch\tmissing raw\tmissing sent")
+	for _, s := range summaries {
+		for hour, h := range s.Hours {
+			if h.Comparisons() == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%02d\t%d\t%d\t%d\t%d\t%d\n", s.Day, s.SiteID, s.DeviceID, orDash(s.WorkField),
+				hour, h.Comparisons(), h.Match, h.Mismatch, h.MissingRaw, h.MissingSent)
+		}
+	}
+	w.Flush()
+}
+
+// eventKinds lists controller event counts by kind, e.g.
+// "door_open 2, power_loss 1", or "-" when there were none.
+func eventKinds(kinds map[string]int64) string {
+	if len(kinds) == 0 {
+		return "-"
+	}
+	names := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, kind := range names {
+		parts[i] = fmt.Sprintf("%s %d", kind, kinds[kind])
+	}
+	return strings.Join(parts, ", ")
+}
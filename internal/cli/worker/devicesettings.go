@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+)
+
+// runDeviceSettings lists, sets or removes the settings that override the
+// worker's options for one site or device. They take effect from the next
+// archive of that device; the worker is not restarted.
+func runDeviceSettings(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "expected: device-settings list|set|unset [flags]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("device-settings "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", "", "worker config file (json or yaml)")
+	siteID := fs.String("site", "", "site id")
+	deviceID := fs.String("device", "", "device id (default: every device of the site)")
+	asJSON := fs.Bool("json", false, "list as JSON lines")
+	window := fs.Int("window", 0, "comparison window in seconds")
+	bucket := fs.Int("compare-bucket-minutes", 0, "compare bucket minutes (0 compares single values)")
+	aggregate := fs.String("compare-aggregate", "", "bucket aggregate")
+	dedupe := fs.String("snapshot-dedupe", "", "snapshot dedupe policy: skip, supersede or keep-all")
+	days := fs.Int("raw-observation-days", 0, "store raw observations and keep them this many days (0 stores none)")
+	publish := fs.Bool("publish", true, "publish the device's results to the event bus")
+	fs.Parse(args[1:])
+	// Only the flags given are stored; the others keep their stored value.
+	var s ingest.DeviceSettings
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "window":
+			s.WindowSeconds = window
+		case "compare-bucket-minutes":
+			s.CompareBucketMinutes = bucket
+		case "compare-aggregate":
+			s.CompareAggregate = aggregate
+		case "snapshot-dedupe":
+			s.SnapshotDedupe = dedupe
+		case "raw-observation-days":
+			s.RawObservationDays = days
+		case "publish":
+			s.Publish = publish
+		}
+	})
+
+	cfg, err := config.LoadWorker(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	db, err := ingest.OpenDB(cfg.DB, dbOptions(cfg))
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "list":
+		list, err := ingest.ListDeviceSettings(ctx, db)
+		if err != nil {
+			fatal(err)
+		}
+		printDeviceSettings(list, *asJSON)
+	case "set":
+		if *siteID == "" {
+			fatal(errors.New("device-settings set: -site is required"))
+		}
+		s.SiteID, s.DeviceID = *siteID, *deviceID
+		if err := ingest.SetDeviceSettings(ctx, db, s); err != nil {
+			fatal(err)
+		}
+	case "unset":
+		if *siteID == "" {
+			fatal(errors.New("device-settings unset: -site is required"))
+		}
+		ok, err := ingest.DeleteDeviceSettings(ctx, db, *siteID, *deviceID)
+		if err != nil {
+			fatal(err)
+		}
+		if !ok {
+			fatal(fmt.Errorf("device-settings unset: no settings for %s/%s", *siteID, *deviceID))
+		}
+	default:
+		fatal(fmt.Errorf("device-settings: unknown action %q", args[0]))
+	}
+}
+
+func printDeviceSettings(list []ingest.DeviceSettings, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, s := range list {
+			enc.Encode(s)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SITE\tDEVICE\tWINDOW\tBUCKET\tAGGREGATE\tDEDUPE\tRAW_DAYS\tPUBLISH\tUPDATED")
+	for _, s := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.SiteID, orDash(s.DeviceID),
+			intOrDash(s.WindowSeconds), intOrDash(s.CompareBucketMinutes), stringOrDash(s.CompareAggregate),
+			stringOrDash(s.SnapshotDedupe), intOrDash(s.RawObservationDays), boolOrDash(s.Publish), formatStamp(s.UpdatedAt))
+	}
+	w.Flush()
+}
+
+func intOrDash(value *int) string {
+	if value == nil {
+		return "-"
+	}
+	return strconv.Itoa(*value)
+}
+
+func stringOrDash(value *string) string {
+	if value == nil {
+		return "-"
+	}
+	return orDash(*value)
+}
+
+func boolOrDash(value *bool) string {
+	if value == nil {
+		return "-"
+	}
+	return strconv.FormatBool(*value)
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+)
+
+// runExport streams the comparison rows a filter selects as JSON lines,
+// to stdout or -o, without paging through them.
+func runExport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := fs.String("db", config.DefaultWorker().DB, "sqlite database path")
+	scope := filterFlags(fs)
+	var filter ingest.ExportFilter
+	fs.StringVar(&filter.SensorID, "sensor", "", "only this sensor id")
+	fs.StringVar(&filter.Result, "result", "", "only this result: MATCH, MISMATCH, MISSING_RAW or MISSING_SENT")
+	fs.IntVar(&filter.Limit, "limit", 0, "stop after this many rows (0 = all)")
+	out := fs.String("o", "", "write to this file instead of stdout")
+	fs.Parse(args)
+	if err := checkFilter(scope); err != nil {
+		fatal(err)
+	}
+	filter.Filter = *scope
+
+	db, err := ingest.OpenReadDB(*dbPath, ingest.DBOptions{})
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if *out != "" {
+		if file, err = os.Create(*out); err != nil {
+			fatal(err)
+		}
+		w = file
+	}
+	buf := bufio.NewWriter(w)
+	n, err := ingest.ExportComparisons(ctx, db, filter, buf)
+	if flushErr := buf.Flush(); err == nil {
+		err = flushErr
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d rows\n", n)
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+)
+
+// runFetchArchive copies the original archives of a site, device and day
+// out of the done directory or wherever done retention moved them, so they
+// can be handed to an investigator.
+func runFetchArchive(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("fetch-archive", flag.ExitOnError)
+	configPath := fs.String("config", "", "worker config file (json or yaml)")
+	siteID := fs.String("site", "", "site id (required)")
+	deviceID := fs.String("device", "", "only this device id")
+	day := fs.String("date", "", "only archives of this day, YYYY-MM-DD")
+	outDir := fs.String("o", ".", "directory to write the archives to; - writes the single match to stdout")
+	list := fs.Bool("list", false, "list the matching archives and where they are kept instead of fetching them")
+	asJSON := fs.Bool("json", false, "with -list, print JSON lines")
+	fs.Parse(args)
+	if *siteID == "" {
+		fatal(errors.New("fetch-archive: -site is required"))
+	}
+
+	cfg, err := config.LoadWorker(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	names, err := cfg.ArchiveNames()
+	if err != nil {
+		fatal(err)
+	}
+	db, err := ingest.OpenReadDB(cfg.DB, dbOptions(cfg))
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	found, err := ingest.FindDoneArchives(ctx, db, cfg.Done, names, *siteID, *deviceID, *day)
+	if err != nil {
+		fatal(err)
+	}
+	if len(found) == 0 {
+		fatal(fmt.Errorf("fetch-archive: no ingested archive of %s matches", *siteID))
+	}
+	if *list {
+		printDoneArchives(found, *asJSON)
+		return
+	}
+	if *outDir == "-" {
+		if len(found) != 1 {
+			fatal(fmt.Errorf("fetch-archive: %d archives match; narrow with -device and -date to write to stdout", len(found)))
+		}
+		if err := ingest.FetchArchive(ctx, found[0], os.Stdout); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fatal(err)
+	}
+	var errs []error
+	for _, a := range found {
+		dest := filepath.Join(*outDir, a.Name)
+		if err := fetchTo(ctx, a, dest); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("%s\t%s\n", dest, a.Location)
+	}
+	if err := errors.Join(errs...); err != nil {
+		fatal(err)
+	}
+}
+
+// fetchTo writes a to dest through a .partial file, so a failed or
+// mismatched copy leaves nothing under dest.
+func fetchTo(ctx context.Context, a ingest.DoneArchive, dest string) error {
+	partial := dest + ".partial"
+	f, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	err = ingest.FetchArchive(ctx, a, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, dest)
+	}
+	if err != nil {
+		os.Remove(partial)
+	}
+	return err
+}
+
+func printDoneArchives(found []ingest.DoneArchive, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, a := range found {
+			enc.Encode(a)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ARCHIVE\tSITE\tDEVICE\tDATE\tLOCATION")
+	for _, a := range found {
+		location := orDash(a.Location)
+		if a.PurgedAt != "" {
+			location = "purged " + a.PurgedAt
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Name, a.SiteID, a.DeviceID, orDash(a.Date), location)
+	}
+	w.Flush()
+}
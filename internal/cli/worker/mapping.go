@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+)
+
+func runMapping(args []string) {
+	if len(args) < 1 || args[0] != "lint" {
+		fmt.Fprintln(os.Stderr, "expected: mapping lint [flags] mapping.json")
+		os.Exit(2)
+	}
+	runMappingLint(args[1:])
+}
+
+// runMappingLint checks a mapping file against a recent archive and lists
+// dead or misconfigured entries. It exits 1 when an entry has a problem;
+// payload ids missing from the mapping are reported but do not fail.
+func runMappingLint(args []string) {
+	fs := flag.NewFlagSet("mapping lint", flag.ExitOnError)
+	archivePath := fs.String("archive", "", "archive to check against (default: the newest archive in -dir)")
+	dir := fs.String("dir", config.DefaultWorker().Done, "directory to take the newest archive from")
+	asJSON := fs.Bool("json", false, "print JSON lines instead of text")
+	configPath := fs.String("config", "", "worker config file, for payload_versions")
+	fs.Parse(args)
+
+	mappingPath := config.DefaultWorker().Mapping
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		mappingPath = fs.Arg(0)
+	default:
+		fatal(errors.New("mapping lint: expected one mapping file"))
+	}
+	mapping, err := ingest.LoadMapping(mappingPath)
+	if err != nil {
+		fatal(err)
+	}
+	cfg, err := config.LoadWorker(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	parsers, err := payloadParsers(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	zipPath := *archivePath
+	if zipPath == "" {
+		names, err := cfg.ArchiveNames()
+		if err != nil {
+			fatal(err)
+		}
+		if zipPath, err = ingest.LatestZip(*dir, names); err != nil {
+			fatal(err)
+		}
+		if zipPath == "" {
+			fatal(fmt.Errorf("mapping lint: no archive in %s; pass -archive", *dir))
+		}
+	}
+
+	findings, err := ingest.LintMapping(zipPath, mapping, parsers)
+	if err != nil {
+		fatal(err)
+	}
+	failed := false
+	enc := json.NewEncoder(os.Stdout)
+	if !*asJSON {
+		fmt.Printf("checked %d entries against %s\n", len(mapping), zipPath)
+	}
+	for _, finding := range findings {
+		if finding.Kind != ingest.LintUnmapped {
+			failed = true
+		}
+		if *asJSON {
+			enc.Encode(map[string]string{
+				"id":        finding.ID,
+				"sensor_id": finding.SensorID,
+				"kind":      finding.Kind,
+				"message":   finding.Message,
+			})
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\n", finding.ID, finding.Kind, finding.Message)
+	}
+	if failed {
+		flushTracing()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"workfield/internal/ingest"
+	"workfield/internal/metrics"
+)
+
+// logProgress logs where the run is every interval until the returned stop
+// function is called. Nothing is logged before the first archive starts.
+func logProgress(progress *ingest.Progress, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				r := progress.Report()
+				if r.Total == 0 || r.Finished {
+					continue
+				}
+				slog.Info("ingest progress", "done", r.Done, "total", r.Total, "failed", r.Failed,
+					"archive", r.Current, "phase", r.Phase, "elapsed", r.Elapsed.Round(time.Second), "eta", r.ETA.Round(time.Second))
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// statusHandler serves the progress report as JSON.
+func statusHandler(progress *ingest.Progress) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(progress.Report())
+	})
+}
+
+// incomingFamilies reports the archives waiting in dir and the age of the
+// oldest, the ingest lag an alert watches.
+func incomingFamilies(dir string) []metrics.Family {
+	zips, err := ingest.ListZipFiles(dir)
+	if err != nil {
+		slog.Debug("incoming not listed for metrics", "dir", dir, "error", err)
+		return nil
+	}
+	var oldest time.Time
+	for _, path := range zips {
+		if info, err := os.Stat(path); err == nil && (oldest.IsZero() || info.ModTime().Before(oldest)) {
+			oldest = info.ModTime()
+		}
+	}
+	var age float64
+	if !oldest.IsZero() {
+		age = time.Since(oldest).Seconds()
+	}
+	return []metrics.Family{
+		{Name: "field_worker_incoming_archives", Help: "Archives waiting in the incoming directory.",
+			Samples: []metrics.Sample{{Value: float64(len(zips))}}},
+		{Name: "field_worker_incoming_oldest_age_seconds", Help: "Age of the oldest archive waiting in incoming, 0 when none wait.",
+			Samples: []metrics.Sample{{Value: age}}},
+	}
+}
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+	"workfield/internal/s3"
+)
+
+// doneStore is where done archives are copied before they are removed, or
+// nil when they are simply deleted.
+func doneStore(cfg config.Worker) (ingest.RetentionStore, error) {
+	switch {
+	case cfg.DoneArchiveTo == "":
+		return nil, nil
+	case strings.HasPrefix(cfg.DoneArchiveTo, "s3://"):
+		client, err := s3.FromURL(cfg.DoneArchiveTo)
+		if err != nil {
+			return nil, err
+		}
+		return ingest.S3Store{Client: client}, nil
+	}
+	return ingest.DirStore(cfg.DoneArchiveTo), nil
+}
+
+// applyRetention archives or deletes done archives past done_retention_days
+// and logs each one.
+func applyRetention(ctx context.Context, db *sql.DB, cfg config.Worker, dryRun bool) ([]ingest.RetainedArchive, error) {
+	store, err := doneStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	names, err := cfg.ArchiveNames()
+	if err != nil {
+		return nil, err
+	}
+	maxAge := time.Duration(cfg.DoneRetentionDays) * 24 * time.Hour
+	retained, err := ingest.RetainDone(ctx, db, cfg.Done, names, maxAge, store, time.Now(), dryRun)
+	for _, r := range retained {
+		if !dryRun {
+			slog.Info("done archive retired", "archive", r.Name, "action", r.Action, "location", r.Location)
+		}
+	}
+	return retained, err
+}
+
+// runRetain applies the done retention policy once, outside an ingest run.
+func runRetain(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("retain", flag.ExitOnError)
+	configPath := fs.String("config", "", "worker config file (json or yaml)")
+	days := fs.Int("days", -1, "retire done archives older than this many days (overrides done_retention_days)")
+	archiveTo := fs.String("archive-to", "", "copy to this directory or s3://bucket/prefix before removing (overrides done_archive_to)")
+	dryRun := fs.Bool("dry-run", false, "list what would be retired without touching it")
+	fs.Parse(args)
+
+	cfg, err := config.LoadWorker(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if *days >= 0 {
+		cfg.DoneRetentionDays = *days
+	}
+	if *archiveTo != "" {
+		cfg.DoneArchiveTo = *archiveTo
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	if cfg.DoneRetentionDays == 0 {
+		fatal(fmt.Errorf("done_retention_days is not set; pass -days"))
+	}
+	db, err := ingest.OpenDB(cfg.DB, dbOptions(cfg))
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	retained, err := applyRetention(ctx, db, cfg, *dryRun)
+	for _, r := range retained {
+		fmt.Printf("%s\t%s\t%s\n", r.Name, r.Action, orDash(r.Location))
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
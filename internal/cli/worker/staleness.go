@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"workfield/internal/config"
+	"workfield/internal/ingest"
+)
+
+// runStaleness lists when each sensor last delivered valid data, so dead
+// sensors and configured-but-removed channels surface in a weekly report.
+func runStaleness(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("staleness", flag.ExitOnError)
+	dbPath := fs.String("db", config.DefaultWorker().DB, "sqlite database path")
+	mappingPath := fs.String("mapping", config.DefaultWorker().Mapping, "sensor mapping json")
+	minDays := fs.Int("min-days", 0, "only list sensors without valid data for at least this many days (never-valid sensors are always listed)")
+	filter := filterFlags(fs)
+	asJSON := fs.Bool("json", false, "print JSON lines instead of a table")
+	fs.Parse(args)
+	if err := checkFilter(filter); err != nil {
+		fatal(err)
+	}
+
+	mapping, err := ingest.LoadMapping(*mappingPath)
+	if err != nil {
+		fatal(err)
+	}
+	db, err := ingest.OpenReadDB(*dbPath, ingest.DBOptions{})
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	report, err := ingest.Staleness(ctx, db, mapping, *filter, time.Now())
+	if err != nil {
+		fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !*asJSON {
+		fmt.Fprintln(w, "site\tdevice\tsensor\tlast seen\tlast valid\tdays\tnote")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, entry := range report {
+		if entry.DaysSinceValid >= 0 && entry.DaysSinceValid < *minDays {
+			continue
+		}
+		days, note := fmt.Sprint(entry.DaysSinceValid), ""
+		if entry.DaysSinceValid < 0 {
+			days = "never"
+		}
+		if !entry.Configured {
+			note = "not in mapping"
+		}
+		if *asJSON {
+			enc.Encode(map[string]any{
+				"site_id":          entry.SiteID,
+				"device_id":        entry.DeviceID,
+				"sensor_id":        entry.SensorID,
+				"configured":       entry.Configured,
+				"last_seen":        formatStamp(entry.LastSeen),
+				"last_valid":       formatStamp(entry.LastValid),
+				"days_since_valid": entry.DaysSinceValid,
+			})
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.SiteID, entry.DeviceID, entry.SensorID,
+			orDash(formatStamp(entry.LastSeen)), orDash(formatStamp(entry.LastValid)), days, note)
+	}
+	w.Flush()
+}
+
+func formatStamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
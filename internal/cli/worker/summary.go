@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"workfield/internal/ingest"
+)
+
+// summaryRows is the print order of RunSummary.Rows.
+var summaryRows = []string{"events", "snapshots", "comparisons", "rejected"}
+
+// printSummary writes the end-of-run picture to w (stdout, or stderr when
+// stdout carries read-only results) for people and wrapper scripts.
+func printSummary(w io.Writer, summary ingest.RunSummary) {
+	fmt.Fprintf(w, "archives: %d processed, %d failed, %d skipped\n", summary.Processed, summary.Failed, summary.Skipped)
+	fmt.Fprint(w, "rows:")
+	for i, name := range summaryRows {
+		sep := ","
+		if i == 0 {
+			sep = ""
+		}
+		fmt.Fprintf(w, "%s %s %d", sep, name, summary.Rows[name])
+	}
+	fmt.Fprintf(w, "\nrows/sec: %.0f\n", summary.RowsPerSec)
+	fmt.Fprintf(w, "mismatches: %d\n", summary.Mismatches)
+	if summary.Duplicates > 0 {
+		fmt.Fprintf(w, "already ingested (moved to done): %d\n", summary.Duplicates)
+	}
+	if n := summary.Rows["unexpected_work_field"]; n > 0 {
+		fmt.Fprintf(w, "unexpected work_field snapshots: %d\n", n)
+	}
+}
+
+// writeSummary stores summary as JSON at path, renamed into place so a
+// script polling the file never reads a partial one.
+func writeSummary(path string, summary ingest.RunSummary) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	partial := path + ".partial"
+	if err := os.WriteFile(partial, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(partial, path)
+}
@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"workfield/internal/transfer"
+)
+
+// runUSBImport verifies archives carried on removable media against their
+// transfer manifests, copies the intact ones into incoming and ingests.
+// It takes the worker flags; the arguments are manifests or media
+// directories. Missing, damaged and unlisted archives are printed and make
+// the command fail after the ingest.
+func runUSBImport(ctx context.Context, args []string) {
+	cfg, paths := parseWorkerFlags(args)
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	if len(paths) == 0 {
+		fatal(errors.New("usb-import: expected transfer manifests or the media directory after the flags"))
+	}
+	manifests, err := transfer.Manifests(paths)
+	if err != nil {
+		fatal(err)
+	}
+	if len(manifests) == 0 {
+		fatal(fmt.Errorf("usb-import: no %s*%s in %v", transfer.ManifestPrefix, transfer.ManifestSuffix, paths))
+	}
+	report, err := transfer.Verify(manifests)
+	if err != nil {
+		fatal(err)
+	}
+	for _, problem := range report.Problems {
+		fmt.Printf("discrepancy %s\n", problem)
+	}
+
+	var archives []string
+	if cfg.ReadOnly {
+		// Read-only runs check the copies in place.
+		archives = report.Verified
+	} else {
+		if err := os.MkdirAll(cfg.Incoming, 0o755); err != nil {
+			fatal(err)
+		}
+		for _, path := range report.Verified {
+			dst := filepath.Join(cfg.Incoming, filepath.Base(path))
+			if _, err := transfer.CopyVerified(path, dst); err != nil {
+				fatal(err)
+			}
+		}
+		fmt.Printf("imported %d archives into %s\n", len(report.Verified), cfg.Incoming)
+	}
+	if len(report.Verified) > 0 {
+		ingestArchives(ctx, cfg, archives)
+	}
+	if len(report.Problems) > 0 {
+		fatal(fmt.Errorf("usb-import: %d discrepancies between the media and its manifests", len(report.Problems)))
+	}
+}
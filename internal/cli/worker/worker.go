@@ -0,0 +1,723 @@
+// Package worker implements the field-ingest-worker command line, shared by
+// the field-ingest-worker binary and "field worker".
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"workfield/internal/archivename"
+	"workfield/internal/buildinfo"
+	"workfield/internal/config"
+	"workfield/internal/debugserver"
+	"workfield/internal/decoder"
+	"workfield/internal/ingest"
+	"workfield/internal/logging"
+	"workfield/internal/metrics"
+	"workfield/internal/migrate"
+	"workfield/internal/publish"
+	"workfield/internal/receipt"
+	"workfield/internal/timeparse"
+	"workfield/internal/tracing"
+)
+
+// Main runs the command with args after the program name and exits on
+// failure. Without a subcommand it ingests the incoming directory.
+func Main(args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownTracing = tracing.Setup("field-ingest-worker")
+	defer flushTracing()
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "version", "-version", "--version":
+			fmt.Println(buildinfo.Get().String("field-ingest-worker"))
+			return
+		case "verify-chain":
+			runVerifyChain(ctx, args[1:])
+			return
+		case "purge":
+			runPurge(ctx, args[1:])
+			return
+		case "import-legacy":
+			runImportLegacy(ctx, args[1:])
+			return
+		case "bench":
+			runBench(ctx, args[1:])
+			return
+		case "service":
+			runService(ctx, args[1:])
+			return
+		case "staleness":
+			runStaleness(ctx, args[1:])
+			return
+		case "daily":
+			runDaily(ctx, args[1:])
+			return
+		case "alignment":
+			runAlignment(ctx, args[1:])
+			return
+		case "retain":
+			runRetain(ctx, args[1:])
+			return
+		case "mapping":
+			runMapping(args[1:])
+			return
+		case "usb-import":
+			runUSBImport(ctx, args[1:])
+			return
+		case "export":
+			runExport(ctx, args[1:])
+			return
+		case "fetch-archive":
+			runFetchArchive(ctx, args[1:])
+			return
+		case "device-settings":
+			runDeviceSettings(ctx, args[1:])
+			return
+		}
+	}
+	runIngest(ctx, args)
+}
+
+func runIngest(ctx context.Context, args []string) {
+	cfg, archives := parseWorkerFlags(args)
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	if len(archives) > 0 && !cfg.ReadOnly {
+		fatal(errors.New("archives can only be named with -read-only; otherwise the incoming directory is ingested"))
+	}
+	if cfg.MigrateOnly {
+		migrateOnly(ctx, cfg)
+		return
+	}
+	if cfg.LastRuns > 0 {
+		lastRuns(ctx, cfg)
+		return
+	}
+	ingestArchives(ctx, cfg, archives)
+}
+
+// dbOptions is how cfg opens the database.
+func dbOptions(cfg config.Worker) ingest.DBOptions {
+	return ingest.DBOptions{
+		BusyTimeout: time.Duration(cfg.BusyTimeoutSeconds) * time.Second,
+		Synchronous: strings.ToLower(cfg.DBSynchronous),
+		Pragmas:     cfg.DBPragmas,
+	}
+}
+
+// migrateOnly brings the database schema up to date and exits, so a
+// deployment can migrate before the new workers start ingesting.
+func migrateOnly(ctx context.Context, cfg config.Worker) {
+	db, err := ingest.OpenDB(cfg.DB, dbOptions(cfg))
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+	history, err := migrate.History(ctx, db)
+	if err != nil {
+		fatal(err)
+	}
+	for _, entry := range history {
+		fmt.Printf("%d\t%s\t%s\n", entry.Version, entry.Name, entry.AppliedAt.Format(time.RFC3339))
+	}
+	fmt.Printf("schema version %d\n", ingest.SchemaVersion())
+}
+
+// lastRuns prints the latest recorded worker runs and the errors they
+// logged, newest first.
+func lastRuns(ctx context.Context, cfg config.Worker) {
+	db, err := ingest.OpenReadDB(cfg.DB, dbOptions(cfg))
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+	runs, err := ingest.LastRuns(ctx, db, cfg.LastRuns)
+	if err != nil {
+		fatal(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "run\tstarted\tduration\tarchives\tprocessed\tfailed\tskipped\tduplicates\tsnapshots\tcomparisons\tmismatches\terrors\tversion")
+	for _, run := range runs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", run.ID, run.StartedAt.Format(time.RFC3339),
+			(time.Duration(run.DurationMS) * time.Millisecond).Round(time.Second), run.Archives, run.Processed, run.Failed, run.Skipped,
+			run.Duplicates, run.Rows["snapshots"], run.Rows["comparisons"], run.Mismatches, run.ErrorCount, run.WorkerVersion)
+	}
+	w.Flush()
+	for _, run := range runs {
+		for _, message := range run.Errors {
+			fmt.Printf("run %d: %s\n", run.ID, message)
+		}
+		if more := run.ErrorCount - len(run.Errors); more > 0 {
+			fmt.Printf("run %d: %d more errors not kept\n", run.ID, more)
+		}
+	}
+}
+
+// ingestArchives runs one ingest with the validated cfg: the named archives
+// in a read-only run, otherwise the incoming directory.
+func ingestArchives(ctx context.Context, cfg config.Worker, archives []string) {
+	started := time.Now()
+	closeLog, err := logging.Setup(cfg.Logging())
+	if err != nil {
+		fatal(err)
+	}
+	defer closeLog()
+	sensorMetrics := &ingest.SensorMetrics{}
+	runMetrics := &ingest.RunMetrics{}
+	progress := &ingest.Progress{}
+	if cfg.PprofAddr != "" {
+		server, err := debugserver.Start(cfg.PprofAddr)
+		if err != nil {
+			fatal(err)
+		}
+		defer server.Close()
+		slog.Info("pprof listening", "url", "http://"+server.Addr()+"/debug/pprof/")
+	}
+	if cfg.MetricsAddr != "" && !cfg.ReadOnly {
+		server, err := metrics.Start(cfg.MetricsAddr, func() []metrics.Family {
+			families := append(runMetrics.Families(), incomingFamilies(cfg.Incoming)...)
+			return append(families, sensorMetrics.Families()...)
+		})
+		if err != nil {
+			fatal(err)
+		}
+		defer server.Close()
+		server.Handle("/status", statusHandler(progress))
+		slog.Info("metrics listening", "url", "http://"+server.Addr()+"/metrics")
+	}
+
+	mapping, err := ingest.LoadMapping(cfg.Mapping)
+	if err != nil {
+		fatal(err)
+	}
+	var allowlist ingest.Allowlist
+	if cfg.Allowlist != "" {
+		if allowlist, err = ingest.LoadAllowlist(cfg.Allowlist); err != nil {
+			fatal(err)
+		}
+	}
+
+	// Read-only runs extract into and write a scratch tree that is removed
+	// afterwards; the configured work, done and database stay untouched.
+	// The device settings are still read from the configured database.
+	var settingsDB *sql.DB
+	if cfg.ReadOnly {
+		settingsDB, err = ingest.OpenReadDB(cfg.DB, dbOptions(cfg))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			slog.Info("no database yet; device settings not applied", "db", cfg.DB)
+		case err != nil:
+			fatal(err)
+		default:
+			defer settingsDB.Close()
+		}
+		scratch, err := os.MkdirTemp("", "field-ingest-read-only-")
+		if err != nil {
+			fatal(err)
+		}
+		defer os.RemoveAll(scratch)
+		cfg.Work = filepath.Join(scratch, "work")
+		cfg.DB = filepath.Join(scratch, "scratch.sqlite3")
+	}
+	if err := os.MkdirAll(cfg.Work, 0o755); err != nil {
+		fatal(err)
+	}
+	if !cfg.ReadOnly {
+		if err := os.MkdirAll(cfg.Done, 0o755); err != nil {
+			fatal(err)
+		}
+		if cfg.WorkRetentionHours > 0 {
+			cleanWorkDir(cfg.Work, time.Duration(cfg.WorkRetentionHours)*time.Hour)
+		}
+	}
+
+	timestamps, err := timeparse.New(cfg.TimestampLayouts, cfg.Timezone)
+	if err != nil {
+		fatal(err)
+	}
+	decoders, err := decoder.NewSet(cfg.Decoders)
+	if err != nil {
+		fatal(err)
+	}
+	defer decoders.Close()
+	codec, err := ingest.ParsePayloadCodec(cfg.PayloadCodec)
+	if err != nil {
+		fatal(err)
+	}
+	dedupe, err := ingest.ParseDedupePolicy(cfg.SnapshotDedupe)
+	if err != nil {
+		fatal(err)
+	}
+	duplicates, err := ingest.ParseDuplicatePolicy(cfg.DuplicateArchives)
+	if err != nil {
+		fatal(err)
+	}
+	order, err := ingest.ParseOrder(cfg.IngestOrder)
+	if err != nil {
+		fatal(err)
+	}
+	names, err := cfg.ArchiveNames()
+	if err != nil {
+		fatal(err)
+	}
+	aggregate, err := ingest.ParseCompareAggregate(cfg.CompareAggregate)
+	if err != nil {
+		fatal(err)
+	}
+	evidence, err := ingest.ParseEvidence(cfg.EvidenceMaxLength, cfg.EvidenceRedact)
+	if err != nil {
+		fatal(err)
+	}
+	parsers, err := payloadParsers(cfg)
+	if err != nil {
+		fatal(err)
+	}
+
+	db, err := ingest.OpenDB(cfg.DB, dbOptions(cfg))
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	var publisher publish.Publisher
+	if cfg.PublishURL != "" && !cfg.ReadOnly {
+		if publisher, err = publish.Open(cfg.PublishURL, cfg.PublishSubject, publish.Options{Batch: cfg.PublishBatch, Spool: cfg.PublishSpool}); err != nil {
+			fatal(err)
+		}
+		defer publisher.Close()
+	}
+
+	opts := ingest.Options{
+		WorkDir:          cfg.Work,
+		DoneDir:          cfg.Done,
+		Window:           time.Duration(cfg.WindowSeconds) * time.Second,
+		HashChain:        cfg.HashChain,
+		CompareBucket:    time.Duration(cfg.CompareBucketMinutes) * time.Minute,
+		CompareAggregate: aggregate,
+		ArchiveTimeout:   time.Duration(cfg.ArchiveTimeoutSeconds) * time.Second,
+		Timestamps:       timestamps,
+		Decoders:         decoders,
+		PayloadCodec:     codec,
+		ReceiptsDir:      cfg.Receipts,
+		HourLayout:       cfg.HourLayout,
+		Summary:          &ingest.Summary{},
+		PayloadParsers:   parsers,
+		SnapshotDedupe:   dedupe,
+		Duplicates:       duplicates,
+		Order:            order,
+		PrioritySites:    cfg.PrioritySites,
+		NameTemplate:     names,
+		NameOverride:     ingest.ArchiveName{SiteID: cfg.SiteID, DeviceID: cfg.DeviceID},
+		QuarantineDir:    cfg.Quarantine,
+		MaxAttempts:      cfg.MaxAttempts,
+		AnalyzeRaw:       cfg.AnalyzeRaw,
+		Stream:           cfg.StreamIngest,
+		RawObservations:  cfg.RawObservationDays > 0,
+		Evidence:         evidence,
+		Publisher:        publisher,
+		PublishSummaries: cfg.PublishSummaries,
+		ReadOnly:         cfg.ReadOnly,
+		SettingsDB:       settingsDB,
+		SensorMetrics:    sensorMetrics,
+		RunMetrics:       runMetrics,
+		Progress:         progress,
+		Concurrency:      cfg.Concurrency,
+		WorkFields:       ingest.WorkFields(cfg.WorkFields),
+		Allowlist:        allowlist,
+		InsertBatch:      cfg.InsertBatch,
+	}
+	if cfg.ProgressSeconds > 0 {
+		stop := logProgress(progress, time.Duration(cfg.ProgressSeconds)*time.Second)
+		defer stop()
+	}
+	var failures []error
+	if len(archives) > 0 {
+		failures, err = ingest.ProcessFiles(ctx, archives, db, mapping, opts)
+	} else {
+		failures, err = ingest.ProcessDir(ctx, cfg.Incoming, db, mapping, opts)
+	}
+	busy := false
+	runErrors := append([]error(nil), failures...)
+	for _, err := range failures {
+		slog.Error("archive failed", "error", err)
+		busy = busy || errors.Is(err, ingest.ErrDBBusy)
+	}
+	if err != nil {
+		runErrors = append(runErrors, err)
+	}
+	if cfg.DoneRetentionDays > 0 && !cfg.ReadOnly && ctx.Err() == nil {
+		if _, err := applyRetention(ctx, db, cfg, false); err != nil {
+			slog.Error("done retention incomplete", "error", err)
+			runErrors = append(runErrors, err)
+		}
+	}
+	if ctx.Err() == nil {
+		if deleted, err := ingest.PruneRawObservations(ctx, db, cfg.RawObservationDays, time.Now()); err != nil {
+			slog.Error("raw observation retention incomplete", "error", err)
+			runErrors = append(runErrors, err)
+		} else if deleted > 0 {
+			slog.Info("raw observations pruned", "rows", deleted, "days", cfg.RawObservationDays)
+		}
+	}
+	summary := opts.Summary.Totals()
+	if cfg.ReadOnly {
+		if _, err := ingest.WriteComparisons(ctx, db, os.Stdout); err != nil {
+			fatal(err)
+		}
+		printSummary(os.Stderr, summary)
+		if err == nil && len(failures) > 0 {
+			err = fmt.Errorf("%d of %d archives failed", len(failures), summary.Archives)
+		}
+	} else {
+		printSummary(os.Stdout, summary)
+	}
+	if cfg.SummaryJSON != "" && !cfg.ReadOnly {
+		if err := writeSummary(cfg.SummaryJSON, summary); err != nil {
+			slog.Error("run summary not written", "path", cfg.SummaryJSON, "error", err)
+		}
+	}
+	// A run that ingested nothing keeps the previous file, whose
+	// timestamps then show how long no archive arrived.
+	if cfg.MetricsTextfile != "" && !cfg.ReadOnly && summary.Processed > 0 {
+		if err := metrics.WriteFile(cfg.MetricsTextfile, sensorMetrics.Families()); err != nil {
+			slog.Error("metrics textfile not written", "path", cfg.MetricsTextfile, "error", err)
+		}
+	}
+	// Recorded after a shutdown signal too, which is why ctx's
+	// cancellation is dropped.
+	if !cfg.ReadOnly {
+		run := ingest.NewIngestRun(started, summary, runErrors)
+		if _, err := ingest.RecordRun(context.WithoutCancel(ctx), db, run); err != nil {
+			slog.Error("ingest run not recorded", "error", err)
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if busy {
+		flushTracing()
+		os.Exit(exitTempFail)
+	}
+}
+
+// cleanWorkDir removes extracted trees that crashed runs left behind.
+// Failures are logged; they do not stop the ingest.
+func cleanWorkDir(dir string, maxAge time.Duration) {
+	removed, err := ingest.CleanWorkDir(dir, maxAge, time.Now())
+	for _, path := range removed {
+		slog.Info("stale work directory removed", "path", path)
+	}
+	if err != nil {
+		slog.Warn("work directory cleanup incomplete", "dir", dir, "error", err)
+	}
+}
+
+// payloadParsers builds the parsers for the payload_versions in cfg, which
+// Validate has checked are numeric.
+func payloadParsers(cfg config.Worker) (map[int]ingest.PayloadParser, error) {
+	parsers := map[int]ingest.PayloadParser{}
+	for key, aliases := range cfg.PayloadVersions {
+		version, _ := strconv.Atoi(key)
+		parser, err := ingest.AliasParser(aliases.Payload, aliases.Item)
+		if err != nil {
+			return nil, &config.FieldError{Key: "payload_versions", Msg: fmt.Sprintf("version %s: %v", key, err)}
+		}
+		parsers[version] = parser
+	}
+	return parsers, nil
+}
+
+// parseWorkerFlags layers settings as defaults < config file < FIELD_WORKER_*
+// environment < command-line flags. Flags are parsed twice: once to find
+// -config, then again on top of the loaded config so only explicit flags win.
+// The remaining arguments are returned as archive paths.
+func parseWorkerFlags(args []string) (config.Worker, []string) {
+	scratch := config.DefaultWorker()
+	var configPath string
+	workerFlagSet(&scratch, &configPath).Parse(args)
+
+	cfg, err := config.LoadWorker(configPath)
+	if err != nil {
+		fatal(err)
+	}
+	fs := workerFlagSet(&cfg, &configPath)
+	fs.Parse(args)
+	return cfg, fs.Args()
+}
+
+func workerFlagSet(cfg *config.Worker, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("field-ingest-worker", flag.ExitOnError)
+	fs.StringVar(configPath, "config", "", "worker config file (json or yaml)")
+	fs.StringVar(&cfg.Incoming, "incoming", cfg.Incoming, "incoming directory")
+	fs.StringVar(&cfg.Work, "work", cfg.Work, "work directory")
+	fs.StringVar(&cfg.Done, "done", cfg.Done, "done directory")
+	fs.IntVar(&cfg.DoneRetentionDays, "done-retention-days", cfg.DoneRetentionDays, "after each run, retire done archives older than this many days (0 keeps them)")
+	fs.StringVar(&cfg.DoneArchiveTo, "done-archive-to", cfg.DoneArchiveTo, "copy retired archives to this directory or s3://bucket/prefix instead of only deleting them")
+	fs.IntVar(&cfg.WorkRetentionHours, "work-retention-hours", cfg.WorkRetentionHours, "on startup, remove unclaimed work trees older than this many hours (0 keeps them)")
+	fs.StringVar(&cfg.Quarantine, "quarantine", cfg.Quarantine, "move archives that can never be ingested here, with a receipt giving the reason (empty leaves them in incoming)")
+	fs.IntVar(&cfg.MaxAttempts, "max-attempts", cfg.MaxAttempts, "quarantine an archive after it failed this many runs (0 retries it forever)")
+	fs.StringVar(&cfg.ArchiveNameTemplate, "archive-name-template", cfg.ArchiveNameTemplate, "template archive names are read with, e.g. {site}_{device}_{date} (the default); also {work_field} and {hour}")
+	fs.StringVar(&cfg.SiteID, "site-id", cfg.SiteID, "site id for archives whose name does not match the template and that have no .name.json sidecar")
+	fs.StringVar(&cfg.DeviceID, "device-id", cfg.DeviceID, "device id to use with -site-id")
+	fs.StringVar(&cfg.Receipts, "receipts", cfg.Receipts, "write <archive>.receipt.json here for the client to collect")
+	fs.StringVar(&cfg.SummaryJSON, "summary-json", cfg.SummaryJSON, "also write the end-of-run summary as JSON to this path")
+	fs.StringVar(&cfg.DB, "db", cfg.DB, "sqlite database path")
+	fs.StringVar(&cfg.Mapping, "mapping", cfg.Mapping, "sensor mapping json")
+	fs.StringVar(&cfg.Allowlist, "allowlist", cfg.Allowlist, "site/device allowlist json; archives of other sites and devices are quarantined")
+	fs.IntVar(&cfg.WindowSeconds, "window", cfg.WindowSeconds, "comparison window in seconds")
+	fs.IntVar(&cfg.CompareBucketMinutes, "compare-bucket-minutes", cfg.CompareBucketMinutes, "compare once per sensor and N-minute window instead of per snapshot (0 = per snapshot)")
+	fs.StringVar(&cfg.CompareAggregate, "compare-aggregate", cfg.CompareAggregate, "value compared per window with -compare-bucket-minutes: last or mean")
+	fs.BoolVar(&cfg.HashChain, "hash-chain", cfg.HashChain, "append comparison results to the tamper-evident hash chain")
+	fs.IntVar(&cfg.ArchiveTimeoutSeconds, "archive-timeout", cfg.ArchiveTimeoutSeconds, "max seconds per archive (0 = no limit)")
+	fs.IntVar(&cfg.BusyTimeoutSeconds, "busy-timeout", cfg.BusyTimeoutSeconds, "seconds to wait for a locked database before giving up")
+	fs.StringVar(&cfg.DBSynchronous, "db-synchronous", cfg.DBSynchronous, "sqlite synchronous pragma of the writer: off, normal, full or extra (empty = full)")
+	var pragmaFlag bool
+	fs.Func("db-pragma", "extra sqlite pragma as name(value), e.g. cache_size(-20000); repeat for more, replaces db_pragmas", func(value string) error {
+		if !pragmaFlag {
+			cfg.DBPragmas, pragmaFlag = nil, true
+		}
+		cfg.DBPragmas = append(cfg.DBPragmas, value)
+		return nil
+	})
+	fs.IntVar(&cfg.InsertBatch, "insert-batch", cfg.InsertBatch, "events and snapshots inserted per statement; an archive still commits as one transaction")
+	fs.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "ingest up to N archives at once; archives of the same site and device still run one at a time")
+	fs.BoolVar(&cfg.ReadOnly, "read-only", cfg.ReadOnly, "verify and compare without touching the database, done directory or receipts; print comparisons as JSON lines")
+	fs.BoolVar(&cfg.MigrateOnly, "migrate-only", cfg.MigrateOnly, "apply pending database schema migrations, print the schema history and exit without ingesting")
+	fs.IntVar(&cfg.LastRuns, "last-runs", cfg.LastRuns, "print the latest N recorded worker runs with their errors and exit without ingesting")
+	fs.BoolVar(&cfg.StreamIngest, "stream", cfg.StreamIngest, "read events and snapshots straight from the archive, verifying them as they are read; only raw_session is extracted, and only when compared or analyzed")
+	fs.BoolVar(&cfg.AnalyzeRaw, "analyze-raw", cfg.AnalyzeRaw, "run the analyzer over each archive's raw_session and store sensor_health_daily")
+	fs.IntVar(&cfg.RawObservationDays, "raw-observation-days", cfg.RawObservationDays, "store parsed raw_session lines in raw_observations and keep this many days of them (0 stores none)")
+	fs.IntVar(&cfg.EvidenceMaxLength, "evidence-max-length", cfg.EvidenceMaxLength, "bytes of a raw_session line kept as comparison evidence (0 = 200)")
+	var redactFlag bool
+	fs.Func("evidence-redact", "regexp whose matches (or capture groups) are replaced in raw_session lines before storage; repeat for more, replaces evidence_redact", func(value string) error {
+		if !redactFlag {
+			cfg.EvidenceRedact, redactFlag = nil, true
+		}
+		cfg.EvidenceRedact = append(cfg.EvidenceRedact, value)
+		return nil
+	})
+	fs.StringVar(&cfg.IngestOrder, "ingest-order", cfg.IngestOrder, "order of waiting archives: name, oldest, round-robin or priority")
+	fs.Func("priority-sites", "comma-separated site ids -ingest-order priority takes first", func(value string) error {
+		cfg.PrioritySites = nil
+		for _, site := range strings.Split(value, ",") {
+			if site = strings.TrimSpace(site); site != "" {
+				cfg.PrioritySites = append(cfg.PrioritySites, site)
+			}
+		}
+		return nil
+	})
+	fs.StringVar(&cfg.SnapshotDedupe, "snapshot-dedupe", cfg.SnapshotDedupe, "re-sent snapshots with a changed payload: skip, supersede or keep-all")
+	fs.StringVar(&cfg.DuplicateArchives, "duplicate-archives", cfg.DuplicateArchives, "archives already ingested with the same name and sha256: skip (move to done) or warn (ingest again)")
+	fs.StringVar(&cfg.PublishURL, "publish-url", cfg.PublishURL, "publish comparison results to nats://host:4222, kafka+http://rest-proxy:8082 or an http(s) REST endpoint")
+	fs.StringVar(&cfg.PublishSubject, "publish-subject", cfg.PublishSubject, "NATS subject or Kafka topic for -publish-url")
+	fs.BoolVar(&cfg.PublishSummaries, "publish-summaries", cfg.PublishSummaries, "publish one summary per archive instead of every comparison result")
+	fs.IntVar(&cfg.PublishBatch, "publish-batch", cfg.PublishBatch, "records per request to an http(s) -publish-url (default 500)")
+	fs.StringVar(&cfg.PublishSpool, "publish-spool", cfg.PublishSpool, "keep batches for an http(s) -publish-url here until delivered, across restarts")
+	fs.StringVar(&cfg.PayloadCodec, "payload-codec", cfg.PayloadCodec, "compress stored payload_json: none or zstd")
+	fs.StringVar(&cfg.PprofAddr, "pprof-addr", cfg.PprofAddr, "serve net/http/pprof on this address while running (e.g. 127.0.0.1:6060)")
+	fs.IntVar(&cfg.ProgressSeconds, "progress-interval", cfg.ProgressSeconds, "log archives done/total, the current archive and stage and an ETA every N seconds (0 = off)")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "serve Prometheus /metrics on this address while running (e.g. :9464): archives, rows, results, durations and incoming backlog")
+	fs.StringVar(&cfg.MetricsTextfile, "metrics-textfile", cfg.MetricsTextfile, "after each run, write per-sensor Prometheus gauges to this .prom file for node_exporter")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn, error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: text or json")
+	return fs
+}
+
+func runVerifyChain(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("verify-chain", flag.ExitOnError)
+	dbPath := fs.String("db", config.DefaultWorker().DB, "sqlite database path")
+	expect := fs.String("expect", "", "head printed by an earlier run (<entries>:<hash>) that must still be in the chain")
+	fs.Parse(args)
+
+	var earlier ingest.ChainHead
+	if *expect != "" {
+		var err error
+		if earlier, err = ingest.ParseChainHead(*expect); err != nil {
+			fatal(err)
+		}
+	}
+
+	db, err := ingest.OpenReadDB(*dbPath, ingest.DBOptions{})
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	head, err := ingest.VerifyChain(ctx, db, earlier)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("chain ok: %d entries\nhead: %s\n", head.Entries, head)
+}
+
+func runPurge(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	dbPath := fs.String("db", config.DefaultWorker().DB, "sqlite database path")
+	doneDir := fs.String("done", config.DefaultWorker().Done, "done directory")
+	siteID := fs.String("site", "", "site id to purge")
+	deviceID := fs.String("device", "", "device id to purge")
+	before := fs.String("before", "", "purge archives dated before YYYYMMDD")
+	nameTemplate := fs.String("archive-name-template", "", "template the archive names in done are read with (default {site}_{device}_{date})")
+	dryRun := fs.Bool("dry-run", false, "list what would be purged without deleting")
+	fs.Parse(args)
+
+	if *siteID == "" || *deviceID == "" {
+		fatal(errors.New("--site and --device are required"))
+	}
+	if _, err := timeparse.ParseDate(*before); err != nil {
+		fatal(fmt.Errorf("invalid --before %q: expected YYYYMMDD", *before))
+	}
+
+	db, err := ingest.OpenDB(*dbPath, ingest.DBOptions{})
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	names, err := archivename.Parse(*nameTemplate)
+	if err != nil {
+		fatal(err)
+	}
+	files, err := ingest.PurgeCandidates(ctx, db, *doneDir, *siteID, *deviceID, *before, names)
+	if err != nil {
+		fatal(err)
+	}
+	copies, err := ingest.RetainedCopies(ctx, db, *siteID, *deviceID, files)
+	if err != nil {
+		fatal(err)
+	}
+	if *dryRun {
+		for _, name := range files {
+			fmt.Printf("would purge %s\n", name)
+		}
+		for _, location := range copies {
+			fmt.Printf("would delete retained copy %s\n", location)
+		}
+		return
+	}
+
+	// Copies go first: if one cannot be deleted the rows stay, so the
+	// archive is still a candidate when purge runs again.
+	for _, location := range copies {
+		if err := ingest.DeleteRetainedCopy(ctx, location); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("deleted retained copy %s\n", location)
+	}
+	total, err := ingest.PurgeIngestFiles(ctx, db, *siteID, *deviceID, *before, files)
+	if err != nil {
+		fatal(err)
+	}
+	for _, name := range files {
+		for _, path := range []string{filepath.Join(*doneDir, name), filepath.Join(*doneDir, receipt.Name(name))} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fatal(err)
+			}
+		}
+	}
+	fmt.Printf("purged %d archives, %d rows\n", len(files), total)
+}
+
+func runImportLegacy(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("import-legacy", flag.ExitOnError)
+	configPath := fs.String("config", "", "worker config file, for timestamp_layouts, timezone, hour_layout and archive_name_template")
+	dbPath := fs.String("db", "", "sqlite database path (default: the config's db)")
+	kind := fs.String("kind", "", "target table: hourly or snapshots")
+	siteID := fs.String("site", "", "site id for rows without a site_id column")
+	deviceID := fs.String("device", "", "device id for rows without a device_id column")
+	fs.Parse(args)
+
+	if *kind != "hourly" && *kind != "snapshots" {
+		fatal(errors.New("--kind must be hourly or snapshots"))
+	}
+	if fs.NArg() == 0 {
+		fatal(errors.New("expected one or more csv files"))
+	}
+
+	cfg, err := config.LoadWorker(*configPath)
+	if err != nil {
+		fatal(err)
+	}
+	if *dbPath != "" {
+		cfg.DB = *dbPath
+	}
+	if err := cfg.Validate(); err != nil {
+		fatal(err)
+	}
+	timestamps, err := timeparse.New(cfg.TimestampLayouts, cfg.Timezone)
+	if err != nil {
+		fatal(err)
+	}
+	names, err := cfg.ArchiveNames()
+	if err != nil {
+		fatal(err)
+	}
+	opts := ingest.Options{Timestamps: timestamps, HourLayout: cfg.HourLayout, NameTemplate: names}
+
+	db, err := ingest.OpenDB(cfg.DB, dbOptions(cfg))
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	for _, path := range fs.Args() {
+		inserted, skipped, err := ingest.ImportLegacyCSV(ctx, db, path, *kind, *siteID, *deviceID, opts)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %w", path, err))
+		}
+		fmt.Printf("%s: inserted=%d skipped=%d\n", path, inserted, skipped)
+	}
+}
+
+// Exit codes follow sysexits.h so service managers can tell a retryable
+// failure from a configuration problem.
+const (
+	exitFailure  = 1
+	exitTempFail = 75
+	exitConfig   = 78
+)
+
+func exitCode(err error) int {
+	var fieldErr *config.FieldError
+	switch {
+	case errors.Is(err, ingest.ErrDBBusy):
+		return exitTempFail
+	case errors.Is(err, ingest.ErrMappingInvalid), errors.As(err, &fieldErr):
+		return exitConfig
+	default:
+		return exitFailure
+	}
+}
+
+// shutdownTracing is replaced in main once tracing is configured.
+var shutdownTracing = func(context.Context) error { return nil }
+
+func flushTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("traces not flushed", "error", err)
+	}
+}
+
+func fatal(err error) {
+	slog.Error("exiting", "error", err)
+	flushTracing()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,762 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"workfield/internal/analyzer"
+	"workfield/internal/archivename"
+	"workfield/internal/i18n"
+	"workfield/internal/logging"
+	"workfield/internal/timeparse"
+	"workfield/internal/upload"
+)
+
+const (
+	ClientEnvPrefix = "FIELD_CLIENT_"
+	WorkerEnvPrefix = "FIELD_WORKER_"
+)
+
+// FieldError reports a problem with a single config key so operators can find
+// the offending line without reading the code.
+type FieldError struct {
+	Key string
+	Msg string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("config %s: %s", e.Key, e.Msg)
+}
+
+type Client struct {
+	SiteID                string              `json:"site_id" yaml:"site_id"`
+	DeviceID              string              `json:"device_id" yaml:"device_id"`
+	WorkField             string              `json:"work_field" yaml:"work_field"`
+	OutboxDir             string              `json:"outbox_dir" yaml:"outbox_dir"`
+	LogRoot               string              `json:"log_root" yaml:"log_root"`
+	IncludeGlobs          []string            `json:"include_globs" yaml:"include_globs"`
+	ExcludeDirs           []string            `json:"exclude_dirs" yaml:"exclude_dirs"`
+	ControllerLogGlobs    []string            `json:"controller_log_globs" yaml:"controller_log_globs"`
+	ExpectedSensors       []string            `json:"expected_sensors" yaml:"expected_sensors"`
+	Thresholds            Thresholds          `json:"thresholds" yaml:"thresholds"`
+	SensorSchedules       map[string][]string `json:"sensor_schedules" yaml:"sensor_schedules"`
+	DuplicateRunThreshold int                 `json:"duplicate_run_threshold" yaml:"duplicate_run_threshold"`
+	FallbackToLatestFile  *bool               `json:"fallback_to_latest_file" yaml:"fallback_to_latest_file"`
+	MaxLines              int                 `json:"max_lines" yaml:"max_lines"`
+	ExampleContextLines   int                 `json:"example_context_lines" yaml:"example_context_lines"`
+	HistoryDir            string              `json:"history_dir" yaml:"history_dir"`
+	HistoryKeepDays       int                 `json:"history_keep_days" yaml:"history_keep_days"`
+	DiskWarnDays          int                 `json:"disk_warn_days" yaml:"disk_warn_days"`
+	PayloadFormat         string              `json:"payload_format" yaml:"payload_format"`
+	EventStream           bool                `json:"event_stream" yaml:"event_stream"`
+	TimestampLayouts      []string            `json:"timestamp_layouts" yaml:"timestamp_layouts"`
+	Timezone              string              `json:"timezone" yaml:"timezone"`
+	Language              string              `json:"language" yaml:"language"`
+	ReceiptsDir           string              `json:"receipts_dir" yaml:"receipts_dir"`
+	NTPServer             string              `json:"ntp_server" yaml:"ntp_server"`
+	Decoders              map[string][]string `json:"decoders" yaml:"decoders"`
+	Secrets               map[string]string   `json:"secrets" yaml:"secrets"`
+	SecretKeyFile         string              `json:"secret_key_file" yaml:"secret_key_file"`
+	UploadTargets         []UploadTarget      `json:"upload_targets" yaml:"upload_targets"`
+	UploadWindows         []string            `json:"upload_windows" yaml:"upload_windows"`
+	WebhookURL            string              `json:"webhook_url" yaml:"webhook_url"`
+	ArchiveNameTemplate   string              `json:"archive_name_template" yaml:"archive_name_template"`
+	WebhookSecret         string              `json:"webhook_secret" yaml:"webhook_secret"`
+	LogLevel              string              `json:"log_level" yaml:"log_level"`
+	LogFormat             string              `json:"log_format" yaml:"log_format"`
+	LogOutput             string              `json:"log_output" yaml:"log_output"`
+	Debug                 bool                `json:"debug" yaml:"debug"`
+}
+
+type Worker struct {
+	Incoming              string                    `json:"incoming" yaml:"incoming"`
+	Work                  string                    `json:"work" yaml:"work"`
+	Done                  string                    `json:"done" yaml:"done"`
+	Quarantine            string                    `json:"quarantine" yaml:"quarantine"`
+	MaxAttempts           int                       `json:"max_attempts" yaml:"max_attempts"`
+	Receipts              string                    `json:"receipts" yaml:"receipts"`
+	SummaryJSON           string                    `json:"summary_json" yaml:"summary_json"`
+	DB                    string                    `json:"db" yaml:"db"`
+	Mapping               string                    `json:"mapping" yaml:"mapping"`
+	Allowlist             string                    `json:"allowlist" yaml:"allowlist"`
+	WindowSeconds         int                       `json:"window" yaml:"window"`
+	CompareBucketMinutes  int                       `json:"compare_bucket_minutes" yaml:"compare_bucket_minutes"`
+	CompareAggregate      string                    `json:"compare_aggregate" yaml:"compare_aggregate"`
+	HashChain             bool                      `json:"hash_chain" yaml:"hash_chain"`
+	ArchiveTimeoutSeconds int                       `json:"archive_timeout" yaml:"archive_timeout"`
+	BusyTimeoutSeconds    int                       `json:"busy_timeout" yaml:"busy_timeout"`
+	DBSynchronous         string                    `json:"db_synchronous" yaml:"db_synchronous"`
+	DBPragmas             []string                  `json:"db_pragmas" yaml:"db_pragmas"`
+	InsertBatch           int                       `json:"insert_batch" yaml:"insert_batch"`
+	PprofAddr             string                    `json:"pprof_addr" yaml:"pprof_addr"`
+	MetricsAddr           string                    `json:"metrics_addr" yaml:"metrics_addr"`
+	MetricsTextfile       string                    `json:"metrics_textfile" yaml:"metrics_textfile"`
+	ProgressSeconds       int                       `json:"progress_interval" yaml:"progress_interval"`
+	Concurrency           int                       `json:"concurrency" yaml:"concurrency"`
+	TimestampLayouts      []string                  `json:"timestamp_layouts" yaml:"timestamp_layouts"`
+	Timezone              string                    `json:"timezone" yaml:"timezone"`
+	HourLayout            string                    `json:"hour_layout" yaml:"hour_layout"`
+	Decoders              map[string][]string       `json:"decoders" yaml:"decoders"`
+	PayloadCodec          string                    `json:"payload_codec" yaml:"payload_codec"`
+	PayloadVersions       map[string]PayloadAliases `json:"payload_versions" yaml:"payload_versions"`
+	SnapshotDedupe        string                    `json:"snapshot_dedupe" yaml:"snapshot_dedupe"`
+	DuplicateArchives     string                    `json:"duplicate_archives" yaml:"duplicate_archives"`
+	SiteID                string                    `json:"site_id" yaml:"site_id"`
+	DeviceID              string                    `json:"device_id" yaml:"device_id"`
+	IngestOrder           string                    `json:"ingest_order" yaml:"ingest_order"`
+	PrioritySites         []string                  `json:"priority_sites" yaml:"priority_sites"`
+	ArchiveNameTemplate   string                    `json:"archive_name_template" yaml:"archive_name_template"`
+	WorkFields            map[string][]string       `json:"work_fields" yaml:"work_fields"`
+	AnalyzeRaw            bool                      `json:"analyze_raw" yaml:"analyze_raw"`
+	StreamIngest          bool                      `json:"stream_ingest" yaml:"stream_ingest"`
+	RawObservationDays    int                       `json:"raw_observation_days" yaml:"raw_observation_days"`
+	EvidenceMaxLength     int                       `json:"evidence_max_length" yaml:"evidence_max_length"`
+	EvidenceRedact        []string                  `json:"evidence_redact" yaml:"evidence_redact"`
+	ReadOnly              bool                      `json:"read_only" yaml:"read_only"`
+	MigrateOnly           bool                      `json:"-" yaml:"-"`
+	LastRuns              int                       `json:"-" yaml:"-"`
+	WorkRetentionHours    int                       `json:"work_retention_hours" yaml:"work_retention_hours"`
+	DoneRetentionDays     int                       `json:"done_retention_days" yaml:"done_retention_days"`
+	DoneArchiveTo         string                    `json:"done_archive_to" yaml:"done_archive_to"`
+	PublishURL            string                    `json:"publish_url" yaml:"publish_url"`
+	PublishSubject        string                    `json:"publish_subject" yaml:"publish_subject"`
+	PublishSummaries      bool                      `json:"publish_summaries" yaml:"publish_summaries"`
+	PublishBatch          int                       `json:"publish_batch" yaml:"publish_batch"`
+	PublishSpool          string                    `json:"publish_spool" yaml:"publish_spool"`
+	LogLevel              string                    `json:"log_level" yaml:"log_level"`
+	LogFormat             string                    `json:"log_format" yaml:"log_format"`
+	LogOutput             string                    `json:"log_output" yaml:"log_output"`
+}
+
+// UploadTarget is one destination "field-client upload" delivers every
+// outbox archive to. URL is a directory (or file://), sftp://user@host:port/dir,
+// an http(s) base url the archive is PUT below, or s3://bucket/prefix. Secret
+// names an entry of secrets: the bearer token for http(s), or
+// "access_key_id:secret_access_key" for s3.
+type UploadTarget struct {
+	Name         string `json:"name" yaml:"name"`
+	URL          string `json:"url" yaml:"url"`
+	IdentityFile string `json:"identity_file" yaml:"identity_file"`
+	Secret       string `json:"secret" yaml:"secret"`
+}
+
+// ThresholdLimits are the highest daily counts of a sensor before
+// analyze-daily grades it WARN; an omitted key is no limit.
+type ThresholdLimits struct {
+	Timeout     *int `json:"timeout" yaml:"timeout"`
+	NoResponse  *int `json:"no_response" yaml:"no_response"`
+	ZeroData    *int `json:"zero_data" yaml:"zero_data"`
+	Duplicates  *int `json:"duplicates" yaml:"duplicates"`
+	ParseErrors *int `json:"parse_errors" yaml:"parse_errors"`
+}
+
+// Thresholds are the limit profiles: default for every sensor, then per
+// sensor type (GATE, WLS, PUMP, TEMP) and per sensor ID overriding the
+// limits they set.
+type Thresholds struct {
+	Default ThresholdLimits            `json:"default" yaml:"default"`
+	Types   map[string]ThresholdLimits `json:"types" yaml:"types"`
+	Sensors map[string]ThresholdLimits `json:"sensors" yaml:"sensors"`
+}
+
+// thresholdTypes are the sensor types the analyzer knows.
+var thresholdTypes = []string{"GATE", "WLS", "PUMP", "TEMP"}
+
+func (t Thresholds) validate() error {
+	check := func(key string, l ThresholdLimits) error {
+		for _, limit := range []*int{l.Timeout, l.NoResponse, l.ZeroData, l.Duplicates, l.ParseErrors} {
+			if limit != nil && *limit < 0 {
+				return &FieldError{Key: key, Msg: "limits must not be negative"}
+			}
+		}
+		return nil
+	}
+	if err := check("thresholds.default", t.Default); err != nil {
+		return err
+	}
+	for sensorType, limits := range t.Types {
+		if !containsFold(thresholdTypes, sensorType) {
+			return &FieldError{Key: "thresholds.types", Msg: fmt.Sprintf("unknown sensor type %q (want one of %s)", sensorType, strings.Join(thresholdTypes, ", "))}
+		}
+		if err := check("thresholds.types."+sensorType, limits); err != nil {
+			return err
+		}
+	}
+	for sensorID, limits := range t.Sensors {
+		if err := check("thresholds.sensors."+sensorID, limits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PayloadAliases describes a snapshot payload version that only renames
+// keys of the base schema: base name → name used by that firmware, for the
+// payload object and for each element of its data array.
+type PayloadAliases struct {
+	Payload map[string]string `json:"payload" yaml:"payload"`
+	Item    map[string]string `json:"item" yaml:"item"`
+}
+
+func DefaultClient() Client {
+	fallback := true
+	return Client{
+		IncludeGlobs:          []string{"GATE*", "WLS*", "PUMP*", "TEMP*"},
+		ExcludeDirs:           []string{"ALL", "PING", "SERVER"},
+		DuplicateRunThreshold: 3,
+		FallbackToLatestFile:  &fallback,
+		MaxLines:              5000,
+		DiskWarnDays:          14,
+	}
+}
+
+func DefaultWorker() Worker {
+	return Worker{
+		Incoming:           "/srv/field-ingest/incoming",
+		Work:               "/srv/field-ingest/work",
+		Done:               "/srv/field-ingest/done",
+		Quarantine:         "/srv/field-ingest/quarantine",
+		MaxAttempts:        5,
+		DB:                 "/srv/field-ingest/db/field_metrics.sqlite3",
+		Mapping:            "mapping.json",
+		WindowSeconds:      3,
+		BusyTimeoutSeconds: 5,
+		InsertBatch:        1000,
+		WorkRetentionHours: 24,
+		ProgressSeconds:    60,
+		Concurrency:        1,
+	}
+}
+
+// LoadClient reads path (JSON, or YAML for .yaml/.yml), applies
+// FIELD_CLIENT_* environment overrides and fills defaults. Validation is left
+// to the caller so command-line overrides can be applied first.
+func LoadClient(path string) (Client, error) {
+	cfg := DefaultClient()
+	if err := load(path, &cfg, ClientEnvPrefix); err != nil {
+		return Client{}, err
+	}
+	if cfg.FallbackToLatestFile == nil {
+		fallback := true
+		cfg.FallbackToLatestFile = &fallback
+	}
+	if cfg.DuplicateRunThreshold <= 0 {
+		cfg.DuplicateRunThreshold = 3
+	}
+	if cfg.MaxLines <= 0 {
+		cfg.MaxLines = 5000
+	}
+	return cfg, nil
+}
+
+// payloadFormats mirrors analyzer.ParsePayloadFormat; "" means auto.
+var payloadFormats = []string{"", "auto", "hex-csv", "dec-csv", "hexstring", "base64"}
+
+// payloadCodecs mirrors ingest.ParsePayloadCodec; "" means none.
+var payloadCodecs = []string{"", "none", "zstd"}
+
+// snapshotDedupePolicies mirrors ingest.ParseDedupePolicy; "" means skip.
+var snapshotDedupePolicies = []string{"", "skip", "supersede", "keep-all"}
+
+// duplicateArchivePolicies mirrors ingest.ParseDuplicatePolicy; "" means
+// skip.
+var duplicateArchivePolicies = []string{"", "skip", "warn"}
+
+// compareAggregates are the accepted compare_aggregate values; "" means last.
+var compareAggregates = []string{"", "last", "mean"}
+
+// ingestOrders are the accepted ingest_order values; "" means name.
+var ingestOrders = []string{"", "name", "oldest", "round-robin", "priority"}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Client) Validate() error {
+	if c.LogRoot == "" {
+		return &FieldError{Key: "log_root", Msg: "is required"}
+	}
+	if c.OutboxDir == "" {
+		return &FieldError{Key: "outbox_dir", Msg: "is required"}
+	}
+	for _, pattern := range c.IncludeGlobs {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return &FieldError{Key: "include_globs", Msg: fmt.Sprintf("invalid pattern %q", pattern)}
+		}
+	}
+	for _, pattern := range c.ControllerLogGlobs {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return &FieldError{Key: "controller_log_globs", Msg: fmt.Sprintf("invalid pattern %q", pattern)}
+		}
+	}
+	if c.HistoryKeepDays < 0 {
+		return &FieldError{Key: "history_keep_days", Msg: "must not be negative"}
+	}
+	if c.DiskWarnDays < 0 {
+		return &FieldError{Key: "disk_warn_days", Msg: "must not be negative"}
+	}
+	if c.ExampleContextLines < 0 || c.ExampleContextLines > 50 {
+		return &FieldError{Key: "example_context_lines", Msg: "must be between 0 and 50"}
+	}
+	if err := c.Thresholds.validate(); err != nil {
+		return err
+	}
+	for key, windows := range c.SensorSchedules {
+		if _, err := analyzer.ParseSchedule(windows); err != nil {
+			return &FieldError{Key: "sensor_schedules." + key, Msg: err.Error()}
+		}
+	}
+	seenSensors := map[string]bool{}
+	for _, id := range c.ExpectedSensors {
+		if id == "" {
+			return &FieldError{Key: "expected_sensors", Msg: "empty sensor id"}
+		}
+		if seenSensors[strings.ToUpper(id)] {
+			return &FieldError{Key: "expected_sensors", Msg: fmt.Sprintf("duplicate sensor id %q", id)}
+		}
+		seenSensors[strings.ToUpper(id)] = true
+	}
+	if !containsFold(payloadFormats, c.PayloadFormat) {
+		return &FieldError{Key: "payload_format", Msg: fmt.Sprintf("must be one of %s", strings.Join(payloadFormats[1:], ", "))}
+	}
+	if _, err := i18n.ParseLang(c.Language); err != nil {
+		return &FieldError{Key: "language", Msg: fmt.Sprintf("must be one of %s, %s", i18n.English, i18n.Korean)}
+	}
+	if err := validateDecoders(c.Decoders); err != nil {
+		return err
+	}
+	for name := range c.Secrets {
+		if err := CheckSecretName(name); err != nil {
+			return &FieldError{Key: "secrets", Msg: err.Error()}
+		}
+	}
+	if err := c.validateUploadTargets(); err != nil {
+		return err
+	}
+	if _, err := upload.ParseSchedule(c.UploadWindows, time.UTC); err != nil {
+		return &FieldError{Key: "upload_windows", Msg: err.Error()}
+	}
+	if err := c.validateWebhook(); err != nil {
+		return err
+	}
+	if _, err := archivename.Parse(c.ArchiveNameTemplate); err != nil {
+		return &FieldError{Key: "archive_name_template", Msg: err.Error()}
+	}
+	if err := validateLogging(c.Logging()); err != nil {
+		return err
+	}
+	return validateTimestamps(c.TimestampLayouts, c.Timezone)
+}
+
+// uploadSchemes are the accepted upload target url schemes; a url without
+// "://" is a local directory.
+var uploadSchemes = []string{"", "file", "sftp", "http", "https", "s3"}
+
+func (c Client) validateUploadTargets() error {
+	seen := make(map[string]bool, len(c.UploadTargets))
+	for i, target := range c.UploadTargets {
+		key := fmt.Sprintf("upload_targets[%d]", i)
+		if target.Name == "" {
+			return &FieldError{Key: key, Msg: "name is required"}
+		}
+		if seen[target.Name] {
+			return &FieldError{Key: key, Msg: fmt.Sprintf("duplicate name %q", target.Name)}
+		}
+		seen[target.Name] = true
+		if target.Secret != "" {
+			if _, ok := c.Secrets[target.Secret]; !ok {
+				return &FieldError{Key: key, Msg: fmt.Sprintf("secret %q is not in secrets", target.Secret)}
+			}
+		}
+		if target.URL != "" && !strings.Contains(target.URL, "://") {
+			continue // a directory, e.g. /mnt/central or D:\upload
+		}
+		u, err := url.Parse(target.URL)
+		if target.URL == "" || err != nil || !containsFold(uploadSchemes, u.Scheme) {
+			return &FieldError{Key: key, Msg: fmt.Sprintf("url must be a directory or a %s url", strings.Join(uploadSchemes[1:], ", "))}
+		}
+		if u.Scheme != "" && u.Scheme != "file" && u.Host == "" {
+			return &FieldError{Key: key, Msg: fmt.Sprintf("url %q has no host", target.URL)}
+		}
+	}
+	return nil
+}
+
+// validateWebhook checks webhook_url is an http(s) url and webhook_secret,
+// the HMAC key its events are signed with, names an entry of secrets.
+func (c Client) validateWebhook() error {
+	if c.WebhookURL == "" {
+		if c.WebhookSecret != "" {
+			return &FieldError{Key: "webhook_secret", Msg: "set without webhook_url"}
+		}
+		return nil
+	}
+	u, err := url.Parse(c.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &FieldError{Key: "webhook_url", Msg: "must be an http or https url"}
+	}
+	if c.WebhookSecret != "" {
+		if _, ok := c.Secrets[c.WebhookSecret]; !ok {
+			return &FieldError{Key: "webhook_secret", Msg: fmt.Sprintf("secret %q is not in secrets", c.WebhookSecret)}
+		}
+	}
+	return nil
+}
+
+// ArchiveNames is archive_name_template, the names of the daily archives.
+func (c Client) ArchiveNames() (*archivename.Template, error) {
+	return archivename.Parse(c.ArchiveNameTemplate)
+}
+
+// UploadSchedule is upload_windows in the configured timezone.
+func (c Client) UploadSchedule() (*upload.Schedule, error) {
+	location, err := timeparse.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return upload.ParseSchedule(c.UploadWindows, location)
+}
+
+// SecretKeyPath is the device key file for configPath: secret_key_file, or
+// field-client.key next to the config file.
+func (c Client) SecretKeyPath(configPath string) string {
+	if c.SecretKeyFile != "" {
+		return c.SecretKeyFile
+	}
+	return filepath.Join(filepath.Dir(configPath), "field-client.key")
+}
+
+// Logging returns the log settings; the legacy debug flag means level debug
+// unless log_level is set.
+func (c Client) Logging() logging.Config {
+	level := c.LogLevel
+	if level == "" && c.Debug {
+		level = "debug"
+	}
+	return logging.Config{Level: level, Format: c.LogFormat, Output: c.LogOutput}
+}
+
+func (w Worker) Logging() logging.Config {
+	return logging.Config{Level: w.LogLevel, Format: w.LogFormat, Output: w.LogOutput}
+}
+
+// ArchiveNames is archive_name_template, the names incoming archives are
+// read with.
+func (w Worker) ArchiveNames() (*archivename.Template, error) {
+	return archivename.Parse(w.ArchiveNameTemplate)
+}
+
+func validateLogging(cfg logging.Config) error {
+	if _, err := logging.ParseLevel(cfg.Level); err != nil {
+		return &FieldError{Key: "log_level", Msg: fmt.Sprintf("must be one of %s", strings.Join(logging.Levels, ", "))}
+	}
+	if err := cfg.Check(); err != nil {
+		return &FieldError{Key: "log_format", Msg: fmt.Sprintf("must be one of %s", strings.Join(logging.Formats, ", "))}
+	}
+	return nil
+}
+
+// validateDecoders checks the sensor type → plugin command lines.
+func validateDecoders(decoders map[string][]string) error {
+	for sensorType, command := range decoders {
+		if len(command) == 0 || strings.TrimSpace(command[0]) == "" {
+			return &FieldError{Key: "decoders", Msg: fmt.Sprintf("%s has an empty command", sensorType)}
+		}
+	}
+	return nil
+}
+
+// validateTimestamps checks the settings later handed to timeparse.New.
+func validateTimestamps(layouts []string, timezone string) error {
+	for _, layout := range layouts {
+		if strings.TrimSpace(layout) == "" {
+			return &FieldError{Key: "timestamp_layouts", Msg: "must not contain empty layouts"}
+		}
+	}
+	if _, err := timeparse.LoadLocation(timezone); err != nil {
+		return &FieldError{Key: "timezone", Msg: fmt.Sprintf("unknown timezone %q", timezone)}
+	}
+	return nil
+}
+
+// LoadWorker is like LoadClient for the ingest worker; an empty path yields
+// defaults plus FIELD_WORKER_* overrides.
+func LoadWorker(path string) (Worker, error) {
+	cfg := DefaultWorker()
+	if err := load(path, &cfg, WorkerEnvPrefix); err != nil {
+		return Worker{}, err
+	}
+	return cfg, nil
+}
+
+// pragmaPattern is what db_pragmas accepts: a pragma name with an optional
+// simple value, so the entries cannot change anything else about the
+// connection string.
+var pragmaPattern = regexp.MustCompile(`^[a-z_]+(\([A-Za-z0-9_.-]*\))?$`)
+
+func (w Worker) Validate() error {
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"incoming", w.Incoming},
+		{"work", w.Work},
+		{"done", w.Done},
+		{"db", w.DB},
+		{"mapping", w.Mapping},
+	}
+	for _, item := range required {
+		if item.value == "" {
+			return &FieldError{Key: item.key, Msg: "is required"}
+		}
+	}
+	if w.WindowSeconds <= 0 {
+		return &FieldError{Key: "window", Msg: "must be positive"}
+	}
+	if w.CompareBucketMinutes < 0 {
+		return &FieldError{Key: "compare_bucket_minutes", Msg: "must not be negative"}
+	}
+	if !containsFold(compareAggregates, w.CompareAggregate) {
+		return &FieldError{Key: "compare_aggregate", Msg: fmt.Sprintf("must be one of %s", strings.Join(compareAggregates[1:], ", "))}
+	}
+	if w.ProgressSeconds < 0 {
+		return &FieldError{Key: "progress_interval", Msg: "must not be negative"}
+	}
+	if w.MaxAttempts < 0 {
+		return &FieldError{Key: "max_attempts", Msg: "must not be negative"}
+	}
+	if _, err := archivename.Parse(w.ArchiveNameTemplate); err != nil {
+		return &FieldError{Key: "archive_name_template", Msg: err.Error()}
+	}
+	if w.Concurrency < 0 {
+		return &FieldError{Key: "concurrency", Msg: "must not be negative"}
+	}
+	if w.ArchiveTimeoutSeconds < 0 {
+		return &FieldError{Key: "archive_timeout", Msg: "must not be negative"}
+	}
+	if w.BusyTimeoutSeconds < 0 {
+		return &FieldError{Key: "busy_timeout", Msg: "must not be negative"}
+	}
+	switch strings.ToLower(w.DBSynchronous) {
+	case "", "off", "normal", "full", "extra":
+	default:
+		return &FieldError{Key: "db_synchronous", Msg: "must be one of off, normal, full, extra"}
+	}
+	for i, pragma := range w.DBPragmas {
+		if !pragmaPattern.MatchString(pragma) {
+			return &FieldError{Key: fmt.Sprintf("db_pragmas[%d]", i), Msg: fmt.Sprintf("%q is not a pragma like cache_size(-20000)", pragma)}
+		}
+	}
+	if w.InsertBatch < 0 {
+		return &FieldError{Key: "insert_batch", Msg: "must not be negative"}
+	}
+	if w.WorkRetentionHours < 0 {
+		return &FieldError{Key: "work_retention_hours", Msg: "must not be negative"}
+	}
+	if w.DoneRetentionDays < 0 {
+		return &FieldError{Key: "done_retention_days", Msg: "must not be negative"}
+	}
+	if w.RawObservationDays < 0 {
+		return &FieldError{Key: "raw_observation_days", Msg: "must not be negative"}
+	}
+	if w.EvidenceMaxLength < 0 {
+		return &FieldError{Key: "evidence_max_length", Msg: "must not be negative"}
+	}
+	for i, pattern := range w.EvidenceRedact {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return &FieldError{Key: fmt.Sprintf("evidence_redact[%d]", i), Msg: err.Error()}
+		}
+	}
+	if strings.HasPrefix(w.DoneArchiveTo, "s3:") {
+		if u, err := url.Parse(w.DoneArchiveTo); err != nil || u.Scheme != "s3" || u.Host == "" {
+			return &FieldError{Key: "done_archive_to", Msg: "must be a directory or s3://bucket/prefix"}
+		}
+	}
+	if w.HourLayout != "" {
+		if err := timeparse.CheckHourLayout(w.HourLayout); err != nil {
+			return &FieldError{Key: "hour_layout", Msg: err.Error()}
+		}
+	}
+	for version := range w.PayloadVersions {
+		if n, err := strconv.Atoi(version); err != nil || n < 2 {
+			return &FieldError{Key: "payload_versions", Msg: fmt.Sprintf("version %q must be a number of 2 or more (1 is the built-in schema)", version)}
+		}
+	}
+	if !containsFold(snapshotDedupePolicies, w.SnapshotDedupe) {
+		return &FieldError{Key: "snapshot_dedupe", Msg: fmt.Sprintf("must be one of %s", strings.Join(snapshotDedupePolicies[1:], ", "))}
+	}
+	if !containsFold(duplicateArchivePolicies, w.DuplicateArchives) {
+		return &FieldError{Key: "duplicate_archives", Msg: fmt.Sprintf("must be one of %s", strings.Join(duplicateArchivePolicies[1:], ", "))}
+	}
+	if (w.SiteID == "") != (w.DeviceID == "") {
+		return &FieldError{Key: "site_id", Msg: "site_id and device_id must be set together"}
+	}
+	if !containsFold(ingestOrders, w.IngestOrder) {
+		return &FieldError{Key: "ingest_order", Msg: fmt.Sprintf("must be one of %s", strings.Join(ingestOrders[1:], ", "))}
+	}
+	for key, fields := range w.WorkFields {
+		site, device, hasDevice := strings.Cut(key, "/")
+		if site == "" || (hasDevice && (device == "" || strings.Contains(device, "/"))) {
+			return &FieldError{Key: "work_fields", Msg: fmt.Sprintf("key %q: expected site or site/device", key)}
+		}
+		if len(fields) == 0 {
+			return &FieldError{Key: "work_fields." + key, Msg: "must list at least one work_field"}
+		}
+	}
+	if strings.EqualFold(w.IngestOrder, "priority") && len(w.PrioritySites) == 0 {
+		return &FieldError{Key: "priority_sites", Msg: "is required for ingest_order priority"}
+	}
+	if err := validatePublish(w.PublishURL, w.PublishSubject); err != nil {
+		return err
+	}
+	if w.PublishBatch < 0 {
+		return &FieldError{Key: "publish_batch", Msg: "must not be negative"}
+	}
+	if !containsFold(payloadCodecs, w.PayloadCodec) {
+		return &FieldError{Key: "payload_codec", Msg: fmt.Sprintf("must be one of %s", strings.Join(payloadCodecs[1:], ", "))}
+	}
+	if err := validateDecoders(w.Decoders); err != nil {
+		return err
+	}
+	if err := validateLogging(w.Logging()); err != nil {
+		return err
+	}
+	return validateTimestamps(w.TimestampLayouts, w.Timezone)
+}
+
+// publishSchemes are the publish_url schemes the worker can publish to.
+var publishSchemes = []string{"nats", "kafka+http", "kafka+https", "http", "https"}
+
+func validatePublish(rawURL, subject string) error {
+	if rawURL == "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return &FieldError{Key: "publish_url", Msg: fmt.Sprintf("%q is not a valid url", rawURL)}
+	}
+	if !containsFold(publishSchemes, u.Scheme) {
+		return &FieldError{Key: "publish_url", Msg: fmt.Sprintf("scheme must be one of %s", strings.Join(publishSchemes, ", "))}
+	}
+	if subject == "" && !strings.EqualFold(u.Scheme, "http") && !strings.EqualFold(u.Scheme, "https") {
+		return &FieldError{Key: "publish_subject", Msg: "is required with publish_url"}
+	}
+	return nil
+}
+
+func load(path string, dst any, envPrefix string) error {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := decode(path, data, dst); err != nil {
+			return err
+		}
+	}
+	return applyEnv(dst, envPrefix, os.LookupEnv)
+}
+
+func decode(path string, data []byte, dst any) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, dst); err != nil {
+			var typeErr *yaml.TypeError
+			if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+				return fmt.Errorf("%s: %s", path, typeErr.Errors[0])
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		if err := json.Unmarshal(data, dst); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) && typeErr.Field != "" {
+				return &FieldError{Key: typeErr.Field, Msg: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)}
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// applyEnv overrides fields from PREFIX + upper-cased json key, e.g.
+// FIELD_CLIENT_LOG_ROOT. Lists are comma separated.
+func applyEnv(dst any, prefix string, lookup func(string) (string, bool)) error {
+	value := reflect.ValueOf(dst).Elem()
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		key := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		raw, ok := lookup(prefix + strings.ToUpper(key))
+		if !ok {
+			continue
+		}
+		if err := setField(value.Field(i), strings.TrimSpace(raw)); err != nil {
+			return &FieldError{Key: key, Msg: fmt.Sprintf("invalid value %q from %s%s: %v", raw, prefix, strings.ToUpper(key), err)}
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), raw); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadClientJSONDefaultsAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"site_id":"siteA","outbox_dir":"/out","log_root":"/logs"}`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	t.Setenv("FIELD_CLIENT_LOG_ROOT", "/env/logs")
+	t.Setenv("FIELD_CLIENT_EXCLUDE_DIRS", "ALL, PING")
+
+	cfg, err := LoadClient(path)
+	if err != nil {
+		t.Fatalf("LoadClient: %v", err)
+	}
+	if cfg.SiteID != "siteA" || cfg.LogRoot != "/env/logs" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	if len(cfg.ExcludeDirs) != 2 || cfg.ExcludeDirs[1] != "PING" {
+		t.Fatalf("expected env exclude_dirs, got %v", cfg.ExcludeDirs)
+	}
+	if cfg.FallbackToLatestFile == nil || !*cfg.FallbackToLatestFile || cfg.MaxLines != 5000 || cfg.DuplicateRunThreshold != 3 {
+		t.Fatalf("expected defaults, got %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestLoadClientYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "site_id: siteB\noutbox_dir: /out\nlog_root: /logs\nfallback_to_latest_file: false\ninclude_globs:\n  - WLS*\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cfg, err := LoadClient(path)
+	if err != nil {
+		t.Fatalf("LoadClient: %v", err)
+	}
+	if cfg.SiteID != "siteB" || *cfg.FallbackToLatestFile || len(cfg.IncludeGlobs) != 1 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestLoadClientThresholdsYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "outbox_dir: /out\nlog_root: /logs\nthresholds:\n  default:\n    zero_data: 10\n  types:\n    wls:\n      zero_data: 500\n      duplicates: 2000\n  sensors:\n    WLS3:\n      duplicates: 5000\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cfg, err := LoadClient(path)
+	if err != nil {
+		t.Fatalf("LoadClient: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	wls := cfg.Thresholds.Types["wls"]
+	if *cfg.Thresholds.Default.ZeroData != 10 || *wls.ZeroData != 500 || *wls.Duplicates != 2000 || *cfg.Thresholds.Sensors["WLS3"].Duplicates != 5000 {
+		t.Fatalf("unexpected thresholds %+v", cfg.Thresholds)
+	}
+	negative := -1
+	cfg.Thresholds.Sensors["WLS3"] = ThresholdLimits{Timeout: &negative}
+	var fieldErr *FieldError
+	if err := cfg.Validate(); !errors.As(err, &fieldErr) || fieldErr.Key != "thresholds.sensors.WLS3" {
+		t.Fatalf("expected thresholds.sensors.WLS3 validation error, got %v", err)
+	}
+}
+
+func TestErrorsNameOffendingKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"duplicate_run_threshold":"three"}`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, err := LoadClient(path)
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "duplicate_run_threshold" {
+		t.Fatalf("expected field error for duplicate_run_threshold, got %v", err)
+	}
+
+	t.Setenv("FIELD_WORKER_WINDOW", "soon")
+	_, err = LoadWorker("")
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "window" {
+		t.Fatalf("expected field error for window, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "outbox_dir" {
+		t.Fatalf("expected outbox_dir validation error, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs", OutboxDir: "/out", PayloadFormat: "octal"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "payload_format" {
+		t.Fatalf("expected payload_format validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, HourLayout: "2006-01-02"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "hour_layout" {
+		t.Fatalf("expected hour_layout validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, PayloadCodec: "lz4"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "payload_codec" {
+		t.Fatalf("expected payload_codec validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, Timezone: "Mars/Olympus"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "timezone" {
+		t.Fatalf("expected timezone validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, SnapshotDedupe: "newest"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "snapshot_dedupe" {
+		t.Fatalf("expected snapshot_dedupe validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, IngestOrder: "priority"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "priority_sites" {
+		t.Fatalf("expected priority_sites validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, PublishURL: "nats://bus:4222"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "publish_subject" {
+		t.Fatalf("expected publish_subject validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, PublishURL: "amqp://bus", PublishSubject: "x"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "publish_url" {
+		t.Fatalf("expected publish_url validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, EvidenceRedact: []string{`operator=(\S+`}}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "evidence_redact[0]" {
+		t.Fatalf("expected evidence_redact[0] validation error, got %v", err)
+	}
+
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, DBSynchronous: "lazy"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "db_synchronous" {
+		t.Fatalf("expected db_synchronous validation error, got %v", err)
+	}
+	err = Worker{Incoming: "i", Work: "w", Done: "d", DB: "db", Mapping: "m", WindowSeconds: 3, DBPragmas: []string{"cache_size(-20000)", "foreign_keys(1)&_txlock=deferred"}}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "db_pragmas[1]" {
+		t.Fatalf("expected db_pragmas[1] validation error, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs", OutboxDir: "/out", UploadTargets: []UploadTarget{
+		{Name: "central", URL: "sftp://field@central/incoming"},
+		{Name: "central", URL: "/mnt/customer"},
+	}}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "upload_targets[1]" {
+		t.Fatalf("expected upload_targets[1] validation error, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs", OutboxDir: "/out", UploadTargets: []UploadTarget{
+		{Name: "customer", URL: "https://customer.example/drop", Secret: "customer_token"},
+	}}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "upload_targets[0]" {
+		t.Fatalf("expected upload_targets[0] secret validation error, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs", OutboxDir: "/out", UploadWindows: []string{"01:00-5"}}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "upload_windows" {
+		t.Fatalf("expected upload_windows validation error, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs", OutboxDir: "/out", WebhookURL: "https://monitor.example/hook", WebhookSecret: "hook_key"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "webhook_secret" {
+		t.Fatalf("expected webhook_secret validation error, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs", OutboxDir: "/out", Thresholds: Thresholds{Types: map[string]ThresholdLimits{"FLOW": {}}}}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "thresholds.types" {
+		t.Fatalf("expected thresholds.types validation error, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs", OutboxDir: "/out", SensorSchedules: map[string][]string{"GATE3": {"06:00-06:00"}}}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "sensor_schedules.GATE3" {
+		t.Fatalf("expected sensor_schedules.GATE3 validation error, got %v", err)
+	}
+
+	err = Client{LogRoot: "/logs", OutboxDir: "/out", LogLevel: "verbose"}.Validate()
+	if !errors.As(err, &fieldErr) || fieldErr.Key != "log_level" {
+		t.Fatalf("expected log_level validation error, got %v", err)
+	}
+	if level := (Client{Debug: true}).Logging().Level; level != "debug" {
+		t.Fatalf("expected debug to imply log level debug, got %q", level)
+	}
+}
+
+func TestLoadWorkerDefaults(t *testing.T) {
+	cfg, err := LoadWorker("")
+	if err != nil {
+		t.Fatalf("LoadWorker: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, DefaultWorker()) {
+		t.Fatalf("expected defaults, got %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestSetSecret(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "config.json")
+	os.WriteFile(jsonPath, []byte(`{"site_id":"siteA","outbox_dir":"/out","log_root":"/logs"}`), 0o600)
+	yamlPath := filepath.Join(dir, "config.yaml")
+	os.WriteFile(yamlPath, []byte("# field PC\nsite_id: siteB\nsecrets:\n  user: field\n"), 0o600)
+
+	for _, path := range []string{jsonPath, yamlPath} {
+		if err := SetSecret(path, "upload_token", "enc:v1:abc"); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		cfg, err := LoadClient(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if cfg.Secrets["upload_token"] != "enc:v1:abc" || cfg.SiteID == "" {
+			t.Fatalf("%s: unexpected config %+v", path, cfg)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+			t.Fatalf("%s: mode changed to %v", path, info.Mode())
+		}
+	}
+	data, _ := os.ReadFile(yamlPath)
+	if cfg, _ := LoadClient(yamlPath); cfg.Secrets["user"] != "field" || !strings.HasPrefix(string(data), "# field PC") {
+		t.Fatalf("expected existing YAML content to survive:\n%s", data)
+	}
+	if err := SetSecret(jsonPath, "bad name", "x"); err == nil {
+		t.Fatal("expected invalid name to be rejected")
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CheckSecretName accepts names usable as environment variable suffixes.
+func CheckSecretName(name string) error {
+	if name == "" {
+		return errors.New("secret name must not be empty")
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return fmt.Errorf("secret name %q may only contain letters, digits and _", name)
+		}
+	}
+	return nil
+}
+
+// SetSecret stores value under secrets.<name> in the config file at path,
+// keeping every other setting. YAML files keep their comments and key
+// order; JSON files are rewritten with sorted keys.
+func SetSecret(path, name, value string) error {
+	if err := CheckSecretName(name); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var updated []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		updated, err = setYAMLSecret(data, name, value)
+	default:
+		updated, err = setJSONSecret(data, name, value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	partial := path + ".partial"
+	if err := os.WriteFile(partial, updated, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+func setJSONSecret(data []byte, name, value string) ([]byte, error) {
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	secrets := map[string]string{}
+	if raw, ok := doc["secrets"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &secrets); err != nil {
+			return nil, fmt.Errorf("secrets: %w", err)
+		}
+	}
+	secrets[name] = value
+	raw, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	doc["secrets"] = raw
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func setYAMLSecret(data []byte, name, value string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("top level is not a mapping")
+	}
+	secrets := mappingValue(root, "secrets")
+	if secrets.Kind != yaml.MappingNode {
+		*secrets = yaml.Node{Kind: yaml.MappingNode}
+	}
+	*mappingValue(secrets, name) = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	return yaml.Marshal(&doc)
+}
+
+// mappingValue returns the value node for key, appending an empty one if the
+// key is missing.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	value := &yaml.Node{}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
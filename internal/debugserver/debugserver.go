@@ -0,0 +1,74 @@
+// Package debugserver exposes net/http/pprof and the build version on an
+// explicitly configured address. Nothing is registered on
+// http.DefaultServeMux.
+package debugserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"workfield/internal/buildinfo"
+)
+
+type Server struct {
+	http     *http.Server
+	mux      *http.ServeMux
+	listener net.Listener
+}
+
+// Start listens on addr (e.g. "127.0.0.1:6060") and serves /debug/pprof/ in
+// the background. Binding errors are returned immediately so a typo in the
+// flag fails the run instead of silently disabling profiling.
+func Start(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("pprof listen %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/version", serveVersion)
+
+	server := &Server{
+		http:     &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		mux:      mux,
+		listener: listener,
+	}
+	go func() {
+		if err := server.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("pprof server stopped", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
+
+// Handle adds a handler next to the debug endpoints, e.g. /metrics.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Addr is the bound address, useful when addr used port 0.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.http.Shutdown(ctx)
+}
@@ -0,0 +1,82 @@
+package debugserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"workfield/internal/buildinfo"
+)
+
+func TestServesPprofIndex(t *testing.T) {
+	server, err := Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer server.Close()
+
+	resp, err := http.Get("http://" + server.Addr() + "/debug/pprof/")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %s", resp.Status)
+	}
+
+	resp, err = http.Get("http://" + server.Addr() + "/")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 outside /debug/pprof/, got %s", resp.Status)
+	}
+}
+
+func TestServesAddedHandler(t *testing.T) {
+	server, err := Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer server.Close()
+	server.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	resp, err := http.Get("http://" + server.Addr() + "/metrics")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Fatalf("expected the added handler, got %s", resp.Status)
+	}
+}
+
+func TestServesVersion(t *testing.T) {
+	server, err := Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer server.Close()
+
+	resp, err := http.Get("http://" + server.Addr() + "/debug/version")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var info buildinfo.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if info.Version == "" || info.GoVersion == "" {
+		t.Fatalf("unexpected version %+v", info)
+	}
+}
+
+func TestStartFailsOnBadAddress(t *testing.T) {
+	if _, err := Start("not-an-address"); err == nil {
+		t.Fatalf("expected listen error")
+	}
+}
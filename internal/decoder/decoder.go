@@ -0,0 +1,235 @@
+// Package decoder runs external decoder plugins for sensor protocols that
+// cannot live in this repository. A plugin is any executable speaking
+// newline-delimited JSON: for every request line on stdin,
+//
+//	{"sensor_type":"WLS","sensor_id":"WLS1","payload":"(FA, FF, 07, ...)"}
+//
+// it writes exactly one response line on stdout, either
+//
+//	{"values":{"value":96,"status":"ok"}}
+//
+// or, when the payload is not a valid frame,
+//
+//	{"error":"bad checksum"}
+//
+// Anything written to stderr is passed through. The process is started on
+// first use and kept running for later payloads.
+package decoder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDecode means the plugin rejected the payload. Other errors mean the
+// plugin itself failed (could not start, crashed, timed out, bad output).
+var ErrDecode = errors.New("payload rejected by decoder")
+
+// DefaultTimeout bounds a single request when Set.Timeout is zero.
+const DefaultTimeout = 5 * time.Second
+
+// DefaultCloseGrace is how long Close waits for a plugin to exit after its
+// stdin is closed when Set.CloseGrace is zero.
+const DefaultCloseGrace = 5 * time.Second
+
+type Request struct {
+	SensorType string `json:"sensor_type"`
+	SensorID   string `json:"sensor_id,omitempty"`
+	Payload    string `json:"payload"`
+}
+
+type Response struct {
+	Values map[string]json.RawMessage `json:"values,omitempty"`
+	Error  string                     `json:"error,omitempty"`
+}
+
+// Set holds one plugin per sensor type. A nil *Set has no decoders.
+type Set struct {
+	Timeout time.Duration
+	// CloseGrace is how long Close lets a plugin exit on its own before
+	// killing it.
+	CloseGrace time.Duration
+	plugins    map[string]*plugin
+}
+
+// NewSet configures plugins from sensor type to command line. Nothing is
+// started until a payload of that type is decoded.
+func NewSet(commands map[string][]string) (*Set, error) {
+	set := &Set{plugins: map[string]*plugin{}}
+	for sensorType, command := range commands {
+		if len(command) == 0 || command[0] == "" {
+			return nil, fmt.Errorf("decoder for %s: empty command", sensorType)
+		}
+		set.plugins[strings.ToUpper(sensorType)] = &plugin{command: command}
+	}
+	return set, nil
+}
+
+// Has reports whether payloads of sensorType go through a plugin.
+func (s *Set) Has(sensorType string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.plugins[strings.ToUpper(sensorType)]
+	return ok
+}
+
+// Decode asks the plugin for sensorType to decode payload into named values.
+func (s *Set) Decode(ctx context.Context, sensorType, sensorID, payload string) (map[string]json.RawMessage, error) {
+	if s == nil {
+		return nil, fmt.Errorf("no decoder for %s", sensorType)
+	}
+	p, ok := s.plugins[strings.ToUpper(sensorType)]
+	if !ok {
+		return nil, fmt.Errorf("no decoder for %s", sensorType)
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return p.decode(ctx, Request{SensorType: sensorType, SensorID: sensorID, Payload: payload})
+}
+
+// Close stops every running plugin. A plugin that has not exited within
+// CloseGrace is killed, with a warning: its requests were all answered.
+func (s *Set) Close() error {
+	if s == nil {
+		return nil
+	}
+	grace := s.CloseGrace
+	if grace <= 0 {
+		grace = DefaultCloseGrace
+	}
+	var errs []error
+	for _, p := range s.plugins {
+		errs = append(errs, p.close(grace))
+	}
+	return errors.Join(errs...)
+}
+
+type plugin struct {
+	command []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func (p *plugin) decode(ctx context.Context, req Request) (map[string]json.RawMessage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return nil, err
+		}
+	}
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		resp Response
+		err  error
+	}
+	done := make(chan result, 1)
+	stdin, stdout := p.stdin, p.stdout
+	go func() {
+		if _, err := stdin.Write(append(line, '\n')); err != nil {
+			done <- result{err: err}
+			return
+		}
+		data, err := stdout.ReadBytes('\n')
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		var resp Response
+		if err := json.Unmarshal(data, &resp); err != nil {
+			done <- result{err: fmt.Errorf("invalid response %q: %w", strings.TrimSpace(string(data)), err)}
+			return
+		}
+		done <- result{resp: resp}
+	}()
+
+	select {
+	case <-ctx.Done():
+		p.stop()
+		return nil, fmt.Errorf("decoder %s: %w", p.command[0], ctx.Err())
+	case r := <-done:
+		if r.err != nil {
+			p.stop()
+			return nil, fmt.Errorf("decoder %s: %w", p.command[0], r.err)
+		}
+		if r.resp.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrDecode, r.resp.Error)
+		}
+		return r.resp.Values, nil
+	}
+}
+
+func (p *plugin) start() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("decoder %s: %w", p.command[0], err)
+	}
+	p.cmd, p.stdin, p.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// stop kills the process; the next request starts a fresh one.
+func (p *plugin) stop() {
+	if p.cmd == nil {
+		return
+	}
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.cmd = nil
+}
+
+// close lets the plugin exit on EOF, killing it if it does not within
+// grace.
+func (p *plugin) close(grace time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return nil
+	}
+	p.stdin.Close()
+	cmd := p.cmd
+	p.cmd = nil
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(grace):
+		cmd.Process.Kill()
+		<-exited
+		slog.Warn("decoder killed after not exiting on close", "command", p.command[0], "grace", grace)
+		return nil
+	}
+}
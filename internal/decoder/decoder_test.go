@@ -0,0 +1,113 @@
+package decoder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperPlugin is not a real test: the other tests run the test binary
+// with DECODER_HELPER set and use it as the plugin.
+func TestHelperPlugin(t *testing.T) {
+	mode := os.Getenv("DECODER_HELPER")
+	if mode == "" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req Request
+		json.Unmarshal(scanner.Bytes(), &req)
+		switch {
+		case mode == "hang":
+			time.Sleep(time.Minute)
+		case strings.HasPrefix(req.Payload, "bad"):
+			fmt.Println(`{"error":"bad frame"}`)
+		default:
+			fmt.Printf(`{"values":{"value":%q,"type":%q}}`+"\n", req.Payload, req.SensorType)
+		}
+	}
+	if mode == "linger" {
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+func helperSet(t *testing.T, mode string) *Set {
+	t.Helper()
+	t.Setenv("DECODER_HELPER", mode)
+	set, err := NewSet(map[string][]string{"wls": {os.Args[0], "-test.run=^TestHelperPlugin$"}})
+	if err != nil {
+		t.Fatalf("NewSet: %v", err)
+	}
+	t.Cleanup(func() { set.Close() })
+	return set
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	set := helperSet(t, "echo")
+	if !set.Has("WLS") || set.Has("GATE") {
+		t.Fatalf("unexpected Has result")
+	}
+	for i := 0; i < 3; i++ {
+		values, err := set.Decode(context.Background(), "WLS", "WLS1", "60")
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if string(values["value"]) != `"60"` || string(values["type"]) != `"WLS"` {
+			t.Fatalf("unexpected values %v", values)
+		}
+	}
+	if _, err := set.Decode(context.Background(), "WLS", "WLS1", "bad"); !errors.Is(err, ErrDecode) {
+		t.Fatalf("expected ErrDecode, got %v", err)
+	}
+	if err := set.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestDecodeTimeoutRestartsPlugin(t *testing.T) {
+	set := helperSet(t, "hang")
+	set.Timeout = 200 * time.Millisecond
+	_, err := set.Decode(context.Background(), "WLS", "WLS1", "60")
+	if err == nil || errors.Is(err, ErrDecode) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func TestCloseKillsLingeringPlugin(t *testing.T) {
+	set := helperSet(t, "linger")
+	set.CloseGrace = 200 * time.Millisecond
+	if _, err := set.Decode(context.Background(), "WLS", "WLS1", "60"); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	start := time.Now()
+	if err := set.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("close took %v", elapsed)
+	}
+}
+
+func TestDecodeFailsForMissingPlugin(t *testing.T) {
+	set, err := NewSet(map[string][]string{"WLS": {"/nonexistent/decoder"}})
+	if err != nil {
+		t.Fatalf("NewSet: %v", err)
+	}
+	if _, err := set.Decode(context.Background(), "WLS", "", "60"); err == nil || errors.Is(err, ErrDecode) {
+		t.Fatalf("expected start error, got %v", err)
+	}
+	var nilSet *Set
+	if nilSet.Has("WLS") {
+		t.Fatalf("nil set has no decoders")
+	}
+	if _, err := NewSet(map[string][]string{"WLS": nil}); err == nil {
+		t.Fatalf("expected empty command error")
+	}
+}
@@ -0,0 +1,254 @@
+// Package e2e drives the ingest pipeline in-process against a throwaway
+// incoming/work/done tree and sqlite database, so tests can cover the path
+// from a client-built archive to stored rows.
+package e2e
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"workfield/internal/archive"
+	"workfield/internal/ingest"
+	"workfield/internal/manifest"
+	"workfield/internal/record"
+	"workfield/internal/timeparse"
+)
+
+type Env struct {
+	t        testing.TB
+	Root     string
+	Incoming string
+	Work     string
+	Done     string
+	DBPath   string
+	DB       *sql.DB
+}
+
+// New creates the directory tree and database under t.TempDir().
+func New(t testing.TB) *Env {
+	t.Helper()
+	root := t.TempDir()
+	env := &Env{
+		t:        t,
+		Root:     root,
+		Incoming: filepath.Join(root, "incoming"),
+		Work:     filepath.Join(root, "work"),
+		Done:     filepath.Join(root, "done"),
+		DBPath:   filepath.Join(root, "db", "field_metrics.sqlite3"),
+	}
+	for _, dir := range []string{env.Incoming, env.Work, env.Done} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+	}
+	db, err := ingest.OpenDB(env.DBPath, ingest.DBOptions{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	env.DB = db
+	return env
+}
+
+// Archive describes a synthetic client archive. Raw maps paths below
+// raw_session/ to their lines.
+type Archive struct {
+	SiteID    string
+	DeviceID  string
+	Date      string
+	Events    []map[string]any
+	Snapshots []record.SensorDataRecord
+	Raw       map[string][]string
+	// Meta, when set, is written as meta.json.
+	Meta map[string]any
+	// Lines are written as they are after the encoded events or snapshots
+	// of the named file, e.g. malformed JSON, and listed in the manifest.
+	Lines map[string][]string
+	// Tamper, when set, runs after the manifest is written and before zipping.
+	Tamper func(dir string)
+}
+
+func (a Archive) Name() string {
+	return a.SiteID + "_" + a.DeviceID + "_" + a.Date + ".zip"
+}
+
+// Snapshot builds a record whose payload carries the given id → value pairs.
+func Snapshot(publishAt time.Time, workField string, values map[int]any) record.SensorDataRecord {
+	ids := make([]int, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	data := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		data = append(data, map[string]any{"id": id, "value": values[id]})
+	}
+	stamp := publishAt.Format(timeparse.LineLayout)
+	payload, _ := json.Marshal(map[string]any{
+		"PublishAt":  stamp,
+		"work_field": workField,
+		"cmd":        "sensor",
+		"data":       data,
+	})
+	return record.SensorDataRecord{CapturedAt: stamp, WorkField: workField, Payload: payload}
+}
+
+// WriteArchive packages a into the incoming directory the way the client
+// does and returns the zip path.
+func (e *Env) WriteArchive(a Archive) string {
+	e.t.Helper()
+	staging := filepath.Join(e.t.TempDir(), "staging")
+	files := map[string]string{}
+
+	var events []string
+	for _, event := range a.Events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			e.t.Fatalf("marshal event: %v", err)
+		}
+		events = append(events, string(line))
+	}
+	files["events.jsonl"] = joinLines(events)
+
+	var snapshots []string
+	for _, snapshot := range a.Snapshots {
+		line, err := record.Encode(snapshot)
+		if err != nil {
+			e.t.Fatalf("encode snapshot: %v", err)
+		}
+		snapshots = append(snapshots, string(line))
+	}
+	files["sensor_data.jsonl"] = joinLines(snapshots)
+	for name, lines := range a.Lines {
+		files[name] += joinLines(lines)
+	}
+
+	for name, lines := range a.Raw {
+		files["raw_session/"+name] = joinLines(lines)
+	}
+	if a.Meta != nil {
+		meta, err := json.Marshal(a.Meta)
+		if err != nil {
+			e.t.Fatalf("marshal meta: %v", err)
+		}
+		files[ingest.MetaFileName] = string(meta) + "\n"
+	}
+
+	names := make([]string, 0, len(files))
+	for name, content := range files {
+		path := filepath.Join(staging, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			e.t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			e.t.Fatalf("write %s: %v", name, err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m, err := manifest.Build(staging, names)
+	if err != nil {
+		e.t.Fatalf("build manifest: %v", err)
+	}
+	if err := manifest.Write(filepath.Join(staging, manifest.FileName), m); err != nil {
+		e.t.Fatalf("write manifest: %v", err)
+	}
+	if a.Tamper != nil {
+		a.Tamper(staging)
+	}
+
+	zipPath := filepath.Join(e.Incoming, a.Name())
+	if err := archive.CreateZip(zipPath, staging, append(names, manifest.FileName)); err != nil {
+		e.t.Fatalf("create zip: %v", err)
+	}
+	return zipPath
+}
+
+// Options returns pipeline options pointing at the env's directories.
+func (e *Env) Options() ingest.Options {
+	return ingest.Options{WorkDir: e.Work, DoneDir: e.Done, Window: 3 * time.Second}
+}
+
+// Run processes the incoming directory and returns per-archive failures.
+func (e *Env) Run(mapping map[string]ingest.SensorMapping, opts ingest.Options) []error {
+	e.t.Helper()
+	failures, err := ingest.ProcessDir(context.Background(), e.Incoming, e.DB, mapping, opts)
+	if err != nil {
+		e.t.Fatalf("process dir: %v", err)
+	}
+	return failures
+}
+
+// MustRun is Run for tests that expect every archive to be ingested.
+func (e *Env) MustRun(mapping map[string]ingest.SensorMapping, opts ingest.Options) {
+	e.t.Helper()
+	if failures := e.Run(mapping, opts); len(failures) != 0 {
+		e.t.Fatalf("unexpected failures: %v", failures)
+	}
+}
+
+// Count returns the number of rows in table matching the optional where
+// clause.
+func (e *Env) Count(table, where string, args ...any) int {
+	e.t.Helper()
+	query := "SELECT COUNT(*) FROM " + table
+	if where != "" {
+		query += " WHERE " + where
+	}
+	var count int
+	if err := e.DB.QueryRow(query, args...).Scan(&count); err != nil {
+		e.t.Fatalf("count %s: %v", table, err)
+	}
+	return count
+}
+
+func (e *Env) AssertCount(table string, want int, where string, args ...any) {
+	e.t.Helper()
+	if got := e.Count(table, where, args...); got != want {
+		e.t.Fatalf("%s where %q: expected %d rows, got %d", table, where, want, got)
+	}
+}
+
+// Results returns the result of every comparison row, keyed by ResultKey.
+func (e *Env) Results() map[string]string {
+	e.t.Helper()
+	rows, err := e.DB.Query(`SELECT sensor_id, publish_at, result FROM comparison_results`)
+	if err != nil {
+		e.t.Fatalf("query results: %v", err)
+	}
+	defer rows.Close()
+	results := map[string]string{}
+	for rows.Next() {
+		var sensorID, publishAt, result string
+		if err := rows.Scan(&sensorID, &publishAt, &result); err != nil {
+			e.t.Fatalf("scan: %v", err)
+		}
+		results[sensorID+"@"+publishAt] = result
+	}
+	return results
+}
+
+func ResultKey(sensorID string, publishAt time.Time) string {
+	return sensorID + "@" + publishAt.Format(time.RFC3339Nano)
+}
+
+// Exists reports whether name is present in dir.
+func Exists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,2191 @@
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"workfield/internal/archive"
+	"workfield/internal/archivename"
+	"workfield/internal/buildinfo"
+	"workfield/internal/decoder"
+	"workfield/internal/ingest"
+	"workfield/internal/metrics"
+	"workfield/internal/publish"
+	"workfield/internal/receipt"
+	"workfield/internal/record"
+)
+
+var testMapping = map[string]ingest.SensorMapping{
+	"1": {SensorID: "WLS1", Type: "WLS", Field: "value", Tolerance: 1.0},
+	"4": {SensorID: "GATE1", Type: "GATE", Field: "value"},
+}
+
+func sampleArchive() Archive {
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	t1 := t0.Add(10 * time.Minute)
+	return Archive{
+		SiteID:   "siteA",
+		DeviceID: "device01",
+		Date:     "20260120",
+		Events: []map[string]any{
+			{"hour": "2026-01-20T00", "work_field": "field-01"},
+		},
+		Snapshots: []record.SensorDataRecord{
+			Snapshot(t0, "field-01", map[int]any{1: 60, 4: "open"}),
+			Snapshot(t1, "field-01", map[int]any{1: 61, 4: "open"}),
+		},
+		Raw: map[string][]string{
+			"WLS1/2026-01-20.log": {
+				"2026-01-20 00:00:01.200 rcv: 60",
+				"2026-01-20 00:10:01.100 rcv: 70",
+			},
+			"GATE1/2026-01-20.log": {
+				"2026-01-20 00:00:01.000 rcv: OPEN",
+			},
+		},
+	}
+}
+
+// ingestSample ingests sampleArchive with testMapping into a new Env.
+func ingestSample(t *testing.T) (*Env, Archive) {
+	t.Helper()
+	env := New(t)
+	a := sampleArchive()
+	env.WriteArchive(a)
+	env.MustRun(testMapping, env.Options())
+	return env, a
+}
+
+func TestPipelineIngestsArchive(t *testing.T) {
+	env, a := ingestSample(t)
+	if !Exists(env.Done, a.Name()) || Exists(env.Incoming, a.Name()) {
+		t.Fatalf("expected archive moved to done")
+	}
+	env.AssertCount("hourly_metrics", 1, "")
+	env.AssertCount("sensor_data_snapshots", 2, "site_id = ? AND device_id = ?", "siteA", "device01")
+	env.AssertCount("comparison_results", 4, "")
+	env.AssertCount("comparison_results", 4, "worker_version = ?", buildinfo.Get().Short())
+
+	done, err := receipt.Read(filepath.Join(env.Done, receipt.Name(a.Name())))
+	if err != nil {
+		t.Fatalf("done receipt: %v", err)
+	}
+	var logged int64
+	if err := env.DB.QueryRow(`SELECT snapshots FROM ingest_log WHERE id = ?`, done.AuditID).Scan(&logged); err != nil || logged != 2 {
+		t.Fatalf("audit row %d: snapshots=%d, %v", done.AuditID, logged, err)
+	}
+
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	results := env.Results()
+	want := map[string]string{
+		ResultKey("WLS1", t0):                      "MATCH",
+		ResultKey("GATE1", t0):                     "MATCH",
+		ResultKey("WLS1", t0.Add(10*time.Minute)):  "MISMATCH",
+		ResultKey("GATE1", t0.Add(10*time.Minute)): "MISSING_RAW",
+	}
+	for key, result := range want {
+		if results[key] != result {
+			t.Fatalf("%s: expected %s, got %q (all: %v)", key, result, results[key], results)
+		}
+	}
+}
+
+func TestPipelineRejectsManifestDrift(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Tamper = func(dir string) {
+		path := filepath.Join(dir, "sensor_data.jsonl")
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString("{}\n"); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	env.WriteArchive(a)
+
+	failures := env.Run(testMapping, env.Options())
+	if len(failures) != 1 {
+		t.Fatalf("expected one failure, got %v", failures)
+	}
+	var archiveErr *ingest.ArchiveError
+	if !errors.As(failures[0], &archiveErr) || archiveErr.Stage != "manifest" || !errors.Is(failures[0], ingest.ErrManifestMismatch) {
+		t.Fatalf("expected manifest mismatch, got %v", failures[0])
+	}
+	if !Exists(env.Incoming, a.Name()) {
+		t.Fatalf("expected rejected archive to stay in incoming")
+	}
+	env.AssertCount("sensor_data_snapshots", 0, "")
+	env.AssertCount("comparison_results", 0, "")
+}
+
+func TestPipelineStreamsArchive(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	env.WriteArchive(a)
+	opts := env.Options()
+	opts.Stream = true
+	env.MustRun(testMapping, opts)
+	env.AssertCount("hourly_metrics", 1, "")
+	env.AssertCount("sensor_data_snapshots", 2, "")
+	env.AssertCount("comparison_results", 4, "")
+	env.AssertCount("comparison_results", 1, "result = 'MISMATCH'")
+	work := filepath.Join(env.Work, "siteA_device01_20260120")
+	if Exists(work, "sensor_data.jsonl") || Exists(work, "events.jsonl") || !Exists(work, "raw_session/WLS1/2026-01-20.log") {
+		t.Fatal("expected only raw_session in the work tree")
+	}
+
+	// Without enabled sensors raw_session is only verified.
+	b := sampleArchive()
+	b.DeviceID = "device02"
+	env.WriteArchive(b)
+	env.MustRun(map[string]ingest.SensorMapping{}, opts)
+	if Exists(filepath.Join(env.Work, "siteA_device02_20260120"), "raw_session") {
+		t.Fatal("expected raw_session not extracted")
+	}
+
+	// A file that does not match the manifest fails the archive, whether
+	// it is read by a stage or only verified.
+	for _, name := range []string{"sensor_data.jsonl", "raw_session/GATE1/2026-01-20.log"} {
+		c := sampleArchive()
+		c.DeviceID = "device03"
+		c.Tamper = func(dir string) {
+			f, err := os.OpenFile(filepath.Join(dir, filepath.FromSlash(name)), os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer f.Close()
+			f.WriteString("{}\n")
+		}
+		env.WriteArchive(c)
+		failures := env.Run(map[string]ingest.SensorMapping{}, opts)
+		if len(failures) != 1 || !errors.Is(failures[0], ingest.ErrManifestMismatch) {
+			t.Fatalf("%s: expected manifest mismatch, got %v", name, failures)
+		}
+		env.AssertCount("sensor_data_snapshots", 0, "device_id = 'device03'")
+		os.Remove(filepath.Join(env.Incoming, c.Name()))
+	}
+}
+
+func TestPipelineRollsBackFailedArchive(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	env.WriteArchive(a)
+	if _, err := env.DB.Exec(`CREATE TRIGGER fail_compare BEFORE INSERT ON comparison_results
+		WHEN (SELECT COUNT(*) FROM comparison_results) >= 2 BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatalf("trigger: %v", err)
+	}
+	if failures := env.Run(testMapping, env.Options()); len(failures) != 1 {
+		t.Fatalf("expected one failure, got %v", failures)
+	}
+	for _, table := range []string{"hourly_metrics", "sensor_data_snapshots", "comparison_results", "ingest_log"} {
+		env.AssertCount(table, 0, "")
+	}
+	if !Exists(env.Incoming, a.Name()) || Exists(env.Done, a.Name()) {
+		t.Fatalf("expected the archive to stay in incoming")
+	}
+
+	// The retry stores everything once, as if the first run never happened.
+	if _, err := env.DB.Exec(`DROP TRIGGER fail_compare`); err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	env.MustRun(testMapping, env.Options())
+	env.AssertCount("sensor_data_snapshots", 2, "")
+	env.AssertCount("comparison_results", 4, "")
+	env.AssertCount("snapshot_duplicates", 0, "")
+	if !Exists(env.Done, a.Name()) {
+		t.Fatalf("expected the archive in done")
+	}
+}
+
+func TestPipelineSummarizesRun(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+	broken := sampleArchive()
+	broken.DeviceID = "device02"
+	broken.Tamper = func(dir string) {
+		os.WriteFile(filepath.Join(dir, "events.jsonl"), []byte("{}\n"), 0o644)
+	}
+	env.WriteArchive(broken)
+
+	opts := env.Options()
+	opts.Summary = &ingest.Summary{}
+	if failures := env.Run(testMapping, opts); len(failures) != 1 {
+		t.Fatalf("expected one failure, got %v", failures)
+	}
+	got := opts.Summary.Totals()
+	if got.Archives != 2 || got.Processed != 1 || got.Failed != 1 || got.Skipped != 0 {
+		t.Fatalf("unexpected archive counts %+v", got)
+	}
+	if got.Rows["snapshots"] != 2 || got.Rows["comparisons"] != 4 || got.Rows["events"] != 1 {
+		t.Fatalf("unexpected rows %v", got.Rows)
+	}
+	if got.Mismatches != 1 {
+		t.Fatalf("expected the 61 vs 70 reading to be the only mismatch, got %d", got.Mismatches)
+	}
+}
+
+func TestRecordRunKeepsRunHistory(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+	broken := sampleArchive()
+	broken.DeviceID = "device02"
+	broken.Tamper = func(dir string) {
+		os.WriteFile(filepath.Join(dir, "events.jsonl"), []byte("{}\n"), 0o644)
+	}
+	env.WriteArchive(broken)
+
+	opts := env.Options()
+	opts.Summary = &ingest.Summary{}
+	started := time.Now()
+	failures := env.Run(testMapping, opts)
+	ctx := context.Background()
+	first, err := ingest.RecordRun(ctx, env.DB, ingest.NewIngestRun(started, opts.Summary.Totals(), failures))
+	if err != nil {
+		t.Fatalf("record run: %v", err)
+	}
+	second, err := ingest.RecordRun(ctx, env.DB, ingest.NewIngestRun(time.Now(), ingest.RunSummary{}, nil))
+	if err != nil {
+		t.Fatalf("record run: %v", err)
+	}
+
+	runs, err := ingest.LastRuns(ctx, env.DB, 5)
+	if err != nil {
+		t.Fatalf("last runs: %v", err)
+	}
+	if len(runs) != 2 || runs[0].ID != second || runs[1].ID != first {
+		t.Fatalf("expected runs %d and %d newest first, got %+v", second, first, runs)
+	}
+	got := runs[1]
+	if got.Archives != 2 || got.Processed != 1 || got.Failed != 1 || got.Rows["snapshots"] != 2 || got.Rows["comparisons"] != 4 {
+		t.Fatalf("unexpected recorded run %+v", got)
+	}
+	if got.ErrorCount != 1 || len(got.Errors) != 1 || !strings.Contains(got.Errors[0], broken.Name()) {
+		t.Fatalf("expected the broken archive's error, got %d %q", got.ErrorCount, got.Errors)
+	}
+	if got.StartedAt.IsZero() || got.FinishedAt.Before(got.StartedAt) {
+		t.Fatalf("unexpected run times %s to %s", got.StartedAt, got.FinishedAt)
+	}
+	if latest, _ := ingest.LastRuns(ctx, env.DB, 1); len(latest) != 1 || latest[0].ID != second {
+		t.Fatalf("expected only run %d, got %+v", second, latest)
+	}
+}
+
+func TestPipelineInsertsInBatches(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	a.Events, a.Snapshots = nil, nil
+	for i := 0; i < 5; i++ {
+		a.Events = append(a.Events, map[string]any{"hour": fmt.Sprintf("2026-01-20T%02d", i), "work_field": "field-01"})
+		a.Snapshots = append(a.Snapshots, Snapshot(t0.Add(time.Duration(i)*time.Minute), "field-01", map[int]any{1: 60 + i}))
+	}
+	// A second copy while the first still waits in its batch.
+	a.Snapshots = slices.Insert(a.Snapshots, 1, Snapshot(t0, "field-01", map[int]any{1: 99}))
+	env.WriteArchive(a)
+
+	opts := env.Options()
+	opts.InsertBatch = 2
+	opts.SnapshotDedupe = ingest.DedupeKeepAll
+	opts.Summary = &ingest.Summary{}
+	env.MustRun(testMapping, opts)
+	env.AssertCount("hourly_metrics", 5, "")
+	env.AssertCount("sensor_data_snapshots", 5, "")
+	env.AssertCount("snapshot_duplicates", 1, "")
+	got := opts.Summary.Totals()
+	if got.Rows["events"] != 5 || got.Rows["snapshots"] != 5 {
+		t.Fatalf("unexpected rows %v", got.Rows)
+	}
+	if got.RowsPerSec <= 0 {
+		t.Fatalf("expected a rows/sec rate, got %v", got.RowsPerSec)
+	}
+}
+
+// v2Snapshot is a snapshot in a payload schema that renames PublishAt, data,
+// id and value.
+func v2Snapshot(publishAt time.Time, values map[int]any) record.SensorDataRecord {
+	stamp := publishAt.Format("2006-01-02 15:04:05.000")
+	var items []map[string]any
+	for id, value := range values {
+		items = append(items, map[string]any{"sensor": id, "reading": value})
+	}
+	payload, _ := json.Marshal(map[string]any{"published_at": stamp, "work_field": "field-01", "sensors": items})
+	return record.SensorDataRecord{CapturedAt: stamp, WorkField: "field-01", Payload: payload}
+}
+
+func TestPipelineSelectsPayloadParserByVersion(t *testing.T) {
+	env := New(t)
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	v2 := sampleArchive()
+	v2.Meta = map[string]any{"version": 2, "firmware": "2.0.3"}
+	v2.Snapshots = []record.SensorDataRecord{v2Snapshot(t0, map[int]any{1: 60, 4: "open"})}
+	env.WriteArchive(v2)
+	v3 := sampleArchive()
+	v3.DeviceID = "device02"
+	v3.Meta = map[string]any{"version": 3}
+	env.WriteArchive(v3)
+
+	parser, err := ingest.AliasParser(
+		map[string]string{"PublishAt": "published_at", "data": "sensors"},
+		map[string]string{"id": "sensor", "value": "reading"})
+	if err != nil {
+		t.Fatalf("parser: %v", err)
+	}
+	opts := env.Options()
+	opts.PayloadParsers = map[int]ingest.PayloadParser{2: parser}
+	failures := env.Run(testMapping, opts)
+	if len(failures) != 1 || !errors.Is(failures[0], ingest.ErrPayloadVersion) {
+		t.Fatalf("expected the v3 archive to be rejected, got %v", failures)
+	}
+
+	results := env.Results()
+	if got := results[ResultKey("WLS1", t0)]; got != "MATCH" {
+		t.Fatalf("expected the v2 payload to be compared, got %q", got)
+	}
+	if got := results[ResultKey("GATE1", t0)]; got != "MATCH" {
+		t.Fatalf("expected the v2 gate value to be compared, got %q", got)
+	}
+	env.AssertCount("sensor_data_snapshots", 1, "payload_version = 2 AND payload_json LIKE '%published_at%'")
+	env.AssertCount("hourly_metrics", 0, "device_id = ?", "device02")
+	if _, err := ingest.AliasParser(map[string]string{"timestamp": "ts"}, nil); err == nil {
+		t.Fatal("expected an unknown base key to be rejected")
+	}
+}
+
+func TestPipelineSkipsDisabledAndSamplesSensors(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Snapshots = nil
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	for i := 0; i < 200; i++ {
+		a.Snapshots = append(a.Snapshots, Snapshot(t0.Add(time.Duration(i)*time.Second), "field-01", map[int]any{1: 60, 4: "open"}))
+	}
+	env.WriteArchive(a)
+
+	disabled := false
+	mapping := map[string]ingest.SensorMapping{
+		"1": {SensorID: "WLS1", Type: "WLS", Field: "value", Tolerance: 1.0, SampleRate: 0.25},
+		"4": {SensorID: "GATE1", Type: "GATE", Field: "value", Enabled: &disabled},
+	}
+	env.MustRun(mapping, env.Options())
+	env.AssertCount("comparison_results", 0, "sensor_id = ?", "GATE1")
+	sampled := env.Count("comparison_results", "sensor_id = ?", "WLS1")
+	if sampled < 20 || sampled > 80 {
+		t.Fatalf("expected about 50 of 200 WLS1 snapshots compared, got %d", sampled)
+	}
+
+	// Sampling depends only on the sensor and publish time.
+	env.DB.Exec(`DELETE FROM comparison_results`)
+	env.DB.Exec(`DELETE FROM sensor_data_snapshots`)
+	os.Rename(filepath.Join(env.Done, a.Name()), filepath.Join(env.Incoming, a.Name()))
+	opts := env.Options()
+	opts.Duplicates = ingest.DuplicateWarn
+	env.MustRun(mapping, opts)
+	env.AssertCount("comparison_results", sampled, "sensor_id = ?", "WLS1")
+}
+
+func bucketArchive() Archive {
+	a := sampleArchive()
+	a.Snapshots = nil
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	for i := 0; i < 15; i++ {
+		level := 60
+		if i >= 10 {
+			level = 70
+		}
+		a.Snapshots = append(a.Snapshots, Snapshot(t0.Add(time.Duration(i)*time.Minute), "field-01", map[int]any{1: level, 4: "open"}))
+	}
+	a.Raw["WLS1/2026-01-20.log"] = []string{
+		"2026-01-20 00:00:01.200 rcv: 60",
+		"2026-01-20 00:05:01.200 rcv: 62",
+		"2026-01-20 00:12:00.000 rcv: 70",
+	}
+	return a
+}
+
+func TestPipelineComparesBuckets(t *testing.T) {
+	for _, tc := range []struct {
+		aggregate ingest.CompareAggregate
+		first     string
+	}{
+		{ingest.AggregateMean, "MATCH"},
+		{ingest.AggregateLast, "MISMATCH"},
+	} {
+		env := New(t)
+		env.WriteArchive(bucketArchive())
+		opts := env.Options()
+		opts.CompareBucket = 10 * time.Minute
+		opts.CompareAggregate = tc.aggregate
+		env.MustRun(testMapping, opts)
+
+		env.AssertCount("comparison_results", 4, "bucket_seconds = 600")
+		results := env.Results()
+		t0 := time.Date(2026, 1, 20, 0, 0, 0, 0, time.Local)
+		t1 := t0.Add(10 * time.Minute)
+		want := map[string]string{
+			ResultKey("WLS1", t0):  tc.first,
+			ResultKey("WLS1", t1):  "MATCH",
+			ResultKey("GATE1", t0): "MATCH",
+			ResultKey("GATE1", t1): "MISSING_RAW",
+		}
+		for key, result := range want {
+			if results[key] != result {
+				t.Errorf("%s %s: expected %s, got %q (all: %v)", tc.aggregate, key, result, results[key], results)
+			}
+		}
+		env.AssertCount("comparison_results", 2, "bucket_snapshots = 10")
+	}
+}
+
+func TestPipelineDecodesRawBeforeComparing(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Raw["WLS1/2026-01-20.log"] = []string{
+		"2026-01-20 00:00:01.200 rcv: FA 00 00 00 00 3C 00 00 00 00 76",
+		"2026-01-20 00:10:01.100 rcv: FA 00 00 00 00 46 00 00 00 00 76",
+	}
+	env.WriteArchive(a)
+
+	mapping := map[string]ingest.SensorMapping{
+		"1": {SensorID: "WLS1", Type: "WLS", Field: "value", Tolerance: 1,
+			RawDecode: &ingest.RawDecode{Format: "hex-csv", Offset: 4, Length: 2}},
+	}
+	env.MustRun(mapping, env.Options())
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	results := env.Results()
+	if got := results[ResultKey("WLS1", t0)]; got != "MATCH" {
+		t.Fatalf("expected 0x003C to match 60, got %q", got)
+	}
+	if got := results[ResultKey("WLS1", t0.Add(10*time.Minute))]; got != "MISMATCH" {
+		t.Fatalf("expected 0x0046 (70) not to match 61, got %q", got)
+	}
+	env.AssertCount("comparison_results", 1, "sensor_id = ? AND raw_value = ?", "WLS1", "60")
+}
+
+func TestPipelineStopsWhenCancelled(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	env.WriteArchive(a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	failures, err := ingest.ProcessDir(ctx, env.Incoming, env.DB, testMapping, env.Options())
+	if !errors.Is(err, context.Canceled) || len(failures) != 0 {
+		t.Fatalf("expected context.Canceled and no failures, got %v %v", err, failures)
+	}
+	if !Exists(env.Incoming, a.Name()) {
+		t.Fatalf("expected archive to stay in incoming")
+	}
+	env.AssertCount("sensor_data_snapshots", 0, "")
+}
+
+func TestPipelineCollectsStageStats(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+
+	opts := env.Options()
+	opts.Stats = &ingest.Stats{}
+	env.MustRun(testMapping, opts)
+	for _, name := range ingest.StageNames() {
+		if got := opts.Stats.Stage(name).Archives; got != 1 {
+			t.Fatalf("%s: expected 1 archive, got %d", name, got)
+		}
+	}
+	if got := opts.Stats.Stage("snapshots").Rows; got != 2 {
+		t.Fatalf("snapshots: expected 2 rows, got %d", got)
+	}
+	compare := opts.Stats.Stage("compare")
+	if compare.Lines != 4 || compare.Rows != 4 {
+		t.Fatalf("compare: expected 4 comparisons and rows, got %+v", compare)
+	}
+}
+
+func TestPipelineKeepsSensorMetrics(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+
+	opts := env.Options()
+	opts.SensorMetrics = &ingest.SensorMetrics{}
+	env.MustRun(testMapping, opts)
+	wls, ok := opts.SensorMetrics.Sensor("WLS1")
+	if !ok || wls.Comparisons != 2 || wls.Match != 1 || wls.Mismatch != 1 || wls.SensorType != "WLS" {
+		t.Fatalf("unexpected WLS1 tally %+v", wls)
+	}
+	gate, _ := opts.SensorMetrics.Sensor("GATE1")
+	if gate.MissingRaw != 1 || gate.MatchRatio() != 0.5 {
+		t.Fatalf("unexpected GATE1 tally %+v", gate)
+	}
+
+	var out strings.Builder
+	if err := metrics.Write(&out, opts.SensorMetrics.Families()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, line := range []string{
+		`field_worker_sensor_match_ratio{sensor_id="WLS1",sensor_type="WLS"} 0.5`,
+		`field_worker_sensor_missing_raw{sensor_id="GATE1",sensor_type="GATE"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestPipelineCountsRunMetrics(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+	broken := sampleArchive()
+	broken.DeviceID = "device02"
+	broken.Tamper = func(dir string) {
+		os.WriteFile(filepath.Join(dir, "events.jsonl"), []byte("{}\n"), 0o644)
+	}
+	env.WriteArchive(broken)
+
+	opts := env.Options()
+	opts.RunMetrics = &ingest.RunMetrics{}
+	if failures := env.Run(testMapping, opts); len(failures) != 1 {
+		t.Fatalf("expected one failure, got %v", failures)
+	}
+	var out strings.Builder
+	if err := metrics.Write(&out, opts.RunMetrics.Families()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	for _, line := range []string{
+		`field_worker_archives_total{status="processed"} 1`,
+		`field_worker_archives_total{status="failed"} 1`,
+		`field_worker_rows_inserted_total{table="sensor_data_snapshots"} 2`,
+		`field_worker_rows_inserted_total{table="comparison_results"} 4`,
+		`field_worker_comparisons_total{result="MATCH"} 2`,
+		`field_worker_comparisons_total{result="MISMATCH"} 1`,
+		`field_worker_comparisons_total{result="MISSING_RAW"} 1`,
+		`field_worker_archive_duration_seconds_bucket{le="+Inf"} 2`,
+		`field_worker_archive_duration_seconds_count 2`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "field_worker_last_ingest_timestamp_seconds ") {
+		t.Fatalf("missing the last ingest time in:\n%s", out.String())
+	}
+}
+
+// recordingPublisher keeps what the pipeline publishes.
+type recordingPublisher struct {
+	messages []publish.Message
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, messages []publish.Message) error {
+	p.messages = append(p.messages, messages...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+func TestPipelinePublishesResults(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+
+	publisher := &recordingPublisher{}
+	opts := env.Options()
+	opts.Publisher = publisher
+	env.MustRun(testMapping, opts)
+	if len(publisher.messages) != 4 {
+		t.Fatalf("expected 4 published results, got %d", len(publisher.messages))
+	}
+	counts := map[string]int{}
+	for _, message := range publisher.messages {
+		if message.Key != "siteA/device01" {
+			t.Fatalf("unexpected key %q", message.Key)
+		}
+		var result struct {
+			SensorID string `json:"sensor_id"`
+			Result   string `json:"result"`
+		}
+		if err := json.Unmarshal(message.Value, &result); err != nil {
+			t.Fatalf("decode %s: %v", message.Value, err)
+		}
+		counts[result.SensorID+":"+result.Result]++
+	}
+	if counts["WLS1:MATCH"] != 1 || counts["WLS1:MISMATCH"] != 1 {
+		t.Fatalf("unexpected results %v", counts)
+	}
+
+	// Re-ingesting the same archive adds no rows, so nothing is published.
+	env.WriteArchive(sampleArchive())
+	publisher.messages = nil
+	env.MustRun(testMapping, opts)
+	if len(publisher.messages) != 0 {
+		t.Fatalf("expected no results for a re-sent archive, got %d", len(publisher.messages))
+	}
+}
+
+// progressPublisher records the run's progress when an archive publishes.
+type progressPublisher struct {
+	progress *ingest.Progress
+	reports  []ingest.ProgressReport
+}
+
+func (p *progressPublisher) Publish(ctx context.Context, messages []publish.Message) error {
+	p.reports = append(p.reports, p.progress.Report())
+	return nil
+}
+
+func (p *progressPublisher) Close() error { return nil }
+
+func TestPipelineReportsProgress(t *testing.T) {
+	env := New(t)
+	renamedArchive(t, env)
+	for _, date := range []string{"20260121", "20260122"} {
+		a := sampleArchive()
+		a.Date = date
+		env.WriteArchive(a)
+	}
+
+	opts := env.Options()
+	opts.Progress = &ingest.Progress{}
+	publisher := &progressPublisher{progress: opts.Progress}
+	opts.Publisher = publisher
+	opts.PublishSummaries = true
+	if failures := env.Run(testMapping, opts); len(failures) != 1 {
+		t.Fatalf("expected the renamed archive to fail, got %v", failures)
+	}
+
+	if len(publisher.reports) != 2 {
+		t.Fatalf("expected 2 reports, got %+v", publisher.reports)
+	}
+	// renamed.zip sorts first and fails in the name stage.
+	first := publisher.reports[0]
+	if first.Total != 3 || first.Done != 1 || first.Failed != 1 || first.Current != "siteA_device01_20260121.zip" || first.Phase != "move" || first.ETA <= 0 {
+		t.Fatalf("unexpected report during the second archive: %+v", first)
+	}
+	if second := publisher.reports[1]; second.Done != 2 || second.Current != "siteA_device01_20260122.zip" {
+		t.Fatalf("unexpected report during the third archive: %+v", second)
+	}
+	final := opts.Progress.Report()
+	if !final.Finished || final.Done != 3 || final.Failed != 1 || final.Current != "" || final.ETA != 0 {
+		t.Fatalf("unexpected final report: %+v", final)
+	}
+}
+
+func TestPipelinePublishesSummaries(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+
+	publisher := &recordingPublisher{}
+	opts := env.Options()
+	opts.Publisher = publisher
+	opts.PublishSummaries = true
+	env.MustRun(testMapping, opts)
+	if len(publisher.messages) != 1 {
+		t.Fatalf("expected one summary, got %d", len(publisher.messages))
+	}
+	var summary struct {
+		Archive    string           `json:"archive"`
+		Rows       map[string]int64 `json:"rows"`
+		Mismatches int64            `json:"mismatches"`
+	}
+	if err := json.Unmarshal(publisher.messages[0].Value, &summary); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if summary.Archive != sampleArchive().Name() || summary.Rows["comparisons"] != 4 || summary.Mismatches != 1 {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+}
+
+// backfillArchive re-sends sampleArchive's snapshots: the first unchanged,
+// the second with a corrected WLS1 value that now matches the raw log.
+func backfillArchive() Archive {
+	a := sampleArchive()
+	a.Date = "20260120_backfill"
+	t1 := time.Date(2026, 1, 20, 0, 10, 1, 0, time.Local)
+	a.Snapshots[1] = Snapshot(t1, "field-01", map[int]any{1: 70, 4: "open"})
+	return a
+}
+
+func TestPipelineDedupesResentSnapshots(t *testing.T) {
+	t1 := time.Date(2026, 1, 20, 0, 10, 1, 0, time.Local)
+	for _, tc := range []struct {
+		policy     ingest.DedupePolicy
+		superseded int
+		wantResult string
+		duplicates int
+	}{
+		{ingest.DedupeSkip, 0, "MISMATCH", 0},
+		{ingest.DedupeSupersede, 1, "MATCH", 0},
+		{ingest.DedupeKeepAll, 0, "MISMATCH", 2},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			env := New(t)
+			opts := env.Options()
+			opts.SnapshotDedupe = tc.policy
+			opts.HashChain = true
+			for _, a := range []Archive{sampleArchive(), backfillArchive()} {
+				env.WriteArchive(a)
+				env.MustRun(testMapping, opts)
+			}
+			env.AssertCount("sensor_data_snapshots", 2, "")
+			env.AssertCount("sensor_data_snapshots", 2, "payload_hash IS NOT NULL")
+			env.AssertCount("sensor_data_snapshots", tc.superseded, "ingest_file = ?", backfillArchive().Name())
+			env.AssertCount("snapshot_duplicates", tc.duplicates, "")
+			env.AssertCount("comparison_results", 4, "")
+			env.AssertCount("purge_log", tc.superseded, "ingest_file = ? AND reason = ? AND rows_deleted > 0",
+				sampleArchive().Name(), "superseded by "+backfillArchive().Name())
+			if got := env.Results()[ResultKey("WLS1", t1)]; got != tc.wantResult {
+				t.Fatalf("WLS1 at t1: got %q, want %q", got, tc.wantResult)
+			}
+			if _, err := ingest.VerifyChain(context.Background(), env.DB, ingest.ChainHead{}); err != nil {
+				t.Fatalf("verify chain: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeviceSettingsOverrideOptions(t *testing.T) {
+	env := New(t)
+	ctx := context.Background()
+	supersede, publish := "supersede", false
+	if err := ingest.SetDeviceSettings(ctx, env.DB, ingest.DeviceSettings{SiteID: "siteA", DeviceID: "device01", SnapshotDedupe: &supersede}); err != nil {
+		t.Fatalf("set device settings: %v", err)
+	}
+	if err := ingest.SetDeviceSettings(ctx, env.DB, ingest.DeviceSettings{SiteID: "siteA", Publish: &publish}); err != nil {
+		t.Fatalf("set site settings: %v", err)
+	}
+	bad := "newest"
+	if err := ingest.SetDeviceSettings(ctx, env.DB, ingest.DeviceSettings{SiteID: "siteA", SnapshotDedupe: &bad}); err == nil {
+		t.Fatalf("expected an unknown dedupe policy to be rejected")
+	}
+
+	publisher := &recordingPublisher{}
+	opts := env.Options()
+	opts.Publisher = publisher
+	for _, device := range []string{"device01", "device02"} {
+		for _, a := range []Archive{sampleArchive(), backfillArchive()} {
+			a.DeviceID = device
+			env.WriteArchive(a)
+			env.MustRun(testMapping, opts)
+		}
+	}
+	// Only device01 supersedes the corrected snapshot; device02 keeps the
+	// worker's skip policy.
+	env.AssertCount("sensor_data_snapshots", 1, "device_id = 'device01' AND ingest_file LIKE '%backfill%'")
+	env.AssertCount("sensor_data_snapshots", 0, "device_id = 'device02' AND ingest_file LIKE '%backfill%'")
+	env.AssertCount("comparison_results", 0, "device_id = 'device01' AND result = 'MISMATCH'")
+	env.AssertCount("comparison_results", 1, "device_id = 'device02' AND result = 'MISMATCH'")
+	if len(publisher.messages) != 0 {
+		t.Fatalf("expected siteA's results kept off the bus, got %d messages", len(publisher.messages))
+	}
+
+	list, err := ingest.ListDeviceSettings(ctx, env.DB)
+	if err != nil || len(list) != 2 || list[0].DeviceID != "" || list[1].SnapshotDedupe == nil {
+		t.Fatalf("unexpected settings %+v, %v", list, err)
+	}
+}
+
+func TestDailySummaryPerWorkField(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+	other := sampleArchive()
+	other.Date = "20260120_field02"
+	t2 := time.Date(2026, 1, 20, 0, 10, 1, 0, time.Local)
+	other.Snapshots = []record.SensorDataRecord{Snapshot(t2, "field-02", map[int]any{1: 70})}
+	env.WriteArchive(other)
+	env.MustRun(testMapping, env.Options())
+
+	summaries, err := ingest.DailySummary(context.Background(), env.DB, ingest.Filter{})
+	if err != nil {
+		t.Fatalf("daily summary: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("expected one rollup per work field, got %+v", summaries)
+	}
+	first := summaries[0]
+	if first.Day != "2026-01-20" || first.WorkField != "field-01" || first.Snapshots != 2 || first.Comparisons != 4 || first.Mismatch != 1 {
+		t.Fatalf("unexpected field-01 rollup %+v", first)
+	}
+
+	filtered, err := ingest.DailySummary(context.Background(), env.DB, ingest.Filter{WorkField: "field-02"})
+	if err != nil {
+		t.Fatalf("daily summary: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].Snapshots != 1 || filtered[0].Comparisons != 2 {
+		t.Fatalf("unexpected field-02 rollup %+v", filtered)
+	}
+	if none, _ := ingest.DailySummary(context.Background(), env.DB, ingest.Filter{From: "2026-01-21"}); len(none) != 0 {
+		t.Fatalf("expected no rollups from 2026-01-21, got %+v", none)
+	}
+
+	report, err := ingest.Staleness(context.Background(), env.DB, testMapping, ingest.Filter{WorkField: "field-02"}, t2)
+	if err != nil {
+		t.Fatalf("staleness: %v", err)
+	}
+	for _, entry := range report {
+		if entry.SensorID == "WLS1" && !entry.LastValid.Equal(t2) {
+			t.Fatalf("expected field-02 WLS1 valid at %s, got %s", t2, entry.LastValid)
+		}
+	}
+}
+
+func TestDailySummarySplitsHours(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	a.Snapshots = []record.SensorDataRecord{
+		Snapshot(t0, "field-01", map[int]any{1: 60}),
+		Snapshot(t0.Add(3*time.Hour), "field-01", map[int]any{1: 61}),
+		Snapshot(t0.Add(3*time.Hour+time.Minute), "field-01", map[int]any{1: 62}),
+	}
+	env.WriteArchive(a)
+	mapping := map[string]ingest.SensorMapping{"1": testMapping["1"]}
+	env.MustRun(mapping, env.Options())
+
+	summaries, err := ingest.DailySummary(context.Background(), env.DB, ingest.Filter{})
+	if err != nil || len(summaries) != 1 {
+		t.Fatalf("daily summary: %+v, %v", summaries, err)
+	}
+	hours := summaries[0].Hours
+	if hours[0] != (ingest.HourCounts{Match: 1}) || hours[3] != (ingest.HourCounts{MissingRaw: 2}) {
+		t.Fatalf("unexpected hours 0 and 3: %+v, %+v", hours[0], hours[3])
+	}
+	var total int64
+	for _, h := range hours {
+		total += h.Comparisons()
+	}
+	if total != summaries[0].Comparisons {
+		t.Fatalf("hours add up to %d, want %d", total, summaries[0].Comparisons)
+	}
+}
+
+func TestPipelineAnalyzesRawSession(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Raw["GATE1/2026-01-20.log"] = append(a.Raw["GATE1/2026-01-20.log"],
+		"2026-01-20 00:05:00.000 snd: STATUS",
+		"2026-01-20 00:05:02.000 timeout",
+	)
+	env.WriteArchive(a)
+
+	opts := env.Options()
+	opts.AnalyzeRaw = true
+	env.MustRun(testMapping, opts)
+	env.AssertCount("sensor_health_daily", 2, "site_id = ? AND device_id = ? AND day = ?", "siteA", "device01", "2026-01-20")
+	env.AssertCount("sensor_health_daily", 1, "sensor_id = ? AND timeouts = 1", "GATE1")
+	env.AssertCount("sensor_health_daily", 1, "sensor_id = ? AND rcv_count = 2 AND timeouts = 0", "WLS1")
+	env.AssertCount("sensor_health_daily", 0, "status = ?", "ERROR")
+
+	// A mapped sensor that logged nothing is reported missing.
+	missing := New(t)
+	b := sampleArchive()
+	delete(b.Raw, "GATE1/2026-01-20.log")
+	missing.WriteArchive(b)
+	missingOpts := missing.Options()
+	missingOpts.AnalyzeRaw = true
+	if failures := missing.Run(testMapping, missingOpts); len(failures) != 0 {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+	missing.AssertCount("sensor_health_daily", 1, "sensor_id = ? AND status = ? AND result_json LIKE ?", "GATE1", "ERROR", "%MISSING_SENSOR%")
+
+	// Without the option nothing is analyzed.
+	other := New(t)
+	other.WriteArchive(sampleArchive())
+	if failures := other.Run(testMapping, other.Options()); len(failures) != 0 {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+	other.AssertCount("sensor_health_daily", 0, "")
+}
+
+func TestPipelineReadOnlyLeavesArchive(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	zipPath := env.WriteArchive(a)
+
+	publisher := &recordingPublisher{}
+	opts := env.Options()
+	opts.ReadOnly = true
+	opts.ReceiptsDir = filepath.Join(env.Root, "receipts")
+	opts.Publisher = publisher
+	failures, err := ingest.ProcessFiles(context.Background(), []string{zipPath}, env.DB, testMapping, opts)
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("unexpected failures: %v %v", failures, err)
+	}
+	if !Exists(env.Incoming, a.Name()) || Exists(env.Done, a.Name()) {
+		t.Fatalf("expected archive to stay in incoming")
+	}
+	if Exists(env.Done, receipt.Name(a.Name())) || Exists(opts.ReceiptsDir, receipt.Name(a.Name())) {
+		t.Fatalf("expected no receipt in read-only mode")
+	}
+	if len(publisher.messages) != 0 {
+		t.Fatalf("expected nothing published, got %d", len(publisher.messages))
+	}
+
+	var out bytes.Buffer
+	n, err := ingest.WriteComparisons(context.Background(), env.DB, &out)
+	if err != nil || n != 4 {
+		t.Fatalf("write comparisons: %d, %v", n, err)
+	}
+	line, _, _ := strings.Cut(out.String(), "\n")
+	var first map[string]any
+	if err := json.Unmarshal([]byte(line), &first); err != nil || first["ingest_file"] != a.Name() {
+		t.Fatalf("unexpected first line %q: %v", line, err)
+	}
+}
+
+func TestPipelineReadOnlyAppliesStoredSettings(t *testing.T) {
+	env := New(t)
+	ctx := context.Background()
+	days := 0
+	if err := ingest.SetDeviceSettings(ctx, env.DB, ingest.DeviceSettings{SiteID: "siteA", DeviceID: "device01", RawObservationDays: &days}); err != nil {
+		t.Fatalf("set device settings: %v", err)
+	}
+	settings, err := ingest.OpenReadDB(env.DBPath, ingest.DBOptions{})
+	if err != nil {
+		t.Fatalf("open settings db: %v", err)
+	}
+	defer settings.Close()
+	scratch, err := ingest.OpenDB(filepath.Join(t.TempDir(), "scratch.sqlite3"), ingest.DBOptions{})
+	if err != nil {
+		t.Fatalf("open scratch db: %v", err)
+	}
+	defer scratch.Close()
+
+	zipPath := env.WriteArchive(sampleArchive())
+	opts := env.Options()
+	opts.ReadOnly = true
+	opts.RawObservations = true
+	opts.SettingsDB = settings
+	failures, err := ingest.ProcessFiles(ctx, []string{zipPath}, scratch, testMapping, opts)
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("unexpected failures: %v %v", failures, err)
+	}
+	var stored int
+	if err := scratch.QueryRow(`SELECT COUNT(*) FROM raw_observations`).Scan(&stored); err != nil || stored != 0 {
+		t.Fatalf("raw observations %d, %v; want none under the device's stored setting", stored, err)
+	}
+	if err := scratch.QueryRow(`SELECT COUNT(*) FROM comparison_results`).Scan(&stored); err != nil || stored == 0 {
+		t.Fatalf("comparisons %d, %v; want the archive compared", stored, err)
+	}
+}
+
+func TestExportComparisonsFilters(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+	other := sampleArchive()
+	other.DeviceID = "device02"
+	env.WriteArchive(other)
+	env.MustRun(testMapping, env.Options())
+
+	for _, tc := range []struct {
+		name   string
+		filter ingest.ExportFilter
+		want   int
+	}{
+		{"all", ingest.ExportFilter{}, 8},
+		{"device", ingest.ExportFilter{Filter: ingest.Filter{DeviceID: "device02"}}, 4},
+		{"sensor and result", ingest.ExportFilter{SensorID: "WLS1", Result: "mismatch"}, 2},
+		{"limit", ingest.ExportFilter{Filter: ingest.Filter{SiteID: "siteA", From: "2026-01-20"}, Limit: 3}, 3},
+		{"other day", ingest.ExportFilter{Filter: ingest.Filter{From: "2026-01-21"}}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := ingest.ExportComparisons(context.Background(), env.DB, tc.filter, &out)
+			if err != nil || n != tc.want {
+				t.Fatalf("exported %d, %v; want %d", n, err, tc.want)
+			}
+			if lines := strings.Count(out.String(), "\n"); lines != n {
+				t.Fatalf("wrote %d lines for %d rows", lines, n)
+			}
+			if tc.filter.DeviceID != "" && n > 0 && !strings.Contains(out.String(), `"device_id":"device02"`) {
+				t.Fatalf("unexpected rows %s", out.String())
+			}
+		})
+	}
+}
+
+func TestCleanWorkDirKeepsClaimedTrees(t *testing.T) {
+	env, _ := ingestSample(t)
+	base := strings.TrimSuffix(sampleArchive().Name(), ".zip")
+	if !Exists(env.Work, base) || Exists(env.Work, base+ingest.ClaimSuffix) {
+		t.Fatalf("expected the work tree to stay and its claim to be released")
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"crashed", "running", "running" + ingest.ClaimSuffix, "orphan" + ingest.ClaimSuffix} {
+		path := filepath.Join(env.Work, name)
+		if strings.HasSuffix(name, ingest.ClaimSuffix) {
+			os.WriteFile(path, nil, 0o644)
+		} else {
+			os.MkdirAll(path, 0o755)
+		}
+		os.Chtimes(path, old, old)
+	}
+	// A live run touches its claim at every stage.
+	os.Chtimes(filepath.Join(env.Work, "running"+ingest.ClaimSuffix), time.Now(), time.Now())
+	os.Chtimes(filepath.Join(env.Work, base), old, old)
+
+	removed, err := ingest.CleanWorkDir(env.Work, 24*time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("clean: %v", err)
+	}
+	if len(removed) != 3 {
+		t.Fatalf("expected 3 removals, got %v", removed)
+	}
+	for _, name := range []string{"crashed", base, "orphan" + ingest.ClaimSuffix} {
+		if Exists(env.Work, name) {
+			t.Fatalf("expected %s to be removed", name)
+		}
+	}
+	if !Exists(env.Work, "running") || !Exists(env.Work, "running"+ingest.ClaimSuffix) {
+		t.Fatalf("expected the claimed tree to stay")
+	}
+}
+
+func TestRetainDoneArchivesOldArchives(t *testing.T) {
+	env, a := ingestSample(t)
+	// Age is counted from ingestion, not from the file's time, which an
+	// archive copied in with its original time would carry.
+	now := time.Now()
+	os.Chtimes(filepath.Join(env.Done, a.Name()), now.Add(-30*24*time.Hour), now.Add(-30*24*time.Hour))
+	archive := filepath.Join(t.TempDir(), "archive")
+	store := ingest.DirStore(archive)
+	retained, err := ingest.RetainDone(context.Background(), env.DB, env.Done, nil, 7*24*time.Hour, store, now, true)
+	if err != nil || len(retained) != 0 {
+		t.Fatalf("a freshly ingested archive should be kept: %v %v", retained, err)
+	}
+	if _, err := env.DB.Exec(`UPDATE ingest_log SET ingested_at = ?`, now.Add(-10*24*time.Hour).Format(time.RFC3339Nano)); err != nil {
+		t.Fatal(err)
+	}
+
+	retained, err = ingest.RetainDone(context.Background(), env.DB, env.Done, nil, 7*24*time.Hour, store, time.Now(), true)
+	if err != nil || len(retained) != 1 || !Exists(env.Done, a.Name()) {
+		t.Fatalf("dry run should only list the archive: %v %v", retained, err)
+	}
+
+	retained, err = ingest.RetainDone(context.Background(), env.DB, env.Done, nil, 7*24*time.Hour, store, time.Now(), false)
+	if err != nil {
+		t.Fatalf("retain: %v", err)
+	}
+	if len(retained) != 1 || retained[0].Action != ingest.RetentionArchived || retained[0].Location != filepath.Join(archive, a.Name()) {
+		t.Fatalf("unexpected retained archives: %+v", retained)
+	}
+	if Exists(env.Done, a.Name()) || Exists(env.Done, receipt.Name(a.Name())) {
+		t.Fatalf("expected the archive and its receipt to leave the done directory")
+	}
+	if !Exists(archive, a.Name()) || !Exists(archive, receipt.Name(a.Name())) {
+		t.Fatalf("expected the archive and its receipt to be copied")
+	}
+	env.AssertCount("ingest_log", 1, "ingest_file = ? AND retention_action = ? AND archive_sha256 = ? AND retained_at IS NOT NULL",
+		a.Name(), ingest.RetentionArchived, retained[0].SHA256)
+	env.AssertCount("sensor_data_snapshots", 2, "")
+}
+
+func TestFetchArchiveFromDoneAndRetention(t *testing.T) {
+	env, a := ingestSample(t)
+	original, err := os.ReadFile(filepath.Join(env.Done, a.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	fetch := func(wantLocation string) {
+		t.Helper()
+		found, err := ingest.FindDoneArchives(ctx, env.DB, env.Done, nil, "siteA", "device01", "2026-01-20")
+		if err != nil || len(found) != 1 || found[0].Location != wantLocation || found[0].Date != "20260120" {
+			t.Fatalf("unexpected archives %+v, %v", found, err)
+		}
+		var got bytes.Buffer
+		if err := ingest.FetchArchive(ctx, found[0], &got); err != nil || !bytes.Equal(got.Bytes(), original) {
+			t.Fatalf("fetched %d bytes, %v; want the original %d", got.Len(), err, len(original))
+		}
+	}
+	fetch(filepath.Join(env.Done, a.Name()))
+	if found, err := ingest.FindDoneArchives(ctx, env.DB, env.Done, nil, "siteA", "", "2026-01-21"); err != nil || len(found) != 0 {
+		t.Fatalf("expected no archive of another day: %+v, %v", found, err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "archive")
+	if _, err := ingest.RetainDone(ctx, env.DB, env.Done, nil, 0, ingest.DirStore(archive), time.Now().Add(time.Hour), false); err != nil {
+		t.Fatalf("retain: %v", err)
+	}
+	fetch(filepath.Join(archive, a.Name()))
+
+	// A copy that changed since it was retired is refused.
+	os.WriteFile(filepath.Join(archive, a.Name()), []byte("not the archive"), 0o644)
+	found, _ := ingest.FindDoneArchives(ctx, env.DB, env.Done, nil, "siteA", "", "")
+	if err := ingest.FetchArchive(ctx, found[0], io.Discard); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Fatalf("expected a sha256 mismatch, got %v", err)
+	}
+}
+
+func TestPurgeDeletesRetainedCopy(t *testing.T) {
+	env, a := ingestSample(t)
+	ctx := context.Background()
+	archive := filepath.Join(t.TempDir(), "archive")
+	if _, err := ingest.RetainDone(ctx, env.DB, env.Done, nil, 0, ingest.DirStore(archive), time.Now().Add(time.Hour), false); err != nil {
+		t.Fatalf("retain: %v", err)
+	}
+	files, err := ingest.PurgeCandidates(ctx, env.DB, env.Done, "siteA", "device01", "20260121", nil)
+	if err != nil || len(files) != 1 || files[0] != a.Name() {
+		t.Fatalf("unexpected candidates %v, %v", files, err)
+	}
+	copies, err := ingest.RetainedCopies(ctx, env.DB, "siteA", "device01", files)
+	if err != nil || len(copies) != 1 || copies[0] != filepath.Join(archive, a.Name()) {
+		t.Fatalf("unexpected retained copies %v, %v", copies, err)
+	}
+	if err := ingest.DeleteRetainedCopy(ctx, copies[0]); err != nil {
+		t.Fatalf("delete copy: %v", err)
+	}
+	if _, err := ingest.PurgeIngestFiles(ctx, env.DB, "siteA", "device01", "20260121", files); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if Exists(archive, a.Name()) || Exists(archive, receipt.Name(a.Name())) {
+		t.Fatalf("expected the copy and its receipt to be deleted")
+	}
+
+	found, err := ingest.FindDoneArchives(ctx, env.DB, env.Done, nil, "siteA", "device01", "")
+	if err != nil || len(found) != 1 || found[0].PurgedAt == "" || found[0].Location != "" {
+		t.Fatalf("unexpected archives %+v, %v", found, err)
+	}
+	if err := ingest.FetchArchive(ctx, found[0], io.Discard); !errors.Is(err, ingest.ErrArchivePurged) {
+		t.Fatalf("fetch of a purged archive: got %v, want ErrArchivePurged", err)
+	}
+	if copies, err := ingest.RetainedCopies(ctx, env.DB, "siteA", "device01", files); err != nil || len(copies) != 0 {
+		t.Fatalf("copies left after purge: %v, %v", copies, err)
+	}
+}
+
+func TestRetainDoneDeletesWithoutStore(t *testing.T) {
+	env, a := ingestSample(t)
+
+	retained, err := ingest.RetainDone(context.Background(), env.DB, env.Done, nil, 24*time.Hour, nil, time.Now(), false)
+	if err != nil || len(retained) != 0 {
+		t.Fatalf("a fresh archive should be kept: %v %v", retained, err)
+	}
+	retained, err = ingest.RetainDone(context.Background(), env.DB, env.Done, nil, 24*time.Hour, nil, time.Now().Add(48*time.Hour), false)
+	if err != nil || len(retained) != 1 || retained[0].Action != ingest.RetentionDeleted {
+		t.Fatalf("unexpected retained archives: %v %v", retained, err)
+	}
+	if Exists(env.Done, a.Name()) {
+		t.Fatalf("expected the archive to be deleted")
+	}
+	env.AssertCount("ingest_log", 1, "ingest_file = ? AND retention_action = ? AND retention_location = ''", a.Name(), ingest.RetentionDeleted)
+}
+
+// renamedArchive writes the sample archive under a name that is not
+// site_device_date.
+func renamedArchive(t *testing.T, env *Env) string {
+	t.Helper()
+	renamed := filepath.Join(env.Incoming, "renamed.zip")
+	if err := os.Rename(env.WriteArchive(sampleArchive()), renamed); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	return renamed
+}
+
+func TestPipelineQuarantinesUnparseableName(t *testing.T) {
+	env := New(t)
+	renamedArchive(t, env)
+	quarantine := filepath.Join(t.TempDir(), "quarantine")
+
+	opts := env.Options()
+	opts.QuarantineDir = quarantine
+	failures := env.Run(testMapping, opts)
+	if len(failures) != 1 || !errors.Is(failures[0], ingest.ErrArchiveName) {
+		t.Fatalf("expected an archive name failure, got %v", failures)
+	}
+	if Exists(env.Incoming, "renamed.zip") || !Exists(quarantine, "renamed.zip") {
+		t.Fatalf("expected the archive to be quarantined")
+	}
+	r, err := receipt.Read(filepath.Join(quarantine, receipt.Name("renamed.zip")))
+	if err != nil || r.Status != receipt.StatusFailed || r.Stage != "name" {
+		t.Fatalf("unexpected quarantine receipt %+v: %v", r, err)
+	}
+	env.AssertCount("sensor_data_snapshots", 0, "")
+}
+
+func TestPipelineQuarantinesArchivesOffTheAllowlist(t *testing.T) {
+	env := New(t)
+	allowed := sampleArchive()
+	unknownDevice := sampleArchive()
+	unknownDevice.DeviceID = "device99"
+	unknownSite := sampleArchive()
+	unknownSite.SiteID = "siteZ"
+	anyDevice := sampleArchive()
+	anyDevice.SiteID, anyDevice.DeviceID = "siteB", "device07"
+	for _, a := range []Archive{allowed, unknownDevice, unknownSite, anyDevice} {
+		env.WriteArchive(a)
+	}
+	path := filepath.Join(t.TempDir(), "allowlist.json")
+	os.WriteFile(path, []byte(`{"siteA": ["device01"], "siteB": ["*"]}`), 0o644)
+	allowlist, err := ingest.LoadAllowlist(path)
+	if err != nil {
+		t.Fatalf("load allowlist: %v", err)
+	}
+	quarantine := filepath.Join(t.TempDir(), "quarantine")
+
+	opts := env.Options()
+	opts.QuarantineDir = quarantine
+	opts.Allowlist = allowlist
+	failures := env.Run(testMapping, opts)
+	if len(failures) != 2 || !errors.Is(failures[0], ingest.ErrNotAllowed) || !errors.Is(failures[1], ingest.ErrNotAllowed) {
+		t.Fatalf("expected two allowlist failures, got %v", failures)
+	}
+	for _, a := range []Archive{unknownDevice, unknownSite} {
+		if Exists(env.Incoming, a.Name()) || !Exists(quarantine, a.Name()) {
+			t.Fatalf("expected %s to be quarantined", a.Name())
+		}
+	}
+	env.AssertCount("sensor_data_snapshots", 2, "site_id = 'siteA' AND device_id = 'device01'")
+	env.AssertCount("sensor_data_snapshots", 2, "site_id = 'siteB'")
+	env.AssertCount("sensor_data_snapshots", 4, "")
+
+	os.WriteFile(path, []byte(`{"siteA": []}`), 0o644)
+	if _, err := ingest.LoadAllowlist(path); err == nil {
+		t.Fatalf("expected a site without devices to be rejected")
+	}
+}
+
+func TestPipelineQuarantinesAfterMaxAttempts(t *testing.T) {
+	env := New(t)
+	broken := sampleArchive()
+	broken.Tamper = func(dir string) {
+		os.WriteFile(filepath.Join(dir, "events.jsonl"), []byte("{}\n"), 0o644)
+	}
+	env.WriteArchive(broken)
+	quarantine := filepath.Join(t.TempDir(), "quarantine")
+
+	opts := env.Options()
+	opts.QuarantineDir = quarantine
+	opts.MaxAttempts = 3
+	for attempt := 1; attempt <= 3; attempt++ {
+		if failures := env.Run(testMapping, opts); len(failures) != 1 {
+			t.Fatalf("run %d: expected one failure, got %v", attempt, failures)
+		}
+		if attempt < 3 {
+			env.AssertCount("ingest_attempts", 1, "ingest_file = ? AND attempts = ? AND stage = ?", broken.Name(), attempt, "manifest")
+			if !Exists(env.Incoming, broken.Name()) {
+				t.Fatalf("run %d: expected the archive to stay in incoming", attempt)
+			}
+		}
+	}
+	if Exists(env.Incoming, broken.Name()) || !Exists(quarantine, broken.Name()) {
+		t.Fatalf("expected the archive to be quarantined")
+	}
+	r, err := receipt.Read(filepath.Join(quarantine, receipt.Name(broken.Name())))
+	if err != nil || r.Status != receipt.StatusFailed || r.Stage != "manifest" || r.Attempts != 3 || r.Error == "" {
+		t.Fatalf("unexpected quarantine receipt %+v: %v", r, err)
+	}
+	env.AssertCount("ingest_attempts", 0, "")
+}
+
+func TestPipelineNamesArchiveFromSidecar(t *testing.T) {
+	env := New(t)
+	renamed := renamedArchive(t, env)
+	os.WriteFile(renamed+ingest.NameFileSuffix, []byte(`{"site_id": "siteB", "device_id": "device07"}`), 0o644)
+
+	opts := env.Options()
+	opts.NameOverride = ingest.ArchiveName{SiteID: "siteC", DeviceID: "device09"}
+	env.MustRun(testMapping, opts)
+	env.AssertCount("sensor_data_snapshots", 2, "site_id = ? AND device_id = ? AND ingest_file = ?", "siteB", "device07", "renamed.zip")
+	if !Exists(env.Done, "renamed.zip") || !Exists(env.Done, ingest.NameFile("renamed.zip")) {
+		t.Fatalf("expected the archive and its sidecar in done")
+	}
+
+	// Without a sidecar the override applies.
+	renamedArchive(t, env)
+	opts.Duplicates = ingest.DuplicateWarn
+	env.MustRun(testMapping, opts)
+	env.AssertCount("sensor_data_snapshots", 2, "site_id = ? AND device_id = ?", "siteC", "device09")
+}
+
+func TestPipelineSkipsArchiveInLedger(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	env.WriteArchive(a)
+	opts := env.Options()
+	opts.ReceiptsDir = filepath.Join(t.TempDir(), "receipts")
+	env.MustRun(testMapping, opts)
+	first, err := receipt.Read(filepath.Join(opts.ReceiptsDir, receipt.Name(a.Name())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.AssertCount("ingest_ledger", 1, "ingest_file = ? AND status = 'ok' AND audit_id = ?", a.Name(), first.AuditID)
+
+	// The same file dropped in again is moved to done untouched.
+	data, _ := os.ReadFile(filepath.Join(env.Done, a.Name()))
+	os.WriteFile(filepath.Join(env.Incoming, a.Name()), data, 0o644)
+	opts.Summary = &ingest.Summary{}
+	env.MustRun(testMapping, opts)
+	env.AssertCount("sensor_data_snapshots", 2, "")
+	env.AssertCount("ingest_log", 1, "")
+	env.AssertCount("ingest_ledger", 1, "ingest_file = ? AND duplicates = 1", a.Name())
+	if got := opts.Summary.Totals(); got.Duplicates != 1 || got.Processed != 0 {
+		t.Fatalf("summary %+v, want one duplicate", got)
+	}
+	again, err := receipt.Read(filepath.Join(opts.ReceiptsDir, receipt.Name(a.Name())))
+	if err != nil || !again.OK() || !again.Duplicate || again.AuditID != first.AuditID {
+		t.Fatalf("duplicate receipt %+v, %v", again, err)
+	}
+	if Exists(env.Incoming, a.Name()) || !Exists(env.Done, a.Name()) {
+		t.Fatal("expected the duplicate in done")
+	}
+
+	// Changed content under the same name is ingested.
+	a.Snapshots = a.Snapshots[:1]
+	env.WriteArchive(a)
+	env.MustRun(testMapping, opts)
+	env.AssertCount("ingest_log", 2, "")
+	env.AssertCount("ingest_ledger", 2, "ingest_file = ? AND status = 'ok'", a.Name())
+}
+
+func TestPipelineReadsNameTemplate(t *testing.T) {
+	env := New(t)
+	names, err := archivename.Parse("{work_field}-{site}-{device}-{date}{hour}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := sampleArchive()
+	name := names.Format(archivename.Fields{Site: "siteB", Device: "device07", WorkField: "north", Date: a.Date, Hour: "06"}) + ".zip"
+	if err := os.Rename(env.WriteArchive(a), filepath.Join(env.Incoming, name)); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+
+	opts := env.Options()
+	opts.NameTemplate = names
+	env.MustRun(testMapping, opts)
+	env.AssertCount("sensor_data_snapshots", 2, "site_id = ? AND device_id = ? AND ingest_file = ?", "siteB", "device07", name)
+
+	// The default template cannot find a device in such a name.
+	if err := os.Rename(env.WriteArchive(a), filepath.Join(env.Incoming, name)); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	failures := env.Run(testMapping, env.Options())
+	if len(failures) != 1 || !errors.Is(failures[0], ingest.ErrArchiveName) {
+		t.Fatalf("expected an archive name failure, got %v", failures)
+	}
+}
+
+func TestPipelineIngestsTarArchives(t *testing.T) {
+	for _, format := range []archive.Format{archive.FormatTarGz, archive.FormatTarZst} {
+		env := New(t)
+		a := sampleArchive()
+		zipPath := env.WriteArchive(a)
+		staging := t.TempDir()
+		if err := archive.Extract(zipPath, staging, archive.DefaultLimits); err != nil {
+			t.Fatalf("extract: %v", err)
+		}
+		entries, _ := os.ReadDir(staging)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		name := archive.TrimExt(a.Name()) + format.Ext()
+		if err := archive.Create(filepath.Join(env.Incoming, name), staging, names, format); err != nil {
+			t.Fatalf("%s: create: %v", format, err)
+		}
+		os.Remove(zipPath)
+
+		if failures := env.Run(testMapping, env.Options()); len(failures) != 0 {
+			t.Fatalf("%s: unexpected failures: %v", format, failures)
+		}
+		if !Exists(env.Done, name) || Exists(env.Incoming, name) {
+			t.Fatalf("%s: expected archive moved to done", format)
+		}
+		env.AssertCount("sensor_data_snapshots", 2, "site_id = ? AND device_id = ?", "siteA", "device01")
+		env.AssertCount("comparison_results", 4, "")
+		env.AssertCount("ingest_log", 1, "ingest_file = ?", name)
+	}
+}
+
+func TestPipelineKeepsRawObservations(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	env.WriteArchive(a)
+	opts := env.Options()
+	opts.RawObservations = true
+	env.MustRun(testMapping, opts)
+	env.AssertCount("raw_observations", 3, "ingest_file = ?", a.Name())
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 200e6, time.Local)
+	var value, line string
+	if err := env.DB.QueryRow(`SELECT value, line FROM raw_observations WHERE sensor_id = 'WLS1' AND observed_at = ?`,
+		t0.UTC().Format("2006-01-02T15:04:05.000Z")).Scan(&value, &line); err != nil {
+		t.Fatalf("raw observation: %v", err)
+	}
+	if value != "60" || line != "2026-01-20 00:00:01.200 rcv: 60" {
+		t.Fatalf("raw observation value %q, line %q", value, line)
+	}
+
+	// Ingesting the archive again replaces its observations.
+	a.Raw["WLS1/2026-01-20.log"] = a.Raw["WLS1/2026-01-20.log"][:1]
+	env.WriteArchive(a)
+	env.MustRun(testMapping, opts)
+	env.AssertCount("raw_observations", 2, "")
+
+	// The device's own retention wins over the worker's.
+	ctx := context.Background()
+	days := 30
+	if err := ingest.SetDeviceSettings(ctx, env.DB, ingest.DeviceSettings{SiteID: "siteA", DeviceID: "device01", RawObservationDays: &days}); err != nil {
+		t.Fatalf("set device settings: %v", err)
+	}
+	if deleted, err := ingest.PruneRawObservations(ctx, env.DB, 1, t0.AddDate(0, 0, 1)); err != nil || deleted != 0 {
+		t.Fatalf("pruned %d, %v; want none within the device's 30 days", deleted, err)
+	}
+	if _, err := ingest.DeleteDeviceSettings(ctx, env.DB, "siteA", "device01"); err != nil {
+		t.Fatalf("delete device settings: %v", err)
+	}
+	deleted, err := ingest.PruneRawObservations(ctx, env.DB, 1, t0.AddDate(0, 0, 1))
+	if err != nil || deleted != 1 {
+		t.Fatalf("pruned %d, %v; want the GATE1 line only", deleted, err)
+	}
+	env.AssertCount("raw_observations", 1, "sensor_id = 'WLS1'")
+}
+
+func TestPipelineRedactsEvidence(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Raw["WLS1/2026-01-20.log"][0] = "2026-01-20 00:00:01.200 user=kim rcv: 60"
+	env.WriteArchive(a)
+	opts := env.Options()
+	opts.RawObservations = true
+	evidence, err := ingest.ParseEvidence(36, []string{`user=(\w+)`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Evidence = evidence
+	env.MustRun(testMapping, opts)
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	if got := env.Results()[ResultKey("WLS1", t0)]; got != "MATCH" {
+		t.Fatalf("WLS1 result %q; redaction must not change the comparison", got)
+	}
+	env.AssertCount("comparison_results", 1, "sensor_id = 'WLS1' AND raw_evidence = ?", "2026-01-20 00:00:01.200 user=[redact")
+	env.AssertCount("raw_observations", 1, "line = ?", "2026-01-20 00:00:01.200 user=[redacted] rcv: 60")
+	env.AssertCount("raw_observations", 0, "line LIKE '%kim%' OR value LIKE '%kim%'")
+
+	if _, err := ingest.ParseEvidence(0, []string{"user=("}); err == nil {
+		t.Fatal("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestPipelineReportsTimeAlignment(t *testing.T) {
+	env, a := ingestSample(t)
+	report, err := ingest.Alignment(context.Background(), env.DB, ingest.Filter{DeviceID: "device01", From: "2026-01-20"}, a.Name())
+	if err != nil {
+		t.Fatalf("alignment: %v", err)
+	}
+	if len(report) != 2 {
+		t.Fatalf("expected GATE1 and WLS1, got %+v", report)
+	}
+	gate, wls := report[0], report[1]
+	if wls.SensorID != "WLS1" || wls.Snapshots != 2 || wls.Matched != 2 || wls.BeyondWindow != 0 ||
+		wls.Skew != 100*time.Millisecond || wls.AbsP90 != 200*time.Millisecond || wls.AbsMax != 200*time.Millisecond {
+		t.Fatalf("unexpected WLS1 alignment %+v", wls)
+	}
+	// The second snapshot's nearest GATE1 line is ten minutes earlier.
+	if gate.SensorID != "GATE1" || gate.Matched != 1 || gate.BeyondWindow != 1 || gate.BeyondP50 != 10*time.Minute || gate.Window != 3*time.Second {
+		t.Fatalf("unexpected GATE1 alignment %+v", gate)
+	}
+
+	if _, err := ingest.PurgeIngestFiles(context.Background(), env.DB, "siteA", "device01", "", []string{a.Name()}); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	env.AssertCount("time_alignment", 0, "")
+}
+
+func TestPipelineComparesLocaleNumbers(t *testing.T) {
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	cases := []struct {
+		format string
+		raw    []string
+		want   string
+	}{
+		{ingest.NumberComma, []string{"rcv: 1.234,5", "rcv: 1 234,5", "rcv: 1234,50"}, "MATCH"},
+		{ingest.NumberPoint, []string{"rcv: 1,234.5", "rcv: 1'234.5", "rcv: 1234.50"}, "MATCH"},
+		// Misplaced group separators are text, and so is every value of
+		// an entry without a number format.
+		{ingest.NumberComma, []string{"rcv: 12.34,5"}, "MISMATCH"},
+		{"", []string{"rcv: 1.234,5"}, "MISMATCH"},
+	}
+	for _, tc := range cases {
+		for _, raw := range tc.raw {
+			env := New(t)
+			a := sampleArchive()
+			a.Snapshots = []record.SensorDataRecord{Snapshot(t0, "field-01", map[int]any{1: 1234.5})}
+			a.Raw = map[string][]string{"WLS1/2026-01-20.log": {"2026-01-20 00:00:01.200 " + raw}}
+			env.WriteArchive(a)
+			mapping := map[string]ingest.SensorMapping{
+				"1": {SensorID: "WLS1", Type: "WLS", Field: "value", NumberFormat: tc.format},
+			}
+			env.MustRun(mapping, env.Options())
+			if got := env.Results()[ResultKey("WLS1", t0)]; got != tc.want {
+				t.Fatalf("%q in format %q: result %q, want %s", raw, tc.format, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestPipelineComparesArraysElementWise(t *testing.T) {
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	cases := []struct {
+		raw         string
+		arrayLength int
+		want        string
+	}{
+		{"rcv: [230.4, 229.5, 231.2]", 0, "MATCH"},
+		{"rcv: [230.4,229.5,231.2]", 3, "MATCH"},
+		{"rcv: [230.4, 228.9, 231.2]", 3, "MISMATCH"},
+		{"rcv: [230.4, 229.5]", 0, "MISMATCH"},
+		// Both arrays agree, but not with the configured length.
+		{"rcv: [230.4, 229.5, 231.2]", 4, "MISMATCH"},
+		{"rcv: 230.4", 0, "MISMATCH"},
+	}
+	for _, tc := range cases {
+		env := New(t)
+		a := sampleArchive()
+		a.Snapshots = []record.SensorDataRecord{Snapshot(t0, "field-01", map[int]any{7: []float64{230.1, 229.8, 231}})}
+		a.Raw = map[string][]string{"AMP1/2026-01-20.log": {"2026-01-20 00:00:01.200 " + tc.raw}}
+		env.WriteArchive(a)
+		mapping := map[string]ingest.SensorMapping{
+			"7": {SensorID: "AMP1", Type: "AMP", Field: "value", Tolerance: 0.5, ArrayLength: tc.arrayLength},
+		}
+		env.MustRun(mapping, env.Options())
+		if got := env.Results()[ResultKey("AMP1", t0)]; got != tc.want {
+			t.Fatalf("%q with array_length %d: result %q, want %s", tc.raw, tc.arrayLength, got, tc.want)
+		}
+	}
+}
+
+func TestPipelineSkipsOverlappingRawLines(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	a.Snapshots = []record.SensorDataRecord{Snapshot(t0, "field-01", map[int]any{1: 61})}
+	// The rotated copy repeats the live log's first line, which would
+	// otherwise be the last observation in the window.
+	a.Raw = map[string][]string{
+		"WLS1/2026-01-20.log":   {"2026-01-20 00:00:00.500 rcv: 60", "2026-01-20 00:00:01.000 rcv: 61"},
+		"WLS1/2026-01-20.log.1": {"2026-01-20 00:00:00.500 rcv: 60"},
+	}
+	env.WriteArchive(a)
+	opts := env.Options()
+	opts.RawObservations = true
+	mapping := map[string]ingest.SensorMapping{"1": {SensorID: "WLS1", Type: "WLS", Field: "value"}}
+	env.MustRun(mapping, opts)
+	if got := env.Results()[ResultKey("WLS1", t0)]; got != "MATCH" {
+		t.Fatalf("WLS1 result %q, want MATCH", got)
+	}
+	env.AssertCount("raw_observations", 2, "sensor_id = 'WLS1'")
+}
+
+func TestPipelineAttributesRawFilesToSensors(t *testing.T) {
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	run := func(raw map[string][]string, mapping map[string]ingest.SensorMapping) (map[string]string, []error) {
+		env := New(t)
+		a := sampleArchive()
+		a.Snapshots = []record.SensorDataRecord{Snapshot(t0, "field-01", map[int]any{4: "open", 5: "closed"})}
+		a.Raw = raw
+		env.WriteArchive(a)
+		failures := env.Run(mapping, env.Options())
+		return env.Results(), failures
+	}
+
+	// GATE10's directory contains "gate1" too; the longer sensor_id wins.
+	results, failures := run(map[string][]string{
+		"GATE1/2026-01-20.log":  {"2026-01-20 00:00:01.000 rcv: OPEN"},
+		"GATE10/2026-01-20.log": {"2026-01-20 00:00:01.000 rcv: CLOSED"},
+	}, map[string]ingest.SensorMapping{
+		"4": {SensorID: "GATE1", Type: "GATE", Field: "value"},
+		"5": {SensorID: "GATE10", Type: "GATE", Field: "value"},
+	})
+	if len(failures) != 0 || results[ResultKey("GATE1", t0)] != "MATCH" || results[ResultKey("GATE10", t0)] != "MATCH" {
+		t.Fatalf("by sensor_id: %v, %v", results, failures)
+	}
+
+	raw := map[string][]string{
+		"gates/north.log": {"2026-01-20 00:00:01.000 rcv: OPEN"},
+		"gates/south.log": {"2026-01-20 00:00:01.000 rcv: CLOSED"},
+	}
+	results, failures = run(raw, map[string]ingest.SensorMapping{
+		"4": {SensorID: "GATE1", Type: "GATE", Field: "value", RawPaths: []string{"gates/north.*"}},
+		"5": {SensorID: "GATE10", Type: "GATE", Field: "value", RawPaths: []string{"re:/south\\.log$"}},
+	})
+	if len(failures) != 0 || results[ResultKey("GATE1", t0)] != "MATCH" || results[ResultKey("GATE10", t0)] != "MATCH" {
+		t.Fatalf("by raw_paths: %v, %v", results, failures)
+	}
+
+	_, failures = run(raw, map[string]ingest.SensorMapping{
+		"4": {SensorID: "GATE1", Type: "GATE", Field: "value", RawPaths: []string{"gates/*"}},
+		"5": {SensorID: "GATE10", Type: "GATE", Field: "value", RawPaths: []string{"re:south"}},
+	})
+	if len(failures) != 1 || !errors.Is(failures[0], ingest.ErrMappingInvalid) {
+		t.Fatalf("expected a raw_paths conflict, got %v", failures)
+	}
+}
+
+func TestPipelineCompressesPayloads(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+
+	opts := env.Options()
+	opts.PayloadCodec = ingest.CodecZstd
+	env.MustRun(testMapping, opts)
+	env.AssertCount("sensor_data_snapshots", 2, "payload_codec = ? AND typeof(payload_json) = 'blob'", "zstd")
+	env.AssertCount("hourly_metrics", 1, "payload_codec = ?", "zstd")
+
+	rows, err := env.DB.Query(`SELECT payload_json, payload_codec FROM sensor_data_snapshots`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stored []byte
+		var codec string
+		if err := rows.Scan(&stored, &codec); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		payload, err := ingest.DecodePayload(stored, codec)
+		if err != nil || !json.Valid(payload) {
+			t.Fatalf("decode: %v %q", err, payload)
+		}
+	}
+	env.AssertCount("comparison_results", 4, "")
+}
+
+func TestPipelineRunsAlongsideReaders(t *testing.T) {
+	env := New(t)
+	env.WriteArchive(sampleArchive())
+
+	reader, err := ingest.OpenReadDB(env.DBPath, ingest.DBOptions{BusyTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("open reader: %v", err)
+	}
+	defer reader.Close()
+	if _, err := reader.Exec(`DELETE FROM hourly_metrics`); err == nil {
+		t.Fatalf("expected read-only connection to refuse writes")
+	}
+	// An open cursor holds a read snapshot for the whole ingest.
+	rows, err := reader.Query(`SELECT id FROM comparison_results`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+
+	// A second writer holding the lock briefly must be waited out.
+	other, err := ingest.OpenDB(env.DBPath, ingest.DBOptions{BusyTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("open writer: %v", err)
+	}
+	defer other.Close()
+	tx, err := other.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		tx.Rollback()
+	}()
+
+	env.MustRun(testMapping, env.Options())
+	env.AssertCount("comparison_results", 4, "")
+}
+
+func TestPipelineWritesReceipts(t *testing.T) {
+	env := New(t)
+	good := sampleArchive()
+	env.WriteArchive(good)
+	bad := sampleArchive()
+	bad.DeviceID = "device02"
+	bad.Tamper = func(dir string) {
+		if err := os.WriteFile(filepath.Join(dir, "events.jsonl"), []byte("{}\n"), 0o644); err != nil {
+			t.Fatalf("tamper: %v", err)
+		}
+	}
+	env.WriteArchive(bad)
+
+	opts := env.Options()
+	opts.ReceiptsDir = filepath.Join(t.TempDir(), "receipts")
+	if failures := env.Run(testMapping, opts); len(failures) != 1 {
+		t.Fatalf("expected one failure, got %v", failures)
+	}
+
+	ok, err := receipt.Read(filepath.Join(opts.ReceiptsDir, receipt.Name(good.Name())))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !ok.OK() || ok.Rows["snapshots"] != 2 || ok.Rows["comparisons"] != 4 || ok.WorkerVersion == "" {
+		t.Fatalf("unexpected receipt %+v", ok)
+	}
+	failed, err := receipt.Read(filepath.Join(opts.ReceiptsDir, receipt.Name(bad.Name())))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if failed.Status != receipt.StatusFailed || failed.Stage != "manifest" || failed.Error == "" {
+		t.Fatalf("unexpected receipt %+v", failed)
+	}
+}
+
+func TestPipelineStoresDeviceHealth(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Events = append(a.Events,
+		map[string]any{"type": "device_health", "sampled_at": "2026-01-20T00:05:00Z", "disk_used_pct": 91.5, "load1": 0.2, "ntp_offset_ms": -120.0},
+		map[string]any{"type": "device_health", "sampled_at": "2026-01-20T12:05:00Z", "errors": []string{"ntp: timeout"}},
+	)
+	env.WriteArchive(a)
+
+	env.MustRun(testMapping, env.Options())
+	env.AssertCount("hourly_metrics", 1, "")
+	env.AssertCount("device_health", 2, "site_id = ? AND device_id = ?", "siteA", "device01")
+	env.AssertCount("device_health", 1, "disk_used_pct > 90 AND ntp_offset_ms = -120")
+	env.AssertCount("device_health", 1, "ntp_offset_ms IS NULL")
+}
+
+func TestPipelineStoresControllerEvents(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Events = append(a.Events,
+		map[string]any{"type": "controller_event", "occurred_at": "2026-01-20T03:12:44+09:00", "kind": "power_loss", "code": "E01", "source": "ALARM"},
+		map[string]any{"type": "controller_event", "occurred_at": "2026-01-20T09:00:00+09:00", "kind": "door_open", "source": "EVENT"},
+		map[string]any{"type": "controller_event", "occurred_at": "2026-01-20T09:30:00+09:00", "kind": "door_open", "source": "EVENT"},
+		map[string]any{"type": "controller_event", "kind": "door_open"},
+	)
+	env.WriteArchive(a)
+
+	env.MustRun(testMapping, env.Options())
+	env.AssertCount("hourly_metrics", 1, "")
+	env.AssertCount("controller_events", 3, "site_id = ? AND device_id = ?", "siteA", "device01")
+	env.AssertCount("controller_events", 1, "kind = ? AND code = ? AND occurred_at = ?", "power_loss", "E01", "2026-01-20T03:12:44+09:00")
+	env.AssertCount("rejected_lines", 1, "reason = ?", "invalid controller_event")
+
+	summaries, err := ingest.DailySummary(context.Background(), env.DB, ingest.Filter{})
+	if err != nil {
+		t.Fatalf("daily summary: %v", err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("expected one rollup, got %+v", summaries)
+	}
+	s := summaries[0]
+	if s.WorkField != "field-01" || s.ControllerEvents != 3 || s.ControllerEventKinds["door_open"] != 2 || s.ControllerEventKinds["power_loss"] != 1 {
+		t.Fatalf("unexpected rollup %+v", s)
+	}
+}
+
+func TestPipelineRejectsMalformedHours(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Events = []map[string]any{
+		{"hour": "2026012001", "work_field": "field-01"},
+		{"hour": "2026-01-20 02", "work_field": "field-01"},
+		{"hour": "2026012003:30", "work_field": "field-01"},
+		{"work_field": "field-01"},
+	}
+	env.WriteArchive(a)
+
+	opts := env.Options()
+	opts.HourLayout = "2006010215"
+	opts.Stats = &ingest.Stats{}
+	env.MustRun(testMapping, opts)
+	env.AssertCount("hourly_metrics", 1, "hour = ?", "2026-01-20T01")
+	env.AssertCount("hourly_metrics", 1, "")
+	env.AssertCount("rejected_lines", 3, "source = ? AND ingest_file = ?", "events.jsonl", a.Name())
+	env.AssertCount("rejected_lines", 1, "line_no = 4 AND reason LIKE ?", "invalid hour%")
+	if got := opts.Stats.Stage("events").Rejected; got != 3 {
+		t.Fatalf("expected 3 rejected lines, got %d", got)
+	}
+}
+
+func TestPipelineRecordsMalformedLines(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Lines = map[string][]string{
+		"events.jsonl":      {`{"hour": "2026-01-20T01"`},
+		"sensor_data.jsonl": {`{"v": 1, "payload":`, `{"v": 1}`},
+	}
+	env.WriteArchive(a)
+
+	opts := env.Options()
+	opts.Summary = &ingest.Summary{}
+	env.MustRun(testMapping, opts)
+	env.AssertCount("sensor_data_snapshots", 2, "")
+	env.AssertCount("rejected_lines", 1, "source = ? AND line_no = 2 AND reason LIKE ?", "events.jsonl", "invalid json: %")
+	env.AssertCount("rejected_lines", 2, "source = ? AND ingest_file = ?", "sensor_data.jsonl", a.Name())
+	env.AssertCount("rejected_lines", 1, "source = ? AND line_no = 3 AND reason LIKE ? AND line = ?", "sensor_data.jsonl", "invalid snapshot: %", `{"v": 1, "payload":`)
+	env.AssertCount("rejected_lines", 1, "line_no = 4 AND reason = ?", "invalid snapshot: record has no payload")
+
+	done, err := receipt.Read(filepath.Join(env.Done, receipt.Name(a.Name())))
+	if err != nil {
+		t.Fatalf("done receipt: %v", err)
+	}
+	if done.Rows["rejected"] != 3 || opts.Summary.Totals().Rows["rejected"] != 3 {
+		t.Fatalf("expected 3 rejected lines in receipt and summary, got %v and %v", done.Rows, opts.Summary.Totals().Rows)
+	}
+}
+
+func TestStalenessReport(t *testing.T) {
+	env, _ := ingestSample(t)
+
+	mapping := map[string]ingest.SensorMapping{
+		"1": testMapping["1"],
+		"9": {SensorID: "PUMP9", Type: "PUMP", Field: "value"},
+	}
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	report, err := ingest.Staleness(context.Background(), env.DB, mapping, ingest.Filter{}, t0.Add(50*time.Hour))
+	if err != nil {
+		t.Fatalf("staleness: %v", err)
+	}
+	got := map[string]ingest.SensorStaleness{}
+	for _, entry := range report {
+		got[entry.SensorID] = entry
+	}
+	if len(report) != 3 || report[0].SensorID != "PUMP9" {
+		t.Fatalf("expected never-seen sensor first, got %+v", report)
+	}
+	if pump := got["PUMP9"]; !pump.Configured || pump.DaysSinceValid != -1 || !pump.LastSeen.IsZero() {
+		t.Fatalf("unexpected PUMP9 %+v", pump)
+	}
+	wls := got["WLS1"]
+	if !wls.LastValid.Equal(t0) || !wls.LastSeen.Equal(t0.Add(10*time.Minute)) || wls.DaysSinceValid != 2 {
+		t.Fatalf("unexpected WLS1 %+v", wls)
+	}
+	if gate := got["GATE1"]; gate.Configured {
+		t.Fatalf("GATE1 is not in the mapping: %+v", gate)
+	}
+}
+
+func pingSnapshot(publishAt time.Time, ping any) record.SensorDataRecord {
+	stamp := publishAt.Format("2006-01-02 15:04:05.000")
+	data := []map[string]any{}
+	if ping != nil {
+		data = append(data, map[string]any{"id": 7, "ping": ping})
+	}
+	payload, _ := json.Marshal(map[string]any{"PublishAt": stamp, "work_field": "field-01", "data": data})
+	return record.SensorDataRecord{CapturedAt: stamp, WorkField: "field-01", Payload: payload}
+}
+
+func TestPipelineAggregatesPingStats(t *testing.T) {
+	env := New(t)
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	a := sampleArchive()
+	a.Snapshots = []record.SensorDataRecord{
+		pingSnapshot(t0, 12),
+		pingSnapshot(t0.Add(time.Minute), 30),
+		pingSnapshot(t0.Add(2*time.Minute), 45),
+		pingSnapshot(t0.Add(3*time.Minute), nil),
+	}
+	a.Raw = map[string][]string{
+		"PING7/2026-01-20.log": {
+			"2026-01-20 00:00:01.100 rcv: 12",
+			"2026-01-20 00:01:01.100 rcv: 30",
+		},
+	}
+	env.WriteArchive(a)
+
+	mapping := map[string]ingest.SensorMapping{"7": {SensorID: "PING7", Type: "PING", Field: "ping"}}
+	env.MustRun(mapping, env.Options())
+	var samples, lost int
+	var minRTT, avgRTT, maxRTT float64
+	err := env.DB.QueryRow(`SELECT samples, lost, rtt_min, rtt_avg, rtt_max FROM ping_stats WHERE sensor_id = ? AND day = ?`,
+		"PING7", t0.Format("2006-01-02")).Scan(&samples, &lost, &minRTT, &avgRTT, &maxRTT)
+	if err != nil {
+		t.Fatalf("ping_stats: %v", err)
+	}
+	if samples != 4 || lost != 2 || minRTT != 12 || avgRTT != 21 || maxRTT != 30 {
+		t.Fatalf("got samples=%d lost=%d min=%v avg=%v max=%v", samples, lost, minRTT, avgRTT, maxRTT)
+	}
+}
+
+func positionSnapshot(publishAt time.Time, position any) record.SensorDataRecord {
+	stamp := publishAt.Format("2006-01-02 15:04:05.000")
+	data := []map[string]any{{"id": 9, "position": position}}
+	payload, _ := json.Marshal(map[string]any{"PublishAt": stamp, "work_field": "field-01", "data": data})
+	return record.SensorDataRecord{CapturedAt: stamp, WorkField: "field-01", Payload: payload}
+}
+
+func TestPipelineComparesPositions(t *testing.T) {
+	env := New(t)
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	a := sampleArchive()
+	a.Snapshots = []record.SensorDataRecord{
+		positionSnapshot(t0, "37.5665, 126.9780"),
+		positionSnapshot(t0.Add(time.Minute), map[string]any{"lat": 37.5665, "lon": 126.978}),
+		positionSnapshot(t0.Add(2*time.Minute), "37.5665,126.9780"),
+		positionSnapshot(t0.Add(3*time.Minute), "somewhere"),
+	}
+	a.Raw = map[string][]string{
+		"GPS9/2026-01-20.log": {
+			"2026-01-20 00:00:01.100 rcv: $GPGGA,000001,3733.990,N,12658.680,E,1,08,0.9,545.4,M,46.9,M,,*47",
+			`2026-01-20 00:01:01.100 rcv: 37°33'59.4"N 126°58'40.8"E`,
+			"2026-01-20 00:02:01.100 rcv: 37.5765,126.9780",
+			"2026-01-20 00:03:01.100 rcv: somewhere",
+		},
+	}
+	env.WriteArchive(a)
+
+	mapping := map[string]ingest.SensorMapping{"9": {SensorID: "GPS9", Type: "GPS", Field: "position"}}
+	env.MustRun(mapping, env.Options())
+	results := env.Results()
+	for i, want := range []string{"MATCH", "MATCH", "MISMATCH", "MATCH"} {
+		if got := results[ResultKey("GPS9", t0.Add(time.Duration(i)*time.Minute))]; got != want {
+			t.Fatalf("snapshot %d: expected %s, got %q", i, want, got)
+		}
+	}
+
+	var sentLat, rawLon float64
+	err := env.DB.QueryRow(`SELECT sent_lat, raw_lon FROM comparison_results WHERE sensor_id = ? AND publish_at = ?`,
+		"GPS9", t0.Format(time.RFC3339Nano)).Scan(&sentLat, &rawLon)
+	if err != nil {
+		t.Fatalf("coordinates: %v", err)
+	}
+	if sentLat != 37.5665 || rawLon < 126.9779 || rawLon > 126.9781 {
+		t.Fatalf("got sent_lat=%v raw_lon=%v", sentLat, rawLon)
+	}
+	var unparsed int
+	env.DB.QueryRow(`SELECT COUNT(*) FROM comparison_results WHERE sensor_id = ? AND sent_lat IS NULL AND raw_lat IS NULL`, "GPS9").Scan(&unparsed)
+	if unparsed != 1 {
+		t.Fatalf("expected the text position to keep NULL coordinates, got %d rows", unparsed)
+	}
+}
+
+func TestLintMapping(t *testing.T) {
+	env := New(t)
+	zipPath := env.WriteArchive(sampleArchive())
+
+	mapping := map[string]ingest.SensorMapping{
+		"1": {SensorID: "WLS1", Type: "WLS", Field: "value"},
+		"4": {SensorID: "GATE1", Type: "GATE", Field: "value", JSONType: "gate"},
+		"7": {SensorID: "PUMP1", Type: "PUMP", Field: "value"},
+	}
+	findings, err := ingest.LintMapping(zipPath, mapping, nil)
+	if err != nil {
+		t.Fatalf("lint: %v", err)
+	}
+	var got []string
+	for _, finding := range findings {
+		got = append(got, finding.ID+":"+finding.Kind)
+	}
+	want := []string{"4:json_type", "7:no_payload", "7:no_raw"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", findings, want)
+	}
+
+	mapping = map[string]ingest.SensorMapping{"1": {SensorID: "WLS1", Field: "value"}, "10": {SensorID: "WLS", Field: "value"}}
+	findings, err = ingest.LintMapping(zipPath, mapping, nil)
+	if err != nil {
+		t.Fatalf("lint: %v", err)
+	}
+	got = nil
+	for _, finding := range findings {
+		got = append(got, finding.ID+":"+finding.Kind)
+	}
+	want = []string{"1:ambiguous_raw", "4:unmapped", "10:ambiguous_raw", "10:no_payload"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, want %v", findings, want)
+	}
+}
+
+// TestHelperHexDecoder is a decoder plugin used by TestPipelineUsesDecoderPlugin:
+// it decodes a single hex byte into {"value": n}.
+func TestHelperHexDecoder(t *testing.T) {
+	if os.Getenv("E2E_DECODER_HELPER") == "" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req decoder.Request
+		json.Unmarshal(scanner.Bytes(), &req)
+		if n, err := strconv.ParseUint(req.Payload, 16, 8); err == nil {
+			fmt.Printf("{\"values\":{\"value\":%d}}\n", n)
+		} else {
+			fmt.Println(`{"error":"not a hex byte"}`)
+		}
+	}
+	os.Exit(0)
+}
+
+func TestPipelineUsesDecoderPlugin(t *testing.T) {
+	t.Setenv("E2E_DECODER_HELPER", "1")
+	decoders, err := decoder.NewSet(map[string][]string{"WLS": {os.Args[0], "-test.run=^TestHelperHexDecoder$"}})
+	if err != nil {
+		t.Fatalf("decoder: %v", err)
+	}
+	defer decoders.Close()
+
+	env := New(t)
+	a := sampleArchive()
+	a.Raw["WLS1/2026-01-20.log"] = []string{
+		"2026-01-20 00:00:01.200 rcv: 3C",
+		"2026-01-20 00:10:01.100 rcv: ZZ",
+	}
+	env.WriteArchive(a)
+
+	opts := env.Options()
+	opts.Decoders = decoders
+	env.MustRun(testMapping, opts)
+	t0 := time.Date(2026, 1, 20, 0, 0, 1, 0, time.Local)
+	results := env.Results()
+	if got := results[ResultKey("WLS1", t0)]; got != "MATCH" {
+		t.Fatalf("expected decoded 0x3C to match 60, got %q", got)
+	}
+	if got := results[ResultKey("WLS1", t0.Add(10*time.Minute))]; got != "MISSING_RAW" {
+		t.Fatalf("expected rejected payload to be skipped, got %q", got)
+	}
+}
+
+func TestPipelineProcessesArchivesConcurrently(t *testing.T) {
+	env := New(t)
+	var names []string
+	for _, device := range []string{"device01", "device02", "device03", "device04"} {
+		for _, date := range []string{"20260120", "20260120_backfill"} {
+			a := sampleArchive()
+			a.DeviceID, a.Date = device, date
+			env.WriteArchive(a)
+			names = append(names, a.Name())
+		}
+	}
+	opts := env.Options()
+	opts.Concurrency = 3
+	opts.HashChain = true
+	opts.Summary = &ingest.Summary{}
+	env.MustRun(testMapping, opts)
+	for _, name := range names {
+		if !Exists(env.Done, name) {
+			t.Fatalf("%s not moved to done", name)
+		}
+	}
+	if got := opts.Summary.Totals(); got.Archives != len(names) || got.Processed != len(names) {
+		t.Fatalf("unexpected summary %+v", got)
+	}
+	env.AssertCount("sensor_data_snapshots", 8, "")
+	env.AssertCount("comparison_results", 16, "")
+	if head, err := ingest.VerifyChain(context.Background(), env.DB, ingest.ChainHead{}); err != nil || head.Entries != 16 {
+		t.Fatalf("verify chain: %d entries, %v", head.Entries, err)
+	}
+}
+
+func TestPipelineFlagsUnexpectedWorkFields(t *testing.T) {
+	env := New(t)
+	wrong := sampleArchive()
+	env.WriteArchive(wrong)
+	right := sampleArchive()
+	right.DeviceID = "device02"
+	env.WriteArchive(right)
+
+	opts := env.Options()
+	opts.WorkFields = ingest.WorkFields{"siteA": {"field-01"}, "siteA/device01": {"field-02"}}
+	opts.Summary = &ingest.Summary{}
+	env.MustRun(testMapping, opts)
+	env.AssertCount("sensor_data_snapshots", 4, "")
+	env.AssertCount("unexpected_work_fields", 1, "")
+	var snapshots int64
+	var device, field string
+	if err := env.DB.QueryRow(`SELECT device_id, work_field, snapshots FROM unexpected_work_fields`).Scan(&device, &field, &snapshots); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if device != "device01" || field != "field-01" || snapshots != 2 {
+		t.Fatalf("flagged %s %s %d", device, field, snapshots)
+	}
+	if got := opts.Summary.Totals().Rows["unexpected_work_field"]; got != 2 {
+		t.Fatalf("summary counts %d unexpected snapshots", got)
+	}
+}
+
+func TestPipelineCrossChecksHourlyAggregates(t *testing.T) {
+	env := New(t)
+	a := sampleArchive()
+	a.Events = []map[string]any{{
+		"hour":           "2026-01-20T00",
+		"work_field":     "field-01",
+		"snapshot_count": 3,
+		"sensors": map[string]any{
+			"1": map[string]any{"count": 2, "min": 60, "max": 61, "mean": 60.5},
+			"4": map[string]any{"count": 2, "mean": 1},
+		},
+	}}
+	env.WriteArchive(a)
+
+	opts := env.Options()
+	opts.Summary = &ingest.Summary{}
+	env.MustRun(testMapping, opts)
+	rows, err := env.DB.Query(`SELECT sensor_key, aggregate, result FROM aggregate_checks ORDER BY sensor_key, aggregate`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var key, aggregate, result string
+		if err := rows.Scan(&key, &aggregate, &result); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		got = append(got, key+":"+aggregate+"="+result)
+	}
+	want := ":snapshot_count=MISMATCH 1:count=MATCH 1:max=MATCH 1:mean=MATCH 1:min=MATCH 4:count=MATCH 4:mean=MISMATCH"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("checks = %v\nwant %s", got, want)
+	}
+	if n := opts.Summary.Totals().Rows["aggregate_mismatches"]; n != 2 {
+		t.Fatalf("summary counts %d aggregate mismatches", n)
+	}
+}
+
+func TestComparisonExportIsStableAcrossRuns(t *testing.T) {
+	export := func(concurrency int, devices []string) []string {
+		env := New(t)
+		for _, device := range devices {
+			a := sampleArchive()
+			a.DeviceID = device
+			env.WriteArchive(a)
+		}
+		opts := env.Options()
+		opts.Concurrency = concurrency
+		env.MustRun(testMapping, opts)
+		// A database from before row keys and schema versions gets the
+		// baseline migration, which fills them in.
+		if _, err := env.DB.Exec(`UPDATE comparison_results SET row_key = NULL WHERE device_id = 'device02'; DELETE FROM schema_version`); err != nil {
+			t.Fatalf("clear keys: %v", err)
+		}
+		if err := ingest.InitSchema(env.DB); err != nil {
+			t.Fatalf("backfill keys: %v", err)
+		}
+		var out bytes.Buffer
+		if _, err := ingest.WriteComparisons(context.Background(), env.DB, &out); err != nil {
+			t.Fatalf("write comparisons: %v", err)
+		}
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var row map[string]any
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("decode %q: %v", line, err)
+			}
+			if row["row_key"] == nil || row["row_key"] == "" {
+				t.Fatalf("row without row_key: %q", line)
+			}
+			lines = append(lines, fmt.Sprint(row["row_key"], " ", row["result"]))
+		}
+		return lines
+	}
+
+	serial := export(1, []string{"device01", "device02", "device03"})
+	concurrent := export(3, []string{"device03", "device02", "device01"})
+	if len(serial) != 12 || strings.Join(serial, "\n") != strings.Join(concurrent, "\n") {
+		t.Fatalf("exports differ:\n%s\n--\n%s", strings.Join(serial, "\n"), strings.Join(concurrent, "\n"))
+	}
+	seen := map[string]bool{}
+	for _, line := range serial {
+		key, _, _ := strings.Cut(line, " ")
+		if seen[key] {
+			t.Fatalf("duplicate row_key %s", key)
+		}
+		seen[key] = true
+	}
+}
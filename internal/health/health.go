@@ -0,0 +1,125 @@
+// Package health samples the state of the collection PC itself (disk, load,
+// uptime, clock offset) so failing edge hardware shows up on the server
+// before it stops sending data. Samples travel as events.jsonl lines with
+// "type": "device_health".
+package health
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// EventType marks health samples among the lines of events.jsonl.
+const EventType = "device_health"
+
+// Sample is one health reading. Fields the platform cannot provide are left
+// nil rather than reported as zero.
+type Sample struct {
+	Type          string    `json:"type"`
+	SampledAt     time.Time `json:"sampled_at"`
+	DiskPath      string    `json:"disk_path,omitempty"`
+	DiskUsedPct   *float64  `json:"disk_used_pct,omitempty"`
+	DiskFreeBytes *uint64   `json:"disk_free_bytes,omitempty"`
+	Load1         *float64  `json:"load1,omitempty"`
+	UptimeSeconds *float64  `json:"uptime_seconds,omitempty"`
+	NTPOffsetMS   *float64  `json:"ntp_offset_ms,omitempty"`
+	// Errors lists what could not be sampled, e.g. an unreachable NTP server.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Collect samples the disk holding diskPath, load and uptime, and the clock
+// offset against ntpServer when one is given.
+func Collect(ctx context.Context, diskPath, ntpServer string) Sample {
+	s := Sample{Type: EventType, SampledAt: time.Now().UTC(), DiskPath: diskPath}
+	if diskPath != "" {
+		if used, free, err := DiskUsage(diskPath); err == nil {
+			s.DiskUsedPct, s.DiskFreeBytes = &used, &free
+		} else if !errors.Is(err, errUnsupported) {
+			s.Errors = append(s.Errors, fmt.Sprintf("disk: %v", err))
+		}
+	}
+	if load, err := load1(); err == nil {
+		s.Load1 = &load
+	} else if !errors.Is(err, errUnsupported) {
+		s.Errors = append(s.Errors, fmt.Sprintf("load: %v", err))
+	}
+	if uptime, err := uptimeSeconds(); err == nil {
+		s.UptimeSeconds = &uptime
+	} else if !errors.Is(err, errUnsupported) {
+		s.Errors = append(s.Errors, fmt.Sprintf("uptime: %v", err))
+	}
+	if ntpServer != "" {
+		if offset, err := NTPOffset(ctx, ntpServer); err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("ntp: %v", err))
+		} else {
+			ms := float64(offset) / float64(time.Millisecond)
+			s.NTPOffsetMS = &ms
+		}
+	}
+	return s
+}
+
+var errUnsupported = errors.New("not supported on this platform")
+
+// ntpEpochOffset is the number of seconds between 1900-01-01 (NTP) and
+// 1970-01-01 (Unix).
+const ntpEpochOffset = 2208988800
+
+// NTPOffset asks server (host or host:port, default port 123) for the time
+// with a single SNTP request and returns how far the local clock is behind
+// it; a negative offset means the local clock is ahead.
+func NTPOffset(ctx context.Context, server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	request := make([]byte, 48)
+	request[0] = 0x23 // LI 0, version 4, mode 3 (client)
+	sent := time.Now()
+	binary.BigEndian.PutUint64(request[40:], toNTP(sent))
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	if n < 48 || response[0]&0x07 != 4 {
+		return 0, errors.New("invalid ntp response")
+	}
+	if binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]) {
+		return 0, errors.New("ntp response does not match request")
+	}
+	serverReceive := fromNTP(binary.BigEndian.Uint64(response[32:]))
+	serverTransmit := fromNTP(binary.BigEndian.Uint64(response[40:]))
+	return (serverReceive.Sub(sent) + serverTransmit.Sub(received)) / 2, nil
+}
+
+func toNTP(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+func fromNTP(v uint64) time.Time {
+	seconds := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
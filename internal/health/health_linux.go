@@ -0,0 +1,45 @@
+package health
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DiskUsage returns the used percentage of the filesystem holding path and
+// the bytes still available to unprivileged users on it.
+func DiskUsage(path string) (float64, uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	total := st.Blocks * uint64(st.Bsize)
+	free := st.Bavail * uint64(st.Bsize)
+	if total == 0 {
+		return 0, free, fmt.Errorf("%s: empty filesystem", path)
+	}
+	used := float64(total-st.Bfree*uint64(st.Bsize)) / float64(total) * 100
+	return used, free, nil
+}
+
+func load1() (float64, error) {
+	return firstProcField("/proc/loadavg")
+}
+
+func uptimeSeconds() (float64, error) {
+	return firstProcField("/proc/uptime")
+}
+
+func firstProcField(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("%s: empty", path)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package health
+
+// Other platforms report only the NTP offset until native probes are added.
+
+// DiskUsage is not supported here; see health_linux.go.
+func DiskUsage(path string) (float64, uint64, error) {
+	return 0, 0, errUnsupported
+}
+
+func load1() (float64, error) {
+	return 0, errUnsupported
+}
+
+func uptimeSeconds() (float64, error) {
+	return 0, errUnsupported
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fakeNTP answers one SNTP request with a clock running ahead by skew.
+func fakeNTP(t *testing.T, skew time.Duration) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp unavailable: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		request := make([]byte, 48)
+		_, addr, err := conn.ReadFrom(request)
+		if err != nil {
+			return
+		}
+		response := make([]byte, 48)
+		response[0] = 0x24 // version 4, mode 4 (server)
+		copy(response[24:32], request[40:48])
+		now := toNTP(time.Now().Add(skew))
+		binary.BigEndian.PutUint64(response[32:], now)
+		binary.BigEndian.PutUint64(response[40:], now)
+		conn.WriteTo(response, addr)
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNTPOffset(t *testing.T) {
+	offset, err := NTPOffset(context.Background(), fakeNTP(t, 3*time.Second))
+	if err != nil {
+		t.Fatalf("ntp: %v", err)
+	}
+	if offset < 2900*time.Millisecond || offset > 3100*time.Millisecond {
+		t.Fatalf("expected about 3s, got %s", offset)
+	}
+}
+
+func TestNTPTimestampRoundTrip(t *testing.T) {
+	now := time.Date(2026, 1, 20, 0, 0, 1, 250_000_000, time.UTC)
+	if got := fromNTP(toNTP(now)); got.Sub(now).Abs() > time.Microsecond {
+		t.Fatalf("round trip %s -> %s", now, got)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	s := Collect(context.Background(), t.TempDir(), fakeNTP(t, 0))
+	if s.Type != EventType || s.NTPOffsetMS == nil || len(s.Errors) != 0 {
+		t.Fatalf("unexpected sample %+v", s)
+	}
+	if runtime.GOOS == "linux" && (s.DiskUsedPct == nil || s.UptimeSeconds == nil || s.Load1 == nil) {
+		t.Fatalf("expected disk, load and uptime on linux: %+v", s)
+	}
+}
+
+func TestCollectReportsUnreachableNTP(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s := Collect(ctx, "", "127.0.0.1:1")
+	if s.NTPOffsetMS != nil || len(s.Errors) != 1 {
+		t.Fatalf("expected an ntp error, got %+v", s)
+	}
+}
@@ -0,0 +1,101 @@
+// Package i18n holds the message catalogs for text shown to field operators:
+// analyzer notes, issue labels in analysis.json and client console output.
+// Keys and JSON field names stay English; only human-readable text changes.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Lang string
+
+const (
+	English Lang = "en"
+	Korean  Lang = "ko"
+)
+
+// Message keys.
+const (
+	NoteTimeRangeEstimated = "note.time_range_estimated"
+	NoteNoTimestamps       = "note.no_timestamps"
+	NoteSndWithoutRcv      = "note.snd_without_rcv"
+	NoteNoPayload          = "note.no_payload"
+
+	IssueTimeout           = "issue.timeout"
+	IssueNoResponse        = "issue.no_response"
+	IssueZeroData          = "issue.zero_data"
+	IssueDuplicates        = "issue.duplicates"
+	IssueParseErrors       = "issue.parse_errors"
+	IssueMissingSensor     = "issue.missing_sensor"
+	IssueUnansweredCommand = "issue.unanswered_command"
+
+	ClientWrote = "client.wrote"
+)
+
+var catalogs = map[Lang]map[string]string{
+	English: {
+		NoteTimeRangeEstimated: "time_range estimated from filename",
+		NoteNoTimestamps:       "no timestamps found for date",
+		NoteSndWithoutRcv:      "snd exists but no rcv found; treated as no_response",
+		NoteNoPayload:          "no payload for date",
+
+		IssueTimeout:           "timeout",
+		IssueNoResponse:        "no response",
+		IssueZeroData:          "zero data",
+		IssueDuplicates:        "duplicate payloads",
+		IssueParseErrors:       "unparsable payloads",
+		IssueMissingSensor:     "no log lines",
+		IssueUnansweredCommand: "command never answered",
+
+		ClientWrote: "wrote %s",
+	},
+	Korean: {
+		NoteTimeRangeEstimated: "time_range는 파일 이름에서 추정했습니다",
+		NoteNoTimestamps:       "해당 날짜의 시각 정보를 찾지 못했습니다",
+		NoteSndWithoutRcv:      "snd는 있으나 rcv가 없어 no_response로 처리했습니다",
+		NoteNoPayload:          "해당 날짜의 응답 데이터가 없습니다",
+
+		IssueTimeout:           "타임아웃",
+		IssueNoResponse:        "무응답",
+		IssueZeroData:          "0 데이터",
+		IssueDuplicates:        "중복 응답",
+		IssueParseErrors:       "해석 불가 응답",
+		IssueMissingSensor:     "로그 없음",
+		IssueUnansweredCommand: "응답 없는 명령",
+
+		ClientWrote: "%s 저장 완료",
+	},
+}
+
+// Langs lists the supported languages.
+func Langs() []Lang {
+	return []Lang{English, Korean}
+}
+
+// ParseLang accepts a configured language; an empty value means English.
+func ParseLang(value string) (Lang, error) {
+	switch lang := Lang(strings.ToLower(strings.TrimSpace(value))); lang {
+	case "":
+		return English, nil
+	case English, Korean:
+		return lang, nil
+	default:
+		return "", fmt.Errorf("unsupported language %q", value)
+	}
+}
+
+// T formats the message for key. Missing translations fall back to English,
+// and unknown keys to the key itself, so a gap never hides a report.
+func (l Lang) T(key string, args ...any) string {
+	format, ok := catalogs[l][key]
+	if !ok {
+		if format, ok = catalogs[English][key]; !ok {
+			format = key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
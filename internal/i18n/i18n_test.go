@@ -0,0 +1,39 @@
+package i18n
+
+import "testing"
+
+func TestCatalogsHaveSameKeys(t *testing.T) {
+	for _, lang := range Langs() {
+		for key := range catalogs[English] {
+			if _, ok := catalogs[lang][key]; !ok {
+				t.Fatalf("%s: missing %s", lang, key)
+			}
+		}
+		if len(catalogs[lang]) != len(catalogs[English]) {
+			t.Fatalf("%s: has keys not in the English catalog", lang)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if got := Korean.T(ClientWrote, "/out/analysis.json"); got != "/out/analysis.json 저장 완료" {
+		t.Fatalf("unexpected %q", got)
+	}
+	if got := Lang("").T(IssueTimeout); got != "timeout" {
+		t.Fatalf("expected English fallback, got %q", got)
+	}
+	if got := English.T("missing.key"); got != "missing.key" {
+		t.Fatalf("expected key fallback, got %q", got)
+	}
+}
+
+func TestParseLang(t *testing.T) {
+	for value, want := range map[string]Lang{"": English, "en": English, "KO": Korean} {
+		if got, err := ParseLang(value); err != nil || got != want {
+			t.Fatalf("%q: got %q, %v", value, got, err)
+		}
+	}
+	if _, err := ParseLang("jp"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,217 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"workfield/internal/alarm"
+	"workfield/internal/health"
+	"workfield/internal/logging"
+	"workfield/internal/record"
+	"workfield/internal/timeparse"
+)
+
+// Results of an aggregate cross-check.
+const (
+	AggregateMatch    = "MATCH"
+	AggregateMismatch = "MISMATCH"
+)
+
+// hourlyReport is what a device reported about one hour in events.jsonl:
+// how many snapshots it sent and, per mapping id, the count, min, max and
+// mean of the values. Aggregates the line leaves out are not checked.
+type hourlyReport struct {
+	SnapshotCount *float64                    `json:"snapshot_count"`
+	Sensors       map[string]sensorAggregates `json:"sensors"`
+}
+
+type sensorAggregates struct {
+	Count *float64 `json:"count"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Mean  *float64 `json:"mean"`
+}
+
+// derivedHour accumulates the snapshots of one work field and hour.
+type derivedHour struct {
+	snapshots int
+	counts    map[string]int
+	values    map[string][]float64
+}
+
+// crossCheckAggregates recomputes the hourly aggregates of the archive's
+// snapshots and compares them with what the device reported for the same
+// work field and hour in events.jsonl, storing one aggregate_checks row per
+// reported aggregate. A device that misreports its own summaries shows up
+// as MISMATCH rows. Only the archive's own snapshots are counted.
+func crossCheckAggregates(ctx context.Context, db dbConn, events io.Reader, snapshots []record.SensorDataRecord, mapping map[string]SensorMapping, siteID, deviceID, ingestFile string, opts Options) (StageCount, error) {
+	var count StageCount
+	times := opts.timestamps()
+	reports, err := readHourlyReports(events, times, opts.HourLayout)
+	if err != nil || len(reports) == 0 {
+		return count, err
+	}
+
+	derived := map[[2]string]*derivedHour{}
+	for _, snapshot := range snapshots {
+		payload, publishAt, err := parsePayload(times, snapshot.Payload)
+		if err != nil {
+			continue
+		}
+		workField := payload.WorkField
+		if workField == "" {
+			workField = snapshot.WorkField
+		}
+		key := [2]string{workField, publishAt.In(times.Location()).Format(timeparse.HourLayout)}
+		hour := derived[key]
+		if hour == nil {
+			hour = &derivedHour{counts: map[string]int{}, values: map[string][]float64{}}
+			derived[key] = hour
+		}
+		hour.snapshots++
+		for id, entry := range mapping {
+			value, found := findSentValue(payload, id, entry)
+			if !found {
+				continue
+			}
+			hour.counts[id]++
+			if number, err := strconv.ParseFloat(value, 64); err == nil {
+				hour.values[id] = append(hour.values[id], number)
+			}
+		}
+	}
+
+	stmt, err := db.PrepareContext(ctx, `
+		INSERT INTO aggregate_checks
+		(site_id, device_id, work_field, hour, sensor_key, aggregate, reported, derived, result, ingest_file, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(site_id, device_id, work_field, hour, sensor_key, aggregate)
+		DO UPDATE SET reported = excluded.reported, derived = excluded.derived, result = excluded.result,
+			ingest_file = excluded.ingest_file, created_at = excluded.created_at
+	`)
+	if err != nil {
+		return count, err
+	}
+	defer stmt.Close()
+	now := time.Now().Format(time.RFC3339Nano)
+	check := func(workField, hour, sensorKey, aggregate string, reported *float64, derived *float64, tolerance float64) error {
+		if reported == nil {
+			return nil
+		}
+		result := AggregateMatch
+		if derived == nil || math.Abs(*reported-*derived) > tolerance {
+			result = AggregateMismatch
+			count.Mismatches++
+		}
+		res, err := stmt.ExecContext(ctx, siteID, deviceID, workField, hour, sensorKey, aggregate, *reported, derived, result, ingestFile, now)
+		if err != nil {
+			return err
+		}
+		count.Rows += rowsAffected(res)
+		return nil
+	}
+
+	for _, key := range sortedReportKeys(reports) {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		count.Lines++
+		report := reports[key]
+		hour := derived[key]
+		if hour == nil {
+			hour = &derivedHour{}
+		}
+		snapshotCount := float64(hour.snapshots)
+		if err := check(key[0], key[1], "", "snapshot_count", report.SnapshotCount, &snapshotCount, 0); err != nil {
+			return count, err
+		}
+		ids := make([]string, 0, len(report.Sensors))
+		for id := range report.Sensors {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			reported := report.Sensors[id]
+			values := hour.values[id]
+			n := float64(hour.counts[id])
+			var low, high, mean *float64
+			if len(values) > 0 {
+				l, h, sum := values[0], values[0], 0.0
+				for _, v := range values {
+					l, h, sum = math.Min(l, v), math.Max(h, v), sum+v
+				}
+				m := sum / float64(len(values))
+				low, high, mean = &l, &h, &m
+			}
+			tolerance := mapping[id].Tolerance
+			for _, a := range []struct {
+				name              string
+				reported, derived *float64
+				tolerance         float64
+			}{
+				{"count", reported.Count, &n, 0},
+				{"min", reported.Min, low, tolerance},
+				{"max", reported.Max, high, tolerance},
+				{"mean", reported.Mean, mean, tolerance},
+			} {
+				if err := check(key[0], key[1], id, a.name, a.reported, a.derived, a.tolerance); err != nil {
+					return count, err
+				}
+			}
+		}
+	}
+	if count.Mismatches > 0 {
+		logging.From(ctx).Warn("device hourly aggregates disagree with its snapshots", "mismatches", count.Mismatches)
+	}
+	return count, nil
+}
+
+// readHourlyReports reads the hourly metric lines of events.jsonl that
+// carry aggregates, keyed by work field and normalized hour. Lines the
+// events stage rejected are skipped here too.
+func readHourlyReports(events io.Reader, times *timeparse.Parser, hourLayout string) (map[[2]string]hourlyReport, error) {
+	reports := map[[2]string]hourlyReport{}
+	scanner := bufio.NewScanner(events)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var typed struct {
+			Type      string `json:"type"`
+			Hour      string `json:"hour"`
+			WorkField string `json:"work_field"`
+		}
+		if line == "" || json.Unmarshal([]byte(line), &typed) != nil || typed.Type == health.EventType || typed.Type == alarm.EventType {
+			continue
+		}
+		hour, err := times.ParseHour(hourLayout, typed.Hour)
+		if err != nil {
+			continue
+		}
+		var report hourlyReport
+		if err := json.Unmarshal([]byte(line), &report); err != nil || (report.SnapshotCount == nil && len(report.Sensors) == 0) {
+			continue
+		}
+		reports[[2]string{typed.WorkField, hour}] = report
+	}
+	return reports, scanner.Err()
+}
+
+func sortedReportKeys(reports map[[2]string]hourlyReport) [][2]string {
+	keys := make([][2]string, 0, len(reports))
+	for key := range reports {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
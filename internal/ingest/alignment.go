@@ -0,0 +1,221 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// SensorAlignment summarizes, for one sensor of one archive, how far the
+// raw observations matched to snapshots lie from the snapshots' publish
+// times, so the comparison window can be sized from data. Offsets are the
+// observation time minus the publish time.
+type SensorAlignment struct {
+	SiteID     string
+	DeviceID   string
+	IngestFile string
+	SensorID   string
+	// FirstPublish is the earliest compared snapshot; the report's day.
+	FirstPublish time.Time
+	Window       time.Duration
+	// Snapshots counts the snapshots compared for the sensor, Matched those
+	// with an observation inside the window.
+	Snapshots int
+	Matched   int
+	// Skew is the median signed offset of the matched observations; a
+	// steady non-zero skew points at a clock difference rather than jitter.
+	Skew time.Duration
+	// AbsP50, AbsP90 and AbsMax are percentiles of the absolute offsets of
+	// the matched observations.
+	AbsP50 time.Duration
+	AbsP90 time.Duration
+	AbsMax time.Duration
+	// BeyondWindow counts the snapshots without an observation in the
+	// window although the sensor has some, and BeyondP50 is the median
+	// absolute offset of the nearest one: how much wider the window would
+	// have to be to match them.
+	BeyondWindow int
+	BeyondP50    time.Duration
+}
+
+// alignmentReport collects the offsets of one archive's comparisons per
+// sensor. A nil report collects nothing.
+type alignmentReport struct {
+	window       time.Duration
+	firstPublish time.Time
+	sensors      map[string]*sensorOffsets
+}
+
+type sensorOffsets struct {
+	snapshots int
+	matched   []time.Duration
+	beyond    []time.Duration
+}
+
+func newAlignmentReport(window time.Duration) *alignmentReport {
+	return &alignmentReport{window: window, sensors: map[string]*sensorOffsets{}}
+}
+
+// observe records the offset of the observation compared with the snapshot
+// published at target: the one findRawValue selects when there is one in
+// the window and the nearest otherwise.
+func (a *alignmentReport) observe(sensorID string, observations []RawObservation, target time.Time) {
+	if a == nil {
+		return
+	}
+	if a.firstPublish.IsZero() || target.Before(a.firstPublish) {
+		a.firstPublish = target
+	}
+	offsets := a.sensors[sensorID]
+	if offsets == nil {
+		offsets = &sensorOffsets{}
+		a.sensors[sensorID] = offsets
+	}
+	offsets.snapshots++
+	var selected, nearest time.Duration
+	found, seen := false, false
+	for _, item := range observations {
+		offset := item.Timestamp.Sub(target)
+		if offset >= -a.window && offset <= a.window {
+			selected, found = offset, true
+		}
+		if !seen || absDuration(offset) < absDuration(nearest) {
+			nearest, seen = offset, true
+		}
+	}
+	switch {
+	case found:
+		offsets.matched = append(offsets.matched, selected)
+	case seen:
+		offsets.beyond = append(offsets.beyond, absDuration(nearest))
+	}
+}
+
+// summaries returns the report per sensor in sensor order.
+func (a *alignmentReport) summaries(siteID, deviceID, ingestFile string) []SensorAlignment {
+	if a == nil {
+		return nil
+	}
+	sensorIDs := make([]string, 0, len(a.sensors))
+	for sensorID := range a.sensors {
+		sensorIDs = append(sensorIDs, sensorID)
+	}
+	sort.Strings(sensorIDs)
+	report := make([]SensorAlignment, 0, len(sensorIDs))
+	for _, sensorID := range sensorIDs {
+		offsets := a.sensors[sensorID]
+		entry := SensorAlignment{
+			SiteID:       siteID,
+			DeviceID:     deviceID,
+			IngestFile:   ingestFile,
+			SensorID:     sensorID,
+			FirstPublish: a.firstPublish,
+			Window:       a.window,
+			Snapshots:    offsets.snapshots,
+			Matched:      len(offsets.matched),
+			BeyondWindow: len(offsets.beyond),
+			Skew:         durationPercentile(offsets.matched, 0.5),
+			BeyondP50:    durationPercentile(offsets.beyond, 0.5),
+		}
+		abs := make([]time.Duration, len(offsets.matched))
+		for i, offset := range offsets.matched {
+			abs[i] = absDuration(offset)
+		}
+		entry.AbsP50 = durationPercentile(abs, 0.5)
+		entry.AbsP90 = durationPercentile(abs, 0.9)
+		entry.AbsMax = durationPercentile(abs, 1)
+		report = append(report, entry)
+	}
+	return report
+}
+
+// durationPercentile is the nearest-rank percentile p of values, sorting
+// them in place; zero for no values.
+func durationPercentile(values []time.Duration, p float64) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	rank := int(p*float64(len(values))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(values) {
+		rank = len(values) - 1
+	}
+	return values[rank]
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// storeAlignment replaces the archive's alignment report.
+func storeAlignment(ctx context.Context, db dbConn, report []SensorAlignment, siteID, deviceID, ingestFile string) error {
+	if _, err := db.ExecContext(ctx, `
+		DELETE FROM time_alignment WHERE site_id = ? AND device_id = ? AND ingest_file = ?
+	`, siteID, deviceID, ingestFile); err != nil {
+		return err
+	}
+	createdAt := time.Now().Format(time.RFC3339Nano)
+	for _, entry := range report {
+		if _, err := db.ExecContext(ctx, `
+			INSERT INTO time_alignment
+			(site_id, device_id, ingest_file, sensor_id, first_publish_at, window_ms, snapshots, matched, skew_ms, abs_p50_ms, abs_p90_ms, abs_max_ms, beyond_window, beyond_p50_ms, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, entry.SiteID, entry.DeviceID, entry.IngestFile, entry.SensorID, entry.FirstPublish.Format(time.RFC3339Nano), entry.Window.Milliseconds(),
+			entry.Snapshots, entry.Matched, entry.Skew.Milliseconds(), entry.AbsP50.Milliseconds(), entry.AbsP90.Milliseconds(), entry.AbsMax.Milliseconds(),
+			entry.BeyondWindow, entry.BeyondP50.Milliseconds(), createdAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Alignment lists the stored alignment reports of the archives matching
+// filter, or only of the named archive when archive is set, newest archive
+// first. The filter's work field does not apply.
+func Alignment(ctx context.Context, db *sql.DB, filter Filter, archive string) ([]SensorAlignment, error) {
+	where, args := filter.deviceWhere("first_publish_at")
+	if archive != "" {
+		if where == "" {
+			where = " WHERE ingest_file = ?"
+		} else {
+			where += " AND ingest_file = ?"
+		}
+		args = append(args, archive)
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT site_id, device_id, ingest_file, sensor_id, first_publish_at, window_ms, snapshots, matched,
+			skew_ms, abs_p50_ms, abs_p90_ms, abs_max_ms, beyond_window, beyond_p50_ms
+		FROM time_alignment`+where+`
+		ORDER BY first_publish_at DESC, site_id, device_id, ingest_file, sensor_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var report []SensorAlignment
+	for rows.Next() {
+		var entry SensorAlignment
+		var firstPublish string
+		var window, skew, p50, p90, max, beyond int64
+		if err := rows.Scan(&entry.SiteID, &entry.DeviceID, &entry.IngestFile, &entry.SensorID, &firstPublish, &window, &entry.Snapshots, &entry.Matched,
+			&skew, &p50, &p90, &max, &entry.BeyondWindow, &beyond); err != nil {
+			return nil, err
+		}
+		entry.FirstPublish, _ = time.Parse(time.RFC3339Nano, firstPublish)
+		entry.Window = time.Duration(window) * time.Millisecond
+		entry.Skew = time.Duration(skew) * time.Millisecond
+		entry.AbsP50 = time.Duration(p50) * time.Millisecond
+		entry.AbsP90 = time.Duration(p90) * time.Millisecond
+		entry.AbsMax = time.Duration(max) * time.Millisecond
+		entry.BeyondP50 = time.Duration(beyond) * time.Millisecond
+		report = append(report, entry)
+	}
+	return report, rows.Err()
+}
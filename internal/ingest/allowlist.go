@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// ErrNotAllowed means the site or device an archive's name resolved to is
+// not on the allowlist. Such archives are quarantined without being
+// extracted, like ones whose name does not parse.
+var ErrNotAllowed = fmt.Errorf("%w: site or device is not on the allowlist", ErrBadArchive)
+
+// AllowAllDevices in an allowlist entry permits every device of the site.
+const AllowAllDevices = "*"
+
+// Allowlist maps each permitted site_id to its permitted device_ids, e.g.
+// {"siteA": ["device01", "device02"], "siteB": ["*"]}. A nil Allowlist
+// permits every site and device.
+type Allowlist map[string][]string
+
+// LoadAllowlist reads an allowlist json file.
+func LoadAllowlist(path string) (Allowlist, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	list := Allowlist{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("allowlist %s: %w", path, err)
+	}
+	for site, devices := range list {
+		if site == "" {
+			return nil, fmt.Errorf("allowlist %s: empty site id", path)
+		}
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("allowlist %s: site %q lists no devices; use [%q] for all", path, site, AllowAllDevices)
+		}
+	}
+	return list, nil
+}
+
+// check returns ErrNotAllowed unless the list permits name.
+func (l Allowlist) check(name ArchiveName) error {
+	if l == nil {
+		return nil
+	}
+	devices, ok := l[name.SiteID]
+	if !ok {
+		return fmt.Errorf("%w: site %s", ErrNotAllowed, name.SiteID)
+	}
+	if !slices.Contains(devices, AllowAllDevices) && !slices.Contains(devices, name.DeviceID) {
+		return fmt.Errorf("%w: device %s/%s", ErrNotAllowed, name.SiteID, name.DeviceID)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"workfield/internal/analyzer"
+	"workfield/internal/buildinfo"
+	"workfield/internal/logging"
+	"workfield/internal/timeparse"
+)
+
+// analyzeRawSession runs the on-device analyzer over the archive's
+// raw_session directory for the archive's date and stores one
+// sensor_health_daily row per sensor, so sensor health is known even for
+// devices that never run the analyzer themselves. The mapped sensors are
+// expected: one that logged nothing gets a MISSING_SENSOR row with status
+// ERROR. A later archive for the same day replaces the rows. The count has
+// sensors analyzed as Lines.
+func analyzeRawSession(ctx context.Context, db dbConn, dir, siteID, deviceID, date, ingestFile string, mapping map[string]SensorMapping, opts Options) (StageCount, error) {
+	var count StageCount
+	day, err := timeparse.ParseDate(date)
+	if err != nil {
+		return count, err
+	}
+	summary, err := analyzer.AnalyzeDaily(ctx, analyzer.Config{
+		SiteID:   siteID,
+		DeviceID: deviceID,
+		LogRoot:  dir,
+		// Only the archive's own day: another day's file would be
+		// recorded under the wrong date.
+		FallbackToLatestFile: false,
+		Timestamps:           opts.timestamps(),
+		Decoders:             opts.Decoders,
+		ExpectedSensors:      expectedSensors(mapping),
+	}, date, 0)
+	if err != nil {
+		return count, err
+	}
+
+	stmt, err := db.PrepareContext(ctx, `
+		INSERT INTO sensor_health_daily
+		(site_id, device_id, day, sensor_id, sensor_type, timeouts, no_response, zero_data, duplicates, parse_errors,
+			snd_count, rcv_count, time_from, time_to, result_json, status, ingest_file, analyzed_at, worker_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(site_id, device_id, day, sensor_id) DO UPDATE SET
+			sensor_type = excluded.sensor_type, timeouts = excluded.timeouts, no_response = excluded.no_response,
+			zero_data = excluded.zero_data, duplicates = excluded.duplicates, parse_errors = excluded.parse_errors,
+			snd_count = excluded.snd_count, rcv_count = excluded.rcv_count, time_from = excluded.time_from,
+			time_to = excluded.time_to, result_json = excluded.result_json, status = excluded.status, ingest_file = excluded.ingest_file,
+			analyzed_at = excluded.analyzed_at, worker_version = excluded.worker_version
+	`)
+	if err != nil {
+		return count, err
+	}
+	defer stmt.Close()
+
+	analyzedAt := time.Now().Format(time.RFC3339Nano)
+	version := buildinfo.Get().Short()
+	for _, sensor := range summary.Sensors {
+		count.Lines++
+		result, err := json.Marshal(sensor)
+		if err != nil {
+			return count, err
+		}
+		m := sensor.Metrics
+		res, err := stmt.ExecContext(ctx, siteID, deviceID, day.Format(time.DateOnly), sensor.SensorID, sensor.SensorType,
+			m.Timeout, m.NoResponse, m.ZeroData, m.Duplicates, m.ParseErrors, m.SndCount, m.RcvCount,
+			m.TimeRange.From, m.TimeRange.To, string(result), sensor.Status, ingestFile, analyzedAt, version)
+		if err != nil {
+			return count, err
+		}
+		count.Rows += rowsAffected(res)
+	}
+	logging.From(ctx).Debug("raw session analyzed", "sensors", len(summary.Sensors))
+	return count, nil
+}
@@ -0,0 +1,55 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"workfield/internal/logging"
+)
+
+// countAttempt keeps the ingest_attempts row of an archive up to date and
+// returns how many runs have failed it. A success clears the count; a
+// locked database or a shutdown is not counted and reports 0, as does a
+// count that cannot be stored, so the archive stays in incoming.
+func countAttempt(ctx context.Context, db *sql.DB, zipName string, err error) int {
+	// The archive's own context may be what ended it.
+	ctx = context.WithoutCancel(ctx)
+	if err == nil {
+		if _, err := db.ExecContext(ctx, `DELETE FROM ingest_attempts WHERE ingest_file = ?`, zipName); err != nil {
+			logging.From(ctx).Warn("ingest attempts not cleared", "error", err)
+		}
+		return 0
+	}
+	if errors.Is(err, ErrDBBusy) || errors.Is(err, context.Canceled) {
+		return 0
+	}
+	var stage string
+	var archiveErr *ArchiveError
+	if errors.As(err, &archiveErr) {
+		stage = archiveErr.Stage
+	}
+	now := time.Now().Format(time.RFC3339Nano)
+	var count int
+	if err := db.QueryRowContext(ctx, `
+		INSERT INTO ingest_attempts (ingest_file, attempts, stage, last_error, first_failed_at, last_failed_at)
+		VALUES (?, 1, ?, ?, ?, ?)
+		ON CONFLICT(ingest_file) DO UPDATE SET attempts = attempts + 1, stage = excluded.stage,
+			last_error = excluded.last_error, last_failed_at = excluded.last_failed_at
+		RETURNING attempts
+	`, zipName, stage, err.Error(), now, now).Scan(&count); err != nil {
+		logging.From(ctx).Warn("ingest attempt not counted", "error", err)
+		return 0
+	}
+	return count
+}
+
+// forgetAttempts drops the count of a quarantined archive, so one put
+// back into incoming after a fix starts over.
+func forgetAttempts(db *sql.DB, zipName string) {
+	if _, err := db.Exec(`DELETE FROM ingest_attempts WHERE ingest_file = ?`, zipName); err != nil {
+		slog.Warn("ingest attempts not cleared", "ingest_file", zipName, "error", err)
+	}
+}
@@ -0,0 +1,95 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// DefaultInsertBatch is the number of rows written per INSERT statement
+// when Options.InsertBatch is not positive.
+const DefaultInsertBatch = 1000
+
+// maxBindVars is sqlite's limit on the parameters of one statement; a batch
+// of a wide table is cut down to fit.
+const maxBindVars = 32766
+
+// insertBatch writes the rows of one table as multi-row INSERT statements
+// of up to size rows, in the archive's transaction, instead of executing a
+// statement per line.
+type insertBatch struct {
+	db       dbConn
+	head     string
+	row      string
+	columns  int
+	size     int
+	full     *sql.Stmt
+	args     []any
+	inserted int64
+}
+
+// newInsertBatch prepares batches for head, an INSERT up to and including
+// VALUES, of rows with the given number of columns.
+func newInsertBatch(db dbConn, head string, columns, size int) *insertBatch {
+	if size <= 0 {
+		size = DefaultInsertBatch
+	}
+	if limit := maxBindVars / columns; size > limit {
+		size = limit
+	}
+	return &insertBatch{
+		db:      db,
+		head:    head,
+		row:     "(" + strings.Repeat("?, ", columns-1) + "?)",
+		columns: columns,
+		size:    size,
+	}
+}
+
+// add queues one row and writes the batch once it is full.
+func (b *insertBatch) add(ctx context.Context, args ...any) error {
+	b.args = append(b.args, args...)
+	if len(b.args) < b.size*b.columns {
+		return nil
+	}
+	return b.flush(ctx)
+}
+
+// flush writes the queued rows. Full batches reuse one prepared statement;
+// the last, shorter one is executed on its own.
+func (b *insertBatch) flush(ctx context.Context) error {
+	rows := len(b.args) / b.columns
+	if rows == 0 {
+		return nil
+	}
+	var res sql.Result
+	var err error
+	if rows == b.size {
+		if b.full == nil {
+			if b.full, err = b.db.PrepareContext(ctx, b.query(rows)); err != nil {
+				return err
+			}
+		}
+		res, err = b.full.ExecContext(ctx, b.args...)
+	} else {
+		res, err = b.db.ExecContext(ctx, b.query(rows), b.args...)
+	}
+	clear(b.args)
+	b.args = b.args[:0]
+	if err != nil {
+		return err
+	}
+	b.inserted += rowsAffected(res)
+	return nil
+}
+
+func (b *insertBatch) query(rows int) string {
+	return b.head + " " + strings.TrimSuffix(strings.Repeat(b.row+", ", rows), ", ")
+}
+
+func (b *insertBatch) Close() error {
+	if b.full != nil {
+		return b.full.Close()
+	}
+	return nil
+}
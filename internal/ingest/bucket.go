@@ -0,0 +1,162 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"workfield/internal/record"
+	"workfield/internal/timeparse"
+)
+
+// CompareAggregate reduces the sent values, and the raw values, of one
+// bucket to the single value compared in bucketed comparison.
+type CompareAggregate string
+
+const (
+	// AggregateLast compares the latest value in the bucket.
+	AggregateLast CompareAggregate = "last"
+	// AggregateMean compares the mean of the bucket's values when they are
+	// all numbers, and the latest value otherwise. Positions always use
+	// the latest value.
+	AggregateMean CompareAggregate = "mean"
+)
+
+// ParseCompareAggregate accepts "" (last), last and mean.
+func ParseCompareAggregate(value string) (CompareAggregate, error) {
+	switch aggregate := CompareAggregate(strings.ToLower(strings.TrimSpace(value))); aggregate {
+	case "":
+		return AggregateLast, nil
+	case AggregateLast, AggregateMean:
+		return aggregate, nil
+	}
+	return AggregateLast, fmt.Errorf("unknown compare aggregate %q", value)
+}
+
+// bucketInfo describes the bucket a comparison row stands for.
+type bucketInfo struct {
+	Size      time.Duration
+	Snapshots int
+}
+
+type bucketKey struct {
+	workField string
+	start     time.Time
+}
+
+type bucketSnapshot struct {
+	payload   SensorPayloadContext
+	publishAt time.Time
+}
+
+// compareBuckets is compareSnapshots for Options.CompareBucket: snapshots
+// and raw observations are grouped into windows of size aligned to the
+// clock, per work field, and each mapped sensor gets one row per window
+// comparing the aggregates. The row's publish_at is the window start. A
+// window is compared once: rows for it from a later archive are ignored
+// like any repeated comparison.
+func compareBuckets(ctx context.Context, w *comparisonWriter, snapshots []record.SensorDataRecord, rawObservations map[string][]RawObservation, mapping map[string]SensorMapping, size time.Duration, aggregate CompareAggregate, times *timeparse.Parser, ingestFile, siteID, deviceID string) error {
+	buckets := map[bucketKey][]bucketSnapshot{}
+	for _, snapshot := range snapshots {
+		payload, publishAt, err := parsePayload(times, snapshot.Payload)
+		if err != nil {
+			continue
+		}
+		workField := payload.WorkField
+		if workField == "" {
+			workField = snapshot.WorkField
+		}
+		key := bucketKey{workField: workField, start: publishAt.Truncate(size)}
+		buckets[key] = append(buckets[key], bucketSnapshot{payload: payload, publishAt: publishAt})
+	}
+	keys := make([]bucketKey, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].start.Equal(keys[j].start) {
+			return keys[i].start.Before(keys[j].start)
+		}
+		return keys[i].workField < keys[j].workField
+	})
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		members := buckets[key]
+		sort.SliceStable(members, func(i, j int) bool { return members[i].publishAt.Before(members[j].publishAt) })
+		end := key.start.Add(size)
+		for _, id := range sortedMappingIDs(mapping) {
+			entry := mapping[id]
+			if !entry.IsEnabled() || !entry.sampled(key.start) {
+				continue
+			}
+			var sent []string
+			for _, member := range members {
+				if value, ok := findSentValue(member.payload, id, entry); ok {
+					sent = append(sent, value)
+				}
+			}
+			var raw []string
+			var evidence string
+			for _, observation := range rawInWindow(rawObservations[entry.SensorID], key.start, end) {
+				if value, ok := rawObservationValue(entry, observation); ok {
+					raw = append(raw, value)
+					evidence = observation.Evidence
+				}
+			}
+			row := comparisonRow{
+				SiteID:      siteID,
+				DeviceID:    deviceID,
+				WorkField:   key.workField,
+				PublishAt:   key.start.Format(time.RFC3339Nano),
+				SensorID:    entry.SensorID,
+				SensorType:  entry.Type,
+				FieldName:   entry.Field,
+				SentValue:   aggregateValues(sent, aggregate, entry),
+				RawValue:    aggregateValues(raw, aggregate, entry),
+				RawEvidence: evidence,
+				IngestFile:  ingestFile,
+			}
+			if err := w.Write(ctx, row, entry, len(sent) > 0, len(raw) > 0, &bucketInfo{Size: size, Snapshots: len(members)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rawInWindow returns the observations in [start, end) ordered by time.
+func rawInWindow(observations []RawObservation, start, end time.Time) []RawObservation {
+	var window []RawObservation
+	for _, observation := range observations {
+		if !observation.Timestamp.Before(start) && observation.Timestamp.Before(end) {
+			window = append(window, observation)
+		}
+	}
+	sort.SliceStable(window, func(i, j int) bool { return window[i].Timestamp.Before(window[j].Timestamp) })
+	return window
+}
+
+func aggregateValues(values []string, aggregate CompareAggregate, entry SensorMapping) string {
+	if len(values) == 0 {
+		return ""
+	}
+	last := values[len(values)-1]
+	if aggregate != AggregateMean || entry.Field == "position" {
+		return last
+	}
+	var sum float64
+	for _, value := range values {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return last
+		}
+		sum += number
+	}
+	return formatNumber(sum / float64(len(values)))
+}
@@ -0,0 +1,401 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"workfield/internal/buildinfo"
+)
+
+// comparisonRow is a stored comparison result; its JSON form is what
+// Options.Publisher receives.
+type comparisonRow struct {
+	SiteID      string `json:"site_id"`
+	DeviceID    string `json:"device_id"`
+	WorkField   string `json:"work_field"`
+	PublishAt   string `json:"publish_at"`
+	SensorID    string `json:"sensor_id"`
+	SensorType  string `json:"sensor_type"`
+	FieldName   string `json:"field_name"`
+	SentValue   string `json:"sent_value"`
+	RawValue    string `json:"raw_value"`
+	Result      string `json:"result"`
+	RawEvidence string `json:"raw_evidence,omitempty"`
+	IngestFile  string `json:"ingest_file"`
+	CreatedAt   string `json:"created_at"`
+	// RowKey identifies the comparison across re-ingests and databases;
+	// see rowKey.
+	RowKey string `json:"row_key,omitempty"`
+}
+
+// rowKey hashes the natural key of a comparison row, the columns of
+// its UNIQUE constraint, so the same snapshot, sensor and field get the
+// same row_key however and whenever they were ingested.
+func rowKey(row comparisonRow) string {
+	fields := []string{row.SiteID, row.DeviceID, row.WorkField, row.PublishAt, row.SensorID, row.FieldName}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// comparisonChain appends one hash per inserted comparison row. Each hash
+// covers the row contents and the previous hash, so editing or deleting a
+// stored row breaks every later link.
+//
+// SQLite hands out the id of a deleted last row again, which would clash
+// with the chain entry of a purged or superseded comparison, so rows
+// written with a chain take their id from NextID instead.
+type comparisonChain struct {
+	stmt *sql.Stmt
+	prev string
+	next int64
+}
+
+func openComparisonChain(ctx context.Context, db dbConn) (*comparisonChain, error) {
+	var prev string
+	err := db.QueryRowContext(ctx, `SELECT hash FROM comparison_chain ORDER BY id DESC LIMIT 1`).Scan(&prev)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	var last int64
+	if err := db.QueryRowContext(ctx, `
+		SELECT MAX(COALESCE((SELECT MAX(comparison_id) FROM comparison_chain), 0),
+			COALESCE((SELECT MAX(id) FROM comparison_results), 0))
+	`).Scan(&last); err != nil {
+		return nil, err
+	}
+	stmt, err := db.PrepareContext(ctx, `
+		INSERT INTO comparison_chain (comparison_id, prev_hash, hash, created_at, site_id, device_id, ingest_file)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return &comparisonChain{stmt: stmt, prev: prev, next: last + 1}, nil
+}
+
+// NextID is the id for the next comparison row, or nil (let SQLite choose)
+// without a chain.
+func (c *comparisonChain) NextID() any {
+	if c == nil {
+		return nil
+	}
+	return c.next
+}
+
+func (c *comparisonChain) Append(ctx context.Context, res sql.Result, row comparisonRow) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return nil
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	hash := chainHash(c.prev, id, row)
+	if _, err := c.stmt.ExecContext(ctx, id, c.prev, hash, time.Now().Format(time.RFC3339Nano), row.SiteID, row.DeviceID, row.IngestFile); err != nil {
+		return err
+	}
+	c.prev = hash
+	c.next = id + 1
+	return nil
+}
+
+func (c *comparisonChain) Close() error {
+	return c.stmt.Close()
+}
+
+func chainHash(prev string, id int64, row comparisonRow) string {
+	fields := []string{
+		prev,
+		strconv.FormatInt(id, 10),
+		row.SiteID,
+		row.DeviceID,
+		row.WorkField,
+		row.PublishAt,
+		row.SensorID,
+		row.SensorType,
+		row.FieldName,
+		row.SentValue,
+		row.RawValue,
+		row.Result,
+		row.RawEvidence,
+		row.IngestFile,
+		row.CreatedAt,
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// purgeReasonPurge is purge_log.reason for a purge; a supersede records
+// "superseded by" and the archive that replaced the snapshot.
+const purgeReasonPurge = "purge"
+
+// recordPurge writes the purge_log row for the comparison rows of one
+// archive of a site and device that are about to be deleted, and flags
+// their chain entries with it. VerifyChain accepts a missing comparison
+// only when its entry names a purge_log row for the site, device and
+// archive the entry was chained with. extra narrows the rows further
+// (" AND ..." over comparison_results, with its arguments). finishPurge
+// completes the record once the rows are deleted.
+func recordPurge(ctx context.Context, db dbConn, siteID, deviceID, ingestFile, before, reason, now string, extra string, args ...any) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO purge_log (site_id, device_id, before_date, ingest_file, rows_deleted, purged_at, worker_version, reason)
+		VALUES (?, ?, ?, ?, 0, ?, ?, ?)
+	`, siteID, deviceID, before, ingestFile, now, buildinfo.Get().Short(), reason)
+	if err != nil {
+		return 0, err
+	}
+	purgeID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE comparison_chain SET purged_at = ?, purge_id = ?,
+			site_id = COALESCE(site_id, ?), device_id = COALESCE(device_id, ?), ingest_file = COALESCE(ingest_file, ?)
+		WHERE comparison_id IN (
+			SELECT id FROM comparison_results WHERE site_id = ? AND device_id = ? AND ingest_file = ?`+extra+`
+		)
+	`, append([]any{now, purgeID, siteID, deviceID, ingestFile, siteID, deviceID, ingestFile}, args...)...)
+	return purgeID, err
+}
+
+// finishPurge stores how many rows the purge deleted and appends the
+// purge record to the hash chain when one is kept. The entry takes the
+// negated purge_log id as its comparison_id, which no comparison has, and
+// its hash covers the record and the entries it flagged, so a made-up
+// purge_log row or an entry flagged afterwards only passes VerifyChain if
+// the chain is rewritten from there on, which changes its head.
+func finishPurge(ctx context.Context, db dbConn, purgeID, deleted int64) error {
+	if _, err := db.ExecContext(ctx, `UPDATE purge_log SET rows_deleted = ? WHERE id = ?`, deleted, purgeID); err != nil {
+		return err
+	}
+	var prev string
+	err := db.QueryRowContext(ctx, `SELECT hash FROM comparison_chain ORDER BY id DESC LIMIT 1`).Scan(&prev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rec purgeRecord
+	if err := db.QueryRowContext(ctx, `SELECT `+purgeRecordColumns+` FROM purge_log p WHERE p.id = ?`, purgeID).Scan(rec.dest()...); err != nil {
+		return err
+	}
+	flagged, err := purgeFlagged(ctx, db, purgeID)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO comparison_chain (comparison_id, prev_hash, hash, created_at, purged_at, purge_id, site_id, device_id, ingest_file)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, -purgeID, prev, purgeHash(prev, rec, flagged[purgeID]), time.Now().Format(time.RFC3339Nano), rec.PurgedAt, purgeID, rec.SiteID, rec.DeviceID, rec.IngestFile)
+	return err
+}
+
+// purgeRecord is the part of a purge_log row its chain entry covers.
+type purgeRecord struct {
+	ID                                       sql.NullInt64
+	SiteID, DeviceID, BeforeDate, IngestFile string
+	Reason, PurgedAt                         string
+	RowsDeleted                              int64
+}
+
+// purgeRecordColumns selects a purgeRecord from purge_log p.
+const purgeRecordColumns = `p.id, COALESCE(p.site_id, ''), COALESCE(p.device_id, ''), COALESCE(p.before_date, ''),
+	COALESCE(p.ingest_file, ''), COALESCE(p.reason, ''), COALESCE(p.purged_at, ''), COALESCE(p.rows_deleted, 0)`
+
+func (r *purgeRecord) dest() []any {
+	return []any{&r.ID, &r.SiteID, &r.DeviceID, &r.BeforeDate, &r.IngestFile, &r.Reason, &r.PurgedAt, &r.RowsDeleted}
+}
+
+// purgeFlagged returns the comparison ids of the chain entries flagged by
+// each purge, or by purgeID alone when it is not zero.
+func purgeFlagged(ctx context.Context, db dbConn, purgeID int64) (map[int64][]int64, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT purge_id, comparison_id FROM comparison_chain
+		WHERE purge_id IS NOT NULL AND comparison_id > 0 AND (? = 0 OR purge_id = ?)
+		ORDER BY purge_id, comparison_id
+	`, purgeID, purgeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	flagged := map[int64][]int64{}
+	for rows.Next() {
+		var purge, comparison int64
+		if err := rows.Scan(&purge, &comparison); err != nil {
+			return nil, err
+		}
+		flagged[purge] = append(flagged[purge], comparison)
+	}
+	return flagged, rows.Err()
+}
+
+func purgeHash(prev string, rec purgeRecord, flagged []int64) string {
+	ids := make([]string, len(flagged))
+	for i, id := range flagged {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	fields := []string{
+		prev,
+		"purge",
+		strconv.FormatInt(rec.ID.Int64, 10),
+		rec.SiteID,
+		rec.DeviceID,
+		rec.BeforeDate,
+		rec.IngestFile,
+		rec.Reason,
+		rec.PurgedAt,
+		strconv.FormatInt(rec.RowsDeleted, 10),
+		strings.Join(ids, ","),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ChainHead is the length of the hash chain and the hash of its last
+// entry. Deleting the newest entries together with their rows, or
+// rewriting the chain, leaves a chain that still verifies on its own;
+// comparing it with a head recorded by an earlier verify-chain shows it.
+type ChainHead struct {
+	Entries int
+	Hash    string
+}
+
+func (h ChainHead) String() string {
+	return strconv.Itoa(h.Entries) + ":" + h.Hash
+}
+
+// ParseChainHead reads a head in the "<entries>:<hash>" form of String.
+func ParseChainHead(s string) (ChainHead, error) {
+	entries, hash, ok := strings.Cut(s, ":")
+	n, err := strconv.Atoi(entries)
+	if !ok || err != nil || n < 1 || hash == "" {
+		return ChainHead{}, fmt.Errorf("chain head %q: want <entries>:<hash>", s)
+	}
+	return ChainHead{Entries: n, Hash: hash}, nil
+}
+
+// VerifyChain recomputes every chain entry from its comparison row or
+// purge record and returns the head of the chain. A comparison that is
+// gone must have been deleted by a purge or supersede whose record is
+// chained after it. When earlier is set, its entry must still be in the
+// chain with the same hash.
+func VerifyChain(ctx context.Context, db *sql.DB, earlier ChainHead) (ChainHead, error) {
+	// Read before the entries: a read-only database has one connection.
+	flagged, err := purgeFlagged(ctx, db, 0)
+	if err != nil {
+		return ChainHead{}, err
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.id, c.comparison_id, c.prev_hash, c.hash, c.purged_at,
+			COALESCE(c.site_id, ''), COALESCE(c.device_id, ''), COALESCE(c.ingest_file, ''),
+			`+purgeRecordColumns+`,
+			r.id, r.site_id, r.device_id, r.work_field, r.publish_at, r.sensor_id, r.sensor_type, r.field_name,
+			r.sent_value, r.raw_value, r.result, r.raw_evidence, r.ingest_file, r.created_at
+		FROM comparison_chain c
+		LEFT JOIN purge_log p ON p.id = c.purge_id
+		LEFT JOIN comparison_results r ON r.id = c.comparison_id
+		ORDER BY c.id
+	`)
+	if err != nil {
+		return ChainHead{}, err
+	}
+	defer rows.Close()
+
+	var head ChainHead
+	// awaiting holds the purges that flagged a missing comparison and the
+	// entry that named them first, until their own entry is reached.
+	awaiting := map[int64]int64{}
+	for rows.Next() {
+		var (
+			chainID, comparisonID int64
+			prevHash, hash        string
+			purgedAt              sql.NullString
+			purged                [3]string
+			rec                   purgeRecord
+			rowID                 sql.NullInt64
+			fields                [13]sql.NullString
+		)
+		dest := append([]any{&chainID, &comparisonID, &prevHash, &hash, &purgedAt, &purged[0], &purged[1], &purged[2]}, rec.dest()...)
+		dest = append(dest, &rowID)
+		for i := range fields {
+			dest = append(dest, &fields[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return head, err
+		}
+		if prevHash != head.Hash {
+			return head, fmt.Errorf("%w at entry %d: prev_hash does not match previous entry", ErrChainBroken, chainID)
+		}
+		switch {
+		case comparisonID < 0:
+			if !rec.ID.Valid || rec.ID.Int64 != -comparisonID {
+				return head, fmt.Errorf("%w at entry %d: purge %d has no purge_log record", ErrChainBroken, chainID, -comparisonID)
+			}
+			if purgeHash(prevHash, rec, flagged[rec.ID.Int64]) != hash {
+				return head, fmt.Errorf("%w at entry %d: purge %d or the entries it flagged were modified", ErrChainBroken, chainID, rec.ID.Int64)
+			}
+			delete(awaiting, rec.ID.Int64)
+		case !rowID.Valid:
+			switch {
+			case !purgedAt.Valid:
+				return head, fmt.Errorf("%w at entry %d: comparison %d is missing", ErrChainBroken, chainID, comparisonID)
+			case !rec.ID.Valid || [3]string{rec.SiteID, rec.DeviceID, rec.IngestFile} != purged:
+				return head, fmt.Errorf("%w at entry %d: comparison %d is flagged purged without a matching purge_log record", ErrChainBroken, chainID, comparisonID)
+			}
+			if _, ok := awaiting[rec.ID.Int64]; !ok {
+				awaiting[rec.ID.Int64] = chainID
+			}
+		default:
+			row := comparisonRow{
+				SiteID:      fields[0].String,
+				DeviceID:    fields[1].String,
+				WorkField:   fields[2].String,
+				PublishAt:   fields[3].String,
+				SensorID:    fields[4].String,
+				SensorType:  fields[5].String,
+				FieldName:   fields[6].String,
+				SentValue:   fields[7].String,
+				RawValue:    fields[8].String,
+				Result:      fields[9].String,
+				RawEvidence: fields[10].String,
+				IngestFile:  fields[11].String,
+				CreatedAt:   fields[12].String,
+			}
+			if chainHash(prevHash, comparisonID, row) != hash {
+				return head, fmt.Errorf("%w at entry %d: comparison %d was modified", ErrChainBroken, chainID, comparisonID)
+			}
+		}
+		head = ChainHead{Entries: head.Entries + 1, Hash: hash}
+		if head.Entries == earlier.Entries && head.Hash != earlier.Hash {
+			return head, fmt.Errorf("%w at entry %d: hash differs from the head recorded earlier", ErrChainBroken, chainID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return head, err
+	}
+	if len(awaiting) > 0 {
+		var purgeID, chainID int64
+		for p, c := range awaiting {
+			if chainID == 0 || c < chainID {
+				purgeID, chainID = p, c
+			}
+		}
+		return head, fmt.Errorf("%w at entry %d: purge %d is not in the chain", ErrChainBroken, chainID, purgeID)
+	}
+	if head.Entries < earlier.Entries {
+		return head, fmt.Errorf("%w: %d entries, fewer than the %d recorded earlier", ErrChainBroken, head.Entries, earlier.Entries)
+	}
+	return head, nil
+}
@@ -0,0 +1,123 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// chainComparisons stores one chained comparison per archive.
+func chainComparisons(t *testing.T, db *sql.DB, files ...string) {
+	t.Helper()
+	ctx := context.Background()
+	chain, err := openComparisonChain(ctx, db)
+	if err != nil {
+		t.Fatalf("open chain: %v", err)
+	}
+	defer chain.Close()
+	for _, file := range files {
+		row := comparisonRow{SiteID: "siteA", DeviceID: "device01", SensorID: "WLS1", Result: "MATCH", IngestFile: file}
+		res, err := db.ExecContext(ctx, `
+			INSERT INTO comparison_results (id, site_id, device_id, sensor_id, result, ingest_file)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, chain.NextID(), row.SiteID, row.DeviceID, row.SensorID, row.Result, row.IngestFile)
+		if err != nil {
+			t.Fatalf("insert comparison: %v", err)
+		}
+		if err := chain.Append(ctx, res, row); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+}
+
+// purgedChain is a chain of two comparisons, the first of them purged.
+func purgedChain(t *testing.T) *sql.DB {
+	t.Helper()
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "chain.db"), DBOptions{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	chainComparisons(t, db, "siteA_device01_20260120.zip", "siteA_device01_20260121.zip")
+	if _, err := PurgeIngestFiles(ctx, db, "siteA", "device01", "20260121", []string{"siteA_device01_20260120.zip"}); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	// Two comparisons and the purge record.
+	if head, err := VerifyChain(ctx, db, ChainHead{}); err != nil || head.Entries != 3 {
+		t.Fatalf("after purge: verified %d, %v", head.Entries, err)
+	}
+	return db
+}
+
+func TestVerifyChainRequiresPurgeRecord(t *testing.T) {
+	ctx := context.Background()
+	db := purgedChain(t)
+
+	// Flagging an entry by hand is not a purge.
+	if _, err := db.ExecContext(ctx, `
+		UPDATE comparison_chain SET purged_at = '2026-02-01T00:00:00Z' WHERE comparison_id = 2;
+		DELETE FROM comparison_results WHERE id = 2;
+	`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	if _, err := VerifyChain(ctx, db, ChainHead{}); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("hand-flagged deletion: got %v, want ErrChainBroken", err)
+	}
+
+	// Nor is pointing it at the purge record of another archive.
+	if _, err := db.ExecContext(ctx, `UPDATE comparison_chain SET purge_id = (SELECT MAX(id) FROM purge_log) WHERE comparison_id = 2`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	if _, err := VerifyChain(ctx, db, ChainHead{}); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("deletion under another archive's purge: got %v, want ErrChainBroken", err)
+	}
+
+	// Nor a purge_log record made up for it.
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO purge_log (site_id, device_id, before_date, ingest_file, rows_deleted, purged_at, reason)
+		VALUES ('siteA', 'device01', '20260122', 'siteA_device01_20260121.zip', 1, '2026-02-01T00:00:00Z', 'purge');
+		UPDATE comparison_chain SET purge_id = (SELECT MAX(id) FROM purge_log) WHERE comparison_id = 2;
+	`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	if _, err := VerifyChain(ctx, db, ChainHead{}); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("made-up purge record: got %v, want ErrChainBroken", err)
+	}
+}
+
+func TestVerifyChainChecksEarlierHead(t *testing.T) {
+	ctx := context.Background()
+	db := purgedChain(t)
+	chainComparisons(t, db, "siteA_device01_20260122.zip")
+	head, err := VerifyChain(ctx, db, ChainHead{})
+	if err != nil || head.Entries != 4 {
+		t.Fatalf("verified %d, %v", head.Entries, err)
+	}
+	if parsed, err := ParseChainHead(head.String()); err != nil || parsed != head {
+		t.Fatalf("parse %q: got %v, %v", head, parsed, err)
+	}
+
+	// Dropping the newest entry with its row leaves a chain that verifies
+	// on its own, but not against the head recorded before.
+	if _, err := db.ExecContext(ctx, `
+		DELETE FROM comparison_results WHERE id = 3;
+		DELETE FROM comparison_chain WHERE comparison_id = 3;
+	`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if _, err := VerifyChain(ctx, db, ChainHead{}); err != nil {
+		t.Fatalf("truncated chain on its own: %v", err)
+	}
+	if _, err := VerifyChain(ctx, db, head); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("truncated chain against earlier head: got %v, want ErrChainBroken", err)
+	}
+
+	// Appending a different entry in its place keeps the length.
+	chainComparisons(t, db, "siteA_device01_20260123.zip")
+	if _, err := VerifyChain(ctx, db, head); !errors.Is(err, ErrChainBroken) {
+		t.Fatalf("rewritten chain against earlier head: got %v, want ErrChainBroken", err)
+	}
+}
@@ -0,0 +1,87 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// PayloadCodec says how payload_json is stored. Rows written without
+// compression keep the JSON text and a NULL payload_codec, so databases
+// that predate the column read back unchanged.
+type PayloadCodec string
+
+const (
+	CodecNone PayloadCodec = ""
+	CodecZstd PayloadCodec = "zstd"
+)
+
+// ParsePayloadCodec accepts "", "none" and "zstd".
+func ParsePayloadCodec(value string) (PayloadCodec, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "none":
+		return CodecNone, nil
+	case "zstd":
+		return CodecZstd, nil
+	}
+	return CodecNone, fmt.Errorf("unknown payload codec %q", value)
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodecs builds the shared encoder and decoder; EncodeAll and DecodeAll
+// are safe for concurrent use.
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// encodePayload returns the payload_json and payload_codec column values for
+// a JSON payload. Compressed payloads are stored as a BLOB.
+func encodePayload(codec PayloadCodec, payload []byte) (any, any, error) {
+	switch codec {
+	case CodecNone:
+		return string(payload), nil, nil
+	case CodecZstd:
+		encoder, _, err := zstdCodecs()
+		if err != nil {
+			return nil, nil, err
+		}
+		return encoder.EncodeAll(payload, nil), string(codec), nil
+	}
+	return nil, nil, fmt.Errorf("unknown payload codec %q", codec)
+}
+
+// DecodePayload returns the JSON stored in a payload_json column, given the
+// row's payload_codec (empty or NULL for plain text). Anything reading
+// payload_json should go through it.
+func DecodePayload(stored []byte, codec string) ([]byte, error) {
+	switch PayloadCodec(codec) {
+	case CodecNone:
+		return stored, nil
+	case CodecZstd:
+		_, decoder, err := zstdCodecs()
+		if err != nil {
+			return nil, err
+		}
+		payload, err := decoder.DecodeAll(stored, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decode zstd payload: %w", err)
+		}
+		return payload, nil
+	}
+	return nil, fmt.Errorf("unknown payload codec %q", codec)
+}
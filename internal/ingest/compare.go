@@ -0,0 +1,495 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"workfield/internal/buildinfo"
+	"workfield/internal/decoder"
+	"workfield/internal/logging"
+	"workfield/internal/position"
+	"workfield/internal/record"
+	"workfield/internal/timeparse"
+)
+
+// RawObservation is one raw_session line. Values holds the named values a
+// decoder plugin produced for it; Value is the text after rcv:/snd:. Line
+// is the whole trimmed line after redaction and Evidence its clipped start.
+type RawObservation struct {
+	Timestamp time.Time
+	Value     string
+	Values    map[string]json.RawMessage
+	Line      string
+	Evidence  string
+}
+
+// loadRawObservations reads raw_session logs for mapped sensors. The count's
+// Lines is the number of log lines scanned. Lines of sensor types with a
+// decoder plugin are decoded; lines the plugin rejects are skipped.
+// evidence redacts and clips what is kept of each line. A line with the
+// same time and value as one already read for the sensor, as when a log and
+// its rotated copy overlap, is skipped so it is not counted twice. Files
+// are attributed to sensors by sensorMatcher.
+func loadRawObservations(ctx context.Context, dir string, mapping map[string]SensorMapping, times *timeparse.Parser, decoders *decoder.Set, evidence Evidence) (map[string][]RawObservation, StageCount, error) {
+	var count StageCount
+	observations := map[string][]RawObservation{}
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return observations, count, nil
+	}
+	type observationKey struct {
+		sensorID string
+		at       int64
+		value    string
+	}
+	seen := map[observationKey]bool{}
+	duplicates := map[string]int{}
+	matcher, err := newSensorMatcher(mapping)
+	if err != nil {
+		return observations, count, err
+	}
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sensor, ok, err := matcher.match(filepath.ToSlash(rel))
+		if err != nil || !ok {
+			return err
+		}
+		sensorID := sensor.SensorID
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			count.Lines++
+			line := scanner.Text()
+			timestamp, value, ok := parseRawLine(times, sensor.Type, line)
+			if !ok {
+				continue
+			}
+			key := observationKey{sensorID, timestamp.UnixNano(), value}
+			if seen[key] {
+				duplicates[sensorID]++
+				continue
+			}
+			seen[key] = true
+			kept := evidence.redact(strings.TrimSpace(line))
+			observation := RawObservation{Timestamp: timestamp, Value: value, Line: kept, Evidence: evidence.clip(kept)}
+			if decoders.Has(sensor.Type) {
+				values, err := decoders.Decode(ctx, sensor.Type, sensorID, value)
+				if errors.Is(err, decoder.ErrDecode) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				observation.Values = values
+			}
+			observations[sensorID] = append(observations[sensorID], observation)
+		}
+		return scanner.Err()
+	})
+	for sensorID, lines := range duplicates {
+		logging.From(ctx).Info("duplicate raw observations skipped", "sensor_id", sensorID, "lines", lines)
+	}
+	return observations, count, err
+}
+
+func parseRawLine(times *timeparse.Parser, sensorType, line string) (time.Time, string, bool) {
+	parsed, _, ok := times.ParsePrefix(line)
+	if !ok {
+		return time.Time{}, "", false
+	}
+	value := extractRawValue(sensorType, line)
+	if value == "" {
+		return time.Time{}, "", false
+	}
+	return parsed, value, true
+}
+
+func extractRawValue(sensorType, line string) string {
+	lower := strings.ToLower(line)
+	if idx := strings.Index(lower, "rcv:"); idx != -1 {
+		return strings.TrimSpace(line[idx+4:])
+	}
+	if idx := strings.Index(lower, "status"); idx != -1 {
+		return strings.TrimSpace(line[idx:])
+	}
+	if idx := strings.Index(lower, "snd:"); idx != -1 {
+		return strings.TrimSpace(line[idx+4:])
+	}
+	return ""
+}
+
+// compareSnapshots writes one comparison row per snapshot and mapped sensor
+// through w.
+func compareSnapshots(ctx context.Context, w *comparisonWriter, snapshots []record.SensorDataRecord, rawObservations map[string][]RawObservation, mapping map[string]SensorMapping, window time.Duration, times *timeparse.Parser, ingestFile, siteID, deviceID string) error {
+	for _, snapshot := range snapshots {
+		payload, publishAt, err := parsePayload(times, snapshot.Payload)
+		if err != nil {
+			continue
+		}
+		workField := payload.WorkField
+		if workField == "" {
+			workField = snapshot.WorkField
+		}
+		publishTime := publishAt
+		for _, id := range sortedMappingIDs(mapping) {
+			entry := mapping[id]
+			if !entry.IsEnabled() || !entry.sampled(publishTime) {
+				continue
+			}
+			sentValue, ok := findSentValue(payload, id, entry)
+			rawValue, rawEvidence, rawFound := findRawValue(entry, rawObservations, publishTime, window)
+			w.alignment.observe(entry.SensorID, rawObservations[entry.SensorID], publishTime)
+			row := comparisonRow{
+				SiteID:      siteID,
+				DeviceID:    deviceID,
+				WorkField:   workField,
+				PublishAt:   publishTime.Format(time.RFC3339Nano),
+				SensorID:    entry.SensorID,
+				SensorType:  entry.Type,
+				FieldName:   entry.Field,
+				SentValue:   sentValue,
+				RawValue:    rawValue,
+				RawEvidence: rawEvidence,
+				IngestFile:  ingestFile,
+			}
+			if err := w.Write(ctx, row, entry, ok, rawFound, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// comparisonWriter stores comparison rows for one archive, appending them
+// to the hash chain and to inserted when those are set. count has the
+// comparisons made as Lines and the new rows as Rows; tallies counts the
+// results per sensor and alignment, when set, the time offsets.
+type comparisonWriter struct {
+	stmt      *sql.Stmt
+	chain     *comparisonChain
+	inserted  *[]comparisonRow
+	version   string
+	count     StageCount
+	tallies   map[string]SensorTally
+	alignment *alignmentReport
+}
+
+func newComparisonWriter(ctx context.Context, db dbConn, chain *comparisonChain, inserted *[]comparisonRow) (*comparisonWriter, error) {
+	stmt, err := db.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO comparison_results
+		(id, site_id, device_id, work_field, publish_at, sensor_id, sensor_type, field_name, sent_value, raw_value, result, raw_evidence, ingest_file, created_at, worker_version, sent_lat, sent_lon, raw_lat, raw_lon, bucket_seconds, bucket_snapshots, row_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return &comparisonWriter{stmt: stmt, chain: chain, inserted: inserted, version: buildinfo.Get().Short(), tallies: map[string]SensorTally{}}, nil
+}
+
+// Write compares row's sent and raw values under entry, sets its result and
+// stores it. bucket is nil for a per-snapshot row.
+func (w *comparisonWriter) Write(ctx context.Context, row comparisonRow, entry SensorMapping, sentFound, rawFound bool, bucket *bucketInfo) error {
+	row.Result = compareValues(row.SentValue, row.RawValue, sentFound, rawFound, entry)
+	var sentPoint, rawPoint *position.Point
+	if entry.Field == "position" {
+		sentPoint = parsePoint(row.SentValue, sentFound)
+		rawPoint = parsePoint(row.RawValue, rawFound)
+		if sentPoint != nil && rawPoint != nil {
+			row.Result = comparePositions(*sentPoint, *rawPoint, entry.Tolerance)
+		}
+	}
+	w.count.Lines++
+	tally := w.tallies[row.SensorID]
+	tally.add(row.SensorType, row.Result)
+	w.tallies[row.SensorID] = tally
+	row.CreatedAt = time.Now().Format(time.RFC3339Nano)
+	row.RowKey = rowKey(row)
+	var bucketSeconds, bucketSnapshots any
+	if bucket != nil {
+		bucketSeconds, bucketSnapshots = int64(bucket.Size/time.Second), bucket.Snapshots
+	}
+	res, err := w.stmt.ExecContext(ctx, w.chain.NextID(), row.SiteID, row.DeviceID, row.WorkField, row.PublishAt, row.SensorID, row.SensorType, row.FieldName, row.SentValue, row.RawValue, row.Result, row.RawEvidence, row.IngestFile, row.CreatedAt, w.version,
+		pointLat(sentPoint), pointLon(sentPoint), pointLat(rawPoint), pointLon(rawPoint), bucketSeconds, bucketSnapshots, row.RowKey)
+	if err != nil {
+		return err
+	}
+	added := rowsAffected(res)
+	w.count.Rows += added
+	if row.Result == "MISMATCH" {
+		w.count.Mismatches += added
+	}
+	if w.inserted != nil && added > 0 {
+		*w.inserted = append(*w.inserted, row)
+	}
+	if w.chain != nil {
+		return w.chain.Append(ctx, res, row)
+	}
+	return nil
+}
+
+func (w *comparisonWriter) Close() error {
+	return w.stmt.Close()
+}
+
+func parsePayload(times *timeparse.Parser, payloadRaw json.RawMessage) (SensorPayloadContext, time.Time, error) {
+	var payload SensorPayload
+	if err := json.Unmarshal(payloadRaw, &payload); err != nil {
+		return SensorPayloadContext{}, time.Time{}, err
+	}
+	publishAt := payload.PublishAt
+	if publishAt == "" {
+		publishAt = payload.Time
+	}
+	timestamp, err := times.Parse(publishAt)
+	if err != nil {
+		return SensorPayloadContext{}, time.Time{}, err
+	}
+
+	return SensorPayloadContext{
+		WorkField: payload.WorkField,
+		Data:      payload.Data,
+	}, timestamp, nil
+}
+
+type SensorPayloadContext struct {
+	WorkField string
+	Data      []SensorDataItem
+}
+
+func findSentValue(payload SensorPayloadContext, id string, entry SensorMapping) (string, bool) {
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		return "", false
+	}
+	for _, item := range payload.Data {
+		if item.ID != idInt {
+			continue
+		}
+		if entry.JSONType != "" && !strings.EqualFold(entry.JSONType, item.Type) {
+			continue
+		}
+		switch entry.Field {
+		case "ping":
+			return normalizeValue(item.Ping), true
+		case "position":
+			return normalizeValue(item.Position), true
+		default:
+			return entry.normalizeJSON(item.Value), true
+		}
+	}
+	return "", false
+}
+
+func normalizeValue(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return strings.TrimSpace(string(raw))
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.ToLower(strings.TrimSpace(v))
+	case float64:
+		return formatNumber(v)
+	case bool:
+		return strings.ToLower(strconv.FormatBool(v))
+	default:
+		return strings.ToLower(strings.TrimSpace(string(raw)))
+	}
+}
+
+func findRawValue(entry SensorMapping, observations map[string][]RawObservation, target time.Time, window time.Duration) (string, string, bool) {
+	obs := observations[entry.SensorID]
+	if len(obs) == 0 {
+		return "", "", false
+	}
+	start := target.Add(-window)
+	end := target.Add(window)
+	var selected RawObservation
+	found := false
+	for _, item := range obs {
+		if item.Timestamp.Before(start) || item.Timestamp.After(end) {
+			continue
+		}
+		selected = item
+		found = true
+	}
+	if !found {
+		return "", "", false
+	}
+	value, ok := rawObservationValue(entry, selected)
+	return value, selected.Evidence, ok
+}
+
+// rawObservationValue is the value of observation compared for entry: the
+// decoder plugin's value for the entry's field, the raw_decode number, or
+// the normalized text.
+func rawObservationValue(entry SensorMapping, observation RawObservation) (string, bool) {
+	if observation.Values != nil {
+		field := entry.Field
+		if field == "" {
+			field = "value"
+		}
+		value, ok := observation.Values[field]
+		if !ok {
+			return "", false
+		}
+		return normalizeText(entry.normalizeJSON(value)), true
+	}
+	if entry.RawDecode != nil {
+		// A payload that does not decode is kept as text so the row shows
+		// what was received; it then compares as a mismatch.
+		if number, err := entry.RawDecode.Decode(observation.Value); err == nil {
+			return formatNumber(number), true
+		}
+	}
+	return normalizeText(entry.normalizeNumber(observation.Value)), true
+}
+
+func formatNumber(value float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.3f", value), "0"), ".")
+}
+
+func normalizeText(value string) string {
+	trimmed := strings.TrimSpace(value)
+	trimmed = strings.ToLower(trimmed)
+	trimmed = strings.ReplaceAll(trimmed, " ", "")
+	return trimmed
+}
+
+func compareValues(sentValue, rawValue string, sentFound, rawFound bool, entry SensorMapping) string {
+	if !sentFound {
+		return "MISSING_SENT"
+	}
+	if sentFound && !rawFound {
+		return "MISSING_RAW"
+	}
+	if sentArray, ok := parseNumberArray(sentValue); ok {
+		if rawArray, ok := parseNumberArray(rawValue); ok {
+			return compareArrays(sentArray, rawArray, entry)
+		}
+	}
+	if entry.Tolerance > 0 {
+		sentNum, sentErr := strconv.ParseFloat(sentValue, 64)
+		rawNum, rawErr := strconv.ParseFloat(rawValue, 64)
+		if sentErr == nil && rawErr == nil {
+			if absFloat(sentNum-rawNum) <= entry.Tolerance {
+				return "MATCH"
+			}
+			return "MISMATCH"
+		}
+	}
+	if normalizeText(sentValue) == normalizeText(rawValue) {
+		return "MATCH"
+	}
+	return "MISMATCH"
+}
+
+// parseNumberArray reads a JSON array of numbers, such as the three phase
+// currents of one reading.
+func parseNumberArray(value string) ([]float64, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+		return nil, false
+	}
+	var numbers []float64
+	if err := json.Unmarshal([]byte(value), &numbers); err != nil {
+		return nil, false
+	}
+	return numbers, true
+}
+
+// compareArrays matches two numeric arrays element by element within the
+// entry's tolerance. Arrays of different lengths, or of another length than
+// the entry's ArrayLength, do not match.
+func compareArrays(sent, raw []float64, entry SensorMapping) string {
+	if len(sent) != len(raw) || (entry.ArrayLength > 0 && len(sent) != entry.ArrayLength) {
+		return "MISMATCH"
+	}
+	for i := range sent {
+		if absFloat(sent[i]-raw[i]) > entry.Tolerance {
+			return "MISMATCH"
+		}
+	}
+	return "MATCH"
+}
+
+// defaultPositionTolerance is the distance in meters under which two
+// positions match when the mapping sets no tolerance. It absorbs the rounding
+// of DMS seconds and NMEA minutes, which is a few meters at most.
+const defaultPositionTolerance = 5.0
+
+// parsePoint parses a normalized position value, or returns nil when the
+// value is missing or not a coordinate we understand. Unparsed positions are
+// compared as text.
+func parsePoint(value string, found bool) *position.Point {
+	if !found {
+		return nil
+	}
+	point, err := position.Parse(value)
+	if err != nil {
+		return nil
+	}
+	return &point
+}
+
+// comparePositions matches two coordinates by distance; for position fields
+// the mapping tolerance is in meters.
+func comparePositions(sent, raw position.Point, tolerance float64) string {
+	if tolerance <= 0 {
+		tolerance = defaultPositionTolerance
+	}
+	if position.Distance(sent, raw) <= tolerance {
+		return "MATCH"
+	}
+	return "MISMATCH"
+}
+
+func pointLat(point *position.Point) any {
+	if point == nil {
+		return nil
+	}
+	return point.Lat
+}
+
+func pointLon(point *position.Point) any {
+	if point == nil {
+		return nil
+	}
+	return point.Lon
+}
+
+func absFloat(value float64) float64 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,182 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+)
+
+// DailyFieldSummary rolls up one day of one work field of a device: the
+// snapshots stored and their comparison results by outcome. Day is the
+// date part of publish_at, i.e. the device's local day. ControllerEvents
+// counts the device's controller events of the day (power loss, door open,
+// ...), which often explain the mismatches; they are device-wide, so every
+// work field of the device shows the same counts. Hours splits the
+// comparison results by the hour of publish_at, for a heatmap of when in
+// the day the faults happen.
+type DailyFieldSummary struct {
+	Day         string `json:"day"`
+	SiteID      string `json:"site_id"`
+	DeviceID    string `json:"device_id"`
+	WorkField   string `json:"work_field"`
+	Snapshots   int64  `json:"snapshots"`
+	Comparisons int64  `json:"comparisons"`
+	Match       int64  `json:"match"`
+	Mismatch    int64  `json:"mismatch"`
+	MissingRaw  int64  `json:"missing_raw"`
+	MissingSent int64  `json:"missing_sent"`
+
+	ControllerEvents     int64            `json:"controller_events"`
+	ControllerEventKinds map[string]int64 `json:"controller_event_kinds,omitempty"`
+
+	Hours [24]HourCounts `json:"hours"`
+}
+
+// HourCounts are the comparison results of one hour of a day.
+type HourCounts struct {
+	Match       int64 `json:"match"`
+	Mismatch    int64 `json:"mismatch"`
+	MissingRaw  int64 `json:"missing_raw"`
+	MissingSent int64 `json:"missing_sent"`
+}
+
+// Comparisons is the number of results in the hour.
+func (h HourCounts) Comparisons() int64 {
+	return h.Match + h.Mismatch + h.MissingRaw + h.MissingSent
+}
+
+// DailySummary computes the per-work-field daily rollups matching filter,
+// ordered by day, site, device and work field. Rollups are computed from
+// the stored rows on every call, so purges and superseded snapshots are
+// reflected immediately.
+func DailySummary(ctx context.Context, db *sql.DB, filter Filter) ([]DailyFieldSummary, error) {
+	type key struct{ day, site, device, field string }
+	found := map[key]*DailyFieldSummary{}
+	entry := func(k key) *DailyFieldSummary {
+		if found[k] == nil {
+			found[k] = &DailyFieldSummary{Day: k.day, SiteID: k.site, DeviceID: k.device, WorkField: k.field}
+		}
+		return found[k]
+	}
+
+	where, args := filter.where()
+	rows, err := db.QueryContext(ctx, `
+		SELECT substr(publish_at, 1, 10), site_id, device_id, COALESCE(work_field, ''), COUNT(*)
+		FROM sensor_data_snapshots`+where+`
+		GROUP BY 1, 2, 3, 4
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var k key
+		var count int64
+		if err := rows.Scan(&k.day, &k.site, &k.device, &k.field, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entry(k).Snapshots = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT substr(publish_at, 1, 10), site_id, device_id, COALESCE(work_field, ''), CAST(substr(publish_at, 12, 2) AS INTEGER), COUNT(*),
+			SUM(result = 'MATCH'), SUM(result = 'MISMATCH'), SUM(result = 'MISSING_RAW'), SUM(result = 'MISSING_SENT')
+		FROM comparison_results`+where+`
+		GROUP BY 1, 2, 3, 4, 5
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var k key
+		var hour int
+		var comparisons int64
+		var h HourCounts
+		if err := rows.Scan(&k.day, &k.site, &k.device, &k.field, &hour, &comparisons, &h.Match, &h.Mismatch, &h.MissingRaw, &h.MissingSent); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e := entry(k)
+		e.Comparisons += comparisons
+		e.Match += h.Match
+		e.Mismatch += h.Mismatch
+		e.MissingRaw += h.MissingRaw
+		e.MissingSent += h.MissingSent
+		if hour >= 0 && hour < len(e.Hours) {
+			e.Hours[hour] = h
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Controller events have no work field: their counts go on every row of
+	// the device and day, or on a row of their own when the device stored
+	// no snapshots that day.
+	fields := map[key][]string{}
+	for k := range found {
+		dev := key{k.day, k.site, k.device, ""}
+		fields[dev] = append(fields[dev], k.field)
+	}
+	where, args = filter.deviceWhere("occurred_at")
+	rows, err = db.QueryContext(ctx, `
+		SELECT substr(occurred_at, 1, 10), site_id, device_id, kind, COUNT(*)
+		FROM controller_events`+where+`
+		GROUP BY 1, 2, 3, 4
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dev key
+		var kind string
+		var count int64
+		if err := rows.Scan(&dev.day, &dev.site, &dev.device, &kind, &count); err != nil {
+			return nil, err
+		}
+		targets := fields[dev]
+		if len(targets) == 0 {
+			if filter.WorkField != "" {
+				continue
+			}
+			targets = []string{""}
+			fields[dev] = targets
+		}
+		for _, field := range targets {
+			e := entry(key{dev.day, dev.site, dev.device, field})
+			if e.ControllerEventKinds == nil {
+				e.ControllerEventKinds = map[string]int64{}
+			}
+			e.ControllerEvents += count
+			e.ControllerEventKinds[kind] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	summaries := make([]DailyFieldSummary, 0, len(found))
+	for _, s := range found {
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.SiteID != b.SiteID {
+			return a.SiteID < b.SiteID
+		}
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.WorkField < b.WorkField
+	})
+	return summaries, nil
+}
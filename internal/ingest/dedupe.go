@@ -0,0 +1,279 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"workfield/internal/record"
+	"workfield/internal/timeparse"
+)
+
+// DedupePolicy decides what happens to a snapshot another archive already
+// stored under the same site, device, work field and publish_at, as when a
+// device backfills data it uploaded before. Copies with the same payload
+// hash are always dropped by skip and supersede; the policies differ on a
+// copy whose payload changed.
+type DedupePolicy string
+
+const (
+	// DedupeSkip keeps the first stored copy.
+	DedupeSkip DedupePolicy = "skip"
+	// DedupeSupersede replaces the stored copy with the newer one and
+	// recompares it; the old comparison rows are deleted (and flagged in
+	// the hash chain like a purge).
+	DedupeSupersede DedupePolicy = "supersede"
+	// DedupeKeepAll keeps the first stored copy and every later copy,
+	// changed or not, in snapshot_duplicates.
+	DedupeKeepAll DedupePolicy = "keep-all"
+)
+
+// ParseDedupePolicy accepts "" (skip), skip, supersede and keep-all.
+func ParseDedupePolicy(value string) (DedupePolicy, error) {
+	switch policy := DedupePolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return DedupeSkip, nil
+	case DedupeSkip, DedupeSupersede, DedupeKeepAll:
+		return policy, nil
+	}
+	return DedupeSkip, fmt.Errorf("unknown snapshot dedupe policy %q", value)
+}
+
+func payloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// snapshotStore writes sensor_data_snapshots rows under a dedupe policy.
+// New snapshots are inserted in batches; pending holds the keys of those
+// not written yet, so a second copy in the same archive finds the first.
+type snapshotStore struct {
+	db         dbConn
+	policy     DedupePolicy
+	find       *sql.Stmt
+	insert     *insertBatch
+	pending    map[[2]string]bool
+	supersede  *sql.Stmt
+	keep       *sql.Stmt
+	identical  int64
+	changed    int64
+	superseded int64
+}
+
+// storedSnapshot is a snapshot row as written by add.
+type storedSnapshot struct {
+	siteID, deviceID, workField, publishAt string
+	payload                                []byte
+	stored, codec                          any
+	version                                int
+	ingestFile                             string
+	// compareKey is the work_field and publish_at its comparison rows
+	// are stored under, empty when the payload has no usable time.
+	compareKey [2]string
+}
+
+func newSnapshotStore(ctx context.Context, db dbConn, policy DedupePolicy, batch int) (*snapshotStore, error) {
+	if policy == "" {
+		policy = DedupeSkip
+	}
+	s := &snapshotStore{db: db, policy: policy, pending: map[[2]string]bool{}}
+	s.insert = newInsertBatch(db, `
+		INSERT OR IGNORE INTO sensor_data_snapshots
+		(site_id, device_id, work_field, publish_at, payload_json, payload_codec, payload_version, payload_hash, ingest_file, ingested_at)
+		VALUES`, 10, batch)
+	var err error
+	for _, prepared := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.find, `
+			SELECT id, payload_hash, payload_json, payload_codec FROM sensor_data_snapshots
+			WHERE site_id = ? AND device_id = ? AND publish_at = ? AND work_field = ?
+		`},
+		{&s.supersede, `
+			UPDATE sensor_data_snapshots
+			SET payload_json = ?, payload_codec = ?, payload_version = ?, payload_hash = ?, ingest_file = ?, ingested_at = ?
+			WHERE id = ?
+		`},
+		{&s.keep, `
+			INSERT INTO snapshot_duplicates
+			(snapshot_id, site_id, device_id, work_field, publish_at, payload_json, payload_codec, payload_version, payload_hash, ingest_file, ingested_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`},
+	} {
+		if *prepared.stmt, err = db.PrepareContext(ctx, prepared.query); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// add stores snap and reports whether it is now the stored copy for its key
+// (new, superseding, or identical to the stored one), which is when it
+// should be compared. The returned count is the number of rows written to
+// sensor_data_snapshots by replacing a stored copy; inserted new ones are
+// counted in s.insert.
+func (s *snapshotStore) add(ctx context.Context, snap storedSnapshot) (bool, int64, error) {
+	hash := payloadHash(snap.payload)
+	now := time.Now().Format(time.RFC3339Nano)
+	key := [2]string{snap.workField, snap.publishAt}
+	if s.pending[key] {
+		if err := s.flush(ctx); err != nil {
+			return false, 0, err
+		}
+	}
+	id, storedHash, err := s.lookup(ctx, snap)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := s.insert.add(ctx, snap.siteID, snap.deviceID, snap.workField, snap.publishAt, snap.stored, snap.codec, snap.version, hash, snap.ingestFile, now); err != nil {
+			return false, 0, err
+		}
+		if len(s.insert.args) == 0 {
+			clear(s.pending)
+		} else {
+			s.pending[key] = true
+		}
+		return true, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	same := storedHash == hash
+	if same {
+		s.identical++
+	} else {
+		s.changed++
+	}
+	switch {
+	case s.policy == DedupeKeepAll:
+		if _, err := s.keep.ExecContext(ctx, id, snap.siteID, snap.deviceID, snap.workField, snap.publishAt, snap.stored, snap.codec, snap.version, hash, snap.ingestFile, now); err != nil {
+			return false, 0, err
+		}
+	case s.policy == DedupeSupersede && !same:
+		if err := s.replace(ctx, id, snap, hash, now); err != nil {
+			return false, 0, err
+		}
+		s.superseded++
+		return true, 1, nil
+	}
+	return same, 0, nil
+}
+
+// lookup returns the stored row for snap's key. Rows from before
+// payload_hash existed are hashed from their stored payload.
+func (s *snapshotStore) lookup(ctx context.Context, snap storedSnapshot) (int64, string, error) {
+	var (
+		id      int64
+		hash    sql.NullString
+		payload []byte
+		codec   sql.NullString
+	)
+	if err := s.find.QueryRowContext(ctx, snap.siteID, snap.deviceID, snap.publishAt, snap.workField).Scan(&id, &hash, &payload, &codec); err != nil {
+		return 0, "", err
+	}
+	if hash.Valid {
+		return id, hash.String, nil
+	}
+	decoded, err := DecodePayload(payload, codec.String)
+	if err != nil {
+		return 0, "", err
+	}
+	return id, payloadHash(decoded), nil
+}
+
+// replace overwrites a stored snapshot and drops the comparisons made from
+// it so the compare stage records them again. Both happen in the archive's
+// transaction.
+func (s *snapshotStore) replace(ctx context.Context, id int64, snap storedSnapshot, hash, now string) error {
+	if _, err := s.supersede.ExecContext(ctx, snap.stored, snap.codec, snap.version, hash, snap.ingestFile, now, id); err != nil {
+		return err
+	}
+	if snap.compareKey == [2]string{} {
+		return nil
+	}
+	// The dropped comparisons are recorded in purge_log, one row per
+	// archive they came from, like a purge.
+	const comparisons = ` AND work_field = ? AND publish_at = ?`
+	files, err := s.comparisonFiles(ctx, snap)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		args := []any{snap.siteID, snap.deviceID, file, snap.compareKey[0], snap.compareKey[1]}
+		purgeID, err := recordPurge(ctx, s.db, snap.siteID, snap.deviceID, file, "", "superseded by "+snap.ingestFile, now, comparisons, args[3:]...)
+		if err != nil {
+			return err
+		}
+		res, err := s.db.ExecContext(ctx, `DELETE FROM comparison_results WHERE site_id = ? AND device_id = ? AND ingest_file = ?`+comparisons, args...)
+		if err != nil {
+			return err
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if err := finishPurge(ctx, s.db, purgeID, deleted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// comparisonFiles lists the archives of the comparisons stored under the
+// snapshot's compare key.
+func (s *snapshotStore) comparisonFiles(ctx context.Context, snap storedSnapshot) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT ingest_file FROM comparison_results
+		WHERE site_id = ? AND device_id = ? AND work_field = ? AND publish_at = ?
+		ORDER BY ingest_file
+	`, snap.siteID, snap.deviceID, snap.compareKey[0], snap.compareKey[1])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// comparisonKey is the work_field and publish_at compareSnapshots stores
+// the snapshot's comparison rows under.
+func comparisonKey(times *timeparse.Parser, snapshot record.SensorDataRecord) [2]string {
+	payload, publishAt, err := parsePayload(times, snapshot.Payload)
+	if err != nil {
+		return [2]string{}
+	}
+	workField := payload.WorkField
+	if workField == "" {
+		workField = snapshot.WorkField
+	}
+	return [2]string{workField, publishAt.Format(time.RFC3339Nano)}
+}
+
+// flush writes the pending new snapshots.
+func (s *snapshotStore) flush(ctx context.Context) error {
+	clear(s.pending)
+	return s.insert.flush(ctx)
+}
+
+func (s *snapshotStore) Close() error {
+	s.insert.Close()
+	for _, stmt := range []*sql.Stmt{s.find, s.supersede, s.keep} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DeviceSettings overrides worker options for the archives of one device,
+// or of every device of a site when DeviceID is empty; a device's own
+// settings replace its site's field by field, and nil fields keep the
+// worker's value. They are read from device_settings for every archive, so
+// a change applies from the next archive on without restarting the worker.
+type DeviceSettings struct {
+	SiteID   string `json:"site_id"`
+	DeviceID string `json:"device_id,omitempty"`
+	// WindowSeconds, CompareBucketMinutes, CompareAggregate and
+	// SnapshotDedupe replace Window, CompareBucket, CompareAggregate and
+	// SnapshotDedupe.
+	WindowSeconds        *int    `json:"window_seconds,omitempty"`
+	CompareBucketMinutes *int    `json:"compare_bucket_minutes,omitempty"`
+	CompareAggregate     *string `json:"compare_aggregate,omitempty"`
+	SnapshotDedupe       *string `json:"snapshot_dedupe,omitempty"`
+	// RawObservationDays stores raw_session lines when positive and keeps
+	// them that many days; 0 stores none.
+	RawObservationDays *int `json:"raw_observation_days,omitempty"`
+	// Publish false keeps the device's results off the event bus.
+	Publish   *bool     `json:"publish,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the values that are set.
+func (s DeviceSettings) Validate() error {
+	switch {
+	case s.SiteID == "":
+		return errors.New("device settings need a site id")
+	case s.WindowSeconds != nil && *s.WindowSeconds <= 0:
+		return errors.New("window_seconds must be positive")
+	case s.CompareBucketMinutes != nil && *s.CompareBucketMinutes < 0:
+		return errors.New("compare_bucket_minutes must not be negative")
+	case s.RawObservationDays != nil && *s.RawObservationDays < 0:
+		return errors.New("raw_observation_days must not be negative")
+	}
+	if s.CompareAggregate != nil {
+		if _, err := ParseCompareAggregate(*s.CompareAggregate); err != nil {
+			return err
+		}
+	}
+	if s.SnapshotDedupe != nil {
+		if _, err := ParseDedupePolicy(*s.SnapshotDedupe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge returns s with the fields other sets replaced.
+func (s DeviceSettings) merge(other DeviceSettings) DeviceSettings {
+	if other.WindowSeconds != nil {
+		s.WindowSeconds = other.WindowSeconds
+	}
+	if other.CompareBucketMinutes != nil {
+		s.CompareBucketMinutes = other.CompareBucketMinutes
+	}
+	if other.CompareAggregate != nil {
+		s.CompareAggregate = other.CompareAggregate
+	}
+	if other.SnapshotDedupe != nil {
+		s.SnapshotDedupe = other.SnapshotDedupe
+	}
+	if other.RawObservationDays != nil {
+		s.RawObservationDays = other.RawObservationDays
+	}
+	if other.Publish != nil {
+		s.Publish = other.Publish
+	}
+	return s
+}
+
+// apply returns opts with the settings that are set.
+func (s DeviceSettings) apply(opts Options) (Options, error) {
+	if s.WindowSeconds != nil {
+		opts.Window = time.Duration(*s.WindowSeconds) * time.Second
+	}
+	if s.CompareBucketMinutes != nil {
+		opts.CompareBucket = time.Duration(*s.CompareBucketMinutes) * time.Minute
+	}
+	if s.CompareAggregate != nil {
+		aggregate, err := ParseCompareAggregate(*s.CompareAggregate)
+		if err != nil {
+			return opts, err
+		}
+		opts.CompareAggregate = aggregate
+	}
+	if s.SnapshotDedupe != nil {
+		policy, err := ParseDedupePolicy(*s.SnapshotDedupe)
+		if err != nil {
+			return opts, err
+		}
+		opts.SnapshotDedupe = policy
+	}
+	if s.RawObservationDays != nil {
+		opts.RawObservations = *s.RawObservationDays > 0
+	}
+	if s.Publish != nil && !*s.Publish {
+		opts.Publisher = nil
+	}
+	return opts, nil
+}
+
+const deviceSettingsColumns = `site_id, device_id, window_seconds, compare_bucket_minutes, compare_aggregate, snapshot_dedupe, raw_observation_days, publish, updated_at`
+
+func scanDeviceSettings(rows *sql.Rows) (DeviceSettings, error) {
+	var s DeviceSettings
+	var window, bucket, days sql.NullInt64
+	var aggregate, dedupe, updatedAt sql.NullString
+	var publish sql.NullBool
+	if err := rows.Scan(&s.SiteID, &s.DeviceID, &window, &bucket, &aggregate, &dedupe, &days, &publish, &updatedAt); err != nil {
+		return s, err
+	}
+	s.WindowSeconds = nullInt(window)
+	s.CompareBucketMinutes = nullInt(bucket)
+	s.RawObservationDays = nullInt(days)
+	if aggregate.Valid {
+		s.CompareAggregate = &aggregate.String
+	}
+	if dedupe.Valid {
+		s.SnapshotDedupe = &dedupe.String
+	}
+	if publish.Valid {
+		s.Publish = &publish.Bool
+	}
+	s.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt.String)
+	return s, nil
+}
+
+func nullInt(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	n := int(value.Int64)
+	return &n
+}
+
+// deviceSettings returns the merged site and device settings of an
+// archive's device.
+func deviceSettings(ctx context.Context, db *sql.DB, siteID, deviceID string) (DeviceSettings, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+deviceSettingsColumns+` FROM device_settings
+		WHERE site_id = ? AND device_id IN ('', ?)
+		ORDER BY device_id
+	`, siteID, deviceID)
+	if err != nil {
+		return DeviceSettings{}, err
+	}
+	defer rows.Close()
+	merged := DeviceSettings{SiteID: siteID, DeviceID: deviceID}
+	for rows.Next() {
+		s, err := scanDeviceSettings(rows)
+		if err != nil {
+			return DeviceSettings{}, err
+		}
+		merged = merged.merge(s)
+	}
+	return merged, rows.Err()
+}
+
+// SetDeviceSettings stores the fields s sets for its site and device,
+// keeping the ones it leaves nil.
+func SetDeviceSettings(ctx context.Context, db *sql.DB, s DeviceSettings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	var publish any
+	if s.Publish != nil {
+		publish = *s.Publish
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO device_settings (`+deviceSettingsColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(site_id, device_id) DO UPDATE SET
+			window_seconds = COALESCE(excluded.window_seconds, window_seconds),
+			compare_bucket_minutes = COALESCE(excluded.compare_bucket_minutes, compare_bucket_minutes),
+			compare_aggregate = COALESCE(excluded.compare_aggregate, compare_aggregate),
+			snapshot_dedupe = COALESCE(excluded.snapshot_dedupe, snapshot_dedupe),
+			raw_observation_days = COALESCE(excluded.raw_observation_days, raw_observation_days),
+			publish = COALESCE(excluded.publish, publish),
+			updated_at = excluded.updated_at
+	`, s.SiteID, s.DeviceID, s.WindowSeconds, s.CompareBucketMinutes, s.CompareAggregate, s.SnapshotDedupe, s.RawObservationDays,
+		publish, time.Now().Format(time.RFC3339Nano))
+	return err
+}
+
+// DeleteDeviceSettings removes the settings of a site (deviceID empty) or
+// device and reports whether there were any.
+func DeleteDeviceSettings(ctx context.Context, db *sql.DB, siteID, deviceID string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM device_settings WHERE site_id = ? AND device_id = ?`, siteID, deviceID)
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected(res) > 0, nil
+}
+
+// ListDeviceSettings returns every stored site and device setting, in site
+// and device order.
+func ListDeviceSettings(ctx context.Context, db *sql.DB) ([]DeviceSettings, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+deviceSettingsColumns+` FROM device_settings ORDER BY site_id, device_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []DeviceSettings
+	for rows.Next() {
+		s, err := scanDeviceSettings(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// describe lists the settings that are set, for logs.
+func (s DeviceSettings) describe() []any {
+	var attrs []any
+	if s.WindowSeconds != nil {
+		attrs = append(attrs, "window_seconds", *s.WindowSeconds)
+	}
+	if s.CompareBucketMinutes != nil {
+		attrs = append(attrs, "compare_bucket_minutes", *s.CompareBucketMinutes)
+	}
+	if s.CompareAggregate != nil {
+		attrs = append(attrs, "compare_aggregate", *s.CompareAggregate)
+	}
+	if s.SnapshotDedupe != nil {
+		attrs = append(attrs, "snapshot_dedupe", *s.SnapshotDedupe)
+	}
+	if s.RawObservationDays != nil {
+		attrs = append(attrs, "raw_observation_days", *s.RawObservationDays)
+	}
+	if s.Publish != nil {
+		attrs = append(attrs, "publish", *s.Publish)
+	}
+	return attrs
+}
@@ -0,0 +1,64 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+
+	"workfield/internal/archive"
+	"workfield/internal/manifest"
+)
+
+// Errors returned by the pipeline wrap one of these, so callers can route on
+// the kind of failure with errors.Is instead of matching message text.
+var (
+	ErrBadArchive       = archive.ErrBadArchive
+	ErrManifestMismatch = manifest.ErrMismatch
+	ErrMappingInvalid   = errors.New("invalid sensor mapping")
+	// ErrDBBusy marks SQLITE_BUSY/SQLITE_LOCKED failures; the archive is left
+	// in incoming and a later run can retry it.
+	ErrDBBusy = errors.New("database busy")
+	// ErrChainBroken is returned by VerifyChain when the hash chain does not
+	// match the stored comparison results.
+	ErrChainBroken = errors.New("chain broken")
+)
+
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// ArchiveError records which archive and which pipeline stage failed.
+type ArchiveError struct {
+	Zip   string
+	Stage string
+	Err   error
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Zip, e.Stage, e.Err)
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+func archiveError(zip, stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ArchiveError{Zip: zip, Stage: stage, Err: classifyDBError(err)}
+}
+
+// classifyDBError tags sqlite busy/locked errors with ErrDBBusy.
+func classifyDBError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case sqliteBusy, sqliteLocked:
+			return fmt.Errorf("%w: %w", ErrDBBusy, err)
+		}
+	}
+	return err
+}
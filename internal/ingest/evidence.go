@@ -0,0 +1,82 @@
+package ingest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultEvidenceLength is how many bytes of a raw_session line are kept as
+// comparison evidence when no length is configured.
+const DefaultEvidenceLength = 200
+
+// redactedText replaces whatever a redaction pattern matched.
+const redactedText = "[redacted]"
+
+// Evidence decides what of a raw_session line is stored: matches of the
+// Redact patterns are replaced first (only the capture groups of a pattern
+// that has any, e.g. `operator=(\S+)`), then the comparison evidence is
+// cut to MaxLength bytes (DefaultEvidenceLength when zero).
+type Evidence struct {
+	MaxLength int
+	Redact    []*regexp.Regexp
+}
+
+// ParseEvidence compiles the redaction patterns.
+func ParseEvidence(maxLength int, patterns []string) (Evidence, error) {
+	if maxLength < 0 {
+		return Evidence{}, fmt.Errorf("evidence length %d must not be negative", maxLength)
+	}
+	evidence := Evidence{MaxLength: maxLength}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return Evidence{}, fmt.Errorf("evidence redaction %q: %w", pattern, err)
+		}
+		evidence.Redact = append(evidence.Redact, re)
+	}
+	return evidence, nil
+}
+
+// redact replaces the matches of every pattern in turn.
+func (e Evidence) redact(line string) string {
+	for _, re := range e.Redact {
+		if re.NumSubexp() == 0 {
+			line = re.ReplaceAllLiteralString(line, redactedText)
+			continue
+		}
+		var b strings.Builder
+		last := 0
+		for _, match := range re.FindAllStringSubmatchIndex(line, -1) {
+			for i := 2; i < len(match); i += 2 {
+				start, end := match[i], match[i+1]
+				if start < last || start == end {
+					continue
+				}
+				b.WriteString(line[last:start])
+				b.WriteString(redactedText)
+				last = end
+			}
+		}
+		b.WriteString(line[last:])
+		line = b.String()
+	}
+	return line
+}
+
+// clip cuts a redacted line to the evidence length without splitting a
+// UTF-8 sequence.
+func (e Evidence) clip(line string) string {
+	max := e.MaxLength
+	if max == 0 {
+		max = DefaultEvidenceLength
+	}
+	if len(line) <= max {
+		return line
+	}
+	for max > 0 && !utf8.RuneStart(line[max]) {
+		max--
+	}
+	return line[:max]
+}
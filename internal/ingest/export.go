@@ -0,0 +1,56 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"strings"
+)
+
+// ExportFilter narrows an export of comparison_results. Empty fields match
+// everything.
+type ExportFilter struct {
+	Filter
+	SensorID string
+	Result   string
+	// Limit stops after that many rows when positive.
+	Limit int
+}
+
+// ExportComparisons writes the comparison rows filter selects to w as JSON
+// lines, in id order, and returns how many it wrote. Rows are read and
+// written one at a time, so an export of millions of rows holds none of
+// them in memory; w is written to as the query runs, and a failure part way
+// leaves the lines before it.
+func ExportComparisons(ctx context.Context, db *sql.DB, filter ExportFilter, w io.Writer) (int, error) {
+	where, args := filter.where()
+	var conds []string
+	for _, c := range []struct{ cond, value string }{
+		{"sensor_id = ?", filter.SensorID},
+		{"result = ?", strings.ToUpper(filter.Result)},
+	} {
+		if c.value != "" {
+			conds = append(conds, c.cond)
+			args = append(args, c.value)
+		}
+	}
+	if len(conds) > 0 {
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += strings.Join(conds, " AND ")
+	}
+	query := `SELECT ` + comparisonColumns + ` FROM comparison_results` + where + ` ORDER BY id`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	return encodeComparisons(rows, w)
+}
@@ -0,0 +1,124 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"workfield/internal/archive"
+	"workfield/internal/archivename"
+	"workfield/internal/s3"
+)
+
+// ErrArchiveGone means an archive's original was deleted by the done
+// retention policy without a copy, so it cannot be fetched.
+var ErrArchiveGone = errors.New("archive was deleted by done retention")
+
+// ErrArchivePurged means an archive was purged along with its rows and
+// retention copy, so it cannot be fetched.
+var ErrArchivePurged = errors.New("archive was purged")
+
+// DoneArchive is an ingested archive and where its original is kept: in
+// the done directory, or where done retention copied it.
+type DoneArchive struct {
+	Name     string `json:"name"`
+	SiteID   string `json:"site_id"`
+	DeviceID string `json:"device_id"`
+	Date     string `json:"date,omitempty"`
+	// Location is the file in the done directory, the retention copy (a
+	// path or s3:// url) or empty when the original is gone.
+	Location string `json:"location,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	// PurgedAt is set when purge removed the archive.
+	PurgedAt string `json:"purged_at,omitempty"`
+}
+
+// FindDoneArchives lists the ingested archives of a site, optionally of
+// one device and of one day (YYYY-MM-DD or the name's own form), by name.
+// Purged archives are listed without a location.
+// Days are read from the names with names.
+func FindDoneArchives(ctx context.Context, db *sql.DB, doneDir string, names *archivename.Template, siteID, deviceID, day string) ([]DoneArchive, error) {
+	query := `
+		SELECT l.ingest_file, l.site_id, l.device_id, COALESCE(l.retention_action, ''), COALESCE(l.retention_location, ''), COALESCE(l.purged_at, ''),
+			COALESCE(l.archive_sha256, (
+				SELECT archive_sha256 FROM ingest_ledger g
+				WHERE g.ingest_file = l.ingest_file AND g.status = ?
+				ORDER BY g.finished_at DESC LIMIT 1
+			), '')
+		FROM ingest_log l
+		WHERE l.site_id = ? AND l.id = (SELECT MAX(id) FROM ingest_log WHERE ingest_file = l.ingest_file)`
+	args := []any{ledgerOK, siteID}
+	if deviceID != "" {
+		query += ` AND l.device_id = ?`
+		args = append(args, deviceID)
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY l.ingest_file`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	day = strings.ReplaceAll(day, "-", "")
+	var found []DoneArchive
+	for rows.Next() {
+		var a DoneArchive
+		var action string
+		if err := rows.Scan(&a.Name, &a.SiteID, &a.DeviceID, &action, &a.Location, &a.PurgedAt, &a.SHA256); err != nil {
+			return nil, err
+		}
+		a.Date, _ = parseZipDate(names, archive.TrimExt(a.Name))
+		if day != "" && !strings.HasPrefix(strings.ReplaceAll(a.Date, "-", ""), day) {
+			continue
+		}
+		switch {
+		case a.PurgedAt != "":
+			a.Location = ""
+		case action == "":
+			a.Location = filepath.Join(doneDir, a.Name)
+		}
+		found = append(found, a)
+	}
+	return found, rows.Err()
+}
+
+// FetchArchive copies the original of a to w and checks it against the
+// SHA-256 recorded when it was ingested or retired.
+func FetchArchive(ctx context.Context, a DoneArchive, w io.Writer) error {
+	if a.PurgedAt != "" {
+		return fmt.Errorf("%s: %w at %s", a.Name, ErrArchivePurged, a.PurgedAt)
+	}
+	if a.Location == "" {
+		return fmt.Errorf("%s: %w", a.Name, ErrArchiveGone)
+	}
+	hash := sha256.New()
+	w = io.MultiWriter(w, hash)
+	if strings.HasPrefix(a.Location, "s3://") {
+		dir, name := a.Location[:strings.LastIndex(a.Location, "/")], a.Location[strings.LastIndex(a.Location, "/")+1:]
+		client, err := s3.FromURL(dir)
+		if err != nil {
+			return err
+		}
+		if err := client.Get(ctx, name, w); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(a.Location)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); a.SHA256 != "" && got != a.SHA256 {
+		return fmt.Errorf("%s: sha256 %s does not match the recorded %s", a.Name, got, a.SHA256)
+	}
+	return nil
+}
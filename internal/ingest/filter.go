@@ -0,0 +1,51 @@
+package ingest
+
+import "strings"
+
+// Filter narrows a report to one site, device or work field and to a day
+// range; empty fields match everything. From and To are inclusive
+// YYYY-MM-DD days compared with the date part of publish_at.
+type Filter struct {
+	SiteID    string
+	DeviceID  string
+	WorkField string
+	From      string
+	To        string
+}
+
+// where returns the filter as " WHERE ..." (or "") over the site_id,
+// device_id, work_field and publish_at columns, and its arguments.
+func (f Filter) where() (string, []any) {
+	return f.whereOn("publish_at", true)
+}
+
+// deviceWhere is where for device-wide tables without a work field, whose
+// day is the date part of column.
+func (f Filter) deviceWhere(column string) (string, []any) {
+	return f.whereOn(column, false)
+}
+
+func (f Filter) whereOn(column string, workField bool) (string, []any) {
+	var conds []string
+	var args []any
+	fieldValue := ""
+	if workField {
+		fieldValue = f.WorkField
+	}
+	for _, c := range []struct{ cond, value string }{
+		{"site_id = ?", f.SiteID},
+		{"device_id = ?", f.DeviceID},
+		{"work_field = ?", fieldValue},
+		{"substr(" + column + ", 1, 10) >= ?", f.From},
+		{"substr(" + column + ", 1, 10) <= ?", f.To},
+	} {
+		if c.value != "" {
+			conds = append(conds, c.cond)
+			args = append(args, c.value)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,855 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"workfield/internal/alarm"
+	"workfield/internal/archive"
+	"workfield/internal/archivename"
+	"workfield/internal/decoder"
+	"workfield/internal/health"
+	"workfield/internal/logging"
+	"workfield/internal/manifest"
+	"workfield/internal/publish"
+	"workfield/internal/record"
+	"workfield/internal/timeparse"
+	"workfield/internal/tracing"
+)
+
+type SensorPayload struct {
+	PublishAt string           `json:"PublishAt"`
+	Time      string           `json:"time"`
+	WorkField string           `json:"work_field"`
+	Cmd       string           `json:"cmd"`
+	Data      []SensorDataItem `json:"data"`
+}
+
+type SensorDataItem struct {
+	ID       int             `json:"id"`
+	Value    json.RawMessage `json:"value"`
+	Ping     json.RawMessage `json:"ping"`
+	Position json.RawMessage `json:"position"`
+	Type     string          `json:"type"`
+}
+
+// Options controls how archives are ingested. WorkDir and DoneDir must exist.
+// ArchiveTimeout, when positive, bounds the time spent on a single archive.
+// Stats, when set, collects per-stage timings and counts. Timestamps parses
+// raw log lines and snapshot PublishAt values; nil means timeparse.Default.
+// Decoders, when set, decode raw payloads of the configured sensor types.
+type Options struct {
+	WorkDir        string
+	DoneDir        string
+	Window         time.Duration
+	HashChain      bool
+	ArchiveTimeout time.Duration
+	Stats          *Stats
+	Timestamps     *timeparse.Parser
+	Decoders       *decoder.Set
+	PayloadCodec   PayloadCodec
+	// ReceiptsDir, when set, receives a receipt for every archive that
+	// finished, successfully or not.
+	ReceiptsDir string
+	// HourLayout is the layout of the events.jsonl hour field
+	// (timeparse.HourLayout when empty). Hours are stored normalized.
+	HourLayout string
+	// Summary, when set, collects the end-of-run totals.
+	Summary *Summary
+	// PayloadParsers reads snapshot payloads by the payload version in the
+	// archive's meta.json. Version 1 needs no parser.
+	PayloadParsers map[int]PayloadParser
+	// ReadOnly leaves the archive where it is and writes no receipt and
+	// publishes nothing; only db is written, which the read-only worker
+	// points at a scratch database.
+	ReadOnly bool
+	// SettingsDB, when set, is where the site and device settings are read
+	// instead of db, so a read-only run on a scratch database still
+	// applies the ones stored in the configured database.
+	SettingsDB *sql.DB
+	// Stream reads events.jsonl, sensor_data.jsonl and meta.json straight
+	// from the archive, checking them against the manifest as they are
+	// read, instead of extracting the whole archive to WorkDir first.
+	// raw_session is still extracted, but only when the mapping has an
+	// enabled sensor or AnalyzeRaw or RawObservations is set. A tar
+	// archive is still unpacked, to a temporary directory.
+	Stream bool
+	// AnalyzeRaw runs the analyzer over each archive's raw_session for the
+	// archive's date and stores the result in sensor_health_daily.
+	AnalyzeRaw bool
+	// Evidence redacts raw_session lines before any of them is stored and
+	// sets the length of comparison evidence.
+	Evidence Evidence
+	// RawObservations stores the parsed raw_session lines of mapped sensors
+	// in raw_observations; PruneRawObservations bounds the table.
+	RawObservations bool
+	// CompareBucket, when positive, compares per sensor once per window of
+	// that size instead of once per snapshot, using the CompareAggregate
+	// of the sent and raw values in the window (AggregateLast when empty).
+	CompareBucket    time.Duration
+	CompareAggregate CompareAggregate
+	// Progress, when set, follows the run for progress reports.
+	Progress *Progress
+	// SensorMetrics, when set, keeps per-sensor result counts of the
+	// latest archive that compared each sensor.
+	SensorMetrics *SensorMetrics
+	// RunMetrics, when set, counts the archives, rows and results of the
+	// run for the Prometheus endpoint.
+	RunMetrics *RunMetrics
+	// SnapshotDedupe decides what happens to snapshots an earlier archive
+	// already stored (DedupeSkip when empty).
+	SnapshotDedupe DedupePolicy
+	// Order is the order ProcessDir takes the waiting archives in
+	// (OrderName when empty); PrioritySites lists the sites OrderPriority
+	// takes first.
+	Order         Order
+	PrioritySites []string
+	// NameTemplate is the template archive names are read with (the
+	// default site_device_date when nil).
+	NameTemplate *archivename.Template
+	// NameOverride is the site and device of archives whose file name does
+	// not parse and that have no name sidecar.
+	NameOverride ArchiveName
+	// Duplicates decides what happens to an archive the ingest ledger
+	// shows was already ingested with the same name and SHA-256
+	// (DuplicateSkip when empty).
+	Duplicates DuplicatePolicy
+	// QuarantineDir, when set, receives archives that can never be
+	// ingested as they are, such as an unparseable name, with a failed
+	// receipt giving the reason. Without it they stay in incoming.
+	QuarantineDir string
+	// MaxAttempts, when positive, also quarantines an archive once that
+	// many runs failed it, counted in ingest_attempts. A locked database
+	// or a shutdown is not the archive's fault and does not count.
+	MaxAttempts int
+	// Publisher, when set, receives the comparison rows each archive added,
+	// or one summary per archive with PublishSummaries, after the archive
+	// was committed.
+	Publisher        publish.Publisher
+	PublishSummaries bool
+	// Concurrency is how many archives ProcessFiles ingests at once (one
+	// when not above 1). Each archive has its own work tree; the database
+	// writes still queue on its single connection, and the hash chain and
+	// publisher are used by one archive at a time.
+	Concurrency int
+	// WorkFields, when set, flags snapshots of a site or device under a
+	// work_field it is not configured for.
+	WorkFields WorkFields
+	// Allowlist, when set, quarantines archives of sites and devices it
+	// does not list, at the name stage.
+	Allowlist Allowlist
+	// InsertBatch is the number of events and snapshots inserted per
+	// statement (DefaultInsertBatch when not positive). The batches still
+	// commit together with the rest of the archive.
+	InsertBatch int
+
+	// retryBusy makes ProcessZip leave a database busy failure unreported
+	// because processArchive tries the archive again.
+	retryBusy bool
+	// serial is shared by the archives of a concurrent run.
+	serial *sync.Mutex
+}
+
+func (o Options) timestamps() *timeparse.Parser {
+	if o.Timestamps == nil {
+		return timeparse.Default()
+	}
+	return o.Timestamps
+}
+
+// ProcessDir ingests every archive waiting in dir, in opts.Order. A failing
+// archive does not stop the run; its error is returned alongside the others.
+// Cancelling ctx stops after the current archive and returns ctx.Err().
+func ProcessDir(ctx context.Context, dir string, db *sql.DB, mapping map[string]SensorMapping, opts Options) ([]error, error) {
+	zips, err := ListZipFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	zips = SortZipFiles(zips, opts.Order, opts.PrioritySites, opts.NameTemplate)
+	ctx, span := tracing.Start(ctx, "ingest.process_dir", tracing.String("dir", dir), tracing.Int("archives", len(zips)))
+	defer span.End()
+	return ProcessFiles(ctx, zips, db, mapping, opts)
+}
+
+// ProcessFiles ingests the named archives in order, like ProcessDir.
+func ProcessFiles(ctx context.Context, zips []string, db *sql.DB, mapping map[string]SensorMapping, opts Options) ([]error, error) {
+	var failures []error
+	opts.Progress.start(len(zips))
+	defer opts.Progress.finish()
+	if opts.Concurrency > 1 {
+		return processConcurrently(ctx, zips, db, mapping, opts)
+	}
+	for i, zipPath := range zips {
+		if err := ctx.Err(); err != nil {
+			opts.Summary.skip(len(zips) - i)
+			return failures, err
+		}
+		opts.Progress.archive(filepath.Base(zipPath))
+		err := processArchive(ctx, zipPath, db, mapping, opts)
+		if err != nil {
+			failures = append(failures, err)
+		}
+		opts.Progress.archiveDone(err)
+	}
+	return failures, ctx.Err()
+}
+
+func processWithTimeout(ctx context.Context, zipPath string, db *sql.DB, mapping map[string]SensorMapping, opts Options) error {
+	if opts.ArchiveTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.ArchiveTimeout)
+		defer cancel()
+	}
+	return ProcessZip(ctx, zipPath, db, mapping, opts)
+}
+
+func ListZipFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var zips []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if _, _, ok := archive.SplitExt(name); !ok {
+			continue
+		}
+		zips = append(zips, filepath.Join(dir, name))
+	}
+	sort.Strings(zips)
+	return zips, nil
+}
+
+// ProcessZip ingests one archive and moves it to DoneDir. Errors are
+// *ArchiveError values naming the failed stage; each stage is also a child
+// span of the archive's trace span.
+func ProcessZip(ctx context.Context, zipPath string, db *sql.DB, mapping map[string]SensorMapping, opts Options) (err error) {
+	zipName := filepath.Base(zipPath)
+	ctx, span := tracing.Start(ctx, "ingest.archive", tracing.String("archive", zipName))
+	ctx = logging.With(ctx, "ingest_file", zipName)
+	run := archiveRun{ctx: ctx, zip: zipName, stats: opts.Stats, progress: opts.Progress, counts: map[string]StageCount{}}
+	start := time.Now()
+	var auditID int64
+	var tallies map[string]SensorTally
+	// tx holds everything the archive stores; it is committed only once
+	// the archive is in done, so a failure at any stage leaves no rows and
+	// the retry starts clean.
+	var tx *sql.Tx
+	// ledger is set once the archive is hashed; duplicate marks one the
+	// ledger shows was already ingested, which is skipped.
+	var ledger ledgerEntry
+	var duplicate bool
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+		if run.claim != "" {
+			os.Remove(run.claim)
+		}
+		span.RecordError(err)
+		span.End()
+		if opts.retryBusy && errors.Is(err, ErrDBBusy) {
+			return
+		}
+		r := newReceipt(zipName, run.counts, auditID, time.Since(start), err)
+		r.Duplicate = duplicate
+		if !opts.ReadOnly {
+			r.Attempts = countAttempt(ctx, db, zipName, err)
+			if err != nil && ledger.sha256 != "" {
+				if err := recordLedger(ctx, db, ledger, 0, err); err != nil {
+					logging.From(ctx).Warn("ingest ledger not updated", "error", err)
+				}
+			}
+		}
+		// An archive interrupted by shutdown is still in incoming and
+		// nothing was decided about it, so it gets no receipt.
+		if opts.ReceiptsDir != "" && !opts.ReadOnly && !errors.Is(err, context.Canceled) {
+			writeReceipt(opts.ReceiptsDir, r)
+		}
+		giveUp := opts.MaxAttempts > 0 && r.Attempts >= opts.MaxAttempts
+		if opts.QuarantineDir != "" && !opts.ReadOnly && (errors.Is(err, ErrArchiveName) || errors.Is(err, ErrNotAllowed) || giveUp) {
+			if quarantineArchive(zipPath, opts.QuarantineDir, r) && r.Attempts > 0 {
+				forgetAttempts(db, zipName)
+			}
+		}
+		if duplicate && err == nil {
+			opts.Summary.duplicate()
+		} else {
+			opts.Summary.record(run.counts, err)
+		}
+		opts.RunMetrics.observe(run.counts, tallies, time.Since(start), duplicate, err)
+	}()
+
+	// The name is resolved first: an archive that belongs to no site, or to
+	// one the allowlist does not permit, is quarantined without being
+	// extracted.
+	var name ArchiveName
+	if err := run.stage("name", func(ctx context.Context) (StageCount, error) {
+		var err error
+		name, err = archiveName(zipPath, opts.NameTemplate, opts.NameOverride)
+		if err != nil {
+			return StageCount{}, err
+		}
+		return StageCount{}, opts.Allowlist.check(name)
+	}); err != nil {
+		return err
+	}
+	siteID, deviceID := name.SiteID, name.DeviceID
+	span.SetAttributes(tracing.String("site_id", siteID), tracing.String("device_id", deviceID))
+	ctx = logging.With(ctx, "site_id", siteID, "device_id", deviceID)
+	run.ctx = ctx
+
+	// An archive dropped into incoming again is found in the ledger by
+	// name and content before anything is extracted.
+	if err := run.stage("ledger", func(ctx context.Context) (StageCount, error) {
+		sum, err := fileSHA256(zipPath)
+		if err != nil {
+			return StageCount{}, err
+		}
+		ledger = ledgerEntry{file: zipName, sha256: sum, siteID: siteID, deviceID: deviceID}
+		earlier, at, ok, err := ingestedBefore(ctx, db, ledger)
+		if err != nil {
+			return StageCount{}, err
+		}
+		if !ok {
+			warnRenamedCopy(ctx, db, ledger)
+			return StageCount{}, nil
+		}
+		if opts.Duplicates == DuplicateWarn {
+			logging.From(ctx).Warn("archive already ingested; ingesting it again", "ingested_at", at, "audit_id", earlier)
+			return StageCount{}, nil
+		}
+		duplicate, auditID = true, earlier
+		return StageCount{}, nil
+	}); err != nil {
+		return err
+	}
+	if duplicate {
+		return run.stage("move", func(ctx context.Context) (StageCount, error) {
+			logging.From(ctx).Info("archive already ingested; skipped", "audit_id", auditID)
+			if opts.ReadOnly {
+				return StageCount{}, nil
+			}
+			if err := os.Rename(zipPath, filepath.Join(opts.DoneDir, zipName)); err != nil {
+				return StageCount{}, err
+			}
+			moveSidecar(zipPath, opts.DoneDir)
+			return StageCount{}, countDuplicate(ctx, db, ledger)
+		})
+	}
+
+	// Settings stored for the site or device replace the worker's options
+	// for this archive.
+	if err := run.stage("settings", func(ctx context.Context) (StageCount, error) {
+		settingsDB := db
+		if opts.SettingsDB != nil {
+			settingsDB = opts.SettingsDB
+		}
+		settings, err := deviceSettings(ctx, settingsDB, siteID, deviceID)
+		if err != nil {
+			return StageCount{}, err
+		}
+		if attrs := settings.describe(); len(attrs) > 0 {
+			logging.From(ctx).Debug("device settings applied", attrs...)
+		}
+		opts, err = settings.apply(opts)
+		return StageCount{}, err
+	}); err != nil {
+		return err
+	}
+
+	zipBase := archive.TrimExt(zipName)
+	workPath := filepath.Join(opts.WorkDir, zipBase)
+	if err := run.stage("prepare", func(ctx context.Context) (StageCount, error) {
+		if err := writeClaim(workPath+ClaimSuffix, zipName); err != nil {
+			return StageCount{}, err
+		}
+		run.claim = workPath + ClaimSuffix
+		if err := os.RemoveAll(workPath); err != nil {
+			return StageCount{}, err
+		}
+		if err := os.MkdirAll(workPath, 0o755); err != nil {
+			return StageCount{}, err
+		}
+		return StageCount{}, ctx.Err()
+	}); err != nil {
+		return err
+	}
+
+	// A streamed archive is read in place; only its raw_session is written
+	// to the work tree, and only when something reads it.
+	var files archiveFiles = dirFiles(workPath)
+	var stream *streamFiles
+	defer func() {
+		if stream != nil {
+			stream.Close()
+		}
+	}()
+	if err := run.stage("extract", func(ctx context.Context) (StageCount, error) {
+		if !opts.Stream {
+			return StageCount{}, archive.Extract(zipPath, workPath, archive.DefaultLimits)
+		}
+		var err error
+		if stream, err = openStream(zipPath); err != nil {
+			return StageCount{}, err
+		}
+		files = stream
+		return StageCount{}, stream.extractRaw(workPath, needsRawSession(mapping, opts))
+	}); err != nil {
+		return err
+	}
+
+	// The payload version is checked with the manifest so an archive no
+	// parser can read is rejected before anything is stored.
+	var schema payloadSchema
+	if err := run.stage("manifest", func(ctx context.Context) (StageCount, error) {
+		if stream == nil {
+			if err := verifyManifest(filepath.Join(workPath, manifest.FileName), workPath); err != nil {
+				return StageCount{}, err
+			}
+		}
+		var err error
+		if schema, err = opts.payloadSchema(files); err != nil {
+			return StageCount{}, err
+		}
+		if stream != nil {
+			// events.jsonl and sensor_data.jsonl are verified as they are
+			// ingested; a mismatch rolls the archive back.
+			return StageCount{}, stream.verifyPending("events.jsonl", "sensor_data.jsonl")
+		}
+		return StageCount{}, nil
+	}); err != nil {
+		return err
+	}
+
+	ingestFile := zipName
+	if err := run.stage("events", func(ctx context.Context) (StageCount, error) {
+		var err error
+		if tx, err = db.BeginTx(ctx, nil); err != nil {
+			return StageCount{}, err
+		}
+		events, err := files.open("events.jsonl")
+		if err != nil {
+			return StageCount{}, err
+		}
+		defer events.Close()
+		return ingestEvents(ctx, tx, events, siteID, deviceID, ingestFile, opts)
+	}); err != nil {
+		return err
+	}
+
+	var snapshots []record.SensorDataRecord
+	if err := run.stage("snapshots", func(ctx context.Context) (count StageCount, err error) {
+		src, err := files.open("sensor_data.jsonl")
+		if err != nil {
+			return count, err
+		}
+		defer src.Close()
+		snapshots, count, err = ingestSnapshots(ctx, tx, src, siteID, deviceID, ingestFile, opts, schema)
+		return count, err
+	}); err != nil {
+		return err
+	}
+
+	if err := run.stage("aggregates", func(ctx context.Context) (StageCount, error) {
+		events, err := files.open("events.jsonl")
+		if err != nil {
+			return StageCount{}, err
+		}
+		defer events.Close()
+		return crossCheckAggregates(ctx, tx, events, snapshots, mapping, siteID, deviceID, ingestFile, opts)
+	}); err != nil {
+		return err
+	}
+
+	var rawObservations map[string][]RawObservation
+	if err := run.stage("raw_session", func(ctx context.Context) (count StageCount, err error) {
+		rawObservations, count, err = loadRawObservations(ctx, filepath.Join(workPath, "raw_session"), mapping, opts.timestamps(), opts.Decoders, opts.Evidence)
+		return count, err
+	}); err != nil {
+		return err
+	}
+	span.SetAttributes(tracing.Int("snapshots", len(snapshots)), tracing.Int("raw_sensors", len(rawObservations)))
+
+	if err := run.stage("raw_store", func(ctx context.Context) (StageCount, error) {
+		if !opts.RawObservations {
+			return StageCount{}, nil
+		}
+		return storeRawObservations(ctx, tx, rawObservations, opts.Evidence, siteID, deviceID, ingestFile)
+	}); err != nil {
+		return err
+	}
+
+	var inserted *[]comparisonRow
+	if opts.Publisher != nil && !opts.PublishSummaries {
+		inserted = &[]comparisonRow{}
+	}
+	if err := run.stage("compare", func(ctx context.Context) (count StageCount, err error) {
+		// The chain links each row to the one before, so archives take
+		// turns appending to it.
+		compare := func() {
+			tallies, count, err = compareArchive(ctx, tx, snapshots, rawObservations, mapping, inserted, opts, ingestFile, siteID, deviceID)
+		}
+		if opts.HashChain {
+			opts.serialize(compare)
+		} else {
+			compare()
+		}
+		return count, err
+	}); err != nil {
+		return err
+	}
+
+	if err := run.stage("ping_stats", func(ctx context.Context) (StageCount, error) {
+		return updatePingStats(ctx, tx, siteID, deviceID, ingestFile)
+	}); err != nil {
+		return err
+	}
+
+	if err := run.stage("analyze", func(ctx context.Context) (StageCount, error) {
+		if !opts.AnalyzeRaw {
+			return StageCount{}, nil
+		}
+		date, ok := parseZipDate(opts.NameTemplate, zipBase)
+		if !ok {
+			logging.From(ctx).Debug("raw session not analyzed: archive name has no date")
+			return StageCount{}, nil
+		}
+		return analyzeRawSession(ctx, tx, filepath.Join(workPath, "raw_session"), siteID, deviceID, date, ingestFile, mapping, opts)
+	}); err != nil {
+		return err
+	}
+
+	if err := run.stage("move", func(ctx context.Context) (StageCount, error) {
+		if stream != nil {
+			if err := stream.verifyPending(); err != nil {
+				return StageCount{}, err
+			}
+		}
+		var err error
+		if auditID, err = logIngest(ctx, tx, siteID, deviceID, ingestFile, run.counts); err != nil {
+			return StageCount{}, err
+		}
+		if err := recordLedger(ctx, tx, ledger, auditID, nil); err != nil {
+			return StageCount{}, err
+		}
+		if opts.ReadOnly {
+			return StageCount{}, tx.Commit()
+		}
+		done := filepath.Join(opts.DoneDir, zipName)
+		if err := os.Rename(zipPath, done); err != nil {
+			return StageCount{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			// Nothing was stored, so the archive goes back for a retry.
+			if moveErr := os.Rename(done, zipPath); moveErr != nil {
+				logging.From(ctx).Error("archive not moved back to incoming", "error", moveErr)
+			}
+			return StageCount{}, err
+		}
+		moveSidecar(zipPath, opts.DoneDir)
+		writeReceipt(opts.DoneDir, newReceipt(zipName, run.counts, auditID, time.Since(start), nil))
+		return StageCount{}, nil
+	}); err != nil {
+		return err
+	}
+	logging.From(ctx).Info("archive ingested", "snapshots", len(snapshots), "rejected", rowCounts(run.counts)["rejected"])
+	opts.SensorMetrics.observe(tallies, time.Now())
+	if opts.ReadOnly {
+		return nil
+	}
+	opts.serialize(func() {
+		publishArchive(ctx, opts, archiveSummary{
+			Archive:    zipName,
+			SiteID:     siteID,
+			DeviceID:   deviceID,
+			AuditID:    auditID,
+			Rows:       rowCounts(run.counts),
+			Mismatches: run.counts["compare"].Mismatches,
+		}, inserted)
+	})
+	return nil
+}
+
+// compareArchive compares the archive's snapshots with its raw
+// observations and stores the rows, chained when opts.HashChain is set.
+func compareArchive(ctx context.Context, db dbConn, snapshots []record.SensorDataRecord, rawObservations map[string][]RawObservation, mapping map[string]SensorMapping, inserted *[]comparisonRow, opts Options, ingestFile, siteID, deviceID string) (map[string]SensorTally, StageCount, error) {
+	var chain *comparisonChain
+	if opts.HashChain {
+		var err error
+		if chain, err = openComparisonChain(ctx, db); err != nil {
+			return nil, StageCount{}, err
+		}
+		defer chain.Close()
+	}
+	w, err := newComparisonWriter(ctx, db, chain, inserted)
+	if err != nil {
+		return nil, StageCount{}, err
+	}
+	defer w.Close()
+	if opts.CompareBucket > 0 {
+		err = compareBuckets(ctx, w, snapshots, rawObservations, mapping, opts.CompareBucket, opts.CompareAggregate, opts.timestamps(), ingestFile, siteID, deviceID)
+	} else {
+		w.alignment = newAlignmentReport(opts.Window)
+		err = compareSnapshots(ctx, w, snapshots, rawObservations, mapping, opts.Window, opts.timestamps(), ingestFile, siteID, deviceID)
+		if err == nil {
+			err = storeAlignment(ctx, db, w.alignment.summaries(siteID, deviceID, ingestFile), siteID, deviceID, ingestFile)
+		}
+	}
+	return w.tallies, w.count, err
+}
+
+// archiveRun runs the stages of one archive, each in its own span, and
+// records their timings in stats when set.
+type archiveRun struct {
+	ctx      context.Context
+	zip      string
+	stats    *Stats
+	progress *Progress
+	counts   map[string]StageCount
+	claim    string
+}
+
+func (r archiveRun) stage(name string, fn func(context.Context) (StageCount, error)) error {
+	ctx, span := tracing.Start(r.ctx, "ingest."+name)
+	defer span.End()
+	touchClaim(r.claim)
+	r.progress.stage(name)
+	start := time.Now()
+	count, err := fn(ctx)
+	elapsed := time.Since(start)
+	span.SetAttributes(tracing.Int64("lines", count.Lines), tracing.Int64("rows", count.Rows))
+	span.RecordError(err)
+	if err == nil {
+		r.stats.add(name, elapsed, count)
+		r.counts[name] = count
+	}
+	logging.From(ctx).Debug("stage finished", "stage", name, "duration", elapsed, "lines", count.Lines, "rows", count.Rows, "error", err)
+	return archiveError(r.zip, name, err)
+}
+
+func verifyManifest(manifestPath, workPath string) error {
+	m, err := manifest.Read(manifestPath)
+	if err != nil {
+		return err
+	}
+	return manifest.Verify(m, workPath)
+}
+
+// ingestEvents stores events.jsonl. Lines typed as device health samples go
+// to device_health and controller events to controller_events; all other
+// lines are hourly metrics whose hour must parse with opts.HourLayout. Lines
+// that fail these checks go to rejected_lines.
+func ingestEvents(ctx context.Context, db dbConn, src io.Reader, siteID, deviceID, ingestFile string, opts Options) (StageCount, error) {
+	var count StageCount
+	metrics := newInsertBatch(db, `
+		INSERT OR IGNORE INTO hourly_metrics
+		(site_id, device_id, work_field, hour, payload_json, payload_codec, ingest_file, ingested_at)
+		VALUES`, 8, opts.InsertBatch)
+	defer metrics.Close()
+	samples := newInsertBatch(db, `
+		INSERT OR IGNORE INTO device_health
+		(site_id, device_id, sampled_at, disk_used_pct, disk_free_bytes, load1, uptime_seconds, ntp_offset_ms,
+			payload_json, payload_codec, ingest_file, ingested_at)
+		VALUES`, 12, opts.InsertBatch)
+	defer samples.Close()
+	alarms := newInsertBatch(db, `
+		INSERT OR IGNORE INTO controller_events
+		(site_id, device_id, occurred_at, kind, code, message, source, payload_json, payload_codec, ingest_file, ingested_at)
+		VALUES`, 11, opts.InsertBatch)
+	defer alarms.Close()
+
+	rejected, err := newRejectedLines(ctx, db, siteID, deviceID, ingestFile, "events.jsonl")
+	if err != nil {
+		return count, err
+	}
+	defer rejected.Close()
+	reject := func(reason, line string) error {
+		count.Rejected++
+		return rejected.add(ctx, count.Lines, reason, line)
+	}
+
+	times := opts.timestamps()
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		count.Lines++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var payload map[string]any
+		if err := json.Unmarshal([]byte(line), &payload); err != nil {
+			if err := reject("invalid json: "+err.Error(), line); err != nil {
+				return count, err
+			}
+			continue
+		}
+		stored, storedCodec, err := encodePayload(opts.PayloadCodec, []byte(line))
+		if err != nil {
+			return count, err
+		}
+		ingestedAt := time.Now().Format(time.RFC3339Nano)
+		if payload["type"] == health.EventType {
+			var sample health.Sample
+			if err := json.Unmarshal([]byte(line), &sample); err != nil || sample.SampledAt.IsZero() {
+				if err := reject("invalid device_health sample", line); err != nil {
+					return count, err
+				}
+				continue
+			}
+			err = samples.add(ctx, siteID, deviceID, sample.SampledAt.UTC().Format(time.RFC3339Nano),
+				sample.DiskUsedPct, sample.DiskFreeBytes, sample.Load1, sample.UptimeSeconds, sample.NTPOffsetMS,
+				stored, storedCodec, ingestFile, ingestedAt)
+		} else if payload["type"] == alarm.EventType {
+			var e alarm.Event
+			if err := json.Unmarshal([]byte(line), &e); err != nil || e.OccurredAt.IsZero() || e.Kind == "" {
+				if err := reject("invalid controller_event", line); err != nil {
+					return count, err
+				}
+				continue
+			}
+			// occurred_at keeps the device's offset so its date is the
+			// device's local day, like the analysis dates.
+			err = alarms.add(ctx, siteID, deviceID, e.OccurredAt.Format(time.RFC3339Nano),
+				e.Kind, e.Code, e.Message, e.Source, stored, storedCodec, ingestFile, ingestedAt)
+		} else {
+			workField, _ := payload["work_field"].(string)
+			rawHour, _ := payload["hour"].(string)
+			hour, hourErr := times.ParseHour(opts.HourLayout, rawHour)
+			if hourErr != nil {
+				if err := reject(hourErr.Error(), line); err != nil {
+					return count, err
+				}
+				continue
+			}
+			err = metrics.add(ctx, siteID, deviceID, workField, hour, stored, storedCodec, ingestFile, ingestedAt)
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+	for _, batch := range []*insertBatch{metrics, samples, alarms} {
+		if err := batch.flush(ctx); err != nil {
+			return count, err
+		}
+		count.Rows += batch.inserted
+	}
+	return count, nil
+}
+
+func rowsAffected(res sql.Result) int64 {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// ingestSnapshots stores sensor_data.jsonl under opts.SnapshotDedupe and
+// returns the snapshots to compare, converted to the base payload schema.
+// A re-sent copy that was not stored because its payload changed is left
+// out so its values do not mix with the stored copy's comparisons.
+// Lines that do not decode as a snapshot go to rejected_lines.
+func ingestSnapshots(ctx context.Context, db dbConn, src io.Reader, siteID, deviceID, ingestFile string, opts Options, schema payloadSchema) ([]record.SensorDataRecord, StageCount, error) {
+	var count StageCount
+	store, err := newSnapshotStore(ctx, db, opts.SnapshotDedupe, opts.InsertBatch)
+	if err != nil {
+		return nil, count, err
+	}
+	defer store.Close()
+	rejected, err := newRejectedLines(ctx, db, siteID, deviceID, ingestFile, "sensor_data.jsonl")
+	if err != nil {
+		return nil, count, err
+	}
+	defer rejected.Close()
+
+	times := opts.timestamps()
+	workFields := newWorkFieldCheck(opts.WorkFields, siteID, deviceID)
+	var snapshots []record.SensorDataRecord
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		count.Lines++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		snapshot, err := record.Decode([]byte(line))
+		if err != nil {
+			count.Rejected++
+			if err := rejected.add(ctx, count.Lines, "invalid snapshot: "+err.Error(), line); err != nil {
+				return nil, count, err
+			}
+			continue
+		}
+		stored, storedCodec, err := encodePayload(opts.PayloadCodec, snapshot.Payload)
+		if err != nil {
+			return nil, count, err
+		}
+		original := snapshot.Payload
+		snapshot.Payload = schema.toBase(snapshot.Payload)
+		if workFields.add(snapshot.WorkField, extractPublishAt(snapshot.Payload)) {
+			count.Flagged++
+		}
+		compare, rows, err := store.add(ctx, storedSnapshot{
+			siteID:     siteID,
+			deviceID:   deviceID,
+			workField:  snapshot.WorkField,
+			publishAt:  extractPublishAt(snapshot.Payload),
+			payload:    original,
+			stored:     stored,
+			codec:      storedCodec,
+			version:    schema.version,
+			ingestFile: ingestFile,
+			compareKey: comparisonKey(times, snapshot),
+		})
+		if err != nil {
+			return nil, count, err
+		}
+		count.Rows += rows
+		if compare {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, count, err
+	}
+	if err := store.flush(ctx); err != nil {
+		return nil, count, err
+	}
+	count.Rows += store.insert.inserted
+	if err := workFields.store(ctx, db, siteID, deviceID, ingestFile); err != nil {
+		return nil, count, err
+	}
+	if store.identical+store.changed > 0 {
+		logging.From(ctx).Info("re-sent snapshots", "policy", store.policy,
+			"identical", store.identical, "changed", store.changed, "superseded", store.superseded)
+	}
+	return snapshots, count, nil
+}
+
+func extractPublishAt(payload json.RawMessage) string {
+	var data SensorPayload
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return ""
+	}
+	if data.PublishAt != "" {
+		return data.PublishAt
+	}
+	return data.Time
+}
@@ -0,0 +1,112 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"workfield/internal/logging"
+)
+
+// DuplicatePolicy decides what happens to an archive the ingest ledger
+// shows was already ingested with the same name and content, as when a
+// file is dropped into incoming again.
+type DuplicatePolicy string
+
+const (
+	// DuplicateSkip moves the archive to done without storing anything and
+	// gives it an ok receipt marked duplicate.
+	DuplicateSkip DuplicatePolicy = "skip"
+	// DuplicateWarn logs a warning and ingests the archive again; the
+	// snapshot dedupe policy decides what happens to its snapshots.
+	DuplicateWarn DuplicatePolicy = "warn"
+)
+
+// ParseDuplicatePolicy accepts "" (skip), skip and warn.
+func ParseDuplicatePolicy(value string) (DuplicatePolicy, error) {
+	switch policy := DuplicatePolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "":
+		return DuplicateSkip, nil
+	case DuplicateSkip, DuplicateWarn:
+		return policy, nil
+	}
+	return DuplicateSkip, fmt.Errorf("unknown duplicate archive policy %q", value)
+}
+
+// Ledger statuses.
+const (
+	ledgerOK     = "ok"
+	ledgerFailed = "failed"
+)
+
+// ledgerEntry is an archive's ingest_ledger key and owner.
+type ledgerEntry struct {
+	file     string
+	sha256   string
+	siteID   string
+	deviceID string
+}
+
+// ingestedBefore returns the ingest_log id and time of an earlier
+// successful ingest of the same name and content, or ok false.
+func ingestedBefore(ctx context.Context, db dbConn, e ledgerEntry) (auditID int64, at string, ok bool, err error) {
+	var id sql.NullInt64
+	err = db.QueryRowContext(ctx, `
+		SELECT audit_id, finished_at FROM ingest_ledger
+		WHERE ingest_file = ? AND archive_sha256 = ? AND status = ?
+	`, e.file, e.sha256, ledgerOK).Scan(&id, &at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", false, nil
+	}
+	return id.Int64, at, err == nil, err
+}
+
+// warnRenamedCopy logs when the same content was ingested under another
+// name; the ledger is keyed by both, so such a copy is ingested again.
+func warnRenamedCopy(ctx context.Context, db dbConn, e ledgerEntry) {
+	var other string
+	err := db.QueryRowContext(ctx, `
+		SELECT ingest_file FROM ingest_ledger
+		WHERE archive_sha256 = ? AND ingest_file <> ? AND status = ?
+		ORDER BY finished_at LIMIT 1
+	`, e.sha256, e.file, ledgerOK).Scan(&other)
+	if err == nil {
+		logging.From(ctx).Warn("archive content already ingested under another name", "earlier", other, "sha256", e.sha256)
+	}
+}
+
+// countDuplicate records that the archive was dropped in again.
+func countDuplicate(ctx context.Context, db dbConn, e ledgerEntry) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE ingest_ledger SET duplicates = duplicates + 1, last_duplicate_at = ?
+		WHERE ingest_file = ? AND archive_sha256 = ?
+	`, time.Now().Format(time.RFC3339Nano), e.file, e.sha256)
+	return err
+}
+
+// recordLedger stores the outcome of the archive. The success row is
+// written in the archive's transaction, so it commits with the rows; a
+// failure is written after the rollback and never replaces an earlier
+// success. A locked database or a shutdown decided nothing and is not
+// recorded.
+func recordLedger(ctx context.Context, db dbConn, e ledgerEntry, auditID int64, err error) error {
+	if errors.Is(err, ErrDBBusy) || errors.Is(err, context.Canceled) {
+		return nil
+	}
+	status, message := ledgerOK, ""
+	if err != nil {
+		status, message = ledgerFailed, err.Error()
+	}
+	now := time.Now().Format(time.RFC3339Nano)
+	_, execErr := db.ExecContext(context.WithoutCancel(ctx), `
+		INSERT INTO ingest_ledger (ingest_file, archive_sha256, site_id, device_id, status, error, audit_id, first_seen_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, 0), ?, ?)
+		ON CONFLICT(ingest_file, archive_sha256) DO UPDATE SET status = excluded.status, error = excluded.error,
+			audit_id = COALESCE(excluded.audit_id, audit_id), finished_at = excluded.finished_at
+		WHERE ingest_ledger.status <> 'ok' OR excluded.status = 'ok'
+	`, e.file, e.sha256, e.siteID, e.deviceID, status, message, auditID, now, now)
+	return execErr
+}
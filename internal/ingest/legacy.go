@@ -0,0 +1,213 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"workfield/internal/archive"
+	"workfield/internal/archivename"
+	"workfield/internal/timeparse"
+)
+
+// Column aliases accepted from the legacy Python CSV exports. Columns that are
+// not listed here are carried over into payload_json.
+var legacyColumnAliases = map[string]string{
+	"site":         "site_id",
+	"site_id":      "site_id",
+	"device":       "device_id",
+	"device_id":    "device_id",
+	"work_field":   "work_field",
+	"field":        "work_field",
+	"hour":         "hour",
+	"publish_at":   "publish_at",
+	"publishat":    "publish_at",
+	"timestamp":    "publish_at",
+	"time":         "publish_at",
+	"payload":      "payload_json",
+	"payload_json": "payload_json",
+}
+
+// ImportLegacyCSV stores the rows of a legacy CSV export as hourly metrics
+// or, with kind "snapshots", sensor data snapshots, and returns how many it
+// inserted and skipped. Hours and publish_at are read with
+// opts.HourLayout and opts.Timestamps and stored in the form ingested rows
+// have; rows whose time does not parse are skipped. Each row is stored
+// under the archive name of its site, device and day (opts.NameTemplate)
+// followed by "_legacy_" and the CSV name, so purge -before selects it
+// like an ingested archive of that day; a template that does not read that
+// name back, such as one with {work_field} for a row without it, fails the
+// import.
+func ImportLegacyCSV(ctx context.Context, db *sql.DB, path, kind, siteID, deviceID string, opts Options) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return 0, 0, err
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if alias, ok := legacyColumnAliases[key]; ok {
+			key = alias
+		}
+		columns[i] = key
+	}
+
+	timeColumn := "hour"
+	query := `
+		INSERT OR IGNORE INTO hourly_metrics
+		(site_id, device_id, work_field, hour, payload_json, ingest_file, ingested_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	if kind == "snapshots" {
+		timeColumn = "publish_at"
+		query = `
+			INSERT OR IGNORE INTO sensor_data_snapshots
+			(site_id, device_id, work_field, publish_at, payload_json, ingest_file, ingested_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`
+	}
+	if !containsString(columns, timeColumn) {
+		return 0, 0, fmt.Errorf("missing %s column", timeColumn)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+
+	times := opts.Timestamps
+	if times == nil {
+		times = timeparse.Default()
+	}
+	legacyName := "_legacy_" + filepath.Base(path)
+	inserted, skipped := 0, 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return inserted, skipped, err
+		}
+		row := map[string]string{"site_id": siteID, "device_id": deviceID}
+		extra := map[string]any{}
+		for i, value := range record {
+			if i >= len(columns) {
+				break
+			}
+			value = strings.TrimSpace(value)
+			if isLegacyColumn(columns[i]) {
+				if value != "" {
+					row[columns[i]] = value
+				}
+				continue
+			}
+			extra[columns[i]] = legacyValue(value)
+		}
+		if row["site_id"] == "" || row["device_id"] == "" || row[timeColumn] == "" {
+			skipped++
+			continue
+		}
+
+		fields := archivename.Fields{Site: row["site_id"], Device: row["device_id"], WorkField: row["work_field"]}
+		if kind == "snapshots" {
+			publishAt, err := times.Parse(row["publish_at"])
+			if err != nil {
+				skipped++
+				continue
+			}
+			row["publish_at"] = publishAt.Format(timeparse.LineLayout)
+			fields.Date, fields.Hour = publishAt.Format(timeparse.DateLayout), publishAt.Format("15")
+		} else {
+			hour, err := times.ParseHour(opts.HourLayout, row["hour"])
+			if err != nil {
+				skipped++
+				continue
+			}
+			row["hour"] = hour
+			fields.Date = strings.ReplaceAll(hour[:len(timeparse.DayLayout)], "-", "")
+			fields.Hour = hour[len(timeparse.DayLayout)+1:]
+		}
+		ingestFile := opts.NameTemplate.Format(fields) + legacyName
+		// purge reads the site, device and date back out of the name.
+		if got, err := parseZipName(opts.NameTemplate, archive.TrimExt(ingestFile)); err != nil || got.Site != fields.Site || got.Device != fields.Device || got.Date != fields.Date {
+			return inserted, skipped, fmt.Errorf("%s: archive name template %s does not give back the site, device and date of %q; purge could not select its rows", path, opts.NameTemplate, ingestFile)
+		}
+
+		payload := row["payload_json"]
+		if payload == "" || !json.Valid([]byte(payload)) {
+			if payload != "" {
+				extra["payload"] = payload
+			}
+			if row["work_field"] != "" {
+				extra["work_field"] = row["work_field"]
+			}
+			if kind == "snapshots" {
+				extra["PublishAt"] = row["publish_at"]
+			} else {
+				extra["hour"] = row["hour"]
+			}
+			data, err := json.Marshal(extra)
+			if err != nil {
+				return inserted, skipped, err
+			}
+			payload = string(data)
+		}
+
+		ingestedAt := time.Now().Format(time.RFC3339Nano)
+		res, err := stmt.ExecContext(ctx, row["site_id"], row["device_id"], row["work_field"], row[timeColumn], payload, ingestFile, ingestedAt)
+		if err != nil {
+			return inserted, skipped, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			skipped++
+			continue
+		}
+		inserted++
+	}
+	return inserted, skipped, tx.Commit()
+}
+
+func isLegacyColumn(name string) bool {
+	canonical, ok := legacyColumnAliases[name]
+	return ok && canonical == name
+}
+
+func legacyValue(value string) any {
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number
+	}
+	return value
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
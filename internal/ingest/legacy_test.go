@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"workfield/internal/archivename"
+)
+
+func TestImportLegacyCSVNormalizesTimesAndCanBePurged(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db, err := OpenDB(filepath.Join(dir, "legacy.db"), DBOptions{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	path := filepath.Join(dir, "export.csv")
+	csv := "hour,work_field,level\n2026-01-20 05:00,field-01,1.5\n2026-01-21 06:00,field-01,2\n2026-01-21 06:30,field-01,3\nyesterday,field-01,4\n"
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inserted, skipped, err := ImportLegacyCSV(ctx, db, path, "hourly", "siteA", "device01", Options{HourLayout: "2006-01-02 15:04"})
+	if err != nil || inserted != 2 || skipped != 2 {
+		t.Fatalf("import: inserted %d, skipped %d, %v", inserted, skipped, err)
+	}
+	rows, err := db.QueryContext(ctx, `SELECT hour, ingest_file FROM hourly_metrics ORDER BY hour`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var hour, file string
+		if err := rows.Scan(&hour, &file); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, hour+" "+file)
+	}
+	want := []string{"2026-01-20T05 siteA_device01_20260120_legacy_export.csv", "2026-01-21T06 siteA_device01_20260121_legacy_export.csv"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("stored %q\nwant %q", got, want)
+	}
+
+	files, err := PurgeCandidates(ctx, db, filepath.Join(dir, "done"), "siteA", "device01", "20260121", nil)
+	if err != nil || !reflect.DeepEqual(files, []string{"siteA_device01_20260120_legacy_export.csv"}) {
+		t.Fatalf("purge candidates: %q, %v", files, err)
+	}
+}
+
+func TestImportLegacyCSVNamesWorkFieldForPurge(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db, err := OpenDB(filepath.Join(dir, "legacy.db"), DBOptions{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	names, err := archivename.Parse("{site}_{device}_{work_field}_{date}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{HourLayout: "2006-01-02 15:04", NameTemplate: names}
+	path := filepath.Join(dir, "export.csv")
+	csv := "hour,work_field,level\n2026-01-20 05:00,field-01,1.5\n2026-01-21 06:00,field-01,2\n"
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if inserted, _, err := ImportLegacyCSV(ctx, db, path, "hourly", "siteA", "device01", opts); err != nil || inserted != 2 {
+		t.Fatalf("import: inserted %d, %v", inserted, err)
+	}
+
+	files, err := PurgeCandidates(ctx, db, filepath.Join(dir, "done"), "siteA", "device01", "20260121", names)
+	if err != nil || !reflect.DeepEqual(files, []string{"siteA_device01_field-01_20260120_legacy_export.csv"}) {
+		t.Fatalf("purge candidates: %q, %v", files, err)
+	}
+	if deleted, err := PurgeIngestFiles(ctx, db, "siteA", "device01", "20260121", files); err != nil || deleted != 1 {
+		t.Fatalf("purge: deleted %d, %v", deleted, err)
+	}
+	var left int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM hourly_metrics WHERE hour < '2026-01-21'`).Scan(&left); err != nil || left != 0 {
+		t.Fatalf("%d rows before the cutoff left, %v", left, err)
+	}
+
+	// Without a work_field the name cannot be read back, so the import
+	// fails rather than store rows purge would never select.
+	path = filepath.Join(dir, "nofield.csv")
+	if err := os.WriteFile(path, []byte("hour,level\n2026-01-20 05:00,1.5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ImportLegacyCSV(ctx, db, path, "hourly", "siteA", "device01", opts); err == nil {
+		t.Fatalf("expected rows without a work_field to be rejected under %s", names)
+	}
+}
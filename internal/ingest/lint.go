@@ -0,0 +1,250 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"workfield/internal/archive"
+	"workfield/internal/archivename"
+	"workfield/internal/record"
+)
+
+// Kinds of mapping findings.
+const (
+	// LintNoRaw: no raw_session path matches the raw_paths or contains the
+	// sensor_id, so every comparison for the entry ends up MISSING_RAW.
+	LintNoRaw = "no_raw"
+	// LintNoPayload: the id never appears in a payload data array.
+	LintNoPayload = "no_payload"
+	// LintJSONType: items with the id exist but never with json_type.
+	LintJSONType = "json_type"
+	// LintEmptyField: the mapped field is empty in every matching item.
+	LintEmptyField = "empty_field"
+	// LintAmbiguous: raw paths of this sensor also contain another mapped
+	// sensor_id, and go to the longer one, or match another sensor's
+	// raw_paths, which fails the ingest.
+	LintAmbiguous = "ambiguous_raw"
+	// LintUnmapped: the payload carries an id the mapping does not list.
+	LintUnmapped = "unmapped"
+)
+
+// MappingFinding is one problem LintMapping found. ID is the mapping key,
+// or the payload id for LintUnmapped.
+type MappingFinding struct {
+	ID       string
+	SensorID string
+	Kind     string
+	Message  string
+}
+
+// LintMapping checks mapping against the contents of one archive without
+// ingesting it: are the sensor ids present in raw_session paths, do the ids
+// occur in payload data arrays, and are the json_type values and fields ever
+// observed. Payloads are read with parsers by the archive's meta.json
+// version, as the pipeline does. Findings are sorted by id.
+func LintMapping(zipPath string, mapping map[string]SensorMapping, parsers map[int]PayloadParser) ([]MappingFinding, error) {
+	reader, err := archive.OpenReader(zipPath, archive.DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var rawPaths []string
+	for _, name := range reader.Names() {
+		if rel, ok := strings.CutPrefix(name, "raw_session/"); ok {
+			rawPaths = append(rawPaths, rel)
+		}
+	}
+	matcher, err := newSensorMatcher(mapping)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := lintSchema(reader, parsers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", zipPath, err)
+	}
+	seen, err := scanPayloadItems(reader, schema)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", zipPath, err)
+	}
+
+	var findings []MappingFinding
+	add := func(id, sensorID, kind, format string, args ...any) {
+		findings = append(findings, MappingFinding{ID: id, SensorID: sensorID, Kind: kind, Message: fmt.Sprintf(format, args...)})
+	}
+	for id, entry := range mapping {
+		matched, others := 0, map[string]bool{}
+		for _, rawPath := range rawPaths {
+			explicit := matcher.explicitSensors(rawPath)
+			if len(entry.RawPaths) > 0 {
+				if !containsString(explicit, entry.SensorID) {
+					continue
+				}
+				matched++
+				for _, other := range explicit {
+					if other != entry.SensorID {
+						others[other] = true
+					}
+				}
+				continue
+			}
+			if len(explicit) > 0 {
+				continue
+			}
+			candidates := matcher.implicitCandidates(rawPath)
+			mine := false
+			for _, candidate := range candidates {
+				mine = mine || candidate.SensorID == entry.SensorID
+			}
+			if !mine {
+				continue
+			}
+			matched++
+			for _, other := range candidates {
+				if other.SensorID != entry.SensorID {
+					others[other.SensorID] = true
+				}
+			}
+		}
+		if matched == 0 {
+			add(id, entry.SensorID, LintNoRaw, "no raw_session path is attributed to %s", entry.SensorID)
+		}
+		if len(others) > 0 {
+			add(id, entry.SensorID, LintAmbiguous, "raw_session paths of %s also match %s", entry.SensorID, strings.Join(sortedKeys(others), ", "))
+		}
+
+		items := seen[id]
+		switch {
+		case items == nil:
+			add(id, entry.SensorID, LintNoPayload, "id %s never appears in payload data", id)
+		case entry.JSONType != "" && items.types[strings.ToLower(entry.JSONType)] == 0:
+			add(id, entry.SensorID, LintJSONType, "json_type %q never observed for id %s (seen: %s)", entry.JSONType, id, orNone(sortedKeys(items.types)))
+		default:
+			field := entry.Field
+			if field == "" {
+				field = "value"
+			}
+			if items.fields[field] == 0 {
+				add(id, entry.SensorID, LintEmptyField, "field %q is empty in all %d items with id %s", field, items.count, id)
+			}
+		}
+	}
+	for id := range seen {
+		if _, ok := mapping[id]; !ok {
+			add(id, "", LintUnmapped, "payload id %s is not in the mapping", id)
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		a, _ := strconv.Atoi(findings[i].ID)
+		b, _ := strconv.Atoi(findings[j].ID)
+		if a != b {
+			return a < b
+		}
+		return findings[i].Kind < findings[j].Kind
+	})
+	return findings, nil
+}
+
+// payloadItems counts what was observed for one payload id.
+type payloadItems struct {
+	count  int
+	types  map[string]int
+	fields map[string]int
+}
+
+// lintSchema picks the payload parser from the archive's meta.json.
+func lintSchema(reader *archive.Reader, parsers map[int]PayloadParser) (payloadSchema, error) {
+	meta := ArchiveMeta{Version: BasePayloadVersion}
+	if file, err := reader.Open(MetaFileName); err == nil {
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return payloadSchema{}, err
+		}
+		if meta, err = decodeArchiveMeta(data); err != nil {
+			return payloadSchema{}, err
+		}
+	}
+	return selectSchema(meta, parsers)
+}
+
+func scanPayloadItems(reader *archive.Reader, schema payloadSchema) (map[string]*payloadItems, error) {
+	file, err := reader.Open("sensor_data.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	seen := map[string]*payloadItems{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		snapshot, err := record.Decode([]byte(line))
+		if err != nil {
+			continue
+		}
+		var payload SensorPayload
+		if err := json.Unmarshal(schema.toBase(snapshot.Payload), &payload); err != nil {
+			continue
+		}
+		for _, item := range payload.Data {
+			id := strconv.Itoa(item.ID)
+			items := seen[id]
+			if items == nil {
+				items = &payloadItems{types: map[string]int{}, fields: map[string]int{}}
+				seen[id] = items
+			}
+			items.count++
+			if item.Type != "" {
+				items.types[strings.ToLower(item.Type)]++
+			}
+			for field, raw := range map[string]json.RawMessage{"value": item.Value, "ping": item.Ping, "position": item.Position} {
+				if len(raw) > 0 && string(raw) != "null" {
+					items.fields[field]++
+				}
+			}
+		}
+	}
+	return seen, scanner.Err()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+// LatestZip returns the most recently named archive in dir, by the package
+// date its name carries under names, or "" when there is none.
+func LatestZip(dir string, names *archivename.Template) (string, error) {
+	zips, err := ListZipFiles(dir)
+	if err != nil || len(zips) == 0 {
+		return "", err
+	}
+	sort.SliceStable(zips, func(i, j int) bool {
+		a, _ := parseZipDate(names, zipBase(zips[i]))
+		b, _ := parseZipDate(names, zipBase(zips[j]))
+		return a < b
+	})
+	return zips[len(zips)-1], nil
+}
@@ -0,0 +1,205 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"workfield/internal/analyzer"
+)
+
+type SensorMapping struct {
+	SensorID  string     `json:"sensor_id"`
+	Type      string     `json:"type"`
+	Field     string     `json:"field"`
+	JSONType  string     `json:"json_type"`
+	Tolerance float64    `json:"tolerance"`
+	RawDecode *RawDecode `json:"raw_decode"`
+	// Enabled false keeps an informational entry in the mapping without
+	// comparing it; omitted means enabled.
+	Enabled *bool `json:"enabled"`
+	// SampleRate, between 0 and 1, compares only that fraction of the
+	// snapshots; omitted or 0 compares every snapshot. The choice is a
+	// hash of the sensor and publish time, so re-ingesting an archive
+	// samples the same snapshots.
+	SampleRate float64 `json:"sample_rate"`
+	// NumberFormat, NumberPoint or NumberComma, reads values written with
+	// that decimal separator and grouped digits as numbers before they are
+	// compared; omitted compares them as text.
+	NumberFormat string `json:"number_format"`
+	// RawPaths selects the sensor's raw_session files explicitly instead
+	// of by the sensor_id in their path: globs matched against the path
+	// under raw_session (e.g. "GATE1/*.log"), or regular expressions with
+	// a "re:" prefix.
+	RawPaths []string `json:"raw_paths"`
+	// ArrayLength, when set, is the number of elements the values of an
+	// array sensor must have. Numeric arrays are compared element by
+	// element within Tolerance either way.
+	ArrayLength int `json:"array_length"`
+}
+
+// IsEnabled reports whether the entry produces comparison results.
+func (m SensorMapping) IsEnabled() bool {
+	return m.Enabled == nil || *m.Enabled
+}
+
+// expectedSensors lists the sensor IDs of the enabled mapping entries once
+// each, sorted.
+func expectedSensors(mapping map[string]SensorMapping) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, entry := range mapping {
+		if entry.IsEnabled() && !seen[entry.SensorID] {
+			seen[entry.SensorID] = true
+			ids = append(ids, entry.SensorID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// sortedMappingIDs returns the mapping ids in order, so comparisons are
+// made, numbered and published the same way on every run.
+func sortedMappingIDs(mapping map[string]SensorMapping) []string {
+	ids := make([]string, 0, len(mapping))
+	for id := range mapping {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// sampled reports whether the snapshot published at publishAt is one the
+// entry's SampleRate compares.
+func (m SensorMapping) sampled(publishAt time.Time) bool {
+	if m.SampleRate <= 0 || m.SampleRate >= 1 {
+		return true
+	}
+	sum := sha256.Sum256([]byte(m.SensorID + "\x1f" + publishAt.UTC().Format(time.RFC3339Nano)))
+	return float64(binary.BigEndian.Uint64(sum[:])>>11)/(1<<53) < m.SampleRate
+}
+
+// RawDecode reads a number out of a raw byte payload before it is compared
+// with the decimal value the device sent, e.g. the WLS level
+// {"format": "hex-csv", "offset": 4, "length": 2}. Format is an analyzer
+// payload format (auto when empty); Length bytes starting at Offset form an
+// unsigned big-endian integer unless LittleEndian or Signed say otherwise,
+// and the result is multiplied by Scale when set.
+type RawDecode struct {
+	Format       string  `json:"format"`
+	Offset       int     `json:"offset"`
+	Length       int     `json:"length"`
+	LittleEndian bool    `json:"little_endian"`
+	Signed       bool    `json:"signed"`
+	Scale        float64 `json:"scale"`
+}
+
+// Decode returns the number encoded in payload.
+func (d RawDecode) Decode(payload string) (float64, error) {
+	format, err := analyzer.ParsePayloadFormat(d.Format)
+	if err != nil {
+		return 0, err
+	}
+	bytes, err := analyzer.DecodeBytes(payload, format)
+	if err != nil {
+		return 0, err
+	}
+	length := d.length()
+	if d.Offset+length > len(bytes) {
+		return 0, fmt.Errorf("payload has %d bytes, need %d", len(bytes), d.Offset+length)
+	}
+	field := bytes[d.Offset : d.Offset+length]
+	var value uint64
+	for i := range field {
+		b := field[i]
+		if d.LittleEndian {
+			b = field[length-1-i]
+		}
+		value = value<<8 | uint64(b)
+	}
+	number := float64(value)
+	if d.Signed {
+		shift := 64 - 8*length
+		number = float64(int64(value<<shift) >> shift)
+	}
+	if d.Scale != 0 {
+		number *= d.Scale
+	}
+	return number, nil
+}
+
+func (d RawDecode) length() int {
+	if d.Length == 0 {
+		return 1
+	}
+	return d.Length
+}
+
+func (d RawDecode) validate() error {
+	if _, err := analyzer.ParsePayloadFormat(d.Format); err != nil {
+		return err
+	}
+	if d.Offset < 0 {
+		return errors.New("raw_decode offset must not be negative")
+	}
+	if d.Length < 0 || d.Length > 8 {
+		return errors.New("raw_decode length must be 1 to 8 bytes")
+	}
+	return nil
+}
+
+// LoadMapping reads mapping.json (sensor_data id → sensor). Entries with a
+// non-numeric id or without sensor_id are skipped with a warning; other
+// malformed files and entries wrap ErrMappingInvalid.
+func LoadMapping(path string) (map[string]SensorMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mapping := map[string]SensorMapping{}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrMappingInvalid, path, err)
+	}
+	for id, entry := range mapping {
+		// Entries that can never match a sensor_data id are skipped, as
+		// they always were, so an old mapping with notes in it still loads.
+		if _, err := strconv.Atoi(id); err != nil {
+			slog.Warn("mapping entry skipped: id is not a number", "path", path, "id", id)
+			delete(mapping, id)
+			continue
+		}
+		if entry.SensorID == "" {
+			slog.Warn("mapping entry skipped: no sensor_id", "path", path, "id", id)
+			delete(mapping, id)
+			continue
+		}
+		if entry.Tolerance < 0 {
+			return nil, fmt.Errorf("%w: %s: id %s has negative tolerance", ErrMappingInvalid, path, id)
+		}
+		if entry.ArrayLength < 0 {
+			return nil, fmt.Errorf("%w: %s: id %s has negative array_length", ErrMappingInvalid, path, id)
+		}
+		if entry.SampleRate < 0 || entry.SampleRate > 1 {
+			return nil, fmt.Errorf("%w: %s: id %s sample_rate must be between 0 and 1", ErrMappingInvalid, path, id)
+		}
+		if err := checkNumberFormat(entry.NumberFormat); err != nil {
+			return nil, fmt.Errorf("%w: %s: id %s: %w", ErrMappingInvalid, path, id, err)
+		}
+		if entry.RawDecode != nil {
+			if err := entry.RawDecode.validate(); err != nil {
+				return nil, fmt.Errorf("%w: %s: id %s: %w", ErrMappingInvalid, path, id, err)
+			}
+		}
+	}
+	if err := checkRawPaths(mapping); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrMappingInvalid, path, err)
+	}
+	return mapping, nil
+}
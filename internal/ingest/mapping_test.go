@@ -0,0 +1,67 @@
+package ingest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMappingSkipsUnusableEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	content := `{"_comment": {"sensor_id": "notes"}, "wls": {"sensor_id": "WLS1"}, "1": {"type": "WLS"}, "2": {"sensor_id": "WLS2"}}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	mapping, err := LoadMapping(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(mapping) != 1 || mapping["2"].SensorID != "WLS2" {
+		t.Fatalf("mapping = %+v, want only id 2", mapping)
+	}
+}
+
+func TestLoadMappingRejectsInvalidEntries(t *testing.T) {
+	for _, content := range []string{
+		`{"1": {"sensor_id": "WLS1"`,
+		`{"1": {"sensor_id": "WLS1", "tolerance": -1}}`,
+		`{"1": {"sensor_id": "WLS1", "raw_decode": {"format": "octal"}}}`,
+		`{"1": {"sensor_id": "WLS1", "raw_decode": {"length": 9}}}`,
+		`{"1": {"sensor_id": "WLS1", "sample_rate": 1.5}}`,
+		`{"1": {"sensor_id": "WLS1", "number_format": "de_DE"}}`,
+		`{"1": {"sensor_id": "WLS1", "array_length": -3}}`,
+		`{"1": {"sensor_id": "WLS1", "raw_paths": ["wls1/[a-"]}}`,
+		`{"1": {"sensor_id": "WLS1", "raw_paths": ["re:wls1("]}}`,
+		`{"1": {"sensor_id": "WLS1", "raw_paths": ["levels/*"]}, "2": {"sensor_id": "WLS2", "raw_paths": ["levels/*"]}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "mapping.json")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := LoadMapping(path); !errors.Is(err, ErrMappingInvalid) {
+			t.Fatalf("%s: expected ErrMappingInvalid, got %v", content, err)
+		}
+	}
+}
+
+func TestRawDecode(t *testing.T) {
+	for _, tc := range []struct {
+		decode  RawDecode
+		payload string
+		want    float64
+	}{
+		{RawDecode{Format: "hex-csv", Offset: 4, Length: 2}, "FA 00 00 00 00 3C 00 00 00 00 76", 60},
+		{RawDecode{Format: "hex-csv", Offset: 0, Length: 2, LittleEndian: true}, "(2C, 01)", 300},
+		{RawDecode{Format: "hexstring", Length: 2, Signed: true}, "FF38", -200},
+		{RawDecode{Format: "dec-csv", Offset: 1, Scale: 0.5}, "1,51", 25.5},
+	} {
+		got, err := tc.decode.Decode(tc.payload)
+		if err != nil || got != tc.want {
+			t.Fatalf("%+v %q: got %v, %v; want %v", tc.decode, tc.payload, got, err, tc.want)
+		}
+	}
+	if _, err := (RawDecode{Format: "hex-csv", Offset: 4, Length: 2}).Decode("FA 00"); err == nil {
+		t.Fatal("expected a short payload to fail")
+	}
+}
@@ -0,0 +1,108 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Number formats of a mapping entry's number_format: which character
+// separates the decimals. Either way digits may be grouped by three with
+// spaces or apostrophes, and by the other of "," and "." too.
+const (
+	NumberPoint = "point" // 1,234.5
+	NumberComma = "comma" // 1.234,5
+)
+
+func checkNumberFormat(format string) error {
+	switch format {
+	case "", NumberPoint, NumberComma:
+		return nil
+	}
+	return fmt.Errorf("number_format %q must be %q or %q", format, NumberPoint, NumberComma)
+}
+
+// normalizeNumber rewrites value as formatNumber does when it is a number
+// written in the entry's number format, so "1.234,50" and the sent 1234.5
+// compare equal. Anything else, and every value of an entry without a
+// number format, is returned unchanged. It is for text only: raw log
+// values and JSON strings.
+func (m SensorMapping) normalizeNumber(value string) string {
+	if m.NumberFormat == "" {
+		return value
+	}
+	if number, ok := parseLocaleNumber(value, m.NumberFormat); ok {
+		return formatNumber(number)
+	}
+	return value
+}
+
+// normalizeJSON is normalizeValue with the entry's number format applied
+// to JSON strings only: a JSON number the device sent is already written
+// with a decimal point, and reading 1.234 in the comma format would make it
+// 1234.
+func (m SensorMapping) normalizeJSON(raw json.RawMessage) string {
+	value := normalizeValue(raw)
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '"' {
+		return m.normalizeNumber(value)
+	}
+	return value
+}
+
+// parseLocaleNumber parses text as a decimal number in format. Group
+// separators are only accepted between groups of three digits, so "1,5"
+// is not read as fifteen in the point format.
+func parseLocaleNumber(text, format string) (float64, bool) {
+	decimal, group := '.', ','
+	if format == NumberComma {
+		decimal, group = ',', '.'
+	}
+	text = strings.TrimSpace(text)
+	sign := ""
+	if strings.HasPrefix(text, "-") || strings.HasPrefix(text, "+") {
+		sign, text = text[:1], text[1:]
+	}
+	whole, fraction, hasFraction := strings.Cut(text, string(decimal))
+	if hasFraction && (fraction == "" || !allDigits(fraction)) {
+		return 0, false
+	}
+	groups := strings.FieldsFunc(whole, func(r rune) bool {
+		return r == group || r == ' ' || r == '\u00a0' || r == '\u202f' || r == '\''
+	})
+	if len(groups) == 0 || strings.Join(groups, "") == "" {
+		return 0, false
+	}
+	// Two separators in a row leave an empty field FieldsFunc drops, so
+	// the group count is checked against the separators seen.
+	separators := 0
+	for _, r := range whole {
+		if r < '0' || r > '9' {
+			separators++
+		}
+	}
+	if separators != len(groups)-1 {
+		return 0, false
+	}
+	for i, g := range groups {
+		if !allDigits(g) || (i > 0 && len(g) != 3) || (i == 0 && len(groups) > 1 && len(g) > 3) {
+			return 0, false
+		}
+	}
+	number := sign + strings.Join(groups, "")
+	if hasFraction {
+		number += "." + fraction
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	return value, err == nil
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,47 @@
+package ingest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNumberFormatLeavesSentNumbersAlone(t *testing.T) {
+	entry := SensorMapping{SensorID: "WLS1", Field: "value", NumberFormat: NumberComma}
+	payload := SensorPayloadContext{Data: []SensorDataItem{{ID: 1, Value: json.RawMessage(`1.234`)}}}
+	sent, ok := findSentValue(payload, "1", entry)
+	if !ok || sent != "1.234" {
+		t.Fatalf("sent 1.234: got %q", sent)
+	}
+	raw, _ := rawObservationValue(entry, RawObservation{Value: "1,234"})
+	if raw != sent {
+		t.Fatalf("raw 1,234 in the comma format: got %q, want %q", raw, sent)
+	}
+
+	// A number the device sent as a string is text in the entry's format.
+	payload.Data[0].Value = json.RawMessage(`"1.234,5"`)
+	if sent, _ := findSentValue(payload, "1", entry); sent != "1234.5" {
+		t.Fatalf(`sent "1.234,5": got %q`, sent)
+	}
+}
+
+func TestParseLocaleNumber(t *testing.T) {
+	for _, tc := range []struct {
+		text, format string
+		want         float64
+		ok           bool
+	}{
+		{"1.234,5", NumberComma, 1234.5, true},
+		{"1 234,5", NumberComma, 1234.5, true},
+		{"-1,234.5", NumberPoint, -1234.5, true},
+		{"1'234.5", NumberPoint, 1234.5, true},
+		{"12.34,5", NumberComma, 0, false},
+		{"1,5", NumberPoint, 0, false},
+		{"1..234", NumberComma, 0, false},
+		{"open", NumberPoint, 0, false},
+	} {
+		got, ok := parseLocaleNumber(tc.text, tc.format)
+		if ok != tc.ok || (ok && got != tc.want) {
+			t.Errorf("%q in %s: got %v, %v", tc.text, tc.format, got, ok)
+		}
+	}
+}
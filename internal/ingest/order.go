@@ -0,0 +1,128 @@
+package ingest
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"workfield/internal/archive"
+	"workfield/internal/archivename"
+)
+
+// Order decides the order ProcessDir works through the waiting archives.
+// Name order lets one site with a large backlog hold up every site sorted
+// after it; the other orders spread the work.
+type Order string
+
+const (
+	// OrderName processes archives by file name (site, device, date).
+	OrderName Order = "name"
+	// OrderOldest processes archives by the date in their name, oldest
+	// first, whatever the site. Archives without a date go last.
+	OrderOldest Order = "oldest"
+	// OrderRoundRobin takes one archive per site in turn, each site's
+	// oldest first.
+	OrderRoundRobin Order = "round-robin"
+	// OrderPriority processes the sites in Options.PrioritySites first, in
+	// that order, then the remaining sites round-robin.
+	OrderPriority Order = "priority"
+)
+
+// ParseOrder accepts "" (name), name, oldest, round-robin and priority.
+func ParseOrder(value string) (Order, error) {
+	switch order := Order(strings.ToLower(strings.TrimSpace(value))); order {
+	case "":
+		return OrderName, nil
+	case OrderName, OrderOldest, OrderRoundRobin, OrderPriority:
+		return order, nil
+	}
+	return OrderName, fmt.Errorf("unknown ingest order %q", value)
+}
+
+// SortZipFiles reorders zips, as returned by ListZipFiles, by order, reading
+// their dates and sites with names. prioritySites is only used by
+// OrderPriority.
+func SortZipFiles(zips []string, order Order, prioritySites []string, names *archivename.Template) []string {
+	sorted := append([]string(nil), zips...)
+	sort.SliceStable(sorted, func(i, j int) bool { return olderZip(names, sorted[i], sorted[j]) })
+	switch order {
+	case OrderOldest:
+		return sorted
+	case OrderRoundRobin:
+		return roundRobin(names, sorted)
+	case OrderPriority:
+		rank := map[string]int{}
+		for i, site := range prioritySites {
+			if _, ok := rank[site]; !ok {
+				rank[site] = i
+			}
+		}
+		var first, rest []string
+		for _, zip := range sorted {
+			if _, ok := rank[zipSite(names, zip)]; ok {
+				first = append(first, zip)
+			} else {
+				rest = append(rest, zip)
+			}
+		}
+		sort.SliceStable(first, func(i, j int) bool { return rank[zipSite(names, first[i])] < rank[zipSite(names, first[j])] })
+		return append(first, roundRobin(names, rest)...)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// roundRobin interleaves zips, already sorted oldest first, one per site
+// per turn with sites in name order.
+func roundRobin(names *archivename.Template, zips []string) []string {
+	bySite := map[string][]string{}
+	var sites []string
+	for _, zip := range zips {
+		site := zipSite(names, zip)
+		if _, ok := bySite[site]; !ok {
+			sites = append(sites, site)
+		}
+		bySite[site] = append(bySite[site], zip)
+	}
+	sort.Strings(sites)
+	out := make([]string, 0, len(zips))
+	for len(out) < len(zips) {
+		for _, site := range sites {
+			if queue := bySite[site]; len(queue) > 0 {
+				out = append(out, queue[0])
+				bySite[site] = queue[1:]
+			}
+		}
+	}
+	return out
+}
+
+// olderZip orders by the date and hour in the archive name, then by name;
+// archives without a date sort after dated ones.
+func olderZip(names *archivename.Template, a, b string) bool {
+	fieldsA, errA := names.Match(zipBase(a))
+	fieldsB, errB := names.Match(zipBase(b))
+	okA, okB := errA == nil && fieldsA.Date != "", errB == nil && fieldsB.Date != ""
+	if okA != okB {
+		return okA
+	}
+	if fieldsA.Date+fieldsA.Hour != fieldsB.Date+fieldsB.Hour {
+		return fieldsA.Date+fieldsA.Hour < fieldsB.Date+fieldsB.Hour
+	}
+	return filepath.Base(a) < filepath.Base(b)
+}
+
+func zipBase(zipPath string) string {
+	return archive.TrimExt(filepath.Base(zipPath))
+}
+
+// zipSite is the site id of an archive, or its whole name when the name
+// does not parse, so such archives still get a turn of their own.
+func zipSite(names *archivename.Template, zipPath string) string {
+	fields, err := parseZipName(names, zipBase(zipPath))
+	if err != nil {
+		return zipBase(zipPath)
+	}
+	return fields.Site
+}
@@ -0,0 +1,53 @@
+package ingest
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSortZipFilesSpreadsSites(t *testing.T) {
+	zips := []string{
+		"in/siteA_dev1_20260103.zip",
+		"in/siteA_dev1_20260101.zip",
+		"in/siteA_dev2_20260102.zip",
+		"in/siteB_dev1_20260105.zip",
+		"in/siteB_dev1_20260104.zip",
+		"in/siteC_dev1_20260101.zip",
+		"in/siteC_dev1.zip",
+	}
+	cases := []struct {
+		order    Order
+		priority []string
+		want     []string
+	}{
+		{OrderName, nil, []string{
+			"siteA_dev1_20260101", "siteA_dev1_20260103", "siteA_dev2_20260102",
+			"siteB_dev1_20260104", "siteB_dev1_20260105", "siteC_dev1", "siteC_dev1_20260101",
+		}},
+		{OrderOldest, nil, []string{
+			"siteA_dev1_20260101", "siteC_dev1_20260101", "siteA_dev2_20260102", "siteA_dev1_20260103",
+			"siteB_dev1_20260104", "siteB_dev1_20260105", "siteC_dev1",
+		}},
+		{OrderRoundRobin, nil, []string{
+			"siteA_dev1_20260101", "siteB_dev1_20260104", "siteC_dev1_20260101",
+			"siteA_dev2_20260102", "siteB_dev1_20260105", "siteC_dev1", "siteA_dev1_20260103",
+		}},
+		{OrderPriority, []string{"siteC", "siteB"}, []string{
+			"siteC_dev1_20260101", "siteC_dev1", "siteB_dev1_20260104", "siteB_dev1_20260105",
+			"siteA_dev1_20260101", "siteA_dev2_20260102", "siteA_dev1_20260103",
+		}},
+	}
+	for _, tc := range cases {
+		var got []string
+		for _, zip := range SortZipFiles(zips, tc.order, tc.priority, nil) {
+			got = append(got, strings.TrimSuffix(filepath.Base(zip), ".zip"))
+		}
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("%s: got %v, want %v", tc.order, got, tc.want)
+		}
+	}
+	if _, err := ParseOrder("newest"); err == nil {
+		t.Fatalf("expected an unknown order to be rejected")
+	}
+}
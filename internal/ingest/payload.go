@@ -0,0 +1,178 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// MetaFileName is the optional archive member describing the payload schema
+// of the device that wrote the archive, e.g. {"version": 2, "firmware":
+// "2.1.0"}. Archives without one are version 1.
+const MetaFileName = "meta.json"
+
+// BasePayloadVersion is the schema SensorPayload describes.
+const BasePayloadVersion = 1
+
+// ErrPayloadVersion means the archive's meta.json names a payload version
+// the worker has no parser for; the archive is rejected untouched.
+var ErrPayloadVersion = errors.New("unsupported payload version")
+
+type ArchiveMeta struct {
+	Version  int    `json:"version"`
+	Firmware string `json:"firmware,omitempty"`
+}
+
+func readArchiveMeta(files archiveFiles) (ArchiveMeta, error) {
+	file, err := files.open(MetaFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ArchiveMeta{Version: BasePayloadVersion}, nil
+	}
+	if err != nil {
+		return ArchiveMeta{}, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return ArchiveMeta{}, err
+	}
+	return decodeArchiveMeta(data)
+}
+
+func decodeArchiveMeta(data []byte) (ArchiveMeta, error) {
+	var meta ArchiveMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return ArchiveMeta{}, fmt.Errorf("%w: %s: %w", ErrBadArchive, MetaFileName, err)
+	}
+	if meta.Version == 0 {
+		meta.Version = BasePayloadVersion
+	}
+	return meta, nil
+}
+
+// PayloadParser reads one snapshot payload of a given schema version into
+// the base schema.
+type PayloadParser func(raw json.RawMessage) (SensorPayload, error)
+
+// payloadKeys and itemKeys are the base schema names AliasParser can rename.
+var (
+	payloadKeys = []string{"PublishAt", "time", "work_field", "cmd", "data"}
+	itemKeys    = []string{"id", "value", "ping", "position", "type"}
+)
+
+// AliasParser builds a parser for firmware whose payload only renames keys.
+// payload and item map base schema names to the names the firmware uses,
+// for the top-level object and for each element of data; keys that are not
+// listed keep their base name.
+func AliasParser(payload, item map[string]string) (PayloadParser, error) {
+	if err := checkAliases(payload, payloadKeys); err != nil {
+		return nil, err
+	}
+	if err := checkAliases(item, itemKeys); err != nil {
+		return nil, err
+	}
+	dataKey := aliasOf(payload, "data")
+	return func(raw json.RawMessage) (SensorPayload, error) {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return SensorPayload{}, err
+		}
+		base := renameKeys(doc, payload, payloadKeys)
+		if data, ok := doc[dataKey]; ok {
+			var items []map[string]json.RawMessage
+			if err := json.Unmarshal(data, &items); err != nil {
+				return SensorPayload{}, fmt.Errorf("%s: %w", dataKey, err)
+			}
+			baseItems := make([]map[string]json.RawMessage, len(items))
+			for i, element := range items {
+				baseItems[i] = renameKeys(element, item, itemKeys)
+			}
+			encoded, err := json.Marshal(baseItems)
+			if err != nil {
+				return SensorPayload{}, err
+			}
+			base["data"] = encoded
+		}
+		encoded, err := json.Marshal(base)
+		if err != nil {
+			return SensorPayload{}, err
+		}
+		var parsed SensorPayload
+		err = json.Unmarshal(encoded, &parsed)
+		return parsed, err
+	}, nil
+}
+
+func checkAliases(aliases map[string]string, known []string) error {
+	for name, alias := range aliases {
+		if !containsString(known, name) {
+			return fmt.Errorf("unknown payload key %q (expected one of %v)", name, known)
+		}
+		if alias == "" {
+			return fmt.Errorf("payload key %q has an empty alias", name)
+		}
+	}
+	return nil
+}
+
+func aliasOf(aliases map[string]string, name string) string {
+	if alias, ok := aliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+func renameKeys(doc map[string]json.RawMessage, aliases map[string]string, known []string) map[string]json.RawMessage {
+	base := make(map[string]json.RawMessage, len(known))
+	for _, name := range known {
+		if value, ok := doc[aliasOf(aliases, name)]; ok {
+			base[name] = value
+		}
+	}
+	return base
+}
+
+// payloadSchema is the parser chosen for one archive. Base version payloads
+// are passed through untouched.
+type payloadSchema struct {
+	version int
+	parse   PayloadParser
+}
+
+func (o Options) payloadSchema(files archiveFiles) (payloadSchema, error) {
+	meta, err := readArchiveMeta(files)
+	if err != nil {
+		return payloadSchema{}, err
+	}
+	return selectSchema(meta, o.PayloadParsers)
+}
+
+func selectSchema(meta ArchiveMeta, parsers map[int]PayloadParser) (payloadSchema, error) {
+	if parser, ok := parsers[meta.Version]; ok {
+		return payloadSchema{version: meta.Version, parse: parser}, nil
+	}
+	if meta.Version == BasePayloadVersion {
+		return payloadSchema{version: meta.Version}, nil
+	}
+	return payloadSchema{}, fmt.Errorf("%w: %d (firmware %q)", ErrPayloadVersion, meta.Version, meta.Firmware)
+}
+
+// toBase returns the payload in the base schema. Payloads the version's
+// parser cannot read are returned unchanged and later skipped like any other
+// malformed payload.
+func (s payloadSchema) toBase(raw json.RawMessage) json.RawMessage {
+	if s.parse == nil {
+		return raw
+	}
+	parsed, err := s.parse(raw)
+	if err != nil {
+		return raw
+	}
+	encoded, err := json.Marshal(parsed)
+	if err != nil {
+		return raw
+	}
+	return encoded
+}
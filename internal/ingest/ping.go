@@ -0,0 +1,109 @@
+package ingest
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// updatePingStats recomputes ping_stats for every sensor mapped with field
+// "ping" on the days this archive touched. Pings are latency measurements,
+// so instead of MATCH/MISMATCH counts they are summarized as min/avg/max of
+// the sent values, with MISSING_* results counted as lost. Days are
+// recomputed from all of their comparison rows, so a day split over several
+// archives still gets one correct row.
+func updatePingStats(ctx context.Context, db dbConn, siteID, deviceID, ingestFile string) (StageCount, error) {
+	var count StageCount
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT substr(publish_at, 1, 10) FROM comparison_results
+		WHERE site_id = ? AND device_id = ? AND ingest_file = ? AND field_name = 'ping'
+	`, siteID, deviceID, ingestFile)
+	if err != nil {
+		return count, err
+	}
+	var days []string
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return count, err
+		}
+		days = append(days, day)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	for _, day := range days {
+		stats, lines, err := pingDay(ctx, db, siteID, deviceID, day)
+		if err != nil {
+			return count, err
+		}
+		count.Lines += lines
+		now := time.Now().Format(time.RFC3339Nano)
+		for sensorID, s := range stats {
+			var minRTT, avgRTT, maxRTT any
+			if s.received > 0 {
+				minRTT, avgRTT, maxRTT = s.min, s.sum/float64(s.received), s.max
+			}
+			res, err := db.ExecContext(ctx, `
+				INSERT OR REPLACE INTO ping_stats
+				(site_id, device_id, sensor_id, day, samples, lost, rtt_min, rtt_avg, rtt_max, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, siteID, deviceID, sensorID, day, s.samples, s.lost, minRTT, avgRTT, maxRTT, now)
+			if err != nil {
+				return count, err
+			}
+			count.Rows += rowsAffected(res)
+		}
+	}
+	return count, nil
+}
+
+type pingAccumulator struct {
+	samples, lost, received int64
+	min, max, sum           float64
+}
+
+func pingDay(ctx context.Context, db dbConn, siteID, deviceID, day string) (map[string]*pingAccumulator, int64, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT sensor_id, sent_value, result FROM comparison_results
+		WHERE site_id = ? AND device_id = ? AND field_name = 'ping' AND substr(publish_at, 1, 10) = ?
+	`, siteID, deviceID, day)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	stats := map[string]*pingAccumulator{}
+	var lines int64
+	for rows.Next() {
+		var sensorID, sent, result string
+		if err := rows.Scan(&sensorID, &sent, &result); err != nil {
+			return nil, lines, err
+		}
+		lines++
+		s := stats[sensorID]
+		if s == nil {
+			s = &pingAccumulator{min: math.Inf(1), max: math.Inf(-1)}
+			stats[sensorID] = s
+		}
+		s.samples++
+		if strings.HasPrefix(result, "MISSING") {
+			s.lost++
+			continue
+		}
+		rtt, err := strconv.ParseFloat(sent, 64)
+		if err != nil {
+			continue
+		}
+		s.received++
+		s.sum += rtt
+		s.min = math.Min(s.min, rtt)
+		s.max = math.Max(s.max, rtt)
+	}
+	return stats, lines, rows.Err()
+}
@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// busyRetries is how many more times an archive that failed on a locked
+// database is tried before it is left in incoming for the next run.
+const busyRetries = 2
+
+// busyRetryDelay is the pause before the first retry; it doubles after.
+var busyRetryDelay = time.Second
+
+// processArchive ingests one archive, retrying it when the database stayed
+// locked past busy_timeout. Earlier attempts leave no receipt and are not
+// counted, so only the last one is reported.
+func processArchive(ctx context.Context, zipPath string, db *sql.DB, mapping map[string]SensorMapping, opts Options) error {
+	delay := busyRetryDelay
+	for attempt := 0; ; attempt++ {
+		opts.retryBusy = attempt < busyRetries
+		err := processWithTimeout(ctx, zipPath, db, mapping, opts)
+		if !opts.retryBusy || !errors.Is(err, ErrDBBusy) {
+			return err
+		}
+		slog.Warn("database busy, retrying archive", "ingest_file", filepath.Base(zipPath), "attempt", attempt+1, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// processConcurrently ingests zips with opts.Concurrency workers. Archives
+// of the same site and device never run at the same time and start in the
+// order given, so re-sent snapshots resolve as in a serial run. Failures
+// are returned in the order of zips.
+func processConcurrently(ctx context.Context, zips []string, db *sql.DB, mapping map[string]SensorMapping, opts Options) ([]error, error) {
+	opts.serial = &sync.Mutex{}
+	queue := &archiveQueue{busy: map[string]bool{}}
+	queue.cond = sync.NewCond(&queue.mu)
+	for i, zipPath := range zips {
+		key := zipPath
+		if name, err := archiveName(zipPath, opts.NameTemplate, opts.NameOverride); err == nil {
+			key = name.SiteID + "\x00" + name.DeviceID
+		}
+		queue.pending = append(queue.pending, queuedArchive{index: i, path: zipPath, key: key})
+	}
+
+	errs := make([]error, len(zips))
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Concurrency && w < len(zips); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				next, ok := queue.take(ctx)
+				if !ok {
+					return
+				}
+				opts.Progress.archive(filepath.Base(next.path))
+				err := processArchive(ctx, next.path, db, mapping, opts)
+				errs[next.index] = err
+				opts.Progress.archiveDone(err)
+				queue.done(next)
+			}
+		}()
+	}
+	// Waiting workers only notice cancellation when woken.
+	stop := context.AfterFunc(ctx, queue.wake)
+	defer stop()
+	wg.Wait()
+
+	opts.Summary.skip(len(queue.pending))
+	var failures []error
+	for _, err := range errs {
+		if err != nil {
+			failures = append(failures, err)
+		}
+	}
+	return failures, ctx.Err()
+}
+
+type queuedArchive struct {
+	index     int
+	path, key string
+}
+
+// archiveQueue hands out archives whose site and device is not in flight.
+type archiveQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []queuedArchive
+	busy    map[string]bool
+}
+
+func (q *archiveQueue) take(ctx context.Context) (queuedArchive, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if ctx.Err() != nil || len(q.pending) == 0 {
+			return queuedArchive{}, false
+		}
+		for i, next := range q.pending {
+			if !q.busy[next.key] {
+				q.busy[next.key] = true
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				return next, true
+			}
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *archiveQueue) done(a queuedArchive) {
+	q.mu.Lock()
+	delete(q.busy, a.key)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *archiveQueue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// serialize runs fn alone among the archives of a concurrent run.
+func (o Options) serialize(fn func()) {
+	if o.serial != nil {
+		o.serial.Lock()
+		defer o.serial.Unlock()
+	}
+	fn()
+}
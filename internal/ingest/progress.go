@@ -0,0 +1,107 @@
+package ingest
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress follows a ProcessFiles run so long backlog runs can report where
+// they are. Set Options.Progress to have the pipeline update it; Report is
+// safe to call from another goroutine while the run goes on.
+type Progress struct {
+	mu       sync.Mutex
+	total    int
+	done     int
+	failed   int
+	current  string
+	phase    string
+	started  time.Time
+	finished bool
+}
+
+// ProgressReport is a snapshot of a run. ETA is only set once an archive
+// has finished, from the average time per archive so far.
+type ProgressReport struct {
+	Total    int           `json:"total"`
+	Done     int           `json:"done"`
+	Failed   int           `json:"failed"`
+	Current  string        `json:"current,omitempty"`
+	Phase    string        `json:"phase,omitempty"`
+	Elapsed  time.Duration `json:"elapsed_ns"`
+	ETA      time.Duration `json:"eta_ns,omitempty"`
+	Finished bool          `json:"finished"`
+}
+
+func (p *Progress) start(total int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total, p.done, p.failed = total, 0, 0
+	p.current, p.phase = "", ""
+	p.started = time.Now()
+	p.finished = false
+}
+
+func (p *Progress) archive(name string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current, p.phase = name, ""
+}
+
+func (p *Progress) stage(name string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.phase = name
+}
+
+func (p *Progress) archiveDone(err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if err != nil {
+		p.failed++
+	}
+	p.current, p.phase = "", ""
+}
+
+func (p *Progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished = true
+	p.current, p.phase = "", ""
+}
+
+// Report returns where the run is now.
+func (p *Progress) Report() ProgressReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := ProgressReport{
+		Total:    p.total,
+		Done:     p.done,
+		Failed:   p.failed,
+		Current:  p.current,
+		Phase:    p.phase,
+		Finished: p.finished,
+	}
+	if !p.started.IsZero() {
+		r.Elapsed = time.Since(p.started)
+	}
+	if p.done > 0 && p.done < p.total && !p.finished {
+		r.ETA = r.Elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+	}
+	return r
+}
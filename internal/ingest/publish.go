@@ -0,0 +1,53 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+
+	"workfield/internal/logging"
+	"workfield/internal/publish"
+)
+
+// archiveSummary is the message published per archive when
+// Options.PublishSummaries is set.
+type archiveSummary struct {
+	Archive    string           `json:"archive"`
+	SiteID     string           `json:"site_id"`
+	DeviceID   string           `json:"device_id"`
+	AuditID    int64            `json:"audit_id"`
+	Rows       map[string]int64 `json:"rows"`
+	Mismatches int64            `json:"mismatches"`
+}
+
+// publishArchive sends an ingested archive's comparison rows, or its
+// summary, to opts.Publisher. The archive is already committed, so a
+// failure is logged and the rows stay queryable in the database.
+func publishArchive(ctx context.Context, opts Options, summary archiveSummary, inserted *[]comparisonRow) {
+	if opts.Publisher == nil {
+		return
+	}
+	key := summary.SiteID + "/" + summary.DeviceID
+	var messages []publish.Message
+	if opts.PublishSummaries {
+		value, err := json.Marshal(summary)
+		if err != nil {
+			logging.From(ctx).Warn("results not published", "error", err)
+			return
+		}
+		messages = append(messages, publish.Message{Key: key, Value: value})
+	} else if inserted != nil {
+		for _, row := range *inserted {
+			value, err := json.Marshal(row)
+			if err != nil {
+				logging.From(ctx).Warn("results not published", "error", err)
+				return
+			}
+			messages = append(messages, publish.Message{Key: key, Value: value})
+		}
+	}
+	if err := opts.Publisher.Publish(ctx, messages); err != nil {
+		logging.From(ctx).Warn("results not published", "messages", len(messages), "error", err)
+		return
+	}
+	logging.From(ctx).Debug("results published", "messages", len(messages))
+}
@@ -0,0 +1,130 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"workfield/internal/archive"
+	"workfield/internal/archivename"
+	"workfield/internal/timeparse"
+)
+
+// PurgeCandidates returns the archive names for site/device dated before the
+// cutoff, collected from both the database and the done directory. Names
+// are read with names.
+func PurgeCandidates(ctx context.Context, db *sql.DB, doneDir, siteID, deviceID, before string, names *archivename.Template) ([]string, error) {
+	seen := map[string]struct{}{}
+	rows, err := db.QueryContext(ctx, `
+		SELECT ingest_file FROM hourly_metrics WHERE site_id = ? AND device_id = ?
+		UNION SELECT ingest_file FROM sensor_data_snapshots WHERE site_id = ? AND device_id = ?
+		UNION SELECT ingest_file FROM comparison_results WHERE site_id = ? AND device_id = ?
+	`, siteID, deviceID, siteID, deviceID, siteID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if name.Valid && archiveBefore(names, name.String, before) {
+			seen[name.String] = struct{}{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(doneDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		base := archive.TrimExt(entry.Name())
+		fields, err := parseZipName(names, base)
+		if err != nil || fields.Site != siteID || fields.Device != deviceID {
+			continue
+		}
+		if archiveBefore(names, entry.Name(), before) {
+			seen[entry.Name()] = struct{}{}
+		}
+	}
+
+	files := make([]string, 0, len(seen))
+	for name := range seen {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func archiveBefore(names *archivename.Template, name, before string) bool {
+	date, ok := parseZipDate(names, archive.TrimExt(name))
+	return ok && date < before
+}
+
+// PurgeIngestFiles deletes the rows of files for site/device and, when
+// before is set, the ping_stats days before it, marks their ingest_log
+// rows purged and returns how many rows it deleted. Archives and their
+// retention copies are not removed; see DeleteRetainedCopy.
+func PurgeIngestFiles(ctx context.Context, db *sql.DB, siteID, deviceID, before string, files []string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Format(time.RFC3339Nano)
+	var total int64
+	for _, name := range files {
+		// Chain entries are kept and flagged with the purge record so
+		// verify-chain can tell a recorded purge apart from an unexplained
+		// deletion.
+		purgeID, err := recordPurge(ctx, tx, siteID, deviceID, name, before, purgeReasonPurge, now, "")
+		if err != nil {
+			return 0, err
+		}
+		var deleted int64
+		for _, table := range []string{"hourly_metrics", "device_health", "controller_events", "raw_observations", "time_alignment", "sensor_data_snapshots", "snapshot_duplicates", "comparison_results", "sensor_health_daily", "rejected_lines", "unexpected_work_fields", "aggregate_checks", "ingest_ledger"} {
+			res, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE site_id = ? AND device_id = ? AND ingest_file = ?", table), siteID, deviceID, name)
+			if err != nil {
+				return 0, err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			deleted += n
+		}
+		if err := finishPurge(ctx, tx, purgeID, deleted); err != nil {
+			return 0, err
+		}
+		// The ingest_log rows stay as the record of what was ingested;
+		// fetch-archive refuses the archives they mark purged.
+		if _, err := tx.ExecContext(ctx, `UPDATE ingest_log SET purged_at = ? WHERE site_id = ? AND device_id = ? AND ingest_file = ?`, now, siteID, deviceID, name); err != nil {
+			return 0, err
+		}
+		total += deleted
+	}
+	if before != "" {
+		cutoff, err := timeparse.ParseDate(before)
+		if err != nil {
+			return 0, err
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM ping_stats WHERE site_id = ? AND device_id = ? AND day < ?`, siteID, deviceID, cutoff.Format(time.DateOnly))
+		if err != nil {
+			return 0, err
+		}
+		total += rowsAffected(res)
+	}
+	return total, tx.Commit()
+}
@@ -0,0 +1,35 @@
+package ingest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestPurgeDeletesPingStatsBeforeCutoff(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "purge.db"), DBOptions{})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO ping_stats (site_id, device_id, sensor_id, day, samples, lost) VALUES
+			('siteA', 'device01', 'PING1', '2026-01-20', 10, 0),
+			('siteA', 'device01', 'PING1', '2026-01-21', 10, 0),
+			('siteA', 'device02', 'PING1', '2026-01-20', 10, 0)
+	`); err != nil {
+		t.Fatalf("insert ping_stats: %v", err)
+	}
+	n, err := PurgeIngestFiles(ctx, db, "siteA", "device01", "20260121", nil)
+	if err != nil || n != 1 {
+		t.Fatalf("purge: deleted %d, %v", n, err)
+	}
+	var days string
+	if err := db.QueryRowContext(ctx, `SELECT group_concat(device_id || ' ' || day, ', ') FROM (SELECT * FROM ping_stats ORDER BY device_id, day)`).Scan(&days); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if want := "device01 2026-01-21, device02 2026-01-20"; days != want {
+		t.Fatalf("ping_stats left: %q, want %q", days, want)
+	}
+}
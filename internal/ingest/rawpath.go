@@ -0,0 +1,155 @@
+package ingest
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// rawPathRule is one raw_paths pattern of a mapping entry: a glob matched
+// case-insensitively against the whole path under raw_session, or, with a
+// "re:" prefix, a regular expression found anywhere in it.
+type rawPathRule struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func parseRawPath(pattern string) (rawPathRule, error) {
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return rawPathRule{}, fmt.Errorf("raw_paths %q: %w", pattern, err)
+		}
+		return rawPathRule{re: re}, nil
+	}
+	glob := strings.ToLower(pattern)
+	if _, err := path.Match(glob, ""); err != nil {
+		return rawPathRule{}, fmt.Errorf("raw_paths %q: %w", pattern, err)
+	}
+	return rawPathRule{glob: glob}, nil
+}
+
+func (r rawPathRule) match(rel string) bool {
+	if r.re != nil {
+		return r.re.MatchString(rel)
+	}
+	ok, _ := path.Match(r.glob, strings.ToLower(rel))
+	return ok
+}
+
+// sensorMatcher attributes raw_session files to mapping entries. Entries
+// with raw_paths match only the files those select, and a file two sensors'
+// raw_paths select is a conflict. Other files go to the entry whose
+// sensor_id is the longest one the path contains, so a GATE10 file is not
+// read as GATE1's.
+type sensorMatcher struct {
+	explicit []explicitEntry
+	implicit []SensorMapping
+}
+
+type explicitEntry struct {
+	entry SensorMapping
+	rules []rawPathRule
+}
+
+func newSensorMatcher(mapping map[string]SensorMapping) (*sensorMatcher, error) {
+	m := &sensorMatcher{}
+	for _, id := range sortedMappingIDs(mapping) {
+		entry := mapping[id]
+		if entry.SensorID == "" {
+			continue
+		}
+		if len(entry.RawPaths) == 0 {
+			m.implicit = append(m.implicit, entry)
+			continue
+		}
+		explicit := explicitEntry{entry: entry}
+		for _, pattern := range entry.RawPaths {
+			rule, err := parseRawPath(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%w: id %s: %w", ErrMappingInvalid, id, err)
+			}
+			explicit.rules = append(explicit.rules, rule)
+		}
+		m.explicit = append(m.explicit, explicit)
+	}
+	// Longest first, so the first contained sensor_id is the most specific;
+	// the stable sort keeps mapping id order among equal lengths.
+	sort.SliceStable(m.implicit, func(i, j int) bool {
+		return len(m.implicit[i].SensorID) > len(m.implicit[j].SensorID)
+	})
+	return m, nil
+}
+
+// match returns the entry rel, a slash-separated path under raw_session,
+// belongs to. It fails when the raw_paths of different sensors select rel.
+func (m *sensorMatcher) match(rel string) (SensorMapping, bool, error) {
+	sensors := m.explicitSensors(rel)
+	switch {
+	case len(sensors) > 1:
+		return SensorMapping{}, false, fmt.Errorf("%w: raw_session/%s matches raw_paths of %s", ErrMappingInvalid, rel, strings.Join(sensors, " and "))
+	case len(sensors) == 1:
+		for _, e := range m.explicit {
+			if e.entry.SensorID == sensors[0] {
+				return e.entry, true, nil
+			}
+		}
+	}
+	candidates := m.implicitCandidates(rel)
+	if len(candidates) == 0 {
+		return SensorMapping{}, false, nil
+	}
+	return candidates[0], true, nil
+}
+
+// explicitSensors lists the sensor ids whose raw_paths select rel.
+func (m *sensorMatcher) explicitSensors(rel string) []string {
+	var sensors []string
+	for _, e := range m.explicit {
+		if containsString(sensors, e.entry.SensorID) {
+			continue
+		}
+		for _, rule := range e.rules {
+			if rule.match(rel) {
+				sensors = append(sensors, e.entry.SensorID)
+				break
+			}
+		}
+	}
+	sort.Strings(sensors)
+	return sensors
+}
+
+// implicitCandidates lists the entries without raw_paths whose sensor_id
+// rel contains, longest sensor_id first.
+func (m *sensorMatcher) implicitCandidates(rel string) []SensorMapping {
+	lower := strings.ToLower(rel)
+	var candidates []SensorMapping
+	for _, entry := range m.implicit {
+		if strings.Contains(lower, strings.ToLower(entry.SensorID)) {
+			candidates = append(candidates, entry)
+		}
+	}
+	return candidates
+}
+
+// checkRawPaths rejects raw_paths that do not parse and the same pattern
+// given for two different sensors.
+func checkRawPaths(mapping map[string]SensorMapping) error {
+	owners := map[string]string{}
+	for _, id := range sortedMappingIDs(mapping) {
+		entry := mapping[id]
+		for _, pattern := range entry.RawPaths {
+			if _, err := parseRawPath(pattern); err != nil {
+				return fmt.Errorf("id %s: %w", id, err)
+			}
+			if owner, ok := owners[pattern]; ok && owner != entry.SensorID {
+				return fmt.Errorf("id %s: raw_paths %q is also given for %s", id, pattern, owner)
+			}
+			owners[pattern] = entry.SensorID
+		}
+	}
+	return nil
+}
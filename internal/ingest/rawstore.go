@@ -0,0 +1,115 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// observedLayout is the raw_observations.observed_at layout: UTC with fixed
+// milliseconds, so the text sorts and compares in time order.
+const observedLayout = "2006-01-02T15:04:05.000Z"
+
+// storeRawObservations keeps the parsed raw_session lines of an archive in
+// raw_observations, replacing any an earlier ingest of the same archive
+// stored, so they can be queried after the work tree is gone. Values are
+// redacted like the lines. Rows counts the observations stored.
+func storeRawObservations(ctx context.Context, db dbConn, observations map[string][]RawObservation, evidence Evidence, siteID, deviceID, ingestFile string) (StageCount, error) {
+	var count StageCount
+	if _, err := db.ExecContext(ctx, `
+		DELETE FROM raw_observations WHERE site_id = ? AND device_id = ? AND ingest_file = ?
+	`, siteID, deviceID, ingestFile); err != nil {
+		return count, err
+	}
+	stmt, err := db.PrepareContext(ctx, `
+		INSERT INTO raw_observations
+		(site_id, device_id, sensor_id, observed_at, value, decoded_json, line, ingest_file, ingested_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return count, err
+	}
+	defer stmt.Close()
+
+	sensorIDs := make([]string, 0, len(observations))
+	for sensorID := range observations {
+		sensorIDs = append(sensorIDs, sensorID)
+	}
+	sort.Strings(sensorIDs)
+	ingestedAt := time.Now().Format(time.RFC3339Nano)
+	for _, sensorID := range sensorIDs {
+		for _, observation := range observations[sensorID] {
+			var decoded sql.NullString
+			if observation.Values != nil {
+				data, err := json.Marshal(observation.Values)
+				if err != nil {
+					return count, err
+				}
+				decoded = sql.NullString{String: string(data), Valid: true}
+			}
+			if _, err := stmt.ExecContext(ctx, siteID, deviceID, sensorID, observation.Timestamp.UTC().Format(observedLayout),
+				evidence.redact(observation.Value), decoded, observation.Line, ingestFile, ingestedAt); err != nil {
+				return count, err
+			}
+			count.Rows++
+		}
+	}
+	return count, nil
+}
+
+// PruneRawObservations deletes the raw observations older than days, or
+// than the raw_observation_days of the device's settings when they set it,
+// and returns how many were deleted. A retention of 0 keeps everything.
+func PruneRawObservations(ctx context.Context, db *sql.DB, days int, now time.Time) (int64, error) {
+	cutoff := func(days int) string {
+		return now.AddDate(0, 0, -days).UTC().Format(observedLayout)
+	}
+	var deleted int64
+	prune := func(query string, args ...any) error {
+		res, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		deleted += rowsAffected(res)
+		return nil
+	}
+	if days > 0 {
+		if err := prune(`
+			DELETE FROM raw_observations WHERE observed_at < ? AND NOT EXISTS (
+				SELECT 1 FROM device_settings s
+				WHERE s.site_id = raw_observations.site_id AND s.device_id IN ('', raw_observations.device_id)
+				AND s.raw_observation_days IS NOT NULL
+			)
+		`, cutoff(days)); err != nil {
+			return deleted, err
+		}
+	}
+	settings, err := ListDeviceSettings(ctx, db)
+	if err != nil {
+		return deleted, err
+	}
+	for _, s := range settings {
+		if s.RawObservationDays == nil || *s.RawObservationDays <= 0 {
+			continue
+		}
+		if s.DeviceID != "" {
+			err = prune(`DELETE FROM raw_observations WHERE site_id = ? AND device_id = ? AND observed_at < ?`,
+				s.SiteID, s.DeviceID, cutoff(*s.RawObservationDays))
+		} else {
+			// The site's retention covers the devices without their own.
+			err = prune(`
+				DELETE FROM raw_observations WHERE site_id = ? AND observed_at < ? AND NOT EXISTS (
+					SELECT 1 FROM device_settings s
+					WHERE s.site_id = raw_observations.site_id AND s.device_id = raw_observations.device_id
+					AND s.raw_observation_days IS NOT NULL
+				)
+			`, s.SiteID, cutoff(*s.RawObservationDays))
+		}
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
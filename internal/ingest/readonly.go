@@ -0,0 +1,69 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+)
+
+// comparisonColumns are the comparison_results columns encodeComparisons
+// reads, in its order.
+const comparisonColumns = `site_id, device_id, work_field, publish_at, sensor_id, sensor_type, field_name,
+	sent_value, raw_value, result, raw_evidence, ingest_file, created_at, row_key`
+
+// WriteComparisons writes every stored comparison row to w as JSON lines
+// and returns how many it wrote. The read-only worker uses it to print what
+// an archive would have stored. Rows come ordered by their natural key, not
+// by id, so two runs over the same archives print the same lines whatever
+// order or concurrency they were ingested with.
+func WriteComparisons(ctx context.Context, db *sql.DB, w io.Writer) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+comparisonColumns+`
+		FROM comparison_results
+		ORDER BY site_id, device_id, work_field, publish_at, sensor_id, field_name
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	return encodeComparisons(rows, w)
+}
+
+// encodeComparisons writes rows, selected with comparisonColumns, to w as
+// JSON lines one at a time and returns how many it wrote.
+func encodeComparisons(rows *sql.Rows, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	n := 0
+	for rows.Next() {
+		var fields [14]sql.NullString
+		dest := make([]any, len(fields))
+		for i := range fields {
+			dest[i] = &fields[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		row := comparisonRow{
+			SiteID:      fields[0].String,
+			DeviceID:    fields[1].String,
+			WorkField:   fields[2].String,
+			PublishAt:   fields[3].String,
+			SensorID:    fields[4].String,
+			SensorType:  fields[5].String,
+			FieldName:   fields[6].String,
+			SentValue:   fields[7].String,
+			RawValue:    fields[8].String,
+			Result:      fields[9].String,
+			RawEvidence: fields[10].String,
+			IngestFile:  fields[11].String,
+			CreatedAt:   fields[12].String,
+			RowKey:      fields[13].String,
+		}
+		if err := enc.Encode(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
@@ -0,0 +1,75 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"workfield/internal/buildinfo"
+	"workfield/internal/receipt"
+)
+
+// logIngest records the archive in ingest_log and returns the row id, which
+// receipts carry as their audit id.
+func logIngest(ctx context.Context, db dbConn, siteID, deviceID, ingestFile string, counts map[string]StageCount) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO ingest_log (site_id, device_id, ingest_file, events, snapshots, comparisons, worker_version, ingested_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, siteID, deviceID, ingestFile, counts["events"].Rows, counts["snapshots"].Rows, counts["compare"].Rows,
+		buildinfo.Get().Short(), time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// newReceipt describes how an archive ended.
+func newReceipt(zipName string, counts map[string]StageCount, auditID int64, elapsed time.Duration, err error) receipt.Receipt {
+	r := receipt.Receipt{
+		Archive:       zipName,
+		Status:        receipt.StatusOK,
+		AuditID:       auditID,
+		DurationMS:    elapsed.Milliseconds(),
+		ServerTime:    time.Now().UTC(),
+		WorkerVersion: buildinfo.Get().Short(),
+	}
+	// A failed archive's rows were rolled back, so it reports none.
+	if err == nil {
+		r.Rows = rowCounts(counts)
+	} else {
+		r.Status = receipt.StatusFailed
+		if errors.Is(err, ErrDBBusy) {
+			r.Status = receipt.StatusRetry
+		}
+		var archiveErr *ArchiveError
+		if errors.As(err, &archiveErr) {
+			r.Stage = archiveErr.Stage
+		}
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// rowCounts names the rows an archive added, as reported in receipts and
+// the run summary.
+func rowCounts(counts map[string]StageCount) map[string]int64 {
+	return map[string]int64{
+		"events":      counts["events"].Rows,
+		"snapshots":   counts["snapshots"].Rows,
+		"comparisons": counts["compare"].Rows,
+		"rejected":    counts["events"].Rejected + counts["snapshots"].Rejected,
+		// snapshots stored under a work_field outside Options.WorkFields
+		"unexpected_work_field": counts["snapshots"].Flagged,
+		// device hourly aggregates that disagree with its snapshots
+		"aggregate_mismatches": counts["aggregates"].Mismatches,
+	}
+}
+
+// writeReceipt stores r in dir. A failure to write the receipt is logged but
+// does not fail the archive, which has already been committed.
+func writeReceipt(dir string, r receipt.Receipt) {
+	if err := receipt.Write(dir, r); err != nil {
+		slog.Warn("receipt not written", "ingest_file", r.Archive, "dir", dir, "error", err)
+	}
+}
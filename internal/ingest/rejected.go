@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// maxRejectedLine bounds how much of a rejected line is kept.
+const maxRejectedLine = 2048
+
+// rejectedLines records input lines that could not be stored, with the
+// reason, so malformed data is visible instead of silently skipped.
+type rejectedLines struct {
+	stmt                         *sql.Stmt
+	siteID, deviceID, ingestFile string
+	source                       string
+}
+
+func newRejectedLines(ctx context.Context, db dbConn, siteID, deviceID, ingestFile, source string) (*rejectedLines, error) {
+	stmt, err := db.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO rejected_lines
+		(site_id, device_id, ingest_file, source, line_no, reason, line, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return &rejectedLines{stmt: stmt, siteID: siteID, deviceID: deviceID, ingestFile: ingestFile, source: source}, nil
+}
+
+func (r *rejectedLines) add(ctx context.Context, lineNo int64, reason, line string) error {
+	if len(line) > maxRejectedLine {
+		line = line[:maxRejectedLine]
+	}
+	_, err := r.stmt.ExecContext(ctx, r.siteID, r.deviceID, r.ingestFile, r.source, lineNo, reason, line, time.Now().Format(time.RFC3339Nano))
+	return err
+}
+
+func (r *rejectedLines) Close() error {
+	return r.stmt.Close()
+}
@@ -0,0 +1,320 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"workfield/internal/archivename"
+	"workfield/internal/buildinfo"
+	"workfield/internal/receipt"
+	"workfield/internal/s3"
+)
+
+// Retention actions recorded in ingest_log.retention_action.
+const (
+	RetentionDeleted  = "deleted"
+	RetentionArchived = "archived"
+)
+
+// RetentionStore keeps a verified copy of a done archive before the
+// original is removed.
+type RetentionStore interface {
+	// Store copies the file at path as name and returns where the copy
+	// lives once it has been checked against the original.
+	Store(ctx context.Context, name, path string) (string, error)
+	// Delete removes the copy stored as name; one that is already gone is
+	// not an error.
+	Delete(ctx context.Context, name string) error
+}
+
+// DirStore copies into a directory, typically a slower or mounted volume,
+// and compares the copy's SHA-256 with the original's.
+type DirStore string
+
+func (d DirStore) Store(ctx context.Context, name, path string) (string, error) {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(string(d), name)
+	partial := dest + ".partial"
+	want, err := copyFile(path, partial)
+	if err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	got, err := fileSHA256(partial)
+	if err != nil || got != want {
+		os.Remove(partial)
+		if err == nil {
+			err = fmt.Errorf("copy of %s does not match the original", name)
+		}
+		return "", err
+	}
+	return dest, os.Rename(partial, dest)
+}
+
+func (d DirStore) Delete(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(string(d), name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// S3Store uploads to a bucket; the bucket checks the upload against the
+// file's MD5 and SHA-256.
+type S3Store struct {
+	Client *s3.Client
+}
+
+func (s S3Store) Store(ctx context.Context, name, path string) (string, error) {
+	if err := s.Client.PutFile(ctx, name, path); err != nil {
+		return "", err
+	}
+	return s.Client.Location(name), nil
+}
+
+func (s S3Store) Delete(ctx context.Context, name string) error {
+	return s.Client.Delete(ctx, name)
+}
+
+// retentionStoreAt is the store holding the copy at location, as Store
+// returned it, and the name the copy is stored under.
+func retentionStoreAt(location string) (RetentionStore, string, error) {
+	if strings.HasPrefix(location, "s3://") {
+		i := strings.LastIndex(location, "/")
+		client, err := s3.FromURL(location[:i])
+		if err != nil {
+			return nil, "", err
+		}
+		return S3Store{Client: client}, location[i+1:], nil
+	}
+	return DirStore(filepath.Dir(location)), filepath.Base(location), nil
+}
+
+// RetainedCopies returns where done retention copied files of a site and
+// device, for the ingest_log rows purge has not reached yet.
+func RetainedCopies(ctx context.Context, db *sql.DB, siteID, deviceID string, files []string) ([]string, error) {
+	var locations []string
+	for _, name := range files {
+		rows, err := db.QueryContext(ctx, `
+			SELECT DISTINCT retention_location FROM ingest_log
+			WHERE site_id = ? AND device_id = ? AND ingest_file = ? AND retention_action = ?
+				AND COALESCE(retention_location, '') != '' AND purged_at IS NULL
+			ORDER BY retention_location
+		`, siteID, deviceID, name, RetentionArchived)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var location string
+			if err := rows.Scan(&location); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			locations = append(locations, location)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return locations, nil
+}
+
+// DeleteRetainedCopy removes the copy at location, as RetainedCopies
+// returned it, and the receipt and name sidecar stored with it.
+func DeleteRetainedCopy(ctx context.Context, location string) error {
+	store, name, err := retentionStoreAt(location)
+	if err != nil {
+		return err
+	}
+	for _, companion := range []string{receipt.Name(name), NameFile(name)} {
+		if err := store.Delete(ctx, companion); err != nil {
+			return err
+		}
+	}
+	return store.Delete(ctx, name)
+}
+
+// RetainedArchive is one done archive handled by RetainDone.
+type RetainedArchive struct {
+	Name     string
+	Action   string
+	Location string
+	SHA256   string
+}
+
+// RetainDone handles the archives in doneDir ingested before now-maxAge:
+// each is copied to store when it is set, recorded in its ingest_log row
+// (added when the archive predates ingest_log) and then removed with its
+// receipt. An archive's age is its latest ingest_log.ingested_at, or its
+// modification time when it has none. With dryRun it only lists them. An
+// archive that fails is reported in the error and left in place. Names are
+// read with names. Purge deletes the copies in store again, see
+// RetainedCopies.
+func RetainDone(ctx context.Context, db *sql.DB, doneDir string, names *archivename.Template, maxAge time.Duration, store RetentionStore, now time.Time, dryRun bool) ([]RetainedArchive, error) {
+	zips, err := ListZipFiles(doneDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	action := RetentionDeleted
+	if store != nil {
+		action = RetentionArchived
+	}
+	cutoff := now.Add(-maxAge)
+	var retained []RetainedArchive
+	var errs []error
+	for _, zipPath := range zips {
+		if err := ctx.Err(); err != nil {
+			return retained, err
+		}
+		ingestedAt, err := archiveIngestedAt(ctx, db, zipPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(zipPath), err))
+			continue
+		}
+		if !ingestedAt.Before(cutoff) {
+			continue
+		}
+		r := RetainedArchive{Name: filepath.Base(zipPath), Action: action}
+		if !dryRun {
+			if r, err = retainArchive(ctx, db, zipPath, names, r, store); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", r.Name, err))
+				continue
+			}
+		}
+		retained = append(retained, r)
+	}
+	sort.Slice(retained, func(i, j int) bool { return retained[i].Name < retained[j].Name })
+	return retained, errors.Join(errs...)
+}
+
+// archiveIngestedAt is when the archive at zipPath was last ingested, or
+// its modification time when ingest_log has no ingested_at for it.
+func archiveIngestedAt(ctx context.Context, db *sql.DB, zipPath string) (time.Time, error) {
+	var ingestedAt sql.NullString
+	if err := db.QueryRowContext(ctx, `SELECT MAX(ingested_at) FROM ingest_log WHERE ingest_file = ?`, filepath.Base(zipPath)).Scan(&ingestedAt); err != nil {
+		return time.Time{}, err
+	}
+	if ingestedAt.Valid {
+		return time.Parse(time.RFC3339Nano, ingestedAt.String)
+	}
+	info, err := os.Stat(zipPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func retainArchive(ctx context.Context, db *sql.DB, zipPath string, names *archivename.Template, r RetainedArchive, store RetentionStore) (RetainedArchive, error) {
+	var err error
+	if r.SHA256, err = fileSHA256(zipPath); err != nil {
+		return r, err
+	}
+	name, err := archiveName(zipPath, names, ArchiveName{})
+	if err != nil {
+		return r, err
+	}
+	// The receipt and name sidecar go wherever the archive goes.
+	var companions []string
+	for _, companion := range []string{receipt.Name(r.Name), NameFile(r.Name)} {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(zipPath), companion)); err == nil {
+			companions = append(companions, companion)
+		}
+	}
+	if store != nil {
+		if r.Location, err = store.Store(ctx, r.Name, zipPath); err != nil {
+			return r, err
+		}
+		for _, companion := range companions {
+			if _, err := store.Store(ctx, companion, filepath.Join(filepath.Dir(zipPath), companion)); err != nil {
+				return r, err
+			}
+		}
+	}
+	if err := logRetention(ctx, db, name, r); err != nil {
+		return r, err
+	}
+	if err := os.Remove(zipPath); err != nil {
+		return r, err
+	}
+	for _, companion := range companions {
+		os.Remove(filepath.Join(filepath.Dir(zipPath), companion))
+	}
+	return r, nil
+}
+
+// logRetention records what happened to an archive on its ingest_log row so
+// the stored rows can still be traced to the original file.
+func logRetention(ctx context.Context, db *sql.DB, name ArchiveName, r RetainedArchive) error {
+	siteID, deviceID := name.SiteID, name.DeviceID
+	retainedAt := time.Now().Format(time.RFC3339Nano)
+	res, err := db.ExecContext(ctx, `
+		UPDATE ingest_log SET retention_action = ?, retention_location = ?, archive_sha256 = ?, retained_at = ?
+		WHERE site_id = ? AND device_id = ? AND ingest_file = ?
+	`, r.Action, r.Location, r.SHA256, retainedAt, siteID, deviceID, r.Name)
+	if err != nil {
+		return err
+	}
+	if rowsAffected(res) > 0 {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO ingest_log (site_id, device_id, ingest_file, worker_version, retention_action, retention_location, archive_sha256, retained_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, siteID, deviceID, r.Name, buildinfo.Get().Short(), r.Action, r.Location, r.SHA256, retainedAt)
+	return err
+}
+
+// copyFile copies src to dst, syncs it and returns the SHA-256 of what was
+// read.
+func copyFile(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), in); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
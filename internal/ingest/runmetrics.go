@@ -0,0 +1,120 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"workfield/internal/metrics"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the archive
+// duration histogram.
+var durationBuckets = []float64{1, 5, 15, 60, 300, 900, 3600}
+
+// rowTables names the tables a stage's new rows went to, for the
+// rows_inserted counter; events covers every table events.jsonl feeds.
+var rowTables = map[string]string{
+	"events":     "events",
+	"snapshots":  "sensor_data_snapshots",
+	"compare":    "comparison_results",
+	"aggregates": "aggregate_checks",
+	"analyze":    "sensor_health_daily",
+}
+
+// RunMetrics counts what the worker ingested since it started, for the
+// Prometheus endpoint. It is safe for concurrent use.
+type RunMetrics struct {
+	mu         sync.Mutex
+	processed  int64
+	failed     int64
+	duplicates int64
+	rows       map[string]int64
+	results    SensorTally
+	buckets    []int64
+	seconds    float64
+	lastIngest time.Time
+}
+
+// observe records one finished archive. An archive interrupted by shutdown
+// is left out, as from the run summary.
+func (m *RunMetrics) observe(counts map[string]StageCount, tallies map[string]SensorTally, elapsed time.Duration, duplicate bool, err error) {
+	if m == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rows == nil {
+		m.rows = map[string]int64{}
+		m.buckets = make([]int64, len(durationBuckets))
+	}
+	switch {
+	case err != nil:
+		m.failed++
+	case duplicate:
+		m.duplicates++
+	default:
+		m.processed++
+		m.lastIngest = time.Now()
+		for stage, table := range rowTables {
+			m.rows[table] += counts[stage].Rows
+		}
+		for _, tally := range tallies {
+			m.results.Match += tally.Match
+			m.results.Mismatch += tally.Mismatch
+			m.results.MissingRaw += tally.MissingRaw
+			m.results.MissingSent += tally.MissingSent
+		}
+	}
+	seconds := elapsed.Seconds()
+	m.seconds += seconds
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+}
+
+// Families renders the counters, the archive duration histogram and the
+// time of the last ingested archive.
+func (m *RunMetrics) Families() []metrics.Family {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	archives := metrics.Family{Name: "field_worker_archives_total", Help: "Archives the worker finished, by outcome.", Type: "counter", Samples: []metrics.Sample{
+		{Labels: map[string]string{"status": "processed"}, Value: float64(m.processed)},
+		{Labels: map[string]string{"status": "failed"}, Value: float64(m.failed)},
+		{Labels: map[string]string{"status": "duplicate"}, Value: float64(m.duplicates)},
+	}}
+	rows := metrics.Family{Name: "field_worker_rows_inserted_total", Help: "New rows stored by ingested archives, by table.", Type: "counter"}
+	for _, table := range rowTables {
+		rows.Samples = append(rows.Samples, metrics.Sample{Labels: map[string]string{"table": table}, Value: float64(m.rows[table])})
+	}
+	results := metrics.Family{Name: "field_worker_comparisons_total", Help: "Comparisons made by ingested archives, by result.", Type: "counter"}
+	for _, r := range []struct {
+		result string
+		count  int64
+	}{{"MATCH", m.results.Match}, {"MISMATCH", m.results.Mismatch}, {"MISSING_RAW", m.results.MissingRaw}, {"MISSING_SENT", m.results.MissingSent}} {
+		results.Samples = append(results.Samples, metrics.Sample{Labels: map[string]string{"result": r.result}, Value: float64(r.count)})
+	}
+	total := m.processed + m.failed + m.duplicates
+	duration := metrics.Family{Name: "field_worker_archive_duration_seconds", Help: "Time taken per finished archive.", Type: "histogram"}
+	for i, bound := range durationBuckets {
+		var count int64
+		if m.buckets != nil {
+			count = m.buckets[i]
+		}
+		duration.Samples = append(duration.Samples, metrics.Sample{Suffix: "_bucket", Labels: map[string]string{"le": strconv.FormatFloat(bound, 'g', -1, 64)}, Value: float64(count)})
+	}
+	duration.Samples = append(duration.Samples,
+		metrics.Sample{Suffix: "_bucket", Labels: map[string]string{"le": "+Inf"}, Value: float64(total)},
+		metrics.Sample{Suffix: "_sum", Value: m.seconds},
+		metrics.Sample{Suffix: "_count", Value: float64(total)})
+	families := []metrics.Family{archives, rows, results, duration}
+	if !m.lastIngest.IsZero() {
+		families = append(families, metrics.Family{Name: "field_worker_last_ingest_timestamp_seconds", Help: "Unix time the latest archive was ingested.",
+			Samples: []metrics.Sample{{Value: float64(m.lastIngest.Unix())}}})
+	}
+	return families
+}
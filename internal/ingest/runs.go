@@ -0,0 +1,101 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"workfield/internal/buildinfo"
+)
+
+// maxRunErrors bounds the error messages kept per run; ErrorCount still
+// counts all of them.
+const maxRunErrors = 100
+
+// IngestRun is one worker invocation as recorded in ingest_runs: its
+// RunSummary, when it started and finished, and the errors it logged.
+// DurationMS covers the whole run; RowsPerSec is not stored.
+type IngestRun struct {
+	ID         int64     `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	RunSummary
+	ErrorCount    int      `json:"error_count"`
+	Errors        []string `json:"errors,omitempty"`
+	WorkerVersion string   `json:"worker_version"`
+}
+
+// NewIngestRun describes a run that started at started and ends now with
+// summary and errs.
+func NewIngestRun(started time.Time, summary RunSummary, errs []error) IngestRun {
+	run := IngestRun{
+		StartedAt:     started,
+		FinishedAt:    time.Now(),
+		RunSummary:    summary,
+		ErrorCount:    len(errs),
+		WorkerVersion: buildinfo.Get().Short(),
+	}
+	run.DurationMS = run.FinishedAt.Sub(started).Milliseconds()
+	for _, err := range errs {
+		if len(run.Errors) == maxRunErrors {
+			break
+		}
+		run.Errors = append(run.Errors, err.Error())
+	}
+	return run
+}
+
+// RecordRun stores run in ingest_runs and returns its id.
+func RecordRun(ctx context.Context, db *sql.DB, run IngestRun) (int64, error) {
+	rows, err := json.Marshal(run.Rows)
+	if err != nil {
+		return 0, err
+	}
+	errs, err := json.Marshal(run.Errors)
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO ingest_runs
+		(started_at, finished_at, archives, processed, failed, skipped, duplicates, mismatches, rows_json, error_count, errors_json, worker_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, run.StartedAt.Format(time.RFC3339Nano), run.FinishedAt.Format(time.RFC3339Nano), run.Archives, run.Processed, run.Failed, run.Skipped,
+		run.Duplicates, run.Mismatches, string(rows), run.ErrorCount, string(errs), run.WorkerVersion)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// LastRuns lists the latest n recorded runs, newest first.
+func LastRuns(ctx context.Context, db *sql.DB, n int) ([]IngestRun, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, started_at, finished_at, archives, processed, failed, skipped, duplicates, mismatches, rows_json, error_count, errors_json, worker_version
+		FROM ingest_runs ORDER BY id DESC LIMIT ?
+	`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []IngestRun
+	for rows.Next() {
+		var run IngestRun
+		var started, finished, rowsJSON, errorsJSON string
+		if err := rows.Scan(&run.ID, &started, &finished, &run.Archives, &run.Processed, &run.Failed, &run.Skipped, &run.Duplicates,
+			&run.Mismatches, &rowsJSON, &run.ErrorCount, &errorsJSON, &run.WorkerVersion); err != nil {
+			return nil, err
+		}
+		run.StartedAt, _ = time.Parse(time.RFC3339Nano, started)
+		run.FinishedAt, _ = time.Parse(time.RFC3339Nano, finished)
+		run.DurationMS = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+		if err := json.Unmarshal([]byte(rowsJSON), &run.Rows); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(errorsJSON), &run.Errors); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,553 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"workfield/internal/migrate"
+
+	_ "modernc.org/sqlite"
+)
+
+// DefaultBusyTimeout is how long a connection waits on another process's
+// lock before SQLITE_BUSY is returned.
+const DefaultBusyTimeout = 5 * time.Second
+
+// DefaultReadConns is how many connections OpenReadDB opens at most when
+// DBOptions sets no limit.
+const DefaultReadConns = 4
+
+// DBOptions tunes the sqlite connections; the zero value is the default.
+type DBOptions struct {
+	// BusyTimeout is how long a connection waits on another process's lock
+	// before SQLITE_BUSY is returned; DefaultBusyTimeout when zero.
+	BusyTimeout time.Duration
+	// Synchronous is the writer's synchronous pragma, e.g. "normal", which
+	// under WAL syncs at checkpoints instead of every commit; sqlite's
+	// "full" when empty.
+	Synchronous string
+	// Pragmas are further pragmas as name(value), e.g. cache_size(-20000),
+	// set on every connection.
+	Pragmas []string
+	// ReadConns limits OpenReadDB's pool; DefaultReadConns when zero.
+	ReadConns int
+}
+
+// OpenDB opens the sqlite database at path for writing, creating its
+// directory and the schema as needed.
+//
+// SQLite allows one writer at a time, so the pool is limited to a single
+// connection: the worker's writes queue in database/sql instead of
+// contending for the file lock with each other. The journal is switched to
+// WAL so readers in other processes (reports, sqlite3 shells) neither block
+// nor are blocked by ingest, transactions take the write lock at BEGIN, and
+// busy_timeout makes the remaining contention wait rather than fail. The
+// connection is kept open rather than reopened when idle.
+func OpenDB(path string, opts DBOptions) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	params := []string{"_pragma=journal_mode(WAL)", "_txlock=immediate"}
+	if opts.Synchronous != "" {
+		params = append(params, fmt.Sprintf("_pragma=synchronous(%s)", opts.Synchronous))
+	}
+	db, err := sql.Open("sqlite", dsn(path, opts, params...))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxIdleTime(0)
+	if err := InitSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenReadDB opens an existing database for queries only. Reads can run in
+// parallel with each other and, under WAL, with the writer.
+func OpenReadDB(path string, opts DBOptions) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn(path, opts, "_pragma=query_only(1)"))
+	if err != nil {
+		return nil, err
+	}
+	conns := opts.ReadConns
+	if conns <= 0 {
+		conns = DefaultReadConns
+	}
+	db.SetMaxOpenConns(conns)
+	return db, nil
+}
+
+// dbConn is what the stages of an archive write through: the archive's
+// transaction, or the database itself.
+type dbConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+func dsn(path string, opts DBOptions, params ...string) string {
+	busyTimeout := opts.BusyTimeout
+	if busyTimeout <= 0 {
+		busyTimeout = DefaultBusyTimeout
+	}
+	all := []string{fmt.Sprintf("_pragma=busy_timeout(%d)", busyTimeout.Milliseconds())}
+	for _, pragma := range opts.Pragmas {
+		all = append(all, "_pragma="+url.QueryEscape(pragma))
+	}
+	return path + "?" + strings.Join(append(all, params...), "&")
+}
+
+// InitSchema brings the database's schema up to date; see MigrateSchema.
+func InitSchema(db *sql.DB) error {
+	_, err := MigrateSchema(context.Background(), db)
+	return err
+}
+
+// MigrateSchema applies the schema migrations the database has not had yet
+// and returns them. A change to the schema is a new migration appended to
+// schemaMigrations; released migrations are never edited.
+func MigrateSchema(ctx context.Context, db *sql.DB) ([]migrate.Migration, error) {
+	applied, err := migrate.Up(ctx, db, schemaMigrations)
+	for _, m := range applied {
+		slog.Info("schema migrated", "version", m.Version, "name", m.Name)
+	}
+	return applied, err
+}
+
+// SchemaVersion is the schema version this build migrates databases to.
+func SchemaVersion() int {
+	return len(schemaMigrations)
+}
+
+var schemaMigrations = []migrate.Migration{
+	{Version: 1, Name: "baseline", Apply: baselineSchema},
+	{Version: 2, Name: "raw_observations", Apply: execSchema(`
+		CREATE TABLE IF NOT EXISTS raw_observations (
+			id INTEGER PRIMARY KEY,
+			site_id TEXT,
+			device_id TEXT,
+			sensor_id TEXT,
+			observed_at TEXT,
+			value TEXT,
+			decoded_json TEXT,
+			line TEXT,
+			ingest_file TEXT,
+			ingested_at TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_raw_observations_sensor ON raw_observations(site_id, device_id, sensor_id, observed_at);
+		CREATE INDEX IF NOT EXISTS idx_raw_observations_observed_at ON raw_observations(observed_at);
+	`)},
+	{Version: 3, Name: "time_alignment", Apply: execSchema(`
+		CREATE TABLE IF NOT EXISTS time_alignment (
+			id INTEGER PRIMARY KEY,
+			site_id TEXT,
+			device_id TEXT,
+			ingest_file TEXT,
+			sensor_id TEXT,
+			first_publish_at TEXT,
+			window_ms INTEGER,
+			snapshots INTEGER,
+			matched INTEGER,
+			skew_ms INTEGER,
+			abs_p50_ms INTEGER,
+			abs_p90_ms INTEGER,
+			abs_max_ms INTEGER,
+			beyond_window INTEGER,
+			beyond_p50_ms INTEGER,
+			created_at TEXT,
+			UNIQUE(site_id, device_id, ingest_file, sensor_id)
+		);
+	`)},
+	{Version: 4, Name: "ingest_runs", Apply: execSchema(`
+		CREATE TABLE IF NOT EXISTS ingest_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at TEXT,
+			finished_at TEXT,
+			archives INTEGER,
+			processed INTEGER,
+			failed INTEGER,
+			skipped INTEGER,
+			duplicates INTEGER,
+			mismatches INTEGER,
+			rows_json TEXT,
+			error_count INTEGER,
+			errors_json TEXT,
+			worker_version TEXT
+		);
+	`)},
+	{Version: 5, Name: "device_settings", Apply: execSchema(`
+		CREATE TABLE IF NOT EXISTS device_settings (
+			site_id TEXT NOT NULL,
+			device_id TEXT NOT NULL DEFAULT '',
+			window_seconds INTEGER,
+			compare_bucket_minutes INTEGER,
+			compare_aggregate TEXT,
+			snapshot_dedupe TEXT,
+			raw_observation_days INTEGER,
+			publish INTEGER,
+			updated_at TEXT,
+			PRIMARY KEY(site_id, device_id)
+		);
+	`)},
+	{Version: 6, Name: "chain_purge_records", Apply: addColumns(
+		[3]string{"comparison_chain", "purge_id", "INTEGER"},
+		[3]string{"comparison_chain", "site_id", "TEXT"},
+		[3]string{"comparison_chain", "device_id", "TEXT"},
+		[3]string{"comparison_chain", "ingest_file", "TEXT"},
+		[3]string{"purge_log", "reason", "TEXT"},
+	)},
+	{Version: 7, Name: "ingest_log_purged_at", Apply: addColumns(
+		[3]string{"ingest_log", "purged_at", "TEXT"},
+	)},
+}
+
+// addColumns adds {table, column, declaration} columns that are missing,
+// so the migration can be replayed over a database that already has them.
+func addColumns(columns ...[3]string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, c := range columns {
+			if err := ensureColumn(ctx, tx, c[0], c[1], c[2]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func execSchema(schema string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, schema)
+		return err
+	}
+}
+
+// baselineSchema is the schema as it was when migrations were introduced.
+// Databases from before then have none recorded, so it must also complete
+// one created by any earlier build: tables are created only when missing
+// and the columns added since are added where absent.
+func baselineSchema(ctx context.Context, tx *sql.Tx) error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS hourly_metrics (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		work_field TEXT,
+		hour TEXT,
+		payload_json TEXT,
+		ingest_file TEXT,
+		ingested_at TEXT,
+		UNIQUE(site_id, device_id, work_field, hour, ingest_file)
+	);
+	CREATE TABLE IF NOT EXISTS sensor_data_snapshots (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		work_field TEXT,
+		publish_at TEXT,
+		payload_json TEXT,
+		ingest_file TEXT,
+		ingested_at TEXT,
+		UNIQUE(site_id, device_id, publish_at, work_field)
+	);
+	CREATE TABLE IF NOT EXISTS snapshot_duplicates (
+		id INTEGER PRIMARY KEY,
+		snapshot_id INTEGER,
+		site_id TEXT,
+		device_id TEXT,
+		work_field TEXT,
+		publish_at TEXT,
+		payload_json TEXT,
+		payload_codec TEXT,
+		payload_version INTEGER,
+		payload_hash TEXT,
+		ingest_file TEXT,
+		ingested_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS sensor_health_daily (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		day TEXT,
+		sensor_id TEXT,
+		sensor_type TEXT,
+		timeouts INTEGER,
+		no_response INTEGER,
+		zero_data INTEGER,
+		duplicates INTEGER,
+		parse_errors INTEGER,
+		snd_count INTEGER,
+		rcv_count INTEGER,
+		time_from TEXT,
+		time_to TEXT,
+		result_json TEXT,
+		ingest_file TEXT,
+		analyzed_at TEXT,
+		worker_version TEXT,
+		UNIQUE(site_id, device_id, day, sensor_id)
+	);
+	CREATE TABLE IF NOT EXISTS device_health (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		sampled_at TEXT,
+		disk_used_pct REAL,
+		disk_free_bytes INTEGER,
+		load1 REAL,
+		uptime_seconds REAL,
+		ntp_offset_ms REAL,
+		payload_json TEXT,
+		payload_codec TEXT,
+		ingest_file TEXT,
+		ingested_at TEXT,
+		UNIQUE(site_id, device_id, sampled_at)
+	);
+	CREATE TABLE IF NOT EXISTS controller_events (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		occurred_at TEXT,
+		kind TEXT,
+		code TEXT,
+		message TEXT,
+		source TEXT,
+		payload_json TEXT,
+		payload_codec TEXT,
+		ingest_file TEXT,
+		ingested_at TEXT,
+		UNIQUE(site_id, device_id, occurred_at, kind, source)
+	);
+	CREATE TABLE IF NOT EXISTS unexpected_work_fields (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		work_field TEXT,
+		ingest_file TEXT,
+		snapshots INTEGER,
+		first_publish_at TEXT,
+		created_at TEXT,
+		UNIQUE(site_id, device_id, work_field, ingest_file)
+	);
+	CREATE TABLE IF NOT EXISTS ingest_attempts (
+		ingest_file TEXT PRIMARY KEY,
+		attempts INTEGER,
+		stage TEXT,
+		last_error TEXT,
+		first_failed_at TEXT,
+		last_failed_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS aggregate_checks (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		work_field TEXT,
+		hour TEXT,
+		sensor_key TEXT,
+		aggregate TEXT,
+		reported REAL,
+		derived REAL,
+		result TEXT,
+		ingest_file TEXT,
+		created_at TEXT,
+		UNIQUE(site_id, device_id, work_field, hour, sensor_key, aggregate)
+	);
+	CREATE TABLE IF NOT EXISTS comparison_results (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		work_field TEXT,
+		publish_at TEXT,
+		sensor_id TEXT,
+		sensor_type TEXT,
+		field_name TEXT,
+		sent_value TEXT,
+		raw_value TEXT,
+		result TEXT,
+		raw_evidence TEXT,
+		ingest_file TEXT,
+		created_at TEXT,
+		UNIQUE(site_id, device_id, work_field, publish_at, sensor_id, field_name)
+	);
+	CREATE TABLE IF NOT EXISTS ping_stats (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		sensor_id TEXT,
+		day TEXT,
+		samples INTEGER,
+		lost INTEGER,
+		rtt_min REAL,
+		rtt_avg REAL,
+		rtt_max REAL,
+		updated_at TEXT,
+		UNIQUE(site_id, device_id, sensor_id, day)
+	);
+	CREATE TABLE IF NOT EXISTS comparison_chain (
+		id INTEGER PRIMARY KEY,
+		comparison_id INTEGER NOT NULL UNIQUE,
+		prev_hash TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		created_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS rejected_lines (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		ingest_file TEXT,
+		source TEXT,
+		line_no INTEGER,
+		reason TEXT,
+		line TEXT,
+		created_at TEXT,
+		UNIQUE(ingest_file, source, line_no)
+	);
+	CREATE TABLE IF NOT EXISTS ingest_log (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		ingest_file TEXT,
+		events INTEGER,
+		snapshots INTEGER,
+		comparisons INTEGER,
+		worker_version TEXT,
+		ingested_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS ingest_ledger (
+		ingest_file TEXT NOT NULL,
+		archive_sha256 TEXT NOT NULL,
+		site_id TEXT,
+		device_id TEXT,
+		status TEXT,
+		error TEXT,
+		audit_id INTEGER,
+		first_seen_at TEXT,
+		finished_at TEXT,
+		duplicates INTEGER NOT NULL DEFAULT 0,
+		last_duplicate_at TEXT,
+		PRIMARY KEY (ingest_file, archive_sha256)
+	);
+	CREATE TABLE IF NOT EXISTS purge_log (
+		id INTEGER PRIMARY KEY,
+		site_id TEXT,
+		device_id TEXT,
+		before_date TEXT,
+		ingest_file TEXT,
+		rows_deleted INTEGER,
+		purged_at TEXT
+	);
+	`
+	if _, err := tx.ExecContext(ctx, schema); err != nil {
+		return err
+	}
+	for _, column := range []struct{ table, name, decl string }{
+		{"comparison_chain", "purged_at", "TEXT"},
+		{"hourly_metrics", "payload_codec", "TEXT"},
+		{"sensor_data_snapshots", "payload_codec", "TEXT"},
+		{"sensor_data_snapshots", "payload_version", "INTEGER"},
+		{"sensor_data_snapshots", "payload_hash", "TEXT"},
+		{"comparison_results", "worker_version", "TEXT"},
+		{"comparison_results", "sent_lat", "REAL"},
+		{"comparison_results", "sent_lon", "REAL"},
+		{"comparison_results", "raw_lat", "REAL"},
+		{"comparison_results", "raw_lon", "REAL"},
+		{"comparison_results", "bucket_seconds", "INTEGER"},
+		{"comparison_results", "bucket_snapshots", "INTEGER"},
+		{"comparison_results", "row_key", "TEXT"},
+		{"purge_log", "worker_version", "TEXT"},
+		{"ingest_log", "retention_action", "TEXT"},
+		{"ingest_log", "retention_location", "TEXT"},
+		{"ingest_log", "archive_sha256", "TEXT"},
+		{"ingest_log", "retained_at", "TEXT"},
+		{"sensor_health_daily", "status", "TEXT"},
+	} {
+		if err := ensureColumn(ctx, tx, column.table, column.name, column.decl); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS idx_comparison_results_row_key ON comparison_results(row_key)`); err != nil {
+		return err
+	}
+	return backfillRowKeys(ctx, tx)
+}
+
+// backfillRowKeys sets row_key on comparison rows stored before it existed.
+// Once done, the lookup finds nothing through the index.
+func backfillRowKeys(ctx context.Context, db dbConn) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, site_id, device_id, work_field, publish_at, sensor_id, field_name
+		FROM comparison_results WHERE row_key IS NULL
+	`)
+	if err != nil {
+		return err
+	}
+	keys := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var fields [6]sql.NullString
+		if err := rows.Scan(&id, &fields[0], &fields[1], &fields[2], &fields[3], &fields[4], &fields[5]); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[id] = rowKey(comparisonRow{
+			SiteID:    fields[0].String,
+			DeviceID:  fields[1].String,
+			WorkField: fields[2].String,
+			PublishAt: fields[3].String,
+			SensorID:  fields[4].String,
+			FieldName: fields[5].String,
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, key := range keys {
+		if _, err := db.ExecContext(ctx, `UPDATE comparison_results SET row_key = ? WHERE id = ?`, key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ensureColumn(ctx context.Context, db dbConn, table, column, decl string) error {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
@@ -0,0 +1,68 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"workfield/internal/migrate"
+)
+
+func TestOpenDBMigratesOldDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.sqlite3")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A table as an early build created it, without the columns added
+	// since and without schema_version.
+	if _, err := old.Exec(`CREATE TABLE purge_log (id INTEGER PRIMARY KEY, site_id TEXT, device_id TEXT, before_date TEXT, ingest_file TEXT, rows_deleted INTEGER, purged_at TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db, err := OpenDB(path, DBOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	if version, err := migrate.Version(context.Background(), db); err != nil || version != SchemaVersion() {
+		t.Fatalf("version %d, %v; want %d", version, err, SchemaVersion())
+	}
+	if _, err := db.Exec(`INSERT INTO purge_log (worker_version) VALUES ('x')`); err != nil {
+		t.Fatalf("baseline did not add purge_log.worker_version: %v", err)
+	}
+	if applied, err := MigrateSchema(context.Background(), db); err != nil || len(applied) != 0 {
+		t.Fatalf("second migration applied %d, %v", len(applied), err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_version (version, name) VALUES (?, 'future')`, SchemaVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitSchema(db); !errors.Is(err, migrate.ErrNewerSchema) {
+		t.Fatalf("expected ErrNewerSchema, got %v", err)
+	}
+}
+
+func TestOpenDBAppliesPragmas(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "tuned.sqlite3"), DBOptions{
+		Synchronous: "normal",
+		Pragmas:     []string{"cache_size(-20000)"},
+	})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+	var journal string
+	var synchronous, cacheSize int
+	if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&journal); err != nil || journal != "wal" {
+		t.Fatalf("journal_mode %q, %v", journal, err)
+	}
+	if err := db.QueryRow(`PRAGMA synchronous`).Scan(&synchronous); err != nil || synchronous != 1 {
+		t.Fatalf("synchronous %d, %v; want 1 (normal)", synchronous, err)
+	}
+	if err := db.QueryRow(`PRAGMA cache_size`).Scan(&cacheSize); err != nil || cacheSize != -20000 {
+		t.Fatalf("cache_size %d, %v", cacheSize, err)
+	}
+}
@@ -0,0 +1,113 @@
+package ingest
+
+import (
+	"sync"
+	"time"
+
+	"workfield/internal/metrics"
+)
+
+// SensorTally counts the comparison results one archive produced for one
+// sensor.
+type SensorTally struct {
+	SensorType  string
+	Comparisons int64
+	Match       int64
+	Mismatch    int64
+	MissingRaw  int64
+	MissingSent int64
+}
+
+func (t *SensorTally) add(sensorType, result string) {
+	t.SensorType = sensorType
+	t.Comparisons++
+	switch result {
+	case "MATCH":
+		t.Match++
+	case "MISMATCH":
+		t.Mismatch++
+	case "MISSING_RAW":
+		t.MissingRaw++
+	case "MISSING_SENT":
+		t.MissingSent++
+	}
+}
+
+// MatchRatio is the share of MATCH results, or 0 without comparisons.
+func (t SensorTally) MatchRatio() float64 {
+	if t.Comparisons == 0 {
+		return 0
+	}
+	return float64(t.Match) / float64(t.Comparisons)
+}
+
+// SensorMetrics keeps, per sensor, the tally of the latest archive that
+// compared it. Sensors only come from mapping entries, which bounds the
+// label values an alerting rule sees. It is safe for concurrent use.
+type SensorMetrics struct {
+	mu      sync.Mutex
+	sensors map[string]sensorLatest
+}
+
+type sensorLatest struct {
+	tally SensorTally
+	at    time.Time
+}
+
+func (m *SensorMetrics) observe(tallies map[string]SensorTally, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sensors == nil {
+		m.sensors = map[string]sensorLatest{}
+	}
+	for sensorID, tally := range tallies {
+		m.sensors[sensorID] = sensorLatest{tally: tally, at: at}
+	}
+}
+
+// Sensor returns the latest tally of sensorID.
+func (m *SensorMetrics) Sensor(sensorID string) (SensorTally, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	latest, ok := m.sensors[sensorID]
+	return latest.tally, ok
+}
+
+// Families renders the tallies as Prometheus gauges labelled by sensor_id
+// and sensor_type.
+func (m *SensorMetrics) Families() []metrics.Family {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gauges := []struct {
+		name, help string
+		value      func(sensorLatest) float64
+	}{
+		{"field_worker_sensor_match_ratio", "Share of MATCH results in the latest archive that compared the sensor.",
+			func(s sensorLatest) float64 { return s.tally.MatchRatio() }},
+		{"field_worker_sensor_comparisons", "Comparisons made for the sensor in the latest archive that compared it.",
+			func(s sensorLatest) float64 { return float64(s.tally.Comparisons) }},
+		{"field_worker_sensor_mismatches", "MISMATCH results of the sensor in the latest archive that compared it.",
+			func(s sensorLatest) float64 { return float64(s.tally.Mismatch) }},
+		{"field_worker_sensor_missing_raw", "MISSING_RAW results of the sensor in the latest archive that compared it.",
+			func(s sensorLatest) float64 { return float64(s.tally.MissingRaw) }},
+		{"field_worker_sensor_missing_sent", "MISSING_SENT results of the sensor in the latest archive that compared it.",
+			func(s sensorLatest) float64 { return float64(s.tally.MissingSent) }},
+		{"field_worker_sensor_last_compared_timestamp_seconds", "Unix time the sensor's latest archive was ingested.",
+			func(s sensorLatest) float64 { return float64(s.at.Unix()) }},
+	}
+	families := make([]metrics.Family, 0, len(gauges))
+	for _, gauge := range gauges {
+		family := metrics.Family{Name: gauge.name, Help: gauge.help}
+		for sensorID, latest := range m.sensors {
+			family.Samples = append(family.Samples, metrics.Sample{
+				Labels: map[string]string{"sensor_id": sensorID, "sensor_type": latest.tally.SensorType},
+				Value:  gauge.value(latest),
+			})
+		}
+		families = append(families, family)
+	}
+	return families
+}
@@ -0,0 +1,121 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"time"
+)
+
+// SensorStaleness is when a sensor of one device last reported anything and
+// last delivered valid data.
+type SensorStaleness struct {
+	SiteID   string
+	DeviceID string
+	SensorID string
+	// Configured is false for sensors that have data but are no longer in the
+	// mapping.
+	Configured bool
+	// LastSeen is the last snapshot carrying a value for the sensor;
+	// LastValid the last one whose raw log matched it. Zero means never.
+	LastSeen  time.Time
+	LastValid time.Time
+	// DaysSinceValid counts whole days from LastValid to the report time; -1
+	// means the sensor never delivered valid data.
+	DaysSinceValid int
+}
+
+// Staleness reports every mapped sensor of every device that has comparison
+// results matching filter, plus unmapped sensors that still have data, most
+// stale first.
+func Staleness(ctx context.Context, db *sql.DB, mapping map[string]SensorMapping, filter Filter, asOf time.Time) ([]SensorStaleness, error) {
+	// MAX(publish_at) compares RFC 3339 text, which orders correctly for one
+	// device's timestamps; the per-result maxima are combined in Go.
+	where, args := filter.where()
+	rows, err := db.QueryContext(ctx, `
+		SELECT site_id, device_id, sensor_id, result, MAX(publish_at)
+		FROM comparison_results`+where+`
+		GROUP BY site_id, device_id, sensor_id, result
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct{ site, device, sensor string }
+	type device struct{ site, device string }
+	found := map[key]*SensorStaleness{}
+	devices := map[device]bool{}
+	for rows.Next() {
+		var k key
+		var result, publishAt string
+		if err := rows.Scan(&k.site, &k.device, &k.sensor, &result, &publishAt); err != nil {
+			return nil, err
+		}
+		devices[device{k.site, k.device}] = true
+		entry := found[k]
+		if entry == nil {
+			entry = &SensorStaleness{SiteID: k.site, DeviceID: k.device, SensorID: k.sensor}
+			found[k] = entry
+		}
+		at, err := time.Parse(time.RFC3339Nano, publishAt)
+		if err != nil || result == "MISSING_SENT" {
+			continue
+		}
+		if at.After(entry.LastSeen) {
+			entry.LastSeen = at
+		}
+		if result == "MATCH" && at.After(entry.LastValid) {
+			entry.LastValid = at
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Disabled entries are configured but never compared, so they get no
+	// "never seen" rows of their own.
+	configured := map[string]bool{}
+	for _, entry := range mapping {
+		configured[entry.SensorID] = configured[entry.SensorID] || entry.IsEnabled()
+	}
+	for d := range devices {
+		for sensorID, enabled := range configured {
+			if !enabled {
+				continue
+			}
+			k := key{d.site, d.device, sensorID}
+			if found[k] == nil {
+				found[k] = &SensorStaleness{SiteID: d.site, DeviceID: d.device, SensorID: sensorID}
+			}
+		}
+	}
+
+	report := make([]SensorStaleness, 0, len(found))
+	for _, entry := range found {
+		_, entry.Configured = configured[entry.SensorID]
+		entry.DaysSinceValid = -1
+		if !entry.LastValid.IsZero() {
+			entry.DaysSinceValid = int(math.Floor(asOf.Sub(entry.LastValid).Hours() / 24))
+		}
+		report = append(report, *entry)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.LastValid.IsZero() != b.LastValid.IsZero() {
+			return a.LastValid.IsZero()
+		}
+		if !a.LastValid.Equal(b.LastValid) {
+			return a.LastValid.Before(b.LastValid)
+		}
+		if a.SiteID != b.SiteID {
+			return a.SiteID < b.SiteID
+		}
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.SensorID < b.SensorID
+	})
+	return report, nil
+}
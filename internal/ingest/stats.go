@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var stageNames = []string{"name", "ledger", "settings", "prepare", "extract", "manifest", "events", "snapshots", "aggregates", "raw_session", "raw_store", "compare", "ping_stats", "analyze", "move"}
+
+// StageNames lists the pipeline stages in execution order.
+func StageNames() []string {
+	return append([]string(nil), stageNames...)
+}
+
+// StageCount is the work one stage did for one archive: input lines read
+// (comparisons for the compare stage), rows inserted and lines rejected.
+// Mismatches counts inserted MISMATCH rows of the compare stage; Flagged
+// counts snapshots under an unexpected work_field.
+type StageCount struct {
+	Lines      int64
+	Rows       int64
+	Rejected   int64
+	Mismatches int64
+	Flagged    int64
+}
+
+type StageStats struct {
+	Archives int
+	Duration time.Duration
+	Lines    int64
+	Rows     int64
+	Rejected int64
+}
+
+// Stats accumulates per-stage timings and counts across archives. Set
+// Options.Stats to collect them. It is safe for concurrent use.
+type Stats struct {
+	mu     sync.Mutex
+	stages map[string]StageStats
+}
+
+func (s *Stats) add(stage string, elapsed time.Duration, count StageCount) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stages == nil {
+		s.stages = map[string]StageStats{}
+	}
+	current := s.stages[stage]
+	current.Archives++
+	current.Duration += elapsed
+	current.Lines += count.Lines
+	current.Rows += count.Rows
+	current.Rejected += count.Rejected
+	s.stages[stage] = current
+}
+
+func (s *Stats) Stage(name string) StageStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stages[name]
+}
+
+// RunSummary is the end-of-run picture of one worker invocation. Processed
+// archives were ingested and moved to done; failed ones were rejected;
+// skipped ones are still in incoming (database busy, or not reached before
+// shutdown) and will be tried again; duplicates had already been ingested
+// and were moved to done untouched. Rows uses the receipt row names;
+// RowsPerSec is the events, snapshots and comparisons stored per second of
+// the run.
+type RunSummary struct {
+	Archives   int              `json:"archives"`
+	Processed  int              `json:"processed"`
+	Failed     int              `json:"failed"`
+	Skipped    int              `json:"skipped"`
+	Duplicates int              `json:"duplicates"`
+	Rows       map[string]int64 `json:"rows"`
+	Mismatches int64            `json:"mismatches"`
+	DurationMS int64            `json:"duration_ms"`
+	RowsPerSec float64          `json:"rows_per_sec"`
+}
+
+// Summary accumulates a RunSummary across archives. Set Options.Summary to
+// collect one. It is safe for concurrent use.
+type Summary struct {
+	mu     sync.Mutex
+	start  time.Time
+	totals RunSummary
+}
+
+func (s *Summary) record(counts map[string]StageCount, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.totals.Archives++
+	switch {
+	case err == nil:
+		s.totals.Processed++
+	case errors.Is(err, context.Canceled) || errors.Is(err, ErrDBBusy):
+		s.totals.Skipped++
+	default:
+		s.totals.Failed++
+	}
+	// A failed archive's rows were rolled back.
+	if err != nil {
+		return
+	}
+	for name, rows := range rowCounts(counts) {
+		s.totals.Rows[name] += rows
+	}
+	s.totals.Mismatches += counts["compare"].Mismatches
+}
+
+// duplicate counts an archive the ingest ledger skipped.
+func (s *Summary) duplicate() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.totals.Archives++
+	s.totals.Duplicates++
+}
+
+// skip counts archives that were never started.
+func (s *Summary) skip(n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	s.totals.Archives += n
+	s.totals.Skipped += n
+}
+
+func (s *Summary) init() {
+	if s.totals.Rows == nil {
+		s.start = time.Now()
+		s.totals.Rows = map[string]int64{}
+	}
+}
+
+// Totals returns the summary so far; the duration runs from the first
+// archive.
+func (s *Summary) Totals() RunSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals := s.totals
+	totals.Rows = map[string]int64{}
+	for name, rows := range s.totals.Rows {
+		totals.Rows[name] = rows
+	}
+	if !s.start.IsZero() {
+		elapsed := time.Since(s.start)
+		totals.DurationMS = elapsed.Milliseconds()
+		rows := totals.Rows["events"] + totals.Rows["snapshots"] + totals.Rows["comparisons"]
+		if elapsed > 0 {
+			totals.RowsPerSec = float64(rows) / elapsed.Seconds()
+		}
+	}
+	return totals
+}
@@ -0,0 +1,163 @@
+package ingest
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"workfield/internal/archive"
+	"workfield/internal/manifest"
+)
+
+// archiveFiles opens the files of an archive by their slash-separated name,
+// returning an fs.ErrNotExist error for a missing one.
+type archiveFiles interface {
+	open(name string) (io.ReadCloser, error)
+}
+
+// dirFiles reads an archive extracted to the directory.
+type dirFiles string
+
+func (d dirFiles) open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// streamFiles reads files straight out of the archive, checking each one
+// the manifest lists as it is read, so only raw_session has to be written
+// to the work tree.
+type streamFiles struct {
+	reader *archive.Reader
+	stream *manifest.Stream
+}
+
+// openStream opens the archive and reads its manifest.
+func openStream(zipPath string) (*streamFiles, error) {
+	reader, err := archive.OpenReader(zipPath, archive.DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+	s := &streamFiles{reader: reader}
+	if err := s.readManifest(); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *streamFiles) readManifest() error {
+	file, err := s.reader.Open(manifest.FileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	m, err := manifest.Parse(data)
+	if err != nil {
+		return err
+	}
+	s.stream, err = manifest.NewStream(m)
+	return err
+}
+
+func (s *streamFiles) open(name string) (io.ReadCloser, error) {
+	file, err := s.reader.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{s.stream.Reader(name, file), file}, nil
+}
+
+// extractRaw writes the raw_session files to dest when extract is set and
+// otherwise only reads them, so they are verified either way.
+func (s *streamFiles) extractRaw(dest string, extract bool) error {
+	for _, name := range s.reader.Names() {
+		if !strings.HasPrefix(name, "raw_session/") {
+			continue
+		}
+		if !extract {
+			if err := s.drain(name); err != nil {
+				return err
+			}
+			continue
+		}
+		rel, err := archive.SafePath(name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := s.copyTo(name, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *streamFiles) copyTo(name, target string) error {
+	src, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func (s *streamFiles) drain(name string) error {
+	src, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(io.Discard, src)
+	return err
+}
+
+// verifyPending reads the listed files not verified yet, except those
+// skip names, which a later stage reads. A listed file missing from the
+// archive is an error, as in manifest.Verify.
+func (s *streamFiles) verifyPending(skip ...string) error {
+	for _, name := range s.stream.Pending() {
+		if containsString(skip, name) {
+			continue
+		}
+		if err := s.drain(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *streamFiles) Close() error {
+	return s.reader.Close()
+}
+
+// needsRawSession reports whether a streamed archive's raw_session has to
+// be extracted: something compares, stores or analyzes it.
+func needsRawSession(mapping map[string]SensorMapping, opts Options) bool {
+	if opts.AnalyzeRaw || opts.RawObservations {
+		return true
+	}
+	for _, entry := range mapping {
+		if entry.IsEnabled() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ClaimSuffix marks the archive a run is working on: <work>/<archive>.claim
+// exists next to the extracted tree while the archive is processed and is
+// touched at every stage, so CleanWorkDir can tell a live tree from one a
+// crashed run left behind.
+const ClaimSuffix = ".claim"
+
+func writeClaim(path, zipName string) error {
+	host, _ := os.Hostname()
+	content := fmt.Sprintf("archive=%s\npid=%d\nhost=%s\nstarted=%s\n", zipName, os.Getpid(), host, time.Now().Format(time.RFC3339))
+	return os.WriteFile(path, []byte(content), 0o644)
+}
+
+func touchClaim(path string) {
+	if path == "" {
+		return
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// CleanWorkDir removes extracted trees under dir that were last modified
+// before now-maxAge and whose claim, if any, is as old. It returns the
+// removed paths; a tree that cannot be removed is reported in the error and
+// does not stop the others.
+func CleanWorkDir(dir string, maxAge time.Duration, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cutoff := now.Add(-maxAge)
+	stale := func(path string) bool {
+		info, err := os.Stat(path)
+		return err != nil || info.ModTime().Before(cutoff)
+	}
+	var removed []string
+	var errs []error
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		var claim string
+		switch {
+		case entry.IsDir():
+			claim = path + ClaimSuffix
+			if _, err := os.Stat(claim); err != nil {
+				claim = ""
+			}
+		case strings.HasSuffix(entry.Name(), ClaimSuffix):
+			// A claim whose tree is gone; the tree's own entry handles
+			// the pair otherwise.
+			if _, err := os.Stat(strings.TrimSuffix(path, ClaimSuffix)); err == nil {
+				continue
+			}
+		default:
+			continue
+		}
+		if !stale(path) || (claim != "" && !stale(claim)) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if claim != "" {
+			os.Remove(claim)
+		}
+		removed = append(removed, path)
+	}
+	return removed, errors.Join(errs...)
+}
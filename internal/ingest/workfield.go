@@ -0,0 +1,103 @@
+package ingest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"workfield/internal/logging"
+)
+
+// WorkFields are the work_field values each site or device may upload
+// snapshots under, keyed "site/device" or "site"; a device entry replaces
+// its site's. Sites and devices without an entry are not checked.
+type WorkFields map[string][]string
+
+// allowed returns the work fields of the device, or false when it has none
+// configured.
+func (w WorkFields) allowed(siteID, deviceID string) ([]string, bool) {
+	if fields, ok := w[siteID+"/"+deviceID]; ok {
+		return fields, true
+	}
+	fields, ok := w[siteID]
+	return fields, ok
+}
+
+// workFieldCheck counts the snapshots of one archive whose work_field the
+// device is not configured for. They are stored and compared as usual; a
+// mis-provisioned client shows up in unexpected_work_fields instead of
+// silently mixing its data into the wrong field.
+type workFieldCheck struct {
+	allowed    map[string]bool
+	unexpected map[string]*unexpectedWorkField
+}
+
+type unexpectedWorkField struct {
+	snapshots      int64
+	firstPublishAt string
+}
+
+func newWorkFieldCheck(fields WorkFields, siteID, deviceID string) *workFieldCheck {
+	allowed, ok := fields.allowed(siteID, deviceID)
+	if !ok {
+		return nil
+	}
+	c := &workFieldCheck{allowed: map[string]bool{}, unexpected: map[string]*unexpectedWorkField{}}
+	for _, field := range allowed {
+		c.allowed[field] = true
+	}
+	return c
+}
+
+// add reports whether the snapshot's work field is unexpected.
+func (c *workFieldCheck) add(workField, publishAt string) bool {
+	if c == nil || c.allowed[workField] {
+		return false
+	}
+	u := c.unexpected[workField]
+	if u == nil {
+		u = &unexpectedWorkField{firstPublishAt: publishAt}
+		c.unexpected[workField] = u
+	}
+	u.snapshots++
+	if publishAt != "" && (u.firstPublishAt == "" || publishAt < u.firstPublishAt) {
+		u.firstPublishAt = publishAt
+	}
+	return true
+}
+
+// store records the archive's unexpected work fields; re-ingesting the
+// archive replaces its counts.
+func (c *workFieldCheck) store(ctx context.Context, db dbConn, siteID, deviceID, ingestFile string) error {
+	if c == nil || len(c.unexpected) == 0 {
+		return nil
+	}
+	fields := make([]string, 0, len(c.unexpected))
+	for field := range c.unexpected {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	allowed := make([]string, 0, len(c.allowed))
+	for field := range c.allowed {
+		allowed = append(allowed, field)
+	}
+	sort.Strings(allowed)
+	logging.From(ctx).Warn("snapshots under unexpected work_field",
+		"work_fields", strings.Join(fields, ","), "allowed", strings.Join(allowed, ","))
+
+	now := time.Now().Format(time.RFC3339Nano)
+	for _, field := range fields {
+		u := c.unexpected[field]
+		if _, err := db.ExecContext(ctx, `
+			INSERT INTO unexpected_work_fields
+			(site_id, device_id, work_field, ingest_file, snapshots, first_publish_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(site_id, device_id, work_field, ingest_file)
+			DO UPDATE SET snapshots = excluded.snapshots, first_publish_at = excluded.first_publish_at, created_at = excluded.created_at
+		`, siteID, deviceID, field, ingestFile, u.snapshots, u.firstPublishAt, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"workfield/internal/archivename"
+	"workfield/internal/receipt"
+)
+
+// ErrArchiveName means the archive's site and device could not be told from
+// its name (Options.NameTemplate, site_device_date.zip by default), a name
+// sidecar or Options.NameOverride.
+var ErrArchiveName = fmt.Errorf("%w: archive name does not match its template", ErrBadArchive)
+
+// NameFileSuffix is appended to an archive's file name for its name
+// sidecar, e.g. renamed.zip.name.json containing {"site_id": "siteA",
+// "device_id": "device01"}. It names the site and device of an archive
+// whose file name was changed by hand and travels with the archive to the
+// done directory.
+const NameFileSuffix = ".name.json"
+
+// ArchiveName is the site and device an archive belongs to.
+type ArchiveName struct {
+	SiteID   string `json:"site_id"`
+	DeviceID string `json:"device_id"`
+}
+
+// NameFile is the name of the sidecar for zipName.
+func NameFile(zipName string) string {
+	return zipName + NameFileSuffix
+}
+
+// archiveName resolves the site and device of zipPath from, in order, its
+// name sidecar, its file name read with names and fallback
+// (Options.NameOverride).
+func archiveName(zipPath string, names *archivename.Template, fallback ArchiveName) (ArchiveName, error) {
+	data, err := os.ReadFile(zipPath + NameFileSuffix)
+	switch {
+	case err == nil:
+		var name ArchiveName
+		if err := json.Unmarshal(data, &name); err != nil {
+			return ArchiveName{}, fmt.Errorf("%w: %s: %w", ErrArchiveName, NameFile(filepath.Base(zipPath)), err)
+		}
+		if name.SiteID == "" || name.DeviceID == "" {
+			return ArchiveName{}, fmt.Errorf("%w: %s needs site_id and device_id", ErrArchiveName, NameFile(filepath.Base(zipPath)))
+		}
+		return name, nil
+	case !errors.Is(err, os.ErrNotExist):
+		return ArchiveName{}, err
+	}
+	fields, err := parseZipName(names, zipBase(zipPath))
+	if err == nil {
+		return ArchiveName{SiteID: fields.Site, DeviceID: fields.Device}, nil
+	}
+	if fallback.SiteID != "" && fallback.DeviceID != "" {
+		return fallback, nil
+	}
+	return ArchiveName{}, err
+}
+
+// quarantineArchive moves an archive the pipeline can never accept, and its
+// name sidecar, out of incoming into dir so later runs stop retrying it. A
+// failed receipt next to it gives the reason. It reports whether the
+// archive was moved.
+func quarantineArchive(zipPath, dir string, r receipt.Receipt) bool {
+	zipName := filepath.Base(zipPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		slog.Warn("archive not quarantined", "ingest_file", zipName, "error", err)
+		return false
+	}
+	if err := os.Rename(zipPath, filepath.Join(dir, zipName)); err != nil {
+		slog.Warn("archive not quarantined", "ingest_file", zipName, "error", err)
+		return false
+	}
+	moveSidecar(zipPath, dir)
+	writeReceipt(dir, r)
+	slog.Warn("archive quarantined", "ingest_file", zipName, "dir", dir, "reason", r.Error, "attempts", r.Attempts)
+	return true
+}
+
+// moveSidecar moves the name sidecar of zipPath, if any, into dir.
+func moveSidecar(zipPath, dir string) {
+	sidecar := zipPath + NameFileSuffix
+	err := os.Rename(sidecar, filepath.Join(dir, filepath.Base(sidecar)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("name sidecar not moved", "path", sidecar, "dir", dir, "error", err)
+	}
+}
+
+// parseZipName reads base, an archive name without its extension, with
+// names.
+func parseZipName(names *archivename.Template, base string) (archivename.Fields, error) {
+	fields, err := names.Match(base)
+	if err != nil {
+		return fields, fmt.Errorf("%w: %s (%s)", ErrArchiveName, base, names)
+	}
+	return fields, nil
+}
+
+// parseZipDate is the date in base, if its name carries one.
+func parseZipDate(names *archivename.Template, base string) (string, bool) {
+	fields, err := names.Match(base)
+	return fields.Date, err == nil && fields.Date != ""
+}
@@ -0,0 +1,114 @@
+// Package logging sets up the process-wide log/slog logger from config so
+// every binary takes the same level, format and destination settings.
+// Command results (e.g. "wrote <path>") stay on stdout; logs are for
+// diagnostics and default to warnings and errors on stderr.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type Config struct {
+	Level  string // debug, info, warn (default), error
+	Format string // text (default) or json
+	Output string // stderr (default), stdout or a file path to append to
+}
+
+var (
+	Levels  = []string{"debug", "info", "warn", "error"}
+	Formats = []string{"text", "json"}
+)
+
+// ParseLevel reads a configured level; an empty value means warn.
+func ParseLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "", "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", value)
+	}
+}
+
+// Check validates cfg without opening the output.
+func (c Config) Check() error {
+	if _, err := ParseLevel(c.Level); err != nil {
+		return err
+	}
+	switch strings.ToLower(c.Format) {
+	case "", "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q", c.Format)
+	}
+}
+
+// Setup installs the default slog logger described by cfg. The returned
+// function closes a log file, if one was opened.
+func Setup(cfg Config) (func() error, error) {
+	logger, closeOutput, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+	return closeOutput, nil
+}
+
+// New builds a logger without installing it.
+func New(cfg Config) (*slog.Logger, func() error, error) {
+	if err := cfg.Check(); err != nil {
+		return nil, nil, err
+	}
+	level, _ := ParseLevel(cfg.Level)
+	out, closeOutput, err := openOutput(cfg.Output)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(out, opts)
+	if strings.EqualFold(cfg.Format, "json") {
+		handler = slog.NewJSONHandler(out, opts)
+	}
+	return slog.New(handler), closeOutput, nil
+}
+
+func openOutput(output string) (io.Writer, func() error, error) {
+	noop := func() error { return nil }
+	switch strings.ToLower(output) {
+	case "", "stderr":
+		return os.Stderr, noop, nil
+	case "stdout":
+		return os.Stdout, noop, nil
+	}
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("log output: %w", err)
+	}
+	return file, file.Close, nil
+}
+
+type loggerKey struct{}
+
+// With returns a context whose logger, as From returns it, adds args to
+// every record, e.g. the site, device and archive a stage works on.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, From(ctx).With(args...))
+}
+
+// From returns the logger With stored in ctx, or the default logger.
+func From(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewWritesJSONToFileAtLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "field.log")
+	logger, closeOutput, err := New(Config{Level: "info", Format: "json", Output: path})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Debug("hidden")
+	logger.Info("archive done", "archive", "a.zip")
+	if err := closeOutput(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the info record, got %q", data)
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if record["msg"] != "archive done" || record["archive"] != "a.zip" {
+		t.Fatalf("unexpected record %v", record)
+	}
+}
+
+func TestParseLevelDefaultsToWarn(t *testing.T) {
+	if level, err := ParseLevel(""); err != nil || level != slog.LevelWarn {
+		t.Fatalf("expected warn, got %v %v", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatalf("expected error")
+	}
+	if err := (Config{Format: "xml"}).Check(); err == nil {
+		t.Fatalf("expected format error")
+	}
+}
+
+func TestWithAddsFieldsToContextLogger(t *testing.T) {
+	var out bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&out, nil)))
+	defer slog.SetDefault(previous)
+
+	ctx := With(context.Background(), "site_id", "siteA", "device_id", "device01")
+	ctx = With(ctx, "sensor_id", "WLS1")
+	From(ctx).Info("observed")
+	From(context.Background()).Info("plain")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two records, got %q", out.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if record["site_id"] != "siteA" || record["device_id"] != "device01" || record["sensor_id"] != "WLS1" {
+		t.Fatalf("unexpected record %v", record)
+	}
+	if strings.Contains(lines[1], "site_id") {
+		t.Fatalf("default logger picked up context fields: %s", lines[1])
+	}
+}
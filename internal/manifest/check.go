@@ -0,0 +1,117 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrEmpty means a file to be packaged has no content.
+	ErrEmpty = errors.New("empty file")
+	// ErrTruncated means a file to be packaged ends part way through a
+	// record, as when its writer was stopped or the disk filled up.
+	ErrTruncated = errors.New("truncated file")
+)
+
+// Problem is a file that should not be packaged as it is.
+type Problem struct {
+	Name string
+	Err  error
+}
+
+func (p Problem) Error() string {
+	return p.Name + ": " + p.Err.Error()
+}
+
+// Check looks at the named files, given relative to root, before they are
+// packaged: an empty file (unless allowEmpty, for a quiet day), a .jsonl
+// file whose last line has no newline or is not JSON, and a .json file
+// that does not parse are reported. A file that cannot be read is an error.
+func Check(root string, names []string, allowEmpty bool) ([]Problem, error) {
+	var problems []Problem
+	for _, name := range names {
+		rel, err := cleanName(name)
+		if err != nil {
+			return nil, err
+		}
+		err = checkContent(filepath.Join(root, filepath.FromSlash(rel)))
+		switch {
+		case errors.Is(err, ErrEmpty) && allowEmpty:
+		case errors.Is(err, ErrEmpty), errors.Is(err, ErrTruncated):
+			problems = append(problems, Problem{Name: rel, Err: err})
+		case err != nil:
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// jsonlTail is how much of the end of a .jsonl file is read to find its
+// last line.
+const jsonlTail = 1 << 20
+
+func checkContent(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return ErrEmpty
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			return ErrEmpty
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("%w: not valid JSON", ErrTruncated)
+		}
+	case ".jsonl":
+		tail, err := readTail(path, info.Size(), jsonlTail)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(tail, []byte("\n")) {
+			return fmt.Errorf("%w: last line has no newline", ErrTruncated)
+		}
+		whole := int64(len(tail)) == info.Size()
+		tail = bytes.TrimRight(tail, "\r\n")
+		if len(bytes.TrimSpace(tail)) == 0 {
+			return ErrEmpty
+		}
+		// A last line longer than the tail is not checked.
+		newline := bytes.LastIndexByte(tail, '\n')
+		if newline < 0 && !whole {
+			return nil
+		}
+		if !json.Valid(tail[newline+1:]) {
+			return fmt.Errorf("%w: last line is not JSON", ErrTruncated)
+		}
+	}
+	return nil
+}
+
+// readTail returns up to n bytes from the end of the file.
+func readTail(path string, size, n int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	offset := max(size-n, 0)
+	buf := make([]byte, size-offset)
+	if _, err := file.ReadAt(buf, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf, nil
+}
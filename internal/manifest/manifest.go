@@ -0,0 +1,152 @@
+package manifest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FormatVersion is written into every new manifest. Manifests without a
+// version field predate versioning and are read as version 0.
+const FormatVersion = 1
+
+const FileName = "manifest.json"
+
+var (
+	// ErrMismatch means a listed file does not match its recorded hash or line
+	// count, i.e. the archive changed after the manifest was written.
+	ErrMismatch           = errors.New("manifest mismatch")
+	ErrInvalidPath        = errors.New("invalid manifest path")
+	ErrUnsupportedVersion = errors.New("unsupported manifest version")
+)
+
+type Manifest struct {
+	Version int              `json:"version,omitempty"`
+	Files   map[string]Entry `json:"files"`
+}
+
+type Entry struct {
+	SHA256 string `json:"sha256"`
+	Lines  int    `json:"lines"`
+}
+
+// BuildEntry hashes the file and counts its lines. A final line without a
+// trailing newline still counts as a line.
+func BuildEntry(path string) (Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer file.Close()
+	return BuildEntryFrom(file)
+}
+
+func BuildEntryFrom(r io.Reader) (Entry, error) {
+	hasher := sha256.New()
+	lines := 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lines++
+			if _, err := hasher.Write(line); err != nil {
+				return Entry{}, err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+	return Entry{SHA256: hex.EncodeToString(hasher.Sum(nil)), Lines: lines}, nil
+}
+
+// Build creates a manifest for the named files, given relative to root.
+func Build(root string, names []string) (Manifest, error) {
+	m := Manifest{Version: FormatVersion, Files: map[string]Entry{}}
+	for _, name := range names {
+		rel, err := cleanName(name)
+		if err != nil {
+			return Manifest{}, err
+		}
+		entry, err := BuildEntry(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return Manifest{}, err
+		}
+		m.Files[rel] = entry
+	}
+	return m, nil
+}
+
+func Write(path string, m Manifest) error {
+	if m.Version == 0 {
+		m.Version = FormatVersion
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func Read(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return Parse(data)
+}
+
+func Parse(data []byte) (Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("parse manifest: %w", err)
+	}
+	if m.Version < 0 || m.Version > FormatVersion {
+		return Manifest{}, fmt.Errorf("%w %d", ErrUnsupportedVersion, m.Version)
+	}
+	return m, nil
+}
+
+// Verify checks every listed file under root against its recorded hash and
+// line count. Files are checked in name order so errors are reproducible.
+func Verify(m Manifest, root string) error {
+	names := make([]string, 0, len(m.Files))
+	for name := range m.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rel, err := cleanName(name)
+		if err != nil {
+			return err
+		}
+		actual, err := BuildEntry(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		expected := m.Files[name]
+		if expected.SHA256 != actual.SHA256 || expected.Lines != actual.Lines {
+			return fmt.Errorf("%w for %s", ErrMismatch, name)
+		}
+	}
+	return nil
+}
+
+func cleanName(name string) (string, error) {
+	rel := filepath.ToSlash(filepath.Clean(filepath.FromSlash(name)))
+	if rel == "." || filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(rel, "/") || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w: %s", ErrInvalidPath, name)
+	}
+	return rel, nil
+}
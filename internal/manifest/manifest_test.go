@@ -0,0 +1,183 @@
+package manifest
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestBuildEntryCountsLines(t *testing.T) {
+	cases := []struct {
+		content string
+		lines   int
+	}{
+		{"", 0},
+		{"a\n", 1},
+		{"a\nb\n", 2},
+		{"a\nb", 2},
+		{"\n\n", 2},
+	}
+	for _, tc := range cases {
+		entry, err := BuildEntryFrom(strings.NewReader(tc.content))
+		if err != nil {
+			t.Fatalf("BuildEntryFrom(%q): %v", tc.content, err)
+		}
+		if entry.Lines != tc.lines {
+			t.Fatalf("BuildEntryFrom(%q): expected %d lines, got %d", tc.content, tc.lines, entry.Lines)
+		}
+		if len(entry.SHA256) != 64 {
+			t.Fatalf("expected hex sha256, got %q", entry.SHA256)
+		}
+	}
+}
+
+func TestBuildWriteReadVerifyRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "events.jsonl", "{\"hour\":\"00\"}\n")
+	writeFile(t, root, "raw_session/WLS1/2026-01-20.log", "line1\nline2\n")
+
+	m, err := Build(root, []string{"events.jsonl", "raw_session/WLS1/2026-01-20.log"})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if m.Version != FormatVersion {
+		t.Fatalf("expected version %d, got %d", FormatVersion, m.Version)
+	}
+	path := filepath.Join(root, FileName)
+	if err := Write(path, m); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	read, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(read.Files) != 2 || read.Files["raw_session/WLS1/2026-01-20.log"].Lines != 2 {
+		t.Fatalf("unexpected manifest: %+v", read)
+	}
+	if err := Verify(read, root); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
+
+func TestVerifyDetectsMismatch(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "sensor_data.jsonl", "a\n")
+	m, err := Build(root, []string{"sensor_data.jsonl"})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	writeFile(t, root, "sensor_data.jsonl", "a\nb\n")
+	err = Verify(m, root)
+	if !errors.Is(err, ErrMismatch) || !strings.Contains(err.Error(), "sensor_data.jsonl") {
+		t.Fatalf("expected mismatch error, got %v", err)
+	}
+}
+
+func TestStreamVerifiesWhileReading(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "events.jsonl", "{}\n{}")
+	writeFile(t, root, "raw_session/WLS1/a.log", "rcv: 01\n")
+	m, err := Build(root, []string{"events.jsonl", "raw_session/WLS1/a.log"})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	s, err := NewStream(m)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	data, err := io.ReadAll(s.Reader("events.jsonl", strings.NewReader("{}\n{}")))
+	if err != nil || string(data) != "{}\n{}" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+	if _, err := io.ReadAll(s.Reader("unlisted.txt", strings.NewReader("x"))); err != nil {
+		t.Fatalf("unlisted file: %v", err)
+	}
+	if pending := s.Pending(); len(pending) != 1 || pending[0] != "raw_session/WLS1/a.log" {
+		t.Fatalf("pending %v", pending)
+	}
+	if _, err := io.ReadAll(s.Reader("raw_session/WLS1/a.log", strings.NewReader("rcv: 02\n"))); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+	if len(s.Pending()) != 1 {
+		t.Fatal("a mismatching file must stay pending")
+	}
+}
+
+func TestVerifyMissingFile(t *testing.T) {
+	m := Manifest{Files: map[string]Entry{"missing.jsonl": {SHA256: "x", Lines: 1}}}
+	if err := Verify(m, t.TempDir()); err == nil {
+		t.Fatalf("expected error for missing file")
+	}
+}
+
+func TestVerifyRejectsEscapingPaths(t *testing.T) {
+	for _, name := range []string{"../secret", "/etc/passwd", "a/../../b"} {
+		m := Manifest{Files: map[string]Entry{name: {}}}
+		err := Verify(m, t.TempDir())
+		if err == nil || !strings.Contains(err.Error(), "invalid manifest path") {
+			t.Fatalf("expected invalid path error for %q, got %v", name, err)
+		}
+	}
+}
+
+func TestParseVersions(t *testing.T) {
+	legacy, err := Parse([]byte(`{"files":{"a":{"sha256":"x","lines":1}}}`))
+	if err != nil {
+		t.Fatalf("Parse legacy: %v", err)
+	}
+	if legacy.Version != 0 || legacy.Files["a"].Lines != 1 {
+		t.Fatalf("unexpected legacy manifest: %+v", legacy)
+	}
+	if _, err := Parse([]byte(`{"version":99,"files":{}}`)); err == nil {
+		t.Fatalf("expected unsupported version error")
+	}
+	if _, err := Parse([]byte(`{`)); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
+
+func TestCheckReportsEmptyAndTruncatedFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "analysis.json", `{"sensors": []}`)
+	writeFile(t, root, "events.jsonl", "")
+	writeFile(t, root, "sensor_data.jsonl", "{\"id\": 1}\n{\"id\": 2, \"val")
+	writeFile(t, root, "raw_session/a.jsonl", "{\"id\":\n")
+	writeFile(t, root, "raw_session/S1.log", "snd 1\n")
+	writeFile(t, root, "meta.json", `{"payload_version": 2`)
+	names := []string{"analysis.json", "events.jsonl", "sensor_data.jsonl", "raw_session/a.jsonl", "raw_session/S1.log", "meta.json"}
+
+	problems, err := Check(root, names, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]error{}
+	for _, p := range problems {
+		got[p.Name] = p.Err
+	}
+	if len(got) != 4 || !errors.Is(got["events.jsonl"], ErrEmpty) || !errors.Is(got["sensor_data.jsonl"], ErrTruncated) ||
+		!errors.Is(got["raw_session/a.jsonl"], ErrTruncated) || !errors.Is(got["meta.json"], ErrTruncated) {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+
+	problems, err = Check(root, names, true)
+	if err != nil || len(problems) != 3 {
+		t.Fatalf("with allowEmpty: %v, %v", problems, err)
+	}
+	if _, err := Check(root, []string{"missing.jsonl"}, true); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
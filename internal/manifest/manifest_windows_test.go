@@ -0,0 +1,27 @@
+package manifest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyRejectsWindowsEscapingPaths(t *testing.T) {
+	for _, name := range []string{`..\secret`, `C:\Windows\win.ini`, `C:relative`, `\rooted`, `\\server\share\x`} {
+		m := Manifest{Files: map[string]Entry{name: {}}}
+		if err := Verify(m, t.TempDir()); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("expected invalid path error for %q, got %v", name, err)
+		}
+	}
+}
+
+func TestBuildUsesSlashNames(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, root, "raw_session/WLS1/2026-01-20.log", "line\r\n")
+	m, err := Build(root, []string{`raw_session\WLS1\2026-01-20.log`})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if _, ok := m.Files["raw_session/WLS1/2026-01-20.log"]; !ok {
+		t.Fatalf("expected slash-separated key, got %v", m.Files)
+	}
+}
@@ -0,0 +1,92 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+)
+
+// Stream checks listed files as they are read from somewhere other than a
+// directory, such as straight out of an archive, instead of hashing them
+// again afterwards as Verify does.
+type Stream struct {
+	files    map[string]Entry
+	verified map[string]bool
+}
+
+// NewStream prepares to check the files of m.
+func NewStream(m Manifest) (*Stream, error) {
+	s := &Stream{files: map[string]Entry{}, verified: map[string]bool{}}
+	for name, entry := range m.Files {
+		rel, err := cleanName(name)
+		if err != nil {
+			return nil, err
+		}
+		s.files[rel] = entry
+	}
+	return s, nil
+}
+
+// Reader passes r, the content of the named file, through. When the file
+// is listed, reading it to the end fails with ErrMismatch instead of
+// io.EOF if it does not match its entry, and marks it verified otherwise.
+func (s *Stream) Reader(name string, r io.Reader) io.Reader {
+	rel, err := cleanName(name)
+	if err != nil {
+		return r
+	}
+	entry, ok := s.files[rel]
+	if !ok {
+		return r
+	}
+	return &streamReader{r: r, stream: s, name: rel, entry: entry, hash: sha256.New()}
+}
+
+// Pending lists the files not verified yet, in name order.
+func (s *Stream) Pending() []string {
+	var names []string
+	for name := range s.files {
+		if !s.verified[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type streamReader struct {
+	r      io.Reader
+	stream *Stream
+	name   string
+	entry  Entry
+	hash   hash.Hash
+	size   int64
+	lines  int
+	last   byte
+}
+
+func (v *streamReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if n > 0 {
+		v.hash.Write(p[:n])
+		v.size += int64(n)
+		v.lines += bytes.Count(p[:n], []byte{'\n'})
+		v.last = p[n-1]
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	lines := v.lines
+	if v.size > 0 && v.last != '\n' {
+		lines++
+	}
+	if hex.EncodeToString(v.hash.Sum(nil)) != v.entry.SHA256 || lines != v.entry.Lines {
+		return n, fmt.Errorf("%w for %s", ErrMismatch, v.name)
+	}
+	v.stream.verified[v.name] = true
+	return n, io.EOF
+}
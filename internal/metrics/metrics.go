@@ -0,0 +1,115 @@
+// Package metrics writes gauges in the Prometheus text exposition format,
+// for a scrape endpoint or a node_exporter textfile. It holds no registry:
+// callers build the families from their own state when asked.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Family is one metric name with its samples.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string // "gauge" when empty
+	Samples []Sample
+}
+
+// Sample is one labelled value of a family. Suffix is appended to the
+// family name, for the _bucket, _sum and _count samples of a histogram.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+	Suffix string
+}
+
+// Write renders families in the text format, samples in label order;
+// histogram samples keep their order, so buckets stay ascending.
+func Write(w io.Writer, families []Family) error {
+	for _, family := range families {
+		kind := family.Type
+		if kind == "" {
+			kind = "gauge"
+		}
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family.Name, escapeHelp(family.Help), family.Name, kind); err != nil {
+			return err
+		}
+		lines := make([]string, 0, len(family.Samples))
+		for _, sample := range family.Samples {
+			lines = append(lines, family.Name+sample.Suffix+formatLabels(sample.Labels)+" "+strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		}
+		if kind != "histogram" {
+			sort.Strings(lines)
+		}
+		for _, line := range lines {
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handler serves the families collect returns on every request.
+func Handler(collect func() []Family) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, collect())
+	})
+}
+
+// WriteFile writes families to path through a .partial file renamed into
+// place, so the textfile collector never reads half a file. node_exporter
+// only reads files ending in .prom.
+func WriteFile(path string, families []Family) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	partial := path + ".partial"
+	file, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	if err := Write(file, families); err != nil {
+		file.Close()
+		os.Remove(partial)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + `="` + escapeLabel(labels[name]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string { return labelEscaper.Replace(value) }
+
+func escapeHelp(value string) string { return helpEscaper.Replace(value) }
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var sample = []Family{{
+	Name: "field_worker_sensor_match_ratio",
+	Help: "Share of MATCH results.",
+	Samples: []Sample{
+		{Labels: map[string]string{"sensor_id": "WLS1", "sensor_type": "WLS"}, Value: 0.5},
+		{Labels: map[string]string{"sensor_type": "GATE", "sensor_id": `GA"TE1`}, Value: 1},
+	},
+}}
+
+const sampleText = `# HELP field_worker_sensor_match_ratio Share of MATCH results.
+# TYPE field_worker_sensor_match_ratio gauge
+field_worker_sensor_match_ratio{sensor_id="GA\"TE1",sensor_type="GATE"} 1
+field_worker_sensor_match_ratio{sensor_id="WLS1",sensor_type="WLS"} 0.5
+`
+
+func TestWrite(t *testing.T) {
+	var out strings.Builder
+	if err := Write(&out, sample); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.String() != sampleText {
+		t.Fatalf("unexpected exposition:\n%s", out.String())
+	}
+}
+
+func TestHandlerAndWriteFile(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(func() []Family { return sample }).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") || rec.Body.String() != sampleText {
+		t.Fatalf("unexpected response %q: %s", rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "textfile", "field_worker.prom")
+	if err := WriteFile(path, sample); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != sampleText {
+		t.Fatalf("unexpected file %q: %v", data, err)
+	}
+	if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+		t.Fatalf("expected the partial file to be gone")
+	}
+}
+
+func TestStartServesHistogramInOrder(t *testing.T) {
+	histogram := []Family{{Name: "field_worker_archive_duration_seconds", Type: "histogram", Samples: []Sample{
+		{Suffix: "_bucket", Labels: map[string]string{"le": "5"}, Value: 1},
+		{Suffix: "_bucket", Labels: map[string]string{"le": "15"}, Value: 2},
+		{Suffix: "_bucket", Labels: map[string]string{"le": "+Inf"}, Value: 2},
+		{Suffix: "_sum", Value: 12.5},
+		{Suffix: "_count", Value: 2},
+	}}}
+	server, err := Start("127.0.0.1:0", func() []Family { return histogram })
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer server.Close()
+	resp, err := http.Get("http://" + server.Addr() + "/metrics")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	want := `# HELP field_worker_archive_duration_seconds 
+# TYPE field_worker_archive_duration_seconds histogram
+field_worker_archive_duration_seconds_bucket{le="5"} 1
+field_worker_archive_duration_seconds_bucket{le="15"} 2
+field_worker_archive_duration_seconds_bucket{le="+Inf"} 2
+field_worker_archive_duration_seconds_sum 12.5
+field_worker_archive_duration_seconds_count 2
+`
+	if string(body) != want {
+		t.Fatalf("unexpected exposition:\n%s", body)
+	}
+}
+
+func TestStartServesAddedHandler(t *testing.T) {
+	server, err := Start("127.0.0.1:0", func() []Family { return sample })
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer server.Close()
+	server.Handle("/status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	resp, err := http.Get("http://" + server.Addr() + "/status")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Fatalf("expected the added handler, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Server serves /metrics on its own listener, apart from the pprof
+// endpoints, so it can be exposed to the Prometheus server alone.
+type Server struct {
+	http     *http.Server
+	mux      *http.ServeMux
+	listener net.Listener
+}
+
+// Start listens on addr (e.g. ":9464") and serves the families collect
+// returns at /metrics in the background. Binding errors are returned
+// immediately.
+func Start(addr string, collect func() []Family) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listen %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(collect))
+	server := &Server{
+		http:     &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		mux:      mux,
+		listener: listener,
+	}
+	go func() {
+		if err := server.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+// Handle adds a handler next to /metrics, e.g. the worker's /status.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Addr is the bound address, useful when addr used port 0.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.http.Shutdown(ctx)
+}
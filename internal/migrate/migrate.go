@@ -0,0 +1,142 @@
+// Package migrate brings a sqlite database's schema forward through
+// numbered migrations. The versions applied are recorded in the
+// schema_version table, so each migration runs once per database no matter
+// how many workers open it.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNewerSchema means the database was migrated by a newer build than this
+// one, which does not know what its schema looks like.
+var ErrNewerSchema = errors.New("database schema is newer than this build")
+
+// Migration is one schema change. Versions start at 1 and increase by one;
+// Apply runs in a transaction that also records the version, so a failed
+// migration leaves nothing behind.
+type Migration struct {
+	Version int
+	Name    string
+	Apply   func(ctx context.Context, tx *sql.Tx) error
+}
+
+// Applied is a migration recorded in schema_version.
+type Applied struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// Up applies the migrations not yet recorded in the database, in version
+// order, and returns those it applied.
+func Up(ctx context.Context, db *sql.DB, migrations []Migration) ([]Migration, error) {
+	if err := check(migrations); err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT,
+			applied_at TEXT
+		)
+	`); err != nil {
+		return nil, err
+	}
+	current, err := Version(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if current > len(migrations) {
+		return nil, fmt.Errorf("%w: database is at version %d, this build knows %d", ErrNewerSchema, current, len(migrations))
+	}
+	var applied []Migration
+	for _, m := range migrations[current:] {
+		ok, err := apply(ctx, db, m)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// apply runs m unless another process recorded it since Up looked, which
+// the write lock taken by the transaction rules out from then on.
+func apply(ctx context.Context, db *sql.DB, m Migration) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var done int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_version WHERE version = ?`, m.Version).Scan(&done); err != nil {
+		return false, err
+	}
+	if done > 0 {
+		return false, nil
+	}
+	if err := m.Apply(ctx, tx); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now().Format(time.RFC3339Nano)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func check(migrations []Migration) error {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Apply == nil {
+			return fmt.Errorf("migration %d (%s) has no Apply", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// Version returns the highest version recorded in the database, 0 for a
+// database that was never migrated.
+func Version(ctx context.Context, db *sql.DB) (int, error) {
+	var exists int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&exists); err != nil || exists == 0 {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// History lists the migrations recorded in the database in version order.
+func History(ctx context.Context, db *sql.DB) ([]Applied, error) {
+	if version, err := Version(ctx, db); err != nil || version == 0 {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_version ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var history []Applied
+	for rows.Next() {
+		var entry Applied
+		var appliedAt string
+		if err := rows.Scan(&entry.Version, &entry.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		entry.AppliedAt, _ = time.Parse(time.RFC3339Nano, appliedAt)
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
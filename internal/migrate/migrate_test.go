@@ -0,0 +1,90 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func exec(query string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query)
+		return err
+	}
+}
+
+func TestUpAppliesPendingMigrationsOnce(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	migrations := []Migration{
+		{Version: 1, Name: "things", Apply: exec(`CREATE TABLE things (id INTEGER PRIMARY KEY)`)},
+	}
+	if applied, err := Up(ctx, db, migrations); err != nil || len(applied) != 1 {
+		t.Fatalf("first Up applied %d, %v", len(applied), err)
+	}
+
+	migrations = append(migrations, Migration{Version: 2, Name: "things.name", Apply: exec(`ALTER TABLE things ADD COLUMN name TEXT`)})
+	applied, err := Up(ctx, db, migrations)
+	if err != nil || len(applied) != 1 || applied[0].Version != 2 {
+		t.Fatalf("second Up applied %+v, %v", applied, err)
+	}
+	if applied, err := Up(ctx, db, migrations); err != nil || len(applied) != 0 {
+		t.Fatalf("third Up applied %d, %v", len(applied), err)
+	}
+	history, err := History(ctx, db)
+	if err != nil || len(history) != 2 || history[1].Name != "things.name" || history[1].AppliedAt.IsZero() {
+		t.Fatalf("unexpected history %+v, %v", history, err)
+	}
+	if _, err := Up(ctx, db, migrations[:1]); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("expected ErrNewerSchema, got %v", err)
+	}
+}
+
+func TestUpRollsBackFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	migrations := []Migration{
+		{Version: 1, Name: "half", Apply: func(ctx context.Context, tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `CREATE TABLE half (id INTEGER)`); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `ALTER TABLE missing ADD COLUMN x TEXT`)
+			return err
+		}},
+	}
+	if _, err := Up(ctx, db, migrations); err == nil {
+		t.Fatal("expected the migration to fail")
+	}
+	if version, err := Version(ctx, db); err != nil || version != 0 {
+		t.Fatalf("version %d, %v after a failed migration", version, err)
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'half'`).Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("failed migration left its table behind: %d, %v", tables, err)
+	}
+}
+
+func TestUpRejectsMisnumberedMigrations(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Up(context.Background(), db, []Migration{{Version: 2, Name: "gap", Apply: exec(`SELECT 1`)}}); err == nil {
+		t.Fatal("expected a migration numbered 2 first to be rejected")
+	}
+	if version, err := Version(context.Background(), db); err != nil || version != 0 {
+		t.Fatalf("version of an unmigrated database: %d, %v", version, err)
+	}
+}
@@ -0,0 +1,208 @@
+// Package position parses the coordinate formats devices report, on both the
+// payload and the raw serial side, into decimal latitude and longitude so the
+// two can be compared by distance rather than as text.
+package position
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Point is a WGS84 coordinate in decimal degrees.
+type Point struct {
+	Lat float64
+	Lon float64
+}
+
+func (p Point) String() string {
+	return fmt.Sprintf("%.6f,%.6f", p.Lat, p.Lon)
+}
+
+var errFormat = errors.New("unrecognized coordinate format")
+
+// Parse accepts, case-insensitively and with or without spaces:
+//
+//	decimal degrees   37.5665,126.9780   37.5665N 126.9780E   -33.86;151.21
+//	DMS               37°33'59.4"N 126°58'40.8"E   37d33m59.4sN,126d58m40.8sE
+//	NMEA              $GPGGA,123519,3733.990,N,12658.680,E,...   3733.990,N,12658.680,E
+//	JSON              {"lat": 37.5665, "lon": 126.978}   [37.5665, 126.978]
+func Parse(value string) (Point, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Point{}, errors.New("empty coordinate")
+	}
+	var (
+		p   Point
+		err error
+	)
+	switch {
+	case value[0] == '{' || value[0] == '[':
+		p, err = parseJSON(value)
+	case strings.HasPrefix(value, "$") || nmeaPattern.MatchString(value):
+		p, err = parseNMEA(value)
+	case strings.ContainsAny(value, "°'\"′″") || dmsMarkers.MatchString(value):
+		p, err = parseDMS(value)
+	default:
+		p, err = parseDecimal(value)
+	}
+	if err != nil {
+		return Point{}, fmt.Errorf("%w: %q", err, value)
+	}
+	if math.IsNaN(p.Lat) || math.IsNaN(p.Lon) || math.Abs(p.Lat) > 90 || math.Abs(p.Lon) > 180 {
+		return Point{}, fmt.Errorf("coordinate out of range: %q", value)
+	}
+	return p, nil
+}
+
+// Distance is the great-circle distance between a and b in meters.
+func Distance(a, b Point) float64 {
+	const earthRadius = 6371008.8
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func parseJSON(value string) (Point, error) {
+	var list []float64
+	if err := json.Unmarshal([]byte(value), &list); err == nil {
+		if len(list) != 2 {
+			return Point{}, errFormat
+		}
+		return Point{Lat: list[0], Lon: list[1]}, nil
+	}
+	var object map[string]float64
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return Point{}, errFormat
+	}
+	lat, latOK := firstKey(object, "lat", "latitude")
+	lon, lonOK := firstKey(object, "lon", "lng", "longitude")
+	if !latOK || !lonOK {
+		return Point{}, errFormat
+	}
+	return Point{Lat: lat, Lon: lon}, nil
+}
+
+func firstKey(object map[string]float64, keys ...string) (float64, bool) {
+	for key, value := range object {
+		for _, want := range keys {
+			if strings.EqualFold(key, want) {
+				return value, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// nmeaPattern finds the ddmm.mmmm,N,dddmm.mmmm,E group of GGA, RMC and GLL
+// sentences.
+var nmeaPattern = regexp.MustCompile(`(?i)(\d{2})(\d{2}\.\d+),\s*([ns]),\s*(\d{3})(\d{2}\.\d+),\s*([ew])`)
+
+func parseNMEA(value string) (Point, error) {
+	m := nmeaPattern.FindStringSubmatch(value)
+	if m == nil {
+		return Point{}, errFormat
+	}
+	lat := degreesMinutes(m[1], m[2])
+	lon := degreesMinutes(m[4], m[5])
+	return Point{Lat: hemisphere(lat, m[3]), Lon: hemisphere(lon, m[6])}, nil
+}
+
+func degreesMinutes(degrees, minutes string) float64 {
+	d, _ := strconv.ParseFloat(degrees, 64)
+	m, _ := strconv.ParseFloat(minutes, 64)
+	return d + m/60
+}
+
+var (
+	dmsMarkers = regexp.MustCompile(`(?i)\d\s*d\s*\d`)
+	dmsPart    = regexp.MustCompile(`(?i)([nsew])?\s*(\d+(?:\.\d+)?)\s*(?:°|d)\s*(?:(\d+(?:\.\d+)?)\s*(?:'|′|m))?\s*(?:(\d+(?:\.\d+)?)\s*(?:"|″|''|s))?\s*([nsew])?`)
+)
+
+func parseDMS(value string) (Point, error) {
+	parts := dmsPart.FindAllStringSubmatch(value, -1)
+	if len(parts) != 2 {
+		return Point{}, errFormat
+	}
+	var coords [2]float64
+	var hemis [2]string
+	for i, m := range parts {
+		d, _ := strconv.ParseFloat(m[2], 64)
+		minutes, _ := strconv.ParseFloat(orZero(m[3]), 64)
+		seconds, _ := strconv.ParseFloat(orZero(m[4]), 64)
+		coords[i] = d + minutes/60 + seconds/3600
+		hemis[i] = strings.ToLower(m[1] + m[5])
+		if len(hemis[i]) > 1 {
+			return Point{}, errFormat
+		}
+	}
+	return orient(coords, hemis)
+}
+
+func orZero(value string) string {
+	if value == "" {
+		return "0"
+	}
+	return value
+}
+
+var decimalPart = regexp.MustCompile(`(?i)([nsew])?\s*([-+]?\d+(?:\.\d+)?)\s*([nsew])?`)
+
+func parseDecimal(value string) (Point, error) {
+	fields := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '\t' })
+	if len(fields) != 2 {
+		// "37.5n126.9e" once spaces were stripped.
+		if m := decimalPart.FindAllString(value, -1); len(m) == 2 {
+			fields = m
+		} else {
+			return Point{}, errFormat
+		}
+	}
+	var coords [2]float64
+	var hemis [2]string
+	for i, field := range fields {
+		m := decimalPart.FindStringSubmatch(field)
+		if m == nil || m[0] != strings.TrimSpace(field) {
+			return Point{}, errFormat
+		}
+		number, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			return Point{}, errFormat
+		}
+		coords[i] = number
+		hemis[i] = strings.ToLower(m[1] + m[3])
+		if len(hemis[i]) > 1 {
+			return Point{}, errFormat
+		}
+	}
+	return orient(coords, hemis)
+}
+
+// orient applies hemisphere letters and puts latitude first even when the
+// letters say the pair was written longitude first.
+func orient(coords [2]float64, hemis [2]string) (Point, error) {
+	for i := range coords {
+		coords[i] = hemisphere(coords[i], hemis[i])
+	}
+	if hemis[0] == "e" || hemis[0] == "w" || hemis[1] == "n" || hemis[1] == "s" {
+		if (hemis[0] != "" && hemis[0] != "e" && hemis[0] != "w") || (hemis[1] != "" && hemis[1] != "n" && hemis[1] != "s") {
+			return Point{}, errFormat
+		}
+		return Point{Lat: coords[1], Lon: coords[0]}, nil
+	}
+	return Point{Lat: coords[0], Lon: coords[1]}, nil
+}
+
+func hemisphere(value float64, letter string) float64 {
+	switch strings.ToLower(letter) {
+	case "s", "w":
+		return -math.Abs(value)
+	}
+	return value
+}
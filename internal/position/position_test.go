@@ -0,0 +1,67 @@
+package position
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b Point) bool {
+	return math.Abs(a.Lat-b.Lat) < 1e-5 && math.Abs(a.Lon-b.Lon) < 1e-5
+}
+
+func TestParseFormats(t *testing.T) {
+	seoul := Point{Lat: 37.5665, Lon: 126.978}
+	for _, value := range []string{
+		"37.5665,126.978",
+		"37.5665, 126.9780",
+		"37.5665N 126.978E",
+		"37.5665n,126.978e",
+		"37.5665n126.978e",
+		"126.978E 37.5665N",
+		`37°33'59.4"N 126°58'40.8"E`,
+		`37°33'59.4"n126°58'40.8"e`,
+		"37d33m59.4sN,126d58m40.8sE",
+		"$GPGGA,123519,3733.990,N,12658.680,E,1,08,0.9,545.4,M,46.9,M,,*47",
+		"3733.990,n,12658.680,e",
+		`{"lat": 37.5665, "lon": 126.978}`,
+		`{"latitude":37.5665,"longitude":126.978}`,
+		"[37.5665, 126.978]",
+	} {
+		got, err := Parse(value)
+		if err != nil {
+			t.Fatalf("%s: %v", value, err)
+		}
+		if !near(got, seoul) {
+			t.Fatalf("%s: got %s", value, got)
+		}
+	}
+}
+
+func TestParseHemispheres(t *testing.T) {
+	sydney := Point{Lat: -33.8688, Lon: 151.2093}
+	for _, value := range []string{"-33.8688;151.2093", "33.8688S 151.2093E", `33°52'7.68"S 151°12'33.48"E`, "3352.128,S,15112.558,E"} {
+		got, err := Parse(value)
+		if err != nil || !near(got, sydney) {
+			t.Fatalf("%s: got %s, %v", value, got, err)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, value := range []string{"", "open", "37.5", "95.0,10.0", "10.0,200.0", "1,2,3", "37.5N 10.0N", `{"lat": 1}`} {
+		if p, err := Parse(value); err == nil {
+			t.Fatalf("%q: expected error, got %s", value, p)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	a := Point{Lat: 37.5665, Lon: 126.978}
+	if d := Distance(a, a); d != 0 {
+		t.Fatalf("expected 0, got %v", d)
+	}
+	// 0.001 degree of latitude is about 111 m.
+	if d := Distance(a, Point{Lat: 37.5675, Lon: 126.978}); d < 110 || d > 112 {
+		t.Fatalf("expected about 111 m, got %v", d)
+	}
+}
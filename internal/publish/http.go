@@ -0,0 +1,333 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpBatch is the default number of records per request.
+const httpBatch = 500
+
+// httpRetries is how many more times a batch is sent after a failure that
+// may pass (a network error, 408, 429 or 5xx) before Publish gives up.
+const httpRetries = 5
+
+// httpRetryDelay is the first backoff; it doubles up to httpMaxDelay. A
+// Retry-After from the server replaces it, up to the same cap.
+var (
+	httpRetryDelay = time.Second
+	httpMaxDelay   = time.Minute
+)
+
+// httpSink posts batches of records to a customer's REST endpoint:
+//
+//	POST <url>
+//	Idempotency-Key: <sink id>-<batch>
+//	{"subject": "...", "batch": 42, "records": [{"key": "...", "value": {...}}]}
+//
+// Batch numbers restart with every sink, so the key carries a random sink
+// id: kept in the spool's id file with a spool, new for every sink without
+// one. Keys then differ across runs and workers, and stay the same when a
+// spooled batch is sent again.
+//
+// With a spool directory every batch is written there before it is sent
+// and removed once the endpoint accepted it; the number of the last
+// accepted batch is kept in the spool's high-water mark. Batches a failed
+// or interrupted run left behind are sent, in order, ahead of the next
+// ones, including after a restart. A batch the endpoint refuses outright
+// (any other 4xx) would fail the same way again, so it is moved to the
+// dead-letter directory spool/dead instead of holding up the rest.
+type httpSink struct {
+	endpoint string
+	subject  string
+	batch    int
+	spool    string
+	id       string
+	client   *http.Client
+
+	mu   sync.Mutex
+	next int64
+}
+
+type httpRequest struct {
+	Subject string        `json:"subject,omitempty"`
+	Batch   int64         `json:"batch"`
+	Records []kafkaRecord `json:"records"`
+}
+
+// permanentError is a response retrying cannot change.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func newHTTPSink(u *url.URL, subject string, opts Options) (*httpSink, error) {
+	s := &httpSink{endpoint: u.String(), subject: subject, batch: opts.Batch, spool: opts.Spool, client: &http.Client{Timeout: 30 * time.Second}}
+	if s.batch <= 0 {
+		s.batch = httpBatch
+	}
+	s.next = 1
+	if s.spool == "" {
+		s.id = newSinkID()
+		return s, nil
+	}
+	if err := os.MkdirAll(filepath.Join(s.spool, "dead"), 0o755); err != nil {
+		return nil, fmt.Errorf("publish: spool: %w", err)
+	}
+	id, err := s.spoolID()
+	if err != nil {
+		return nil, err
+	}
+	s.id = id
+	hwm, err := s.highWaterMark()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.pending()
+	if err != nil {
+		return nil, err
+	}
+	s.next = hwm + 1
+	if len(pending) > 0 {
+		s.next = max(s.next, pending[len(pending)-1]+1)
+		slog.Info("publish spool has undelivered batches", "spool", s.spool, "batches", len(pending))
+	}
+	return s, nil
+}
+
+// Publish sends messages in batches. With a spool, batches left from
+// earlier calls go first; a batch that still fails stays spooled and stops
+// the call, so later batches keep their order behind it.
+func (s *httpSink) Publish(ctx context.Context, messages []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var batches []httpRequest
+	for start := 0; start < len(messages); start += s.batch {
+		end := min(start+s.batch, len(messages))
+		request := httpRequest{Subject: s.subject, Batch: s.next}
+		for _, message := range messages[start:end] {
+			request.Records = append(request.Records, kafkaRecord{Key: message.Key, Value: message.Value})
+		}
+		s.next++
+		batches = append(batches, request)
+	}
+	if s.spool == "" {
+		for _, request := range batches {
+			body, err := json.Marshal(request)
+			if err != nil {
+				return err
+			}
+			if err := s.send(ctx, request.Batch, body); err != nil {
+				return fmt.Errorf("publish: http %s: batch %d: %w", s.endpoint, request.Batch, err)
+			}
+		}
+		return nil
+	}
+
+	for _, request := range batches {
+		body, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		if err := writeAtomic(s.batchPath(request.Batch), body); err != nil {
+			return fmt.Errorf("publish: spool: %w", err)
+		}
+	}
+	return s.flush(ctx)
+}
+
+// flush sends the spooled batches, oldest first.
+func (s *httpSink) flush(ctx context.Context) error {
+	pending, err := s.pending()
+	if err != nil {
+		return err
+	}
+	var dead []error
+	for _, batch := range pending {
+		path := s.batchPath(batch)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("publish: spool: %w", err)
+		}
+		err = s.send(ctx, batch, body)
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			if err := os.Rename(path, filepath.Join(s.spool, "dead", filepath.Base(path))); err != nil {
+				return fmt.Errorf("publish: spool: %w", err)
+			}
+			slog.Warn("publish batch dead-lettered", "batch", batch, "spool", s.spool, "error", err)
+			dead = append(dead, fmt.Errorf("batch %d: %w", batch, err))
+		} else if err != nil {
+			return fmt.Errorf("publish: http %s: batch %d (%d spooled): %w", s.endpoint, batch, len(pending), err)
+		} else if err := os.Remove(path); err != nil {
+			return fmt.Errorf("publish: spool: %w", err)
+		}
+		if err := writeAtomic(filepath.Join(s.spool, "hwm"), []byte(strconv.FormatInt(batch, 10)+"\n")); err != nil {
+			return fmt.Errorf("publish: spool: %w", err)
+		}
+	}
+	if len(dead) > 0 {
+		return fmt.Errorf("publish: http %s: dead-lettered %w", s.endpoint, errors.Join(dead...))
+	}
+	return nil
+}
+
+// send posts one batch, retrying failures that may pass. A 429 or 503 is
+// the endpoint asking to slow down, so its Retry-After is honored.
+func (s *httpSink) send(ctx context.Context, batch int64, body []byte) error {
+	delay := httpRetryDelay
+	for attempt := 0; ; attempt++ {
+		wait, err := s.post(ctx, batch, body)
+		var permanent permanentError
+		if err == nil || errors.As(err, &permanent) || attempt == httpRetries || ctx.Err() != nil {
+			return err
+		}
+		if wait < 0 {
+			wait = delay
+			delay = min(delay*2, httpMaxDelay)
+		}
+		slog.Debug("publish batch retrying", "batch", batch, "attempt", attempt+1, "delay", wait, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// post sends one request. It returns the Retry-After of the response, or a
+// negative wait when there was none.
+func (s *httpSink) post(ctx context.Context, batch int64, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return -1, permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", s.id+"-"+strconv.FormatInt(batch, 10))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode/100 == 2 {
+		return -1, nil
+	}
+	err = fmt.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		return retryAfter(resp.Header.Get("Retry-After")), err
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode >= 500:
+		return -1, err
+	}
+	return -1, permanentError{err}
+}
+
+// retryAfter reads a Retry-After header given in seconds or as a date.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return -1
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return min(time.Duration(seconds)*time.Second, httpMaxDelay)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return min(max(time.Until(at), 0), httpMaxDelay)
+	}
+	return -1
+}
+
+func (s *httpSink) batchPath(batch int64) string {
+	return filepath.Join(s.spool, fmt.Sprintf("%020d.json", batch))
+}
+
+// pending lists the spooled batch numbers in order.
+func (s *httpSink) pending() ([]int64, error) {
+	entries, err := os.ReadDir(s.spool)
+	if err != nil {
+		return nil, fmt.Errorf("publish: spool: %w", err)
+	}
+	var batches []int64
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		if batch, err := strconv.ParseInt(name, 10, 64); err == nil {
+			batches = append(batches, batch)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i] < batches[j] })
+	return batches, nil
+}
+
+// highWaterMark is the last batch the endpoint accepted or refused, 0 for
+// a new spool.
+func (s *httpSink) highWaterMark() (int64, error) {
+	data, err := os.ReadFile(filepath.Join(s.spool, "hwm"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("publish: spool: %w", err)
+	}
+	hwm, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("publish: spool: high-water mark %q: %w", strings.TrimSpace(string(data)), err)
+	}
+	return hwm, nil
+}
+
+// spoolID reads the sink id kept in the spool, creating it for a new spool.
+func (s *httpSink) spoolID() (string, error) {
+	path := filepath.Join(s.spool, "id")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("publish: spool: %w", err)
+	}
+	id := newSinkID()
+	if err := writeAtomic(path, []byte(id+"\n")); err != nil {
+		return "", fmt.Errorf("publish: spool: %w", err)
+	}
+	return id, nil
+}
+
+func newSinkID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func (s *httpSink) Close() error {
+	return nil
+}
+
+// writeAtomic replaces path with data through a rename, so a crash leaves
+// either the old or the new file.
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,92 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// kafkaBatch caps the records sent in one REST proxy request.
+const kafkaBatch = 500
+
+// kafkaREST produces to a topic through the Confluent REST proxy v2 API
+// (POST /topics/<topic> with application/vnd.kafka.json.v2+json).
+type kafkaREST struct {
+	endpoint string
+	client   *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaResponse struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func newKafkaREST(u *url.URL, topic string) *kafkaREST {
+	base := *u
+	base.Scheme = strings.TrimPrefix(strings.ToLower(u.Scheme), "kafka+")
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/topics/" + url.PathEscape(topic)
+	return &kafkaREST{endpoint: base.String(), client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (k *kafkaREST) Publish(ctx context.Context, messages []Message) error {
+	for start := 0; start < len(messages); start += kafkaBatch {
+		end := min(start+kafkaBatch, len(messages))
+		if err := k.post(ctx, messages[start:end]); err != nil {
+			return fmt.Errorf("publish: kafka %s: %w", k.endpoint, err)
+		}
+	}
+	return nil
+}
+
+func (k *kafkaREST) post(ctx context.Context, messages []Message) error {
+	records := make([]kafkaRecord, len(messages))
+	for i, message := range messages {
+		records[i] = kafkaRecord{Key: message.Key, Value: message.Value}
+	}
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	// The proxy answers 200 even when single records failed.
+	var result kafkaResponse
+	if err := json.Unmarshal(data, &result); err == nil {
+		for _, offset := range result.Offsets {
+			if offset.ErrorCode != nil {
+				return fmt.Errorf("record rejected: %s (code %d)", offset.Error, *offset.ErrorCode)
+			}
+		}
+	}
+	return nil
+}
+
+func (k *kafkaREST) Close() error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package publish
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const natsDialTimeout = 10 * time.Second
+
+// natsPublisher speaks the NATS client protocol: CONNECT once, then PUB per
+// message and a PING whose PONG confirms the server has processed them.
+// The connection is opened lazily and reopened after an error.
+type natsPublisher struct {
+	addr    string
+	subject string
+	connect []byte
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func newNATS(u *url.URL, subject string) (*natsPublisher, error) {
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("publish: nats subject %q must not contain whitespace", subject)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	options := map[string]any{"verbose": false, "pedantic": false, "name": "field-worker", "lang": "go"}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options["user"] = u.User.Username()
+			options["pass"] = password
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{addr: host, subject: subject, connect: connect}, nil
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	if err := p.dial(ctx); err != nil {
+		return err
+	}
+	if err := p.publish(ctx, messages); err != nil {
+		p.Close()
+		return fmt.Errorf("publish: nats %s: %w", p.addr, err)
+	}
+	return nil
+}
+
+func (p *natsPublisher) dial(ctx context.Context) error {
+	if p.conn != nil {
+		return nil
+	}
+	dialer := net.Dialer{Timeout: natsDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return fmt.Errorf("publish: nats %s: %w", p.addr, err)
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	line, err := reader.ReadString('\n')
+	if err == nil && !strings.HasPrefix(line, "INFO ") {
+		err = fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if err == nil {
+		var info struct {
+			TLSRequired bool `json:"tls_required"`
+		}
+		json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+		if info.TLSRequired {
+			err = errors.New("server requires TLS, which is not supported")
+		}
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(conn, "CONNECT %s\r\n", p.connect)
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("publish: nats %s: %w", p.addr, err)
+	}
+	p.conn, p.reader = conn, reader
+	return nil
+}
+
+func (p *natsPublisher) publish(ctx context.Context, messages []Message) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	p.conn.SetDeadline(deadline)
+	writer := bufio.NewWriter(p.conn)
+	for _, message := range messages {
+		fmt.Fprintf(writer, "PUB %s %d\r\n", p.subject, len(message.Value))
+		writer.Write(message.Value)
+		writer.WriteString("\r\n")
+	}
+	writer.WriteString("PING\r\n")
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (p *natsPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.reader = nil, nil
+	return err
+}
@@ -0,0 +1,63 @@
+// Package publish sends comparison results to an event bus so downstream
+// services can consume them as archives are ingested. Two transports are
+// built in, both on the standard library: the NATS client protocol and the
+// Kafka REST proxy (v2 JSON API). A plain http(s) url posts batches to a
+// customer's REST endpoint instead, with retries and an on-disk spool.
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Message is one record to publish. Key groups records of the same device
+// (the Kafka partition key); NATS ignores it.
+type Message struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// Publisher delivers messages to a fixed subject or topic. Publish returns
+// once the server has accepted every message.
+type Publisher interface {
+	Publish(ctx context.Context, messages []Message) error
+	Close() error
+}
+
+// Options tune the http(s) publisher; the others ignore them.
+type Options struct {
+	// Batch is the number of records per request (500 when not positive).
+	Batch int
+	// Spool, when set, is the directory batches wait in until the endpoint
+	// accepted them, so they survive failures and restarts.
+	Spool string
+}
+
+// Open returns the publisher for rawURL:
+//
+//	nats://[user:pass@]host:4222        NATS subject
+//	kafka+http://proxy:8082             Kafka topic via the REST proxy
+//	kafka+https://proxy:8082
+//	http(s)://api.example.com/results   REST endpoint; subject is optional
+func Open(rawURL, subject string, opts Options) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if subject == "" && scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("publish: subject is required")
+	}
+	switch scheme {
+	case "nats":
+		return newNATS(u, subject)
+	case "kafka+http", "kafka+https":
+		return newKafkaREST(u, subject), nil
+	case "http", "https":
+		return newHTTPSink(u, subject, opts)
+	}
+	return nil, fmt.Errorf("publish: unsupported url scheme %q (want nats, kafka+http, kafka+https, http or https)", u.Scheme)
+}
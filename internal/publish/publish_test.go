@@ -0,0 +1,240 @@
+package publish
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts one connection and records the payload of every PUB.
+func fakeNATS(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	published := make(chan string, 16)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 3 && fields[0] == "PUB":
+				size, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, payload); err != nil {
+					return
+				}
+				published <- fields[1] + " " + string(payload[:size])
+			case len(fields) == 1 && fields[0] == "PING":
+				conn.Write([]byte("PONG\r\n"))
+			}
+		}
+	}()
+	return "nats://" + listener.Addr().String(), published
+}
+
+func TestNATSPublish(t *testing.T) {
+	addr, published := fakeNATS(t)
+	p, err := Open(addr, "field.comparisons", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	messages := []Message{{Value: json.RawMessage(`{"result":"MATCH"}`)}, {Value: json.RawMessage(`{"result":"MISMATCH"}`)}}
+	if err := p.Publish(context.Background(), messages); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	for _, want := range []string{`field.comparisons {"result":"MATCH"}`, `field.comparisons {"result":"MISMATCH"}`} {
+		if got := <-published; got != want {
+			t.Fatalf("published %q, want %q", got, want)
+		}
+	}
+}
+
+func TestKafkaRESTPublish(t *testing.T) {
+	var got struct {
+		Records []kafkaRecord `json:"records"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/field.comparisons" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("content type %q", ct)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer server.Close()
+
+	p, err := Open("kafka+"+server.URL, "field.comparisons", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(context.Background(), []Message{{Key: "siteA/device01", Value: json.RawMessage(`{"result":"MATCH"}`)}}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if len(got.Records) != 1 || got.Records[0].Key != "siteA/device01" || string(got.Records[0].Value) != `{"result":"MATCH"}` {
+		t.Fatalf("records = %+v", got.Records)
+	}
+}
+
+func TestKafkaRESTRecordError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"offsets":[{"error_code":40403,"error":"topic not found"}]}`))
+	}))
+	defer server.Close()
+	p, _ := Open("kafka+"+server.URL, "missing", Options{})
+	err := p.Publish(context.Background(), []Message{{Value: json.RawMessage(`{}`)}})
+	if err == nil || !strings.Contains(err.Error(), "topic not found") {
+		t.Fatalf("err = %v, want topic not found", err)
+	}
+}
+
+func TestOpenRejectsUnknownScheme(t *testing.T) {
+	if _, err := Open("amqp://broker", "x", Options{}); err == nil {
+		t.Fatal("expected an error for amqp")
+	}
+}
+
+func TestHTTPSinkRetriesAndHonorsBackpressure(t *testing.T) {
+	httpRetryDelay = time.Millisecond
+	var requests []httpRequest
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		var request httpRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if key := r.Header.Get("Idempotency-Key"); !strings.HasSuffix(key, "-"+strconv.FormatInt(request.Batch, 10)) {
+			t.Errorf("idempotency key %q for batch %d", key, request.Batch)
+		}
+		requests = append(requests, request)
+	}))
+	defer server.Close()
+
+	p, err := Open(server.URL+"/results", "", Options{Batch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []Message{{Value: json.RawMessage(`1`)}, {Value: json.RawMessage(`2`)}, {Value: json.RawMessage(`3`)}}
+	if err := p.Publish(context.Background(), messages); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if calls != 3 || len(requests) != 2 || len(requests[0].Records) != 2 || requests[1].Batch != 2 || string(requests[1].Records[0].Value) != "3" {
+		t.Fatalf("%d calls, requests %+v", calls, requests)
+	}
+}
+
+func TestHTTPSinkKeysAreUniquePerSink(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+	}))
+	defer server.Close()
+
+	// Two runs, or two workers, without a spool both start at batch 1.
+	messages := []Message{{Value: json.RawMessage(`1`)}}
+	for i := 0; i < 2; i++ {
+		p, err := Open(server.URL, "", Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Publish(context.Background(), messages); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	if len(keys) != 2 || keys[0] == keys[1] || !strings.HasSuffix(keys[0], "-1") || !strings.HasSuffix(keys[1], "-1") {
+		t.Fatalf("keys %q, want two distinct keys for batch 1", keys)
+	}
+
+	// A spooled sink keeps its id across restarts.
+	spool := t.TempDir()
+	keys = nil
+	for i := 0; i < 2; i++ {
+		p, _ := Open(server.URL, "", Options{Spool: spool})
+		if err := p.Publish(context.Background(), messages); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	id, _, _ := strings.Cut(keys[0], "-")
+	if len(keys) != 2 || keys[1] != id+"-2" {
+		t.Fatalf("spooled keys %q", keys)
+	}
+}
+
+func TestHTTPSinkSpoolsUntilDelivered(t *testing.T) {
+	httpRetryDelay = time.Millisecond
+	spool := t.TempDir()
+	status := http.StatusBadGateway
+	var delivered []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request httpRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if string(request.Records[0].Value) == `"bad"` {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			delivered = append(delivered, request.Batch)
+		}
+	}))
+	defer server.Close()
+
+	p, _ := Open(server.URL, "field.comparisons", Options{Spool: spool})
+	if err := p.Publish(context.Background(), []Message{{Value: json.RawMessage(`1`)}}); err == nil {
+		t.Fatal("expected the publish to fail")
+	}
+	if _, err := os.Stat(filepath.Join(spool, fmt.Sprintf("%020d.json", 1))); err != nil {
+		t.Fatalf("expected the batch to stay spooled: %v", err)
+	}
+
+	// A restarted worker sends the spooled batch ahead of its own; one
+	// the endpoint refuses is dead-lettered without blocking the next.
+	status = http.StatusOK
+	p, _ = Open(server.URL, "field.comparisons", Options{Spool: spool})
+	err := p.Publish(context.Background(), []Message{{Value: json.RawMessage(`"bad"`)}})
+	if err == nil || !strings.Contains(err.Error(), "dead-lettered") {
+		t.Fatalf("err = %v, want dead-lettered", err)
+	}
+	if err := p.Publish(context.Background(), []Message{{Value: json.RawMessage(`3`)}}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if fmt.Sprint(delivered) != "[1 3]" {
+		t.Fatalf("delivered batches %v", delivered)
+	}
+	if _, err := os.Stat(filepath.Join(spool, "dead", fmt.Sprintf("%020d.json", 2))); err != nil {
+		t.Fatalf("expected batch 2 in dead: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(spool, "hwm")); strings.TrimSpace(string(data)) != "3" {
+		t.Fatalf("high-water mark %q", data)
+	}
+}
@@ -0,0 +1,222 @@
+// Package receipt is the hand-off between the ingest worker and the field
+// client: the worker writes <archive>.receipt.json for every archive it
+// finishes, and the client deletes its local copy of an archive only after
+// it has collected a successful receipt for it. The same receipt is kept
+// next to each archive in the worker's done directory.
+package receipt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Suffix is appended to the archive name to form the receipt file name.
+const Suffix = ".receipt.json"
+
+// Receipt statuses. StatusRetry means the archive was left in incoming and a
+// later run will try it again, so the client must keep its copy.
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+	StatusRetry  = "retry"
+)
+
+type Receipt struct {
+	Archive string           `json:"archive"`
+	Status  string           `json:"status"`
+	Stage   string           `json:"stage,omitempty"`
+	Error   string           `json:"error,omitempty"`
+	Rows    map[string]int64 `json:"rows,omitempty"`
+	// AuditID is the archive's ingest_log row, set once it was ingested.
+	AuditID       int64     `json:"audit_id,omitempty"`
+	DurationMS    int64     `json:"duration_ms"`
+	ServerTime    time.Time `json:"server_time"`
+	WorkerVersion string    `json:"worker_version,omitempty"`
+	// Attempts is how many runs have failed the archive so far.
+	Attempts int `json:"attempts,omitempty"`
+	// Duplicate means the archive had already been ingested with the same
+	// content; AuditID is that earlier ingest and nothing was stored.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// OK reports whether the archive was fully ingested.
+func (r Receipt) OK() bool {
+	return r.Status == StatusOK
+}
+
+// rejectStages are the worker stages that check the archive itself; a
+// failure there means the upload arrived but the package is broken.
+var rejectStages = []string{"extract", "manifest", "name"}
+
+// Rejected reports whether the worker refused the archive as packaged
+// (unreadable zip, manifest mismatch, bad name), as opposed to failing while
+// storing it.
+func (r Receipt) Rejected() bool {
+	if r.Status != StatusFailed {
+		return false
+	}
+	for _, stage := range rejectStages {
+		if r.Stage == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Name is the receipt file name for an archive.
+func Name(archive string) string {
+	return archive + Suffix
+}
+
+// Write stores r in dir, replacing any earlier receipt for the same archive.
+// The file is renamed into place so readers never see a partial receipt.
+func Write(dir string, r Receipt) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, Name(r.Archive))
+	partial := path + ".partial"
+	if err := os.WriteFile(partial, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+func Read(path string) (Receipt, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Receipt{}, err
+	}
+	var r Receipt
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Receipt{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if r.Archive == "" || Name(r.Archive) != filepath.Base(path) {
+		return Receipt{}, fmt.Errorf("%s: receipt names archive %q", path, r.Archive)
+	}
+	return r, nil
+}
+
+// List returns the receipt files in dir, sorted.
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), Suffix) {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Await polls dir every interval until a final receipt for archive appears
+// and returns it. Retry receipts mean the worker will try again, so Await
+// keeps waiting. It returns ctx.Err() when ctx ends first.
+func Await(ctx context.Context, dir, archive string, interval time.Duration) (Receipt, error) {
+	path := filepath.Join(dir, Name(archive))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r, err := Read(path)
+		switch {
+		case err == nil && r.Status != StatusRetry:
+			return r, nil
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return Receipt{}, err
+		}
+		select {
+		case <-ctx.Done():
+			return Receipt{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Result is what Collect did for one receipt.
+type Result struct {
+	Receipt Receipt
+	// Deleted is set when the local archive was removed (or would have been,
+	// in a dry run).
+	Deleted bool
+	// Held is set when the receipt is ok but hold kept the archive.
+	Held bool
+}
+
+// Collect copies every receipt in receiptsDir into outboxDir/receipts and,
+// for successful ones, deletes outboxDir/<archive>. Archives without a
+// receipt, or whose receipt is not ok, are left alone, as are those hold
+// (when not nil) reports true for, e.g. because another upload target has
+// not received them yet. With dryRun nothing is copied or deleted.
+func Collect(receiptsDir, outboxDir string, dryRun bool, hold func(archive string) bool) ([]Result, error) {
+	paths, err := List(receiptsDir)
+	if err != nil {
+		return nil, err
+	}
+	localDir := filepath.Join(outboxDir, "receipts")
+	var results []Result
+	for _, path := range paths {
+		r, err := Read(path)
+		if err != nil {
+			return results, err
+		}
+		result := Result{Receipt: r}
+		if !dryRun {
+			if err := copyFile(path, filepath.Join(localDir, filepath.Base(path))); err != nil {
+				return results, err
+			}
+		}
+		if r.OK() {
+			local := filepath.Join(outboxDir, r.Archive)
+			if _, err := os.Stat(local); err == nil && hold != nil && hold(r.Archive) {
+				result.Held = true
+			} else if err == nil {
+				result.Deleted = true
+				if !dryRun {
+					if err := os.Remove(local); err != nil {
+						return results, err
+					}
+				}
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return results, err
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,140 @@
+package receipt
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	want := Receipt{Archive: "siteA_device01_20260120.zip", Status: StatusOK, Rows: map[string]int64{"snapshots": 2}, ServerTime: time.Date(2026, 1, 21, 0, 0, 0, 0, time.UTC)}
+	if err := Write(dir, want); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	paths, err := List(dir)
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("list: %v %v", paths, err)
+	}
+	got, err := Read(paths[0])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got.Archive != want.Archive || !got.OK() || got.Rows["snapshots"] != 2 || !got.ServerTime.Equal(want.ServerTime) {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestReadRejectsMismatchedArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), Name("a.zip"))
+	if err := os.WriteFile(path, []byte(`{"archive": "../b.zip", "status": "ok"}`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := Read(path); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCollectDeletesOnlyIngestedArchives(t *testing.T) {
+	receipts, outbox := t.TempDir(), t.TempDir()
+	for name, status := range map[string]string{"ok.zip": StatusOK, "bad.zip": StatusFailed, "busy.zip": StatusRetry} {
+		if err := Write(receipts, Receipt{Archive: name, Status: status}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for _, name := range []string{"ok.zip", "bad.zip", "busy.zip", "pending.zip"} {
+		if err := os.WriteFile(filepath.Join(outbox, name), []byte("zip"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	results, err := Collect(receipts, outbox, true, nil)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("dry run: %v %v", results, err)
+	}
+	if _, err := os.Stat(filepath.Join(outbox, "ok.zip")); err != nil {
+		t.Fatalf("dry run deleted the archive: %v", err)
+	}
+
+	results, err = Collect(receipts, outbox, false, nil)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	deleted := map[string]bool{}
+	for _, result := range results {
+		deleted[result.Receipt.Archive] = result.Deleted
+	}
+	for name, want := range map[string]bool{"ok.zip": true, "bad.zip": false, "busy.zip": false} {
+		if deleted[name] != want {
+			t.Fatalf("%s: deleted=%v", name, deleted[name])
+		}
+		_, err := os.Stat(filepath.Join(outbox, name))
+		if exists := err == nil; exists == want {
+			t.Fatalf("%s: exists=%v", name, exists)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outbox, "pending.zip")); err != nil {
+		t.Fatalf("archive without receipt was touched: %v", err)
+	}
+	if copied, _ := List(filepath.Join(outbox, "receipts")); len(copied) != 3 {
+		t.Fatalf("expected receipts copied locally, got %v", copied)
+	}
+}
+
+func TestCollectHoldsUndeliveredArchives(t *testing.T) {
+	receipts, outbox := t.TempDir(), t.TempDir()
+	for _, name := range []string{"sent.zip", "unsent.zip"} {
+		if err := Write(receipts, Receipt{Archive: name, Status: StatusOK}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(outbox, name), []byte("zip"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	results, err := Collect(receipts, outbox, false, func(archive string) bool { return archive == "unsent.zip" })
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	for _, result := range results {
+		held := result.Receipt.Archive == "unsent.zip"
+		if result.Held != held || result.Deleted == held {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outbox, "unsent.zip")); err != nil {
+		t.Fatalf("held archive was deleted: %v", err)
+	}
+}
+
+func TestAwaitWaitsPastRetry(t *testing.T) {
+	dir := t.TempDir()
+	const archive = "siteA_device01_20260120.zip"
+	if err := Write(dir, Receipt{Archive: archive, Status: StatusRetry}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		Write(dir, Receipt{Archive: archive, Status: StatusFailed, Stage: "manifest", Error: "manifest mismatch"})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := Await(ctx, dir, archive, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("await: %v", err)
+	}
+	if !got.Rejected() || got.OK() {
+		t.Fatalf("expected a rejected receipt, got %+v", got)
+	}
+	if (Receipt{Status: StatusFailed, Stage: "compare"}).Rejected() {
+		t.Fatal("a failure while storing is not a rejected package")
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancelShort()
+	if _, err := Await(short, dir, "other.zip", 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// PayloadError is a payload that does not have the SensorPayload shape the
+// worker compares: Field names the offending member ("cmd", "time",
+// "data", "data.id", ...) and Index the data item, -1 for the others.
+type PayloadError struct {
+	Field string
+	Index int
+	Msg   string
+}
+
+func (e *PayloadError) Error() string {
+	if e.Index >= 0 {
+		return fmt.Sprintf("data[%d]: %s %s", e.Index, e.Field, e.Msg)
+	}
+	return e.Field + " " + e.Msg
+}
+
+// Kind is the error without the item index, for counting alike problems.
+func (e *PayloadError) Kind() string {
+	return e.Field + " " + e.Msg
+}
+
+// CheckPayload reports the first way payload differs from what the worker
+// expects: an object with a non-empty cmd, a PublishAt or time string, and
+// a data array of objects with an integer id, a string type when set and a
+// scalar value or an array of scalars.
+func CheckPayload(payload json.RawMessage) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &members); err != nil || members == nil {
+		return &PayloadError{Field: "payload", Index: -1, Msg: "is not an object"}
+	}
+	var cmd string
+	if json.Unmarshal(members["cmd"], &cmd) != nil || cmd == "" {
+		return &PayloadError{Field: "cmd", Index: -1, Msg: "is missing or not a string"}
+	}
+	var publishAt, at string
+	json.Unmarshal(members["PublishAt"], &publishAt)
+	json.Unmarshal(members["time"], &at)
+	if publishAt == "" && at == "" {
+		return &PayloadError{Field: "time", Index: -1, Msg: "has neither PublishAt nor time"}
+	}
+	if raw, ok := members["work_field"]; ok && !isKind(raw, '"') {
+		return &PayloadError{Field: "work_field", Index: -1, Msg: "is not a string"}
+	}
+	var items []json.RawMessage
+	if json.Unmarshal(members["data"], &items) != nil || !isKind(members["data"], '[') {
+		return &PayloadError{Field: "data", Index: -1, Msg: "is missing or not an array"}
+	}
+	for i, item := range items {
+		if err := checkDataItem(item); err != nil {
+			err.Index = i
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDataItem(item json.RawMessage) *PayloadError {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(item, &members); err != nil || members == nil {
+		return &PayloadError{Field: "item", Msg: "is not an object"}
+	}
+	var id int
+	if json.Unmarshal(members["id"], &id) != nil || isKind(members["id"], 'n') {
+		return &PayloadError{Field: "id", Msg: "is missing or not an integer"}
+	}
+	if raw, ok := members["type"]; ok && !isKind(raw, '"') && !isKind(raw, 'n') {
+		return &PayloadError{Field: "type", Msg: "is not a string"}
+	}
+	if raw, ok := members["value"]; ok {
+		if isKind(raw, '{') {
+			return &PayloadError{Field: "value", Msg: "is an object"}
+		}
+		if isKind(raw, '[') {
+			var values []json.RawMessage
+			json.Unmarshal(raw, &values)
+			for _, v := range values {
+				if isKind(v, '{') || isKind(v, '[') {
+					return &PayloadError{Field: "value", Msg: "is an array of non-scalars"}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isKind reports whether raw is a JSON value starting with c: '"' for a
+// string, '[' for an array, '{' for an object and 'n' for null.
+func isKind(raw json.RawMessage, c byte) bool {
+	trimmed := bytes.TrimSpace(raw)
+	return len(trimmed) > 0 && trimmed[0] == c
+}
+
+// PayloadReport counts the lines of a sensor_data.jsonl whose record does
+// not decode or whose payload fails CheckPayload.
+type PayloadReport struct {
+	Lines         int
+	Nonconforming int
+	// Kinds counts the problems by PayloadError.Kind, or "record does not
+	// decode"; FirstLine is the 1-based line each kind was first seen on.
+	Kinds     map[string]int
+	FirstLine map[string]int
+}
+
+// SortedKinds lists the problem kinds, most frequent first.
+func (r PayloadReport) SortedKinds() []string {
+	kinds := make([]string, 0, len(r.Kinds))
+	for kind := range r.Kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if r.Kinds[kinds[i]] != r.Kinds[kinds[j]] {
+			return r.Kinds[kinds[i]] > r.Kinds[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+	return kinds
+}
+
+// CheckPayloads reads sensor_data.jsonl lines from src and checks each
+// record's payload. Blank lines are skipped.
+func CheckPayloads(src io.Reader) (PayloadReport, error) {
+	report := PayloadReport{Kinds: map[string]int{}, FirstLine: map[string]int{}}
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		report.Lines++
+		kind := ""
+		if rec, err := Decode(line); err != nil {
+			kind = "record does not decode"
+		} else if err := CheckPayload(rec.Payload); err != nil {
+			var payloadErr *PayloadError
+			errors.As(err, &payloadErr)
+			kind = payloadErr.Kind()
+		}
+		if kind == "" {
+			continue
+		}
+		report.Nonconforming++
+		if report.Kinds[kind] == 0 {
+			report.FirstLine[kind] = n
+		}
+		report.Kinds[kind]++
+	}
+	return report, scanner.Err()
+}
@@ -0,0 +1,73 @@
+package record
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// CurrentVersion is stamped on every record written by Encode. Records
+// without a version field are the original unversioned format (version 0).
+const CurrentVersion = 1
+
+var ErrUnsupportedVersion = errors.New("unsupported record version")
+
+// SensorDataRecord is one line of sensor_data.jsonl: the payload sent to the
+// server plus the capture metadata added on the device.
+type SensorDataRecord struct {
+	Version    int             `json:"version"`
+	CapturedAt string          `json:"captured_at"`
+	WorkField  string          `json:"work_field"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// wireRecord accepts every field name any released version has used.
+// Renames are added here so older devices keep ingesting after a change.
+type wireRecord struct {
+	Version    *int            `json:"version"`
+	CapturedAt *string         `json:"captured_at"`
+	WorkField  *string         `json:"work_field"`
+	Payload    json.RawMessage `json:"payload"`
+
+	// Spellings seen from pre-release clients.
+	CapturedAtCamel *string `json:"capturedAt"`
+	WorkFieldCamel  *string `json:"workField"`
+}
+
+// Decode parses a record of any supported version into the current shape.
+func Decode(line []byte) (SensorDataRecord, error) {
+	var wire wireRecord
+	if err := json.Unmarshal(line, &wire); err != nil {
+		return SensorDataRecord{}, err
+	}
+	version := 0
+	if wire.Version != nil {
+		version = *wire.Version
+	}
+	if version < 0 || version > CurrentVersion {
+		return SensorDataRecord{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	if len(wire.Payload) == 0 || string(wire.Payload) == "null" {
+		return SensorDataRecord{}, errors.New("record has no payload")
+	}
+
+	rec := SensorDataRecord{Version: version, Payload: wire.Payload}
+	rec.CapturedAt = firstString(wire.CapturedAt, wire.CapturedAtCamel)
+	rec.WorkField = firstString(wire.WorkField, wire.WorkFieldCamel)
+	return rec, nil
+}
+
+// Encode writes rec in the current format.
+func Encode(rec SensorDataRecord) ([]byte, error) {
+	rec.Version = CurrentVersion
+	return json.Marshal(rec)
+}
+
+func firstString(values ...*string) string {
+	for _, value := range values {
+		if value != nil {
+			return *value
+		}
+	}
+	return ""
+}
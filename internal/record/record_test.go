@@ -0,0 +1,111 @@
+package record
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	in := SensorDataRecord{
+		CapturedAt: "2026-01-20 00:00:01.000",
+		WorkField:  "field-01",
+		Payload:    json.RawMessage(`{"cmd":"sensor","data":[{"id":1,"value":60}]}`),
+	}
+	data, err := Encode(in)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	out, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if out.Version != CurrentVersion || out.CapturedAt != in.CapturedAt || out.WorkField != in.WorkField || string(out.Payload) != string(in.Payload) {
+		t.Fatalf("round trip mismatch: %+v", out)
+	}
+}
+
+func TestDecodeUnversionedRecord(t *testing.T) {
+	out, err := Decode([]byte(`{"captured_at":"2026-01-20 00:00:01.000","work_field":"field-01","payload":{"cmd":"x"}}`))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if out.Version != 0 || out.WorkField != "field-01" || out.CapturedAt == "" {
+		t.Fatalf("unexpected record: %+v", out)
+	}
+}
+
+func TestDecodeLegacySpellings(t *testing.T) {
+	out, err := Decode([]byte(`{"capturedAt":"2026-01-20 00:00:01.000","workField":"field-02","payload":{}}`))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if out.WorkField != "field-02" || out.CapturedAt != "2026-01-20 00:00:01.000" {
+		t.Fatalf("legacy fields not mapped: %+v", out)
+	}
+}
+
+func TestDecodeRejectsUnknownVersionAndMissingPayload(t *testing.T) {
+	if _, err := Decode([]byte(`{"version":99,"payload":{}}`)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+	if _, err := Decode([]byte(`{"version":1,"work_field":"f"}`)); err == nil {
+		t.Fatalf("expected missing payload error")
+	}
+	if _, err := Decode([]byte(`not json`)); err == nil {
+		t.Fatalf("expected syntax error")
+	}
+}
+
+func TestCheckPayloadShapes(t *testing.T) {
+	for _, tc := range []struct {
+		payload string
+		want    string
+	}{
+		{`{"cmd":"sensor","PublishAt":"2026-01-20 00:00:01","data":[{"id":1,"value":60,"type":"WLS"},{"id":7,"value":[1.5,2]}]}`, ""},
+		{`{"cmd":"sensor","time":"2026-01-20 00:00:01","data":[]}`, ""},
+		{`[1,2]`, "payload is not an object"},
+		{`{"PublishAt":"t","data":[]}`, "cmd is missing or not a string"},
+		{`{"cmd":"sensor","data":[]}`, "time has neither PublishAt nor time"},
+		{`{"cmd":"sensor","time":"t","data":{"id":1}}`, "data is missing or not an array"},
+		{`{"cmd":"sensor","time":"t","data":[{"id":1},{"id":"2"}]}`, "data[1]: id is missing or not an integer"},
+		{`{"cmd":"sensor","time":"t","data":[{"id":null}]}`, "data[0]: id is missing or not an integer"},
+		{`{"cmd":"sensor","time":"t","data":[{"id":1,"value":{"v":1}}]}`, "data[0]: value is an object"},
+		{`{"cmd":"sensor","time":"t","data":[{"id":1,"value":[[1]]}]}`, "data[0]: value is an array of non-scalars"},
+		{`{"cmd":"sensor","time":"t","data":[{"id":1,"type":3}]}`, "data[0]: type is not a string"},
+	} {
+		err := CheckPayload(json.RawMessage(tc.payload))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.payload, got, tc.want)
+		}
+	}
+}
+
+func TestCheckPayloadsCountsKinds(t *testing.T) {
+	lines := strings.Join([]string{
+		`{"version":1,"payload":{"cmd":"sensor","time":"t","data":[{"id":1,"value":60}]}}`,
+		`{"version":1,"payload":{"cmd":"sensor","time":"t","data":[{"id":"1"}]}}`,
+		``,
+		`not json`,
+		`{"version":1,"payload":{"cmd":"sensor","time":"t","data":[{"id":2},{"id":1.5}]}}`,
+	}, "\n")
+	report, err := CheckPayloads(strings.NewReader(lines))
+	if err != nil {
+		t.Fatalf("CheckPayloads: %v", err)
+	}
+	if report.Lines != 4 || report.Nonconforming != 3 {
+		t.Fatalf("unexpected counts %+v", report)
+	}
+	kinds := report.SortedKinds()
+	if len(kinds) != 2 || kinds[0] != "id is missing or not an integer" || report.Kinds[kinds[0]] != 2 || report.FirstLine[kinds[0]] != 2 {
+		t.Fatalf("unexpected kinds %v in %+v", kinds, report)
+	}
+	if report.FirstLine["record does not decode"] != 4 {
+		t.Fatalf("expected the undecodable record on line 4, got %+v", report.FirstLine)
+	}
+}
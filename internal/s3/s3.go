@@ -0,0 +1,314 @@
+// Package s3 stores files in an S3-compatible bucket, which is all the
+// worker needs to archive its done directory: PutFile uploads a file with
+// a single signed (AWS Signature Version 4) PUT and Get reads it back with
+// a signed GET. Credentials and region come from the usual AWS environment
+// variables; AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) points it at MinIO
+// or another compatible store.
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials sign requests.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Client puts objects below Prefix in Bucket.
+type Client struct {
+	Bucket string
+	Prefix string
+	Region string
+	// Endpoint, when set, is used with path-style addressing
+	// (<endpoint>/<bucket>/<key>); otherwise the AWS virtual-hosted
+	// endpoint of Region is used.
+	Endpoint    string
+	Credentials Credentials
+	HTTP        *http.Client
+}
+
+// FromURL builds a client for s3://bucket/prefix from the environment.
+func FromURL(rawURL string) (*Client, error) {
+	return WithCredentials(rawURL, Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+// WithCredentials is like FromURL but signs with creds instead of the AWS_*
+// key variables; region and endpoint still come from the environment.
+func WithCredentials(rawURL string, creds Credentials) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("s3: %q is not an s3://bucket/prefix url", rawURL)
+	}
+	c := &Client{
+		Bucket:      u.Host,
+		Prefix:      strings.Trim(u.Path, "/"),
+		Region:      firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Endpoint:    firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		Credentials: creds,
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Credentials.AccessKeyID == "" || c.Credentials.SecretAccessKey == "" {
+		return nil, errors.New("s3: an access key id and secret access key are required (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)")
+	}
+	return c, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Location is the s3:// url of name below the prefix.
+func (c *Client) Location(name string) string {
+	return "s3://" + c.Bucket + "/" + c.key(name)
+}
+
+func (c *Client) key(name string) string {
+	if c.Prefix == "" {
+		return name
+	}
+	return path.Join(c.Prefix, name)
+}
+
+// PutFile streams the file at path up as name below the prefix. Its MD5
+// and SHA-256 are computed first and sent as Content-MD5 and
+// x-amz-checksum-sha256, so the bucket rejects a corrupted upload; a
+// SHA-256 checksum in the response must match too. The ETag is not used:
+// it is not the MD5 with SSE-KMS or for multipart objects.
+func (c *Client) PutFile(ctx context.Context, name, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sum := sha256Hash.Sum(nil)
+	checksum := base64.StdEncoding.EncodeToString(sum)
+	objectURL, err := c.objectURL(c.key(name))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL.String(), io.NopCloser(f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Hash.Sum(nil)))
+	req.Header.Set("X-Amz-Checksum-Sha256", checksum)
+	c.sign(req, hex.EncodeToString(sum))
+	client := c.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3: put %s: %w", c.Location(name), err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("s3: put %s: %s: %s", c.Location(name), resp.Status, strings.TrimSpace(string(body)))
+	}
+	if got := resp.Header.Get("X-Amz-Checksum-Sha256"); got != "" && got != checksum {
+		return fmt.Errorf("s3: put %s: checksum %q does not match the file's sha256", c.Location(name), got)
+	}
+	return nil
+}
+
+// Get writes the object stored as name below the prefix to w.
+func (c *Client) Get(ctx context.Context, name string, w io.Writer) error {
+	objectURL, err := c.objectURL(c.key(name))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	c.sign(req, sha256Hex(nil))
+	client := c.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3: get %s: %w", c.Location(name), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("s3: get %s: %s: %s", c.Location(name), resp.Status, strings.TrimSpace(string(body)))
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("s3: get %s: %w", c.Location(name), err)
+	}
+	return nil
+}
+
+// Delete removes the object stored as name below the prefix. An object
+// that is not there is not an error.
+func (c *Client) Delete(ctx context.Context, name string) error {
+	objectURL, err := c.objectURL(c.key(name))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, objectURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	c.sign(req, sha256Hex(nil))
+	client := c.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3: delete %s: %w", c.Location(name), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("s3: delete %s: %s: %s", c.Location(name), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (c *Client) objectURL(key string) (*url.URL, error) {
+	escaped := (&url.URL{Path: "/" + key}).EscapedPath()
+	if c.Endpoint != "" {
+		base, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+		if err != nil {
+			return nil, err
+		}
+		return url.Parse(base.String() + "/" + c.Bucket + escaped)
+	}
+	return url.Parse("https://" + c.Bucket + ".s3." + c.Region + ".amazonaws.com" + escaped)
+}
+
+// sign adds the SigV4 Authorization header for service s3; payloadHash is
+// the hex SHA-256 of the body.
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	t := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.Credentials.SessionToken)
+	}
+	req.Header.Set("Authorization", authorization(req, payloadHash, c.Credentials, c.Region, "s3", t))
+}
+
+// authorization computes the SigV4 Authorization header value over every
+// header set on req plus Host.
+func authorization(req *http.Request, payloadHash string, creds Credentials, region, service string, t time.Time) string {
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "authorization" {
+			continue
+		}
+		headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalPath := req.URL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := t.Format("20060102")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", t.Format("20060102T150405Z"), scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	return "AWS4-HMAC-SHA256 Credential=" + creds.AccessKeyID + "/" + scope + ", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		vs := append([]string(nil), values[key]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(key)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
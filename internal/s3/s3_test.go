@@ -0,0 +1,138 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignatureVector checks the signer against get-vanilla from the AWS
+// Signature Version 4 test suite.
+func TestSignatureVector(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	req.Header.Set("X-Amz-Date", "20150830T123600Z")
+	at := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	got := authorization(req, sha256Hex(nil), Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}, "us-east-1", "service", at)
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got != want {
+		t.Fatalf("authorization\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestPutFileSendsChecksums(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotBody []byte
+	checksum := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.Path, r.Header.Get("Authorization")
+		gotBody, _ = io.ReadAll(r.Body)
+		sum := md5.Sum(gotBody)
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			http.Error(w, "BadDigest", http.StatusBadRequest)
+			return
+		}
+		// SSE-KMS and multipart ETags are not the MD5.
+		w.Header().Set("ETag", `"0123-2"`)
+		if checksum == "" {
+			checksum = r.Header.Get("X-Amz-Checksum-Sha256")
+		}
+		w.Header().Set("X-Amz-Checksum-Sha256", checksum)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "a.zip")
+	os.WriteFile(path, []byte("zip bytes"), 0o644)
+
+	c := &Client{Bucket: "field", Prefix: "done/siteA", Region: "ap-northeast-2", Endpoint: server.URL,
+		Credentials: Credentials{AccessKeyID: "AK", SecretAccessKey: "SK"}}
+	if err := c.PutFile(context.Background(), "a.zip", path); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if gotPath != "/field/done/siteA/a.zip" || string(gotBody) != "zip bytes" {
+		t.Fatalf("unexpected request %s %q", gotPath, gotBody)
+	}
+	if sum := sha256.Sum256([]byte("zip bytes")); checksum != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Fatalf("checksum header = %q", checksum)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AK/") || !strings.Contains(gotAuth, "/ap-northeast-2/s3/aws4_request") ||
+		!strings.Contains(gotAuth, "content-md5;") || !strings.Contains(gotAuth, "x-amz-checksum-sha256;") {
+		t.Fatalf("unexpected authorization %q", gotAuth)
+	}
+	if got := c.Location("a.zip"); got != "s3://field/done/siteA/a.zip" {
+		t.Fatalf("location = %s", got)
+	}
+
+	checksum = "AAAA"
+	if err := c.PutFile(context.Background(), "a.zip", path); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Fatalf("expected a checksum mismatch error, got %v", err)
+	}
+}
+
+func TestGetReadsObject(t *testing.T) {
+	var gotMethod, gotPath, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath, gotAuth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+		if strings.HasSuffix(r.URL.Path, "/missing.zip") {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("zip bytes"))
+	}))
+	defer server.Close()
+
+	c := &Client{Bucket: "field", Prefix: "done", Region: "ap-northeast-2", Endpoint: server.URL,
+		Credentials: Credentials{AccessKeyID: "AK", SecretAccessKey: "SK"}}
+	var got strings.Builder
+	if err := c.Get(context.Background(), "a.zip", &got); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if gotMethod != http.MethodGet || gotPath != "/field/done/a.zip" || got.String() != "zip bytes" {
+		t.Fatalf("unexpected request %s %s: %q", gotMethod, gotPath, got.String())
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AK/") {
+		t.Fatalf("unexpected authorization %q", gotAuth)
+	}
+	if err := c.Get(context.Background(), "missing.zip", io.Discard); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected a 404 error, got %v", err)
+	}
+}
+
+func TestDeleteRemovesObject(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/missing.zip"):
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/locked.zip"):
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	c := &Client{Bucket: "field", Prefix: "done", Region: "ap-northeast-2", Endpoint: server.URL,
+		Credentials: Credentials{AccessKeyID: "AK", SecretAccessKey: "SK"}}
+	if err := c.Delete(context.Background(), "a.zip"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := c.Delete(context.Background(), "missing.zip"); err != nil {
+		t.Fatalf("delete of a missing object: %v", err)
+	}
+	if err := c.Delete(context.Background(), "locked.zip"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected a 403 error, got %v", err)
+	}
+	if len(requests) != 3 || requests[0] != "DELETE /field/done/a.zip" {
+		t.Fatalf("unexpected requests %q", requests)
+	}
+}
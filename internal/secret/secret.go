@@ -0,0 +1,142 @@
+// Package secret keeps credentials in the client config encrypted with a
+// per-device key. Encrypted values look like "enc:v1:<base64>" and are only
+// decrypted in memory by the command that needs them; the key lives in its
+// own file (mode 0600) so a copied config.json is useless on its own.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix marks an encrypted value.
+const Prefix = "enc:v1:"
+
+const keySize = 32
+
+// ErrNoKey is returned when an encrypted value is found but the device key
+// file does not exist.
+var ErrNoKey = errors.New("device key not found")
+
+// IsEncrypted reports whether value was produced by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// LoadKey reads a hex-encoded AES-256 key.
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNoKey, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("%s: not a %d-byte hex key", path, keySize)
+	}
+	return key, nil
+}
+
+// LoadOrCreateKey is LoadKey, generating a new key first if path does not
+// exist yet.
+func LoadOrCreateKey(path string) ([]byte, error) {
+	key, err := LoadKey(path)
+	if !errors.Is(err, ErrNoKey) {
+		return key, err
+	}
+	key = make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return key, file.Close()
+}
+
+// Encrypt seals plaintext with AES-256-GCM. name is bound into the
+// ciphertext, so a value copied under another name fails to decrypt.
+func Encrypt(key []byte, name, plaintext string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(name))
+	return Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt for the same name. Values
+// without Prefix are returned unchanged so plaintext configs keep working.
+func Decrypt(key []byte, name, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", name, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("secret %s: value too short", name)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("secret %s: cannot decrypt with this device key", name)
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Resolve decrypts every value in secrets. The key file is only read when
+// at least one value is encrypted.
+func Resolve(keyPath string, secrets map[string]string) (map[string]string, error) {
+	var key []byte
+	resolved := make(map[string]string, len(secrets))
+	for name, value := range secrets {
+		if IsEncrypted(value) && key == nil {
+			var err error
+			if key, err = LoadKey(keyPath); err != nil {
+				return nil, err
+			}
+		}
+		plaintext, err := Decrypt(key, name, value)
+		if err != nil {
+			return nil, err
+		}
+		resolved[name] = plaintext
+	}
+	return resolved, nil
+}
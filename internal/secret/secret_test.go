@@ -0,0 +1,63 @@
+package secret
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "field-client.key")
+	key, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("key file: %v %v", info.Mode(), err)
+	}
+	again, err := LoadOrCreateKey(path)
+	if err != nil || string(again) != string(key) {
+		t.Fatalf("expected the existing key to be reused: %v", err)
+	}
+
+	value, err := Encrypt(key, "upload_token", "s3cr3t")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if !IsEncrypted(value) || strings.Contains(value, "s3cr3t") {
+		t.Fatalf("unexpected value %q", value)
+	}
+	if got, err := Decrypt(key, "upload_token", value); err != nil || got != "s3cr3t" {
+		t.Fatalf("decrypt: %q %v", got, err)
+	}
+	if _, err := Decrypt(key, "other_name", value); err == nil {
+		t.Fatal("expected a value moved to another name to fail")
+	}
+	other := make([]byte, keySize)
+	if _, err := Decrypt(other, "upload_token", value); err == nil {
+		t.Fatal("expected another device key to fail")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "field-client.key")
+
+	plain, err := Resolve(path, map[string]string{"user": "field"})
+	if err != nil || plain["user"] != "field" {
+		t.Fatalf("plaintext values must not need a key: %v %v", plain, err)
+	}
+
+	key, _ := LoadOrCreateKey(path)
+	token, _ := Encrypt(key, "token", "abc")
+	got, err := Resolve(path, map[string]string{"user": "field", "token": token})
+	if err != nil || got["token"] != "abc" || got["user"] != "field" {
+		t.Fatalf("resolve: %v %v", got, err)
+	}
+
+	if _, err := Resolve(filepath.Join(dir, "missing.key"), map[string]string{"token": token}); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+// Package service installs the field tools as scheduled system jobs: a
+// systemd service plus timer on Linux, a Task Scheduler task on Windows.
+// Both tools are one-shot runs (one day of logs, one pass over incoming), so
+// a timer rather than a long-running unit is the natural fit.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DefaultUnitDir is where systemd units are written unless overridden.
+const DefaultUnitDir = "/etc/systemd/system"
+
+// Spec describes one scheduled command.
+type Spec struct {
+	// Name is the systemd unit name without suffix and the Windows task name.
+	Name        string
+	Description string
+	// Exec is the absolute path of the binary; Args follow it.
+	Exec       string
+	Args       []string
+	WorkingDir string
+	// User runs the systemd service as this account (root when empty).
+	User string
+	// Either At ("HH:MM", once a day) or Every (a fixed interval) is set.
+	At    string
+	Every time.Duration
+}
+
+// Validate checks the fields both backends rely on.
+func (s Spec) Validate() error {
+	if s.Name == "" || strings.ContainsAny(s.Name, `/\ `) {
+		return fmt.Errorf("invalid service name %q", s.Name)
+	}
+	if !filepath.IsAbs(s.Exec) && !isWindowsAbs(s.Exec) {
+		return fmt.Errorf("service executable must be an absolute path: %q", s.Exec)
+	}
+	switch {
+	case s.At != "" && s.Every != 0:
+		return errors.New("set either a daily time or an interval, not both")
+	case s.At != "":
+		if _, err := time.Parse("15:04", s.At); err != nil {
+			return fmt.Errorf("invalid daily time %q: expected HH:MM", s.At)
+		}
+	case s.Every < time.Minute:
+		return fmt.Errorf("interval must be at least 1m, got %s", s.Every)
+	}
+	return nil
+}
+
+// ServiceUnit renders the oneshot systemd service.
+func (s Spec) ServiceUnit() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s\nAfter=network-online.target\nWants=network-online.target\n\n", s.Description)
+	b.WriteString("[Service]\nType=oneshot\n")
+	if s.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", s.User)
+	}
+	if s.WorkingDir != "" {
+		fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdQuote(s.WorkingDir))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", commandLine(s.Exec, s.Args, systemdQuote))
+	return b.String()
+}
+
+// TimerUnit renders the systemd timer that starts the service.
+func (s Spec) TimerUnit() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\nDescription=Schedule %s\n\n[Timer]\n", s.Name)
+	if s.At != "" {
+		fmt.Fprintf(&b, "OnCalendar=*-*-* %s:00\nPersistent=true\n", s.At)
+	} else {
+		fmt.Fprintf(&b, "OnBootSec=%s\nOnUnitInactiveSec=%s\n", systemdSpan(s.Every), systemdSpan(s.Every))
+	}
+	b.WriteString("\n[Install]\nWantedBy=timers.target\n")
+	return b.String()
+}
+
+// Units renders both systemd units with a header naming each file, for
+// review before installing.
+func (s Spec) Units() string {
+	return fmt.Sprintf("# %s.service\n%s\n# %s.timer\n%s", s.Name, s.ServiceUnit(), s.Name, s.TimerUnit())
+}
+
+// TaskArgs are the schtasks.exe arguments that create the Windows task.
+func (s Spec) TaskArgs() []string {
+	args := []string{"/Create", "/F", "/TN", s.Name, "/TR", commandLine(s.Exec, s.Args, windowsQuote)}
+	if s.At != "" {
+		return append(args, "/SC", "DAILY", "/ST", s.At)
+	}
+	return append(args, "/SC", "MINUTE", "/MO", fmt.Sprint(int(s.Every/time.Minute)))
+}
+
+// Runner executes an external command such as systemctl.
+type Runner func(ctx context.Context, name string, args ...string) error
+
+// Installer writes and registers specs. The zero value targets the running
+// OS with DefaultUnitDir and real commands.
+type Installer struct {
+	GOOS    string
+	UnitDir string
+	// NoEnable only writes the units without enabling the timer.
+	NoEnable bool
+	Run      Runner
+}
+
+// Install writes the units (or creates the task) and enables the schedule.
+// It returns the files written, which is empty on Windows.
+func (in Installer) Install(ctx context.Context, spec Spec) ([]string, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if in.goos() == "windows" {
+		return nil, in.run(ctx, "schtasks", spec.TaskArgs()...)
+	}
+	dir := in.unitDir()
+	files := []string{filepath.Join(dir, spec.Name+".service"), filepath.Join(dir, spec.Name+".timer")}
+	for i, content := range []string{spec.ServiceUnit(), spec.TimerUnit()} {
+		if err := os.WriteFile(files[i], []byte(content), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	if err := in.run(ctx, "systemctl", "daemon-reload"); err != nil {
+		return files, err
+	}
+	if in.NoEnable {
+		return files, nil
+	}
+	return files, in.run(ctx, "systemctl", "enable", "--now", spec.Name+".timer")
+}
+
+// Uninstall stops and removes what Install created. Missing units are not an
+// error so uninstall can be repeated.
+func (in Installer) Uninstall(ctx context.Context, name string) error {
+	if in.goos() == "windows" {
+		return in.run(ctx, "schtasks", "/Delete", "/F", "/TN", name)
+	}
+	dir := in.unitDir()
+	timer := filepath.Join(dir, name+".timer")
+	if _, err := os.Stat(timer); err == nil {
+		if err := in.run(ctx, "systemctl", "disable", "--now", name+".timer"); err != nil {
+			return err
+		}
+	}
+	for _, path := range []string{timer, filepath.Join(dir, name+".service")} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return in.run(ctx, "systemctl", "daemon-reload")
+}
+
+func (in Installer) goos() string {
+	if in.GOOS != "" {
+		return in.GOOS
+	}
+	return runtime.GOOS
+}
+
+func (in Installer) unitDir() string {
+	if in.UnitDir != "" {
+		return in.UnitDir
+	}
+	return DefaultUnitDir
+}
+
+func (in Installer) run(ctx context.Context, name string, args ...string) error {
+	if in.Run != nil {
+		return in.Run(ctx, name, args...)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// Executable is the resolved path of the running binary, which is what units
+// should start rather than a symlink that may later be repointed.
+func Executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
+
+func commandLine(exe string, args []string, quote func(string) string) string {
+	parts := []string{quote(exe)}
+	for _, arg := range args {
+		parts = append(parts, quote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// systemdQuote quotes values with spaces, quotes or backslashes; systemd
+// understands C-style escapes inside double quotes. "%" is always doubled
+// because systemd expands specifiers in ExecStart.
+func systemdQuote(value string) string {
+	value = strings.ReplaceAll(value, "%", "%%")
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\") {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+func windowsQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// isWindowsAbs accepts C:\ paths when specs are rendered on another OS.
+func isWindowsAbs(path string) bool {
+	return len(path) > 2 && path[1] == ':' && (path[2] == '\\' || path[2] == '/')
+}
+
+func systemdSpan(d time.Duration) string {
+	return fmt.Sprintf("%ds", int(d/time.Second))
+}
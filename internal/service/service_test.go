@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func clientSpec() Spec {
+	return Spec{
+		Name:        "field-client-analyze",
+		Description: "field-client analyze-daily",
+		Exec:        "/opt/field/field-client",
+		Args:        []string{"analyze-daily", "-config", "/etc/field/my config.json", "-date", "yesterday"},
+		User:        "field",
+		At:          "00:05",
+	}
+}
+
+type recorder struct{ calls []string }
+
+func (r *recorder) run(_ context.Context, name string, args ...string) error {
+	r.calls = append(r.calls, name+" "+strings.Join(args, " "))
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	if err := clientSpec().Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	for _, mutate := range []func(*Spec){
+		func(s *Spec) { s.Name = "" },
+		func(s *Spec) { s.Exec = "field-client" },
+		func(s *Spec) { s.At = "25:00" },
+		func(s *Spec) { s.Every = time.Minute },
+		func(s *Spec) { s.At, s.Every = "", 30*time.Second },
+	} {
+		spec := clientSpec()
+		mutate(&spec)
+		if err := spec.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", spec)
+		}
+	}
+}
+
+func TestUnitsRender(t *testing.T) {
+	spec := clientSpec()
+	service := spec.ServiceUnit()
+	if want := `ExecStart=/opt/field/field-client analyze-daily -config "/etc/field/my config.json" -date yesterday`; !strings.Contains(service, want) {
+		t.Fatalf("missing %q in\n%s", want, service)
+	}
+	if !strings.Contains(service, "User=field\n") || !strings.Contains(service, "Type=oneshot\n") {
+		t.Fatalf("unexpected service unit\n%s", service)
+	}
+	if timer := spec.TimerUnit(); !strings.Contains(timer, "OnCalendar=*-*-* 00:05:00\n") {
+		t.Fatalf("unexpected timer unit\n%s", timer)
+	}
+
+	spec.At, spec.Every = "", 5*time.Minute
+	if timer := spec.TimerUnit(); !strings.Contains(timer, "OnUnitInactiveSec=300s\n") {
+		t.Fatalf("unexpected interval timer\n%s", timer)
+	}
+}
+
+func TestTaskArgs(t *testing.T) {
+	spec := clientSpec()
+	spec.Exec = `C:\field\field-client.exe`
+	got := spec.TaskArgs()
+	want := []string{"/Create", "/F", "/TN", "field-client-analyze",
+		"/TR", `C:\field\field-client.exe analyze-daily -config "/etc/field/my config.json" -date yesterday`,
+		"/SC", "DAILY", "/ST", "00:05"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestInstallAndUninstallSystemd(t *testing.T) {
+	dir := t.TempDir()
+	rec := &recorder{}
+	in := Installer{GOOS: "linux", UnitDir: dir, Run: rec.run}
+	files, err := in.Install(context.Background(), clientSpec())
+	if err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected two units, got %v", files)
+	}
+	for _, path := range files {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("unit not written: %v", err)
+		}
+	}
+	if err := in.Uninstall(context.Background(), "field-client-analyze"); err != nil {
+		t.Fatalf("uninstall: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "field-client-analyze.timer")); !os.IsNotExist(err) {
+		t.Fatalf("timer not removed: %v", err)
+	}
+	want := []string{
+		"systemctl daemon-reload",
+		"systemctl enable --now field-client-analyze.timer",
+		"systemctl disable --now field-client-analyze.timer",
+		"systemctl daemon-reload",
+	}
+	if !reflect.DeepEqual(rec.calls, want) {
+		t.Fatalf("calls %q", rec.calls)
+	}
+	if err := in.Uninstall(context.Background(), "field-client-analyze"); err != nil {
+		t.Fatalf("second uninstall: %v", err)
+	}
+}
+
+func TestInstallWindowsUsesScheduler(t *testing.T) {
+	rec := &recorder{}
+	in := Installer{GOOS: "windows", Run: rec.run}
+	spec := clientSpec()
+	spec.Exec = `C:\field\field-client.exe`
+	if _, err := in.Install(context.Background(), spec); err != nil {
+		t.Fatalf("install: %v", err)
+	}
+	if len(rec.calls) != 1 || !strings.HasPrefix(rec.calls[0], "schtasks /Create") {
+		t.Fatalf("calls %q", rec.calls)
+	}
+}
@@ -0,0 +1,386 @@
+// Package simulate generates synthetic device data: per-sensor serial logs in
+// the layout the analyzer reads, and daily archives in the layout the ingest
+// worker expects, with optional fault injection.
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"workfield/internal/archive"
+	"workfield/internal/manifest"
+	"workfield/internal/record"
+	"workfield/internal/timeparse"
+)
+
+type Sensor struct {
+	ID       int
+	SensorID string
+	Type     string
+	Field    string
+	JSONType string
+}
+
+// DefaultSensors matches config/mapping.sample.json for the sensor types the
+// analyzer knows about.
+var DefaultSensors = []Sensor{
+	{ID: 1, SensorID: "WLS1", Type: "WLS", Field: "value"},
+	{ID: 4, SensorID: "GATE1", Type: "GATE", Field: "value"},
+	{ID: 6, SensorID: "TEMP1", Type: "TEMP", Field: "value"},
+	{ID: 301, SensorID: "PUMP1", Type: "PUMP", Field: "value"},
+}
+
+// LoadSensors reads sensors from a mapping.json file (id → entry).
+func LoadSensors(path string) ([]Sensor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]struct {
+		SensorID string `json:"sensor_id"`
+		Type     string `json:"type"`
+		Field    string `json:"field"`
+		JSONType string `json:"json_type"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	var sensors []Sensor
+	for key, entry := range entries {
+		id, err := strconv.Atoi(key)
+		if err != nil || entry.SensorID == "" {
+			continue
+		}
+		sensors = append(sensors, Sensor{ID: id, SensorID: entry.SensorID, Type: entry.Type, Field: entry.Field, JSONType: entry.JSONType})
+	}
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].ID < sensors[j].ID })
+	return sensors, nil
+}
+
+// Faults are per-sample probabilities, except Drift which is added to the
+// sent value per hour elapsed since Start.
+type Faults struct {
+	TimeoutRate   float64
+	DuplicateRate float64
+	ZeroRate      float64
+	DriftPerHour  float64
+}
+
+type Config struct {
+	SiteID    string
+	DeviceID  string
+	WorkField string
+	Sensors   []Sensor
+	Start     time.Time
+	End       time.Time
+	Interval  time.Duration
+	Faults    Faults
+	Seed      int64
+	// Format is the archive container WriteArchive writes; empty is zip.
+	Format archive.Format
+}
+
+// Day holds everything generated for one calendar day.
+type Day struct {
+	Date      time.Time
+	Logs      map[string][]string
+	Snapshots []record.SensorDataRecord
+	Events    []map[string]any
+}
+
+func (d Day) DateString() string {
+	return d.Date.Format(timeparse.DateLayout)
+}
+
+type sensorState struct {
+	value       float64
+	lastPayload string
+}
+
+// Generate produces one Day per calendar day touched by [Start, End).
+func Generate(cfg Config) []Day {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	if len(cfg.Sensors) == 0 {
+		cfg.Sensors = DefaultSensors
+	}
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	states := map[string]*sensorState{}
+	for _, sensor := range cfg.Sensors {
+		states[sensor.SensorID] = &sensorState{value: initialValue(sensor.Type, rng)}
+	}
+
+	var days []Day
+	var current *Day
+	hourCounts := map[string]int{}
+	flushHours := func() {
+		if current == nil {
+			return
+		}
+		hours := make([]string, 0, len(hourCounts))
+		for hour := range hourCounts {
+			hours = append(hours, hour)
+		}
+		sort.Strings(hours)
+		for _, hour := range hours {
+			current.Events = append(current.Events, map[string]any{
+				"hour":           hour,
+				"work_field":     cfg.WorkField,
+				"snapshot_count": hourCounts[hour],
+			})
+		}
+		hourCounts = map[string]int{}
+	}
+
+	for tick := cfg.Start; tick.Before(cfg.End); tick = tick.Add(cfg.Interval) {
+		dayStart := time.Date(tick.Year(), tick.Month(), tick.Day(), 0, 0, 0, 0, tick.Location())
+		if current == nil || !current.Date.Equal(dayStart) {
+			flushHours()
+			days = append(days, Day{Date: dayStart, Logs: map[string][]string{}})
+			current = &days[len(days)-1]
+		}
+
+		drift := cfg.Faults.DriftPerHour * tick.Sub(cfg.Start).Hours()
+		var items []map[string]any
+		for _, sensor := range cfg.Sensors {
+			state := states[sensor.SensorID]
+			lines, sent := sample(sensor, state, tick, drift, cfg.Faults, rng)
+			current.Logs[sensor.SensorID] = append(current.Logs[sensor.SensorID], lines...)
+			item := map[string]any{"id": sensor.ID}
+			if sensor.JSONType != "" {
+				item["type"] = sensor.JSONType
+			}
+			if sensor.Field == "" {
+				item["value"] = sent
+			} else {
+				item[sensor.Field] = sent
+			}
+			items = append(items, item)
+		}
+
+		publishAt := tick.Add(time.Second)
+		stamp := publishAt.Format(timeparse.LineLayout)
+		payload, _ := json.Marshal(map[string]any{
+			"PublishAt":  stamp,
+			"work_field": cfg.WorkField,
+			"cmd":        "sensor",
+			"data":       items,
+		})
+		current.Snapshots = append(current.Snapshots, record.SensorDataRecord{
+			CapturedAt: stamp,
+			WorkField:  cfg.WorkField,
+			Payload:    payload,
+		})
+		hourCounts[publishAt.Format("2006-01-02T15")]++
+	}
+	flushHours()
+	return days
+}
+
+// sample advances one sensor by one tick and returns its log lines and the
+// value the device reports in JSON. On timeout the device keeps reporting its
+// last known value, which is what the worker sees as MISSING_RAW.
+func sample(sensor Sensor, state *sensorState, tick time.Time, drift float64, faults Faults, rng *rand.Rand) ([]string, any) {
+	sndAt := tick.Format(timeparse.LineLayout)
+	rcvAt := tick.Add(300 * time.Millisecond).Format(timeparse.LineLayout)
+	lines := []string{fmt.Sprintf("%s snd: %s", sndAt, command(sensor.Type))}
+
+	if rng.Float64() < faults.TimeoutRate {
+		lines = append(lines, fmt.Sprintf("%s timeout waiting for %s", rcvAt, sensor.SensorID))
+		state.lastPayload = ""
+		return lines, sentValue(sensor.Type, state.value, drift)
+	}
+
+	var payload string
+	switch {
+	case state.lastPayload != "" && rng.Float64() < faults.DuplicateRate:
+		payload = state.lastPayload
+	case rng.Float64() < faults.ZeroRate:
+		payload = "(00, 00, 00)"
+		lines = append(lines, fmt.Sprintf("%s rcv: %s", rcvAt, payload))
+		state.lastPayload = payload
+		return lines, 0
+	default:
+		state.value = step(sensor.Type, state.value, rng)
+		payload = rawPayload(sensor.Type, state.value)
+	}
+	state.lastPayload = payload
+	lines = append(lines, fmt.Sprintf("%s rcv: %s", rcvAt, payload))
+	return lines, sentValue(sensor.Type, state.value, drift)
+}
+
+func command(sensorType string) string {
+	switch sensorType {
+	case "WLS":
+		return "(FA, FF, 07, 03, 76)"
+	default:
+		return "STATUS"
+	}
+}
+
+func initialValue(sensorType string, rng *rand.Rand) float64 {
+	switch sensorType {
+	case "WLS":
+		return float64(20 + rng.Intn(40))
+	case "TEMP":
+		return 15 + rng.Float64()*10
+	default:
+		return 0
+	}
+}
+
+func step(sensorType string, value float64, rng *rand.Rand) float64 {
+	switch sensorType {
+	case "WLS":
+		return math.Max(0, math.Min(96, value+float64(rng.Intn(3)-1)))
+	case "TEMP":
+		return math.Round((value+(rng.Float64()-0.5)*0.4)*10) / 10
+	case "GATE", "PUMP":
+		if rng.Float64() < 0.05 {
+			return 1 - value
+		}
+		return value
+	default:
+		return math.Round(5 + rng.Float64()*20)
+	}
+}
+
+// rawPayload renders the value as it appears after "rcv:" on the bus. WLS
+// uses the 11-byte FA … 76 frame the analyzer validates.
+func rawPayload(sensorType string, value float64) string {
+	if sensorType == "WLS" {
+		level := int(value)
+		frame := []byte{0xFA, 0xFF, 0x07, 0x15, byte(level >> 8), byte(level), 0xDD, 0xDD, 0xFF, 0x00, 0x76}
+		var sum byte
+		for _, b := range frame[:9] {
+			sum += b
+		}
+		frame[9] = sum
+		parts := make([]string, len(frame))
+		for i, b := range frame {
+			parts[i] = fmt.Sprintf("%02X", b)
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	}
+	return formatNumber(value)
+}
+
+func sentValue(sensorType string, value, drift float64) any {
+	switch sensorType {
+	case "GATE", "PUMP":
+		return int(value)
+	case "WLS":
+		return math.Round(value + drift)
+	default:
+		return math.Round((value+drift)*10) / 10
+	}
+}
+
+func formatNumber(value float64) string {
+	if value == math.Trunc(value) {
+		return fmt.Sprintf("%d", int64(value))
+	}
+	return fmt.Sprintf("%.1f", value)
+}
+
+// WriteLogRoot writes root/SENSOR/YYYY-MM-DD.log for every day.
+func WriteLogRoot(root string, days []Day) error {
+	for _, day := range days {
+		for sensorID, lines := range day.Logs {
+			dir := filepath.Join(root, sensorID)
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+			path := filepath.Join(dir, day.Date.Format(timeparse.DayLayout)+".log")
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteArchive packages one day as site_device_YYYYMMDD.zip in dir, or
+// with the extension of cfg.Format.
+func WriteArchive(dir string, cfg Config, day Day) (string, error) {
+	staging, err := os.MkdirTemp("", "field-simulator-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	var events, snapshots []string
+	for _, event := range day.Events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return "", err
+		}
+		events = append(events, string(line))
+	}
+	for _, snapshot := range day.Snapshots {
+		line, err := record.Encode(snapshot)
+		if err != nil {
+			return "", err
+		}
+		snapshots = append(snapshots, string(line))
+	}
+	if err := writeLines(filepath.Join(staging, "events.jsonl"), events); err != nil {
+		return "", err
+	}
+	if err := writeLines(filepath.Join(staging, "sensor_data.jsonl"), snapshots); err != nil {
+		return "", err
+	}
+	names := []string{"events.jsonl", "sensor_data.jsonl"}
+	for sensorID, lines := range day.Logs {
+		name := "raw_session/" + sensorID + "/" + day.Date.Format(timeparse.DayLayout) + ".log"
+		if err := writeLines(filepath.Join(staging, filepath.FromSlash(name)), lines); err != nil {
+			return "", err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m, err := manifest.Build(staging, names)
+	if err != nil {
+		return "", err
+	}
+	if err := manifest.Write(filepath.Join(staging, manifest.FileName), m); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	format := cfg.Format
+	if format == "" {
+		format = archive.FormatZip
+	}
+	name := fmt.Sprintf("%s_%s_%s%s", cfg.SiteID, cfg.DeviceID, day.DateString(), format.Ext())
+	zipPath := filepath.Join(dir, name)
+	partial := zipPath + ".partial"
+	if err := archive.Create(partial, staging, append(names, manifest.FileName), format); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	return zipPath, os.Rename(partial, zipPath)
+}
+
+func writeLines(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	content := ""
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n") + "\n"
+	}
+	return os.WriteFile(path, []byte(content), 0o644)
+}
@@ -0,0 +1,49 @@
+package simulate
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateIsDeterministicAndSplitsDays(t *testing.T) {
+	cfg := Config{
+		SiteID:    "siteA",
+		DeviceID:  "device01",
+		WorkField: "field-01",
+		Start:     time.Date(2026, 1, 20, 23, 0, 0, 0, time.Local),
+		End:       time.Date(2026, 1, 21, 1, 0, 0, 0, time.Local),
+		Interval:  10 * time.Minute,
+		Seed:      7,
+	}
+	a := Generate(cfg)
+	b := Generate(cfg)
+	if len(a) != 2 || a[0].DateString() != "20260120" || a[1].DateString() != "20260121" {
+		t.Fatalf("unexpected days: %d", len(a))
+	}
+	if len(a[0].Snapshots) != 6 || len(a[1].Snapshots) != 6 {
+		t.Fatalf("expected 6 snapshots per day, got %d and %d", len(a[0].Snapshots), len(a[1].Snapshots))
+	}
+	if strings.Join(a[0].Logs["WLS1"], "\n") != strings.Join(b[0].Logs["WLS1"], "\n") {
+		t.Fatalf("expected identical output for identical seed")
+	}
+	if len(a[1].Events) != 1 || a[1].Events[0]["snapshot_count"] != 6 {
+		t.Fatalf("unexpected events: %v", a[1].Events)
+	}
+}
+
+func TestGenerateInjectsTimeouts(t *testing.T) {
+	cfg := Config{
+		Start:    time.Date(2026, 1, 20, 0, 0, 0, 0, time.Local),
+		End:      time.Date(2026, 1, 20, 1, 0, 0, 0, time.Local),
+		Interval: time.Minute,
+		Sensors:  []Sensor{{ID: 1, SensorID: "WLS1", Type: "WLS", Field: "value"}},
+		Faults:   Faults{TimeoutRate: 1},
+	}
+	days := Generate(cfg)
+	for _, line := range days[0].Logs["WLS1"] {
+		if strings.Contains(line, "rcv:") {
+			t.Fatalf("expected no responses with timeout rate 1, got %q", line)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Package support builds the diagnostic zip field staff attach to support
+// tickets: the effective client config with secrets redacted, recent logs,
+// an outbox listing, the last analysis and a description of the machine.
+// A section that cannot be gathered is noted in errors.txt instead of
+// failing the bundle, since a broken install is when one is needed most.
+package support
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"workfield/internal/buildinfo"
+	"workfield/internal/config"
+	"workfield/internal/health"
+	"workfield/internal/upload"
+)
+
+// Redacted replaces secret values in the bundle.
+const Redacted = "REDACTED"
+
+// maxListing caps the outbox listing for outboxes that were never cleaned.
+const maxListing = 10000
+
+// Bundle describes what goes into the zip.
+type Bundle struct {
+	ConfigPath string
+	// Config is the effective config; nil when it could not be loaded, in
+	// which case ConfigErr says why.
+	Config    *config.Client
+	ConfigErr error
+	// TailBytes is how much of the end of each log file is included.
+	TailBytes int64
+	// Journal, when set, is a command whose output is included as
+	// journal.txt, e.g. journalctl for the client's systemd units.
+	Journal []string
+	Now     time.Time
+}
+
+// Write writes the bundle as a zip to w.
+func (b Bundle) Write(ctx context.Context, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	var problems []string
+	add := func(name string, data []byte) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: b.Now})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}
+	note := func(section string, err error) {
+		problems = append(problems, fmt.Sprintf("%s: %v", section, err))
+	}
+
+	system, err := json.MarshalIndent(b.system(ctx), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := add("system.json", system); err != nil {
+		return err
+	}
+	if b.ConfigErr != nil {
+		note("config", b.ConfigErr)
+	}
+	if b.Config != nil {
+		if err := b.Config.Validate(); err != nil {
+			note("config validation", err)
+		}
+		data, err := json.MarshalIndent(RedactConfig(*b.Config), "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add("config.json", data); err != nil {
+			return err
+		}
+		for _, section := range b.fileSections() {
+			data, err := section.read()
+			if section.optional && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				note(section.name, err)
+				continue
+			}
+			if err := add(section.name, data); err != nil {
+				return err
+			}
+		}
+	}
+	if len(b.Journal) > 0 {
+		out, err := exec.CommandContext(ctx, b.Journal[0], b.Journal[1:]...).Output()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(exitErr.Stderr))
+		}
+		if err != nil {
+			note("journal", err)
+		}
+		if len(out) > 0 {
+			if err := add("journal.txt", out); err != nil {
+				return err
+			}
+		}
+	}
+	if len(problems) > 0 {
+		if err := add("errors.txt", []byte(strings.Join(problems, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+type section struct {
+	name string
+	read func() ([]byte, error)
+	// optional sections are left out silently when the file is missing.
+	optional bool
+}
+
+// fileSections are the parts read from the outbox and log files.
+func (b Bundle) fileSections() []section {
+	cfg := b.Config
+	sections := []section{
+		{"outbox.txt", func() ([]byte, error) { return listTree(cfg.OutboxDir) }, false},
+		{"upload_state.json", func() ([]byte, error) { return os.ReadFile(filepath.Join(cfg.OutboxDir, upload.StateFile)) }, true},
+	}
+	if path, date, err := lastAnalysis(cfg.OutboxDir); err != nil {
+		sections = append(sections, section{"analysis.json", func() ([]byte, error) { return nil, err }, false})
+	} else if path != "" {
+		sections = append(sections, section{"analysis/" + date + "/analysis.json", func() ([]byte, error) { return os.ReadFile(path) }, false})
+	}
+	if output := cfg.LogOutput; output != "" && output != "stderr" && output != "stdout" {
+		sections = append(sections, section{"logs/" + filepath.Base(output), func() ([]byte, error) { return tail(output, b.TailBytes) }, false})
+	}
+	return sections
+}
+
+type systemInfo struct {
+	Build      buildinfo.Info `json:"build"`
+	OS         string         `json:"os"`
+	Arch       string         `json:"arch"`
+	CPUs       int            `json:"cpus"`
+	Hostname   string         `json:"hostname,omitempty"`
+	Time       time.Time      `json:"time"`
+	Timezone   string         `json:"timezone"`
+	ConfigPath string         `json:"config_path"`
+	Health     *health.Sample `json:"health,omitempty"`
+}
+
+func (b Bundle) system(ctx context.Context) systemInfo {
+	info := systemInfo{
+		Build:      buildinfo.Get(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPUs:       runtime.NumCPU(),
+		Time:       b.Now,
+		ConfigPath: b.ConfigPath,
+	}
+	info.Hostname, _ = os.Hostname()
+	info.Timezone, _ = b.Now.Zone()
+	if b.Config != nil {
+		sample := health.Collect(ctx, b.Config.OutboxDir, b.Config.NTPServer)
+		info.Health = &sample
+	}
+	return info
+}
+
+// RedactConfig blanks secret values and passwords in upload target urls,
+// keeping the secret names so a missing one is still visible.
+func RedactConfig(cfg config.Client) config.Client {
+	if cfg.Secrets != nil {
+		secrets := make(map[string]string, len(cfg.Secrets))
+		for name := range cfg.Secrets {
+			secrets[name] = Redacted
+		}
+		cfg.Secrets = secrets
+	}
+	targets := make([]config.UploadTarget, len(cfg.UploadTargets))
+	for i, target := range cfg.UploadTargets {
+		if u, err := url.Parse(target.URL); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), Redacted)
+				target.URL = u.String()
+			}
+		}
+		targets[i] = target
+	}
+	cfg.UploadTargets = targets
+	return cfg
+}
+
+// lastAnalysis finds the newest outbox/daily/<date>/analysis.json.
+func lastAnalysis(outboxDir string) (path, date string, err error) {
+	entries, err := os.ReadDir(filepath.Join(outboxDir, "daily"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		candidate := filepath.Join(outboxDir, "daily", entries[i].Name(), "analysis.json")
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, entries[i].Name(), nil
+		}
+	}
+	return "", "", nil
+}
+
+// listTree lists every file below root, in walk (lexical) order, with its
+// size and modification time.
+func listTree(root string) ([]byte, error) {
+	var lines []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if len(lines) == maxListing {
+			lines = append(lines, fmt.Sprintf("... more than %d files", maxListing))
+			return filepath.SkipAll
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		lines = append(lines, fmt.Sprintf("%s\t%d\t%s", filepath.ToSlash(rel), info.Size(), info.ModTime().UTC().Format(time.RFC3339)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// tail reads up to the last n bytes of path, starting at a line boundary.
+func tail(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - n
+	if n <= 0 || offset <= 0 {
+		return io.ReadAll(f)
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, nil
+}
@@ -0,0 +1,172 @@
+// Package timeparse parses the timestamps found in sensor logs, snapshot
+// payloads and archive names. Layouts and the timezone are configurable so
+// sites whose loggers write a different format do not need code changes.
+package timeparse
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// LineLayout is the timestamp at the start of every raw log line.
+	LineLayout = "2006-01-02 15:04:05.000"
+	// DateLayout is the YYYYMMDD form used in archive names and -date flags.
+	DateLayout = "20060102"
+	// DayLayout is the date part of LineLayout, used in log file names.
+	DayLayout = "2006-01-02"
+	// HourLayout is the normalized form of the hour field in events.jsonl.
+	HourLayout = "2006-01-02T15"
+)
+
+// DefaultLayouts are tried in order when no layouts are configured.
+var DefaultLayouts = []string{LineLayout, time.RFC3339Nano}
+
+// Parser tries each layout in order, interpreting timestamps without an
+// explicit offset in Location.
+type Parser struct {
+	layouts  []string
+	location *time.Location
+}
+
+// New returns a parser for layouts (DefaultLayouts when empty) in the named
+// IANA timezone. An empty name or "Local" uses the system timezone.
+func New(layouts []string, timezone string) (*Parser, error) {
+	location, err := LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	if len(layouts) == 0 {
+		layouts = DefaultLayouts
+	}
+	for _, layout := range layouts {
+		if strings.TrimSpace(layout) == "" {
+			return nil, errors.New("empty timestamp layout")
+		}
+	}
+	return &Parser{layouts: append([]string(nil), layouts...), location: location}, nil
+}
+
+// Default returns a parser for DefaultLayouts in the system timezone.
+func Default() *Parser {
+	return &Parser{layouts: DefaultLayouts, location: time.Local}
+}
+
+// LoadLocation resolves a configured timezone name.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return time.Local, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return location, nil
+}
+
+func (p *Parser) Location() *time.Location {
+	return p.location
+}
+
+// Parse reads a whole timestamp value.
+func (p *Parser) Parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, errors.New("missing timestamp")
+	}
+	for _, layout := range p.layouts {
+		if t, err := time.ParseInLocation(layout, value, p.location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %s", value)
+}
+
+// ParsePrefix reads the timestamp at the start of line, ignoring leading
+// blanks, and returns the rest of the line. A layout containing n spaces
+// consumes the first n+1 space-separated fields.
+func (p *Parser) ParsePrefix(line string) (time.Time, string, bool) {
+	line = strings.TrimLeft(line, " \t")
+	for _, layout := range p.layouts {
+		stamp, rest := splitFields(line, strings.Count(layout, " ")+1)
+		if t, err := time.ParseInLocation(layout, stamp, p.location); err == nil {
+			return t, rest, true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// ParseDate reads a YYYYMMDD date as midnight in the parser's timezone.
+func (p *Parser) ParseDate(value string) (time.Time, error) {
+	t, err := time.ParseInLocation(DateLayout, value, p.location)
+	if err != nil || len(value) != len(DateLayout) {
+		return time.Time{}, fmt.Errorf("invalid date %q: expected YYYYMMDD", value)
+	}
+	return t, nil
+}
+
+// ResolveDate turns a YYYYMMDD date, "today" or "yesterday" into YYYYMMDD,
+// taking the relative forms in the parser's timezone. Schedulers without a
+// date(1) that can do arithmetic (Windows Task Scheduler) pass the names.
+func (p *Parser) ResolveDate(value string, now time.Time) (string, error) {
+	switch strings.ToLower(value) {
+	case "today":
+		return now.In(p.location).Format(DateLayout), nil
+	case "yesterday":
+		return now.In(p.location).AddDate(0, 0, -1).Format(DateLayout), nil
+	}
+	if _, err := p.ParseDate(value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// ParseHour reads an hour value in layout (HourLayout when empty) and
+// returns it in HourLayout. Values that are not on the hour are rejected.
+func (p *Parser) ParseHour(layout, value string) (string, error) {
+	if layout == "" {
+		layout = HourLayout
+	}
+	t, err := time.ParseInLocation(layout, strings.TrimSpace(value), p.location)
+	if err != nil {
+		return "", fmt.Errorf("invalid hour %q: expected %s", value, layout)
+	}
+	if t.Minute() != 0 || t.Second() != 0 || t.Nanosecond() != 0 {
+		return "", fmt.Errorf("invalid hour %q: not on the hour", value)
+	}
+	return t.Format(HourLayout), nil
+}
+
+// CheckHourLayout reports whether layout can represent a distinct hour,
+// i.e. it keeps the date and the hour of day when formatted and parsed back.
+func CheckHourLayout(layout string) error {
+	reference := time.Date(2026, 11, 22, 19, 0, 0, 0, time.UTC)
+	parsed, err := time.Parse(layout, reference.Format(layout))
+	if err != nil || !parsed.Equal(reference) {
+		return fmt.Errorf("hour layout %q must include the date and the hour", layout)
+	}
+	return nil
+}
+
+// ParseDate reads a YYYYMMDD date in the system timezone.
+func ParseDate(value string) (time.Time, error) {
+	return Default().ParseDate(value)
+}
+
+func splitFields(line string, n int) (string, string) {
+	end := 0
+	for i := 0; i < n; i++ {
+		next := strings.IndexByte(line[end:], ' ')
+		if next == -1 {
+			return line, ""
+		}
+		if i == n-1 {
+			end += next
+			break
+		}
+		end += next + 1
+	}
+	return line[:end], line[end:]
+}
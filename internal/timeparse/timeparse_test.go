@@ -0,0 +1,142 @@
+package timeparse
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDefaultLayouts(t *testing.T) {
+	p := Default()
+	for _, value := range []string{"2026-01-20 00:00:01.200", "2026-01-20T00:00:01.2+09:00"} {
+		if _, err := p.Parse(value); err != nil {
+			t.Fatalf("%s: %v", value, err)
+		}
+	}
+	if _, err := p.Parse("20/01/2026 00:00"); err == nil {
+		t.Fatalf("expected error for unknown layout")
+	}
+}
+
+func TestParseUsesConfiguredTimezone(t *testing.T) {
+	p, err := New(nil, "Asia/Seoul")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+	got, err := p.Parse("2026-01-20 09:00:00.000")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if want := time.Date(2026, 1, 20, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, got.UTC())
+	}
+}
+
+func TestParsePrefixCustomLayout(t *testing.T) {
+	p, err := New([]string{"2006/01/02 15:04:05", LineLayout}, "UTC")
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	got, rest, ok := p.ParsePrefix("  2026/01/20 00:00:01 rcv: (01)")
+	if !ok || rest != " rcv: (01)" {
+		t.Fatalf("expected prefix parsed, got ok=%v rest=%q", ok, rest)
+	}
+	if got.Second() != 1 {
+		t.Fatalf("unexpected time %s", got)
+	}
+	if _, _, ok := p.ParsePrefix("2026-01-20 00:00:01.200 rcv: (01)"); !ok {
+		t.Fatalf("expected fallback to the second layout")
+	}
+}
+
+func TestNewRejectsBadTimezone(t *testing.T) {
+	if _, err := New(nil, "Mars/Olympus"); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	if _, err := ParseDate("20260120"); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, value := range []string{"", "2026012", "20261320", "2026-01-20"} {
+		if _, err := ParseDate(value); err == nil {
+			t.Fatalf("%q: expected error", value)
+		}
+	}
+}
+
+func TestResolveDate(t *testing.T) {
+	p, err := New(nil, "UTC")
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	now := time.Date(2026, 3, 1, 0, 30, 0, 0, time.UTC)
+	for value, want := range map[string]string{"today": "20260301", "Yesterday": "20260228", "20260120": "20260120"} {
+		if got, err := p.ResolveDate(value, now); err != nil || got != want {
+			t.Fatalf("%q: got %q, %v", value, got, err)
+		}
+	}
+	if _, err := p.ResolveDate("tomorrow", now); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestParseHour(t *testing.T) {
+	p, err := New(nil, "UTC")
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	for _, tc := range []struct{ layout, value, want string }{
+		{"", "2026-01-20T07", "2026-01-20T07"},
+		{"2006010215", "2026012007", "2026-01-20T07"},
+		{"2006-01-02 15:04", "2026-01-20 07:00", "2026-01-20T07"},
+	} {
+		if got, err := p.ParseHour(tc.layout, tc.value); err != nil || got != tc.want {
+			t.Fatalf("%q: got %q, %v", tc.value, got, err)
+		}
+	}
+	for _, value := range []string{"", "2026-01-20", "2026-01-20T25", "2026-01-20T07:30"} {
+		if _, err := p.ParseHour("", value); err == nil {
+			t.Fatalf("%q: expected error", value)
+		}
+	}
+	if _, err := p.ParseHour("2006-01-02 15:04", "2026-01-20 07:30"); err == nil {
+		t.Fatalf("expected error for a value off the hour")
+	}
+}
+
+func TestCheckHourLayout(t *testing.T) {
+	for _, layout := range []string{HourLayout, "2006010215", "01/02/2006 3PM"} {
+		if err := CheckHourLayout(layout); err != nil {
+			t.Fatalf("%q: %v", layout, err)
+		}
+	}
+	for _, layout := range []string{"2006-01-02", "15:04", "nonsense"} {
+		if err := CheckHourLayout(layout); err == nil {
+			t.Fatalf("%q: expected error", layout)
+		}
+	}
+}
+
+func FuzzParsePrefix(f *testing.F) {
+	f.Add("2026-01-20 00:00:01.200 rcv: (01)")
+	f.Add("2026-01-20T00:00:01Z snd: STATUS")
+	f.Add(" \t2026-01-20 23:59:59.999")
+	f.Add("")
+	p := Default()
+	f.Fuzz(func(t *testing.T, line string) {
+		parsed, rest, ok := p.ParsePrefix(line)
+		if !ok {
+			return
+		}
+		if !strings.HasSuffix(line, rest) {
+			t.Fatalf("rest %q is not a suffix of %q", rest, line)
+		}
+		stamp := strings.TrimSuffix(strings.TrimLeft(line, " \t"), rest)
+		again, err := p.Parse(stamp)
+		if err != nil || !again.Equal(parsed) {
+			t.Fatalf("Parse(%q) = %v, %v; ParsePrefix gave %v", stamp, again, err, parsed)
+		}
+	})
+}
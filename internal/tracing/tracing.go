@@ -0,0 +1,201 @@
+// Package tracing records spans for the ingest pipeline and the client and
+// exports them as OTLP/HTTP JSON when an OTLP endpoint is configured through
+// the standard OTEL_EXPORTER_OTLP_* environment variables. Without an
+// endpoint every call is a no-op.
+//
+// The API follows the shape of go.opentelemetry.io/otel/trace (Start, End,
+// RecordError, attributes) so call sites stay familiar, without pulling the
+// SDK and its gRPC dependencies onto field PCs.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBuffered spans are kept before an export is forced.
+const maxBuffered = 512
+
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attr      { return Attr{Key: key, Value: value} }
+func Int(key string, value int) Attr     { return Attr{Key: key, Value: int64(value)} }
+func Int64(key string, value int64) Attr { return Attr{Key: key, Value: value} }
+
+type Span struct {
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    []Attr
+	err      string
+	ended    bool
+	mu       sync.Mutex
+}
+
+// SetAttributes adds attributes to the span. Safe on a nil span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.queue(s)
+}
+
+type Tracer struct {
+	service  string
+	exporter exporter
+	mu       sync.Mutex
+	pending  []*Span
+}
+
+type exporter interface {
+	export(ctx context.Context, service string, spans []*Span) error
+}
+
+var (
+	globalMu sync.RWMutex
+	global   *Tracer
+)
+
+// Setup installs the process tracer from the environment and returns a
+// function that flushes queued spans; call it before exiting. When no OTLP
+// endpoint is set, tracing stays disabled and shutdown is a no-op.
+func Setup(service string) func(context.Context) error {
+	endpoint := tracesEndpoint()
+	if endpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	}
+	exp := &otlpExporter{endpoint: endpoint, headers: parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))}
+	tracer := &Tracer{service: service, exporter: exp}
+	setGlobal(tracer)
+	return func(ctx context.Context) error {
+		setGlobal(nil)
+		return tracer.Flush(ctx)
+	}
+}
+
+func setGlobal(t *Tracer) {
+	globalMu.Lock()
+	global = t
+	globalMu.Unlock()
+}
+
+// Start begins a span as a child of the span in ctx, if any. The returned
+// span is nil when tracing is disabled; all Span methods accept nil.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	globalMu.RLock()
+	tracer := global
+	globalMu.RUnlock()
+	if tracer == nil {
+		return ctx, nil
+	}
+	return tracer.Start(ctx, name, attrs...)
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	span := &Span{tracer: t, name: name, start: time.Now(), attrs: attrs}
+	if parent := spanFromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) queue(span *Span) {
+	t.mu.Lock()
+	t.pending = append(t.pending, span)
+	full := len(t.pending) >= maxBuffered
+	t.mu.Unlock()
+	if full {
+		if err := t.Flush(context.Background()); err != nil {
+			slog.Warn("tracing export failed", "error", err)
+		}
+	}
+}
+
+// Flush exports every ended span.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.exporter.export(ctx, t.service, spans)
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func tracesEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimRight(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// parseHeaders reads the "k1=v1,k2=v2" form of OTEL_EXPORTER_OTLP_HEADERS.
+func parseHeaders(value string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return headers
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisabledWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	shutdown := Setup("test")
+	ctx, span := Start(context.Background(), "noop")
+	if span != nil || ctx != context.Background() {
+		t.Fatalf("expected no-op span when tracing is not configured")
+	}
+	span.SetAttributes(String("k", "v"))
+	span.RecordError(errors.New("x"))
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
+
+func TestExportsOTLPJSON(t *testing.T) {
+	var got otlpRequest
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		header = r.Header.Get("X-Token")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL+"/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "X-Token=secret")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+
+	shutdown := Setup("field-ingest-worker")
+	ctx, parent := Start(context.Background(), "ingest.archive", String("archive", "a.zip"))
+	_, child := Start(ctx, "ingest.extract")
+	child.RecordError(errors.New("bad archive"))
+	child.End()
+	parent.SetAttributes(Int("snapshots", 3))
+	parent.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if header != "secret" {
+		t.Fatalf("expected header from OTEL_EXPORTER_OTLP_HEADERS, got %q", header)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+	service := got.ResourceSpans[0].Resource.Attributes[0]
+	if service.Key != "service.name" || service.Value["stringValue"] != "field-ingest-worker" {
+		t.Fatalf("unexpected resource: %+v", service)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	extract, archive := spans[0], spans[1]
+	if extract.TraceID != archive.TraceID || extract.ParentSpanID != archive.SpanID || archive.ParentSpanID != "" {
+		t.Fatalf("expected extract to be a child of archive: %+v %+v", extract, archive)
+	}
+	if extract.Status == nil || extract.Status.Code != statusCodeError || extract.Status.Message != "bad archive" {
+		t.Fatalf("expected error status, got %+v", extract.Status)
+	}
+	if len(archive.Attributes) != 2 || archive.Attributes[1].Value["intValue"] != "3" {
+		t.Fatalf("unexpected attributes: %+v", archive.Attributes)
+	}
+}
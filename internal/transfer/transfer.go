@@ -0,0 +1,274 @@
+// Package transfer moves archives by removable media for air-gapped sites.
+// The client copies archives to a mounted path, reads each copy back to
+// check it against the original's SHA-256, and writes a transfer manifest
+// listing them; the worker verifies the media against that manifest before
+// ingesting and reports what is missing, damaged or unlisted.
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"workfield/internal/archive"
+)
+
+// FormatVersion is written into every transfer manifest.
+const FormatVersion = 1
+
+// ManifestPrefix and ManifestSuffix frame transfer manifest file names,
+// transfer_<site>_<device>_<time>.json.
+const (
+	ManifestPrefix = "transfer_"
+	ManifestSuffix = ".json"
+)
+
+// ErrMismatch means a copy does not match the original's size or hash.
+var ErrMismatch = errors.New("transfer copy mismatch")
+
+// Manifest lists the archives of one export.
+type Manifest struct {
+	Version   int       `json:"version"`
+	SiteID    string    `json:"site_id"`
+	DeviceID  string    `json:"device_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     []File    `json:"files"`
+}
+
+// File is one exported archive, stored next to the manifest.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestName is the file name of a manifest created at t.
+func ManifestName(siteID, deviceID string, t time.Time) string {
+	return ManifestPrefix + siteID + "_" + deviceID + "_" + t.UTC().Format("20060102T150405Z") + ManifestSuffix
+}
+
+// Export copies each named archive from srcDir to destDir and verifies the
+// copy, then writes the manifest of the verified copies into destDir. The
+// manifest is written last, so media pulled early has no manifest rather
+// than one listing missing files. The copies that succeeded are in the
+// returned manifest even when err is not nil.
+func Export(srcDir, destDir string, names []string, siteID, deviceID string, now time.Time) (Manifest, string, error) {
+	m := Manifest{Version: FormatVersion, SiteID: siteID, DeviceID: deviceID, CreatedAt: now.UTC()}
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return m, "", err
+	}
+	var failed []error
+	for _, name := range names {
+		file, err := CopyVerified(filepath.Join(srcDir, name), filepath.Join(destDir, name))
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		m.Files = append(m.Files, file)
+	}
+	path := ""
+	if len(m.Files) > 0 {
+		path = filepath.Join(destDir, ManifestName(siteID, deviceID, now))
+		if err := writeManifest(path, m); err != nil {
+			return m, "", err
+		}
+	}
+	return m, path, errors.Join(failed...)
+}
+
+// CopyVerified copies src to dst through a synced .partial file and reads
+// the copy back, since removable media fails silently more often than not.
+func CopyVerified(src, dst string) (File, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return File{}, err
+	}
+	defer in.Close()
+	tmp := dst + ".partial"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return File{}, err
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hasher), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return File{}, err
+	}
+	want := File{Name: filepath.Base(dst), Size: size, SHA256: hex.EncodeToString(hasher.Sum(nil))}
+	if err := check(tmp, want); err != nil {
+		os.Remove(tmp)
+		return File{}, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return File{}, err
+	}
+	return want, nil
+}
+
+// check compares the file at path with want's size and hash.
+func check(path string, want File) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, f)
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(hasher.Sum(nil)); size != want.Size || got != want.SHA256 {
+		return fmt.Errorf("%w: %d bytes sha256 %s, expected %d bytes sha256 %s", ErrMismatch, size, got, want.Size, want.SHA256)
+	}
+	return nil
+}
+
+func writeManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".partial"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadManifest reads a transfer manifest.
+func ReadManifest(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("parse transfer manifest %s: %w", path, err)
+	}
+	if m.Version < 1 || m.Version > FormatVersion {
+		return Manifest{}, fmt.Errorf("transfer manifest %s: unsupported version %d", path, m.Version)
+	}
+	for _, f := range m.Files {
+		if f.Name == "" || f.Name != filepath.Base(f.Name) || strings.ContainsAny(f.Name, `/\`) {
+			return Manifest{}, fmt.Errorf("transfer manifest %s: invalid file name %q", path, f.Name)
+		}
+	}
+	return m, nil
+}
+
+// Manifests expands paths to manifest files: a directory stands for the
+// transfer manifests directly inside it.
+func Manifests(paths []string) ([]string, error) {
+	var manifests []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			manifests = append(manifests, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, ManifestPrefix+"*"+ManifestSuffix))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		manifests = append(manifests, matches...)
+	}
+	return manifests, nil
+}
+
+// Problem is a discrepancy between media and manifest.
+type Problem struct {
+	// Manifest is the manifest path, or the directory for unlisted files.
+	Manifest string
+	Name     string
+	// Kind is missing, mismatch or unlisted.
+	Kind string
+	Err  error
+}
+
+func (p Problem) String() string {
+	if p.Err != nil {
+		return fmt.Sprintf("%s: %s: %s: %v", p.Manifest, p.Name, p.Kind, p.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.Manifest, p.Name, p.Kind)
+}
+
+// Report is the outcome of Verify.
+type Report struct {
+	// Verified are the archive paths that match their manifest.
+	Verified []string
+	Problems []Problem
+}
+
+// Verify checks every archive listed in the manifests against its size and
+// hash, and reports archives next to a manifest that no manifest lists.
+func Verify(manifests []string) (Report, error) {
+	var report Report
+	listed := map[string]bool{}
+	dirs := map[string]bool{}
+	for _, path := range manifests {
+		m, err := ReadManifest(path)
+		if err != nil {
+			return report, err
+		}
+		dir := filepath.Dir(path)
+		dirs[dir] = true
+		for _, f := range m.Files {
+			archive := filepath.Join(dir, f.Name)
+			if listed[archive] {
+				continue // exported again later; checked once
+			}
+			listed[archive] = true
+			err := check(archive, f)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				report.Problems = append(report.Problems, Problem{Manifest: path, Name: f.Name, Kind: "missing"})
+			case err != nil:
+				report.Problems = append(report.Problems, Problem{Manifest: path, Name: f.Name, Kind: "mismatch", Err: err})
+			default:
+				report.Verified = append(report.Verified, archive)
+			}
+		}
+	}
+	for dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return report, err
+		}
+		for _, entry := range entries {
+			if _, _, ok := archive.SplitExt(entry.Name()); !ok || !entry.Type().IsRegular() {
+				continue
+			}
+			if !listed[filepath.Join(dir, entry.Name())] {
+				report.Problems = append(report.Problems, Problem{Manifest: dir, Name: entry.Name(), Kind: "unlisted"})
+			}
+		}
+	}
+	sort.Slice(report.Problems, func(i, j int) bool {
+		a, b := report.Problems[i], report.Problems[j]
+		if a.Manifest != b.Manifest {
+			return a.Manifest < b.Manifest
+		}
+		return a.Name < b.Name
+	})
+	return report, nil
+}
@@ -0,0 +1,68 @@
+package transfer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportVerify(t *testing.T) {
+	outbox, usb := t.TempDir(), t.TempDir()
+	names := []string{"siteA_device01_20260120.zip", "siteA_device01_20260121.zip", "siteA_device01_20260122.zip"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(outbox, name), []byte("zip "+name), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	now := time.Date(2026, 1, 23, 1, 2, 3, 0, time.UTC)
+	m, path, err := Export(outbox, usb, append(names, "siteA_device01_20260123.zip"), "siteA", "device01", now)
+	if err == nil {
+		t.Fatalf("expected an error for the archive that does not exist")
+	}
+	if len(m.Files) != 3 || filepath.Base(path) != "transfer_siteA_device01_20260123T010203Z.json" {
+		t.Fatalf("export: %+v %s", m, path)
+	}
+
+	report, err := Verify([]string{path})
+	if err != nil || len(report.Verified) != 3 || len(report.Problems) != 0 {
+		t.Fatalf("clean media: %+v %v", report, err)
+	}
+
+	os.Remove(filepath.Join(usb, names[0]))
+	os.WriteFile(filepath.Join(usb, names[1]), []byte("zip damaged"), 0o644)
+	os.WriteFile(filepath.Join(usb, "other_device02_20260120.zip"), []byte("zip"), 0o644)
+	manifests, err := Manifests([]string{usb})
+	if err != nil || len(manifests) != 1 || manifests[0] != path {
+		t.Fatalf("manifests in %s: %v %v", usb, manifests, err)
+	}
+	report, err = Verify(manifests)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if len(report.Verified) != 1 || report.Verified[0] != filepath.Join(usb, names[2]) {
+		t.Fatalf("verified = %v", report.Verified)
+	}
+	kinds := map[string]string{}
+	for _, p := range report.Problems {
+		kinds[p.Name] = p.Kind
+	}
+	want := map[string]string{names[0]: "missing", names[1]: "mismatch", "other_device02_20260120.zip": "unlisted"}
+	if len(kinds) != len(want) {
+		t.Fatalf("problems = %v", report.Problems)
+	}
+	for name, kind := range want {
+		if kinds[name] != kind {
+			t.Fatalf("%s: got %q, want %q (%v)", name, kinds[name], kind, report.Problems)
+		}
+	}
+}
+
+func TestReadManifestRejectsPaths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transfer_x.json")
+	os.WriteFile(path, []byte(`{"version":1,"files":[{"name":"../etc/passwd","size":1,"sha256":"00"}]}`), 0o644)
+	if _, err := ReadManifest(path); err == nil {
+		t.Fatalf("expected an error for a path outside the media")
+	}
+}
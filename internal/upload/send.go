@@ -0,0 +1,154 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"workfield/internal/s3"
+)
+
+// dirSender copies into a local or mounted directory through a .partial
+// file, so a reader of the directory never sees half an archive.
+type dirSender struct {
+	dir string
+}
+
+func (s dirSender) Send(ctx context.Context, name, src string) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	dst := filepath.Join(s.dir, name)
+	tmp := dst + ".partial"
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// sftpCommand is the sftp client run for sftp targets; tests replace it.
+var sftpCommand = "sftp"
+
+// sftpSender runs the system sftp client in batch mode, so host keys,
+// ssh_config and agents work as they do for the existing upload scripts.
+// The archive is put as .partial and renamed once complete.
+type sftpSender struct {
+	url          *url.URL
+	identityFile string
+}
+
+func (s sftpSender) Send(ctx context.Context, name, src string) error {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if port := s.url.Port(); port != "" {
+		args = append(args, "-P", port)
+	}
+	if s.identityFile != "" {
+		args = append(args, "-i", s.identityFile)
+	}
+	host := s.url.Hostname()
+	if s.url.User != nil {
+		host = s.url.User.Username() + "@" + host
+	}
+	args = append(args, host)
+
+	dst := path.Join(s.url.Path, name)
+	if s.url.Path == "" {
+		dst = name
+	}
+	var batch strings.Builder
+	fmt.Fprintf(&batch, "put %s %s\n", sftpQuote(src), sftpQuote(dst+".partial"))
+	// A leading "-" lets the batch go on when there is nothing to remove.
+	fmt.Fprintf(&batch, "-rm %s\n", sftpQuote(dst))
+	fmt.Fprintf(&batch, "rename %s %s\n", sftpQuote(dst+".partial"), sftpQuote(dst))
+
+	cmd := exec.CommandContext(ctx, sftpCommand, args...)
+	cmd.Stdin = strings.NewReader(batch.String())
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("sftp %s: %w: %s", host, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+func sftpQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// httpSender PUTs the archive to <base>/<name>.
+type httpSender struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func (s httpSender) Send(ctx context.Context, name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	target := s.base + "/" + url.PathEscape(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/zip")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	client := s.http
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("put %s: %s: %s", target, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+type s3Sender struct {
+	client *s3.Client
+}
+
+func (s s3Sender) Send(ctx context.Context, name, src string) error {
+	return s.client.PutFile(ctx, name, src)
+}
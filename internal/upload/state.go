@@ -0,0 +1,110 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateFile is the name of the delivery state kept in the outbox.
+const StateFile = "upload_state.json"
+
+// Delivery is what happened to one archive at one target.
+type Delivery struct {
+	UploadedAt time.Time `json:"uploaded_at,omitempty"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// Done reports whether the archive reached the target.
+func (d Delivery) Done() bool {
+	return !d.UploadedAt.IsZero()
+}
+
+// State records, per archive and target name, which deliveries are done so
+// a target that was down is retried without resending to the others.
+type State struct {
+	path     string
+	Archives map[string]map[string]Delivery `json:"archives"`
+}
+
+// LoadState reads the state at path; a missing file is an empty state.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, Archives: map[string]map[string]Delivery{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.Archives == nil {
+		s.Archives = map[string]map[string]Delivery{}
+	}
+	return s, nil
+}
+
+// Delivery returns the record of archive at target.
+func (s *State) Delivery(archive, target string) Delivery {
+	return s.Archives[archive][target]
+}
+
+// Pending lists the targets archive has not reached yet.
+func (s *State) Pending(archive string, targets []string) []string {
+	var pending []string
+	for _, target := range targets {
+		if !s.Delivery(archive, target).Done() {
+			pending = append(pending, target)
+		}
+	}
+	return pending
+}
+
+// Record notes an attempt to deliver archive to target at the given time;
+// err nil means it arrived.
+func (s *State) Record(archive, target string, at time.Time, err error) {
+	deliveries := s.Archives[archive]
+	if deliveries == nil {
+		deliveries = map[string]Delivery{}
+		s.Archives[archive] = deliveries
+	}
+	d := deliveries[target]
+	d.Attempts++
+	if err != nil {
+		d.LastError = err.Error()
+	} else {
+		d.UploadedAt = at.UTC()
+		d.LastError = ""
+	}
+	deliveries[target] = d
+}
+
+// Forget drops archives that are no longer in the outbox.
+func (s *State) Forget(keep func(archive string) bool) {
+	for archive := range s.Archives {
+		if !keep(archive) {
+			delete(s.Archives, archive)
+		}
+	}
+}
+
+// Save writes the state atomically.
+func (s *State) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".partial"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
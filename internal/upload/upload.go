@@ -0,0 +1,166 @@
+// Package upload delivers outbox archives to one or more destinations
+// (a directory, SFTP, an HTTP endpoint or S3), each tracked independently
+// in the outbox's upload state so a failing target does not hold back or
+// duplicate deliveries to the others.
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"workfield/internal/archive"
+	"workfield/internal/s3"
+)
+
+// Target is one destination with its secret already decrypted.
+type Target struct {
+	Name string
+	// URL is a directory, file://, sftp://, http(s):// or s3:// url.
+	URL string
+	// IdentityFile is the ssh key for sftp.
+	IdentityFile string
+	// Secret is the bearer token for http(s) or
+	// "access_key_id:secret_access_key" for s3.
+	Secret string
+}
+
+// Sender puts one file at a target.
+type Sender interface {
+	Send(ctx context.Context, name, path string) error
+}
+
+// NewSender returns the sender for t's url scheme.
+func NewSender(t Target) (Sender, error) {
+	if !strings.Contains(t.URL, "://") {
+		// A plain path; url.Parse would read a Windows drive as a scheme.
+		return dirSender{dir: t.URL}, nil
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("upload %s: %w", t.Name, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return dirSender{dir: u.Path}, nil
+	case "sftp":
+		return sftpSender{url: u, identityFile: t.IdentityFile}, nil
+	case "http", "https":
+		return httpSender{base: strings.TrimSuffix(t.URL, "/"), token: t.Secret}, nil
+	case "s3":
+		creds := s3.Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if t.Secret != "" {
+			id, key, ok := strings.Cut(t.Secret, ":")
+			if !ok {
+				return nil, fmt.Errorf("upload %s: s3 secret must be access_key_id:secret_access_key", t.Name)
+			}
+			creds = s3.Credentials{AccessKeyID: id, SecretAccessKey: key}
+		}
+		client, err := s3.WithCredentials(t.URL, creds)
+		if err != nil {
+			return nil, fmt.Errorf("upload %s: %w", t.Name, err)
+		}
+		return s3Sender{client: client}, nil
+	default:
+		return nil, fmt.Errorf("upload %s: unsupported url scheme %q", t.Name, u.Scheme)
+	}
+}
+
+// Result is one delivery attempt made (or, in a dry run, planned) by Run.
+type Result struct {
+	Archive string
+	Target  string
+	Err     error
+}
+
+// Archives lists the zip, tar.gz and tar.zst archives directly in
+// outboxDir, sorted by name.
+func Archives(outboxDir string) ([]string, error) {
+	entries, err := os.ReadDir(outboxDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, _, ok := archive.SplitExt(entry.Name()); ok && entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// TargetNames returns the names of targets in order.
+func TargetNames(targets []Target) []string {
+	names := make([]string, len(targets))
+	for i, t := range targets {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// Run sends every archive in outboxDir to each target it has not reached
+// yet and records the outcome in outboxDir/upload_state.json after every
+// attempt. A failed delivery is reported in its Result and retried on the
+// next run; the returned error is only for the outbox and the state file,
+// or ErrWindowClosed once schedule (nil for any time) no longer allows
+// sending. With dryRun nothing is sent or recorded and schedule is ignored.
+func Run(ctx context.Context, outboxDir string, targets []Target, schedule *Schedule, dryRun bool) ([]Result, error) {
+	senders := make(map[string]Sender, len(targets))
+	for _, t := range targets {
+		sender, err := NewSender(t)
+		if err != nil {
+			return nil, err
+		}
+		senders[t.Name] = sender
+	}
+	archives, err := Archives(outboxDir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := LoadState(filepath.Join(outboxDir, StateFile))
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(archives))
+	for _, archive := range archives {
+		present[archive] = true
+	}
+	state.Forget(func(archive string) bool { return present[archive] })
+
+	var results []Result
+	for _, archive := range archives {
+		for _, target := range state.Pending(archive, TargetNames(targets)) {
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			if dryRun {
+				results = append(results, Result{Archive: archive, Target: target})
+				continue
+			}
+			if !schedule.Open(time.Now()) {
+				return results, ErrWindowClosed
+			}
+			sendErr := senders[target].Send(ctx, archive, filepath.Join(outboxDir, archive))
+			if errors.Is(sendErr, context.Canceled) {
+				return results, sendErr
+			}
+			state.Record(archive, target, time.Now(), sendErr)
+			if err := state.Save(); err != nil {
+				return results, err
+			}
+			results = append(results, Result{Archive: archive, Target: target, Err: sendErr})
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,204 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeArchive(t *testing.T, dir, name string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("zip "+name), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestRunTracksTargetsIndependently(t *testing.T) {
+	outbox := t.TempDir()
+	central := filepath.Join(t.TempDir(), "central")
+	writeArchive(t, outbox, "siteA_device01_20260120.zip")
+	writeArchive(t, outbox, "siteA_device01_20260121.zip")
+
+	var down atomic.Bool
+	down.Store(true)
+	var gotAuth string
+	got := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotAuth = r.Header.Get("Authorization")
+		got[r.URL.Path] = string(body)
+	}))
+	defer server.Close()
+
+	targets := []Target{
+		{Name: "central", URL: central},
+		{Name: "customer", URL: server.URL + "/drop/", Secret: "tok"},
+	}
+	ctx := context.Background()
+
+	results, err := Run(ctx, outbox, targets, nil, false)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 attempts, got %+v", results)
+	}
+	for _, r := range results {
+		if (r.Err != nil) != (r.Target == "customer") {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(central, "siteA_device01_20260121.zip")); err != nil || string(data) != "zip siteA_device01_20260121.zip" {
+		t.Fatalf("central copy: %q %v", data, err)
+	}
+
+	state, err := LoadState(filepath.Join(outbox, StateFile))
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	d := state.Delivery("siteA_device01_20260120.zip", "customer")
+	if d.Done() || d.Attempts != 1 || !strings.Contains(d.LastError, "503") {
+		t.Fatalf("customer delivery = %+v", d)
+	}
+
+	down.Store(false)
+	results, err = Run(ctx, outbox, targets, nil, false)
+	if err != nil {
+		t.Fatalf("rerun: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected only the customer deliveries again, got %+v", results)
+	}
+	for _, r := range results {
+		if r.Target != "customer" || r.Err != nil {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	}
+	if gotAuth != "Bearer tok" || got["/drop/siteA_device01_20260120.zip"] != "zip siteA_device01_20260120.zip" {
+		t.Fatalf("unexpected http upload %q %v", gotAuth, got)
+	}
+	state, _ = LoadState(filepath.Join(outbox, StateFile))
+	if d := state.Delivery("siteA_device01_20260120.zip", "customer"); !d.Done() || d.Attempts != 2 || d.LastError != "" {
+		t.Fatalf("customer delivery after retry = %+v", d)
+	}
+	if pending := state.Pending("siteA_device01_20260121.zip", TargetNames(targets)); len(pending) != 0 {
+		t.Fatalf("pending = %v", pending)
+	}
+
+	results, err = Run(ctx, outbox, targets, nil, false)
+	if err != nil || len(results) != 0 {
+		t.Fatalf("expected nothing left to send, got %+v %v", results, err)
+	}
+}
+
+func TestRunDryRunSendsNothing(t *testing.T) {
+	outbox := t.TempDir()
+	central := filepath.Join(t.TempDir(), "central")
+	writeArchive(t, outbox, "siteA_device01_20260120.zip")
+
+	results, err := Run(context.Background(), outbox, []Target{{Name: "central", URL: "file://" + central}}, nil, true)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("dry run: %+v %v", results, err)
+	}
+	if _, err := os.Stat(central); !os.IsNotExist(err) {
+		t.Fatalf("dry run created the target: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outbox, StateFile)); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote the state: %v", err)
+	}
+}
+
+func TestRunStopsOutsideWindow(t *testing.T) {
+	outbox := t.TempDir()
+	central := filepath.Join(t.TempDir(), "central")
+	writeArchive(t, outbox, "siteA_device01_20260120.zip")
+	now := time.Now()
+	closed := &Schedule{Windows: []Window{{Start: time.Duration(now.Hour()+1) * time.Hour, End: time.Duration(now.Hour()+1)*time.Hour + time.Minute}}}
+	if now.Hour() == 23 {
+		closed.Windows[0] = Window{Start: time.Hour, End: 2 * time.Hour}
+	}
+
+	results, err := Run(context.Background(), outbox, []Target{{Name: "central", URL: central}}, closed, false)
+	if !errors.Is(err, ErrWindowClosed) || len(results) != 0 {
+		t.Fatalf("expected ErrWindowClosed before sending, got %+v %v", results, err)
+	}
+	if _, err := os.Stat(central); !os.IsNotExist(err) {
+		t.Fatalf("sent outside the window: %v", err)
+	}
+}
+
+func TestScheduleOpenAndNext(t *testing.T) {
+	seoul := time.FixedZone("KST", 9*3600)
+	s, err := ParseSchedule([]string{"01:00-05:00", "22:30-00:30"}, seoul)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	at := func(hour, minute int) time.Time { return time.Date(2026, 1, 20, hour, minute, 0, 0, seoul) }
+	for _, tc := range []struct {
+		at   time.Time
+		open bool
+		next time.Time
+	}{
+		{at(0, 15), true, at(0, 15)},
+		{at(0, 30), false, at(1, 0)},
+		{at(4, 59), true, at(4, 59)},
+		{at(5, 0), false, at(22, 30)},
+		{at(23, 0), true, at(23, 0)},
+		{at(12, 0).UTC(), false, at(22, 30)},
+	} {
+		if got := s.Open(tc.at); got != tc.open {
+			t.Fatalf("Open(%s) = %v", tc.at, got)
+		}
+		if got := s.Next(tc.at); !got.Equal(tc.next) {
+			t.Fatalf("Next(%s) = %s, want %s", tc.at, got, tc.next)
+		}
+	}
+	if !(*Schedule)(nil).Open(at(12, 0)) {
+		t.Fatalf("nil schedule should always be open")
+	}
+	for _, bad := range []string{"01:00", "01:00-01:00", "25:00-02:00"} {
+		if _, err := ParseSchedule([]string{bad}, seoul); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestSFTPSenderBatch(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sftp")
+	record := filepath.Join(dir, "record")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+record+"\ncat >> "+record+"\n"), 0o755); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	old := sftpCommand
+	sftpCommand = script
+	defer func() { sftpCommand = old }()
+
+	sender, err := NewSender(Target{Name: "customer", URL: "sftp://field@sftp.customer.example:2222/inbox", IdentityFile: "/etc/field-client/customer_ed25519"})
+	if err != nil {
+		t.Fatalf("sender: %v", err)
+	}
+	if err := sender.Send(context.Background(), "a.zip", "/outbox/a.zip"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	data, _ := os.ReadFile(record)
+	want := "-b - -o BatchMode=yes -P 2222 -i /etc/field-client/customer_ed25519 field@sftp.customer.example\n" +
+		`put "/outbox/a.zip" "/inbox/a.zip.partial"` + "\n" +
+		`-rm "/inbox/a.zip"` + "\n" +
+		`rename "/inbox/a.zip.partial" "/inbox/a.zip"` + "\n"
+	if string(data) != want {
+		t.Fatalf("sftp invocation\n got %q\nwant %q", data, want)
+	}
+}
@@ -0,0 +1,111 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrWindowClosed is returned by Run when the upload windows close before
+// every delivery was made; the rest wait for the next window.
+var ErrWindowClosed = errors.New("upload window closed")
+
+// Window is a daily span of local time, as offsets from midnight. End
+// before Start wraps past midnight (22:00-02:00).
+type Window struct {
+	Start, End time.Duration
+}
+
+// Schedule is the set of windows uploads may run in. No windows means any
+// time.
+type Schedule struct {
+	Windows  []Window
+	Location *time.Location
+}
+
+// ParseSchedule reads windows written as "HH:MM-HH:MM" in loc.
+func ParseSchedule(specs []string, loc *time.Location) (*Schedule, error) {
+	s := &Schedule{Location: loc}
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(strings.ReplaceAll(spec, " ", ""), "-")
+		if !ok {
+			return nil, fmt.Errorf("upload window %q: expected HH:MM-HH:MM", spec)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("upload window %q: %w", spec, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("upload window %q: %w", spec, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("upload window %q is empty", spec)
+		}
+		s.Windows = append(s.Windows, Window{Start: start, End: end})
+	}
+	return s, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Open reports whether t falls inside a window.
+func (s *Schedule) Open(t time.Time) bool {
+	if s == nil || len(s.Windows) == 0 {
+		return true
+	}
+	t = t.In(s.location())
+	offset := t.Sub(midnight(t))
+	for _, w := range s.Windows {
+		if w.Start < w.End {
+			if offset >= w.Start && offset < w.End {
+				return true
+			}
+		} else if offset >= w.Start || offset < w.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns t if a window is open, otherwise when the next one opens.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.Open(t) {
+		return t
+	}
+	t = t.In(s.location())
+	today := midnight(t)
+	var next time.Time
+	for _, w := range s.Windows {
+		opens := today.Add(w.Start)
+		if !opens.After(t) {
+			opens = midnight(today.AddDate(0, 0, 1)).Add(w.Start)
+		}
+		if next.IsZero() || opens.Before(next) {
+			next = opens
+		}
+	}
+	return next
+}
+
+func (s *Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSendSignsEvent(t *testing.T) {
+	var got Event
+	var signature string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		json.Unmarshal(body, &got)
+	}))
+	defer server.Close()
+
+	hook := Hook{URL: server.URL, Secret: "s3cret"}
+	hook.Send(context.Background(), Event{Step: StepUpload, SiteID: "siteA", DeviceID: "device01", OK: true, Counts: map[string]int64{"sent": 2}})
+	if got.Step != StepUpload || got.Counts["sent"] != 2 || got.At.IsZero() || got.ClientVersion == "" {
+		t.Fatalf("unexpected event %+v", got)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Fatalf("signature %q, want %q", signature, want)
+	}
+}
+
+func TestSendIgnoresFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	hook := Hook{URL: server.URL}
+	if err := hook.send(context.Background(), Event{Step: StepCollect}); err == nil {
+		t.Fatal("expected an error for 502")
+	}
+	hook.Send(context.Background(), Event{Step: StepCollect})
+	Hook{}.Send(context.Background(), Event{Step: StepCollect})
+}
+
+func TestDescribe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.zip")
+	os.WriteFile(path, []byte("abc"), 0o644)
+	f, err := Describe(path)
+	if err != nil || f.Bytes != 3 || f.SHA256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatalf("describe = %+v, %v", f, err)
+	}
+}